@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"dtm/tx"
+
+	"github.com/spf13/cobra"
+)
+
+// benchStrategies are the settle strategies measured by the bench command,
+// the priority strategy settles the first generated address first.
+var benchStrategies = tx.SettleStrategies(tx.AddressPriority{"Person1": 1})
+
+// BenchResult is the time and transfer count of settling a generated trip with one strategy.
+type BenchResult struct {
+	Strategy  string
+	Duration  time.Duration
+	Transfers int
+}
+
+func benchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "bench",
+		Short:   "benchmark settlement on a generated trip",
+		Long:    `generate a trip of random payments among --people addresses and settle it with every strategy, the time and transfer count of each strategy is reported for tracking performance regressions. The same --seed generates the same trip.`,
+		Example: `dtm bench --people 50 --records 1000`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			people, _ := cmd.Flags().GetInt("people")
+			records, _ := cmd.Flags().GetInt("records")
+			seed, _ := cmd.Flags().GetInt64("seed")
+
+			if people < 2 {
+				return fmt.Errorf("--people must be at least 2, got %d", people)
+			}
+			if records < 1 {
+				return fmt.Errorf("--records must be at least 1, got %d", records)
+			}
+
+			results, err := RunBench(GenerateUserPayments(seed, people, records))
+			if err != nil {
+				return err
+			}
+			return writeBenchResults(cmd.OutOrStdout(), people, records, results)
+		},
+	}
+
+	cmd.Flags().Int("people", 10, "number of addresses in the generated trip")
+	cmd.Flags().Int("records", 100, "number of payments in the generated trip")
+	cmd.Flags().Int64("seed", 1, "seed of the random generator")
+
+	return cmd
+}
+
+// GenerateUserPayments builds records random payments among people addresses, each is paid by one address
+// and split evenly among a random non-empty subset of the addresses.
+func GenerateUserPayments(seed int64, people, records int) []tx.UserPayment {
+	r := rand.New(rand.NewSource(seed))
+	addresses := make([]string, people)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("Person%d", i+1)
+	}
+
+	payments := make([]tx.UserPayment, 0, records)
+	for i := 0; i < records; i++ {
+		shouldPay := make([]string, 0, people)
+		for _, address := range addresses {
+			if r.Intn(2) == 0 {
+				shouldPay = append(shouldPay, address)
+			}
+		}
+		if len(shouldPay) == 0 {
+			shouldPay = append(shouldPay, addresses[r.Intn(people)])
+		}
+		payments = append(payments, tx.UserPayment{
+			Name:             fmt.Sprintf("record%d", i+1),
+			Amount:           float64(1 + r.Intn(10000)),
+			PrePayAddress:    addresses[r.Intn(people)],
+			ShouldPayAddress: shouldPay,
+		})
+	}
+	return payments
+}
+
+// RunBench settles the payments with every strategy and measures each of them,
+// the conversion of payments to balances is shared and not part of the measured time.
+func RunBench(payments []tx.UserPayment) ([]BenchResult, error) {
+	txList, err := tx.UIList2TxList(payments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+	txPackage := tx.Package{TxList: txList}
+	cashList := tx.NormalizeCash(txPackage.ProcessTransactions())
+
+	results := make([]BenchResult, 0, len(benchStrategies))
+	for _, s := range benchStrategies {
+		// strategies may reorder the cash list, each one settles its own copy
+		cashCopy := append([]tx.Cash(nil), cashList...)
+		start := time.Now()
+		settled, _, err := tx.CashListToTxPackage(cashCopy, s.Name, s.Strategy)
+		elapsed := time.Since(start)
+		if err != nil {
+			return nil, fmt.Errorf("strategy %s: %w", s.Name, err)
+		}
+		settled.SetNoSmallValue(tx.MinValueTxOutput)
+		settled.DropZeroTx()
+		results = append(results, BenchResult{Strategy: s.Name, Duration: elapsed, Transfers: len(settled.TxList)})
+	}
+	return results, nil
+}
+
+func writeBenchResults(w io.Writer, people, records int, results []BenchResult) error {
+	if _, err := fmt.Fprintf(w, "%d people, %d records\n", people, records); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if _, err := fmt.Fprintf(w, "%-12s %12s %6d transfers\n", result.Strategy, result.Duration, result.Transfers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
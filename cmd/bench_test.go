@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchCmd_Tiny(t *testing.T) {
+	var stdout bytes.Buffer
+	cmd := benchCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--people", "4", "--records", "6"})
+	require.NoError(t, cmd.Execute())
+
+	out := stdout.String()
+	assert.Contains(t, out, "4 people, 6 records")
+	for _, s := range benchStrategies {
+		assert.Regexp(t, s.Name+`\s+\S+\s+\d+ transfers`, out)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	payments := GenerateUserPayments(7, 5, 20)
+	require.Len(t, payments, 20)
+	assert.Equal(t, payments, GenerateUserPayments(7, 5, 20), "same seed generates the same trip")
+
+	results, err := RunBench(payments)
+	require.NoError(t, err)
+	require.Len(t, results, len(benchStrategies))
+	for i, result := range results {
+		assert.Equal(t, benchStrategies[i].Name, result.Strategy)
+		assert.Positive(t, result.Transfers)
+		// 5 addresses settle with at most 4 transfers on the fewest transfer strategy
+		if result.Strategy == "min-count" || result.Strategy == "optimized" {
+			assert.LessOrEqual(t, result.Transfers, 4)
+		}
+	}
+}
+
+func TestBenchCmd_InvalidFlags(t *testing.T) {
+	cmd := benchCommand()
+	cmd.SetArgs([]string{"--people", "1"})
+	assert.EqualError(t, cmd.Execute(), "--people must be at least 2, got 1")
+}
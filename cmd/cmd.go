@@ -14,4 +14,8 @@ func init() {
 	RootCmd.AddCommand(shareCmd())
 	RootCmd.AddCommand(serverCommand())
 	RootCmd.AddCommand(migrateCommand())
+	RootCmd.AddCommand(exportSettlementCommand())
+	RootCmd.AddCommand(lintCommand())
+	RootCmd.AddCommand(validateCommand())
+	RootCmd.AddCommand(benchCommand())
 }
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"dtm/db/db"
+	"dtm/db/mem"
+	"dtm/db/pg"
+	"dtm/service"
+	"dtm/tx"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// memTripDB is the process wide in-memory store used by `--db mem`, mainly for test and dev.
+var memTripDB = mem.NewInMemoryTripDBWrapper()
+
+func exportSettlementCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "export-settlement",
+		Short:   "export settlement of a trip in db to CSV",
+		Long:    `load the records of a trip from db, calculate the settlement and write every transfer as one CSV row (From,To,Amount).`,
+		Example: `dtm export-settlement --db pg --trip 7f1c0a52-3d6b-4d1e-9a57-2d6f1c9b8e11 --output transfers.csv`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dbMode, _ := cmd.Flags().GetString("db")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			tripFlag, _ := cmd.Flags().GetString("trip")
+			output, _ := cmd.Flags().GetString("output")
+			anonymizeFlag, _ := cmd.Flags().GetBool("anonymize")
+			mappingFlag, _ := cmd.Flags().GetString("mapping")
+
+			tripID, err := uuid.Parse(tripFlag)
+			if err != nil {
+				return fmt.Errorf("invalid trip ID: %w", err)
+			}
+
+			tripDB, closeDB, err := openTripDB(dbMode, dsn)
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			txPackage, totalRemaining, err := service.SettlementConfig{}.SettleTrip(cmd.Context(), tripDB, tripID)
+			if err != nil {
+				return fmt.Errorf("failed to settle trip: %w", err)
+			}
+			if err := reportRemaining(cmd.ErrOrStderr(), totalRemaining, false); err != nil {
+				return err
+			}
+			if anonymizeFlag {
+				if txPackage, _, err = anonymizeExport(mappingFlag, output, txPackage, nil); err != nil {
+					return err
+				}
+			}
+
+			outputFile, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer func(outputFile *os.File) {
+				err := outputFile.Close()
+				if err != nil {
+					log.Fatalf("Failed to close output file: %v", err)
+				}
+			}(outputFile)
+
+			return writeTransfersCSV(outputFile, txPackage)
+		},
+	}
+
+	cmd.Flags().String("db", "pg", "database backend (pg, mem)")
+	cmd.Flags().String("dsn", "", "postgres connection string, default from DATABASE_URL or DATABASE_PASSWORD env")
+	cmd.Flags().String("trip", "", "trip ID to settle (required)")
+	if err := cmd.MarkFlagRequired("trip"); err != nil {
+		log.Fatal(err)
+		return nil
+	}
+	cmd.Flags().StringP("output", "o", "", "csv output file path (required)")
+	if err := cmd.MarkFlagRequired("output"); err != nil {
+		log.Fatal(err)
+		return nil
+	}
+	cmd.Flags().Bool("anonymize", false, "replace addresses with pseudonyms (Person A, Person B ...) in the output")
+	cmd.Flags().String("mapping", "", "JSON file of address to pseudonym mapping used with --anonymize, loaded if it exists and updated after; default <output>.mapping.json")
+
+	return cmd
+}
+
+// openTripDB returns the db wrapper of the given backend and a function to release it.
+func openTripDB(mode string, dsn string) (db.TripDBWrapper, func(), error) {
+	switch mode {
+	case "mem":
+		return memTripDB, func() {}, nil
+	case "pg":
+		if dsn == "" {
+			dsn = pg.CreateDSN()
+		}
+		iDB, err := pg.InitPostgresGORM(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pg.NewPgDBWrapper(iDB), func() { pg.CloseGORM(iDB) }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported db backend: %s", mode)
+	}
+}
+
+// writeTransfersCSV writes one row per transfer, the input address pays the amount to the output address.
+func writeTransfersCSV(w io.Writer, txPackage tx.Package) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"From", "To", "Amount"}); err != nil {
+		return err
+	}
+	for _, t := range txPackage.TxList {
+		for _, input := range t.Input {
+			if input.Amount <= 0 || input.Address == t.Output.Address {
+				continue
+			}
+			if err := writer.Write([]string{input.Address, t.Output.Address, service.SettlementConfig{}.FormatAmount(input.Amount, input.Currency)}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"dtm/db/db"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedMemTrip(t *testing.T) uuid.UUID {
+	t.Helper()
+	tripID := uuid.New()
+	require.NoError(t, memTripDB.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "export trip"}))
+	for _, addr := range []db.Address{"Alice", "Bob", "Carol"} {
+		require.NoError(t, memTripDB.TripAddressListAdd(t.Context(), tripID, addr))
+	}
+
+	newRecord := func(name string, amount float64, prePay db.Address, shouldPay ...db.Address) db.Record {
+		record := db.Record{RecordInfo: db.RecordInfo{
+			ID:            uuid.New(),
+			Name:          name,
+			Amount:        amount,
+			Time:          time.Now(),
+			PrePayAddress: prePay,
+			Category:      db.CategoryNormal,
+		}}
+		for _, addr := range shouldPay {
+			record.ShouldPayAddress = append(record.ShouldPayAddress, db.ExtendAddress{Address: addr})
+		}
+		return record
+	}
+	require.NoError(t, memTripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		newRecord("hotel", 90, "Alice", "Alice", "Bob", "Carol"),
+		newRecord("dinner", 60, "Bob", "Alice", "Bob", "Carol"),
+	}))
+	return tripID
+}
+
+func TestExportSettlementCmd_Mem(t *testing.T) {
+	tripID := seedMemTrip(t)
+	output := filepath.Join(t.TempDir(), "transfers.csv")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exportSettlementCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--db", "mem", "--trip", tripID.String(), "--output", output})
+	require.NoError(t, cmd.Execute())
+	assert.Empty(t, stderr.String())
+
+	file, err := os.Open(output)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, rows)
+	assert.Equal(t, []string{"From", "To", "Amount"}, rows[0])
+
+	// each person spends 50, so Alice gets 40 back and Bob gets 10 back, all from Carol
+	net := map[string]float64{}
+	for _, row := range rows[1:] {
+		amount, err := strconv.ParseFloat(row[2], 64)
+		require.NoError(t, err)
+		net[row[0]] -= amount
+		net[row[1]] += amount
+	}
+	assert.InDelta(t, 40, net["Alice"], 0.01)
+	assert.InDelta(t, 10, net["Bob"], 0.01)
+	assert.InDelta(t, -50, net["Carol"], 0.01)
+}
+
+func TestExportSettlementCmd_InvalidArgs(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "transfers.csv")
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "invalid trip id", args: []string{"--db", "mem", "--trip", "not-a-uuid", "--output", output}},
+		{name: "unknown trip", args: []string{"--db", "mem", "--trip", uuid.New().String(), "--output", output}},
+		{name: "unsupported db", args: []string{"--db", "mysql", "--trip", uuid.New().String(), "--output", output}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exportSettlementCommand()
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			cmd.SetArgs(tt.args)
+			assert.Error(t, cmd.Execute())
+		})
+	}
+}
+
+func TestExportSettlementCmd_Anonymize(t *testing.T) {
+	tripID := seedMemTrip(t)
+	output := filepath.Join(t.TempDir(), "transfers.csv")
+
+	cmd := exportSettlementCommand()
+	cmd.SetArgs([]string{"--db", "mem", "--trip", tripID.String(), "--output", output, "--anonymize"})
+	require.NoError(t, cmd.Execute())
+
+	result, err := os.ReadFile(output)
+	require.NoError(t, err)
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		assert.NotContains(t, string(result), name)
+	}
+	assert.Contains(t, string(result), "Person C,Person A,40.00")
+
+	saved, err := os.ReadFile(output + ".mapping.json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Alice": "Person A", "Bob": "Person B", "Carol": "Person C"}`, string(saved))
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"dtm/db/db"
+	"dtm/tx"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// lint severities, errors make the settlement wrong or fail, warnings are worth a look
+const (
+	lintError   = "error"
+	lintWarning = "warn"
+)
+
+// lintFinding is one problem of a trip found by lintTrip.
+type lintFinding struct {
+	Severity string
+	Check    string // e.g. "unknown-address", stable for scripts
+	Record   string // name of the record, empty for findings about the whole trip
+	Message  string
+}
+
+func (f lintFinding) String() string {
+	if f.Record == "" {
+		return fmt.Sprintf("[dtm:%s] %s %s", f.Severity, f.Check, f.Message)
+	}
+	return fmt.Sprintf("[dtm:%s] %s record=%s %s", f.Severity, f.Check, f.Record, f.Message)
+}
+
+func lintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "lint",
+		Short:   "check a trip in db for settlement problems",
+		Long:    `check the records of a trip in db before settlement: addresses not in the address list, duplicate records, zero or negative amounts, split parameters not matching the should pay list and an unbalanced ledger. Every finding is reported, the command exits non-zero when any of them is an error.`,
+		Example: `dtm lint --db pg --trip 7f1c0a52-3d6b-4d1e-9a57-2d6f1c9b8e11`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dbMode, _ := cmd.Flags().GetString("db")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			tripFlag, _ := cmd.Flags().GetString("trip")
+
+			tripID, err := uuid.Parse(tripFlag)
+			if err != nil {
+				return fmt.Errorf("invalid trip ID: %w", err)
+			}
+
+			tripDB, closeDB, err := openTripDB(dbMode, dsn)
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			findings, err := lintTrip(cmd.Context(), tripDB, tripID)
+			if err != nil {
+				return fmt.Errorf("failed to lint trip: %w", err)
+			}
+			return reportFindings(cmd.OutOrStdout(), findings)
+		},
+	}
+
+	cmd.Flags().String("db", "pg", "database backend (pg, mem)")
+	cmd.Flags().String("dsn", "", "postgres connection string, default from DATABASE_URL or DATABASE_PASSWORD env")
+	cmd.Flags().String("trip", "", "trip ID to check (required)")
+	if err := cmd.MarkFlagRequired("trip"); err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	return cmd
+}
+
+// reportFindings writes one line per finding to out, it returns an error when any finding is an error.
+func reportFindings(out io.Writer, findings []lintFinding) error {
+	errorCount := 0
+	for _, f := range findings {
+		if f.Severity == lintError {
+			errorCount++
+		}
+		if _, err := fmt.Fprintln(out, f.String()); err != nil {
+			return err
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("lint found %d error(s) and %d warning(s)", errorCount, len(findings)-errorCount)
+	}
+	return nil
+}
+
+// lintTrip checks the records of a trip, records with an error are left out of the ledger check,
+// so a single bad record does not hide the problems of the others.
+func lintTrip(ctx context.Context, tripDB db.TripDBWrapper, tripID uuid.UUID) ([]lintFinding, error) {
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+	addressList, err := tripDB.GetTripAddressList(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[db.Address]bool, len(addressList))
+	for _, addr := range addressList {
+		known[addr] = true
+	}
+
+	var findings []lintFinding
+	add := func(severity, check string, record *db.RecordInfo, format string, a ...any) {
+		f := lintFinding{Severity: severity, Check: check, Message: fmt.Sprintf(format, a...)}
+		if record != nil {
+			f.Record = record.Name
+		}
+		findings = append(findings, f)
+	}
+
+	names := make(map[uuid.UUID]string, len(records))
+	var payments []tx.UserPayment
+	for i := range records {
+		record := &records[i]
+		names[record.ID] = record.Name
+		shouldPay, err := tripDB.GetRecordAddressList(ctx, record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get should pay addresses for record %s: %w", record.ID, err)
+		}
+
+		valid := true
+		var unknown []string
+		if !known[record.PrePayAddress] {
+			unknown = append(unknown, string(record.PrePayAddress))
+		}
+		inShouldPay := make(map[db.Address]bool, len(shouldPay))
+		for _, addr := range shouldPay {
+			inShouldPay[addr.Address] = true
+			if !known[addr.Address] {
+				unknown = append(unknown, string(addr.Address))
+			}
+		}
+		if len(unknown) > 0 {
+			add(lintError, "unknown-address", record, "addresses not in the trip address list: %s", strings.Join(unknown, ","))
+			valid = false
+		}
+
+		switch {
+		case record.Amount < 0:
+			add(lintError, "negative-amount", record, "amount %.2f is negative", record.Amount)
+			continue
+		case record.Amount == 0:
+			add(lintWarning, "zero-amount", record, "amount is zero, the record does not affect the settlement")
+			continue
+		}
+
+		var extra []string
+		for addr := range record.SplitOverrides {
+			if !inShouldPay[addr] {
+				extra = append(extra, string(addr))
+			}
+		}
+		if len(extra) > 0 {
+			sort.Strings(extra)
+			add(lintError, "split-mismatch", record, "split parameters for addresses not in the should pay list: %s", strings.Join(extra, ","))
+			continue
+		}
+
+		payment := tx.RecordToUserPayment(*record, shouldPay)
+		t, err := payment.ToTx(tx.ShareMoneyStrategyFactory(payment.PaymentType))
+		if err == nil {
+			err = t.ValidateDetailed()
+		}
+		if err != nil {
+			add(lintError, "invalid-split", record, "%v", err)
+			continue
+		}
+		if valid {
+			payments = append(payments, payment)
+		}
+	}
+
+	duplicates, err := tripDB.GetDuplicateRecords(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range duplicates {
+		ids := make([]string, len(group))
+		for i, id := range group {
+			ids[i] = id.String()
+		}
+		add(lintWarning, "duplicate", nil, "records named %s look identical: %s", names[group[0]], strings.Join(ids, ","))
+	}
+
+	if len(payments) > 0 {
+		if _, remaining, err := tx.ShareMoneyEasy(payments); err != nil {
+			add(lintError, "unbalanced-ledger", nil, "%v", err)
+		} else if remaining > tx.MinValueTxOutput {
+			add(lintError, "unbalanced-ledger", nil, "there are remaining unspent inputs totaling %.2f", remaining)
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"dtm/db/db"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runLintCmd(t *testing.T, tripID uuid.UUID) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	cmd := lintCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--db", "mem", "--trip", tripID.String()})
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestLintCmd_ReportsEveryIssue(t *testing.T) {
+	tripID := uuid.New()
+	require.NoError(t, memTripDB.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "lint trip"}))
+	for _, addr := range []db.Address{"Alice", "Bob", "Carol"} {
+		require.NoError(t, memTripDB.TripAddressListAdd(t.Context(), tripID, addr))
+	}
+
+	newRecord := func(name string, amount float64, category db.RecordCategory, prePay db.Address, shouldPay ...db.ExtendAddress) db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{ID: uuid.New(), Name: name, Amount: amount, Time: time.Now(), PrePayAddress: prePay, Category: category},
+			RecordData: db.RecordData{ShouldPayAddress: shouldPay},
+		}
+	}
+	alice, bob, carol := db.ExtendAddress{Address: "Alice"}, db.ExtendAddress{Address: "Bob"}, db.ExtendAddress{Address: "Carol"}
+	museum := newRecord("museum", 40, db.CategoryFix, "Alice", alice, bob)
+	museum.SplitOverrides = map[db.Address]float64{"Alice": 20, "Dave": 20}
+	require.NoError(t, memTripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		newRecord("hotel", 90, db.CategoryNormal, "Alice", alice, bob, carol),
+		newRecord("taxi", 20, db.CategoryNormal, "Zed", alice),
+		newRecord("snack", 0, db.CategoryNormal, "Bob", bob),
+		newRecord("refund", -10, db.CategoryNormal, "Bob", alice),
+		newRecord("lunch", 30, db.CategoryNormal, "Carol", alice, carol),
+		newRecord("lunch", 30, db.CategoryNormal, "Carol", alice, carol),
+		museum,
+		newRecord("tickets", 50, db.CategoryFix, "Bob", db.ExtendAddress{Address: "Alice", ExtendMsg: 10}, db.ExtendAddress{Address: "Bob", ExtendMsg: 10}),
+	}))
+
+	stdout, err := runLintCmd(t, tripID)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "lint found 4 error(s) and 2 warning(s)")
+
+	assert.Contains(t, stdout, "[dtm:error] unknown-address record=taxi addresses not in the trip address list: Zed")
+	assert.Contains(t, stdout, "[dtm:warn] zero-amount record=snack")
+	assert.Contains(t, stdout, "[dtm:error] negative-amount record=refund amount -10.00 is negative")
+	assert.Contains(t, stdout, "[dtm:warn] duplicate records named lunch look identical")
+	assert.Contains(t, stdout, "[dtm:error] split-mismatch record=museum split parameters for addresses not in the should pay list: Dave")
+	assert.Contains(t, stdout, "[dtm:error] invalid-split record=tickets")
+	assert.NotContains(t, stdout, "record=hotel")
+}
+
+func TestLintCmd_CleanTrip(t *testing.T) {
+	tripID := seedMemTrip(t)
+
+	stdout, err := runLintCmd(t, tripID)
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	_, err = runLintCmd(t, uuid.New())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
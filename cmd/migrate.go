@@ -10,13 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
-	_ "dtm/migration" // Import your migration package to register migrations
-
-	_ "github.com/lib/pq" // PostgreSQL driver
-
-	"github.com/pressly/goose/v3"
-
-	"dtm/config"
+	migrations "dtm/migration" // Import your migration package to register migrations
 )
 
 func migrateCommand() *cobra.Command {
@@ -51,13 +45,10 @@ func migrateCommand() *cobra.Command {
 				log.Printf("Using default connection string: %s", connStr)
 			}
 
-			if err := goose.SetDialect("postgres"); err != nil {
-				log.Fatalf("Failed to set goose dialect: %v", err)
-			}
-
-			db, err := sql.Open("postgres", connStr)
+			ctx := context.Background()
+			db, err := migrations.Open(ctx, connStr)
 			if err != nil {
-				log.Fatalf("Failed to open database: %v", err)
+				log.Fatalf("Failed to connect database: %v", err)
 			}
 			defer func(db *sql.DB) {
 				err := db.Close()
@@ -65,43 +56,34 @@ func migrateCommand() *cobra.Command {
 					log.Fatalf("Failed to close database connection: %v", err)
 				}
 			}(db)
-
-			// create app schema if not exists
-			if _, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", config.AppName)); err != nil {
-				log.Fatalf("Failed to create schema: %v", err)
-			}
-
-			// set search path to target schema
-			if _, err := db.Exec(fmt.Sprintf("SET search_path TO %s", config.AppName)); err != nil {
-				log.Fatalf("Failed to set search path: %v", err)
-			}
-
-			// Ping to confirm connection
-			pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer pingCancel()
-			if err := db.PingContext(pingCtx); err != nil {
-				log.Fatalf("Failed to ping database: %v", err)
-			}
 			log.Println("Successfully connected to the database.")
 
-			migrationsDir := "migration"
 			if up {
 				log.Println("Running 'up' migrations...")
-				if err := goose.UpContext(context.Background(), db, migrationsDir); err != nil {
+				if err := migrations.Up(ctx, db, migrations.Dir); err != nil {
 					log.Fatalf("Goose UpContext failed: %v", err)
 				}
 				log.Println("Goose operations completed.")
 			} else if down {
 				log.Println("Rolling back('down') the last migration...")
-				if err := goose.DownContext(context.Background(), db, migrationsDir); err != nil {
+				if err := migrations.Down(ctx, db, migrations.Dir); err != nil {
 					log.Fatalf("Goose DownContext failed: %v", err)
 				}
 				log.Println("Goose operations completed.")
 			}
 			log.Println("Checking migration status...")
-			if err := goose.StatusContext(context.Background(), db, migrationsDir); err != nil {
+			version, statuses, err := migrations.Status(ctx, db, migrations.Dir)
+			if err != nil {
 				log.Fatalf("Goose StatusContext failed: %v", err)
 			}
+			for _, status := range statuses {
+				appliedAt := "Pending"
+				if status.Applied {
+					appliedAt = status.AppliedAt.Format(time.ANSIC)
+				}
+				log.Printf("    %-24s -- %v", appliedAt, status.Source)
+			}
+			log.Printf("Current version: %d", version)
 		},
 	}
 
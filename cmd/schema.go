@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"bytes"
+	"dtm/web"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// minInputRowSize is the size in bytes of the shortest possible input row, e.g. `a,0,b,c\n`.
+const minInputRowSize = 8
+
+// DefaultMaxInputRows is the most rows that fit in a request body of the server,
+// so a file is never accepted where the same upload would be rejected.
+const DefaultMaxInputRows = web.MaxBodySize / minInputRowSize
+
+// MaxInputRows caps the number of payments read from a CSV input.
+var MaxInputRows = DefaultMaxInputRows
+
+// ErrTooManyRows is returned when an input has more payments than MaxInputRows.
+var ErrTooManyRows = errors.New("too many rows")
+
+// checkRowCount returns ErrTooManyRows with the count and the limit when rows exceeds MaxInputRows.
+func checkRowCount(rows int) error {
+	if rows > MaxInputRows {
+		return fmt.Errorf("%w: %d payments exceed the limit of %d", ErrTooManyRows, rows, MaxInputRows)
+	}
+	return nil
+}
+
+//go:embed input.schema.json
+var inputSchemaJSON []byte
+
+// inputSchema is the parsed input.schema.json, only the subset of JSON Schema used by the file is supported.
+// Each CSV row is validated as one item of the schema.
+var inputSchema = mustLoadInputSchema()
+
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+	Minimum    *float64               `json:"minimum"`
+	MinLength  *int                   `json:"minLength"`
+	MinItems   *int                   `json:"minItems"`
+	// CSVColumns is the column order of a CSV row, a CSV cell is converted to the type of its property before validation
+	CSVColumns []string `json:"x-csv-columns"`
+}
+
+func mustLoadInputSchema() *jsonSchema {
+	var s jsonSchema
+	if err := json.Unmarshal(inputSchemaJSON, &s); err != nil {
+		panic(fmt.Sprintf("invalid embedded input schema: %v", err))
+	}
+	if s.Items == nil || len(s.Items.CSVColumns) == 0 {
+		panic("invalid embedded input schema: items must declare x-csv-columns")
+	}
+	return &s
+}
+
+// SchemaError is a single schema violation, Path points to the offending value, e.g. "row 3.Amount",
+// it is "$" when the input as a whole is rejected.
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidateInputAgainstSchema checks the CSV content against the embedded input schema.
+// All violations are returned joined, each one is a *SchemaError; nil means content is valid.
+func ValidateInputAgainstSchema(content []byte) error {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1 // column count is checked against the schema for a clearer message
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return &SchemaError{Path: "$", Message: fmt.Sprintf("invalid CSV: %v", err)}
+	}
+	if len(rows) == 0 {
+		return &SchemaError{Path: "row 1", Message: "missing header row"}
+	}
+	if err := checkRowCount(len(rows) - 1); err != nil {
+		return &SchemaError{Path: "$", Message: err.Error()}
+	}
+
+	item := inputSchema.Items
+	// trailing optional columns may be left out, the required ones come first
+	minColumns, maxColumns := len(item.Required), len(item.CSVColumns)
+	var errs []error
+	// skip the header row, rows are numbered as in the CSV file (header is row 1)
+	for i, row := range rows[1:] {
+		path := fmt.Sprintf("row %d", i+2)
+		if len(row) < minColumns {
+			errs = append(errs, &SchemaError{Path: path, Message: fmt.Sprintf("expected %d columns, but got %d", minColumns, len(row))})
+			continue
+		}
+		if len(row) > maxColumns {
+			errs = append(errs, &SchemaError{Path: path, Message: fmt.Sprintf("expected at most %d columns, but got %d", maxColumns, len(row))})
+			continue
+		}
+		record := make(map[string]any, len(row))
+		for j, cell := range row {
+			column := item.CSVColumns[j]
+			record[column] = csvCellValue(item.Properties[column], cell)
+		}
+		errs = append(errs, validateValue(item, record, path)...)
+	}
+	return errors.Join(errs...)
+}
+
+// csvCellValue converts a CSV cell to the type expected by schema, a cell that cannot be converted is kept
+// as string so the type check reports it.
+func csvCellValue(schema *jsonSchema, cell string) any {
+	if schema == nil {
+		return cell
+	}
+	switch schema.Type {
+	case "number":
+		if v, err := strconv.ParseFloat(strings.TrimSpace(cell), 64); err == nil {
+			return v
+		}
+	case "array":
+		var list []any
+		for _, part := range strings.Split(cell, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, csvCellValue(schema.Items, part))
+			}
+		}
+		return list
+	}
+	return cell
+}
+
+func validateValue(schema *jsonSchema, value any, path string) []error {
+	fail := func(format string, args ...any) []error {
+		return []error{&SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	switch schema.Type {
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			return fail("must be an array")
+		}
+		if schema.MinItems != nil && len(list) < *schema.MinItems {
+			return fail("must have at least %d items", *schema.MinItems)
+		}
+		var errs []error
+		if schema.Items != nil {
+			for i, v := range list {
+				errs = append(errs, validateValue(schema.Items, v, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return errs
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fail("must be an object")
+		}
+		var errs []error
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, &SchemaError{Path: path + "." + name, Message: "is required"})
+			}
+		}
+		// iterate in sorted order so the messages are stable
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v, ok := obj[name]; ok {
+				errs = append(errs, validateValue(schema.Properties[name], v, path+"."+name)...)
+			}
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if schema.MinLength != nil && len(strings.TrimSpace(s)) < *schema.MinLength {
+			return fail("must have at least %d characters", *schema.MinLength)
+		}
+	case "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fail("must be a number, got %q", fmt.Sprint(value))
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return fail("must be >= %v, got %v", *schema.Minimum, n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateInputAgainstSchema_CSV(t *testing.T) {
+	const header = "Name,Amount,PrePayAddress,ShouldPayAddress\n"
+	tests := []struct {
+		name        string
+		content     string
+		expectPaths []string
+		expectMsg   string
+	}{
+		{name: "valid", content: header + "lunch,30,Alice,\"Alice,Bob\"\n"},
+		{name: "wrong column count", content: header + "lunch,30,Alice\n", expectPaths: []string{"row 2"}, expectMsg: "expected 4 columns, but got 3"},
+		{name: "non-numeric amount", content: header + "lunch,30,Alice,Bob\ntaxi,abc,Bob,Alice\n", expectPaths: []string{"row 3.Amount"}, expectMsg: "must be a number"},
+		{name: "negative amount", content: header + "lunch,-5,Alice,Bob\n", expectPaths: []string{"row 2.Amount"}, expectMsg: "must be >= 0"},
+		{name: "empty should pay", content: header + "lunch,30,Alice,\n", expectPaths: []string{"row 2.ShouldPayAddress"}, expectMsg: "at least 1 items"},
+		{name: "all violations reported", content: header + "lunch,abc,,Bob\ntaxi,10\n", expectPaths: []string{"row 2.Amount", "row 2.PrePayAddress", "row 3"}},
+		{name: "empty file", content: "", expectPaths: []string{"row 1"}, expectMsg: "missing header row"},
+		{name: "strategy column", content: "Name,Amount,PrePayAddress,ShouldPayAddress,Strategy\nlunch,30,Alice,\"Alice:10,Bob:20\",fixed\ntaxi,10,Bob,Alice,\n"},
+		{name: "extend pay column", content: "Name,Amount,PrePayAddress,ShouldPayAddress,Strategy,ExtendPayMsg\nlunch,30,Alice,\"Alice,Bob\",fixed,\"10,20\"\ntaxi,10,Bob,Alice,,\n"},
+		{name: "negative extend pay value", content: "Name,Amount,PrePayAddress,ShouldPayAddress,Strategy,ExtendPayMsg\nlunch,30,Alice,\"Alice,Bob\",fixed,\"-10,40\"\n", expectPaths: []string{"row 2.ExtendPayMsg[0]"}, expectMsg: "must be >= 0"},
+		{name: "too many columns", content: header + "lunch,30,Alice,Bob,average,,extra\n", expectPaths: []string{"row 2"}, expectMsg: "expected at most 6 columns, but got 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateInputAgainstSchema([]byte(tt.content))
+			if len(tt.expectPaths) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.ElementsMatch(t, tt.expectPaths, schemaErrorPaths(err))
+			assert.Contains(t, err.Error(), tt.expectMsg)
+		})
+	}
+}
+
+func TestValidateInputAgainstSchema_RowLimit(t *testing.T) {
+	MaxInputRows = 2
+	t.Cleanup(func() { MaxInputRows = DefaultMaxInputRows })
+	header := "Name,Amount,PrePayAddress,ShouldPayAddress\n"
+	row := "lunch,30,Alice,Bob\n"
+
+	assert.NoError(t, ValidateInputAgainstSchema([]byte(header+row+row)))
+
+	err := ValidateInputAgainstSchema([]byte(header + row + row + row))
+	require.Error(t, err)
+	assert.Equal(t, []string{"$"}, schemaErrorPaths(err))
+	assert.Contains(t, err.Error(), "too many rows: 3 payments exceed the limit of 2")
+}
+
+func TestShareCmd_SchemaError(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,-30,Alice,Bob\n"), 0o600))
+
+	_, _, err := runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2.Amount: must be >= 0")
+	assert.NoFileExists(t, filepath.Join(dir, "output.txt"))
+}
+
+// schemaErrorPaths collects the Path of every *SchemaError in err, err is a single one or a joined list.
+func schemaErrorPaths(err error) []string {
+	if schemaErr, ok := err.(*SchemaError); ok {
+		return []string{schemaErr.Path}
+	}
+	var paths []string
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			paths = append(paths, schemaErrorPaths(e)...)
+		}
+	}
+	return paths
+}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"dtm/db/db"
 	"dtm/mq/mq"
+	"dtm/service"
 	"dtm/web"
 
 	"github.com/spf13/cobra"
@@ -12,23 +14,79 @@ func serverCommand() *cobra.Command {
 		Use:   "serve",
 		Short: "Start the web server",
 		Long:  `This command starts the web server for the application.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			isDev := cmd.Flags().Lookup("dev").Value.String() == "true"
 			port := cmd.Flags().Lookup("port").Value.String()
 			mqMode := cmd.Flags().Lookup("mq").Value.String()
+			maxBatch, err := cmd.Flags().GetInt("loader-max-batch")
+			if err != nil {
+				return err
+			}
+			wait, err := cmd.Flags().GetDuration("loader-wait")
+			if err != nil {
+				return err
+			}
+			startupTimeout, err := cmd.Flags().GetDuration("startup-timeout")
+			if err != nil {
+				return err
+			}
+			recordBudget, err := cmd.Flags().GetInt("settlement-record-budget")
+			if err != nil {
+				return err
+			}
+			zeroAmount, err := service.ParseZeroAmountPolicy(cmd.Flags().Lookup("zero-amount").Value.String())
+			if err != nil {
+				return err
+			}
+			currency, err := service.ParseCurrency(cmd.Flags().Lookup("currency").Value.String())
+			if err != nil {
+				return err
+			}
+			rounding, err := cmd.Flags().GetFloat64("rounding")
+			if err != nil {
+				return err
+			}
+			if rounding, err = service.ParseRoundingPrecision(rounding); err != nil {
+				return err
+			}
+			maxDecimals, err := cmd.Flags().GetInt("max-decimals")
+			if err != nil {
+				return err
+			}
 
 			// Start the web server
 			web.Serve(web.ServiceConfig{
 				IsDev:  isDev,
 				Port:   port,
 				MqMode: mq.Mode(mqMode),
+				DataLoader: db.DataLoaderConfig{
+					MaxBatch: maxBatch,
+					Wait:     wait,
+				},
+				Settlement: service.SettlementConfig{
+					ZeroAmountPolicy:         zeroAmount,
+					RecordBudget:             recordBudget,
+					DefaultCurrency:          currency,
+					DefaultRoundingPrecision: rounding,
+				},
+				Write:        db.WriteConfig{MaxRecordDecimals: maxDecimals},
+				StartupRetry: web.StartupRetryConfig{Timeout: startupTimeout},
 			})
+			return nil
 		},
 	}
 
 	cmd.Flags().Bool("dev", true, "Run in development mode")
 	cmd.Flags().String("port", "8080", "Port to run the web server on")
-	cmd.Flags().String("mq", "go_chan", "Message queue mode (go_chan, rabbitmq, gcp_pub_sub)")
+	cmd.Flags().String("mq", "go_chan", "Message queue mode (go_chan, rabbitmq, gcp_pub_sub, nats, kafka)")
+	cmd.Flags().Int("loader-max-batch", 0, "Max keys in one dataloader fetch, 0 is unbounded")
+	cmd.Flags().Duration("loader-wait", 0, "Time a dataloader collects keys before a fetch, 0 is the default 16ms")
+	cmd.Flags().Duration("startup-timeout", 0, "Time to wait for postgres and the message queue on startup, retried with backoff, 0 tries once")
+	cmd.Flags().Int("settlement-record-budget", 0, "Max records settled in one GraphQL request, the rest is left out and marked truncated, 0 is unlimited")
+	cmd.Flags().String("currency", "", "ISO 4217 code of trips created without currency, e.g. TWD")
+	cmd.Flags().Float64("rounding", 0, "Increment transfers in the default currency are rounded up to, e.g. 1 for whole units, 0 keeps them unrounded")
+	cmd.Flags().Int("max-decimals", db.NoDecimalLimit, "Decimal places amounts and split values of written records may have, e.g. 2, more are rejected, -1 accepts any")
+	cmd.Flags().String("zero-amount", string(service.ZeroAmountSkip), "Handling of records without positive amount in settlement (skip, error, noop)")
 
 	return cmd
 }
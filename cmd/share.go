@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"dtm/db/db"
 	"dtm/tx"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -14,17 +20,44 @@ import (
 
 var inputPath string
 var outputPath string
+var strictMode bool
+var decimals int
+var outputFormat string
+var anonymize bool
+var mappingPath string
+
+// output formats of the share command
+const (
+	outputFormatText     = "text"
+	outputFormatMarkdown = "md"
+	outputFormatCSV      = "csv"
+	outputFormatDOT      = "dot"
+)
+
+// defaultDecimals is the allowed decimal places of amounts when --decimals is not set, cents of most currencies
+const defaultDecimals = 2
+
+// warnings are tagged with a fixed prefix so scripts can detect them in stderr
+const (
+	remainingWarningFormat   = "[dtm:warn] remaining-inputs total=%.2f\n"
+	precisionWarningFormat   = "[dtm:warn] precision name=%s field=%s index=%d value=%v decimals=%d\n"
+	duplicateWarningFormat   = "[dtm:warn] duplicate name=%s amount=%.2f prepay=%s rows=%s\n"
+	selfPaymentWarningFormat = "[dtm:warn] self-payment name=%s prepay=%s row=%d\n"
+)
 
 func shareCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "share",
 		Short:   "accept two CSV file paths",
 		Long:    `accept two CSV file paths, one for input and one for output. It will read the input CSV, validate its format, and write a sample data to the output CSV if the format is incorrect.`,
-		Example: `dtm share --input input.csv --output output.csv`,
+		Example: `dtm share --input input.csv --output output.csv --strict`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if inputPath == "" || outputPath == "" {
 				return cmd.Help()
 			}
+			if outputFormat != outputFormatText && outputFormat != outputFormatMarkdown && outputFormat != outputFormatCSV && outputFormat != outputFormatDOT {
+				return fmt.Errorf("unsupported output format: %s", outputFormat)
+			}
 
 			// read the input CSV file
 			inputFile, err := os.Open(inputPath)
@@ -38,7 +71,15 @@ func shareCmd() *cobra.Command {
 				}
 			}(inputFile)
 
-			csvContent, err := csv.NewReader(inputFile).ReadAll()
+			content, err := io.ReadAll(inputFile)
+			if err != nil {
+				return err
+			}
+			if err := ValidateInputAgainstSchema(content); err != nil {
+				return fmt.Errorf("failed to parse CSV: %w", err)
+			}
+
+			csvContent, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
 			if err != nil {
 				return err
 			}
@@ -50,14 +91,23 @@ func shareCmd() *cobra.Command {
 			if len(payments) == 0 {
 				return fmt.Errorf("no valid user payments found in the CSV")
 			}
+			if err := reportPrecision(cmd.ErrOrStderr(), tx.CheckPrecision(payments, decimals)); err != nil {
+				return err
+			}
+			if err := reportDuplicates(cmd.ErrOrStderr(), payments); err != nil {
+				return err
+			}
+			if err := reportSelfPayments(cmd.ErrOrStderr(), payments); err != nil {
+				return err
+			}
 
 			// create a TxPackage from the payments
-			txPackage, totalRemaining, err := tx.ShareMoneyEasy(payments)
+			txPackage, totalRemaining, err := shareMoney(payments)
 			if err != nil {
 				return fmt.Errorf("failed to create TxPackage: %w", err)
 			}
-			if totalRemaining > 0 {
-				fmt.Printf("Warning: There are remaining unspent inputs totaling %.2f\n", totalRemaining)
+			if err := reportRemaining(cmd.ErrOrStderr(), totalRemaining, strictMode); err != nil {
+				return err
 			}
 
 			// write the TxPackage to the output CSV file
@@ -73,12 +123,24 @@ func shareCmd() *cobra.Command {
 			}(outputFile)
 
 			// show result in output
-			_, err = outputFile.Write([]byte(txPackage.String()))
-			if err != nil {
-				return err
+			records := userPaymentsToRecords(payments)
+			if anonymize {
+				if txPackage, records, err = anonymizeExport(mappingPath, outputPath, txPackage, records); err != nil {
+					return err
+				}
 			}
-
-			return nil
+			switch outputFormat {
+			case outputFormatCSV:
+				return writeTransfersCSV(outputFile, txPackage)
+			case outputFormatDOT:
+				_, err = outputFile.Write([]byte(tx.ExportDOT(txPackage)))
+			case outputFormatMarkdown:
+				tripName := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+				_, err = outputFile.Write([]byte(tx.ExportMarkdown(tripName, records, txPackage)))
+			default:
+				_, err = outputFile.Write([]byte(txPackage.String()))
+			}
+			return err
 		},
 	}
 
@@ -94,10 +156,115 @@ func shareCmd() *cobra.Command {
 		log.Fatal(err)
 		return nil
 	}
+	cmd.Flags().BoolVar(&strictMode, "strict", false, "exit with error when there are remaining unspent inputs")
+	cmd.Flags().IntVar(&decimals, "decimals", defaultDecimals, "allowed decimal places of amounts, warn when exceeded")
+	cmd.Flags().StringVar(&outputFormat, "output-format", outputFormatText, "output format, text, md (Markdown for sharing in chat apps), csv (From,To,Amount per transfer) or dot (Graphviz digraph of transfers)")
+	cmd.Flags().BoolVar(&anonymize, "anonymize", false, "replace addresses with pseudonyms (Person A, Person B ...) in the output")
+	cmd.Flags().IntVar(&MaxInputRows, "max-rows", DefaultMaxInputRows, "maximum number of payments accepted from the input, larger files are rejected")
+	cmd.Flags().StringVar(&mappingPath, "mapping", "", "JSON file of address to pseudonym mapping used with --anonymize, loaded if it exists and updated after; default <output>.mapping.json")
 
 	return cmd
 }
 
+// shareMoney settles the payments of share, it is replaced in tests to leave inputs unspent,
+// which rows validated by the parser do not.
+var shareMoney = tx.ShareMoneyEasy
+
+// reportRemaining writes the remaining inputs warning to errOut (stderr), keep stdout clean for the result.
+// In strict mode, remaining inputs are returned as error to make the command exit non-zero.
+func reportRemaining(errOut io.Writer, totalRemaining float64, strict bool) error {
+	if totalRemaining < tx.MinValueTxOutput {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("there are remaining unspent inputs totaling %.2f", totalRemaining)
+	}
+	_, err := fmt.Fprintf(errOut, remainingWarningFormat, totalRemaining)
+	return err
+}
+
+// reportPrecision writes a warning line to errOut (stderr) for each over-precise value.
+func reportPrecision(errOut io.Writer, warnings []tx.PrecisionWarning) error {
+	for _, w := range warnings {
+		if _, err := fmt.Fprintf(errOut, precisionWarningFormat, w.Name, w.Field, w.Index, w.Value, w.Decimals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportDuplicates writes a warning line to errOut (stderr) for each group of identical looking rows,
+// rows are numbered as in the CSV file (header is row 1).
+func reportDuplicates(errOut io.Writer, payments []tx.UserPayment) error {
+	for _, group := range tx.FindDuplicatePayments(payments) {
+		rows := make([]string, len(group))
+		for i, idx := range group {
+			rows[i] = strconv.Itoa(idx + 2)
+		}
+		first := payments[group[0]]
+		if _, err := fmt.Fprintf(errOut, duplicateWarningFormat, first.Name, first.Amount, first.PrePayAddress, strings.Join(rows, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportSelfPayments writes a warning line to errOut (stderr) for each row only shared by its prepayer,
+// the settlement skips it. Rows are numbered as in the CSV file (header is row 1).
+func reportSelfPayments(errOut io.Writer, payments []tx.UserPayment) error {
+	for _, idx := range tx.FindSelfPayments(payments) {
+		up := payments[idx]
+		if _, err := fmt.Fprintf(errOut, selfPaymentWarningFormat, up.Name, up.PrePayAddress, idx+2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anonymizeExport replaces the addresses of txPackage and records with pseudonyms, the mapping is loaded from
+// mappingPath when the file exists, so repeated exports keep the same pseudonyms, and is written back to it.
+// An empty mappingPath defaults to the output path with ".mapping.json" suffix.
+func anonymizeExport(mappingPath, outputPath string, txPackage tx.Package, records []db.RecordInfo) (tx.Package, []db.RecordInfo, error) {
+	if mappingPath == "" {
+		mappingPath = outputPath + ".mapping.json"
+	}
+	var mapping tx.AddressMapping
+	content, err := os.ReadFile(mappingPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(content, &mapping); err != nil {
+			return tx.Package{}, nil, fmt.Errorf("invalid mapping file %s: %w", mappingPath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return tx.Package{}, nil, err
+	}
+
+	anonymized, mapping := txPackage.Anonymize(mapping)
+	anonymizedRecords, mapping := tx.AnonymizeRecords(records, mapping)
+
+	content, err = json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return tx.Package{}, nil, err
+	}
+	if err := os.WriteFile(mappingPath, content, 0o600); err != nil {
+		return tx.Package{}, nil, err
+	}
+	return anonymized, anonymizedRecords, nil
+}
+
+// userPaymentsToRecords keeps the fields of payments shown in the expense list of an export.
+func userPaymentsToRecords(payments []tx.UserPayment) []db.RecordInfo {
+	records := make([]db.RecordInfo, len(payments))
+	for i, payment := range payments {
+		records[i] = db.RecordInfo{
+			Name:          payment.Name,
+			Amount:        payment.Amount,
+			PrePayAddress: db.Address(payment.PrePayAddress),
+		}
+	}
+	return records
+}
+
 // ParseCSVToUserPayments parses a CSV content into a slice of tx.UserPayment structs.
 func ParseCSVToUserPayments(csvContent [][]string) ([]tx.UserPayment, error) {
 	if len(csvContent) == 0 {
@@ -106,33 +273,98 @@ func ParseCSVToUserPayments(csvContent [][]string) ([]tx.UserPayment, error) {
 
 	// skip the header row
 	dataRows := csvContent[1:]
+	if err := checkRowCount(len(dataRows)); err != nil {
+		return nil, err
+	}
 
 	var payments []tx.UserPayment
 	for i, row := range dataRows {
-		if len(row) != 4 {
-			return nil, fmt.Errorf("row %d: expected 4 columns, but got %d", i+2, len(row)) // +2 to account for the header row
+		payment, err := parseCSVRow(row, i+2) // +2 to account for the header row
+		if err != nil {
+			return nil, err
 		}
+		payments = append(payments, payment)
+	}
 
-		amount, err := strconv.ParseFloat(row[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("row %d: failed to convert amount '%s' to float: %w", i+2, row[1], err)
+	return payments, nil
+}
+
+// parseCSVRow parses a data row of the CSV input, rowNumber is the row in the file and prefixes every error.
+func parseCSVRow(row []string, rowNumber int) (tx.UserPayment, error) {
+	if len(row) < 4 || len(row) > 6 {
+		return tx.UserPayment{}, fmt.Errorf("row %d: expected 4 to 6 columns, but got %d", rowNumber, len(row))
+	}
+
+	amount, err := strconv.ParseFloat(row[1], 64)
+	if err != nil {
+		return tx.UserPayment{}, fmt.Errorf("row %d: failed to convert amount '%s' to float: %w", rowNumber, row[1], err)
+	}
+
+	paymentType := 0 // Default to AverageSplitStrategy
+	if len(row) >= 5 && strings.TrimSpace(row[4]) != "" {
+		if paymentType, err = tx.ShareMoneyStrategyEnum(strings.TrimSpace(row[4])); err != nil {
+			return tx.UserPayment{}, fmt.Errorf("row %d: %w", rowNumber, err)
 		}
+	}
 
-		shouldPayAddresses := strings.Split(row[3], ",")
+	shouldPayAddresses := strings.Split(row[3], ",")
+	extendPayMsg := make([]float64, len(shouldPayAddresses)) // Initialize with zero values
+	for j := range shouldPayAddresses {
+		shouldPayAddresses[j] = strings.TrimSpace(shouldPayAddresses[j])
+	}
+	if len(row) == 6 && strings.TrimSpace(row[5]) != "" {
+		// the values of the sixth column are in the order of the should pay addresses
+		if extendPayMsg, err = parseExtendPayMsg(row[5]); err != nil {
+			return tx.UserPayment{}, fmt.Errorf("row %d: %w", rowNumber, err)
+		}
+		if needsExtendPayMsg(paymentType) && len(extendPayMsg) != len(shouldPayAddresses) {
+			return tx.UserPayment{}, fmt.Errorf("row %d: expected %d extend pay values for strategy %q, but got %d",
+				rowNumber, len(shouldPayAddresses), tx.ShareMoneyStrategyNames[paymentType], len(extendPayMsg))
+		}
+	} else if paymentType != 0 {
+		// the value of a strategy other than average is written after the address, e.g. Alice:30
 		for j := range shouldPayAddresses {
-			shouldPayAddresses[j] = strings.TrimSpace(shouldPayAddresses[j])
+			address, value, found := strings.Cut(shouldPayAddresses[j], ":")
+			if !found {
+				continue
+			}
+			if extendPayMsg[j], err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				return tx.UserPayment{}, fmt.Errorf("row %d: failed to convert value of should pay address '%s' to float: %w", rowNumber, shouldPayAddresses[j], err)
+			}
+			shouldPayAddresses[j] = strings.TrimSpace(address)
 		}
+	}
 
-		payment := tx.UserPayment{
-			Name:             row[0],
-			Amount:           amount,
-			PrePayAddress:    row[2],
-			ShouldPayAddress: shouldPayAddresses,
-			ExtendPayMsg:     make([]float64, len(shouldPayAddresses)), // Initialize with zero values
-			PaymentType:      0,                                        // Default to AverageSplitStrategy
-		}
-		payments = append(payments, payment)
+	return tx.UserPayment{
+		Name:             row[0],
+		Amount:           amount,
+		PrePayAddress:    row[2],
+		ShouldPayAddress: shouldPayAddresses,
+		ExtendPayMsg:     extendPayMsg,
+		PaymentType:      paymentType,
+	}, nil
+}
+
+// needsExtendPayMsg reports whether the strategy enum splits by one ExtendPayMsg value per should pay address,
+// average and transfer do not use them.
+func needsExtendPayMsg(paymentType int) bool {
+	switch tx.ShareMoneyStrategyNames[paymentType] {
+	case "average", "transfer":
+		return false
 	}
+	return true
+}
 
-	return payments, nil
+// parseExtendPayMsg parses the comma-separated ExtendPayMsg column, e.g. "10,20".
+func parseExtendPayMsg(cell string) ([]float64, error) {
+	parts := strings.Split(cell, ",")
+	values := make([]float64, len(parts))
+	for j, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert extend pay value '%s' to float: %w", part, err)
+		}
+		values[j] = value
+	}
+	return values, nil
 }
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bytes"
+	"dtm/tx"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runShareCmd executes the share command with separated stdout and stderr buffers.
+func runShareCmd(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	strictMode, decimals, outputFormat, anonymize, mappingPath = false, 2, outputFormatText, false, "" // flags are bound to package vars, reset between runs
+	t.Cleanup(func() { MaxInputRows = DefaultMaxInputRows })
+
+	var stdout, stderr bytes.Buffer
+	cmd := shareCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestReportRemaining(t *testing.T) {
+	tests := []struct {
+		name           string
+		totalRemaining float64
+		strict         bool
+		expectError    bool
+		expectWarning  bool
+	}{
+		{name: "no remaining", totalRemaining: 0, strict: false},
+		{name: "no remaining strict", totalRemaining: 0, strict: true},
+		{name: "remaining non-strict warns", totalRemaining: 12.5, strict: false, expectWarning: true},
+		{name: "remaining strict fails", totalRemaining: 12.5, strict: true, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			err := reportRemaining(&errOut, tt.totalRemaining, tt.strict)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "12.50")
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.expectWarning {
+				assert.True(t, strings.HasPrefix(errOut.String(), "[dtm:warn] remaining-inputs"))
+				assert.Contains(t, errOut.String(), "total=12.50")
+			} else {
+				assert.Empty(t, errOut.String())
+			}
+		})
+	}
+}
+
+func TestShareCmd_StreamSeparation(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\n"), 0o600))
+
+	for _, strict := range []bool{false, true} {
+		output := filepath.Join(dir, "output.txt")
+		args := []string{"--input", input, "--output", output}
+		if strict {
+			args = append(args, "--strict")
+		}
+
+		stdout, stderr, err := runShareCmd(t, args...)
+		require.NoError(t, err, "strict=%v", strict)
+		assert.Empty(t, stdout, "strict=%v", strict)
+		assert.Empty(t, stderr, "strict=%v", strict)
+
+		result, err := os.ReadFile(output)
+		require.NoError(t, err)
+		assert.Contains(t, string(result), "Bob: 15.00")
+	}
+}
+
+func TestShareCmd_RemainingInputs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\n"), 0o600))
+
+	// settle an unbalanced ledger, Alice paid 12.50 more than anyone owes
+	original := shareMoney
+	shareMoney = func(payments []tx.UserPayment) (tx.Package, float64, error) {
+		txPackage, _, err := original(payments)
+		return txPackage, 12.5, err
+	}
+	t.Cleanup(func() { shareMoney = original })
+
+	output := filepath.Join(dir, "output.txt")
+	stdout, stderr, err := runShareCmd(t, "--input", input, "--output", output)
+	require.NoError(t, err, "non-strict exits zero")
+	assert.Empty(t, stdout)
+	assert.Equal(t, "[dtm:warn] remaining-inputs total=12.50\n", stderr)
+	result, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(result), "Bob: 15.00", "the result is still written")
+
+	strictOutput := filepath.Join(dir, "strict.txt")
+	_, stderr, err = runShareCmd(t, "--input", input, "--output", strictOutput, "--strict")
+	require.Error(t, err, "strict exits non-zero")
+	assert.Contains(t, err.Error(), "remaining unspent inputs totaling 12.50")
+	assert.NotContains(t, stderr, "[dtm:warn]")
+	assert.NoFileExists(t, strictOutput)
+}
+
+func TestShareCmd_InvalidInputExitsWithError(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,abc,Alice,Bob\n"), 0o600))
+
+	stdout, stderr, err := runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"), "--strict")
+	assert.Error(t, err)
+	assert.NotContains(t, stdout, "failed to parse CSV")
+	assert.Contains(t, stderr, "failed to parse CSV")
+}
+
+func TestShareCmd_PrecisionWarning(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30.125,Alice,\"Alice,Bob\"\n"), 0o600))
+
+	stdout, stderr, err := runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"))
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "[dtm:warn] precision name=lunch field=Amount index=-1 value=30.125 decimals=3")
+
+	_, stderr, err = runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"), "--decimals", "3")
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+}
+
+func TestShareCmd_DuplicateWarning(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\ntaxi,10,Bob,Alice\nlunch,30,Alice,\"Alice,Bob\"\n"), 0o600))
+
+	stdout, stderr, err := runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"))
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "[dtm:warn] duplicate name=lunch amount=30.00 prepay=Alice rows=2,4")
+}
+
+func TestShareCmd_SelfPaymentWarning(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\nsouvenir,50,Bob,Bob\n"), 0o600))
+
+	stdout, stderr, err := runShareCmd(t, "--input", input, "--output", filepath.Join(dir, "output.txt"))
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "[dtm:warn] self-payment name=souvenir prepay=Bob row=3\n", stderr)
+}
+
+func TestShareCmd_MarkdownOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "kyoto.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\n"), 0o600))
+	output := filepath.Join(dir, "output.md")
+
+	_, stderr, err := runShareCmd(t, "--input", input, "--output", output, "--output-format", "md")
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+
+	result, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(result), "# kyoto\n")
+	assert.Contains(t, string(result), "| lunch | 30.00 | Alice |")
+	assert.Contains(t, string(result), "- Bob pays Alice 15.00")
+
+	_, _, err = runShareCmd(t, "--input", input, "--output", output, "--output-format", "pdf")
+	assert.ErrorContains(t, err, "unsupported output format")
+}
+
+func TestShareCmd_CSVOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nhotel,90,Alice,\"Alice,Bob,Carol\"\ntaxi,20,Dave,\"Carol,Dave\"\n"), 0o600))
+	output := filepath.Join(dir, "output.csv")
+
+	_, stderr, err := runShareCmd(t, "--input", input, "--output", output, "--output-format", "csv")
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+
+	file, err := os.Open(output)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, rows)
+	assert.Equal(t, []string{"From", "To", "Amount"}, rows[0])
+	assert.ElementsMatch(t, [][]string{
+		{"Carol", "Alice", "40.00"},
+		{"Bob", "Alice", "20.00"},
+		{"Bob", "Dave", "10.00"},
+	}, rows[1:])
+}
+
+func TestShareCmd_DOTOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nhotel,90,Alice,\"Alice,Bob,Carol\"\ntaxi,20,Dave,\"Carol,Dave\"\n"), 0o600))
+	output := filepath.Join(dir, "output.dot")
+
+	_, stderr, err := runShareCmd(t, "--input", input, "--output", output, "--output-format", "dot")
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+
+	result, err := os.ReadFile(output)
+	require.NoError(t, err)
+	dot := string(result)
+	assert.True(t, strings.HasPrefix(dot, "digraph settlement {\n"))
+	assert.True(t, strings.HasSuffix(dot, "}\n"))
+	assert.Contains(t, dot, "\t\"Carol\" -> \"Alice\" [label=\"40.00\"];\n")
+	assert.Contains(t, dot, "\t\"Bob\" -> \"Alice\" [label=\"20.00\"];\n")
+	assert.Contains(t, dot, "\t\"Bob\" -> \"Dave\" [label=\"10.00\"];\n")
+	assert.Equal(t, 3, strings.Count(dot, " -> "))
+}
+
+func TestShareCmd_AnonymizeOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	require.NoError(t, os.WriteFile(input, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,30,Alice,\"Alice,Bob\"\n"), 0o600))
+	output := filepath.Join(dir, "output.md")
+	mapping := filepath.Join(dir, "mapping.json")
+	// a provided mapping is respected, missing addresses get the next free pseudonym
+	require.NoError(t, os.WriteFile(mapping, []byte(`{"Bob": "Person A"}`), 0o600))
+
+	_, stderr, err := runShareCmd(t, "--input", input, "--output", output, "--output-format", "md", "--anonymize", "--mapping", mapping)
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+
+	result, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.NotContains(t, string(result), "Alice")
+	assert.NotContains(t, string(result), "Bob")
+	assert.Contains(t, string(result), "- Person A pays Person B 15.00")
+
+	saved, err := os.ReadFile(mapping)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Alice": "Person B", "Bob": "Person A"}`, string(saved))
+
+	// without --mapping the mapping is written next to the output
+	_, _, err = runShareCmd(t, "--input", input, "--output", output, "--anonymize")
+	require.NoError(t, err)
+	saved, err = os.ReadFile(output + ".mapping.json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Alice": "Person A", "Bob": "Person B"}`, string(saved))
+}
+
+// csvWithRows returns CSV rows of a header and n payments.
+func csvWithRows(n int) [][]string {
+	rows := [][]string{{"Name", "Amount", "PrePayAddress", "ShouldPayAddress"}}
+	for i := 0; i < n; i++ {
+		rows = append(rows, []string{"lunch", "30", "Alice", "Alice,Bob"})
+	}
+	return rows
+}
+
+func TestParseCSVToUserPayments_RowLimit(t *testing.T) {
+	MaxInputRows = 3
+	t.Cleanup(func() { MaxInputRows = DefaultMaxInputRows })
+
+	payments, err := ParseCSVToUserPayments(csvWithRows(3))
+	require.NoError(t, err)
+	assert.Len(t, payments, 3)
+
+	_, err = ParseCSVToUserPayments(csvWithRows(4))
+	require.ErrorIs(t, err, ErrTooManyRows)
+	assert.EqualError(t, err, "too many rows: 4 payments exceed the limit of 3")
+}
+
+func TestShareCmd_MaxRows(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	output := filepath.Join(dir, "output.txt")
+	var content bytes.Buffer
+	require.NoError(t, csv.NewWriter(&content).WriteAll(csvWithRows(3)))
+	require.NoError(t, os.WriteFile(input, content.Bytes(), 0o644))
+
+	_, _, err := runShareCmd(t, "--input", input, "--output", output, "--max-rows", "3")
+	require.NoError(t, err)
+
+	_, _, err = runShareCmd(t, "--input", input, "--output", output, "--max-rows", "2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many rows: 3 payments exceed the limit of 2")
+}
+
+func TestParseCSVToUserPayments_Strategy(t *testing.T) {
+	payments, err := ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy"},
+		{"hotel", "30", "Alice", "Alice:10, Bob:20", "fixed"},
+		{"lunch", "20", "Bob", "Alice,Bob", ""},
+		{"taxi", "12", "Bob", "Alice:1,Bob:2", "part"},
+	})
+	require.NoError(t, err)
+	require.Len(t, payments, 3)
+
+	fixed, err := tx.ShareMoneyStrategyEnum("fixed")
+	require.NoError(t, err)
+	assert.Equal(t, fixed, payments[0].PaymentType)
+	assert.Equal(t, []string{"Alice", "Bob"}, payments[0].ShouldPayAddress)
+	assert.Equal(t, []float64{10, 20}, payments[0].ExtendPayMsg)
+	// an empty strategy is average
+	assert.Equal(t, 0, payments[1].PaymentType)
+	assert.Equal(t, []float64{0, 0}, payments[1].ExtendPayMsg)
+
+	// each row is split by its own strategy
+	txPackage, _, err := tx.ShareMoneyEasy(payments)
+	require.NoError(t, err)
+	assert.NotEmpty(t, txPackage.TxList)
+}
+
+func TestParseCSVToUserPayments_ExtendPayMsg(t *testing.T) {
+	payments, err := ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy", "ExtendPayMsg"},
+		{"hotel", "30", "Alice", "Alice,Bob", "fixed", "10, 20"},
+		{"lunch", "20", "Bob", "Alice,Bob", "", ""},
+		{"taxi", "12", "Bob", "Alice,Bob"},
+		{"museum", "50", "Carol", "Alice,Bob,Carol", "fixed", "5,15,30"},
+	})
+	require.NoError(t, err)
+	require.Len(t, payments, 4)
+
+	fixed, err := tx.ShareMoneyStrategyEnum("fixed")
+	require.NoError(t, err)
+	assert.Equal(t, fixed, payments[0].PaymentType)
+	assert.Equal(t, []string{"Alice", "Bob"}, payments[0].ShouldPayAddress)
+	assert.Equal(t, []float64{10, 20}, payments[0].ExtendPayMsg)
+	// rows without the extra columns keep the average split
+	for _, payment := range payments[1:3] {
+		assert.Equal(t, 0, payment.PaymentType)
+		assert.Equal(t, []float64{0, 0}, payment.ExtendPayMsg)
+	}
+	assert.Equal(t, []float64{5, 15, 30}, payments[3].ExtendPayMsg)
+
+	txPackage, _, err := tx.ShareMoneyEasy(payments)
+	require.NoError(t, err)
+	assert.NotEmpty(t, txPackage.TxList)
+}
+
+func TestParseCSVToUserPayments_ExtendPayMsgMismatch(t *testing.T) {
+	_, err := ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy", "ExtendPayMsg"},
+		{"hotel", "30", "Alice", "Alice,Bob", "fixed", "30"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `row 2: expected 2 extend pay values for strategy "fixed", but got 1`)
+
+	_, err = ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy", "ExtendPayMsg"},
+		{"hotel", "30", "Alice", "Alice,Bob", "fixed", "10,ten"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2: failed to convert extend pay value 'ten'")
+
+	// transfer does not split by the values, their count is not checked
+	_, err = ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy", "ExtendPayMsg"},
+		{"refund", "30", "Alice", "Bob", "transfer", "1,2"},
+	})
+	require.NoError(t, err)
+}
+
+func TestParseCSVToUserPayments_UnknownStrategy(t *testing.T) {
+	_, err := ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy"},
+		{"hotel", "30", "Alice", "Alice,Bob", "equal"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `row 2: unknown share money strategy "equal"`)
+
+	_, err = ParseCSVToUserPayments([][]string{
+		{"Name", "Amount", "PrePayAddress", "ShouldPayAddress", "Strategy"},
+		{"hotel", "30", "Alice", "Alice:ten,Bob:20", "fixed"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2: failed to convert value of should pay address 'Alice:ten'")
+}
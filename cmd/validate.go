@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"dtm/tx"
+
+	"github.com/spf13/cobra"
+)
+
+func validateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "validate",
+		Short:   "check a CSV input without writing a settlement",
+		Long:    `check a CSV input against the input schema, parse its payments and check the split of every row adds up to its amount. Every problem is reported, not only the first one, a value with more decimal places than --decimals is a problem too. With --deep the payments are also settled and every address must net to the same balance as in the input, so no money is lost or created by the settlement.`,
+		Example: `dtm validate --input input.csv --deep`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, _ := cmd.Flags().GetString("input")
+			deep, _ := cmd.Flags().GetBool("deep")
+			maxDecimals, _ := cmd.Flags().GetInt("decimals")
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			payments, problems, err := validateCSVRows(content, maxDecimals)
+			if err != nil {
+				return fmt.Errorf("invalid input: %w", err)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("invalid input, %d problems found:\n%w", len(problems), errors.Join(problems...))
+			}
+			if deep {
+				if err := tx.VerifyRoundTrip(payments); err != nil {
+					return fmt.Errorf("settlement does not round-trip: %w", err)
+				}
+			}
+
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "%d payments are valid\n", len(payments))
+			return err
+		},
+	}
+
+	cmd.Flags().StringP("input", "i", "", "csv input file path (required)")
+	cmd.Flags().Bool("deep", false, "also settle the payments and check every address nets to its input balance")
+	cmd.Flags().Int("decimals", defaultDecimals, "allowed decimal places of amounts, a row exceeding it is a problem")
+	if err := cmd.MarkFlagRequired("input"); err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	return cmd
+}
+
+// validateCSVRows checks every data row of a CSV input, unlike ParseCSVToUserPayments it does not stop at
+// the first bad row. Values with more than decimals decimal places are checked like share does.
+// It returns the payments of the valid rows and one error per problem found,
+// the error is only set when the input as a whole cannot be read.
+func validateCSVRows(content []byte, decimals int) ([]tx.UserPayment, []error, error) {
+	var problems []error
+	flagged := make(map[int]bool) // rows with a schema violation, they are not parsed again
+	if err := ValidateInputAgainstSchema(content); err != nil {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, e := range errs {
+			var schemaErr *SchemaError
+			if errors.As(e, &schemaErr) && schemaErr.Path == "$" {
+				return nil, nil, e
+			}
+			var rowNumber int
+			if schemaErr != nil {
+				if _, scanErr := fmt.Sscanf(schemaErr.Path, "row %d", &rowNumber); scanErr == nil {
+					flagged[rowNumber] = true
+				}
+			}
+			problems = append(problems, e)
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1 // column count is checked per row
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, problems, nil
+	}
+
+	var payments []tx.UserPayment
+	// skip the header row, rows are numbered as in the CSV file (header is row 1)
+	for i, row := range rows[1:] {
+		rowNumber := i + 2
+		if flagged[rowNumber] {
+			continue
+		}
+		payment, err := parseCSVRow(row, rowNumber)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if warnings := tx.CheckPrecision([]tx.UserPayment{payment}, decimals); len(warnings) > 0 {
+			for _, w := range warnings {
+				problems = append(problems, precisionProblem(rowNumber, w, decimals))
+			}
+			continue
+		}
+		t, err := payment.ToTx(tx.ShareMoneyStrategyFactory(payment.PaymentType))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("row %d: %w", rowNumber, err))
+			continue
+		}
+		if !t.BoolValidate() {
+			problems = append(problems, fmt.Errorf("row %d: %w", rowNumber, t.ValidateDetailed()))
+			continue
+		}
+		payments = append(payments, payment)
+	}
+	return payments, problems, nil
+}
+
+// precisionProblem reports an over-precise value of a row, the ExtendPayMsg index is included for split values.
+func precisionProblem(rowNumber int, w tx.PrecisionWarning, decimals int) error {
+	field := w.Field
+	if w.Index >= 0 {
+		field = fmt.Sprintf("%s[%d]", w.Field, w.Index)
+	}
+	return fmt.Errorf("row %d: %s %v has %d decimal places, more than %d", rowNumber, field, w.Value, w.Decimals, decimals)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runValidateCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	cmd := validateCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestValidateCmd_DeepSample(t *testing.T) {
+	out, err := runValidateCmd(t, "--input", filepath.Join("..", "sampleInput.csv"), "--deep")
+	require.NoError(t, err)
+	assert.Contains(t, out, "payments are valid")
+}
+
+func TestValidateCmd_InputOutputMismatch(t *testing.T) {
+	// the fixed amounts only cover 95 of the 100 paid
+	inputPath := filepath.Join(t.TempDir(), "input.csv")
+	content := "Name,Amount,PrePayAddress,ShouldPayAddress,Strategy,ExtendPayMsg\nhotel,100,Alice,\"Alice,Bob\",fixed,\"45,50\"\n"
+	require.NoError(t, os.WriteFile(inputPath, []byte(content), 0o600))
+
+	// the split of every row is checked without --deep
+	_, err := runValidateCmd(t, "--input", inputPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2: tx hotel inputs sum 95.00 != output 100.00")
+}
+
+func TestValidateCmd_ReportsEveryProblem(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "input.csv")
+	content := strings.Join([]string{
+		"Name,Amount,PrePayAddress,ShouldPayAddress,Strategy,ExtendPayMsg",
+		"lunch,30,Alice,\"Alice,Bob\"",                  // valid
+		"dinner,60,Alice",                               // too few columns
+		"taxi,abc,Bob,\"Alice,Bob\"",                    // non-numeric amount
+		"snack,10,,\"Alice,Bob\"",                       // empty pre-pay
+		"hotel,100,Alice,\"Alice,Bob\",fixed,\"45,50\"", // split does not add up
+		"ticket,20,Bob,\"Alice,Bob\"",                   // valid
+	}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(inputPath, []byte(content), 0o600))
+
+	out, err := runValidateCmd(t, "--input", inputPath)
+	require.Error(t, err)
+	assert.NotContains(t, out, "payments are valid")
+	msg := err.Error()
+	assert.Contains(t, msg, "4 problems found")
+	assert.Contains(t, msg, "row 3: expected 4 columns, but got 3")
+	assert.Contains(t, msg, "row 4.Amount")
+	assert.Contains(t, msg, "row 5.PrePayAddress")
+	assert.Contains(t, msg, "row 6: tx hotel inputs sum 95.00 != output 100.00")
+	assert.NotContains(t, msg, "row 2")
+	assert.NotContains(t, msg, "row 7")
+}
+
+func TestValidateCmd_OverPrecise(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "input.csv")
+	content := strings.Join([]string{
+		"Name,Amount,PrePayAddress,ShouldPayAddress,Strategy,ExtendPayMsg",
+		"lunch,30.125,Alice,\"Alice,Bob\"",                      // over-precise amount
+		"hotel,100,Alice,\"Alice,Bob\",fixed,\"49.995,50.005\"", // over-precise split values
+		"ticket,20.5,Bob,\"Alice,Bob\"",                         // valid
+	}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(inputPath, []byte(content), 0o600))
+
+	_, err := runValidateCmd(t, "--input", inputPath)
+	require.Error(t, err)
+	msg := err.Error()
+	assert.Contains(t, msg, "3 problems found")
+	assert.Contains(t, msg, "row 2: Amount 30.125 has 3 decimal places, more than 2")
+	assert.Contains(t, msg, "row 3: ExtendPayMsg[0] 49.995 has 3 decimal places, more than 2")
+	assert.Contains(t, msg, "row 3: ExtendPayMsg[1] 50.005 has 3 decimal places, more than 2")
+	assert.NotContains(t, msg, "row 4")
+
+	// the same limit as share, raised by --decimals
+	out, err := runValidateCmd(t, "--input", inputPath, "--decimals", "3")
+	require.NoError(t, err)
+	assert.Contains(t, out, "3 payments are valid")
+}
+
+func TestValidateCmd_SchemaError(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "input.csv")
+	require.NoError(t, os.WriteFile(inputPath, []byte("Name,Amount,PrePayAddress,ShouldPayAddress\nlunch,-1,Alice,Bob\n"), 0o600))
+
+	_, err := runValidateCmd(t, "--input", inputPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2.Amount")
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"dtm/db/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r3labs/diff/v3"
+	"github.com/redis/go-redis/v9"
+)
+
+// cachingWrapper is a TripDBWrapper which caches the trip info, records and address list of a trip in Redis,
+// the other methods, the data loaders included, are served by the embedded inner wrapper.
+// A mutation deletes the cached keys of its trip after the inner write, the next read fills them again.
+type cachingWrapper struct {
+	db.TripDBWrapper
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// NewCachingWrapper returns a TripDBWrapper which caches reads of inner in rdb for ttl.
+// Redis is best effort on reads, a cache error falls through to inner.
+func NewCachingWrapper(inner db.TripDBWrapper, rdb *redis.Client, ttl time.Duration) db.TripDBWrapper {
+	return &cachingWrapper{TripDBWrapper: inner, rdb: rdb, ttl: ttl}
+}
+
+func tripInfoKey(id uuid.UUID) string {
+	return fmt.Sprintf("dtm:trip:%s:info", id)
+}
+
+func tripRecordsKey(id uuid.UUID) string {
+	return fmt.Sprintf("dtm:trip:%s:records", id)
+}
+
+func tripAddressListKey(id uuid.UUID) string {
+	return fmt.Sprintf("dtm:trip:%s:addresses", id)
+}
+
+// cached returns the value of key, on a miss or a cache error it is loaded and stored for ttl.
+func cached[T any](ctx context.Context, c *cachingWrapper, key string, load func() (T, error)) (T, error) {
+	if data, err := c.rdb.Get(ctx, key).Bytes(); err == nil {
+		var value T
+		if err := json.Unmarshal(data, &value); err == nil {
+			return value, nil
+		}
+	}
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		// a value which can not be cached is still returned
+		_ = c.rdb.Set(ctx, key, data, c.ttl).Err()
+	}
+	return value, nil
+}
+
+// invalidate deletes the keys after a write of inner, a failure is reported as the cache may serve stale data until ttl.
+func (c *cachingWrapper) invalidate(ctx context.Context, writeErr error, keys ...string) error {
+	if len(keys) == 0 {
+		return writeErr
+	}
+	if err := c.rdb.Del(ctx, keys...).Err(); err != nil {
+		return errors.Join(writeErr, fmt.Errorf("failed to invalidate cache %v: %w", keys, err))
+	}
+	return writeErr
+}
+
+// WithActor returns a wrapper on the same storage and cache whose changes are audited as made by actor.
+func (c *cachingWrapper) WithActor(actor string) db.TripDBWrapper {
+	return &cachingWrapper{TripDBWrapper: c.TripDBWrapper.WithActor(actor), rdb: c.rdb, ttl: c.ttl}
+}
+
+func (c *cachingWrapper) GetTripInfo(ctx context.Context, id uuid.UUID) (*db.TripInfo, error) {
+	return cached(ctx, c, tripInfoKey(id), func() (*db.TripInfo, error) {
+		return c.TripDBWrapper.GetTripInfo(ctx, id)
+	})
+}
+
+func (c *cachingWrapper) GetTripRecords(ctx context.Context, id uuid.UUID) ([]db.RecordInfo, error) {
+	return cached(ctx, c, tripRecordsKey(id), func() ([]db.RecordInfo, error) {
+		return c.TripDBWrapper.GetTripRecords(ctx, id)
+	})
+}
+
+func (c *cachingWrapper) GetTripAddressList(ctx context.Context, id uuid.UUID) ([]db.Address, error) {
+	return cached(ctx, c, tripAddressListKey(id), func() ([]db.Address, error) {
+		return c.TripDBWrapper.GetTripAddressList(ctx, id)
+	})
+}
+
+func (c *cachingWrapper) CreateTrip(ctx context.Context, info *db.TripInfo) error {
+	err := c.TripDBWrapper.CreateTrip(ctx, info)
+	return c.invalidate(ctx, err, tripInfoKey(info.ID))
+}
+
+func (c *cachingWrapper) CreateTripRecords(ctx context.Context, id uuid.UUID, records []db.Record) error {
+	err := c.TripDBWrapper.CreateTripRecords(ctx, id, records)
+	return c.invalidate(ctx, err, tripRecordsKey(id))
+}
+
+func (c *cachingWrapper) UpdateTripInfo(ctx context.Context, info *db.TripInfo) error {
+	err := c.TripDBWrapper.UpdateTripInfo(ctx, info)
+	return c.invalidate(ctx, err, tripInfoKey(info.ID))
+}
+
+func (c *cachingWrapper) UpsertTrip(ctx context.Context, info *db.TripInfo) error {
+	err := c.TripDBWrapper.UpsertTrip(ctx, info)
+	return c.invalidate(ctx, err, tripInfoKey(info.ID))
+}
+
+func (c *cachingWrapper) UpdateTripRecord(ctx context.Context, recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error) {
+	tripID, err := c.TripDBWrapper.UpdateTripRecord(ctx, recordID, changeLog)
+	return tripID, c.invalidate(ctx, err, recordsKeysOf(tripID)...)
+}
+
+func (c *cachingWrapper) UpdateTripRecords(ctx context.Context, records []db.Record) (map[uuid.UUID]uuid.UUID, error) {
+	tripIDs, err := c.TripDBWrapper.UpdateTripRecords(ctx, records)
+	var keys []string
+	seen := make(map[uuid.UUID]bool)
+	for _, tripID := range tripIDs {
+		if !seen[tripID] {
+			seen[tripID] = true
+			keys = append(keys, recordsKeysOf(tripID)...)
+		}
+	}
+	return tripIDs, c.invalidate(ctx, err, keys...)
+}
+
+func (c *cachingWrapper) PatchTripRecord(ctx context.Context, recordID uuid.UUID, patch db.RecordPatch) (uuid.UUID, error) {
+	tripID, err := c.TripDBWrapper.PatchTripRecord(ctx, recordID, patch)
+	return tripID, c.invalidate(ctx, err, recordsKeysOf(tripID)...)
+}
+
+func (c *cachingWrapper) DeleteTripRecord(ctx context.Context, recordID uuid.UUID) (uuid.UUID, error) {
+	tripID, err := c.TripDBWrapper.DeleteTripRecord(ctx, recordID)
+	return tripID, c.invalidate(ctx, err, recordsKeysOf(tripID)...)
+}
+
+func (c *cachingWrapper) TripAddressListAdd(ctx context.Context, id uuid.UUID, address db.Address) error {
+	err := c.TripDBWrapper.TripAddressListAdd(ctx, id, address)
+	return c.invalidate(ctx, err, tripAddressListKey(id))
+}
+
+func (c *cachingWrapper) RepairTripAddressList(ctx context.Context, tripID uuid.UUID) ([]db.Address, error) {
+	added, err := c.TripDBWrapper.RepairTripAddressList(ctx, tripID)
+	return added, c.invalidate(ctx, err, tripAddressListKey(tripID))
+}
+
+func (c *cachingWrapper) TripAddressListRemove(ctx context.Context, id uuid.UUID, address db.Address) error {
+	err := c.TripDBWrapper.TripAddressListRemove(ctx, id, address)
+	return c.invalidate(ctx, err, tripAddressListKey(id))
+}
+
+func (c *cachingWrapper) DeleteTrip(ctx context.Context, id uuid.UUID) error {
+	err := c.TripDBWrapper.DeleteTrip(ctx, id)
+	return c.invalidate(ctx, err, tripInfoKey(id), tripRecordsKey(id), tripAddressListKey(id))
+}
+
+// recordsKeysOf is the records key of the trip returned by a record mutation, none when the trip is unknown.
+func recordsKeysOf(tripID uuid.UUID) []string {
+	if tripID == uuid.Nil {
+		return nil
+	}
+	return []string{tripRecordsKey(tripID)}
+}
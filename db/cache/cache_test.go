@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"dtm/db/db"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTripDB is an in-memory inner wrapper which counts the cached reads,
+// methods the tests do not use panic on the nil embedded interface.
+type fakeTripDB struct {
+	db.TripDBWrapper
+
+	mu        sync.Mutex
+	infos     map[uuid.UUID]*db.TripInfo
+	records   map[uuid.UUID][]db.RecordInfo
+	addresses map[uuid.UUID][]db.Address
+	recordOf  map[uuid.UUID]uuid.UUID // trip ID of each record
+	reads     map[string]int          // calls of each cached read
+}
+
+func newFakeTripDB() *fakeTripDB {
+	return &fakeTripDB{
+		infos:     make(map[uuid.UUID]*db.TripInfo),
+		records:   make(map[uuid.UUID][]db.RecordInfo),
+		addresses: make(map[uuid.UUID][]db.Address),
+		recordOf:  make(map[uuid.UUID]uuid.UUID),
+		reads:     make(map[string]int),
+	}
+}
+
+func (f *fakeTripDB) readCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads[method]
+}
+
+func (f *fakeTripDB) CreateTrip(_ context.Context, info *db.TripInfo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *info
+	f.infos[info.ID] = &copied
+	return nil
+}
+
+func (f *fakeTripDB) UpdateTripInfo(_ context.Context, info *db.TripInfo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.infos[info.ID]; !ok {
+		return fmt.Errorf("trip %s: %w", info.ID, db.ErrNotFound)
+	}
+	copied := *info
+	f.infos[info.ID] = &copied
+	return nil
+}
+
+func (f *fakeTripDB) CreateTripRecords(_ context.Context, id uuid.UUID, records []db.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, record := range records {
+		f.records[id] = append(f.records[id], record.RecordInfo)
+		f.recordOf[record.ID] = id
+	}
+	return nil
+}
+
+func (f *fakeTripDB) PatchTripRecord(_ context.Context, recordID uuid.UUID, patch db.RecordPatch) (uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tripID, ok := f.recordOf[recordID]
+	if !ok {
+		return uuid.Nil, fmt.Errorf("record %s: %w", recordID, db.ErrNotFound)
+	}
+	for i, record := range f.records[tripID] {
+		if record.ID == recordID {
+			full := db.Record{RecordInfo: record}
+			patch.Apply(&full)
+			f.records[tripID][i] = full.RecordInfo
+		}
+	}
+	return tripID, nil
+}
+
+func (f *fakeTripDB) TripAddressListAdd(_ context.Context, id uuid.UUID, address db.Address) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addresses[id] = append(f.addresses[id], address)
+	return nil
+}
+
+func (f *fakeTripDB) DeleteTrip(_ context.Context, id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.infos, id)
+	delete(f.records, id)
+	delete(f.addresses, id)
+	return nil
+}
+
+func (f *fakeTripDB) GetTripInfo(_ context.Context, id uuid.UUID) (*db.TripInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads["GetTripInfo"]++
+	info, ok := f.infos[id]
+	if !ok {
+		return nil, fmt.Errorf("trip %s: %w", id, db.ErrNotFound)
+	}
+	copied := *info
+	return &copied, nil
+}
+
+func (f *fakeTripDB) GetTripRecords(_ context.Context, id uuid.UUID) ([]db.RecordInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads["GetTripRecords"]++
+	return append([]db.RecordInfo{}, f.records[id]...), nil
+}
+
+func (f *fakeTripDB) GetTripAddressList(_ context.Context, id uuid.UUID) ([]db.Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads["GetTripAddressList"]++
+	return append([]db.Address{}, f.addresses[id]...), nil
+}
+
+func (f *fakeTripDB) DataLoaderGetTripInfoList(_ context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*db.TripInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads["DataLoaderGetTripInfoList"]++
+	result := make(map[uuid.UUID]*db.TripInfo, len(tripIds))
+	for _, id := range tripIds {
+		result[id] = f.infos[id]
+	}
+	return result, nil
+}
+
+const testTTL = time.Minute
+
+func newTestWrapper(t *testing.T) (db.TripDBWrapper, *fakeTripDB, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	inner := newFakeTripDB()
+	return NewCachingWrapper(inner, rdb, testTTL), inner, mr
+}
+
+// createTestTrip creates a trip with one record of Alice shared by Alice and Bob.
+func createTestTrip(t *testing.T, wrapper db.TripDBWrapper) (uuid.UUID, uuid.UUID) {
+	t.Helper()
+	ctx := t.Context()
+	tripID, recordID := uuid.New(), uuid.New()
+	require.NoError(t, wrapper.CreateTrip(ctx, &db.TripInfo{ID: tripID, Name: "cached trip", Currency: "TWD"}))
+	require.NoError(t, wrapper.TripAddressListAdd(ctx, tripID, "Alice"))
+	require.NoError(t, wrapper.TripAddressListAdd(ctx, tripID, "Bob"))
+	require.NoError(t, wrapper.CreateTripRecords(ctx, tripID, []db.Record{{
+		RecordInfo: db.RecordInfo{
+			ID:             recordID,
+			Name:           "lunch",
+			Amount:         30,
+			Time:           time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC),
+			PrePayAddress:  "Alice",
+			SplitOverrides: map[db.Address]float64{"Alice": 10, "Bob": 20},
+		},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}},
+	}}))
+	return tripID, recordID
+}
+
+func TestCachingWrapper_CacheHit(t *testing.T) {
+	wrapper, inner, mr := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, _ := createTestTrip(t, wrapper)
+
+	wantInfo, err := inner.GetTripInfo(ctx, tripID)
+	require.NoError(t, err)
+	wantRecords, err := inner.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	wantAddresses, err := inner.GetTripAddressList(ctx, tripID)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		info, err := wrapper.GetTripInfo(ctx, tripID)
+		require.NoError(t, err)
+		assert.Equal(t, wantInfo, info)
+
+		records, err := wrapper.GetTripRecords(ctx, tripID)
+		require.NoError(t, err)
+		assert.Equal(t, wantRecords, records)
+
+		addresses, err := wrapper.GetTripAddressList(ctx, tripID)
+		require.NoError(t, err)
+		assert.Equal(t, wantAddresses, addresses)
+	}
+
+	// one read each by the test, one miss each by the wrapper
+	assert.Equal(t, 2, inner.readCount("GetTripInfo"))
+	assert.Equal(t, 2, inner.readCount("GetTripRecords"))
+	assert.Equal(t, 2, inner.readCount("GetTripAddressList"))
+	assert.True(t, mr.Exists(tripInfoKey(tripID)))
+	assert.True(t, mr.Exists(tripRecordsKey(tripID)))
+	assert.True(t, mr.Exists(tripAddressListKey(tripID)))
+}
+
+func TestCachingWrapper_TTLExpiry(t *testing.T) {
+	wrapper, inner, mr := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, _ := createTestTrip(t, wrapper)
+
+	_, err := wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, testTTL, mr.TTL(tripRecordsKey(tripID)))
+
+	mr.FastForward(testTTL - time.Second)
+	_, err = wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, inner.readCount("GetTripRecords"), "cached within ttl")
+
+	mr.FastForward(2 * time.Second)
+	_, err = wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.readCount("GetTripRecords"), "expired after ttl")
+}
+
+func TestCachingWrapper_InvalidateOnUpdate(t *testing.T) {
+	wrapper, inner, _ := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, recordID := createTestTrip(t, wrapper)
+
+	_, err := wrapper.GetTripInfo(ctx, tripID)
+	require.NoError(t, err)
+	require.NoError(t, wrapper.UpdateTripInfo(ctx, &db.TripInfo{ID: tripID, Name: "renamed trip", Currency: "TWD"}))
+	info, err := wrapper.GetTripInfo(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed trip", info.Name)
+	assert.Equal(t, 2, inner.readCount("GetTripInfo"))
+
+	_, err = wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	amount := 45.0
+	patchedTripID, err := wrapper.PatchTripRecord(ctx, recordID, db.RecordPatch{Amount: &amount})
+	require.NoError(t, err)
+	assert.Equal(t, tripID, patchedTripID)
+	records, err := wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 45.0, records[0].Amount)
+	assert.Equal(t, 2, inner.readCount("GetTripRecords"))
+
+	_, err = wrapper.GetTripAddressList(ctx, tripID)
+	require.NoError(t, err)
+	require.NoError(t, wrapper.TripAddressListAdd(ctx, tripID, "Carol"))
+	addresses, err := wrapper.GetTripAddressList(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice", "Bob", "Carol"}, addresses)
+	assert.Equal(t, 2, inner.readCount("GetTripAddressList"))
+
+	// the info cached for the read above is not touched by record and address writes
+	_, err = wrapper.GetTripInfo(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.readCount("GetTripInfo"))
+}
+
+func TestCachingWrapper_DeleteTripInvalidatesAll(t *testing.T) {
+	wrapper, _, mr := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, _ := createTestTrip(t, wrapper)
+
+	_, err := wrapper.GetTripInfo(ctx, tripID)
+	require.NoError(t, err)
+	_, err = wrapper.GetTripRecords(ctx, tripID)
+	require.NoError(t, err)
+	_, err = wrapper.GetTripAddressList(ctx, tripID)
+	require.NoError(t, err)
+
+	require.NoError(t, wrapper.DeleteTrip(ctx, tripID))
+	assert.False(t, mr.Exists(tripInfoKey(tripID)))
+	assert.False(t, mr.Exists(tripRecordsKey(tripID)))
+	assert.False(t, mr.Exists(tripAddressListKey(tripID)))
+
+	_, err = wrapper.GetTripInfo(ctx, tripID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	assert.False(t, mr.Exists(tripInfoKey(tripID)), "errors are not cached")
+}
+
+func TestCachingWrapper_RedisDown(t *testing.T) {
+	wrapper, inner, mr := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, _ := createTestTrip(t, wrapper)
+
+	mr.Close()
+	info, err := wrapper.GetTripInfo(ctx, tripID)
+	require.NoError(t, err, "a cache error falls through to inner")
+	assert.Equal(t, "cached trip", info.Name)
+	assert.Equal(t, 1, inner.readCount("GetTripInfo"))
+}
+
+func TestCachingWrapper_DataLoaderDelegated(t *testing.T) {
+	wrapper, inner, mr := newTestWrapper(t)
+	ctx := t.Context()
+	tripID, _ := createTestTrip(t, wrapper)
+
+	for i := 0; i < 2; i++ {
+		infos, err := wrapper.DataLoaderGetTripInfoList(ctx, []uuid.UUID{tripID})
+		require.NoError(t, err)
+		assert.Equal(t, "cached trip", infos[tripID].Name)
+	}
+	assert.Equal(t, 2, inner.readCount("DataLoaderGetTripInfoList"), "data loaders are not cached")
+	assert.False(t, mr.Exists(tripInfoKey(tripID)))
+}
@@ -2,36 +2,98 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/r3labs/diff/v3"
 )
 
+// ErrNotFound is returned by every TripDBWrapper backend when the trip, record or address does not exist,
+// check it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
+// ErrAddressNotInTrip is returned by every TripDBWrapper backend when a should pay address of a record
+// is not in the trip's address list, check it with errors.Is.
+var ErrAddressNotInTrip = errors.New("not in trip")
+
+// ErrTooPrecise is returned by every TripDBWrapper backend when an amount or split value of a written record
+// has more decimal places than WriteConfig.MaxRecordDecimals of the wrapper, check it with errors.Is.
+var ErrTooPrecise = errors.New("too many decimal places")
+
+// RecordError reports the record which failed a batch write, the whole batch is rolled back.
+// Address is the address of the record which caused it, empty when it is the record itself.
+// Check it with errors.As, errors.Is still matches the cause like ErrAddressNotInTrip.
+type RecordError struct {
+	RecordID uuid.UUID
+	Address  Address
+	Err      error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %s: %v", e.RecordID, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// TripDBWrapper is the storage of trips, the methods which access it take the context of the request,
+// a cancelled context aborts the call with the context error.
 type TripDBWrapper interface {
 	// CreateTrip Create
-	CreateTrip(info *TripInfo) error
-	// CreateTripRecords Create
-	CreateTripRecords(id uuid.UUID, records []Record) error
+	CreateTrip(ctx context.Context, info *TripInfo) error
+	// CreateTripRecords Create, a record whose ExternalID already exists in the trip is not created again,
+	// its element in records is replaced by the existing record instead and it is not validated again
+	CreateTripRecords(ctx context.Context, id uuid.UUID, records []Record) error
 	// GetTripInfo Read
-	GetTripInfo(id uuid.UUID) (*TripInfo, error)
+	GetTripInfo(ctx context.Context, id uuid.UUID) (*TripInfo, error)
+	// GetTripInfos Read, every requested ID has an entry, nil for a trip which does not exist
+	GetTripInfos(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*TripInfo, error)
 	// GetTripRecords Read
-	GetTripRecords(id uuid.UUID) ([]RecordInfo, error)
+	GetTripRecords(ctx context.Context, id uuid.UUID) ([]RecordInfo, error)
+	// GetTripRecordsByGroup Read
+	GetTripRecordsByGroup(ctx context.Context, id uuid.UUID, groupID uuid.UUID) ([]RecordInfo, error)
+	// GetTripRecordsQuery Read
+	GetTripRecordsQuery(ctx context.Context, tripID uuid.UUID, opts RecordQuery) ([]RecordInfo, int, error)
+	// GetTripRecordsFiltered Read, records passing the filter sorted by time
+	GetTripRecordsFiltered(ctx context.Context, id uuid.UUID, filter RecordFilter) ([]RecordInfo, error)
+	// GetDuplicateRecords Read
+	GetDuplicateRecords(ctx context.Context, tripID uuid.UUID) ([][]uuid.UUID, error)
 	// GetTripAddressList Read
-	GetTripAddressList(id uuid.UUID) ([]Address, error)
+	GetTripAddressList(ctx context.Context, id uuid.UUID) ([]Address, error)
+	// GetTripsForAddress Read, trips whose address list contains the address, sorted by name
+	GetTripsForAddress(ctx context.Context, address Address) ([]TripInfo, error)
 	// GetRecordAddressList Read
-	GetRecordAddressList(recordID uuid.UUID) ([]ExtendAddress, error)
+	GetRecordAddressList(ctx context.Context, recordID uuid.UUID) ([]ExtendAddress, error)
 	// UpdateTripInfo Update
-	UpdateTripInfo(info *TripInfo) error
+	UpdateTripInfo(ctx context.Context, info *TripInfo) error
+	// UpsertTrip Create or Update, a missing trip is created, an existing one gets the name and currency of info
+	// and keeps its records and address list
+	UpsertTrip(ctx context.Context, info *TripInfo) error
 	// UpdateTripRecord	Update
-	UpdateTripRecord(recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error)
+	UpdateTripRecord(ctx context.Context, recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error)
+	// UpdateTripRecords Update, each record replaces the stored record of its ID, returns the trip ID of each record,
+	// a failing record leaves every record unchanged
+	UpdateTripRecords(ctx context.Context, records []Record) (map[uuid.UUID]uuid.UUID, error)
+	// PatchTripRecord Update, only the fields set in patch are changed, returns the trip ID of the record
+	PatchTripRecord(ctx context.Context, recordID uuid.UUID, patch RecordPatch) (uuid.UUID, error)
 	// TripAddressListAdd Update
-	TripAddressListAdd(id uuid.UUID, address Address) error
+	TripAddressListAdd(ctx context.Context, id uuid.UUID, address Address) error
+	// RepairTripAddressList Update
+	RepairTripAddressList(ctx context.Context, tripID uuid.UUID) ([]Address, error)
 	// TripAddressListRemove Update
-	TripAddressListRemove(id uuid.UUID, address Address) error
+	TripAddressListRemove(ctx context.Context, id uuid.UUID, address Address) error
 	// DeleteTrip Delete
-	DeleteTrip(id uuid.UUID) error
+	DeleteTrip(ctx context.Context, id uuid.UUID) error
 	// DeleteTripRecord Delete
-	DeleteTripRecord(recordID uuid.UUID) (uuid.UUID, error)
+	DeleteTripRecord(ctx context.Context, recordID uuid.UUID) (uuid.UUID, error)
+	// GetAuditLog Read, entries of every change of the trip in time order
+	GetAuditLog(ctx context.Context, tripID uuid.UUID) ([]AuditEntry, error)
+	// WithActor returns a wrapper on the same storage whose changes are audited as made by actor
+	WithActor(actor string) TripDBWrapper
 	// DataLoaderGetRecordInfoList DataLoader
 	DataLoaderGetRecordInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]RecordInfo, error)
 	// DataLoaderGetTripAddressList DataLoader
@@ -41,3 +103,18 @@ type TripDBWrapper interface {
 	// DataLoaderGetTripInfoList DataLoader
 	DataLoaderGetTripInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*TripInfo, error)
 }
+
+// AuditChangeDetail lists the changed fields of an update for the audit log, e.g. "RecordInfo.Amount,RecordInfo.Name".
+func AuditChangeDetail(changeLog diff.Changelog) string {
+	seen := make(map[string]bool, len(changeLog))
+	var fields []string
+	for _, change := range changeLog {
+		field := strings.Join(change.Path, ".")
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
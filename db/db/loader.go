@@ -1,6 +1,8 @@
 package db
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/vikstrous/dataloadgen"
 )
@@ -18,12 +20,36 @@ type TripDataLoader struct {
 	GetTripInfoList        *dataloadgen.Loader[uuid.UUID, *TripInfo]
 }
 
+// DataLoaderConfig tunes the batching of the trip data loaders, zero values keep the dataloadgen defaults.
+// A high latency db batches better with a longer wait, a low latency db adds less latency with a shorter one.
+type DataLoaderConfig struct {
+	MaxBatch int           // max keys in one fetch, 0 is unbounded
+	Wait     time.Duration // time to collect keys before a fetch, 0 is the default 16ms
+}
+
+func (c DataLoaderConfig) options() []dataloadgen.Option {
+	var options []dataloadgen.Option
+	if c.MaxBatch > 0 {
+		options = append(options, dataloadgen.WithBatchCapacity(c.MaxBatch))
+	}
+	if c.Wait > 0 {
+		options = append(options, dataloadgen.WithWait(c.Wait))
+	}
+	return options
+}
+
 // NewTripDataLoader creates a new TripDataLoader with the provided TripDBWrapper.
 func NewTripDataLoader(dbWrapper TripDBWrapper) *TripDataLoader {
+	return NewTripDataLoaderWithConfig(dbWrapper, DataLoaderConfig{})
+}
+
+// NewTripDataLoaderWithConfig creates a new TripDataLoader whose loaders batch as configured.
+func NewTripDataLoaderWithConfig(dbWrapper TripDBWrapper, config DataLoaderConfig) *TripDataLoader {
+	options := config.options()
 	return &TripDataLoader{
-		GetRecordInfoList:      dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetRecordInfoList),
-		GetTripAddressList:     dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetTripAddressList),
-		GetRecordShouldPayList: dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetRecordShouldPayList),
-		GetTripInfoList:        dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetTripInfoList),
+		GetRecordInfoList:      dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetRecordInfoList, options...),
+		GetTripAddressList:     dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetTripAddressList, options...),
+		GetRecordShouldPayList: dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetRecordShouldPayList, options...),
+		GetTripInfoList:        dataloadgen.NewMappedLoader(dbWrapper.DataLoaderGetTripInfoList, options...),
 	}
 }
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingTripDB counts the trip info fetches of the data loader, other methods are not used.
+type countingTripDB struct {
+	TripDBWrapper
+	mu      sync.Mutex
+	fetches int
+}
+
+func (c *countingTripDB) DataLoaderGetTripInfoList(_ context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*TripInfo, error) {
+	c.mu.Lock()
+	c.fetches++
+	c.mu.Unlock()
+	result := make(map[uuid.UUID]*TripInfo, len(tripIds))
+	for _, id := range tripIds {
+		result[id] = &TripInfo{ID: id}
+	}
+	return result, nil
+}
+
+func TestNewTripDataLoaderWithConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        DataLoaderConfig
+		gap           time.Duration // time between two loads
+		expectFetches int
+	}{
+		{name: "Large batch collects all keys", config: DataLoaderConfig{Wait: 200 * time.Millisecond}, expectFetches: 1},
+		{name: "Small batch splits keys", config: DataLoaderConfig{MaxBatch: 3, Wait: 200 * time.Millisecond}, expectFetches: 4},
+		{name: "Long wait batches spaced loads", config: DataLoaderConfig{Wait: 500 * time.Millisecond}, gap: 5 * time.Millisecond, expectFetches: 1},
+		{name: "Small wait fetches spaced loads alone", config: DataLoaderConfig{Wait: time.Microsecond}, gap: 5 * time.Millisecond, expectFetches: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tripDB := &countingTripDB{}
+			loader := NewTripDataLoaderWithConfig(tripDB, tt.config)
+
+			thunks := make([]func() (*TripInfo, error), 10)
+			ids := make([]uuid.UUID, len(thunks))
+			for i := range thunks {
+				ids[i] = uuid.New()
+				thunks[i] = loader.GetTripInfoList.LoadThunk(context.Background(), ids[i])
+				time.Sleep(tt.gap)
+			}
+			for i, thunk := range thunks {
+				info, err := thunk()
+				require.NoError(t, err)
+				assert.Equal(t, ids[i], info.ID)
+			}
+			assert.Equal(t, tt.expectFetches, tripDB.fetches)
+		})
+	}
+}
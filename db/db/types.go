@@ -1,6 +1,10 @@
 package db
 
 import (
+	"dtm/libs/decimal"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,6 +27,8 @@ type ExtendAddress struct {
 type TripInfo struct {
 	ID   uuid.UUID
 	Name string
+	// Currency is the ISO 4217 code of the trip, empty falls back to the deployment default
+	Currency string
 }
 
 type TripData struct {
@@ -42,6 +48,13 @@ type RecordInfo struct {
 	Time          time.Time
 	PrePayAddress Address
 	Category      RecordCategory
+	GroupID       uuid.UUID // optional sub-activity of the trip, uuid.Nil when ungrouped
+	GroupName     string
+	// SplitOverrides holds the split parameter of should pay addresses keyed by address,
+	// when set it is used instead of ExtendMsg of the should pay list.
+	SplitOverrides map[Address]float64
+	// ExternalID is an optional key supplied by the client, unique in the trip, so a retried create is a no-op.
+	ExternalID string
 }
 
 type RecordData struct {
@@ -52,3 +65,260 @@ type Record struct {
 	RecordInfo
 	RecordData
 }
+
+// CheckShouldPayAddresses returns an error wrapping ErrAddressNotInTrip for the first should pay address
+// which is not in addressList, empty addresses are skipped as the backends do not store them.
+func CheckShouldPayAddresses(tripID uuid.UUID, addressList []Address, shouldPay []ExtendAddress) error {
+	if address, ok := unlistedAddress(addressList, shouldPay); ok {
+		return fmt.Errorf("address %q %w %s address list", address, ErrAddressNotInTrip, tripID)
+	}
+	return nil
+}
+
+// CheckRecord runs CheckShouldPayAddresses and CheckRecordPrecision on a record of a batch write,
+// the error is a *RecordError naming the record and the unlisted address.
+func (c WriteConfig) CheckRecord(tripID uuid.UUID, addressList []Address, record Record) error {
+	if err := CheckShouldPayAddresses(tripID, addressList, record.ShouldPayAddress); err != nil {
+		address, _ := unlistedAddress(addressList, record.ShouldPayAddress)
+		return &RecordError{RecordID: record.ID, Address: address, Err: err}
+	}
+	if err := c.CheckRecordPrecision(record); err != nil {
+		return &RecordError{RecordID: record.ID, Err: err}
+	}
+	return nil
+}
+
+// unlistedAddress returns the first should pay address which is not in addressList.
+func unlistedAddress(addressList []Address, shouldPay []ExtendAddress) (Address, bool) {
+	listed := make(map[Address]bool, len(addressList))
+	for _, address := range addressList {
+		listed[address] = true
+	}
+	for _, extAddr := range shouldPay {
+		if extAddr.Address != "" && !listed[extAddr.Address] {
+			return extAddr.Address, true
+		}
+	}
+	return "", false
+}
+
+// NoDecimalLimit as MaxRecordDecimals accepts values of any precision, so does any negative value.
+const NoDecimalLimit = -1
+
+// WriteConfig is the check a TripDBWrapper runs on the records it writes, it is set when the wrapper is created.
+type WriteConfig struct {
+	// MaxRecordDecimals is the decimal places the amount and should pay values of a written record may have,
+	// e.g. 2 like the NUMERIC(10,2) amount column which otherwise rounds silently.
+	MaxRecordDecimals int
+}
+
+// DefaultWriteConfig is the config of a wrapper created without one, it accepts values of any precision.
+func DefaultWriteConfig() WriteConfig {
+	return WriteConfig{MaxRecordDecimals: NoDecimalLimit}
+}
+
+// checkDecimals returns an error wrapping ErrTooPrecise when v has more decimal places than MaxRecordDecimals.
+func (c WriteConfig) checkDecimals(v float64) error {
+	if c.MaxRecordDecimals < 0 {
+		return nil
+	}
+	if d := decimal.Places(v); d > c.MaxRecordDecimals {
+		return fmt.Errorf("%v has %w (%d > %d)", v, ErrTooPrecise, d, c.MaxRecordDecimals)
+	}
+	return nil
+}
+
+// CheckRecordPrecision checks the amount and the should pay values of record against MaxRecordDecimals,
+// it returns an error wrapping ErrTooPrecise for the first value with more decimal places.
+func (c WriteConfig) CheckRecordPrecision(record Record) error {
+	if err := c.checkDecimals(record.Amount); err != nil {
+		return fmt.Errorf("amount of record %q: %w", record.Name, err)
+	}
+	for _, extAddr := range record.ShouldPayAddress {
+		if err := c.checkDecimals(extAddr.ExtendMsg); err != nil {
+			return fmt.Errorf("split value of %q in record %q: %w", extAddr.Address, record.Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckPatchPrecision works like CheckRecordPrecision for the amount and should pay values set by the patch.
+func (c WriteConfig) CheckPatchPrecision(p RecordPatch) error {
+	if p.Amount != nil {
+		if err := c.checkDecimals(*p.Amount); err != nil {
+			return fmt.Errorf("amount: %w", err)
+		}
+	}
+	if p.ShouldPayAddress != nil {
+		for _, extAddr := range *p.ShouldPayAddress {
+			if err := c.checkDecimals(extAddr.ExtendMsg); err != nil {
+				return fmt.Errorf("split value of %q: %w", extAddr.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RecordPatch lists the fields of a record to change, nil fields keep their stored value.
+// The should pay list is replaced as a whole when ShouldPayAddress is set.
+type RecordPatch struct {
+	Name             *string
+	Amount           *float64
+	Time             *time.Time
+	PrePayAddress    *Address
+	Category         *RecordCategory
+	ShouldPayAddress *[]ExtendAddress
+}
+
+// Apply sets the fields of the patch on record.
+func (p RecordPatch) Apply(record *Record) {
+	if p.Name != nil {
+		record.Name = *p.Name
+	}
+	if p.Amount != nil {
+		record.Amount = *p.Amount
+	}
+	if p.Time != nil {
+		record.Time = *p.Time
+	}
+	if p.PrePayAddress != nil {
+		record.PrePayAddress = *p.PrePayAddress
+	}
+	if p.Category != nil {
+		record.Category = *p.Category
+	}
+	if p.ShouldPayAddress != nil {
+		record.ShouldPayAddress = make([]ExtendAddress, 0, len(*p.ShouldPayAddress))
+		for _, addr := range *p.ShouldPayAddress {
+			if addr.Address != "" { // as in an update, empty addresses are not stored
+				record.ShouldPayAddress = append(record.ShouldPayAddress, addr)
+			}
+		}
+	}
+}
+
+// AuditDetail lists the patched fields for the audit log in the format of AuditChangeDetail.
+func (p RecordPatch) AuditDetail() string {
+	var fields []string
+	if p.Amount != nil {
+		fields = append(fields, "RecordInfo.Amount")
+	}
+	if p.Category != nil {
+		fields = append(fields, "RecordInfo.Category")
+	}
+	if p.Name != nil {
+		fields = append(fields, "RecordInfo.Name")
+	}
+	if p.PrePayAddress != nil {
+		fields = append(fields, "RecordInfo.PrePayAddress")
+	}
+	if p.Time != nil {
+		fields = append(fields, "RecordInfo.Time")
+	}
+	if p.ShouldPayAddress != nil {
+		fields = append(fields, "RecordData.ShouldPayAddress")
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+type RecordSortField int
+
+const (
+	RecordSortByTime RecordSortField = iota
+	RecordSortByAmount
+	RecordSortByName
+)
+
+// RecordQuery describes one page of trip records, nil filters match every record.
+type RecordQuery struct {
+	Offset        int
+	Limit         int // 0 means no limit
+	SortBy        RecordSortField
+	Desc          bool
+	FromTime      *time.Time // inclusive
+	ToTime        *time.Time // exclusive
+	Category      *RecordCategory
+	PrePayAddress *Address
+}
+
+// Match reports whether the record passes the filters of the query.
+func (q RecordQuery) Match(info RecordInfo) bool {
+	if q.FromTime != nil && info.Time.Before(*q.FromTime) {
+		return false
+	}
+	if q.ToTime != nil && !info.Time.Before(*q.ToTime) {
+		return false
+	}
+	if q.Category != nil && info.Category != *q.Category {
+		return false
+	}
+	if q.PrePayAddress != nil && info.PrePayAddress != *q.PrePayAddress {
+		return false
+	}
+	return true
+}
+
+// Validate checks the pagination, sort options and time range of the query.
+func (q RecordQuery) Validate() error {
+	if q.Offset < 0 || q.Limit < 0 {
+		return fmt.Errorf("invalid pagination offset=%d limit=%d", q.Offset, q.Limit)
+	}
+	if q.SortBy < RecordSortByTime || q.SortBy > RecordSortByName {
+		return fmt.Errorf("unsupported sort field %d", q.SortBy)
+	}
+	if q.FromTime != nil && q.ToTime != nil && q.ToTime.Before(*q.FromTime) {
+		return fmt.Errorf("invalid time range from=%s to=%s", q.FromTime.Format(time.RFC3339), q.ToTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RecordFilter selects records by time range and category, nil fields mean no constraint.
+// FromTime is inclusive and ToTime exclusive, so the ranges of consecutive days do not overlap.
+type RecordFilter struct {
+	FromTime *time.Time
+	ToTime   *time.Time
+	Category *RecordCategory
+}
+
+// Query returns the RecordQuery of every record passing the filter, sorted by time.
+func (f RecordFilter) Query() RecordQuery {
+	return RecordQuery{SortBy: RecordSortByTime, FromTime: f.FromTime, ToTime: f.ToTime, Category: f.Category}
+}
+
+// AuditOperation is the kind of change recorded in the audit log.
+type AuditOperation string
+
+const (
+	AuditCreateTrip    AuditOperation = "create_trip"
+	AuditUpdateTrip    AuditOperation = "update_trip"
+	AuditDeleteTrip    AuditOperation = "delete_trip"
+	AuditCreateRecord  AuditOperation = "create_record"
+	AuditUpdateRecord  AuditOperation = "update_record"
+	AuditDeleteRecord  AuditOperation = "delete_record"
+	AuditAddAddress    AuditOperation = "add_address"
+	AuditRemoveAddress AuditOperation = "remove_address"
+)
+
+const (
+	// AuditActorSystem is the actor of changes made by a wrapper without WithActor, e.g. from the cli.
+	AuditActorSystem = "system"
+	// AuditActorAnonymous is the actor of requests which carry no key.
+	AuditActorAnonymous = "anonymous"
+)
+
+type auditActorKey string
+
+// AuditActorKey is the gin context key of the actor of the request.
+const AuditActorKey auditActorKey = "audit_actor"
+
+// AuditEntry is one change of a trip, entries are kept after the trip is deleted.
+type AuditEntry struct {
+	ID        uuid.UUID
+	TripID    uuid.UUID
+	TargetID  uuid.UUID // record ID for record operations, trip ID otherwise
+	Operation AuditOperation
+	Actor     string
+	Detail    string // changed fields of an update, or the address of an address operation
+	Time      time.Time
+}
@@ -0,0 +1,88 @@
+package dbtest
+
+import (
+	"testing"
+
+	"dtm/db/db"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunNotFoundTests checks that every backend reports a missing trip, record or address with db.ErrNotFound,
+// so callers can rely on errors.Is whatever backend is configured.
+func RunNotFoundTests(t *testing.T, wrapper db.TripDBWrapper) {
+	t.Helper()
+	tripID := uuid.New()
+	CreateTrip(t, wrapper, &db.TripInfo{ID: tripID, Name: "not found trip"}, "Alice")
+	missingTrip, missingRecord := uuid.New(), uuid.New()
+	name := "renamed"
+
+	t.Run("GetTripInfo", func(t *testing.T) {
+		_, err := wrapper.GetTripInfo(t.Context(), missingTrip)
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	})
+	t.Run("UpdateTripInfo", func(t *testing.T) {
+		assert.ErrorIs(t, wrapper.UpdateTripInfo(t.Context(), &db.TripInfo{ID: missingTrip, Name: name}), db.ErrNotFound)
+	})
+	t.Run("TripAddressListAdd", func(t *testing.T) {
+		assert.ErrorIs(t, wrapper.TripAddressListAdd(t.Context(), missingTrip, "Alice"), db.ErrNotFound)
+	})
+	t.Run("TripAddressListRemove missing trip", func(t *testing.T) {
+		assert.ErrorIs(t, wrapper.TripAddressListRemove(t.Context(), missingTrip, "Alice"), db.ErrNotFound)
+	})
+	t.Run("TripAddressListRemove missing address", func(t *testing.T) {
+		assert.ErrorIs(t, wrapper.TripAddressListRemove(t.Context(), tripID, "Bob"), db.ErrNotFound)
+		addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+		require.NoError(t, err)
+		assert.Equal(t, []db.Address{"Alice"}, addresses)
+	})
+	t.Run("DeleteTrip", func(t *testing.T) {
+		assert.ErrorIs(t, wrapper.DeleteTrip(t.Context(), missingTrip), db.ErrNotFound)
+	})
+	t.Run("DeleteTripRecord", func(t *testing.T) {
+		_, err := wrapper.DeleteTripRecord(t.Context(), missingRecord)
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	})
+	t.Run("PatchTripRecord", func(t *testing.T) {
+		_, err := wrapper.PatchTripRecord(t.Context(), missingRecord, db.RecordPatch{Name: &name})
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	})
+	t.Run("UpdateTripRecords", func(t *testing.T) {
+		record := NewGroupRecord("missing", 10, "Alice", []db.Address{"Alice"}, uuid.Nil)
+		_, err := wrapper.UpdateTripRecords(t.Context(), []db.Record{record})
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	})
+}
+
+// RunExternalIDRetryTests checks that every backend skips validation for a record whose ExternalID already exists,
+// a retry is answered with the stored record even if its payload changed, while new records are still validated.
+func RunExternalIDRetryTests(t *testing.T, wrapper db.TripDBWrapper) {
+	t.Helper()
+	tripID := uuid.New()
+	CreateTrip(t, wrapper, &db.TripInfo{ID: tripID, Name: "retry trip"}, "Alice", "Bob")
+	record := NewGroupRecord("dinner", 100, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil)
+	record.ExternalID = "retry-1"
+	records := []db.Record{record}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, records))
+
+	t.Run("retry with changed payload", func(t *testing.T) {
+		retry := NewGroupRecord("dinner", 100.123456789, "Alice", []db.Address{"Alice", "Zed"}, uuid.Nil)
+		retry.ExternalID = "retry-1"
+		retried := []db.Record{retry}
+		require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, retried))
+		assert.Equal(t, records[0].ID, retried[0].ID)
+		assert.Equal(t, 100.0, retried[0].Amount)
+	})
+	t.Run("new record is validated", func(t *testing.T) {
+		retry := record
+		invalid := NewGroupRecord("taxi", 20, "Alice", []db.Address{"Zed"}, uuid.Nil)
+		invalid.ExternalID = "retry-2"
+		err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{retry, invalid})
+		assert.ErrorIs(t, err, db.ErrAddressNotInTrip)
+		stored, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+		assert.Len(t, stored, 1)
+	})
+}
@@ -0,0 +1,40 @@
+// Package dbtest holds fixtures shared by the tests of packages which read trips through db.TripDBWrapper.
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"dtm/db/db"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// NewGroupRecord returns a normal record of the group, prePay pays amount for the shouldPay addresses.
+func NewGroupRecord(name string, amount float64, prePay db.Address, shouldPay []db.Address, groupID uuid.UUID) db.Record {
+	record := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID:            uuid.New(),
+			Name:          name,
+			Amount:        amount,
+			Time:          time.Now(),
+			PrePayAddress: prePay,
+			Category:      db.CategoryNormal,
+			GroupID:       groupID,
+		},
+	}
+	for _, addr := range shouldPay {
+		record.ShouldPayAddress = append(record.ShouldPayAddress, db.ExtendAddress{Address: addr})
+	}
+	return record
+}
+
+// CreateTrip creates the trip with the addresses in its address list.
+func CreateTrip(t testing.TB, tripDB db.TripDBWrapper, info *db.TripInfo, addresses ...db.Address) {
+	t.Helper()
+	require.NoError(t, tripDB.CreateTrip(t.Context(), info))
+	for _, address := range addresses {
+		require.NoError(t, tripDB.TripAddressListAdd(t.Context(), info.ID, address))
+	}
+}
@@ -3,7 +3,9 @@ package mem
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/r3labs/diff/v3"
@@ -12,14 +14,23 @@ import (
 	// Assuming this library is used for dataloaders
 	dbt "dtm/db/db" // Alias the db package as dbt
 	cdiff "dtm/libs/diff"
+	"dtm/tx"
 )
 
 // inMemoryTripDBWrapper is an in-memory implementation of dbt.TripDBWrapper.
 // It uses maps to store data for quick lookups.
 type inMemoryTripDBWrapper struct {
+	*memStore
+	actor string // recorded in the audit log of changes made through this wrapper
+}
+
+// memStore is the storage shared by the wrappers returned from WithActor.
+type memStore struct {
 	// Using maps to store dbt.TripInfo and TripData by Trip ID.
 	tripsInfo map[uuid.UUID]*dbt.TripInfo
 	tripsData map[uuid.UUID]*dbt.TripData // Stores records and address lists for each trip
+	auditLog  map[uuid.UUID][]dbt.AuditEntry
+	config    dbt.WriteConfig
 
 	// Mutex for thread-safety, important for concurrent access in a real application.
 	mu sync.RWMutex
@@ -27,16 +38,60 @@ type inMemoryTripDBWrapper struct {
 
 // NewInMemoryTripDBWrapper creates and returns a new instance of inMemoryTripDBWrapper.
 func NewInMemoryTripDBWrapper() dbt.TripDBWrapper {
+	return NewInMemoryTripDBWrapperWithConfig(dbt.DefaultWriteConfig())
+}
+
+// NewInMemoryTripDBWrapperWithConfig works like NewInMemoryTripDBWrapper, written records are checked by config.
+func NewInMemoryTripDBWrapperWithConfig(config dbt.WriteConfig) dbt.TripDBWrapper {
 	return &inMemoryTripDBWrapper{
-		tripsInfo: make(map[uuid.UUID]*dbt.TripInfo),
-		tripsData: make(map[uuid.UUID]*dbt.TripData),
+		memStore: &memStore{
+			tripsInfo: make(map[uuid.UUID]*dbt.TripInfo),
+			tripsData: make(map[uuid.UUID]*dbt.TripData),
+			auditLog:  make(map[uuid.UUID][]dbt.AuditEntry),
+			config:    config,
+		},
+		actor: dbt.AuditActorSystem,
+	}
+}
+
+// WithActor returns a wrapper on the same storage whose changes are audited as made by actor.
+func (db *inMemoryTripDBWrapper) WithActor(actor string) dbt.TripDBWrapper {
+	return &inMemoryTripDBWrapper{memStore: db.memStore, actor: actor}
+}
+
+// audit appends an entry to the trip's audit log, the caller must hold the write lock.
+func (db *inMemoryTripDBWrapper) audit(tripID, targetID uuid.UUID, op dbt.AuditOperation, detail string) {
+	db.auditLog[tripID] = append(db.auditLog[tripID], dbt.AuditEntry{
+		ID:        uuid.New(),
+		TripID:    tripID,
+		TargetID:  targetID,
+		Operation: op,
+		Actor:     db.actor,
+		Detail:    detail,
+		Time:      time.Now(),
+	})
+}
+
+// GetAuditLog returns the audit entries of the trip in time order, a deleted trip keeps its log.
+func (db *inMemoryTripDBWrapper) GetAuditLog(ctx context.Context, tripID uuid.UUID) ([]dbt.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := make([]dbt.AuditEntry, len(db.auditLog[tripID]))
+	copy(entries, db.auditLog[tripID])
+	return entries, nil
 }
 
 // --- Create Operations ---
 
 // CreateTrip creates a new trip entry in memory.
-func (db *inMemoryTripDBWrapper) CreateTrip(info *dbt.TripInfo) error {
+func (db *inMemoryTripDBWrapper) CreateTrip(ctx context.Context, info *dbt.TripInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -51,51 +106,113 @@ func (db *inMemoryTripDBWrapper) CreateTrip(info *dbt.TripInfo) error {
 		Records:     []dbt.Record{},
 		AddressList: []dbt.Address{},
 	}
+	db.audit(info.ID, info.ID, dbt.AuditCreateTrip, info.Name)
 	return nil
 }
 
 // CreateTripRecords adds a slice of records to an existing trip.
-func (db *inMemoryTripDBWrapper) CreateTripRecords(id uuid.UUID, records []dbt.Record) error {
+func (db *inMemoryTripDBWrapper) CreateTripRecords(ctx context.Context, id uuid.UUID, records []dbt.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tripData, exists := db.tripsData[id]
 	if !exists {
-		return fmt.Errorf("trip with ID %s not found", id)
+		return fmt.Errorf("trip with ID %s %w", id, dbt.ErrNotFound)
 	}
 
-	// Append new records and also add them to the flat recordsByID map.
+	// check every new record first, so an unlisted address or an over-precise value leaves the trip unchanged
 	for _, record := range records {
+		if _, ok := findByExternalID(tripData.Records, record.ExternalID); ok {
+			continue
+		}
+		if err := dbt.CheckShouldPayAddresses(id, tripData.AddressList, record.ShouldPayAddress); err != nil {
+			return err
+		}
+		if err := db.config.CheckRecordPrecision(record); err != nil {
+			return err
+		}
+	}
+
+	// Append new records and also add them to the flat recordsByID map.
+	for i, record := range records {
+		if existing, ok := findByExternalID(tripData.Records, record.ExternalID); ok {
+			// a retry of a created record, return the existing one
+			records[i] = existing
+			continue
+		}
 		recordCopy := record // Create a copy for the map
 		tripData.Records = append(tripData.Records, recordCopy)
+		db.audit(id, record.ID, dbt.AuditCreateRecord, record.Name)
 	}
 	return nil
 }
 
+// findByExternalID returns the record with the external ID, an empty external ID never matches.
+func findByExternalID(records []dbt.Record, externalID string) (dbt.Record, bool) {
+	if externalID == "" {
+		return dbt.Record{}, false
+	}
+	for _, record := range records {
+		if record.ExternalID == externalID {
+			return record, true
+		}
+	}
+	return dbt.Record{}, false
+}
+
 // --- Read Operations ---
 
 // GetTripInfo retrieves trip information by ID.
-func (db *inMemoryTripDBWrapper) GetTripInfo(id uuid.UUID) (*dbt.TripInfo, error) {
+func (db *inMemoryTripDBWrapper) GetTripInfo(ctx context.Context, id uuid.UUID) (*dbt.TripInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	info, exists := db.tripsInfo[id]
 	if !exists {
-		return nil, fmt.Errorf("trip info with ID %s not found", id)
+		return nil, fmt.Errorf("trip info with ID %s %w", id, dbt.ErrNotFound)
 	}
 	// Return a copy to prevent external modification
 	infoCopy := *info
 	return &infoCopy, nil
 }
 
+// GetTripInfos retrieves the TripInfo of each ID in one locked read, a missing trip is a nil entry.
+func (db *inMemoryTripDBWrapper) GetTripInfos(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*dbt.TripInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := make(map[uuid.UUID]*dbt.TripInfo, len(ids))
+	for _, id := range ids {
+		result[id] = nil
+		if info, exists := db.tripsInfo[id]; exists {
+			// Return a copy to prevent external modification
+			infoCopy := *info
+			result[id] = &infoCopy
+		}
+	}
+	return result, nil
+}
+
 // GetTripRecords retrieves all records for a given trip ID.
-func (db *inMemoryTripDBWrapper) GetTripRecords(id uuid.UUID) ([]dbt.RecordInfo, error) {
+func (db *inMemoryTripDBWrapper) GetTripRecords(ctx context.Context, id uuid.UUID) ([]dbt.RecordInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	tripData, exists := db.tripsData[id]
 	if !exists {
-		return nil, fmt.Errorf("trip data with ID %s not found", id)
+		return nil, fmt.Errorf("trip data with ID %s %w", id, dbt.ErrNotFound)
 	}
 
 	// Convert Record to RecordInfo for the return type
@@ -106,14 +223,117 @@ func (db *inMemoryTripDBWrapper) GetTripRecords(id uuid.UUID) ([]dbt.RecordInfo,
 	return recordInfos, nil
 }
 
+// GetTripRecordsByGroup retrieves the records of a trip which belong to the given group.
+// uuid.Nil as groupID retrieves the records without group.
+func (db *inMemoryTripDBWrapper) GetTripRecordsByGroup(ctx context.Context, id uuid.UUID, groupID uuid.UUID) ([]dbt.RecordInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tripData, exists := db.tripsData[id]
+	if !exists {
+		return nil, fmt.Errorf("trip data with ID %s %w", id, dbt.ErrNotFound)
+	}
+
+	recordInfos := make([]dbt.RecordInfo, 0, len(tripData.Records))
+	for _, r := range tripData.Records {
+		if r.GroupID == groupID {
+			recordInfos = append(recordInfos, r.RecordInfo)
+		}
+	}
+	return recordInfos, nil
+}
+
+// GetTripRecordsFiltered retrieves the records of a trip which pass the filter, sorted by time.
+func (db *inMemoryTripDBWrapper) GetTripRecordsFiltered(ctx context.Context, id uuid.UUID, filter dbt.RecordFilter) ([]dbt.RecordInfo, error) {
+	records, _, err := db.GetTripRecordsQuery(ctx, id, filter.Query())
+	return records, err
+}
+
+// GetTripRecordsQuery retrieves one sorted page of the trip's records which pass the query filters,
+// together with the number of matching records before pagination.
+func (db *inMemoryTripDBWrapper) GetTripRecordsQuery(ctx context.Context, tripID uuid.UUID, opts dbt.RecordQuery) ([]dbt.RecordInfo, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tripData, exists := db.tripsData[tripID]
+	if !exists {
+		return nil, 0, fmt.Errorf("trip data with ID %s %w", tripID, dbt.ErrNotFound)
+	}
+
+	recordInfos := make([]dbt.RecordInfo, 0, len(tripData.Records))
+	for _, r := range tripData.Records {
+		if opts.Match(r.RecordInfo) {
+			recordInfos = append(recordInfos, r.RecordInfo)
+		}
+	}
+
+	less := func(a, b dbt.RecordInfo) bool {
+		switch opts.SortBy {
+		case dbt.RecordSortByAmount:
+			return a.Amount < b.Amount
+		case dbt.RecordSortByName:
+			return a.Name < b.Name
+		default:
+			return a.Time.Before(b.Time)
+		}
+	}
+	sort.SliceStable(recordInfos, func(i, j int) bool {
+		if opts.Desc {
+			return less(recordInfos[j], recordInfos[i])
+		}
+		return less(recordInfos[i], recordInfos[j])
+	})
+
+	total := len(recordInfos)
+	start := min(opts.Offset, total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+	return recordInfos[start:end], total, nil
+}
+
+// GetDuplicateRecords groups the IDs of records in a trip which have same name, amount and prepayer.
+func (db *inMemoryTripDBWrapper) GetDuplicateRecords(ctx context.Context, tripID uuid.UUID) ([][]uuid.UUID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tripData, exists := db.tripsData[tripID]
+	if !exists {
+		return nil, fmt.Errorf("trip data with ID %s %w", tripID, dbt.ErrNotFound)
+	}
+
+	recordInfos := make([]dbt.RecordInfo, len(tripData.Records))
+	for i, r := range tripData.Records {
+		recordInfos[i] = r.RecordInfo
+	}
+	return tx.FindDuplicateRecords(recordInfos), nil
+}
+
 // GetTripAddressList retrieves the address list for a given trip ID.
-func (db *inMemoryTripDBWrapper) GetTripAddressList(id uuid.UUID) ([]dbt.Address, error) {
+func (db *inMemoryTripDBWrapper) GetTripAddressList(ctx context.Context, id uuid.UUID) ([]dbt.Address, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	tripData, exists := db.tripsData[id]
 	if !exists {
-		return nil, fmt.Errorf("trip data with ID %s not found", id)
+		return nil, fmt.Errorf("trip data with ID %s %w", id, dbt.ErrNotFound)
 	}
 
 	// Return a copy of the slice to prevent external modification
@@ -122,8 +342,39 @@ func (db *inMemoryTripDBWrapper) GetTripAddressList(id uuid.UUID) ([]dbt.Address
 	return addressListCopy, nil
 }
 
+// GetTripsForAddress retrieves the trips whose address list contains the address, sorted by name.
+func (db *inMemoryTripDBWrapper) GetTripsForAddress(ctx context.Context, address dbt.Address) ([]dbt.TripInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	trips := []dbt.TripInfo{}
+	for tripID, tripData := range db.tripsData {
+		for _, addr := range tripData.AddressList {
+			if addr == address {
+				if info, ok := db.tripsInfo[tripID]; ok {
+					trips = append(trips, *info)
+				}
+				break
+			}
+		}
+	}
+	sort.Slice(trips, func(i, j int) bool {
+		if trips[i].Name != trips[j].Name {
+			return trips[i].Name < trips[j].Name
+		}
+		return trips[i].ID.String() < trips[j].ID.String()
+	})
+	return trips, nil
+}
+
 // GetRecordAddressList retrieves the ShouldPayAddress list for a given record ID.
-func (db *inMemoryTripDBWrapper) GetRecordAddressList(recordID uuid.UUID) ([]dbt.ExtendAddress, error) {
+func (db *inMemoryTripDBWrapper) GetRecordAddressList(ctx context.Context, recordID uuid.UUID) ([]dbt.ExtendAddress, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -139,30 +390,69 @@ func (db *inMemoryTripDBWrapper) GetRecordAddressList(recordID uuid.UUID) ([]dbt
 	}
 
 	// If we reach here, the record was not found in any trip
-	return nil, fmt.Errorf("record with ID %s not found", recordID)
+	return nil, fmt.Errorf("record with ID %s %w", recordID, dbt.ErrNotFound)
 }
 
 // --- Update Operations ---
 
 // UpdateTripInfo updates the information of an existing trip.
-func (db *inMemoryTripDBWrapper) UpdateTripInfo(info *dbt.TripInfo) error {
+func (db *inMemoryTripDBWrapper) UpdateTripInfo(ctx context.Context, info *dbt.TripInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if _, exists := db.tripsInfo[info.ID]; !exists {
-		return fmt.Errorf("trip with ID %s not found for update", info.ID)
+	existing, exists := db.tripsInfo[info.ID]
+	if !exists {
+		return fmt.Errorf("trip with ID %s %w for update", info.ID, dbt.ErrNotFound)
+	}
+
+	// Update the existing info, an empty currency keeps the current one like the pg Updates
+	infoCopy := *info
+	if infoCopy.Currency == "" {
+		infoCopy.Currency = existing.Currency
+	}
+	db.tripsInfo[info.ID] = &infoCopy
+	db.audit(info.ID, info.ID, dbt.AuditUpdateTrip, info.Name)
+	return nil
+}
+
+// UpsertTrip creates the trip if it does not exist, otherwise it updates the trip like UpdateTripInfo.
+func (db *inMemoryTripDBWrapper) UpsertTrip(ctx context.Context, info *dbt.TripInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	// Update the existing info
 	infoCopy := *info
+	existing, exists := db.tripsInfo[info.ID]
+	if !exists {
+		db.tripsInfo[info.ID] = &infoCopy
+		db.tripsData[info.ID] = &dbt.TripData{
+			Records:     []dbt.Record{},
+			AddressList: []dbt.Address{},
+		}
+		db.audit(info.ID, info.ID, dbt.AuditCreateTrip, info.Name)
+		return nil
+	}
+
+	if infoCopy.Currency == "" {
+		infoCopy.Currency = existing.Currency
+	}
 	db.tripsInfo[info.ID] = &infoCopy
+	db.audit(info.ID, info.ID, dbt.AuditUpdateTrip, info.Name)
 	return nil
 }
 
 // UpdateTripRecord updates a specific record within a trip.
 // This function updates both the RecordInfo and RecordData parts.
 // Return trip ID if the record was found and updated, or an error if not found.
-func (db *inMemoryTripDBWrapper) UpdateTripRecord(recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error) {
+func (db *inMemoryTripDBWrapper) UpdateTripRecord(ctx context.Context, recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error) {
+	if err := ctx.Err(); err != nil {
+		return uuid.Nil, err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -176,35 +466,140 @@ func (db *inMemoryTripDBWrapper) UpdateTripRecord(recordID uuid.UUID, changeLog
 			}
 		}
 		if foundIdx != -1 {
-			// apply patch on record
-			pl := cdiff.GetCustomDiffer().Patch(changeLog, &tripData.Records[foundIdx])
+			// apply patch on a copy, so a rejected patch leaves the record unchanged
+			record := tripData.Records[foundIdx]
+			record.ShouldPayAddress = append([]dbt.ExtendAddress(nil), record.ShouldPayAddress...)
+			pl := cdiff.GetCustomDiffer().Patch(changeLog, &record)
 			if pl.HasErrors() {
 				return uuid.Nil, fmt.Errorf("trip with ID %s update fail", recordID)
 			}
 			// remove empty string (patch can not decrease array/map len)
-			tmpAddrArray := make([]dbt.ExtendAddress, 0, len(tripData.Records[foundIdx].ShouldPayAddress))
-			for _, extAddr := range tripData.Records[foundIdx].ShouldPayAddress {
+			tmpAddrArray := make([]dbt.ExtendAddress, 0, len(record.ShouldPayAddress))
+			for _, extAddr := range record.ShouldPayAddress {
 				if extAddr.Address != "" {
 					tmpAddrArray = append(tmpAddrArray, extAddr)
 				}
 			}
+			if err := dbt.CheckShouldPayAddresses(tripID, tripData.AddressList, tmpAddrArray); err != nil {
+				return uuid.Nil, err
+			}
 			// set new array
-			tripData.Records[foundIdx].ShouldPayAddress = tmpAddrArray
+			record.ShouldPayAddress = tmpAddrArray
+			if err := db.config.CheckRecordPrecision(record); err != nil {
+				return uuid.Nil, err
+			}
+			tripData.Records[foundIdx] = record
+			db.audit(tripID, recordID, dbt.AuditUpdateRecord, dbt.AuditChangeDetail(changeLog))
 
 			return tripID, nil // Record found and updated, exit early
 		}
 	}
-	return uuid.Nil, fmt.Errorf("record with ID %s not found in any trip for update", recordID)
+	return uuid.Nil, fmt.Errorf("record with ID %s %w in any trip for update", recordID, dbt.ErrNotFound)
+}
+
+// UpdateTripRecords replaces each record by its ID, nothing is changed when a record is not found.
+func (db *inMemoryTripDBWrapper) UpdateTripRecords(ctx context.Context, records []dbt.Record) (map[uuid.UUID]uuid.UUID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// find every record first, so a missing one leaves the batch unapplied
+	type location struct {
+		tripID    uuid.UUID
+		index     int
+		changeLog diff.Changelog
+	}
+	locations := make([]location, len(records))
+	for i, record := range records {
+		found := false
+		for tripID, tripData := range db.tripsData {
+			for j, rec := range tripData.Records {
+				if rec.ID == record.ID {
+					locations[i] = location{tripID: tripID, index: j}
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("record with ID %s %w in any trip for update", record.ID, dbt.ErrNotFound)
+		}
+		if err := dbt.CheckShouldPayAddresses(locations[i].tripID, db.tripsData[locations[i].tripID].AddressList, record.ShouldPayAddress); err != nil {
+			return nil, fmt.Errorf("record %s: %w", record.ID, err)
+		}
+		if err := db.config.CheckRecordPrecision(record); err != nil {
+			return nil, fmt.Errorf("record %s: %w", record.ID, err)
+		}
+		stored := db.tripsData[locations[i].tripID].Records[locations[i].index]
+		changeLog, err := cdiff.GetCustomDiffer().Diff(stored, record)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", record.ID, err)
+		}
+		locations[i].changeLog = changeLog
+	}
+
+	tripIDs := make(map[uuid.UUID]uuid.UUID, len(records))
+	for i, record := range records {
+		loc := locations[i]
+		updated := record
+		updated.ShouldPayAddress = make([]dbt.ExtendAddress, 0, len(record.ShouldPayAddress))
+		for _, extAddr := range record.ShouldPayAddress {
+			if extAddr.Address != "" {
+				updated.ShouldPayAddress = append(updated.ShouldPayAddress, extAddr)
+			}
+		}
+		db.tripsData[loc.tripID].Records[loc.index] = updated
+		db.audit(loc.tripID, record.ID, dbt.AuditUpdateRecord, dbt.AuditChangeDetail(loc.changeLog))
+		tripIDs[record.ID] = loc.tripID
+	}
+	return tripIDs, nil
+}
+
+// PatchTripRecord changes only the fields set in patch, the should pay list is kept unless the patch sets it.
+func (db *inMemoryTripDBWrapper) PatchTripRecord(ctx context.Context, recordID uuid.UUID, patch dbt.RecordPatch) (uuid.UUID, error) {
+	if err := ctx.Err(); err != nil {
+		return uuid.Nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for tripID, tripData := range db.tripsData {
+		for i := range tripData.Records {
+			if tripData.Records[i].ID != recordID {
+				continue
+			}
+			if patch.ShouldPayAddress != nil {
+				if err := dbt.CheckShouldPayAddresses(tripID, tripData.AddressList, *patch.ShouldPayAddress); err != nil {
+					return uuid.Nil, err
+				}
+			}
+			if err := db.config.CheckPatchPrecision(patch); err != nil {
+				return uuid.Nil, err
+			}
+			patch.Apply(&tripData.Records[i])
+			db.audit(tripID, recordID, dbt.AuditUpdateRecord, patch.AuditDetail())
+			return tripID, nil
+		}
+	}
+	return uuid.Nil, fmt.Errorf("record with ID %s %w in any trip for update", recordID, dbt.ErrNotFound)
 }
 
 // TripAddressListAdd adds an address to a trip's address list.
-func (db *inMemoryTripDBWrapper) TripAddressListAdd(id uuid.UUID, address dbt.Address) error {
+func (db *inMemoryTripDBWrapper) TripAddressListAdd(ctx context.Context, id uuid.UUID, address dbt.Address) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tripData, exists := db.tripsData[id]
 	if !exists {
-		return fmt.Errorf("trip with ID %s not found", id)
+		return fmt.Errorf("trip with ID %s %w", id, dbt.ErrNotFound)
 	}
 
 	// Check if address already exists to avoid duplicates
@@ -215,17 +610,61 @@ func (db *inMemoryTripDBWrapper) TripAddressListAdd(id uuid.UUID, address dbt.Ad
 	}
 
 	tripData.AddressList = append(tripData.AddressList, address)
+	db.audit(id, id, dbt.AuditAddAddress, string(address))
 	return nil
 }
 
+// RepairTripAddressList adds the prepay and should-pay addresses of the trip's records
+// which are missing from the trip's address list, and returns the added ones.
+func (db *inMemoryTripDBWrapper) RepairTripAddressList(ctx context.Context, tripID uuid.UUID) ([]dbt.Address, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tripData, exists := db.tripsData[tripID]
+	if !exists {
+		return nil, fmt.Errorf("trip with ID %s %w", tripID, dbt.ErrNotFound)
+	}
+
+	known := make(map[dbt.Address]bool, len(tripData.AddressList))
+	for _, addr := range tripData.AddressList {
+		known[addr] = true
+	}
+
+	var added []dbt.Address
+	addIfMissing := func(addr dbt.Address) {
+		if !known[addr] {
+			known[addr] = true
+			added = append(added, addr)
+		}
+	}
+	for _, r := range tripData.Records {
+		addIfMissing(r.PrePayAddress)
+		for _, ea := range r.ShouldPayAddress {
+			addIfMissing(ea.Address)
+		}
+	}
+
+	tripData.AddressList = append(tripData.AddressList, added...)
+	for _, addr := range added {
+		db.audit(tripID, tripID, dbt.AuditAddAddress, string(addr))
+	}
+	return added, nil
+}
+
 // TripAddressListRemove removes an address from a trip's address list.
-func (db *inMemoryTripDBWrapper) TripAddressListRemove(id uuid.UUID, address dbt.Address) error {
+func (db *inMemoryTripDBWrapper) TripAddressListRemove(ctx context.Context, id uuid.UUID, address dbt.Address) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tripData, exists := db.tripsData[id]
 	if !exists {
-		return fmt.Errorf("trip with ID %s not found", id)
+		return fmt.Errorf("trip with ID %s %w", id, dbt.ErrNotFound)
 	}
 
 	foundIdx := -1
@@ -237,7 +676,7 @@ func (db *inMemoryTripDBWrapper) TripAddressListRemove(id uuid.UUID, address dbt
 	}
 
 	if foundIdx == -1 {
-		return fmt.Errorf("address %s not found in trip %s", address, id)
+		return fmt.Errorf("address %s %w in trip %s", address, dbt.ErrNotFound, id)
 	}
 
 	// Remove the address by slicing
@@ -255,32 +694,40 @@ func (db *inMemoryTripDBWrapper) TripAddressListRemove(id uuid.UUID, address dbt
 			}
 		}
 	}
+	db.audit(id, id, dbt.AuditRemoveAddress, string(address))
 	return nil
 }
 
 // --- Delete Operations ---
 
 // DeleteTrip deletes a trip and all its associated data (info, records, address list).
-func (db *inMemoryTripDBWrapper) DeleteTrip(id uuid.UUID) error {
+func (db *inMemoryTripDBWrapper) DeleteTrip(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	// check if the trip exists
 	if _, exists := db.tripsInfo[id]; !exists {
-		return fmt.Errorf("trip with ID %s not found for deletion", id)
+		return fmt.Errorf("trip with ID %s %w for deletion", id, dbt.ErrNotFound)
 	}
 	// Delete the trip info and data
 	if _, exists := db.tripsData[id]; !exists {
-		return fmt.Errorf("trip data with ID %s not found for deletion", id)
+		return fmt.Errorf("trip data with ID %s %w for deletion", id, dbt.ErrNotFound)
 	}
 
+	db.audit(id, id, dbt.AuditDeleteTrip, db.tripsInfo[id].Name)
 	delete(db.tripsInfo, id)
 	delete(db.tripsData, id)
 	return nil
 }
 
 // DeleteTripRecord deletes a specific record from a trip.
-func (db *inMemoryTripDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID, error) {
+func (db *inMemoryTripDBWrapper) DeleteTripRecord(ctx context.Context, recordID uuid.UUID) (uuid.UUID, error) {
+	if err := ctx.Err(); err != nil {
+		return uuid.Nil, err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -297,6 +744,7 @@ func (db *inMemoryTripDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID
 		}
 
 		if foundIdx != -1 {
+			db.audit(id, recordID, dbt.AuditDeleteRecord, tripData.Records[foundIdx].Name)
 			// Remove the record by slicing
 			tripData.Records = append(tripData.Records[:foundIdx], tripData.Records[foundIdx+1:]...)
 			found = true
@@ -305,7 +753,7 @@ func (db *inMemoryTripDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID
 	}
 
 	if !found {
-		return uuid.Nil, fmt.Errorf("record with ID %s not found for deletion", recordID)
+		return uuid.Nil, fmt.Errorf("record with ID %s %w for deletion", recordID, dbt.ErrNotFound)
 	}
 
 	return tripId, nil
@@ -313,8 +761,22 @@ func (db *inMemoryTripDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID
 
 // --- Data Loader Operations ---
 
+// fetchError returns the per key errors of a DataLoader batch as dataloadgen.MappedFetchError,
+// or nil when every key was found, like the SQL backends.
+func fetchError(errors map[uuid.UUID]error) error {
+	for _, err := range errors {
+		if err != nil {
+			return dataloadgen.MappedFetchError[uuid.UUID](errors)
+		}
+	}
+	return nil
+}
+
 // DataLoaderGetRecordInfoList retrieves a map of RecordInfo lists for given trip IDs.
-func (db *inMemoryTripDBWrapper) DataLoaderGetRecordInfoList(_ context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]dbt.RecordInfo, error) {
+func (db *inMemoryTripDBWrapper) DataLoaderGetRecordInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]dbt.RecordInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -333,14 +795,17 @@ func (db *inMemoryTripDBWrapper) DataLoaderGetRecordInfoList(_ context.Context,
 			// If a trip ID is not found, you might choose to return an empty slice or an error.
 			// For a data loader, typically an empty slice is returned if no data exists for the key.
 			result[tripID] = []dbt.RecordInfo{}
-			errors[tripID] = fmt.Errorf("trip with ID %s not found", tripID)
+			errors[tripID] = fmt.Errorf("trip with ID %s %w", tripID, dbt.ErrNotFound)
 		}
 	}
-	return result, dataloadgen.MappedFetchError[uuid.UUID](errors)
+	return result, fetchError(errors)
 }
 
 // DataLoaderGetTripAddressList retrieves a map of Address lists for given trip IDs.
-func (db *inMemoryTripDBWrapper) DataLoaderGetTripAddressList(_ context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]dbt.Address, error) {
+func (db *inMemoryTripDBWrapper) DataLoaderGetTripAddressList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]dbt.Address, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -356,14 +821,17 @@ func (db *inMemoryTripDBWrapper) DataLoaderGetTripAddressList(_ context.Context,
 			errors[tripID] = nil // No error for this trip ID
 		} else {
 			result[tripID] = []dbt.Address{}
-			errors[tripID] = fmt.Errorf("trip with ID %s not found", tripID)
+			errors[tripID] = fmt.Errorf("trip with ID %s %w", tripID, dbt.ErrNotFound)
 		}
 	}
-	return result, dataloadgen.MappedFetchError[uuid.UUID](errors)
+	return result, fetchError(errors)
 }
 
 // DataLoaderGetRecordShouldPayList retrieves a map of ShouldPayAddress lists for given record IDs.
-func (db *inMemoryTripDBWrapper) DataLoaderGetRecordShouldPayList(_ context.Context, recordIds []uuid.UUID) (map[uuid.UUID][]dbt.ExtendAddress, error) {
+func (db *inMemoryTripDBWrapper) DataLoaderGetRecordShouldPayList(ctx context.Context, recordIds []uuid.UUID) (map[uuid.UUID][]dbt.ExtendAddress, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -390,14 +858,17 @@ func (db *inMemoryTripDBWrapper) DataLoaderGetRecordShouldPayList(_ context.Cont
 		}
 		if !found {
 			result[recordID] = []dbt.ExtendAddress{}
-			errors[recordID] = fmt.Errorf("record with ID %s not found", recordID)
+			errors[recordID] = fmt.Errorf("record with ID %s %w", recordID, dbt.ErrNotFound)
 		}
 	}
-	return result, dataloadgen.MappedFetchError[uuid.UUID](errors)
+	return result, fetchError(errors)
 }
 
 // DataLoaderGetTripInfoList retrieves a map of TripInfo pointers for given trip IDs.
-func (db *inMemoryTripDBWrapper) DataLoaderGetTripInfoList(_ context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*dbt.TripInfo, error) {
+func (db *inMemoryTripDBWrapper) DataLoaderGetTripInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*dbt.TripInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -413,9 +884,9 @@ func (db *inMemoryTripDBWrapper) DataLoaderGetTripInfoList(_ context.Context, tr
 		} else {
 			// If a trip ID is not found, typically nil is returned for that specific key.
 			result[tripID] = nil
-			errors[tripID] = fmt.Errorf("trip with ID %s not found", tripID)
+			errors[tripID] = fmt.Errorf("trip with ID %s %w", tripID, dbt.ErrNotFound)
 		}
 	}
 
-	return result, dataloadgen.MappedFetchError[uuid.UUID](errors)
+	return result, fetchError(errors)
 }
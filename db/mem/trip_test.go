@@ -2,6 +2,7 @@ package mem
 
 import (
 	"context"
+	"slices"
 	"sort"
 	"testing"
 	"time"
@@ -10,8 +11,11 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vikstrous/dataloadgen"
 
 	dbt "dtm/db/db"
+	"dtm/db/dbtest"
 )
 
 // Helper function to create a new TripInfo
@@ -22,6 +26,16 @@ func newTripInfo(name string) *dbt.TripInfo {
 	}
 }
 
+// Helper function to add the should pay addresses of the records to the trip's address list,
+// so the records can be created, listed addresses are skipped
+func listShouldPayAddresses(db dbt.TripDBWrapper, tripID uuid.UUID, records ...dbt.Record) {
+	for _, record := range records {
+		for _, addr := range record.ShouldPayAddress {
+			_ = db.TripAddressListAdd(context.Background(), tripID, addr.Address)
+		}
+	}
+}
+
 // Helper function to create a new Record
 func newRecord(name string, amount float64, prePayAddress dbt.Address, shouldPayAddresses []dbt.ExtendAddress) dbt.Record {
 	return dbt.Record{
@@ -44,31 +58,31 @@ func TestCreateTrip(t *testing.T) {
 
 	t.Run("Successfully create a trip", func(t *testing.T) {
 		info := newTripInfo("Trip Alpha")
-		err := db.CreateTrip(info)
+		err := db.CreateTrip(t.Context(), info)
 		assert.NoError(t, err)
 
-		retrievedInfo, err := db.GetTripInfo(info.ID)
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info.ID)
 		assert.NoError(t, err)
 		assert.NotNil(t, retrievedInfo)
 		assert.Equal(t, info.ID, retrievedInfo.ID)
 		assert.Equal(t, info.Name, retrievedInfo.Name)
 
 		// Ensure TripData is initialized
-		tripData, err := db.GetTripRecords(info.ID) // GetTripRecords indirectly checks TripData's records slice
+		tripData, err := db.GetTripRecords(t.Context(), info.ID) // GetTripRecords indirectly checks TripData's records slice
 		assert.NoError(t, err)
 		assert.Empty(t, tripData)
 
-		addressList, err := db.GetTripAddressList(info.ID)
+		addressList, err := db.GetTripAddressList(t.Context(), info.ID)
 		assert.NoError(t, err)
 		assert.Empty(t, addressList)
 	})
 
 	t.Run("Fail to create a trip with existing ID", func(t *testing.T) {
 		info := newTripInfo("Trip Beta")
-		err := db.CreateTrip(info)
+		err := db.CreateTrip(t.Context(), info)
 		assert.NoError(t, err)
 
-		err = db.CreateTrip(info) // Try to create again with the same ID
+		err = db.CreateTrip(t.Context(), info) // Try to create again with the same ID
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "already exists")
 	})
@@ -77,7 +91,7 @@ func TestCreateTrip(t *testing.T) {
 func TestCreateTripRecords(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Gamma")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	t.Run("Successfully add records to a trip", func(t *testing.T) {
 		records := []dbt.Record{
@@ -89,10 +103,11 @@ func TestCreateTripRecords(t *testing.T) {
 				{Address: "Address Z", ExtendMsg: 30.0},
 			}),
 		}
-		err := db.CreateTripRecords(tripInfo.ID, records)
+		listShouldPayAddresses(db, tripInfo.ID, records...)
+		err := db.CreateTripRecords(t.Context(), tripInfo.ID, records)
 		assert.NoError(t, err)
 
-		retrievedRecords, err := db.GetTripRecords(tripInfo.ID)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, retrievedRecords, 2)
 
@@ -109,10 +124,11 @@ func TestCreateTripRecords(t *testing.T) {
 				{Address: "Address W", ExtendMsg: 15.0},
 			}),
 		}
-		err = db.CreateTripRecords(tripInfo.ID, moreRecords)
+		listShouldPayAddresses(db, tripInfo.ID, moreRecords...)
+		err = db.CreateTripRecords(t.Context(), tripInfo.ID, moreRecords)
 		assert.NoError(t, err)
 
-		retrievedRecords, err = db.GetTripRecords(tripInfo.ID)
+		retrievedRecords, err = db.GetTripRecords(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, retrievedRecords, 3)
 		assert.Contains(t, retrievedRecords, moreRecords[0].RecordInfo)
@@ -122,22 +138,163 @@ func TestCreateTripRecords(t *testing.T) {
 	t.Run("Fail to add records to non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
 		records := []dbt.Record{newRecord("Record 4", 20.0, "Address D", nil)}
-		err := db.CreateTripRecords(nonExistentID, records)
+		err := db.CreateTripRecords(t.Context(), nonExistentID, records)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "trip with ID")
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestCreateTripRecords_ExternalID(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Retry")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+
+	first := newRecord("Taxi", 30.0, "Address A", []dbt.ExtendAddress{{Address: "Address B"}})
+	first.ExternalID = "client-1"
+	listShouldPayAddresses(db, tripInfo.ID, first)
+	require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{first}))
+
+	// the retry carries a new ID, as the client does not know the created one
+	retry := newRecord("Taxi", 30.0, "Address A", []dbt.ExtendAddress{{Address: "Address B"}})
+	retry.ExternalID = "client-1"
+	other := newRecord("Lunch", 12.0, "Address B", nil)
+	other.ExternalID = "client-2"
+	batch := []dbt.Record{retry, other}
+	listShouldPayAddresses(db, tripInfo.ID, batch...)
+	require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, batch))
+	assert.Equal(t, first.ID, batch[0].ID, "retry returns the existing record")
+	assert.Equal(t, first.ShouldPayAddress, batch[0].ShouldPayAddress)
+	assert.Equal(t, other.ID, batch[1].ID)
+
+	records, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []dbt.RecordInfo{first.RecordInfo, other.RecordInfo}, records)
+
+	t.Run("Same external ID in another trip is a new record", func(t *testing.T) {
+		otherTrip := newTripInfo("Trip Other")
+		require.NoError(t, db.CreateTrip(t.Context(), otherTrip))
+		record := newRecord("Taxi", 30.0, "Address A", nil)
+		record.ExternalID = "client-1"
+		require.NoError(t, db.CreateTripRecords(t.Context(), otherTrip.ID, []dbt.Record{record}))
+
+		records, err := db.GetTripRecords(t.Context(), otherTrip.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{record.RecordInfo}, records)
+	})
+}
+
+func TestCreateTripRecords_UnlistedAddress(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Unlisted")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+	require.NoError(t, db.TripAddressListAdd(t.Context(), tripInfo.ID, "Alice"))
+
+	listed := newRecord("Lunch", 20.0, "Alice", []dbt.ExtendAddress{{Address: "Alice"}})
+	unlisted := newRecord("Taxi", 30.0, "Alice", []dbt.ExtendAddress{{Address: "Alice"}, {Address: "Zed"}})
+	err := db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{listed, unlisted})
+	assert.ErrorIs(t, err, dbt.ErrAddressNotInTrip)
+	assert.EqualError(t, err, `address "Zed" not in trip `+tripInfo.ID.String()+` address list`)
+
+	records, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+
+	t.Run("Updates are rejected as well", func(t *testing.T) {
+		require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{listed}))
+		changed := listed
+		changed.ShouldPayAddress = []dbt.ExtendAddress{{Address: "Zed"}}
+		cl, err := diff.GetCustomDiffer().Diff(listed, changed)
+		require.NoError(t, err)
+		_, err = db.UpdateTripRecord(t.Context(), listed.ID, cl)
+		assert.ErrorIs(t, err, dbt.ErrAddressNotInTrip)
+
+		_, err = db.UpdateTripRecords(t.Context(), []dbt.Record{changed})
+		assert.ErrorIs(t, err, dbt.ErrAddressNotInTrip)
+
+		_, err = db.PatchTripRecord(t.Context(), listed.ID, dbt.RecordPatch{ShouldPayAddress: &changed.ShouldPayAddress})
+		assert.ErrorIs(t, err, dbt.ErrAddressNotInTrip)
+
+		shouldPay, err := db.GetRecordAddressList(t.Context(), listed.ID)
+		require.NoError(t, err)
+		assert.Equal(t, listed.ShouldPayAddress, shouldPay)
+	})
+}
+
+func TestCreateTripRecords_TooPrecise(t *testing.T) {
+	db := NewInMemoryTripDBWrapperWithConfig(dbt.WriteConfig{MaxRecordDecimals: 2})
+	tripInfo := newTripInfo("Trip Precise")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+	require.NoError(t, db.TripAddressListAdd(t.Context(), tripInfo.ID, "Alice"))
+
+	compliant := newRecord("Lunch", 20.25, "Alice", []dbt.ExtendAddress{{Address: "Alice", ExtendMsg: 1.5}})
+	tooPrecise := newRecord("Taxi", 30.125, "Alice", []dbt.ExtendAddress{{Address: "Alice"}})
+	err := db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{compliant, tooPrecise})
+	assert.ErrorIs(t, err, dbt.ErrTooPrecise)
+	assert.EqualError(t, err, `amount of record "Taxi": 30.125 has too many decimal places (3 > 2)`)
+	records, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+
+	require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{compliant}))
+
+	t.Run("Updates are rejected as well", func(t *testing.T) {
+		changed := compliant
+		changed.ShouldPayAddress = []dbt.ExtendAddress{{Address: "Alice", ExtendMsg: 0.333}}
+		cl, err := diff.GetCustomDiffer().Diff(compliant, changed)
+		require.NoError(t, err)
+		_, err = db.UpdateTripRecord(t.Context(), compliant.ID, cl)
+		assert.EqualError(t, err, `split value of "Alice" in record "Lunch": 0.333 has too many decimal places (3 > 2)`)
+
+		_, err = db.UpdateTripRecords(t.Context(), []dbt.Record{changed})
+		assert.ErrorIs(t, err, dbt.ErrTooPrecise)
+
+		amount := 10.001
+		_, err = db.PatchTripRecord(t.Context(), compliant.ID, dbt.RecordPatch{Amount: &amount})
+		assert.ErrorIs(t, err, dbt.ErrTooPrecise)
+
+		record, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		require.Len(t, record, 1)
+		assert.Equal(t, 20.25, record[0].Amount)
+	})
+
+	t.Run("No limit accepts any precision", func(t *testing.T) {
+		db := NewInMemoryTripDBWrapperWithConfig(dbt.WriteConfig{MaxRecordDecimals: dbt.NoDecimalLimit})
+		require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+		require.NoError(t, db.TripAddressListAdd(t.Context(), tripInfo.ID, "Alice"))
+		require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{tooPrecise}))
 	})
 }
 
+func TestCancelledContext(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Cancelled")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := db.GetTripInfo(ctx, tripInfo.ID)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, db.CreateTrip(ctx, newTripInfo("Trip Never")), context.Canceled)
+	_, err = db.DataLoaderGetTripInfoList(ctx, []uuid.UUID{tripInfo.ID})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, db.UpdateTripInfo(ctx, &dbt.TripInfo{ID: tripInfo.ID, Name: "Renamed"}), context.Canceled)
+
+	info, err := db.GetTripInfo(t.Context(), tripInfo.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Trip Cancelled", info.Name, "cancelled update is not written")
+}
+
 func TestGetTripInfo(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	info1 := newTripInfo("Trip Delta")
 	info2 := newTripInfo("Trip Epsilon")
-	_ = db.CreateTrip(info1)
-	_ = db.CreateTrip(info2)
+	_ = db.CreateTrip(t.Context(), info1)
+	_ = db.CreateTrip(t.Context(), info2)
 
 	t.Run("Successfully retrieve existing trip info", func(t *testing.T) {
-		retrievedInfo, err := db.GetTripInfo(info1.ID)
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info1.ID)
 		assert.NoError(t, err)
 		assert.NotNil(t, retrievedInfo)
 		assert.Equal(t, info1.ID, retrievedInfo.ID)
@@ -146,17 +303,17 @@ func TestGetTripInfo(t *testing.T) {
 
 	t.Run("Fail to retrieve non-existent trip info", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		retrievedInfo, err := db.GetTripInfo(nonExistentID)
+		retrievedInfo, err := db.GetTripInfo(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Nil(t, retrievedInfo)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 }
 
 func TestGetTripRecords(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Zeta")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	record1 := newRecord("Zeta Record 1", 10.0, "Addr1", []dbt.ExtendAddress{
 		{Address: "Pay1", ExtendMsg: 5.0},
@@ -165,10 +322,11 @@ func TestGetTripRecords(t *testing.T) {
 		{Address: "Pay2", ExtendMsg: 10.0},
 		{Address: "Pay3", ExtendMsg: 15.0},
 	})
-	_ = db.CreateTripRecords(tripInfo.ID, []dbt.Record{record1, record2})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2})
 
 	t.Run("Successfully retrieve trip records", func(t *testing.T) {
-		retrievedRecords, err := db.GetTripRecords(tripInfo.ID)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, retrievedRecords, 2)
 
@@ -185,31 +343,227 @@ func TestGetTripRecords(t *testing.T) {
 
 	t.Run("Retrieve records for trip with no records", func(t *testing.T) {
 		emptyTrip := newTripInfo("Empty Trip")
-		_ = db.CreateTrip(emptyTrip)
-		retrievedRecords, err := db.GetTripRecords(emptyTrip.ID)
+		_ = db.CreateTrip(t.Context(), emptyTrip)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), emptyTrip.ID)
 		assert.NoError(t, err)
 		assert.Empty(t, retrievedRecords)
 	})
 
 	t.Run("Fail to retrieve records for non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		retrievedRecords, err := db.GetTripRecords(nonExistentID)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Nil(t, retrievedRecords)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestGetTripRecordsByGroup(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Group")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+
+	dayOne := uuid.New()
+	dayTwo := uuid.New()
+	record1 := newRecord("Day One Lunch", 30.0, "Addr1", []dbt.ExtendAddress{{Address: "Addr2"}})
+	record1.GroupID, record1.GroupName = dayOne, "day one"
+	record2 := newRecord("Day Two Hotel", 90.0, "Addr2", []dbt.ExtendAddress{{Address: "Addr1"}})
+	record2.GroupID, record2.GroupName = dayTwo, "day two"
+	record3 := newRecord("No Group Taxi", 10.0, "Addr1", []dbt.ExtendAddress{{Address: "Addr2"}})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2, record3)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2, record3})
+
+	t.Run("Retrieve records of each group", func(t *testing.T) {
+		records, err := db.GetTripRecordsByGroup(t.Context(), tripInfo.ID, dayOne)
+		assert.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{record1.RecordInfo}, records)
+
+		records, err = db.GetTripRecordsByGroup(t.Context(), tripInfo.ID, dayTwo)
+		assert.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{record2.RecordInfo}, records)
+	})
+
+	t.Run("Nil group retrieves records without group", func(t *testing.T) {
+		records, err := db.GetTripRecordsByGroup(t.Context(), tripInfo.ID, uuid.Nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{record3.RecordInfo}, records)
+	})
+
+	t.Run("Unknown group retrieves nothing", func(t *testing.T) {
+		records, err := db.GetTripRecordsByGroup(t.Context(), tripInfo.ID, uuid.New())
+		assert.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("Fail to retrieve records for non-existent trip", func(t *testing.T) {
+		records, err := db.GetTripRecordsByGroup(t.Context(), uuid.New(), dayOne)
+		assert.Error(t, err)
+		assert.Nil(t, records)
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestGetTripRecordsQuery(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Query")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+
+	newFixRecord := func(name string, amount float64, prePay dbt.Address) dbt.Record {
+		record := newRecord(name, amount, prePay, []dbt.ExtendAddress{{Address: "Addr1", ExtendMsg: amount}})
+		record.Category = dbt.CategoryFix
+		return record
+	}
+	hotel := newFixRecord("Hotel", 300.0, "Addr1")
+	taxi := newFixRecord("Taxi", 20.0, "Addr2")
+	museum := newFixRecord("Museum", 45.0, "Addr1")
+	lunch := newRecord("Lunch", 500.0, "Addr2", []dbt.ExtendAddress{{Address: "Addr1"}})
+	listShouldPayAddresses(db, tripInfo.ID, hotel, taxi, lunch, museum)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{hotel, taxi, lunch, museum})
+
+	fix := dbt.CategoryFix
+	t.Run("Sort by amount desc with category filter and pagination", func(t *testing.T) {
+		opts := dbt.RecordQuery{Limit: 2, SortBy: dbt.RecordSortByAmount, Desc: true, Category: &fix}
+		page, total, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []dbt.RecordInfo{hotel.RecordInfo, museum.RecordInfo}, page)
+
+		opts.Offset = 2
+		page, total, err = db.GetTripRecordsQuery(t.Context(), tripInfo.ID, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []dbt.RecordInfo{taxi.RecordInfo}, page)
+
+		opts.Offset = 5
+		page, total, err = db.GetTripRecordsQuery(t.Context(), tripInfo.ID, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+		assert.Empty(t, page)
+	})
+
+	t.Run("Sort by name with payer filter", func(t *testing.T) {
+		payer := dbt.Address("Addr2")
+		page, total, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, dbt.RecordQuery{SortBy: dbt.RecordSortByName, PrePayAddress: &payer})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Equal(t, []dbt.RecordInfo{lunch.RecordInfo, taxi.RecordInfo}, page)
+	})
+
+	t.Run("Fail for invalid pagination", func(t *testing.T) {
+		page, _, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, dbt.RecordQuery{Offset: -1})
+		assert.Error(t, err)
+		assert.Nil(t, page)
+	})
+
+	t.Run("Fail for non-existent trip", func(t *testing.T) {
+		page, _, err := db.GetTripRecordsQuery(t.Context(), uuid.New(), dbt.RecordQuery{})
+		assert.Error(t, err)
+		assert.Nil(t, page)
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestGetTripRecordsQuery_TimeRange(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Time Range")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+
+	day := func(d int) time.Time { return time.Date(2025, 8, d, 12, 0, 0, 0, time.UTC) }
+	newDayRecord := func(name string, d int, category dbt.RecordCategory) dbt.Record {
+		record := newRecord(name, 10.0, "Addr1", []dbt.ExtendAddress{{Address: "Addr2"}})
+		record.Time = day(d)
+		record.Category = category
+		return record
+	}
+	hotel := newDayRecord("Hotel", 3, dbt.CategoryFix)
+	breakfast := newDayRecord("Breakfast", 1, dbt.CategoryNormal)
+	dinner := newDayRecord("Dinner", 2, dbt.CategoryNormal)
+	ferry := newDayRecord("Ferry", 4, dbt.CategoryFix)
+	listShouldPayAddresses(db, tripInfo.ID, hotel, breakfast, dinner, ferry)
+	require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{hotel, breakfast, dinner, ferry}))
+
+	fix := dbt.CategoryFix
+	from, to := day(2), day(4)
+	tests := []struct {
+		name     string
+		query    dbt.RecordQuery
+		expected []dbt.RecordInfo
+	}{
+		{"No constraint", dbt.RecordQuery{}, []dbt.RecordInfo{breakfast.RecordInfo, dinner.RecordInfo, hotel.RecordInfo, ferry.RecordInfo}},
+		{"From is inclusive", dbt.RecordQuery{FromTime: &from}, []dbt.RecordInfo{dinner.RecordInfo, hotel.RecordInfo, ferry.RecordInfo}},
+		{"To is exclusive", dbt.RecordQuery{ToTime: &to}, []dbt.RecordInfo{breakfast.RecordInfo, dinner.RecordInfo, hotel.RecordInfo}},
+		{"Range", dbt.RecordQuery{FromTime: &from, ToTime: &to}, []dbt.RecordInfo{dinner.RecordInfo, hotel.RecordInfo}},
+		{"Category", dbt.RecordQuery{Category: &fix}, []dbt.RecordInfo{hotel.RecordInfo, ferry.RecordInfo}},
+		{"Range and category", dbt.RecordQuery{FromTime: &from, ToTime: &to, Category: &fix}, []dbt.RecordInfo{hotel.RecordInfo}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, _, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, records)
+		})
+	}
+
+	t.Run("Filtered by time range and category", func(t *testing.T) {
+		records, err := db.GetTripRecordsFiltered(t.Context(), tripInfo.ID, dbt.RecordFilter{FromTime: &from, ToTime: &to, Category: &fix})
+		require.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{hotel.RecordInfo}, records)
+		records, err = db.GetTripRecordsFiltered(t.Context(), tripInfo.ID, dbt.RecordFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, []dbt.RecordInfo{breakfast.RecordInfo, dinner.RecordInfo, hotel.RecordInfo, ferry.RecordInfo}, records)
+	})
+
+	t.Run("Empty range matches nothing", func(t *testing.T) {
+		records, _, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, dbt.RecordQuery{FromTime: &from, ToTime: &from})
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("Fail for reversed range", func(t *testing.T) {
+		_, _, err := db.GetTripRecordsQuery(t.Context(), tripInfo.ID, dbt.RecordQuery{FromTime: &to, ToTime: &from})
+		assert.Error(t, err)
+	})
+
+	t.Run("Fail for non-existent trip", func(t *testing.T) {
+		_, _, err := db.GetTripRecordsQuery(t.Context(), uuid.New(), dbt.RecordQuery{})
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestGetDuplicateRecords(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Duplicate")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+
+	record1 := newRecord("Dinner", 60.0, "Addr1", []dbt.ExtendAddress{{Address: "Addr2"}})
+	record2 := newRecord("Dinner", 60.0, "Addr1", []dbt.ExtendAddress{{Address: "Addr2"}})
+	record3 := newRecord("Dinner", 60.0, "Addr2", []dbt.ExtendAddress{{Address: "Addr1"}})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2, record3)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2, record3})
+
+	t.Run("Identical records are reported as a group", func(t *testing.T) {
+		groups, err := db.GetDuplicateRecords(t.Context(), tripInfo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]uuid.UUID{{record1.ID, record2.ID}}, groups)
+	})
+
+	t.Run("Fail for non-existent trip", func(t *testing.T) {
+		groups, err := db.GetDuplicateRecords(t.Context(), uuid.New())
+		assert.Error(t, err)
+		assert.Nil(t, groups)
 	})
 }
 
 func TestGetTripAddressList(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Eta")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
-	_ = db.TripAddressListAdd(tripInfo.ID, "Addr A")
-	_ = db.TripAddressListAdd(tripInfo.ID, "Addr B")
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Addr A")
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Addr B")
 
 	t.Run("Successfully retrieve trip address list", func(t *testing.T) {
-		addressList, err := db.GetTripAddressList(tripInfo.ID)
+		addressList, err := db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, addressList, 2)
 		assert.Contains(t, addressList, dbt.Address("Addr A"))
@@ -218,25 +572,51 @@ func TestGetTripAddressList(t *testing.T) {
 
 	t.Run("Retrieve address list for trip with no addresses", func(t *testing.T) {
 		emptyTrip := newTripInfo("Empty Address Trip")
-		_ = db.CreateTrip(emptyTrip)
-		addressList, err := db.GetTripAddressList(emptyTrip.ID)
+		_ = db.CreateTrip(t.Context(), emptyTrip)
+		addressList, err := db.GetTripAddressList(t.Context(), emptyTrip.ID)
 		assert.NoError(t, err)
 		assert.Empty(t, addressList)
 	})
 
 	t.Run("Fail to retrieve address list for non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		addressList, err := db.GetTripAddressList(nonExistentID)
+		addressList, err := db.GetTripAddressList(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Nil(t, addressList)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestGetTripsForAddress(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	beach := newTripInfo("Beach")
+	alps := newTripInfo("Alps")
+	city := newTripInfo("City")
+	for _, trip := range []*dbt.TripInfo{beach, alps, city} {
+		require.NoError(t, db.CreateTrip(t.Context(), trip))
+	}
+	require.NoError(t, db.TripAddressListAdd(t.Context(), beach.ID, "Alice"))
+	require.NoError(t, db.TripAddressListAdd(t.Context(), beach.ID, "Bob"))
+	require.NoError(t, db.TripAddressListAdd(t.Context(), alps.ID, "Alice"))
+	require.NoError(t, db.TripAddressListAdd(t.Context(), city.ID, "Bob"))
+
+	t.Run("Address in two trips", func(t *testing.T) {
+		trips, err := db.GetTripsForAddress(t.Context(), "Alice")
+		require.NoError(t, err)
+		assert.Equal(t, []dbt.TripInfo{*alps, *beach}, trips)
+	})
+
+	t.Run("Address in no trip", func(t *testing.T) {
+		trips, err := db.GetTripsForAddress(t.Context(), "Zed")
+		require.NoError(t, err)
+		assert.Empty(t, trips)
 	})
 }
 
 func TestGetRecordAddressList(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Theta")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	record1 := newRecord("Rec Theta 1", 10.0, "PrePay1", []dbt.ExtendAddress{
 		{Address: "ShouldPay1", ExtendMsg: 5.0},
@@ -245,16 +625,17 @@ func TestGetRecordAddressList(t *testing.T) {
 	record2 := newRecord("Rec Theta 2", 20.0, "PrePay2", []dbt.ExtendAddress{
 		{Address: "ShouldPay3", ExtendMsg: 15.0},
 	})
-	_ = db.CreateTripRecords(tripInfo.ID, []dbt.Record{record1, record2})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2})
 
 	t.Run("Successfully retrieve record's should pay address list", func(t *testing.T) {
-		addressList, err := db.GetRecordAddressList(record1.ID)
+		addressList, err := db.GetRecordAddressList(t.Context(), record1.ID)
 		assert.NoError(t, err)
 		assert.Len(t, addressList, 2)
 		assert.Contains(t, addressList, dbt.ExtendAddress{Address: "ShouldPay1", ExtendMsg: 5.0})
 		assert.Contains(t, addressList, dbt.ExtendAddress{Address: "ShouldPay2", ExtendMsg: 10.0})
 
-		addressList, err = db.GetRecordAddressList(record2.ID)
+		addressList, err = db.GetRecordAddressList(t.Context(), record2.ID)
 		assert.NoError(t, err)
 		assert.Len(t, addressList, 1)
 		assert.Contains(t, addressList, dbt.ExtendAddress{Address: "ShouldPay3", ExtendMsg: 15.0})
@@ -262,56 +643,186 @@ func TestGetRecordAddressList(t *testing.T) {
 
 	t.Run("Retrieve should pay address list for record with no should pay addresses", func(t *testing.T) {
 		recordEmpty := newRecord("Rec Empty", 5.0, "PrePay", nil)
-		_ = db.CreateTripRecords(tripInfo.ID, []dbt.Record{recordEmpty})
-		addressList, err := db.GetRecordAddressList(recordEmpty.ID)
+		_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{recordEmpty})
+		addressList, err := db.GetRecordAddressList(t.Context(), recordEmpty.ID)
 		assert.NoError(t, err)
 		assert.Empty(t, addressList)
 	})
 
 	t.Run("Fail to retrieve record's should pay address list for non-existent record", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		addressList, err := db.GetRecordAddressList(nonExistentID)
+		addressList, err := db.GetRecordAddressList(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Nil(t, addressList)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 }
 
 func TestUpdateTripInfo(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	info := newTripInfo("Original Trip Name")
-	_ = db.CreateTrip(info)
+	_ = db.CreateTrip(t.Context(), info)
 
 	t.Run("Successfully update trip info", func(t *testing.T) {
 		updatedInfo := &dbt.TripInfo{
 			ID:   info.ID,
 			Name: "Updated Trip Name",
 		}
-		err := db.UpdateTripInfo(updatedInfo)
+		err := db.UpdateTripInfo(t.Context(), updatedInfo)
 		assert.NoError(t, err)
 
-		retrievedInfo, err := db.GetTripInfo(info.ID)
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info.ID)
 		assert.NoError(t, err)
 		assert.NotNil(t, retrievedInfo)
 		assert.Equal(t, updatedInfo.Name, retrievedInfo.Name)
 	})
 
+	t.Run("Empty currency keeps the current one", func(t *testing.T) {
+		err := db.UpdateTripInfo(t.Context(), &dbt.TripInfo{ID: info.ID, Name: "Tokyo Trip", Currency: "JPY"})
+		assert.NoError(t, err)
+		err = db.UpdateTripInfo(t.Context(), &dbt.TripInfo{ID: info.ID, Name: "Tokyo Trip 2026"})
+		assert.NoError(t, err)
+
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Tokyo Trip 2026", retrievedInfo.Name)
+		assert.Equal(t, "JPY", retrievedInfo.Currency)
+	})
+
 	t.Run("Fail to update non-existent trip info", func(t *testing.T) {
 		nonExistentID := uuid.New()
 		updatedInfo := &dbt.TripInfo{
 			ID:   nonExistentID,
 			Name: "Non-existent Update",
 		}
-		err := db.UpdateTripInfo(updatedInfo)
+		err := db.UpdateTripInfo(t.Context(), updatedInfo)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "not found for update")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestUpdateTripRecords(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Batch")
+	require.NoError(t, db.CreateTrip(t.Context(), tripInfo))
+	records := []dbt.Record{
+		newRecord("Rec 1", 10.0, "Alice", []dbt.ExtendAddress{{Address: "Alice"}}),
+		newRecord("Rec 2", 20.0, "Alice", []dbt.ExtendAddress{{Address: "Bob"}}),
+		newRecord("Rec 3", 30.0, "Bob", []dbt.ExtendAddress{{Address: "Alice"}}),
+	}
+	listShouldPayAddresses(db, tripInfo.ID, records...)
+	require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, records))
+
+	t.Run("Update three records", func(t *testing.T) {
+		updated := make([]dbt.Record, len(records))
+		for i, record := range records {
+			updated[i] = record
+			updated[i].Amount = record.Amount + 1
+			updated[i].ShouldPayAddress = []dbt.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}
+		}
+		tripIDs, err := db.UpdateTripRecords(t.Context(), updated)
+		require.NoError(t, err)
+		assert.Len(t, tripIDs, 3)
+
+		for _, record := range updated {
+			assert.Equal(t, tripInfo.ID, tripIDs[record.ID])
+			addresses, err := db.GetRecordAddressList(t.Context(), record.ID)
+			require.NoError(t, err)
+			assert.Len(t, addresses, 2)
+		}
+		stored, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []float64{11, 21, 31}, []float64{stored[0].Amount, stored[1].Amount, stored[2].Amount})
+	})
+
+	t.Run("A missing record changes nothing", func(t *testing.T) {
+		before, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+
+		batch := []dbt.Record{records[0], newRecord("Missing", 5.0, "Alice", nil), records[2]}
+		batch[0].Amount, batch[2].Amount = 100, 300
+		tripIDs, err := db.UpdateTripRecords(t.Context(), batch)
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+		assert.Nil(t, tripIDs)
+
+		after, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("Zero values clear the stored fields", func(t *testing.T) {
+		record := newRecord("Grouped", 10.0, "Alice", []dbt.ExtendAddress{{Address: "Bob"}})
+		record.Category = dbt.CategoryFix
+		record.GroupID = uuid.New()
+		record.GroupName = "Day 1"
+		record.SplitOverrides = map[dbt.Address]float64{"Bob": 4}
+		record.ExternalID = "ext-clear"
+		require.NoError(t, db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record}))
+
+		cleared := record
+		cleared.Category = dbt.CategoryNormal
+		cleared.GroupID = uuid.Nil
+		cleared.GroupName = ""
+		cleared.SplitOverrides = nil
+		cleared.ExternalID = ""
+		_, err := db.UpdateTripRecords(t.Context(), []dbt.Record{cleared})
+		require.NoError(t, err)
+
+		stored, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		idx := slices.IndexFunc(stored, func(r dbt.RecordInfo) bool { return r.ID == record.ID })
+		require.NotEqual(t, -1, idx)
+		got := stored[idx]
+		assert.Equal(t, dbt.CategoryNormal, got.Category)
+		assert.Equal(t, uuid.Nil, got.GroupID)
+		assert.Empty(t, got.GroupName)
+		assert.Empty(t, got.SplitOverrides)
+		assert.Empty(t, got.ExternalID)
+	})
+}
+
+func TestUpsertTrip(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	info := newTripInfo("Original Trip Name")
+
+	t.Run("Create a missing trip", func(t *testing.T) {
+		require.NoError(t, db.UpsertTrip(t.Context(), info))
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Original Trip Name", retrievedInfo.Name)
+	})
+
+	t.Run("Second upsert updates and keeps the data", func(t *testing.T) {
+		require.NoError(t, db.TripAddressListAdd(t.Context(), info.ID, "Alice"))
+		record := newRecord("Dinner", 30.0, "Alice", []dbt.ExtendAddress{{Address: "Alice"}})
+		listShouldPayAddresses(db, info.ID, record)
+		require.NoError(t, db.CreateTripRecords(t.Context(), info.ID, []dbt.Record{record}))
+
+		require.NoError(t, db.UpsertTrip(t.Context(), &dbt.TripInfo{ID: info.ID, Name: "Renamed Trip"}))
+
+		retrievedInfo, err := db.GetTripInfo(t.Context(), info.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed Trip", retrievedInfo.Name)
+		records, err := db.GetTripRecords(t.Context(), info.ID)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, record.ID, records[0].ID)
+		addresses, err := db.GetTripAddressList(t.Context(), info.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []dbt.Address{"Alice"}, addresses)
+
+		entries, err := db.GetAuditLog(t.Context(), info.ID)
+		require.NoError(t, err)
+		assert.Equal(t, dbt.AuditCreateTrip, entries[0].Operation)
+		assert.Equal(t, dbt.AuditUpdateTrip, entries[len(entries)-1].Operation)
 	})
 }
 
 func TestUpdateTripRecord(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Iota")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	record1 := newRecord("Rec Iota 1", 10.0, "PrePay1", []dbt.ExtendAddress{
 		{Address: "PayA"},
@@ -319,7 +830,8 @@ func TestUpdateTripRecord(t *testing.T) {
 	record2 := newRecord("Rec Iota 2", 20.0, "PrePay2", []dbt.ExtendAddress{
 		{Address: "PayB"},
 	})
-	_ = db.CreateTripRecords(tripInfo.ID, []dbt.Record{record1, record2})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2})
 
 	t.Run("Successfully update an existing record", func(t *testing.T) {
 		updatedRecordInfo := dbt.RecordInfo{
@@ -336,14 +848,15 @@ func TestUpdateTripRecord(t *testing.T) {
 				ShouldPayAddress: []dbt.ExtendAddress{{Address: "PayU"}},
 			},
 		}
+		require.NoError(t, db.TripAddressListAdd(t.Context(), tripInfo.ID, "PayU"))
 		cl, err := diff.GetCustomDiffer().Diff(record1, updatedRecord)
 		assert.NoError(t, err)
-		tripId, err := db.UpdateTripRecord(record1.ID, cl)
+		tripId, err := db.UpdateTripRecord(t.Context(), record1.ID, cl)
 		assert.NoError(t, err)
 		assert.Equal(t, tripInfo.ID, tripId, "Trip ID should match the original trip")
 
 		// Retrieve records and verify
-		retrievedRecords, err := db.GetTripRecords(tripInfo.ID)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, retrievedRecords, 2)
 
@@ -362,11 +875,33 @@ func TestUpdateTripRecord(t *testing.T) {
 		assert.True(t, found, "Updated record not found in retrieved list")
 
 		// Verify that RecordData (ShouldPayAddress)
-		shouldPayList, err := db.GetRecordAddressList(record1.ID)
+		shouldPayList, err := db.GetRecordAddressList(t.Context(), record1.ID)
 		assert.NoError(t, err)
 		assert.Equal(t, []dbt.ExtendAddress{{Address: "PayU", ExtendMsg: 0}}, shouldPayList) // Should be updated to "PayU"
 	})
 
+	t.Run("Set and clear split overrides", func(t *testing.T) {
+		withOverrides := record2
+		withOverrides.SplitOverrides = map[dbt.Address]float64{"PayB": 2}
+		cl, err := diff.GetCustomDiffer().Diff(record2, withOverrides)
+		require.NoError(t, err)
+		_, err = db.UpdateTripRecord(t.Context(), record2.ID, cl)
+		require.NoError(t, err)
+		records, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		idx := slices.IndexFunc(records, func(r dbt.RecordInfo) bool { return r.ID == record2.ID })
+		require.NotEqual(t, -1, idx)
+		assert.Equal(t, withOverrides.SplitOverrides, records[idx].SplitOverrides)
+
+		cl, err = diff.GetCustomDiffer().Diff(withOverrides, record2)
+		require.NoError(t, err)
+		_, err = db.UpdateTripRecord(t.Context(), record2.ID, cl)
+		require.NoError(t, err)
+		records, err = db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		assert.Empty(t, records[slices.IndexFunc(records, func(r dbt.RecordInfo) bool { return r.ID == record2.ID })].SplitOverrides)
+	})
+
 	t.Run("Fail to update non-existent record", func(t *testing.T) {
 		nonExistentRecordInfo := dbt.RecordInfo{
 			ID:   uuid.New(),
@@ -380,143 +915,259 @@ func TestUpdateTripRecord(t *testing.T) {
 			},
 		})
 		assert.NoError(t, err)
-		tripId, err := db.UpdateTripRecord(nonExistentRecordInfo.ID, cl)
+		tripId, err := db.UpdateTripRecord(t.Context(), nonExistentRecordInfo.ID, cl)
 		assert.Error(t, err)
 		assert.Equal(t, uuid.Nil, tripId, "Trip ID should be nil for non-existent record")
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestPatchTripRecord(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Patch")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+	record := newRecord("Lunch", 30.0, "Alice", []dbt.ExtendAddress{
+		{Address: "Alice", ExtendMsg: 1},
+		{Address: "Bob", ExtendMsg: 2},
+	})
+	listShouldPayAddresses(db, tripInfo.ID, record)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record})
+
+	getRecord := func(t *testing.T) dbt.RecordInfo {
+		t.Helper()
+		records, err := db.GetTripRecords(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		return records[0]
+	}
+
+	t.Run("only the amount", func(t *testing.T) {
+		amount := 45.5
+		tripID, err := db.PatchTripRecord(t.Context(), record.ID, dbt.RecordPatch{Amount: &amount})
+		require.NoError(t, err)
+		assert.Equal(t, tripInfo.ID, tripID)
+
+		got := getRecord(t)
+		assert.Equal(t, 45.5, got.Amount)
+		assert.Equal(t, "Lunch", got.Name)
+		assert.Equal(t, dbt.Address("Alice"), got.PrePayAddress)
+		assert.Equal(t, dbt.CategoryNormal, got.Category)
+		shouldPay, err := db.GetRecordAddressList(t.Context(), record.ID)
+		require.NoError(t, err)
+		assert.Equal(t, record.ShouldPayAddress, shouldPay)
+	})
+
+	t.Run("only the name", func(t *testing.T) {
+		name := "Brunch"
+		_, err := db.PatchTripRecord(t.Context(), record.ID, dbt.RecordPatch{Name: &name})
+		require.NoError(t, err)
+
+		got := getRecord(t)
+		assert.Equal(t, "Brunch", got.Name)
+		assert.Equal(t, 45.5, got.Amount)
+		shouldPay, err := db.GetRecordAddressList(t.Context(), record.ID)
+		require.NoError(t, err)
+		assert.Equal(t, record.ShouldPayAddress, shouldPay)
+	})
+
+	t.Run("should pay list is replaced when set", func(t *testing.T) {
+		shouldPay := []dbt.ExtendAddress{{Address: "Carol"}}
+		require.NoError(t, db.TripAddressListAdd(t.Context(), tripInfo.ID, "Carol"))
+		_, err := db.PatchTripRecord(t.Context(), record.ID, dbt.RecordPatch{ShouldPayAddress: &shouldPay})
+		require.NoError(t, err)
+
+		got, err := db.GetRecordAddressList(t.Context(), record.ID)
+		require.NoError(t, err)
+		assert.Equal(t, shouldPay, got)
+		assert.Equal(t, "Brunch", getRecord(t).Name)
+	})
+
+	t.Run("audited with the patched fields", func(t *testing.T) {
+		entries, err := db.GetAuditLog(t.Context(), tripInfo.ID)
+		require.NoError(t, err)
+		var details []string
+		for _, entry := range entries {
+			if entry.Operation == dbt.AuditUpdateRecord {
+				details = append(details, entry.Detail)
+			}
+		}
+		assert.Equal(t, []string{"RecordInfo.Amount", "RecordInfo.Name", "RecordData.ShouldPayAddress"}, details)
+	})
+
+	t.Run("non-existent record", func(t *testing.T) {
+		amount := 1.0
+		tripID, err := db.PatchTripRecord(t.Context(), uuid.New(), dbt.RecordPatch{Amount: &amount})
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+		assert.Equal(t, uuid.Nil, tripID)
 	})
 }
 
 func TestTripAddressListAdd(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Kappa")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	t.Run("Successfully add address to list", func(t *testing.T) {
-		err := db.TripAddressListAdd(tripInfo.ID, "Address Alpha")
+		err := db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address Alpha")
 		assert.NoError(t, err)
-		list, _ := db.GetTripAddressList(tripInfo.ID)
+		list, _ := db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.Contains(t, list, dbt.Address("Address Alpha"))
 		assert.Len(t, list, 1)
 
-		err = db.TripAddressListAdd(tripInfo.ID, "Address Beta")
+		err = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address Beta")
 		assert.NoError(t, err)
-		list, _ = db.GetTripAddressList(tripInfo.ID)
+		list, _ = db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.Contains(t, list, dbt.Address("Address Beta"))
 		assert.Len(t, list, 2)
 	})
 
 	t.Run("Fail to add existing address", func(t *testing.T) {
-		err := db.TripAddressListAdd(tripInfo.ID, "Address Alpha") // Try to add again
+		err := db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address Alpha") // Try to add again
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "already exists")
-		list, _ := db.GetTripAddressList(tripInfo.ID)
+		list, _ := db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.Len(t, list, 2) // Should still be 2
 	})
 
 	t.Run("Fail to add address to non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		err := db.TripAddressListAdd(nonExistentID, "Address Gamma")
+		err := db.TripAddressListAdd(t.Context(), nonExistentID, "Address Gamma")
+		assert.Error(t, err)
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+	})
+}
+
+func TestRepairTripAddressList(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	tripInfo := newTripInfo("Trip Repair")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Addr1")
+
+	// the prepay address of the record was never added to the trip's address list
+	record := newRecord("Drifted", 30.0, "Addr2", []dbt.ExtendAddress{{Address: "Addr1"}})
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record})
+
+	t.Run("Missing addresses are added", func(t *testing.T) {
+		added, err := db.RepairTripAddressList(t.Context(), tripInfo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []dbt.Address{"Addr2"}, added)
+
+		addressList, err := db.GetTripAddressList(t.Context(), tripInfo.ID)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []dbt.Address{"Addr1", "Addr2"}, addressList)
+	})
+
+	t.Run("Repair again adds nothing", func(t *testing.T) {
+		added, err := db.RepairTripAddressList(t.Context(), tripInfo.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, added)
+	})
+
+	t.Run("Fail for non-existent trip", func(t *testing.T) {
+		added, err := db.RepairTripAddressList(t.Context(), uuid.New())
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "not found")
+		assert.Nil(t, added)
 	})
 }
 
 func TestTripAddressListRemove(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Lambda")
-	_ = db.CreateTrip(tripInfo)
-	_ = db.TripAddressListAdd(tripInfo.ID, "Address X")
-	_ = db.TripAddressListAdd(tripInfo.ID, "Address Y")
-	_ = db.TripAddressListAdd(tripInfo.ID, "Address Z")
+	_ = db.CreateTrip(t.Context(), tripInfo)
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address X")
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address Y")
+	_ = db.TripAddressListAdd(t.Context(), tripInfo.ID, "Address Z")
 
 	t.Run("Successfully remove address from list", func(t *testing.T) {
-		err := db.TripAddressListRemove(tripInfo.ID, "Address Y")
+		err := db.TripAddressListRemove(t.Context(), tripInfo.ID, "Address Y")
 		assert.NoError(t, err)
-		list, _ := db.GetTripAddressList(tripInfo.ID)
+		list, _ := db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.NotContains(t, list, dbt.Address("Address Y"))
 		assert.Len(t, list, 2)
 
-		err = db.TripAddressListRemove(tripInfo.ID, "Address X")
+		err = db.TripAddressListRemove(t.Context(), tripInfo.ID, "Address X")
 		assert.NoError(t, err)
-		list, _ = db.GetTripAddressList(tripInfo.ID)
+		list, _ = db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.NotContains(t, list, dbt.Address("Address X"))
 		assert.Len(t, list, 1)
 	})
 
 	t.Run("Fail to remove non-existent address", func(t *testing.T) {
-		err := db.TripAddressListRemove(tripInfo.ID, "Address W")
+		err := db.TripAddressListRemove(t.Context(), tripInfo.ID, "Address W")
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "not found")
-		list, _ := db.GetTripAddressList(tripInfo.ID)
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+		list, _ := db.GetTripAddressList(t.Context(), tripInfo.ID)
 		assert.Len(t, list, 1) // Should still be 1 (Address Z)
 	})
 
 	t.Run("Fail to remove address from non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		err := db.TripAddressListRemove(nonExistentID, "Address Z")
+		err := db.TripAddressListRemove(t.Context(), nonExistentID, "Address Z")
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 }
 
 func TestDeleteTrip(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	trip1 := newTripInfo("Trip Mu")
-	_ = db.CreateTrip(trip1)
+	_ = db.CreateTrip(t.Context(), trip1)
 	record1 := newRecord("Rec Mu 1", 10.0, "P1", []dbt.ExtendAddress{{Address: "S1"}})
-	_ = db.CreateTripRecords(trip1.ID, []dbt.Record{record1})
-	_ = db.TripAddressListAdd(trip1.ID, "AddrM1")
+	listShouldPayAddresses(db, trip1.ID, record1)
+	_ = db.CreateTripRecords(t.Context(), trip1.ID, []dbt.Record{record1})
+	_ = db.TripAddressListAdd(t.Context(), trip1.ID, "AddrM1")
 
 	trip2 := newTripInfo("Trip Nu")
-	_ = db.CreateTrip(trip2)
+	_ = db.CreateTrip(t.Context(), trip2)
 
 	t.Run("Successfully delete an existing trip", func(t *testing.T) {
-		err := db.DeleteTrip(trip1.ID)
+		err := db.DeleteTrip(t.Context(), trip1.ID)
 		assert.NoError(t, err)
 
-		_, err = db.GetTripInfo(trip1.ID)
+		_, err = db.GetTripInfo(t.Context(), trip1.ID)
 		assert.Error(t, err) // Should not find trip info
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 
-		_, err = db.GetTripRecords(trip1.ID)
+		_, err = db.GetTripRecords(t.Context(), trip1.ID)
 		assert.Error(t, err) // Should not find trip records
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 
-		_, err = db.GetTripAddressList(trip1.ID)
+		_, err = db.GetTripAddressList(t.Context(), trip1.ID)
 		assert.Error(t, err) // Should not find trip address list
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 
 		// Ensure associated record is also deleted from recordsByID map
-		_, err = db.GetRecordAddressList(record1.ID) // This checks recordsByID map
+		_, err = db.GetRecordAddressList(t.Context(), record1.ID) // This checks recordsByID map
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 
 	t.Run("Fail to delete non-existent trip", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		err := db.DeleteTrip(nonExistentID)
+		err := db.DeleteTrip(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "not found for deletion")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 }
 
 func TestDeleteTripRecord(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	tripInfo := newTripInfo("Trip Xi")
-	_ = db.CreateTrip(tripInfo)
+	_ = db.CreateTrip(t.Context(), tripInfo)
 
 	record1 := newRecord("Rec Xi 1", 10.0, "P1", []dbt.ExtendAddress{{Address: "S1"}})
 	record2 := newRecord("Rec Xi 2", 20.0, "P2", []dbt.ExtendAddress{{Address: "S2"}})
 	record3 := newRecord("Rec Xi 3", 30.0, "P3", []dbt.ExtendAddress{{Address: "S3"}})
-	_ = db.CreateTripRecords(tripInfo.ID, []dbt.Record{record1, record2, record3})
+	listShouldPayAddresses(db, tripInfo.ID, record1, record2, record3)
+	_ = db.CreateTripRecords(t.Context(), tripInfo.ID, []dbt.Record{record1, record2, record3})
 
 	t.Run("Successfully delete an existing record", func(t *testing.T) {
-		tripId, err := db.DeleteTripRecord(record2.ID)
+		tripId, err := db.DeleteTripRecord(t.Context(), record2.ID)
 		assert.NoError(t, err)
 		assert.Equal(t, tripInfo.ID, tripId, "Trip ID should match the original trip")
 
-		retrievedRecords, err := db.GetTripRecords(tripInfo.ID)
+		retrievedRecords, err := db.GetTripRecords(t.Context(), tripInfo.ID)
 		assert.NoError(t, err)
 		assert.Len(t, retrievedRecords, 2) // record2 should be gone
 		assert.Contains(t, retrievedRecords, record1.RecordInfo)
@@ -524,17 +1175,17 @@ func TestDeleteTripRecord(t *testing.T) {
 		assert.NotContains(t, retrievedRecords, record2.RecordInfo)
 
 		// Ensure record is removed from recordsByID map
-		_, err = db.GetRecordAddressList(record2.ID)
+		_, err = db.GetRecordAddressList(t.Context(), record2.ID)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 
 	t.Run("Fail to delete non-existent record", func(t *testing.T) {
 		nonExistentID := uuid.New()
-		tripId, err := db.DeleteTripRecord(nonExistentID)
+		tripId, err := db.DeleteTripRecord(t.Context(), nonExistentID)
 		assert.Error(t, err)
 		assert.Empty(t, tripId)
-		assert.Contains(t, err.Error(), "not found")
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
 	})
 }
 
@@ -543,20 +1194,22 @@ func TestDataLoaderGetRecordInfoList(t *testing.T) {
 	ctx := context.Background()
 
 	trip1 := newTripInfo("Trip Omicron")
-	_ = db.CreateTrip(trip1)
+	_ = db.CreateTrip(t.Context(), trip1)
 	rec1 := newRecord("Rec Omi 1", 1.0, "P1", nil)
 	rec2 := newRecord("Rec Omi 2", 2.0, "P2", nil)
-	_ = db.CreateTripRecords(trip1.ID, []dbt.Record{rec1, rec2})
+	listShouldPayAddresses(db, trip1.ID, rec1, rec2)
+	_ = db.CreateTripRecords(t.Context(), trip1.ID, []dbt.Record{rec1, rec2})
 
 	trip2 := newTripInfo("Trip Pi")
-	_ = db.CreateTrip(trip2)
+	_ = db.CreateTrip(t.Context(), trip2)
 	rec3 := newRecord("Rec Pi 1", 3.0, "P3", nil)
-	_ = db.CreateTripRecords(trip2.ID, []dbt.Record{rec3})
+	listShouldPayAddresses(db, trip2.ID, rec3)
+	_ = db.CreateTripRecords(t.Context(), trip2.ID, []dbt.Record{rec3})
 
 	t.Run("Successfully load existing record infos", func(t *testing.T) {
 		keys := []uuid.UUID{trip1.ID, trip2.ID}
-		result, _ := db.DataLoaderGetRecordInfoList(ctx, keys)
-		// assert.NoError(t, err)
+		result, err := db.DataLoaderGetRecordInfoList(ctx, keys)
+		assert.NoError(t, err)
 		assert.Len(t, result, 2)
 
 		assert.Contains(t, result, trip1.ID)
@@ -581,6 +1234,7 @@ func TestDataLoaderGetRecordInfoList(t *testing.T) {
 		assert.Contains(t, result, nonExistentID)
 		assert.Equal(t, result[nonExistentID], []dbt.RecordInfo{}) // Missing key should have nil value
 		assert.Contains(t, err.Error(), nonExistentID.String()+" not found")
+		assert.ErrorIs(t, err.(dataloadgen.MappedFetchError[uuid.UUID])[nonExistentID], dbt.ErrNotFound)
 	})
 }
 
@@ -589,17 +1243,18 @@ func TestDataLoaderGetTripAddressList(t *testing.T) {
 	ctx := context.Background()
 
 	trip1 := newTripInfo("Trip Rho")
-	_ = db.CreateTrip(trip1)
-	_ = db.TripAddressListAdd(trip1.ID, "A1")
-	_ = db.TripAddressListAdd(trip1.ID, "A2")
+	_ = db.CreateTrip(t.Context(), trip1)
+	_ = db.TripAddressListAdd(t.Context(), trip1.ID, "A1")
+	_ = db.TripAddressListAdd(t.Context(), trip1.ID, "A2")
 
 	trip2 := newTripInfo("Trip Sigma")
-	_ = db.CreateTrip(trip2)
+	_ = db.CreateTrip(t.Context(), trip2)
 	// No addresses for trip2
 
 	t.Run("Successfully load existing trip address lists", func(t *testing.T) {
 		keys := []uuid.UUID{trip1.ID, trip2.ID}
-		result, _ := db.DataLoaderGetTripAddressList(ctx, keys)
+		result, err := db.DataLoaderGetTripAddressList(ctx, keys)
+		assert.NoError(t, err)
 		assert.Len(t, result, 2)
 
 		assert.Contains(t, result, trip1.ID)
@@ -622,6 +1277,7 @@ func TestDataLoaderGetTripAddressList(t *testing.T) {
 		assert.Contains(t, result, nonExistentID)
 		assert.Equal(t, result[nonExistentID], []dbt.Address{}) // Missing key should have empty slice
 		assert.Contains(t, err.Error(), nonExistentID.String()+" not found")
+		assert.ErrorIs(t, err.(dataloadgen.MappedFetchError[uuid.UUID])[nonExistentID], dbt.ErrNotFound)
 	})
 }
 
@@ -630,7 +1286,7 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 	ctx := context.Background()
 
 	trip1 := newTripInfo("Trip Tau")
-	_ = db.CreateTrip(trip1)
+	_ = db.CreateTrip(t.Context(), trip1)
 	rec1 := newRecord("Rec Tau 1", 100.0, "P1", []dbt.ExtendAddress{
 		{Address: "SP1", ExtendMsg: 0.5},
 		{Address: "SP2", ExtendMsg: 1.0},
@@ -639,12 +1295,13 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 		{Address: "SP3", ExtendMsg: 2.0},
 	})
 	rec3 := newRecord("Rec Tau 3", 300.0, "P3", nil) // No should pay addresses
-	_ = db.CreateTripRecords(trip1.ID, []dbt.Record{rec1, rec2, rec3})
+	listShouldPayAddresses(db, trip1.ID, rec1, rec2, rec3)
+	_ = db.CreateTripRecords(t.Context(), trip1.ID, []dbt.Record{rec1, rec2, rec3})
 
 	t.Run("Successfully load existing record should pay lists", func(t *testing.T) {
 		keys := []uuid.UUID{rec1.ID, rec2.ID, rec3.ID}
-		result, _ := db.DataLoaderGetRecordShouldPayList(ctx, keys)
-		// assert.NoError(t, err)
+		result, err := db.DataLoaderGetRecordShouldPayList(ctx, keys)
+		assert.NoError(t, err)
 		assert.Len(t, result, 3)
 
 		assert.Contains(t, result, rec1.ID)
@@ -676,22 +1333,50 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 		assert.Contains(t, result, nonExistentID)
 		assert.Equal(t, result[nonExistentID], []dbt.ExtendAddress{}) // Missing key should have empty slice
 		assert.Contains(t, err.Error(), nonExistentID.String()+" not found")
+		assert.ErrorIs(t, err.(dataloadgen.MappedFetchError[uuid.UUID])[nonExistentID], dbt.ErrNotFound)
 	})
 }
 
+func TestGetTripInfos(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	trip1 := newTripInfo("Batch Trip 1")
+	trip2 := newTripInfo("Batch Trip 2")
+	require.NoError(t, db.CreateTrip(t.Context(), trip1))
+	require.NoError(t, db.CreateTrip(t.Context(), trip2))
+
+	missing := uuid.New()
+	result, err := db.GetTripInfos(t.Context(), []uuid.UUID{trip1.ID, missing, trip2.ID})
+	require.NoError(t, err)
+	assert.Len(t, result, 3)
+	assert.Equal(t, trip1.Name, result[trip1.ID].Name)
+	assert.Equal(t, trip2.Name, result[trip2.ID].Name)
+	assert.Contains(t, result, missing)
+	assert.Nil(t, result[missing])
+
+	// the result is a copy
+	result[trip1.ID].Name = "Changed"
+	info, err := db.GetTripInfo(t.Context(), trip1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Batch Trip 1", info.Name)
+
+	empty, err := db.GetTripInfos(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
 func TestDataLoaderGetTripInfoList(t *testing.T) {
 	db := NewInMemoryTripDBWrapper()
 	ctx := context.Background()
 
 	trip1 := newTripInfo("DataLoader Trip 1")
 	trip2 := newTripInfo("DataLoader Trip 2")
-	_ = db.CreateTrip(trip1)
-	_ = db.CreateTrip(trip2)
+	_ = db.CreateTrip(t.Context(), trip1)
+	_ = db.CreateTrip(t.Context(), trip2)
 
 	t.Run("Successfully load existing trip infos", func(t *testing.T) {
 		keys := []uuid.UUID{trip1.ID, trip2.ID}
-		result, _ := db.DataLoaderGetTripInfoList(ctx, keys)
-		// assert.NoError(t, err)
+		result, err := db.DataLoaderGetTripInfoList(ctx, keys)
+		assert.NoError(t, err)
 		assert.Len(t, result, 2)
 
 		assert.Contains(t, result, trip1.ID)
@@ -719,3 +1404,73 @@ func TestDataLoaderGetTripInfoList(t *testing.T) {
 		assert.Contains(t, err.Error(), nonExistentID.String())
 	})
 }
+
+func TestGetAuditLog(t *testing.T) {
+	db := NewInMemoryTripDBWrapper()
+	owner := db.WithActor("key:owner")
+
+	trip := newTripInfo("Audit Trip")
+	require.NoError(t, db.CreateTrip(t.Context(), trip))
+	record := newRecord("Lunch", 30, "Alice", nil)
+	require.NoError(t, owner.CreateTripRecords(t.Context(), trip.ID, []dbt.Record{record}))
+
+	updated := record
+	updated.Name = "Dinner"
+	updated.Amount = 60
+	cl, err := diff.GetCustomDiffer().Diff(record, updated)
+	require.NoError(t, err)
+	_, err = owner.UpdateTripRecord(t.Context(), record.ID, cl)
+	require.NoError(t, err)
+	require.NoError(t, owner.TripAddressListAdd(t.Context(), trip.ID, "Carol"))
+	_, err = owner.DeleteTripRecord(t.Context(), record.ID)
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteTrip(t.Context(), trip.ID))
+
+	entries, err := db.GetAuditLog(t.Context(), trip.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 6, "deleted trip keeps its audit log")
+
+	expected := []struct {
+		op     dbt.AuditOperation
+		target uuid.UUID
+		actor  string
+		detail string
+	}{
+		{dbt.AuditCreateTrip, trip.ID, dbt.AuditActorSystem, "Audit Trip"},
+		{dbt.AuditCreateRecord, record.ID, "key:owner", "Lunch"},
+		{dbt.AuditUpdateRecord, record.ID, "key:owner", "RecordInfo.Amount,RecordInfo.Name"},
+		{dbt.AuditAddAddress, trip.ID, "key:owner", "Carol"},
+		{dbt.AuditDeleteRecord, record.ID, "key:owner", "Dinner"},
+		{dbt.AuditDeleteTrip, trip.ID, dbt.AuditActorSystem, "Audit Trip"},
+	}
+	for i, want := range expected {
+		assert.Equal(t, want.op, entries[i].Operation, "entry %d", i)
+		assert.Equal(t, trip.ID, entries[i].TripID, "entry %d", i)
+		assert.Equal(t, want.target, entries[i].TargetID, "entry %d", i)
+		assert.Equal(t, want.actor, entries[i].Actor, "entry %d", i)
+		assert.Equal(t, want.detail, entries[i].Detail, "entry %d", i)
+		assert.False(t, entries[i].Time.IsZero(), "entry %d", i)
+	}
+
+	t.Run("Failed change is not audited", func(t *testing.T) {
+		_, err := owner.DeleteTripRecord(t.Context(), uuid.New())
+		assert.ErrorIs(t, err, dbt.ErrNotFound)
+		entries, err := db.GetAuditLog(t.Context(), trip.ID)
+		require.NoError(t, err)
+		assert.Len(t, entries, 6)
+	})
+
+	t.Run("Unknown trip has empty log", func(t *testing.T) {
+		entries, err := db.GetAuditLog(t.Context(), uuid.New())
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestNotFound(t *testing.T) {
+	dbtest.RunNotFoundTests(t, NewInMemoryTripDBWrapper())
+}
+
+func TestExternalIDRetry(t *testing.T) {
+	dbtest.RunExternalIDRetryTests(t, NewInMemoryTripDBWrapper())
+}
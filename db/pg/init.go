@@ -39,6 +39,17 @@ func CreateDSN() string {
 	return connStr
 }
 
+// CreateReplicaDSN returns the connection string of the read replica from DATABASE_REPLICA_URL,
+// empty when it is not set so every read goes to the primary.
+func CreateReplicaDSN() string {
+	connStr := os.Getenv("DATABASE_REPLICA_URL")
+	if connStr == "" {
+		return ""
+	}
+	log.Printf("Using DATABASE_REPLICA_URL: *")
+	return connStr + fmt.Sprintf(" search_path=%s", config.AppName)
+}
+
 func CloseGORM(db *gorm.DB) {
 	sqlDB, err := db.DB()
 	if err != nil {
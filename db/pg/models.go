@@ -1,6 +1,8 @@
 package pg
 
 import (
+	"dtm/db/db"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,6 +11,8 @@ import (
 type TripInfoModel struct {
 	ID   uuid.UUID `gorm:"type:uuid;primaryKey"`
 	Name string    `gorm:"size:255;not null"`
+	// Currency is empty for trips using the deployment default
+	Currency string `gorm:"size:3;not null;default:''"`
 	// meta data
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -20,13 +24,19 @@ func (TripInfoModel) TableName() string {
 }
 
 type RecordModel struct {
-	ID            uuid.UUID `gorm:"type:uuid;primaryKey"`
-	TripID        uuid.UUID `gorm:"type:uuid;not null"`
-	Name          string    `gorm:"size:255;not null"`
-	Amount        float64   `gorm:"type:numeric(10,2);not null"`
-	Time          time.Time `gorm:"not null"` // Use time.Time to store the timestamp
-	PrePayAddress string    `gorm:"size:255;not null"`
-	Category      int       `gorm:"not null"` // Use int to store the category
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	TripID        uuid.UUID  `gorm:"type:uuid;not null"`
+	Name          string     `gorm:"size:255;not null"`
+	Amount        float64    `gorm:"type:numeric(10,2);not null"`
+	Time          time.Time  `gorm:"not null"` // Use time.Time to store the timestamp
+	PrePayAddress string     `gorm:"size:255;not null"`
+	Category      int        `gorm:"not null"`  // Use int to store the category
+	GroupID       *uuid.UUID `gorm:"type:uuid"` // nullable, records without group are not in any sub-activity
+	GroupName     string     `gorm:"size:255;not null;default:''"`
+	// SplitOverrides is db.RecordInfo.SplitOverrides encoded as JSON, NULL when not set
+	SplitOverrides []byte `gorm:"type:jsonb"`
+	// ExternalID is NULL when the record has no external ID, unique per trip otherwise
+	ExternalID *string `gorm:"size:255"`
 	// meta data
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -37,6 +47,57 @@ func (RecordModel) TableName() string {
 	return "records"
 }
 
+// toRecordInfo converts the model to the interface record info.
+func (m RecordModel) toRecordInfo() db.RecordInfo {
+	info := db.RecordInfo{
+		ID:            m.ID,
+		Name:          m.Name,
+		Amount:        m.Amount,
+		Time:          m.Time,
+		PrePayAddress: db.Address(m.PrePayAddress),
+		Category:      db.RecordCategory(m.Category),
+		GroupName:     m.GroupName,
+	}
+	if m.ExternalID != nil {
+		info.ExternalID = *m.ExternalID
+	}
+	if m.GroupID != nil {
+		info.GroupID = *m.GroupID
+	}
+	if len(m.SplitOverrides) > 0 {
+		// column is only written by newRecordModel, a broken value is treated as not set
+		_ = json.Unmarshal(m.SplitOverrides, &info.SplitOverrides)
+	}
+	return info
+}
+
+// newRecordModel converts the interface record info to a model which belongs to trip.
+func newRecordModel(tripID uuid.UUID, info db.RecordInfo) RecordModel {
+	model := RecordModel{
+		ID:            info.ID,
+		TripID:        tripID,
+		Name:          info.Name,
+		Amount:        info.Amount,
+		Time:          info.Time,
+		PrePayAddress: string(info.PrePayAddress),
+		Category:      int(info.Category),
+		GroupName:     info.GroupName,
+	}
+	if info.GroupID != uuid.Nil {
+		groupID := info.GroupID
+		model.GroupID = &groupID
+	}
+	if info.ExternalID != "" {
+		externalID := info.ExternalID
+		model.ExternalID = &externalID
+	}
+	if len(info.SplitOverrides) > 0 {
+		// map of finite floats always encodes
+		model.SplitOverrides, _ = json.Marshal(info.SplitOverrides)
+	}
+	return model
+}
+
 type RecordShouldPayAddressListModel struct {
 	RecordID    uuid.UUID `gorm:"type:uuid;primaryKey"`
 	TripID      uuid.UUID `gorm:"type:uuid;primaryKey"`
@@ -63,3 +124,32 @@ type TripAddressListModel struct {
 func (TripAddressListModel) TableName() string {
 	return "trip_address_lists"
 }
+
+type AuditLogModel struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	TripID    uuid.UUID `gorm:"type:uuid;not null"`
+	TargetID  uuid.UUID `gorm:"type:uuid;not null"`
+	Operation string    `gorm:"size:64;not null"`
+	Actor     string    `gorm:"size:255;not null"`
+	Detail    string    `gorm:"not null;default:''"`
+	// meta data
+	CreatedAt time.Time
+}
+
+// TableName returns the table name for AuditLogModel.
+func (AuditLogModel) TableName() string {
+	return "audit_log"
+}
+
+// toAuditEntry converts the model to the interface audit entry.
+func (m AuditLogModel) toAuditEntry() db.AuditEntry {
+	return db.AuditEntry{
+		ID:        m.ID,
+		TripID:    m.TripID,
+		TargetID:  m.TargetID,
+		Operation: db.AuditOperation(m.Operation),
+		Actor:     m.Actor,
+		Detail:    m.Detail,
+		Time:      m.CreatedAt,
+	}
+}
@@ -3,47 +3,141 @@ package pg
 import (
 	"context"
 	"dtm/db/db"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/r3labs/diff/v3"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	cdiff "dtm/libs/diff"
 )
 
 // pgDBWrapper is an implementation of TripDBWrapper using GORM.
 type pgDBWrapper struct {
-	db *gorm.DB
+	db      *gorm.DB
+	replica *gorm.DB // serves the reads outside a transaction, nil reads from db
+	actor   string   // recorded in the audit log of changes made through this wrapper
+	config  db.WriteConfig
+}
+
+// notFound maps gorm's not found error to db.ErrNotFound, the gorm error is kept in the chain.
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: %w", db.ErrNotFound, err)
+	}
+	return err
 }
 
 // NewPgDBWrapper creates a new instance of pgDBWrapper.
-func NewPgDBWrapper(db *gorm.DB) db.TripDBWrapper { // Assuming db.TripDBWrapper is the interface type
-	return &pgDBWrapper{db: db}
+func NewPgDBWrapper(gormDB *gorm.DB) db.TripDBWrapper { // Assuming db.TripDBWrapper is the interface type
+	return NewPgDBWrapperWithConfig(gormDB, nil, db.DefaultWriteConfig())
+}
+
+// NewPgDBWrapperWithReplica works like NewPgDBWrapper, but the reads outside a transaction, e.g. GetTripInfo,
+// GetTripRecords and the DataLoaders, go to replica, a read-only connection to a replica of primary.
+// Writes and the reads they depend on go to primary, a nil replica reads from primary as well.
+func NewPgDBWrapperWithReplica(primary, replica *gorm.DB) db.TripDBWrapper {
+	return NewPgDBWrapperWithConfig(primary, replica, db.DefaultWriteConfig())
+}
+
+// NewPgDBWrapperWithConfig works like NewPgDBWrapperWithReplica, written records are checked by config.
+func NewPgDBWrapperWithConfig(primary, replica *gorm.DB, config db.WriteConfig) db.TripDBWrapper {
+	return &pgDBWrapper{db: primary, replica: replica, actor: db.AuditActorSystem, config: config}
+}
+
+// WithActor returns a wrapper on the same connection whose changes are audited as made by actor.
+func (p *pgDBWrapper) WithActor(actor string) db.TripDBWrapper {
+	return &pgDBWrapper{db: p.db, replica: p.replica, actor: actor, config: p.config}
+}
+
+// reader returns the connection for reads outside a transaction, the replica when one is set.
+func (p *pgDBWrapper) reader() *gorm.DB {
+	if p.replica != nil {
+		return p.replica
+	}
+	return p.db
+}
+
+// audit inserts an audit log entry in tx, so the entry is only kept when the change is committed.
+func (p *pgDBWrapper) audit(tx *gorm.DB, tripID, targetID uuid.UUID, op db.AuditOperation, detail string) error {
+	return tx.Create(&AuditLogModel{
+		ID:        uuid.New(),
+		TripID:    tripID,
+		TargetID:  targetID,
+		Operation: string(op),
+		Actor:     p.actor,
+		Detail:    detail,
+	}).Error
+}
+
+// GetAuditLog returns the audit entries of the trip in time order, a deleted trip keeps its log.
+func (p *pgDBWrapper) GetAuditLog(ctx context.Context, tripID uuid.UUID) ([]db.AuditEntry, error) {
+	var models []AuditLogModel
+	if err := p.reader().WithContext(ctx).Where("trip_id = ?", tripID).Order("created_at, id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	entries := make([]db.AuditEntry, len(models))
+	for i, m := range models {
+		entries[i] = m.toAuditEntry()
+	}
+	return entries, nil
 }
 
-func (p *pgDBWrapper) CreateTrip(info *db.TripInfo) error { // Assuming db.TripInfo is the type from db/types.go
+func (p *pgDBWrapper) CreateTrip(ctx context.Context, info *db.TripInfo) error { // Assuming db.TripInfo is the type from db/types.go
 	tripModel := TripInfoModel{
-		ID:   info.ID,
-		Name: info.Name,
+		ID:       info.ID,
+		Name:     info.Name,
+		Currency: info.Currency,
 	}
-	return p.db.Create(&tripModel).Error
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tripModel).Error; err != nil {
+			return err
+		}
+		return p.audit(tx, info.ID, info.ID, db.AuditCreateTrip, info.Name)
+	})
 }
 
-func (p *pgDBWrapper) CreateTripRecords(id uuid.UUID, records []db.Record) error { // Assuming db.Record
+func (p *pgDBWrapper) CreateTripRecords(ctx context.Context, id uuid.UUID, records []db.Record) error { // Assuming db.Record
 	// This can be done in a transaction for atomicity
-	return p.db.Transaction(func(tx *gorm.DB) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		addressList, err := tripAddressList(tx, id)
+		if err != nil {
+			return err
+		}
+		created, err := createdExternalIDs(tx, id, records)
+		if err != nil {
+			return err
+		}
+		// a retry of a created record is not written, so it is not checked either
 		for _, rec := range records {
-			recordModel := RecordModel{
-				ID:            rec.RecordInfo.ID,
-				TripID:        id, // Link to the trip
-				Name:          rec.RecordInfo.Name,
-				Amount:        rec.RecordInfo.Amount,
-				Time:          rec.RecordInfo.Time,
-				PrePayAddress: string(rec.RecordInfo.PrePayAddress),
-				Category:      int(rec.RecordInfo.Category),
+			if created[rec.ExternalID] {
+				continue
 			}
-			if err := tx.Create(&recordModel).Error; err != nil {
+			if err := p.config.CheckRecord(id, addressList, rec); err != nil {
+				return err
+			}
+		}
+		for i, rec := range records {
+			recordModel := newRecordModel(id, rec.RecordInfo) // Link to the trip
+			// a record with a known external ID is a retry, keep the existing record
+			result := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "trip_id"}, {Name: "external_id"}},
+				DoNothing: true,
+			}).Create(&recordModel)
+			if result.Error != nil {
+				return &db.RecordError{RecordID: rec.ID, Err: result.Error}
+			}
+			if result.RowsAffected == 0 && rec.ExternalID != "" {
+				existing, err := findRecordByExternalID(tx, id, rec.ExternalID)
+				if err != nil {
+					return err
+				}
+				records[i] = existing
+				continue
+			}
+			if err := p.audit(tx, id, rec.ID, db.AuditCreateRecord, rec.Name); err != nil {
 				return err
 			}
 
@@ -56,7 +150,7 @@ func (p *pgDBWrapper) CreateTripRecords(id uuid.UUID, records []db.Record) error
 					ExtendedMsg: addr.ExtendMsg,
 				}
 				if err := tx.Create(&shouldPayModel).Error; err != nil {
-					return err
+					return &db.RecordError{RecordID: rec.ID, Address: addr.Address, Err: err}
 				}
 			}
 		}
@@ -64,40 +158,240 @@ func (p *pgDBWrapper) CreateTripRecords(id uuid.UUID, records []db.Record) error
 	})
 }
 
-func (p *pgDBWrapper) GetTripInfo(id uuid.UUID) (*db.TripInfo, error) {
-	var tripModel TripInfoModel
-	if err := p.db.First(&tripModel, "id = ?", id).Error; err != nil {
+// tripAddressList reads the address list of the trip in tx.
+func tripAddressList(tx *gorm.DB, tripID uuid.UUID) ([]db.Address, error) {
+	var models []TripAddressListModel
+	if err := tx.Where("trip_id = ?", tripID).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	addressList := make([]db.Address, len(models))
+	for i, m := range models {
+		addressList[i] = db.Address(m.Address)
+	}
+	return addressList, nil
+}
+
+// createdExternalIDs returns the external IDs of records which already exist in the trip.
+func createdExternalIDs(tx *gorm.DB, tripID uuid.UUID, records []db.Record) (map[string]bool, error) {
+	var externalIDs []string
+	for _, rec := range records {
+		if rec.ExternalID != "" {
+			externalIDs = append(externalIDs, rec.ExternalID)
+		}
+	}
+	created := make(map[string]bool)
+	if len(externalIDs) == 0 {
+		return created, nil
+	}
+	var existing []string
+	if err := tx.Model(&RecordModel{}).Where("trip_id = ? AND external_id IN ?", tripID, externalIDs).Pluck("external_id", &existing).Error; err != nil {
 		return nil, err
 	}
+	for _, externalID := range existing {
+		created[externalID] = true
+	}
+	return created, nil
+}
+
+// findRecordByExternalID loads the record of the trip with the external ID and its should pay list.
+func findRecordByExternalID(tx *gorm.DB, tripID uuid.UUID, externalID string) (db.Record, error) {
+	var recordModel RecordModel
+	if err := tx.First(&recordModel, "trip_id = ? AND external_id = ?", tripID, externalID).Error; err != nil {
+		return db.Record{}, notFound(err)
+	}
+	var shouldPayModels []RecordShouldPayAddressListModel
+	if err := tx.Where("record_id = ?", recordModel.ID).Find(&shouldPayModels).Error; err != nil {
+		return db.Record{}, err
+	}
+	record := db.Record{RecordInfo: recordModel.toRecordInfo()}
+	for _, d := range shouldPayModels {
+		record.ShouldPayAddress = append(record.ShouldPayAddress, db.ExtendAddress{
+			Address:   db.Address(d.Address),
+			ExtendMsg: d.ExtendedMsg,
+		})
+	}
+	return record, nil
+}
+
+func (p *pgDBWrapper) GetTripInfo(ctx context.Context, id uuid.UUID) (*db.TripInfo, error) {
+	var tripModel TripInfoModel
+	if err := p.reader().WithContext(ctx).First(&tripModel, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
 	return &db.TripInfo{
-		ID:   tripModel.ID,
-		Name: tripModel.Name,
+		ID:       tripModel.ID,
+		Name:     tripModel.Name,
+		Currency: tripModel.Currency,
 	}, nil
 }
 
-func (p *pgDBWrapper) GetTripRecords(id uuid.UUID) ([]db.RecordInfo, error) {
+// GetTripInfos reads the trips of ids with one query, a missing trip is a nil entry.
+func (p *pgDBWrapper) GetTripInfos(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*db.TripInfo, error) {
+	result := make(map[uuid.UUID]*db.TripInfo, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var trips []TripInfoModel
+	if err := p.reader().WithContext(ctx).Where("id IN ?", ids).Find(&trips).Error; err != nil {
+		return nil, err
+	}
+
+	for _, t := range trips {
+		result[t.ID] = &db.TripInfo{
+			ID:       t.ID,
+			Name:     t.Name,
+			Currency: t.Currency,
+		}
+	}
+	// Ensure all requested tripIds have an entry in the map, even if nil
+	for _, tripID := range ids {
+		if _, ok := result[tripID]; !ok {
+			result[tripID] = nil
+		}
+	}
+	return result, nil
+}
+
+func (p *pgDBWrapper) GetTripRecords(ctx context.Context, id uuid.UUID) ([]db.RecordInfo, error) {
 	var recordModels []RecordModel
-	if err := p.db.Where("trip_id = ?", id).Find(&recordModels).Error; err != nil {
+	if err := p.reader().WithContext(ctx).Where("trip_id = ?", id).Find(&recordModels).Error; err != nil {
 		return nil, err
 	}
 
 	var recordInfos []db.RecordInfo
 	for _, rm := range recordModels {
-		recordInfos = append(recordInfos, db.RecordInfo{
-			ID:            rm.ID,
-			Name:          rm.Name,
-			Amount:        rm.Amount,
-			PrePayAddress: db.Address(rm.PrePayAddress),
-			Time:          rm.Time,
-			Category:      db.RecordCategory(rm.Category),
-		})
+		recordInfos = append(recordInfos, rm.toRecordInfo())
+	}
+	return recordInfos, nil
+}
+
+func (p *pgDBWrapper) GetTripRecordsByGroup(ctx context.Context, id uuid.UUID, groupID uuid.UUID) ([]db.RecordInfo, error) {
+	query := p.reader().WithContext(ctx).Where("trip_id = ?", id)
+	if groupID == uuid.Nil {
+		query = query.Where("group_id IS NULL")
+	} else {
+		query = query.Where("group_id = ?", groupID)
+	}
+	var recordModels []RecordModel
+	if err := query.Find(&recordModels).Error; err != nil {
+		return nil, err
+	}
+
+	var recordInfos []db.RecordInfo
+	for _, rm := range recordModels {
+		recordInfos = append(recordInfos, rm.toRecordInfo())
 	}
 	return recordInfos, nil
 }
 
-func (p *pgDBWrapper) GetTripAddressList(id uuid.UUID) ([]db.Address, error) {
+// GetTripRecordsFiltered retrieves the records of a trip which pass the filter, sorted by time.
+func (p *pgDBWrapper) GetTripRecordsFiltered(ctx context.Context, id uuid.UUID, filter db.RecordFilter) ([]db.RecordInfo, error) {
+	records, _, err := p.GetTripRecordsQuery(ctx, id, filter.Query())
+	return records, err
+}
+
+// recordSortColumns maps the sort fields of db.RecordQuery to record columns.
+var recordSortColumns = map[db.RecordSortField]string{
+	db.RecordSortByTime:   "time",
+	db.RecordSortByAmount: "amount",
+	db.RecordSortByName:   "name",
+}
+
+// GetTripRecordsQuery retrieves one sorted page of the trip's records which pass the query filters,
+// together with the number of matching records before pagination.
+func (p *pgDBWrapper) GetTripRecordsQuery(ctx context.Context, tripID uuid.UUID, opts db.RecordQuery) ([]db.RecordInfo, int, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	// bounds in UTC, the sqlite backend reuses this query and compares times as UTC text
+	query := p.reader().WithContext(ctx).Model(&RecordModel{}).Where("trip_id = ?", tripID)
+	if opts.FromTime != nil {
+		query = query.Where("time >= ?", opts.FromTime.UTC())
+	}
+	if opts.ToTime != nil {
+		query = query.Where("time < ?", opts.ToTime.UTC())
+	}
+	if opts.Category != nil {
+		query = query.Where("category = ?", int(*opts.Category))
+	}
+	if opts.PrePayAddress != nil {
+		query = query.Where("pre_pay_address = ?", string(*opts.PrePayAddress))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	direction := "ASC"
+	if opts.Desc {
+		direction = "DESC"
+	}
+	query = query.Order(recordSortColumns[opts.SortBy] + " " + direction).Order("created_at, id")
+	if opts.Offset > 0 {
+		query = query.Offset(opts.Offset)
+	}
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+
+	var recordModels []RecordModel
+	if err := query.Find(&recordModels).Error; err != nil {
+		return nil, 0, err
+	}
+
+	recordInfos := make([]db.RecordInfo, 0, len(recordModels))
+	for _, rm := range recordModels {
+		recordInfos = append(recordInfos, rm.toRecordInfo())
+	}
+	return recordInfos, int(total), nil
+}
+
+// GetDuplicateRecords groups the IDs of records in a trip which have same name, amount and prepayer.
+// The records are grouped in Go rather than by an aggregate, so the query is portable to sqlite.
+func (p *pgDBWrapper) GetDuplicateRecords(ctx context.Context, tripID uuid.UUID) ([][]uuid.UUID, error) {
+	var rows []struct {
+		ID            uuid.UUID
+		Name          string
+		Amount        float64
+		PrePayAddress string
+	}
+	err := p.reader().WithContext(ctx).Model(&RecordModel{}).
+		Select("id, name, amount, pre_pay_address").
+		Where("trip_id = ?", tripID).
+		Order("created_at, id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// groups are ordered by their earliest record, the IDs of a group by creation
+	type duplicateKey struct {
+		name, prePayAddress string
+		amount              float64
+	}
+	var keys []duplicateKey
+	grouped := make(map[duplicateKey][]uuid.UUID)
+	for _, row := range rows {
+		key := duplicateKey{name: row.Name, prePayAddress: row.PrePayAddress, amount: row.Amount}
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], row.ID)
+	}
+	var groups [][]uuid.UUID
+	for _, key := range keys {
+		if len(grouped[key]) > 1 {
+			groups = append(groups, grouped[key])
+		}
+	}
+	return groups, nil
+}
+
+func (p *pgDBWrapper) GetTripAddressList(ctx context.Context, id uuid.UUID) ([]db.Address, error) {
 	var addressModels []TripAddressListModel
-	if err := p.db.Where("trip_id = ?", id).Find(&addressModels).Error; err != nil {
+	if err := p.reader().WithContext(ctx).Where("trip_id = ?", id).Find(&addressModels).Error; err != nil {
 		return nil, err
 	}
 
@@ -108,9 +402,28 @@ func (p *pgDBWrapper) GetTripAddressList(id uuid.UUID) ([]db.Address, error) {
 	return addresses, nil
 }
 
-func (p *pgDBWrapper) GetRecordAddressList(recordID uuid.UUID) ([]db.ExtendAddress, error) {
+// GetTripsForAddress retrieves the trips whose address list contains the address, sorted by name.
+func (p *pgDBWrapper) GetTripsForAddress(ctx context.Context, address db.Address) ([]db.TripInfo, error) {
+	var tripModels []TripInfoModel
+	err := p.reader().WithContext(ctx).Model(&TripInfoModel{}).
+		Joins("JOIN trip_address_lists ON trip_address_lists.trip_id = trips.id").
+		Where("trip_address_lists.address = ?", string(address)).
+		Order("trips.name, trips.id").
+		Find(&tripModels).Error
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make([]db.TripInfo, 0, len(tripModels))
+	for _, tm := range tripModels {
+		trips = append(trips, db.TripInfo{ID: tm.ID, Name: tm.Name, Currency: tm.Currency})
+	}
+	return trips, nil
+}
+
+func (p *pgDBWrapper) GetRecordAddressList(ctx context.Context, recordID uuid.UUID) ([]db.ExtendAddress, error) {
 	var shouldPayModels []RecordShouldPayAddressListModel
-	if err := p.db.Where("record_id = ?", recordID).Find(&shouldPayModels).Error; err != nil {
+	if err := p.reader().WithContext(ctx).Where("record_id = ?", recordID).Find(&shouldPayModels).Error; err != nil {
 		return nil, err
 	}
 
@@ -124,123 +437,381 @@ func (p *pgDBWrapper) GetRecordAddressList(recordID uuid.UUID) ([]db.ExtendAddre
 	return addresses, nil
 }
 
-func (p *pgDBWrapper) UpdateTripInfo(info *db.TripInfo) error {
+func (p *pgDBWrapper) UpdateTripInfo(ctx context.Context, info *db.TripInfo) error {
 	tripModel := TripInfoModel{
-		ID:   info.ID,
-		Name: info.Name,
+		ID:       info.ID,
+		Name:     info.Name,
+		Currency: info.Currency,
 	}
-	return p.db.Model(&TripInfoModel{}).Where("id = ?", info.ID).Updates(tripModel).Error
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TripInfoModel{}).Where("id = ?", info.ID).Updates(tripModel)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("trip with ID %s %w for update", info.ID, db.ErrNotFound)
+		}
+		return p.audit(tx, info.ID, info.ID, db.AuditUpdateTrip, info.Name)
+	})
 }
 
-func (p *pgDBWrapper) UpdateTripRecord(recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error) {
-	// use transaction to update info and data
-	tripId := uuid.Nil
-	ret := p.db.Transaction(func(tx *gorm.DB) error {
-		// load cur data
-		var recordModel RecordModel
-		var shouldPayModels []RecordShouldPayAddressListModel
-		if err := tx.First(&recordModel, "id = ?", recordID).Error; err != nil {
+// UpsertTrip creates the trip if it does not exist, otherwise it updates the trip like UpdateTripInfo.
+func (p *pgDBWrapper) UpsertTrip(ctx context.Context, info *db.TripInfo) error {
+	tripModel := TripInfoModel{
+		ID:       info.ID,
+		Name:     info.Name,
+		Currency: info.Currency,
+	}
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing TripInfoModel
+		err := tx.Where("id = ?", info.ID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Create(&tripModel).Error; err != nil {
+				return err
+			}
+			return p.audit(tx, info.ID, info.ID, db.AuditCreateTrip, info.Name)
+		}
+		if err != nil {
 			return err
 		}
-		if err := p.db.Where("record_id = ?", recordID).Find(&shouldPayModels).Error; err != nil {
+		if err := tx.Model(&existing).Updates(tripModel).Error; err != nil {
 			return err
 		}
-		// convert to interface
-		record := &db.Record{
-			RecordInfo: db.RecordInfo{
-				ID:            recordModel.ID,
-				Name:          recordModel.Name,
-				Amount:        recordModel.Amount,
-				Time:          recordModel.Time,
-				PrePayAddress: db.Address(recordModel.PrePayAddress),
-				Category:      db.RecordCategory(recordModel.Category),
-			},
-			RecordData: db.RecordData{
-				ShouldPayAddress: make([]db.ExtendAddress, len(shouldPayModels)),
-			},
-		}
-		for i, d := range shouldPayModels {
-			record.ShouldPayAddress[i] = db.ExtendAddress{
-				Address:   db.Address(d.Address),
-				ExtendMsg: d.ExtendedMsg,
-			}
+		return p.audit(tx, info.ID, info.ID, db.AuditUpdateTrip, info.Name)
+	})
+}
+
+func (p *pgDBWrapper) UpdateTripRecord(ctx context.Context, recordID uuid.UUID, changeLog diff.Changelog) (uuid.UUID, error) {
+	// use transaction to update info and data
+	tripId := uuid.Nil
+	ret := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// load cur data
+		recordModel, current, err := loadRecord(tx, recordID)
+		if err != nil {
+			return err
 		}
+		record := &current
 
 		// apply patch
 		if pl := cdiff.GetCustomDiffer().Patch(changeLog, &record); pl.HasErrors() {
 			return fmt.Errorf("record %s patch failed", recordID)
 		}
 
-		// convert back to db model
-		newRecordModel := RecordModel{
-			ID:            recordModel.ID,     // Keep same record ID
-			TripID:        recordModel.TripID, // Keep the same trip ID
-			Name:          record.RecordInfo.Name,
-			Amount:        record.RecordInfo.Amount,
-			Time:          record.RecordInfo.Time,
-			PrePayAddress: string(record.RecordInfo.PrePayAddress),
-			Category:      int(record.RecordInfo.Category), // Use int to store the category
+		if err := p.saveRecord(tx, recordModel, *record); err != nil {
+			return err
 		}
-		// update db
-		if err := tx.Model(&RecordModel{}).Where("id = ?", record.RecordInfo.ID).Updates(&newRecordModel).Error; err != nil {
+		if err := p.audit(tx, recordModel.TripID, recordID, db.AuditUpdateRecord, db.AuditChangeDetail(changeLog)); err != nil {
 			return err
 		}
-		if err := tx.Where("record_id = ?", record.RecordInfo.ID).Delete(&RecordShouldPayAddressListModel{}).Error; err != nil {
+		tripId = recordModel.TripID // Store the trip ID for return
+		// If everything is successful, return nil to commit the transaction
+		return nil
+	})
+	if ret != nil {
+		return uuid.Nil, ret
+	}
+	return tripId, nil
+}
+
+// UpdateTripRecords replaces each record by its ID in one transaction, a failing record rolls back the whole batch.
+func (p *pgDBWrapper) UpdateTripRecords(ctx context.Context, records []db.Record) (map[uuid.UUID]uuid.UUID, error) {
+	tripIDs := make(map[uuid.UUID]uuid.UUID, len(records))
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			recordModel, current, err := loadRecord(tx, record.ID)
+			if err != nil {
+				return fmt.Errorf("record %s: %w", record.ID, err)
+			}
+			changeLog, err := cdiff.GetCustomDiffer().Diff(current, record)
+			if err != nil {
+				return fmt.Errorf("record %s: %w", record.ID, err)
+			}
+			if err := p.saveRecord(tx, recordModel, record); err != nil {
+				return fmt.Errorf("record %s: %w", record.ID, err)
+			}
+			if err := p.audit(tx, recordModel.TripID, record.ID, db.AuditUpdateRecord, db.AuditChangeDetail(changeLog)); err != nil {
+				return err
+			}
+			tripIDs[record.ID] = recordModel.TripID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tripIDs, nil
+}
+
+// loadRecord reads the record and its should pay rows.
+func loadRecord(tx *gorm.DB, recordID uuid.UUID) (RecordModel, db.Record, error) {
+	var recordModel RecordModel
+	var shouldPayModels []RecordShouldPayAddressListModel
+	if err := tx.First(&recordModel, "id = ?", recordID).Error; err != nil {
+		return RecordModel{}, db.Record{}, notFound(err)
+	}
+	if err := tx.Where("record_id = ?", recordID).Find(&shouldPayModels).Error; err != nil {
+		return RecordModel{}, db.Record{}, err
+	}
+	// convert to interface
+	record := db.Record{
+		RecordInfo: recordModel.toRecordInfo(),
+		RecordData: db.RecordData{
+			ShouldPayAddress: make([]db.ExtendAddress, len(shouldPayModels)),
+		},
+	}
+	for i, d := range shouldPayModels {
+		record.ShouldPayAddress[i] = db.ExtendAddress{
+			Address:   db.Address(d.Address),
+			ExtendMsg: d.ExtendedMsg,
+		}
+	}
+	return recordModel, record, nil
+}
+
+// saveRecord writes record over the row of recordModel and replaces its should pay rows,
+// a should pay address missing from the trip's address list or an over-precise value is rejected before anything is written.
+func (p *pgDBWrapper) saveRecord(tx *gorm.DB, recordModel RecordModel, record db.Record) error {
+	addressList, err := tripAddressList(tx, recordModel.TripID)
+	if err != nil {
+		return err
+	}
+	if err := db.CheckShouldPayAddresses(recordModel.TripID, addressList, record.ShouldPayAddress); err != nil {
+		return err
+	}
+	if err := p.config.CheckRecordPrecision(record); err != nil {
+		return err
+	}
+	// convert back to db model
+	newModel := newRecordModel(recordModel.TripID, record.RecordInfo) // Keep the same trip ID
+	newModel.ID = recordModel.ID                                      // Keep same record ID
+	// update db, every column is selected so zero values, e.g. a cleared group or external ID, are written too
+	if err := tx.Model(&RecordModel{}).Where("id = ?", recordModel.ID).Select("*").Omit("created_at").Updates(&newModel).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("record_id = ?", recordModel.ID).Delete(&RecordShouldPayAddressListModel{}).Error; err != nil {
+		return err
+	}
+
+	// insert batch
+	models := make([]RecordShouldPayAddressListModel, 0, len(record.RecordData.ShouldPayAddress))
+	for _, addr := range record.RecordData.ShouldPayAddress {
+		if addr.Address == "" {
+			continue
+		}
+		shouldPayModel := RecordShouldPayAddressListModel{
+			RecordID:    recordModel.ID,
+			TripID:      recordModel.TripID, // Link to the trip
+			Address:     string(addr.Address),
+			ExtendedMsg: addr.ExtendMsg,
+		}
+		models = append(models, shouldPayModel)
+	}
+	return tx.Create(&models).Error
+}
+
+// PatchTripRecord changes only the columns set in patch, the should pay rows are kept unless the patch sets them.
+func (p *pgDBWrapper) PatchTripRecord(ctx context.Context, recordID uuid.UUID, patch db.RecordPatch) (uuid.UUID, error) {
+	tripId := uuid.Nil
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var recordModel RecordModel
+		if err := tx.First(&recordModel, "id = ?", recordID).Error; err != nil {
+			return notFound(err)
+		}
+
+		if patch.ShouldPayAddress != nil {
+			addressList, err := tripAddressList(tx, recordModel.TripID)
+			if err != nil {
+				return err
+			}
+			if err := db.CheckShouldPayAddresses(recordModel.TripID, addressList, *patch.ShouldPayAddress); err != nil {
+				return err
+			}
+		}
+		if err := p.config.CheckPatchPrecision(patch); err != nil {
 			return err
 		}
 
-		// insert batch
-		models := make([]RecordShouldPayAddressListModel, 0, len(record.RecordData.ShouldPayAddress))
-		for _, addr := range record.RecordData.ShouldPayAddress {
-			if addr.Address == "" {
-				continue
+		// a map updates zero values as well, e.g. an amount patched to 0
+		columns := make(map[string]any)
+		if patch.Name != nil {
+			columns["name"] = *patch.Name
+		}
+		if patch.Amount != nil {
+			columns["amount"] = *patch.Amount
+		}
+		if patch.Time != nil {
+			columns["time"] = *patch.Time
+		}
+		if patch.PrePayAddress != nil {
+			columns["pre_pay_address"] = string(*patch.PrePayAddress)
+		}
+		if patch.Category != nil {
+			columns["category"] = int(*patch.Category)
+		}
+		if len(columns) > 0 {
+			if err := tx.Model(&RecordModel{}).Where("id = ?", recordID).Updates(columns).Error; err != nil {
+				return err
 			}
-			shouldPayModel := RecordShouldPayAddressListModel{
-				RecordID:    record.RecordInfo.ID,
-				TripID:      recordModel.TripID, // Link to the trip
-				Address:     string(addr.Address),
-				ExtendedMsg: addr.ExtendMsg,
+		}
+
+		if patch.ShouldPayAddress != nil {
+			if err := tx.Where("record_id = ?", recordID).Delete(&RecordShouldPayAddressListModel{}).Error; err != nil {
+				return err
+			}
+			models := make([]RecordShouldPayAddressListModel, 0, len(*patch.ShouldPayAddress))
+			for _, addr := range *patch.ShouldPayAddress {
+				if addr.Address == "" {
+					continue
+				}
+				models = append(models, RecordShouldPayAddressListModel{
+					RecordID:    recordID,
+					TripID:      recordModel.TripID,
+					Address:     string(addr.Address),
+					ExtendedMsg: addr.ExtendMsg,
+				})
+			}
+			if len(models) > 0 {
+				if err := tx.Create(&models).Error; err != nil {
+					return err
+				}
 			}
-			models = append(models, shouldPayModel)
 		}
-		if err := tx.Create(&models).Error; err != nil {
+
+		if err := p.audit(tx, recordModel.TripID, recordID, db.AuditUpdateRecord, patch.AuditDetail()); err != nil {
 			return err
 		}
-		tripId = recordModel.TripID // Store the trip ID for return
-		// If everything is successful, return nil to commit the transaction
+		tripId = recordModel.TripID
 		return nil
 	})
-	if ret != nil {
-		return uuid.Nil, ret
+	if err != nil {
+		return uuid.Nil, err
 	}
 	return tripId, nil
 }
 
-func (p *pgDBWrapper) TripAddressListAdd(id uuid.UUID, address db.Address) error {
+func (p *pgDBWrapper) TripAddressListAdd(ctx context.Context, id uuid.UUID, address db.Address) error {
 	addressModel := TripAddressListModel{
 		TripID:  id,
 		Address: string(address),
 	}
-	// Using FirstOrCreate to avoid duplicate entries if the address already exists for the trip.
-	return p.db.FirstOrCreate(&addressModel, TripAddressListModel{TripID: id, Address: string(address)}).Error
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&TripInfoModel{}, "id = ?", id).Error; err != nil {
+			return notFound(err)
+		}
+		// Using FirstOrCreate to avoid duplicate entries if the address already exists for the trip.
+		result := tx.FirstOrCreate(&addressModel, TripAddressListModel{TripID: id, Address: string(address)})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return p.audit(tx, id, id, db.AuditAddAddress, string(address))
+	})
 }
 
-func (p *pgDBWrapper) TripAddressListRemove(id uuid.UUID, address db.Address) error {
-	return p.db.Where("trip_id = ? AND address = ?", id, string(address)).Delete(&TripAddressListModel{}).Error
+// RepairTripAddressList adds the prepay and should-pay addresses of the trip's records
+// which are missing from the trip's address list, and returns the added ones.
+func (p *pgDBWrapper) RepairTripAddressList(ctx context.Context, tripID uuid.UUID) ([]db.Address, error) {
+	var added []db.Address
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tripModel TripInfoModel
+		if err := tx.First(&tripModel, "id = ?", tripID).Error; err != nil {
+			return notFound(err)
+		}
+
+		var addressModels []TripAddressListModel
+		if err := tx.Where("trip_id = ?", tripID).Find(&addressModels).Error; err != nil {
+			return err
+		}
+		known := make(map[string]bool, len(addressModels))
+		for _, am := range addressModels {
+			known[am.Address] = true
+		}
+
+		var recordModels []RecordModel
+		if err := tx.Where("trip_id = ?", tripID).Order("time, id").Find(&recordModels).Error; err != nil {
+			return err
+		}
+		var shouldPayModels []RecordShouldPayAddressListModel
+		if err := tx.Where("trip_id = ?", tripID).Order("record_id, address").Find(&shouldPayModels).Error; err != nil {
+			return err
+		}
+
+		addIfMissing := func(addr string) error {
+			if known[addr] {
+				return nil
+			}
+			known[addr] = true
+			if err := tx.Create(&TripAddressListModel{TripID: tripID, Address: addr}).Error; err != nil {
+				return err
+			}
+			if err := p.audit(tx, tripID, tripID, db.AuditAddAddress, addr); err != nil {
+				return err
+			}
+			added = append(added, db.Address(addr))
+			return nil
+		}
+		for _, rm := range recordModels {
+			if err := addIfMissing(rm.PrePayAddress); err != nil {
+				return err
+			}
+		}
+		for _, sp := range shouldPayModels {
+			if err := addIfMissing(sp.Address); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
 }
 
-func (p *pgDBWrapper) DeleteTrip(id uuid.UUID) error {
-	return p.db.Delete(&TripInfoModel{}, "id = ?", id).Error
+func (p *pgDBWrapper) TripAddressListRemove(ctx context.Context, id uuid.UUID, address db.Address) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("trip_id = ? AND address = ?", id, string(address)).Delete(&TripAddressListModel{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("address %s %w in trip %s", address, db.ErrNotFound, id)
+		}
+		return p.audit(tx, id, id, db.AuditRemoveAddress, string(address))
+	})
 }
 
-func (p *pgDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID, error) {
+// DeleteTrip deletes the trip with its records and address list, the foreign keys restrict deletes,
+// so the rows are removed child first in one transaction.
+func (p *pgDBWrapper) DeleteTrip(ctx context.Context, id uuid.UUID) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tripModel TripInfoModel
+		if err := tx.First(&tripModel, "id = ?", id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := tx.Where("trip_id = ?", id).Delete(&RecordShouldPayAddressListModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trip_id = ?", id).Delete(&RecordModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trip_id = ?", id).Delete(&TripAddressListModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&TripInfoModel{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return p.audit(tx, id, id, db.AuditDeleteTrip, tripModel.Name)
+	})
+}
+
+func (p *pgDBWrapper) DeleteTripRecord(ctx context.Context, recordID uuid.UUID) (uuid.UUID, error) {
 	// first fetch the trip ID for the record
 	var recordModel RecordModel
-	if err := p.db.First(&recordModel, "id = ?", recordID).Error; err != nil {
-		return uuid.Nil, err // Record not found or other error
+	if err := p.db.WithContext(ctx).First(&recordModel, "id = ?", recordID).Error; err != nil {
+		return uuid.Nil, notFound(err)
 	}
 
-	ret := p.db.Transaction(func(tx *gorm.DB) error {
+	ret := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("record_id = ?", recordID).Delete(&RecordShouldPayAddressListModel{}).Error; err != nil {
 			return err
 		}
@@ -249,7 +820,7 @@ func (p *pgDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID, error) {
 			return err
 		}
 
-		return nil
+		return p.audit(tx, recordModel.TripID, recordID, db.AuditDeleteRecord, recordModel.Name)
 	})
 	if ret != nil {
 		return uuid.Nil, ret
@@ -262,20 +833,13 @@ func (p *pgDBWrapper) DeleteTripRecord(recordID uuid.UUID) (uuid.UUID, error) {
 // to avoid N+1 problems. The implementations below are basic.
 func (p *pgDBWrapper) DataLoaderGetRecordInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]db.RecordInfo, error) {
 	var records []RecordModel
-	if err := p.db.WithContext(ctx).Where("trip_id IN ?", tripIds).Find(&records).Error; err != nil {
+	if err := p.reader().WithContext(ctx).Where("trip_id IN ?", tripIds).Find(&records).Error; err != nil {
 		return nil, err
 	}
 
 	result := make(map[uuid.UUID][]db.RecordInfo)
 	for _, r := range records {
-		result[r.TripID] = append(result[r.TripID], db.RecordInfo{
-			ID:            r.ID,
-			Name:          r.Name,
-			Amount:        r.Amount,
-			Time:          r.Time,
-			PrePayAddress: db.Address(r.PrePayAddress),
-			Category:      db.RecordCategory(r.Category),
-		})
+		result[r.TripID] = append(result[r.TripID], r.toRecordInfo())
 	}
 	// Ensure all requested tripIds have an entry in the map, even if empty
 	for _, tripID := range tripIds {
@@ -288,7 +852,7 @@ func (p *pgDBWrapper) DataLoaderGetRecordInfoList(ctx context.Context, tripIds [
 
 func (p *pgDBWrapper) DataLoaderGetTripAddressList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID][]db.Address, error) {
 	var addresses []TripAddressListModel
-	if err := p.db.WithContext(ctx).Where("trip_id IN ?", tripIds).Find(&addresses).Error; err != nil {
+	if err := p.reader().WithContext(ctx).Where("trip_id IN ?", tripIds).Find(&addresses).Error; err != nil {
 		return nil, err
 	}
 
@@ -307,8 +871,8 @@ func (p *pgDBWrapper) DataLoaderGetTripAddressList(ctx context.Context, tripIds
 
 func (p *pgDBWrapper) DataLoaderGetRecordShouldPayList(ctx context.Context, recordIds []uuid.UUID) (map[uuid.UUID][]db.ExtendAddress, error) {
 	var shouldPayAddresses []RecordShouldPayAddressListModel
-	// Assuming RecordShouldPayAddressListModel has RecordID and Address
-	if err := p.db.WithContext(ctx).Where("record_id IN ?", recordIds).Find(&shouldPayAddresses).Error; err != nil {
+	// one query for the whole batch; extended_msg carries the ratio or fixed share of each payer
+	if err := p.reader().WithContext(ctx).Where("record_id IN ?", recordIds).Find(&shouldPayAddresses).Error; err != nil {
 		return nil, err
 	}
 
@@ -329,23 +893,5 @@ func (p *pgDBWrapper) DataLoaderGetRecordShouldPayList(ctx context.Context, reco
 }
 
 func (p *pgDBWrapper) DataLoaderGetTripInfoList(ctx context.Context, tripIds []uuid.UUID) (map[uuid.UUID]*db.TripInfo, error) {
-	var trips []TripInfoModel
-	if err := p.db.WithContext(ctx).Where("id IN ?", tripIds).Find(&trips).Error; err != nil {
-		return nil, err
-	}
-
-	result := make(map[uuid.UUID]*db.TripInfo)
-	for _, t := range trips {
-		result[t.ID] = &db.TripInfo{
-			ID:   t.ID,
-			Name: t.Name,
-		}
-	}
-	// Ensure all requested tripIds have an entry in the map, even if nil
-	for _, tripID := range tripIds {
-		if _, ok := result[tripID]; !ok {
-			result[tripID] = nil // Or an empty TripInfo if that's preferred
-		}
-	}
-	return result, nil
+	return p.GetTripInfos(ctx, tripIds)
 }
@@ -3,7 +3,11 @@ package pg
 import (
 	"context"
 	"dtm/db/db"
+	"dtm/db/dbtest"
+	"fmt"
 	"os"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,18 +33,23 @@ func getTestDSN() string {
 
 // setupTestDB initializes the database for testing and returns the wrapper and a cleanup function.
 func setupTestDB(t *testing.T) (db.TripDBWrapper, func()) {
+	return setupTestDBWithConfig(t, db.DefaultWriteConfig())
+}
+
+// setupTestDBWithConfig works like setupTestDB, written records are checked by config.
+func setupTestDBWithConfig(t *testing.T, config db.WriteConfig) (db.TripDBWrapper, func()) {
 	dsn := getTestDSN()
 	gormDB, err := InitPostgresGORM(dsn) // Assumes InitPostgresGORM handles base migrations from init.go
 	require.NoError(t, err, "Failed to initialize test database using DSN: %s", dsn)
 
-	tripDBWrapper := NewPgDBWrapper(gormDB)
+	tripDBWrapper := NewPgDBWrapperWithConfig(gormDB, nil, config)
 
 	cleanup := func() {
 		// Truncate tables to clean up data. Order matters if not using CASCADE effectively.
 		// Using Exec for raw SQL.
 		// RESTART IDENTITY is important to reset auto-incrementing PKs for predictable test data.
 		// CASCADE should handle dependent rows.
-		err := gormDB.Exec("TRUNCATE TABLE record_should_pay_address_lists, records, trip_address_lists, trips RESTART IDENTITY CASCADE").Error
+		err := gormDB.Exec("TRUNCATE TABLE record_should_pay_address_lists, records, trip_address_lists, trips, audit_log RESTART IDENTITY CASCADE").Error
 		if err != nil {
 			// Fallback if TRUNCATE CASCADE isn't working as expected or not fully supported for all constraints.
 			// This is a less ideal cleanup as it doesn't reset sequences typically.
@@ -49,6 +58,7 @@ func setupTestDB(t *testing.T) (db.TripDBWrapper, func()) {
 			gormDB.Exec("DELETE FROM records")
 			gormDB.Exec("DELETE FROM trip_address_lists")
 			gormDB.Exec("DELETE FROM trips")
+			gormDB.Exec("DELETE FROM audit_log")
 		}
 
 		sqlDB, _ := gormDB.DB()
@@ -73,10 +83,10 @@ func TestCreateTrip(t *testing.T) {
 		Name: "My Test Trip",
 	}
 
-	err := wrapper.CreateTrip(tripInfo)
+	err := wrapper.CreateTrip(t.Context(), tripInfo)
 	require.NoError(t, err)
 
-	fetchedTrip, err := wrapper.GetTripInfo(tripID)
+	fetchedTrip, err := wrapper.GetTripInfo(t.Context(), tripID)
 	require.NoError(t, err)
 	require.NotNil(t, fetchedTrip)
 	assert.Equal(t, tripInfo.ID, fetchedTrip.ID)
@@ -87,18 +97,40 @@ func TestGetTripInfo_NotFound(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	_, err := wrapper.GetTripInfo(uuid.New())
+	_, err := wrapper.GetTripInfo(t.Context(), uuid.New())
 	require.Error(t, err)
+	assert.ErrorIs(t, err, db.ErrNotFound)
 	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 }
 
+func TestCancelledContext(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Cancelled Trip"}))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := wrapper.GetTripInfo(ctx, tripID)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, db.ErrNotFound, "a cancelled read is not a missing trip")
+	_, err = wrapper.GetTripRecords(ctx, tripID)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, wrapper.UpdateTripInfo(ctx, &db.TripInfo{ID: tripID, Name: "Renamed"}), context.Canceled)
+
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Cancelled Trip", info.Name, "cancelled update is not written")
+}
+
 func TestCreateTripRecords(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	tripID := uuid.New()
 	tripInfo := &db.TripInfo{ID: tripID, Name: "Trip For Records"}
-	err := wrapper.CreateTrip(tripInfo)
+	err := wrapper.CreateTrip(t.Context(), tripInfo)
 	require.NoError(t, err)
 
 	// Prerequisites for foreign keys in RecordModel and RecordShouldPayAddressListModel:
@@ -108,10 +140,10 @@ func TestCreateTripRecords(t *testing.T) {
 	shouldPayAddrB := db.Address("should_pay_B_for_records")
 	shouldPayAddrC := db.Address("should_pay_C_for_records")
 
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, prePayAddr1))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, shouldPayAddrA))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, shouldPayAddrB))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, shouldPayAddrC))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, prePayAddr1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, shouldPayAddrA))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, shouldPayAddrB))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, shouldPayAddrC))
 
 	recordID1 := uuid.New()
 	recordID2 := uuid.New()
@@ -151,10 +183,10 @@ func TestCreateTripRecords(t *testing.T) {
 		},
 	}
 
-	err = wrapper.CreateTripRecords(tripID, recordsToCreate)
+	err = wrapper.CreateTripRecords(t.Context(), tripID, recordsToCreate)
 	require.NoError(t, err)
 
-	fetchedRecords, err := wrapper.GetTripRecords(tripID)
+	fetchedRecords, err := wrapper.GetTripRecords(t.Context(), tripID)
 	require.NoError(t, err)
 	require.Len(t, fetchedRecords, 2)
 
@@ -173,7 +205,7 @@ func TestCreateTripRecords(t *testing.T) {
 	assert.Equal(t, prePayAddr1, r1.PrePayAddress)
 	assert.Equal(t, time1.UnixMilli(), r1.Time.UnixMilli())
 	assert.Equal(t, db.CategoryFix, r1.Category)
-	shouldPay1, err := wrapper.GetRecordAddressList(recordID1)
+	shouldPay1, err := wrapper.GetRecordAddressList(t.Context(), recordID1)
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []db.ExtendAddress{
 		{Address: shouldPayAddrA, ExtendMsg: 20.0},
@@ -186,68 +218,455 @@ func TestCreateTripRecords(t *testing.T) {
 	assert.Equal(t, prePayAddr1, r2.PrePayAddress)
 	assert.Equal(t, time2.UnixMilli(), r2.Time.UnixMilli())
 	assert.Equal(t, db.CategoryNormal, r2.Category)
-	shouldPay2, err := wrapper.GetRecordAddressList(recordID2)
+	shouldPay2, err := wrapper.GetRecordAddressList(t.Context(), recordID2)
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []db.ExtendAddress{
 		{Address: shouldPayAddrC, ExtendMsg: 50.0},
 	}, shouldPay2)
 }
 
+func TestCreateTripRecords_UnlistedAddress(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Unlisted Address"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+
+	newRecord := func(name string, shouldPay ...db.Address) db.Record {
+		record := db.Record{RecordInfo: db.RecordInfo{ID: uuid.New(), Name: name, Amount: 20, PrePayAddress: "Alice", Time: time.Now()}}
+		for _, addr := range shouldPay {
+			record.ShouldPayAddress = append(record.ShouldPayAddress, db.ExtendAddress{Address: addr})
+		}
+		return record
+	}
+	taxi := newRecord("Taxi", "Alice", "Zed")
+	err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", "Alice"), taxi})
+	assert.ErrorIs(t, err, db.ErrAddressNotInTrip)
+	assert.EqualError(t, err, `record `+taxi.ID.String()+`: address "Zed" not in trip `+tripID.String()+` address list`)
+	var recordErr *db.RecordError
+	require.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, taxi.ID, recordErr.RecordID)
+	assert.Equal(t, db.Address("Zed"), recordErr.Address)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+}
+
+func TestCreateTripRecords_TooPrecise(t *testing.T) {
+	wrapper, cleanup := setupTestDBWithConfig(t, db.WriteConfig{MaxRecordDecimals: 2})
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Precise Amounts"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+
+	newRecord := func(name string, amount float64) db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{ID: uuid.New(), Name: name, Amount: amount, PrePayAddress: "Alice", Time: time.Now()},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}}},
+		}
+	}
+	taxi := newRecord("Taxi", 30.125)
+	err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", 20.25), taxi})
+	assert.ErrorIs(t, err, db.ErrTooPrecise)
+	assert.EqualError(t, err, `record `+taxi.ID.String()+`: amount of record "Taxi": 30.125 has too many decimal places (3 > 2)`)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", 20.25)}))
+}
+
 func TestGetTripRecords_NoRecords(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip With No Records"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With No Records"})
 	require.NoError(t, err)
 
-	records, err := wrapper.GetTripRecords(tripID)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
 	require.NoError(t, err)
 	assert.Empty(t, records)
 }
 
+func TestGetTripRecordsByGroup(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Groups"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "group_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "group_addr_B"))
+
+	groupID := uuid.New()
+	groupRecordID := uuid.New()
+	plainRecordID := uuid.New()
+	err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{
+		{
+			RecordInfo: db.RecordInfo{
+				ID: groupRecordID, Name: "Grouped", Amount: 30, PrePayAddress: "group_addr_A",
+				Time: time.Now(), GroupID: groupID, GroupName: "day one",
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "group_addr_B"}}},
+		},
+		{
+			RecordInfo: db.RecordInfo{
+				ID: plainRecordID, Name: "Plain", Amount: 10, PrePayAddress: "group_addr_B", Time: time.Now(),
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "group_addr_A"}}},
+		},
+	})
+	require.NoError(t, err)
+
+	grouped, err := wrapper.GetTripRecordsByGroup(t.Context(), tripID, groupID)
+	require.NoError(t, err)
+	require.Len(t, grouped, 1)
+	assert.Equal(t, groupRecordID, grouped[0].ID)
+	assert.Equal(t, groupID, grouped[0].GroupID)
+	assert.Equal(t, "day one", grouped[0].GroupName)
+
+	plain, err := wrapper.GetTripRecordsByGroup(t.Context(), tripID, uuid.Nil)
+	require.NoError(t, err)
+	require.Len(t, plain, 1)
+	assert.Equal(t, plainRecordID, plain[0].ID)
+	assert.Equal(t, uuid.Nil, plain[0].GroupID)
+
+	// moving the record out of its group leaves the group empty
+	ungrouped := db.Record{RecordInfo: grouped[0]}
+	ungrouped.GroupID, ungrouped.GroupName = uuid.Nil, ""
+	changeLog, err := diff.GetCustomDiffer().Diff(db.Record{RecordInfo: grouped[0]}, ungrouped)
+	require.NoError(t, err)
+	_, err = wrapper.UpdateTripRecord(t.Context(), groupRecordID, changeLog)
+	require.NoError(t, err)
+	grouped, err = wrapper.GetTripRecordsByGroup(t.Context(), tripID, groupID)
+	require.NoError(t, err)
+	assert.Empty(t, grouped)
+	plain, err = wrapper.GetTripRecordsByGroup(t.Context(), tripID, uuid.Nil)
+	require.NoError(t, err)
+	require.Len(t, plain, 2)
+	for _, r := range plain {
+		assert.Empty(t, r.GroupName)
+	}
+}
+
+func TestGetTripRecordsQuery(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Query"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "query_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "query_addr_B"))
+
+	newQueryRecord := func(name string, amount float64, category db.RecordCategory) db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{
+				ID: uuid.New(), Name: name, Amount: amount, PrePayAddress: "query_addr_A", Time: time.Now(), Category: category,
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "query_addr_B", ExtendMsg: amount}}},
+		}
+	}
+	hotel := newQueryRecord("Hotel", 300, db.CategoryFix)
+	taxi := newQueryRecord("Taxi", 20, db.CategoryFix)
+	museum := newQueryRecord("Museum", 45, db.CategoryFix)
+	lunch := newQueryRecord("Lunch", 500, db.CategoryNormal)
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{hotel, taxi, lunch, museum}))
+
+	fix := db.CategoryFix
+	opts := db.RecordQuery{Limit: 2, SortBy: db.RecordSortByAmount, Desc: true, Category: &fix}
+	page, total, err := wrapper.GetTripRecordsQuery(t.Context(), tripID, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, hotel.ID, page[0].ID)
+	assert.Equal(t, museum.ID, page[1].ID)
+
+	opts.Offset = 2
+	page, total, err = wrapper.GetTripRecordsQuery(t.Context(), tripID, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, page, 1)
+	assert.Equal(t, taxi.ID, page[0].ID)
+
+	_, _, err = wrapper.GetTripRecordsQuery(t.Context(), tripID, db.RecordQuery{Limit: -1})
+	assert.Error(t, err)
+}
+
+func TestGetTripRecordsQuery_TimeRange(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Time Range"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "filter_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "filter_addr_B"))
+
+	day := func(d int) time.Time { return time.Date(2025, 8, d, 12, 0, 0, 0, time.UTC) }
+	newDayRecord := func(name string, d int, category db.RecordCategory) db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{
+				ID: uuid.New(), Name: name, Amount: 10, PrePayAddress: "filter_addr_A", Time: day(d), Category: category,
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "filter_addr_B"}}},
+		}
+	}
+	hotel := newDayRecord("Hotel", 3, db.CategoryFix)
+	breakfast := newDayRecord("Breakfast", 1, db.CategoryNormal)
+	dinner := newDayRecord("Dinner", 2, db.CategoryNormal)
+	ferry := newDayRecord("Ferry", 4, db.CategoryFix)
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{hotel, breakfast, dinner, ferry}))
+
+	fix := db.CategoryFix
+	from, to := day(2), day(4)
+	tests := []struct {
+		name     string
+		query    db.RecordQuery
+		expected []uuid.UUID
+	}{
+		{"No constraint", db.RecordQuery{}, []uuid.UUID{breakfast.ID, dinner.ID, hotel.ID, ferry.ID}},
+		{"From is inclusive", db.RecordQuery{FromTime: &from}, []uuid.UUID{dinner.ID, hotel.ID, ferry.ID}},
+		{"To is exclusive", db.RecordQuery{ToTime: &to}, []uuid.UUID{breakfast.ID, dinner.ID, hotel.ID}},
+		{"Range and category", db.RecordQuery{FromTime: &from, ToTime: &to, Category: &fix}, []uuid.UUID{hotel.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, _, err := wrapper.GetTripRecordsQuery(t.Context(), tripID, tt.query)
+			require.NoError(t, err)
+			ids := make([]uuid.UUID, len(records))
+			for i, r := range records {
+				ids[i] = r.ID
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	_, _, err := wrapper.GetTripRecordsQuery(t.Context(), tripID, db.RecordQuery{FromTime: &to, ToTime: &from})
+	assert.Error(t, err)
+
+	// GetTripRecordsFiltered is the query of the filter
+	records, err := wrapper.GetTripRecordsFiltered(t.Context(), tripID, db.RecordFilter{FromTime: &from, ToTime: &to})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []uuid.UUID{dinner.ID, hotel.ID}, []uuid.UUID{records[0].ID, records[1].ID})
+}
+
+func TestCreateTripRecords_SplitOverrides(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Overrides"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "split_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "split_addr_B"))
+
+	overrides := map[db.Address]float64{"split_addr_A": 1, "split_addr_B": 3}
+	withOverrides := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID: uuid.New(), Name: "Part", Amount: 40, PrePayAddress: "split_addr_A", Time: time.Now(),
+			SplitOverrides: overrides,
+		},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "split_addr_B"}, {Address: "split_addr_A"}}},
+	}
+	plain := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID: uuid.New(), Name: "Plain", Amount: 10, PrePayAddress: "split_addr_B", Time: time.Now(),
+		},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "split_addr_A"}}},
+	}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{withOverrides, plain}))
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for _, r := range records {
+		if r.ID == withOverrides.ID {
+			assert.Equal(t, overrides, r.SplitOverrides)
+		} else {
+			assert.Nil(t, r.SplitOverrides)
+		}
+	}
+
+	// removing the overrides falls back to the should pay list
+	cleared := withOverrides
+	cleared.SplitOverrides = nil
+	changeLog, err := diff.GetCustomDiffer().Diff(withOverrides, cleared)
+	require.NoError(t, err)
+	_, err = wrapper.UpdateTripRecord(t.Context(), withOverrides.ID, changeLog)
+	require.NoError(t, err)
+	records, err = wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	for _, r := range records {
+		assert.Nil(t, r.SplitOverrides)
+	}
+}
+
+func TestCreateTripRecords_ExternalID(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Retry"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "ext_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "ext_addr_B"))
+
+	newTaxi := func() db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{
+				ID: uuid.New(), Name: "Taxi", Amount: 30, PrePayAddress: "ext_addr_A", Time: time.Now(),
+				ExternalID: "client-1",
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "ext_addr_B"}}},
+		}
+	}
+	first := newTaxi()
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{first}))
+
+	retry := []db.Record{newTaxi()}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, retry))
+	assert.Equal(t, first.ID, retry[0].ID, "retry returns the existing record")
+	assert.Equal(t, "client-1", retry[0].ExternalID)
+	assert.Equal(t, first.ShouldPayAddress, retry[0].ShouldPayAddress)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, first.ID, records[0].ID)
+
+	entries, err := wrapper.GetAuditLog(t.Context(), tripID)
+	require.NoError(t, err)
+	created := 0
+	for _, e := range entries {
+		if e.Operation == db.AuditCreateRecord {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created, "retry is not audited")
+}
+
+func TestGetDuplicateRecords(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip With Duplicates"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "dup_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "dup_addr_B"))
+
+	newDinner := func(prePay db.Address) db.Record {
+		return db.Record{
+			RecordInfo: db.RecordInfo{
+				ID: uuid.New(), Name: "Dinner", Amount: 60, PrePayAddress: prePay, Time: time.Now(),
+			},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "dup_addr_A"}, {Address: "dup_addr_B"}}},
+		}
+	}
+	first, second, other := newDinner("dup_addr_A"), newDinner("dup_addr_A"), newDinner("dup_addr_B")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{first, second, other}))
+
+	groups, err := wrapper.GetDuplicateRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.ElementsMatch(t, []uuid.UUID{first.ID, second.ID}, groups[0])
+
+	groups, err = wrapper.GetDuplicateRecords(t.Context(), uuid.New())
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestRepairTripAddressList(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip To Repair"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "repair_addr_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "repair_addr_B"))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{
+		{
+			RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 20, PrePayAddress: "repair_addr_A", Time: time.Now()},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "repair_addr_B"}}},
+		},
+	}))
+
+	// FKs keep a consistent trip, so repair has nothing to add
+	added, err := wrapper.RepairTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, added)
+
+	_, err = wrapper.RepairTripAddressList(t.Context(), uuid.New())
+	assert.Error(t, err)
+}
+
 func TestTripAddressListAddAndGet(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip For Address List"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip For Address List"})
 	require.NoError(t, err)
 
 	addr1 := db.Address("addr1_test_talag")
 	addr2 := db.Address("addr2_test_talag")
 
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr1))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr2))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr1)) // Test idempotency
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr2))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr1)) // Test idempotency
 
-	addresses, err := wrapper.GetTripAddressList(tripID)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []db.Address{addr1, addr2}, addresses)
 }
 
+func TestGetTripsForAddress(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	beach := db.TripInfo{ID: uuid.New(), Name: "Beach"}
+	alps := db.TripInfo{ID: uuid.New(), Name: "Alps"}
+	city := db.TripInfo{ID: uuid.New(), Name: "City"}
+	for _, trip := range []db.TripInfo{beach, alps, city} {
+		require.NoError(t, wrapper.CreateTrip(t.Context(), &trip))
+	}
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), beach.ID, "trips_addr_alice"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), beach.ID, "trips_addr_bob"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), alps.ID, "trips_addr_alice"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), city.ID, "trips_addr_bob"))
+
+	trips, err := wrapper.GetTripsForAddress(t.Context(), "trips_addr_alice")
+	require.NoError(t, err)
+	assert.Equal(t, []db.TripInfo{alps, beach}, trips)
+
+	trips, err = wrapper.GetTripsForAddress(t.Context(), "trips_addr_nobody")
+	require.NoError(t, err)
+	assert.Empty(t, trips)
+}
+
 func TestTripAddressListRemove(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip For Address Removal"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip For Address Removal"})
 	require.NoError(t, err)
 
 	addr1 := db.Address("addr_to_remove1_talr")
 	addr2 := db.Address("addr_to_keep_talr")
 
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr1))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr2))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr2))
 
-	err = wrapper.TripAddressListRemove(tripID, addr1)
+	err = wrapper.TripAddressListRemove(t.Context(), tripID, addr1)
 	require.NoError(t, err)
 
-	addresses, err := wrapper.GetTripAddressList(tripID)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []db.Address{addr2}, addresses)
 
-	err = wrapper.TripAddressListRemove(tripID, db.Address("non_existent_addr_talr"))
+	err = wrapper.TripAddressListRemove(t.Context(), tripID, db.Address("non_existent_addr_talr"))
 	require.NoError(t, err) // Should not error
 }
 
@@ -256,36 +675,36 @@ func TestTripAddressListRemoveWithRestrict(t *testing.T) {
 	defer cleanup()
 
 	tripId := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripId, Name: "Trip For Address Removal With Restrict"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripId, Name: "Trip For Address Removal With Restrict"})
 	require.NoError(t, err)
-	err = wrapper.TripAddressListAdd(tripId, "addr1")
+	err = wrapper.TripAddressListAdd(t.Context(), tripId, "addr1")
 	require.NoError(t, err)
-	err = wrapper.TripAddressListAdd(tripId, "addr2")
+	err = wrapper.TripAddressListAdd(t.Context(), tripId, "addr2")
 	require.NoError(t, err)
-	err = wrapper.TripAddressListAdd(tripId, "addr3")
+	err = wrapper.TripAddressListAdd(t.Context(), tripId, "addr3")
 	require.NoError(t, err)
-	err = wrapper.TripAddressListAdd(tripId, "addr4")
+	err = wrapper.TripAddressListAdd(t.Context(), tripId, "addr4")
 	require.NoError(t, err)
 
 	// should not create record with not exist address
 	wrongRecord := []db.Record{
 		{RecordInfo: db.RecordInfo{Name: "Sample Record", Amount: 50.0, PrePayAddress: "addr1", Category: db.CategoryNormal}, RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "addr_not_exist", ExtendMsg: 0.0}, {Address: "addr2", ExtendMsg: 0.0}}}},
 	}
-	err = wrapper.CreateTripRecords(tripId, wrongRecord)
+	err = wrapper.CreateTripRecords(t.Context(), tripId, wrongRecord)
 	require.Error(t, err)
 
 	sampleRecord := []db.Record{
 		{RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Sample Record", Amount: 50.0, PrePayAddress: "addr1", Category: db.CategoryNormal}, RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "addr1", ExtendMsg: 0.0}, {Address: "addr2", ExtendMsg: 0.0}}}},
 	}
-	err = wrapper.CreateTripRecords(tripId, sampleRecord)
+	err = wrapper.CreateTripRecords(t.Context(), tripId, sampleRecord)
 	require.NoError(t, err)
 
 	// should not rm address be records' owner
-	err = wrapper.TripAddressListRemove(tripId, "addr1")
+	err = wrapper.TripAddressListRemove(t.Context(), tripId, "addr1")
 	require.Error(t, err)
 
 	// should not rm address in records' pay list
-	err = wrapper.TripAddressListRemove(tripId, "addr2")
+	err = wrapper.TripAddressListRemove(t.Context(), tripId, "addr2")
 	require.Error(t, err)
 
 	// rm addr2 in pay list
@@ -293,19 +712,19 @@ func TestTripAddressListRemoveWithRestrict(t *testing.T) {
 	sampleRecord[0].RecordData.ShouldPayAddress = []db.ExtendAddress{{Address: "addr1", ExtendMsg: 0.0}, {Address: "addr3", ExtendMsg: 0.0}, {Address: "addr4", ExtendMsg: 0.0}}
 	cl, err := diff.GetCustomDiffer().Diff(originalRecord, sampleRecord[0])
 	require.NoError(t, err)
-	_, err = wrapper.UpdateTripRecord(sampleRecord[0].ID, cl)
+	_, err = wrapper.UpdateTripRecord(t.Context(), sampleRecord[0].ID, cl)
 	require.NoError(t, err)
 
 	// can rm addr2
-	err = wrapper.TripAddressListRemove(tripId, "addr2")
+	err = wrapper.TripAddressListRemove(t.Context(), tripId, "addr2")
 	require.NoError(t, err)
 
 	// delete record
-	_, err = wrapper.DeleteTripRecord(sampleRecord[0].ID)
+	_, err = wrapper.DeleteTripRecord(t.Context(), sampleRecord[0].ID)
 	require.NoError(t, err)
 
 	// can rm owner addr1
-	err = wrapper.TripAddressListRemove(tripId, db.Address("addr1"))
+	err = wrapper.TripAddressListRemove(t.Context(), tripId, db.Address("addr1"))
 	require.NoError(t, err)
 }
 
@@ -314,34 +733,143 @@ func TestUpdateTripInfo(t *testing.T) {
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Original Trip Name"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Original Trip Name"})
 	require.NoError(t, err)
 
 	updatedInfo := &db.TripInfo{ID: tripID, Name: "Updated Trip Name"}
-	err = wrapper.UpdateTripInfo(updatedInfo)
+	err = wrapper.UpdateTripInfo(t.Context(), updatedInfo)
 	require.NoError(t, err)
 
-	fetchedTrip, err := wrapper.GetTripInfo(tripID)
+	fetchedTrip, err := wrapper.GetTripInfo(t.Context(), tripID)
 	require.NoError(t, err)
 	assert.Equal(t, updatedInfo.Name, fetchedTrip.Name)
 }
 
+func TestUpdateTripRecords(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip for Batch Update"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Bob"))
+	records := make([]db.Record, 3)
+	for i := range records {
+		records[i] = db.Record{
+			RecordInfo: db.RecordInfo{ID: uuid.New(), Name: fmt.Sprintf("Record %d", i), Amount: float64(10 * (i + 1)), PrePayAddress: "Alice"},
+			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Bob"}}},
+		}
+	}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, records))
+
+	// the middle record references an address outside the trip, none of the three changes persist
+	failing := make([]db.Record, len(records))
+	copy(failing, records)
+	for i := range failing {
+		failing[i].Amount = 99
+	}
+	failing[1].ShouldPayAddress = []db.ExtendAddress{{Address: "missing_should_pay"}}
+	_, err := wrapper.UpdateTripRecords(t.Context(), failing)
+	require.ErrorIs(t, err, db.ErrAddressNotInTrip)
+	stored, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	for _, record := range stored {
+		assert.NotEqual(t, 99.0, record.Amount)
+	}
+
+	// all three are updated together
+	updated := make([]db.Record, len(records))
+	copy(updated, records)
+	for i := range updated {
+		updated[i].Amount = 42.5
+		updated[i].ShouldPayAddress = []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}
+	}
+	tripIDs, err := wrapper.UpdateTripRecords(t.Context(), updated)
+	require.NoError(t, err)
+	require.Len(t, tripIDs, 3)
+	stored, err = wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	for _, record := range stored {
+		assert.Equal(t, 42.5, record.Amount)
+		assert.Equal(t, tripID, tripIDs[record.ID])
+		addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+		require.NoError(t, err)
+		assert.Len(t, addresses, 2)
+	}
+
+	// zero values replace the stored ones as well
+	record := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID: uuid.New(), Name: "Grouped", Amount: 10, PrePayAddress: "Alice", Category: db.CategoryFix,
+			GroupID: uuid.New(), GroupName: "Day 1", SplitOverrides: map[db.Address]float64{"Bob": 4}, ExternalID: "ext-clear",
+		},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Bob"}}},
+	}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+	cleared := record
+	cleared.Category = db.CategoryNormal
+	cleared.GroupID = uuid.Nil
+	cleared.GroupName = ""
+	cleared.SplitOverrides = nil
+	cleared.ExternalID = ""
+	_, err = wrapper.UpdateTripRecords(t.Context(), []db.Record{cleared})
+	require.NoError(t, err)
+	stored, err = wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	idx := slices.IndexFunc(stored, func(r db.RecordInfo) bool { return r.ID == record.ID })
+	require.NotEqual(t, -1, idx)
+	got := stored[idx]
+	assert.Equal(t, db.CategoryNormal, got.Category)
+	assert.Equal(t, uuid.Nil, got.GroupID)
+	assert.Empty(t, got.GroupName)
+	assert.Empty(t, got.SplitOverrides)
+	assert.Empty(t, got.ExternalID)
+}
+
+func TestUpsertTrip(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.UpsertTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Original Trip Name", Currency: "JPY"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+	recordID := uuid.New()
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{
+		{RecordInfo: db.RecordInfo{ID: recordID, Name: "Dinner", Amount: 30, PrePayAddress: "Alice"}},
+	}))
+
+	// a second upsert updates instead of failing on the existing ID
+	require.NoError(t, wrapper.UpsertTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Renamed Trip"}))
+
+	fetchedTrip, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed Trip", fetchedTrip.Name)
+	assert.Equal(t, "JPY", fetchedTrip.Currency)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, recordID, records[0].ID)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice"}, addresses)
+}
+
 func TestUpdateTripRecord(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip for Record Update"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip for Record Update"})
 	require.NoError(t, err)
 
 	prePayAddr := db.Address("prepay_for_update_test_utr")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, prePayAddr)) // Prereq for RecordModel FK
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, prePayAddr)) // Prereq for RecordModel FK
 
 	recordID := uuid.New()
 	originalRecord := []db.Record{
 		{RecordInfo: db.RecordInfo{ID: recordID, Name: "Original Record", Amount: 50.0, PrePayAddress: prePayAddr}},
 	}
-	err = wrapper.CreateTripRecords(tripID, originalRecord)
+	err = wrapper.CreateTripRecords(t.Context(), tripID, originalRecord)
 	require.NoError(t, err)
 
 	curTime := time.Now()
@@ -355,16 +883,16 @@ func TestUpdateTripRecord(t *testing.T) {
 	// should err as db constrain
 	cl, err := diff.GetCustomDiffer().Diff(db.Record{}, updatedRecord)
 	require.NoError(t, err)
-	tripId, err := wrapper.UpdateTripRecord(updatedRecordInfo.ID, cl)
+	tripId, err := wrapper.UpdateTripRecord(t.Context(), updatedRecordInfo.ID, cl)
 	require.Error(t, err)
 	assert.Empty(t, tripId)
 	// should success as insert address
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, "shouldpay_for_update_test_utr")) // Add a should pay address
-	tripId, err = wrapper.UpdateTripRecord(updatedRecordInfo.ID, cl)
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "shouldpay_for_update_test_utr")) // Add a should pay address
+	tripId, err = wrapper.UpdateTripRecord(t.Context(), updatedRecordInfo.ID, cl)
 	require.NoError(t, err)
 	assert.Equal(t, tripID, tripId)
 
-	fetchedRecords, err := wrapper.GetTripRecords(tripID)
+	fetchedRecords, err := wrapper.GetTripRecords(t.Context(), tripID)
 	require.NoError(t, err)
 	require.Len(t, fetchedRecords, 1)
 	assert.Equal(t, updatedRecordInfo.Name, fetchedRecords[0].Name)
@@ -375,7 +903,7 @@ func TestUpdateTripRecord(t *testing.T) {
 	assert.Equal(t, curTime.UnixMilli(), fetchedRecords[0].Time.UnixMilli())
 	assert.NotEmpty(t, fetchedRecords[0].ID)
 
-	shouldPayAddresses, err := wrapper.GetRecordAddressList(recordID)
+	shouldPayAddresses, err := wrapper.GetRecordAddressList(t.Context(), recordID)
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []db.ExtendAddress{
 		{Address: "shouldpay_for_update_test_utr", ExtendMsg: 10.0},
@@ -387,13 +915,13 @@ func TestDeleteTripRecord(t *testing.T) {
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip for Record Deletion"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip for Record Deletion"})
 	require.NoError(t, err)
 
 	prePayAddr := db.Address("prepay_for_delete_dtr")
 	shouldPayAddr := db.Address("shouldpay_for_delete_dtr")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, prePayAddr))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, shouldPayAddr))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, prePayAddr))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, shouldPayAddr))
 
 	recordID := uuid.New()
 	records := []db.Record{
@@ -404,14 +932,14 @@ func TestDeleteTripRecord(t *testing.T) {
 			}},
 		},
 	}
-	err = wrapper.CreateTripRecords(tripID, records)
+	err = wrapper.CreateTripRecords(t.Context(), tripID, records)
 	require.NoError(t, err)
 
-	tripId, err := wrapper.DeleteTripRecord(recordID)
+	tripId, err := wrapper.DeleteTripRecord(t.Context(), recordID)
 	require.NoError(t, err)
 	assert.Equal(t, tripID, tripId)
 
-	fetchedRecords, err := wrapper.GetTripRecords(tripID)
+	fetchedRecords, err := wrapper.GetTripRecords(t.Context(), tripID)
 	require.NoError(t, err)
 	assert.Empty(t, fetchedRecords)
 
@@ -428,11 +956,11 @@ func TestDeleteTrip(t *testing.T) {
 	defer cleanup()
 
 	tripID := uuid.New()
-	err := wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "Trip To Fully Delete"})
+	err := wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Trip To Fully Delete"})
 	require.NoError(t, err)
 
 	addr := db.Address("addr_for_delete_trip_dt")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addr))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr))
 
 	recordID := uuid.New()
 	records := []db.Record{
@@ -441,27 +969,49 @@ func TestDeleteTrip(t *testing.T) {
 			RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: addr, ExtendMsg: 0.5}}},
 		},
 	}
-	err = wrapper.CreateTripRecords(tripID, records)
+	err = wrapper.CreateTripRecords(t.Context(), tripID, records)
 	require.NoError(t, err)
 
-	err = wrapper.DeleteTrip(tripID)
-	require.Error(t, err)
-
-	// delete records
-	_, err = wrapper.DeleteTripRecord(recordID)
+	// records and address list are deleted with the trip
+	err = wrapper.DeleteTrip(t.Context(), tripID)
 	require.NoError(t, err)
 
-	// delete addr
-	err = wrapper.TripAddressListRemove(tripID, db.Address("addr_for_delete_trip_dt"))
-	require.NoError(t, err)
+	_, err = wrapper.GetTripInfo(t.Context(), tripID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	dbConn := (wrapper.(*pgDBWrapper)).db
+	for _, model := range []any{&RecordModel{}, &RecordShouldPayAddressListModel{}, &TripAddressListModel{}} {
+		var count int64
+		require.NoError(t, dbConn.Model(model).Where("trip_id = ?", tripID).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	}
 
-	// delete trip success
-	err = wrapper.DeleteTrip(tripID)
-	require.NoError(t, err)
+	err = wrapper.DeleteTrip(t.Context(), tripID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
 }
 
 // --- Data Loader Tests ---
 
+func TestGetTripInfos(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: ids[0], Name: "Batch Trip 1"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: ids[1], Name: "Batch Trip 2", Currency: "JPY"}))
+
+	result, err := wrapper.GetTripInfos(t.Context(), ids)
+	require.NoError(t, err)
+	require.Len(t, result, 3)
+	assert.Equal(t, &db.TripInfo{ID: ids[0], Name: "Batch Trip 1"}, result[ids[0]])
+	assert.Equal(t, &db.TripInfo{ID: ids[1], Name: "Batch Trip 2", Currency: "JPY"}, result[ids[1]])
+	assert.Contains(t, result, ids[2])
+	assert.Nil(t, result[ids[2]])
+
+	empty, err := wrapper.GetTripInfos(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
 func TestDataLoaderGetTripInfoList(t *testing.T) {
 	wrapper, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -472,8 +1022,8 @@ func TestDataLoaderGetTripInfoList(t *testing.T) {
 		{ID: ids[0], Name: "DL Trip 1"},
 		{ID: ids[1], Name: "DL Trip 2"},
 	}
-	require.NoError(t, wrapper.CreateTrip(infos[0]))
-	require.NoError(t, wrapper.CreateTrip(infos[1]))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), infos[0]))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), infos[1]))
 
 	resultMap, err := wrapper.DataLoaderGetTripInfoList(ctx, ids)
 	require.NoError(t, err)
@@ -489,24 +1039,24 @@ func TestDataLoaderGetRecordInfoList(t *testing.T) {
 	ctx := context.Background()
 
 	tripID1 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID1, Name: "DLRec Trip 1"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID1, Name: "DLRec Trip 1"}))
 	tripID2 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID2, Name: "DLRec Trip 2"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID2, Name: "DLRec Trip 2"}))
 	tripID3 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID3, Name: "DLRec Trip 3"})) // No records
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID3, Name: "DLRec Trip 3"})) // No records
 
 	addrT1 := db.Address("dlrec_t1_addr")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID1, addrT1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID1, addrT1))
 	addrT2 := db.Address("dlrec_t2_addr")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID2, addrT2))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID2, addrT2))
 
 	curTime := time.Now()
 	rec1T1 := db.Record{RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "T1R1", PrePayAddress: addrT1, Time: curTime, Category: db.CategoryFix}}
 	rec2T1 := db.Record{RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "T1R2", PrePayAddress: addrT1, Time: curTime, Category: db.CategoryNormal}}
-	require.NoError(t, wrapper.CreateTripRecords(tripID1, []db.Record{rec1T1, rec2T1}))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID1, []db.Record{rec1T1, rec2T1}))
 
 	rec1T2 := db.Record{RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "T2R1", PrePayAddress: addrT2, Time: curTime, Category: db.CategoryNormal}}
-	require.NoError(t, wrapper.CreateTripRecords(tripID2, []db.Record{rec1T2}))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID2, []db.Record{rec1T2}))
 
 	resultMap, err := wrapper.DataLoaderGetRecordInfoList(ctx, []uuid.UUID{tripID1, tripID2, tripID3})
 	require.NoError(t, err)
@@ -522,19 +1072,19 @@ func TestDataLoaderGetTripAddressList(t *testing.T) {
 	ctx := context.Background()
 
 	tripID1 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID1, Name: "DLAddr Trip 1"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID1, Name: "DLAddr Trip 1"}))
 	tripID2 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID2, Name: "DLAddr Trip 2"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID2, Name: "DLAddr Trip 2"}))
 	tripID3 := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID3, Name: "DLAddr Trip 3"})) // No addresses
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID3, Name: "DLAddr Trip 3"})) // No addresses
 
 	addr1T1 := db.Address("t1a1_dl")
 	addr2T1 := db.Address("t1a2_dl")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID1, addr1T1))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID1, addr2T1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID1, addr1T1))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID1, addr2T1))
 
 	addr1T2 := db.Address("t2a1_dl")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID2, addr1T2))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID2, addr1T2))
 
 	resultMap, err := wrapper.DataLoaderGetTripAddressList(ctx, []uuid.UUID{tripID1, tripID2, tripID3})
 	require.NoError(t, err)
@@ -550,17 +1100,17 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 	ctx := context.Background()
 
 	tripID := uuid.New()
-	require.NoError(t, wrapper.CreateTrip(&db.TripInfo{ID: tripID, Name: "DLShouldPay Trip"}))
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "DLShouldPay Trip"}))
 
 	// Pre-add all addresses to TripAddressList
 	prePay := db.Address("dlsp_prepay")
 	addrA := db.Address("dlsp_A")
 	addrB := db.Address("dlsp_B")
 	addrC := db.Address("dlsp_C")
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, prePay))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addrA))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addrB))
-	require.NoError(t, wrapper.TripAddressListAdd(tripID, addrC))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, prePay))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addrA))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addrB))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addrC))
 
 	recID1 := uuid.New()
 	recID2 := uuid.New()
@@ -577,7 +1127,7 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 		}}},
 		{RecordInfo: db.RecordInfo{ID: recID3, Name: "R3", PrePayAddress: prePay}, RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{}}},
 	}
-	require.NoError(t, wrapper.CreateTripRecords(tripID, records))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, records))
 
 	resultMap, err := wrapper.DataLoaderGetRecordShouldPayList(ctx, []uuid.UUID{recID1, recID2, recID3, recID4NonExistent})
 	require.NoError(t, err)
@@ -592,3 +1142,164 @@ func TestDataLoaderGetRecordShouldPayList(t *testing.T) {
 	assert.Empty(t, resultMap[recID3])
 	assert.Empty(t, resultMap[recID4NonExistent])
 }
+
+func TestPatchTripRecord(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Patch Trip"}))
+	record := db.Record{
+		RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 30, Time: time.Now(), PrePayAddress: "patch_A"},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "patch_A", ExtendMsg: 1}, {Address: "patch_B", ExtendMsg: 2}}},
+	}
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "patch_A"))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "patch_B"))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	amount := 45.5
+	gotTripID, err := wrapper.PatchTripRecord(t.Context(), record.ID, db.RecordPatch{Amount: &amount})
+	require.NoError(t, err)
+	assert.Equal(t, tripID, gotTripID)
+	name := "Dinner"
+	_, err = wrapper.PatchTripRecord(t.Context(), record.ID, db.RecordPatch{Name: &name})
+	require.NoError(t, err)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "Dinner", records[0].Name)
+	assert.Equal(t, 45.5, records[0].Amount)
+	assert.Equal(t, db.Address("patch_A"), records[0].PrePayAddress)
+	addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, record.ShouldPayAddress, addresses)
+
+	_, err = wrapper.PatchTripRecord(t.Context(), uuid.New(), db.RecordPatch{Name: &name})
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestGetAuditLog(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+	owner := wrapper.WithActor("key:owner")
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Audit Trip"}))
+	record := db.Record{
+		RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 30, Time: time.Now(), PrePayAddress: "Alice"},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}},
+	}
+	require.NoError(t, owner.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	updated := record
+	updated.Name = "Dinner"
+	cl, err := diff.GetCustomDiffer().Diff(record, updated)
+	require.NoError(t, err)
+	_, err = owner.UpdateTripRecord(t.Context(), record.ID, cl)
+	require.NoError(t, err)
+	_, err = owner.DeleteTripRecord(t.Context(), record.ID)
+	require.NoError(t, err)
+	require.NoError(t, wrapper.DeleteTrip(t.Context(), tripID))
+
+	entries, err := wrapper.GetAuditLog(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, entries, 5, "deleted trip keeps its audit log")
+
+	expected := []struct {
+		op     db.AuditOperation
+		target uuid.UUID
+		actor  string
+	}{
+		{db.AuditCreateTrip, tripID, db.AuditActorSystem},
+		{db.AuditCreateRecord, record.ID, "key:owner"},
+		{db.AuditUpdateRecord, record.ID, "key:owner"},
+		{db.AuditDeleteRecord, record.ID, "key:owner"},
+		{db.AuditDeleteTrip, tripID, db.AuditActorSystem},
+	}
+	for i, want := range expected {
+		assert.Equal(t, want.op, entries[i].Operation, "entry %d", i)
+		assert.Equal(t, want.target, entries[i].TargetID, "entry %d", i)
+		assert.Equal(t, want.actor, entries[i].Actor, "entry %d", i)
+	}
+	assert.Equal(t, "RecordInfo.Name", entries[2].Detail)
+
+	// a change which fails leaves no entry
+	_, err = owner.DeleteTripRecord(t.Context(), uuid.New())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	entries, err = wrapper.GetAuditLog(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
+
+// statementCounter counts the statements GORM runs on one connection.
+type statementCounter struct {
+	reads, writes atomic.Int32
+}
+
+func countStatements(t *testing.T, gormDB *gorm.DB) *statementCounter {
+	c := &statementCounter{}
+	read := func(*gorm.DB) { c.reads.Add(1) }
+	write := func(*gorm.DB) { c.writes.Add(1) }
+	require.NoError(t, gormDB.Callback().Query().After("gorm:query").Register("test:count_query", read))
+	require.NoError(t, gormDB.Callback().Row().After("gorm:row").Register("test:count_row", read))
+	require.NoError(t, gormDB.Callback().Create().After("gorm:create").Register("test:count_create", write))
+	require.NoError(t, gormDB.Callback().Update().After("gorm:update").Register("test:count_update", write))
+	require.NoError(t, gormDB.Callback().Delete().After("gorm:delete").Register("test:count_delete", write))
+	return c
+}
+
+func TestNewPgDBWrapperWithReplica(t *testing.T) {
+	_, cleanup := setupTestDB(t) // truncates the tables afterward
+	defer cleanup()
+	primary, err := InitPostgresGORM(getTestDSN())
+	require.NoError(t, err)
+	defer CloseGORM(primary)
+	replica, err := InitPostgresGORM(getTestDSN())
+	require.NoError(t, err)
+	defer CloseGORM(replica)
+	primaryCount, replicaCount := countStatements(t, primary), countStatements(t, replica)
+	wrapper := NewPgDBWrapperWithReplica(primary, replica).WithActor("key:owner")
+
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Replica Trip"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+	record := db.Record{
+		RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 20, PrePayAddress: "Alice", Time: time.Now()},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}}},
+	}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+	assert.NotZero(t, primaryCount.writes.Load(), "writes go to the primary")
+	assert.Zero(t, replicaCount.reads.Load()+replicaCount.writes.Load(), "writes do not touch the replica")
+
+	primaryCount.reads.Store(0)
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Replica Trip", info.Name)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice"}, addresses)
+	recordsByTrip, err := wrapper.DataLoaderGetRecordInfoList(t.Context(), []uuid.UUID{tripID})
+	require.NoError(t, err)
+	assert.Len(t, recordsByTrip[tripID], 1)
+	_, err = wrapper.DataLoaderGetTripInfoList(t.Context(), []uuid.UUID{tripID})
+	require.NoError(t, err)
+	assert.NotZero(t, replicaCount.reads.Load(), "reads go to the replica")
+	assert.Zero(t, primaryCount.reads.Load(), "reads do not touch the primary")
+	assert.Zero(t, replicaCount.writes.Load())
+}
+
+func TestNotFound(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+	dbtest.RunNotFoundTests(t, wrapper)
+}
+
+func TestExternalIDRetry(t *testing.T) {
+	wrapper, cleanup := setupTestDB(t)
+	defer cleanup()
+	dbtest.RunExternalIDRetryTests(t, wrapper)
+}
@@ -0,0 +1,168 @@
+package sqlite
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	gormsqlite "github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// createSchema is the sqlite version of the tables created by the goose migrations,
+// the foreign keys cascade updates but restrict deletes like the postgres tables.
+var createSchema = []string{
+	`CREATE TABLE IF NOT EXISTS trips (
+		id TEXT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS trip_address_lists (
+		trip_id TEXT NOT NULL,
+		address VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (trip_id, address),
+		CONSTRAINT fk_trip_address_lists_trip
+			FOREIGN KEY(trip_id)
+			REFERENCES trips(id)
+			ON UPDATE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS records (
+		id TEXT PRIMARY KEY,
+		trip_id TEXT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		amount NUMERIC(10,2) NOT NULL,
+		time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		pre_pay_address VARCHAR(255) NOT NULL,
+		category INTEGER NOT NULL,
+		group_id TEXT,
+		group_name VARCHAR(255) NOT NULL DEFAULT '',
+		split_overrides BLOB,
+		external_id VARCHAR(255),
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT fk_records_trip
+			FOREIGN KEY(trip_id)
+			REFERENCES trips(id)
+			ON UPDATE CASCADE,
+		CONSTRAINT fk_records_trip_address
+			FOREIGN KEY(trip_id, pre_pay_address)
+			REFERENCES trip_address_lists(trip_id, address)
+			ON UPDATE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_records_trip_id ON records(trip_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_records_trip_id_pre_pay_address ON records(trip_id, pre_pay_address)`,
+	`CREATE INDEX IF NOT EXISTS idx_records_trip_id_group_id ON records(trip_id, group_id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS uq_records_trip_id_external_id ON records(trip_id, external_id)`,
+	`CREATE TABLE IF NOT EXISTS record_should_pay_address_lists (
+		record_id TEXT NOT NULL,
+		trip_id TEXT NOT NULL,
+		address VARCHAR(255) NOT NULL,
+		extended_msg NUMERIC(10,2),
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (record_id, trip_id, address),
+		CONSTRAINT fk_rspl_record
+			FOREIGN KEY(record_id)
+			REFERENCES records(id)
+			ON UPDATE CASCADE,
+		CONSTRAINT fk_rspl_trip_address
+			FOREIGN KEY(trip_id, address)
+			REFERENCES trip_address_lists(trip_id, address)
+			ON UPDATE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_rspl_record_id ON record_should_pay_address_lists(record_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_rspl_trip_id_address ON record_should_pay_address_lists(trip_id, address)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		trip_id TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		operation VARCHAR(64) NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_log_trip_id_created_at ON audit_log(trip_id, created_at)`,
+}
+
+// InitSqliteGORM opens the sqlite database at dsn (a file path or ":memory:") and creates the tables if missing.
+// The connection pool keeps a single connection, sqlite serializes writes anyway and
+// an in-memory database only lives as long as its connection.
+func InitSqliteGORM(dsn string) (*gorm.DB, error) {
+	newLogger := logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
+		logger.Config{
+			SlowThreshold: time.Second,   // Slow SQL threshold
+			LogLevel:      logger.Silent, // Log level (Silent, Error, Warn, Info)
+			Colorful:      true,          // Disable color
+		},
+	)
+
+	db, err := gorm.Open(gormsqlite.Open(dsn), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	if err := db.Callback().Create().Before("gorm:create").Register("sqlite:utc_times", utcTimes); err != nil {
+		return nil, fmt.Errorf("failed to register callback: %w", err)
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("sqlite:utc_times", utcTimes); err != nil {
+		return nil, fmt.Errorf("failed to register callback: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+
+	// foreign keys are off by default in sqlite
+	if err := db.Exec("PRAGMA foreign_keys = ON").Error; err != nil {
+		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	}
+	for _, stmt := range createSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	return db, nil
+}
+
+// utcTimes converts the time fields of the written models to UTC. sqlite compares times as text,
+// so times written in different zones would not sort or filter by instant.
+func utcTimes(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Dest == nil {
+		return
+	}
+	rv := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	switch {
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			utcModelTimes(db, reflect.Indirect(rv.Index(i)))
+		}
+	default:
+		utcModelTimes(db, rv)
+	}
+}
+
+func utcModelTimes(db *gorm.DB, rv reflect.Value) {
+	if rv.Kind() != reflect.Struct || rv.Type() != db.Statement.Schema.ModelType {
+		return
+	}
+	for _, field := range db.Statement.Schema.Fields {
+		if field.FieldType != reflect.TypeOf(time.Time{}) {
+			continue
+		}
+		if value, isZero := field.ValueOf(db.Statement.Context, rv); !isZero {
+			if err := field.Set(db.Statement.Context, rv, value.(time.Time).UTC()); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package sqlite
+
+import (
+	"dtm/db/db"
+	"dtm/db/pg"
+)
+
+// NewSqliteDBWrapper opens the sqlite database at dsn, see InitSqliteGORM, and returns a TripDBWrapper on it.
+// The GORM queries of the postgres backend are portable, so the postgres wrapper is reused on the sqlite connection.
+func NewSqliteDBWrapper(dsn string) (db.TripDBWrapper, error) {
+	return NewSqliteDBWrapperWithConfig(dsn, db.DefaultWriteConfig())
+}
+
+// NewSqliteDBWrapperWithConfig works like NewSqliteDBWrapper, written records are checked by config.
+func NewSqliteDBWrapperWithConfig(dsn string, config db.WriteConfig) (db.TripDBWrapper, error) {
+	gormDB, err := InitSqliteGORM(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return pg.NewPgDBWrapperWithConfig(gormDB, nil, config), nil
+}
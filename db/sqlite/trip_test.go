@@ -0,0 +1,760 @@
+package sqlite
+
+import (
+	"context"
+	"dtm/db/db"
+	"dtm/db/dbtest"
+	"dtm/db/pg"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"dtm/libs/diff"
+)
+
+// setupTestDB returns a wrapper on a new in-memory database.
+func setupTestDB(t *testing.T) db.TripDBWrapper {
+	t.Helper()
+	return setupTestDBWithConfig(t, db.DefaultWriteConfig())
+}
+
+// setupTestDBWithConfig works like setupTestDB, written records are checked by config.
+func setupTestDBWithConfig(t *testing.T, config db.WriteConfig) db.TripDBWrapper {
+	t.Helper()
+	gormDB, err := InitSqliteGORM(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, err := gormDB.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	})
+	return pg.NewPgDBWrapperWithConfig(gormDB, nil, config)
+}
+
+// createTripWithAddresses creates a trip with the given address list.
+func createTripWithAddresses(t *testing.T, wrapper db.TripDBWrapper, name string, addresses ...db.Address) uuid.UUID {
+	t.Helper()
+	tripID := uuid.New()
+	require.NoError(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: name}))
+	for _, addr := range addresses {
+		require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, addr))
+	}
+	return tripID
+}
+
+func newRecord(name string, amount float64, prePayAddress db.Address, shouldPay ...db.Address) db.Record {
+	record := db.Record{RecordInfo: db.RecordInfo{
+		ID:            uuid.New(),
+		Name:          name,
+		Amount:        amount,
+		Time:          time.Now(),
+		PrePayAddress: prePayAddress,
+		Category:      db.CategoryNormal,
+	}}
+	for _, addr := range shouldPay {
+		record.ShouldPayAddress = append(record.ShouldPayAddress, db.ExtendAddress{Address: addr})
+	}
+	return record
+}
+
+func TestNewSqliteDBWrapper(t *testing.T) {
+	path := t.TempDir() + "/dtm.db"
+	wrapper, err := NewSqliteDBWrapper(path)
+	require.NoError(t, err)
+	tripID := createTripWithAddresses(t, wrapper, "File Trip", "Alice")
+
+	// tables are kept when the file is opened again
+	reopened, err := NewSqliteDBWrapper(path)
+	require.NoError(t, err)
+	info, err := reopened.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "File Trip", info.Name)
+}
+
+func TestCreateAndGetTrip(t *testing.T) {
+	wrapper := setupTestDB(t)
+
+	tripID := createTripWithAddresses(t, wrapper, "Trip Alpha")
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, &db.TripInfo{ID: tripID, Name: "Trip Alpha"}, info)
+
+	assert.Error(t, wrapper.CreateTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Duplicate"}), "trip IDs are unique")
+
+	_, err = wrapper.GetTripInfo(t.Context(), uuid.New())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestCancelledContext(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Cancelled Trip", "Alice")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := wrapper.GetTripInfo(ctx, tripID)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = wrapper.GetDuplicateRecords(ctx, tripID)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, wrapper.TripAddressListAdd(ctx, tripID, "Bob"), context.Canceled)
+
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice"}, addresses)
+}
+
+func TestCreateAndGetTripRecords(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Records Trip", "Alice", "Bob")
+
+	record := newRecord("Dinner", 60.5, "Alice", "Alice", "Bob")
+	record.Category = db.CategoryFix
+	record.ShouldPayAddress[0].ExtendMsg = 20.5
+	record.ShouldPayAddress[1].ExtendMsg = 40
+	record.GroupID = uuid.New()
+	record.GroupName = "Day 1"
+	record.SplitOverrides = map[db.Address]float64{"Bob": 40}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	got := records[0]
+	assert.Equal(t, record.ID, got.ID)
+	assert.Equal(t, "Dinner", got.Name)
+	assert.Equal(t, 60.5, got.Amount)
+	assert.Equal(t, db.Address("Alice"), got.PrePayAddress)
+	assert.Equal(t, db.CategoryFix, got.Category)
+	assert.Equal(t, record.GroupID, got.GroupID)
+	assert.Equal(t, "Day 1", got.GroupName)
+	assert.Equal(t, record.SplitOverrides, got.SplitOverrides)
+	assert.WithinDuration(t, record.Time, got.Time, time.Second)
+
+	addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []db.ExtendAddress{{Address: "Alice", ExtendMsg: 20.5}, {Address: "Bob", ExtendMsg: 40}}, addresses)
+
+	t.Run("Removed overrides are cleared", func(t *testing.T) {
+		cleared := record
+		cleared.SplitOverrides = nil
+		cl, err := diff.GetCustomDiffer().Diff(record, cleared)
+		require.NoError(t, err)
+		_, err = wrapper.UpdateTripRecord(t.Context(), record.ID, cl)
+		require.NoError(t, err)
+		records, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Nil(t, records[0].SplitOverrides)
+	})
+
+	t.Run("Foreign keys reject unknown trip and address", func(t *testing.T) {
+		assert.Error(t, wrapper.CreateTripRecords(t.Context(), uuid.New(), []db.Record{newRecord("Lost", 10, "Alice", "Alice")}))
+		assert.Error(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Stranger", 10, "Zed", "Alice")}))
+		assert.Error(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Stranger", 10, "Alice", "Zed")}))
+		records, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+		assert.Len(t, records, 1, "failed creations are rolled back")
+	})
+}
+
+func TestCreateTripRecords_ExternalID(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Retry Trip", "Alice", "Bob")
+
+	first := newRecord("Taxi", 30, "Alice", "Bob")
+	first.ExternalID = "client-1"
+	plain := newRecord("Lunch", 12, "Bob", "Alice")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{first, plain}))
+
+	retry := newRecord("Taxi", 30, "Alice", "Bob")
+	retry.ExternalID = "client-1"
+	otherPlain := newRecord("Lunch", 12, "Bob", "Alice")
+	batch := []db.Record{retry, otherPlain}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, batch))
+	assert.Equal(t, first.ID, batch[0].ID, "retry returns the existing record")
+	assert.Equal(t, first.ShouldPayAddress, batch[0].ShouldPayAddress)
+	assert.Equal(t, otherPlain.ID, batch[1].ID, "records without external ID are always created")
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	ids := make([]uuid.UUID, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	assert.ElementsMatch(t, []uuid.UUID{first.ID, plain.ID, otherPlain.ID}, ids)
+}
+
+func TestCreateTripRecords_UnlistedAddress(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Unlisted Trip", "Alice")
+
+	taxi := newRecord("Taxi", 30, "Alice", "Alice", "Zed")
+	err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", 20, "Alice", "Alice"), taxi})
+	assert.ErrorIs(t, err, db.ErrAddressNotInTrip)
+	assert.EqualError(t, err, `record `+taxi.ID.String()+`: address "Zed" not in trip `+tripID.String()+` address list`)
+	var recordErr *db.RecordError
+	require.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, taxi.ID, recordErr.RecordID)
+	assert.Equal(t, db.Address("Zed"), recordErr.Address)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+}
+
+func TestCreateTripRecords_TooPrecise(t *testing.T) {
+	wrapper := setupTestDBWithConfig(t, db.WriteConfig{MaxRecordDecimals: 2})
+	tripID := createTripWithAddresses(t, wrapper, "Precise Trip", "Alice")
+
+	taxi := newRecord("Taxi", 30.125, "Alice", "Alice")
+	err := wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", 20.25, "Alice", "Alice"), taxi})
+	assert.ErrorIs(t, err, db.ErrTooPrecise)
+	assert.EqualError(t, err, `record `+taxi.ID.String()+`: amount of record "Taxi": 30.125 has too many decimal places (3 > 2)`)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, records, "no record of the batch is created")
+
+	compliant := newRecord("Lunch", 20.25, "Alice", "Alice")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{compliant}))
+
+	changed := compliant
+	changed.Amount = 20.255
+	_, err = wrapper.UpdateTripRecords(t.Context(), []db.Record{changed})
+	assert.ErrorIs(t, err, db.ErrTooPrecise)
+	shouldPay := []db.ExtendAddress{{Address: "Alice", ExtendMsg: 0.001}}
+	_, err = wrapper.PatchTripRecord(t.Context(), compliant.ID, db.RecordPatch{ShouldPayAddress: &shouldPay})
+	assert.EqualError(t, err, `split value of "Alice": 0.001 has too many decimal places (3 > 2)`)
+
+	records, err = wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 20.25, records[0].Amount)
+}
+
+func TestGetTripRecordsByGroupAndQuery(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Query Trip", "Alice", "Bob")
+
+	groupID := uuid.New()
+	hotel := newRecord("Hotel", 300, "Alice", "Alice", "Bob")
+	hotel.GroupID, hotel.GroupName = groupID, "Stay"
+	lunch := newRecord("Lunch", 30, "Bob", "Alice", "Bob")
+	taxi := newRecord("Taxi", 20, "Alice", "Bob")
+	taxi.Category = db.CategoryFix
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{hotel, lunch, taxi}))
+
+	grouped, err := wrapper.GetTripRecordsByGroup(t.Context(), tripID, groupID)
+	require.NoError(t, err)
+	require.Len(t, grouped, 1)
+	assert.Equal(t, hotel.ID, grouped[0].ID)
+	ungrouped, err := wrapper.GetTripRecordsByGroup(t.Context(), tripID, uuid.Nil)
+	require.NoError(t, err)
+	assert.Len(t, ungrouped, 2)
+
+	// moving the hotel out of its group leaves the group empty
+	moved := hotel
+	moved.GroupID, moved.GroupName = uuid.Nil, ""
+	cl, err := diff.GetCustomDiffer().Diff(hotel, moved)
+	require.NoError(t, err)
+	_, err = wrapper.UpdateTripRecord(t.Context(), hotel.ID, cl)
+	require.NoError(t, err)
+	grouped, err = wrapper.GetTripRecordsByGroup(t.Context(), tripID, groupID)
+	require.NoError(t, err)
+	assert.Empty(t, grouped)
+	ungrouped, err = wrapper.GetTripRecordsByGroup(t.Context(), tripID, uuid.Nil)
+	require.NoError(t, err)
+	assert.Len(t, ungrouped, 3)
+	for _, r := range ungrouped {
+		assert.Empty(t, r.GroupName)
+	}
+
+	page, total, err := wrapper.GetTripRecordsQuery(t.Context(), tripID, db.RecordQuery{SortBy: db.RecordSortByAmount, Desc: true, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, []string{"Hotel", "Lunch"}, []string{page[0].Name, page[1].Name})
+
+	alice := db.Address("Alice")
+	page, total, err = wrapper.GetTripRecordsQuery(t.Context(), tripID, db.RecordQuery{SortBy: db.RecordSortByName, PrePayAddress: &alice, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, page, 1)
+	assert.Equal(t, "Taxi", page[0].Name)
+}
+
+func TestGetTripRecordsQuery_TimeRange(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Time Range Trip", "Alice", "Bob")
+
+	day := func(d int) time.Time { return time.Date(2025, 8, d, 12, 0, 0, 0, time.UTC) }
+	newDayRecord := func(name string, at time.Time, category db.RecordCategory) db.Record {
+		record := newRecord(name, 10, "Alice", "Bob")
+		record.Time = at
+		record.Category = category
+		return record
+	}
+	hotel := newDayRecord("Hotel", day(3), db.CategoryFix)
+	breakfast := newDayRecord("Breakfast", day(1), db.CategoryNormal)
+	// 2025-08-02 14:00 UTC written in a zone behind UTC, its local clock is before the range starts
+	dinner := newDayRecord("Dinner", day(2).Add(2*time.Hour).In(time.FixedZone("EST", -5*60*60)), db.CategoryNormal)
+	ferry := newDayRecord("Ferry", day(4), db.CategoryFix)
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{hotel, breakfast, dinner, ferry}))
+
+	fix := db.CategoryFix
+	from, to := day(2), day(4)
+	tests := []struct {
+		name     string
+		query    db.RecordQuery
+		expected []uuid.UUID
+	}{
+		{"No constraint", db.RecordQuery{}, []uuid.UUID{breakfast.ID, dinner.ID, hotel.ID, ferry.ID}},
+		{"From is inclusive", db.RecordQuery{FromTime: &from}, []uuid.UUID{dinner.ID, hotel.ID, ferry.ID}},
+		{"To is exclusive", db.RecordQuery{ToTime: &to}, []uuid.UUID{breakfast.ID, dinner.ID, hotel.ID}},
+		{"Range and category", db.RecordQuery{FromTime: &from, ToTime: &to, Category: &fix}, []uuid.UUID{hotel.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, _, err := wrapper.GetTripRecordsQuery(t.Context(), tripID, tt.query)
+			require.NoError(t, err)
+			ids := make([]uuid.UUID, len(records))
+			for i, r := range records {
+				ids[i] = r.ID
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	// GetTripRecordsFiltered is the query of the filter
+	records, err := wrapper.GetTripRecordsFiltered(t.Context(), tripID, db.RecordFilter{FromTime: &from, ToTime: &to})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []uuid.UUID{dinner.ID, hotel.ID}, []uuid.UUID{records[0].ID, records[1].ID})
+}
+
+func TestGetDuplicateRecords(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Duplicate Trip", "Alice", "Bob")
+
+	first := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	second := newRecord("Lunch", 30, "Alice", "Bob")
+	other := newRecord("Lunch", 30, "Bob", "Alice", "Bob")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{first}))
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{other, second}))
+
+	groups, err := wrapper.GetDuplicateRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]uuid.UUID{{first.ID, second.ID}}, groups)
+}
+
+func TestAddressList(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Address Trip", "Alice", "Bob")
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"), "adding an address twice is a no-op")
+
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []db.Address{"Alice", "Bob"}, addresses)
+
+	record := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+	assert.Error(t, wrapper.TripAddressListRemove(t.Context(), tripID, "Alice"), "prepayer of a record can not be removed")
+	assert.Error(t, wrapper.TripAddressListRemove(t.Context(), tripID, "Bob"), "should payer of a record can not be removed")
+
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Carol"))
+	require.NoError(t, wrapper.TripAddressListRemove(t.Context(), tripID, "Carol"))
+	addresses, err = wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []db.Address{"Alice", "Bob"}, addresses)
+
+	otherTrip := createTripWithAddresses(t, wrapper, "Another Trip", "Alice")
+	trips, err := wrapper.GetTripsForAddress(t.Context(), "Alice")
+	require.NoError(t, err)
+	assert.Equal(t, []db.TripInfo{{ID: tripID, Name: "Address Trip"}, {ID: otherTrip, Name: "Another Trip"}}, trips)
+	trips, err = wrapper.GetTripsForAddress(t.Context(), "Zed")
+	require.NoError(t, err)
+	assert.Empty(t, trips)
+
+	added, err := wrapper.RepairTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, added, "foreign keys keep the address list complete")
+}
+
+func TestUpdateTripInfoAndRecord(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Original Trip Name", "Alice", "Bob", "Carol")
+
+	require.NoError(t, wrapper.UpdateTripInfo(t.Context(), &db.TripInfo{ID: tripID, Name: "Updated Trip Name"}))
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Updated Trip Name", info.Name)
+	assert.Empty(t, info.Currency)
+
+	// an empty currency keeps the current one
+	require.NoError(t, wrapper.UpdateTripInfo(t.Context(), &db.TripInfo{ID: tripID, Name: "Updated Trip Name", Currency: "JPY"}))
+	require.NoError(t, wrapper.UpdateTripInfo(t.Context(), &db.TripInfo{ID: tripID, Name: "Tokyo Trip"}))
+	info, err = wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Tokyo Trip", info.Name)
+	assert.Equal(t, "JPY", info.Currency)
+
+	record := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	updated := record
+	updated.Name = "Dinner"
+	updated.Amount = 90
+	updated.ShouldPayAddress = []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}, {Address: "Carol"}}
+	cl, err := diff.GetCustomDiffer().Diff(record, updated)
+	require.NoError(t, err)
+	gotTripID, err := wrapper.UpdateTripRecord(t.Context(), record.ID, cl)
+	require.NoError(t, err)
+	assert.Equal(t, tripID, gotTripID)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "Dinner", records[0].Name)
+	assert.Equal(t, 90.0, records[0].Amount)
+	addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, updated.ShouldPayAddress, addresses)
+
+	_, err = wrapper.UpdateTripRecord(t.Context(), uuid.New(), cl)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestPatchTripRecord(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Patch Trip", "Alice", "Bob", "Carol")
+	record := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	record.ShouldPayAddress[1].ExtendMsg = 2
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	// an amount of 0 is a value to patch, not a missing one
+	amount := 0.0
+	gotTripID, err := wrapper.PatchTripRecord(t.Context(), record.ID, db.RecordPatch{Amount: &amount})
+	require.NoError(t, err)
+	assert.Equal(t, tripID, gotTripID)
+	name := "Dinner"
+	_, err = wrapper.PatchTripRecord(t.Context(), record.ID, db.RecordPatch{Name: &name})
+	require.NoError(t, err)
+
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "Dinner", records[0].Name)
+	assert.Equal(t, 0.0, records[0].Amount)
+	assert.Equal(t, db.Address("Alice"), records[0].PrePayAddress)
+	assert.WithinDuration(t, record.Time, records[0].Time, time.Second)
+	addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, record.ShouldPayAddress, addresses)
+
+	shouldPay := []db.ExtendAddress{{Address: "Carol", ExtendMsg: 1}}
+	_, err = wrapper.PatchTripRecord(t.Context(), record.ID, db.RecordPatch{ShouldPayAddress: &shouldPay})
+	require.NoError(t, err)
+	addresses, err = wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, shouldPay, addresses)
+
+	_, err = wrapper.PatchTripRecord(t.Context(), uuid.New(), db.RecordPatch{Name: &name})
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestDeleteTripRecordAndTrip(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Delete Trip", "Alice", "Bob")
+	record := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	other := newRecord("Dinner", 20, "Bob", "Alice", "Bob")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record, other}))
+
+	// deleting a record removes its should pay list too
+	gotTripID, err := wrapper.DeleteTripRecord(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, tripID, gotTripID)
+	addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+	require.NoError(t, err)
+	assert.Empty(t, addresses)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// a trip with records and addresses is deleted with them
+	require.NoError(t, wrapper.DeleteTrip(t.Context(), tripID))
+	_, err = wrapper.GetTripInfo(t.Context(), tripID)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	records, err = wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	addresses, err = wrapper.GetRecordAddressList(t.Context(), other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, addresses)
+	tripAddresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Empty(t, tripAddresses)
+
+	assert.ErrorIs(t, wrapper.DeleteTrip(t.Context(), tripID), db.ErrNotFound)
+	_, err = wrapper.DeleteTripRecord(t.Context(), uuid.New())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func TestDataLoaders(t *testing.T) {
+	wrapper := setupTestDB(t)
+	ctx := context.Background()
+	tripA := createTripWithAddresses(t, wrapper, "Trip A", "Alice", "Bob")
+	tripB := createTripWithAddresses(t, wrapper, "Trip B", "Carol")
+	empty := uuid.New()
+
+	lunch := newRecord("Lunch", 30, "Alice", "Alice", "Bob")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripA, []db.Record{lunch}))
+
+	records, err := wrapper.DataLoaderGetRecordInfoList(ctx, []uuid.UUID{tripA, tripB, empty})
+	require.NoError(t, err)
+	require.Len(t, records[tripA], 1)
+	assert.Equal(t, lunch.ID, records[tripA][0].ID)
+	assert.Empty(t, records[tripB])
+	assert.Contains(t, records, empty)
+
+	addresses, err := wrapper.DataLoaderGetTripAddressList(ctx, []uuid.UUID{tripA, tripB, empty})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []db.Address{"Alice", "Bob"}, addresses[tripA])
+	assert.Equal(t, []db.Address{"Carol"}, addresses[tripB])
+	assert.Empty(t, addresses[empty])
+
+	shouldPay, err := wrapper.DataLoaderGetRecordShouldPayList(ctx, []uuid.UUID{lunch.ID, empty})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}, shouldPay[lunch.ID])
+	assert.Empty(t, shouldPay[empty])
+
+	infos, err := wrapper.DataLoaderGetTripInfoList(ctx, []uuid.UUID{tripA, empty})
+	require.NoError(t, err)
+	assert.Equal(t, &db.TripInfo{ID: tripA, Name: "Trip A"}, infos[tripA])
+	assert.Nil(t, infos[empty])
+}
+
+func TestGetAuditLog(t *testing.T) {
+	wrapper := setupTestDB(t)
+	owner := wrapper.WithActor("key:owner")
+
+	tripID := createTripWithAddresses(t, wrapper, "Audit Trip")
+	require.NoError(t, owner.TripAddressListAdd(t.Context(), tripID, "Alice"))
+	record := newRecord("Lunch", 30, "Alice", "Alice")
+	require.NoError(t, owner.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+	_, err := owner.DeleteTripRecord(t.Context(), record.ID)
+	require.NoError(t, err)
+
+	entries, err := wrapper.GetAuditLog(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	expected := []struct {
+		op    db.AuditOperation
+		actor string
+	}{
+		{db.AuditCreateTrip, db.AuditActorSystem},
+		{db.AuditAddAddress, "key:owner"},
+		{db.AuditCreateRecord, "key:owner"},
+		{db.AuditDeleteRecord, "key:owner"},
+	}
+	for i, want := range expected {
+		assert.Equal(t, want.op, entries[i].Operation, "entry %d", i)
+		assert.Equal(t, want.actor, entries[i].Actor, "entry %d", i)
+	}
+
+	// the owner wrapper keeps the sqlite specific queries
+	_, err = owner.GetDuplicateRecords(t.Context(), tripID)
+	assert.NoError(t, err)
+}
+
+func TestUpsertTrip(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := uuid.New()
+	require.NoError(t, wrapper.UpsertTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Original Trip Name", Currency: "JPY"}))
+	require.NoError(t, wrapper.TripAddressListAdd(t.Context(), tripID, "Alice"))
+	record := newRecord("Dinner", 30, "Alice", "Alice")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+	// a second upsert updates instead of failing on the existing ID
+	require.NoError(t, wrapper.UpsertTrip(t.Context(), &db.TripInfo{ID: tripID, Name: "Renamed Trip"}))
+
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed Trip", info.Name)
+	assert.Equal(t, "JPY", info.Currency)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, record.ID, records[0].ID)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice"}, addresses)
+
+	entries, err := wrapper.GetAuditLog(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, db.AuditCreateTrip, entries[0].Operation)
+	assert.Equal(t, db.AuditUpdateTrip, entries[len(entries)-1].Operation)
+}
+
+func TestUpdateTripRecords(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripID := createTripWithAddresses(t, wrapper, "Batch Trip", "Alice", "Bob")
+	records := []db.Record{
+		newRecord("Rec 1", 10, "Alice", "Alice"),
+		newRecord("Rec 2", 20, "Alice", "Bob"),
+		newRecord("Rec 3", 30, "Bob", "Alice"),
+	}
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, records))
+
+	t.Run("Update three records", func(t *testing.T) {
+		updated := make([]db.Record, len(records))
+		for i, record := range records {
+			updated[i] = record
+			updated[i].Amount = record.Amount + 1
+			updated[i].ShouldPayAddress = []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}
+		}
+		tripIDs, err := wrapper.UpdateTripRecords(t.Context(), updated)
+		require.NoError(t, err)
+		assert.Len(t, tripIDs, 3)
+
+		for _, record := range updated {
+			assert.Equal(t, tripID, tripIDs[record.ID])
+			addresses, err := wrapper.GetRecordAddressList(t.Context(), record.ID)
+			require.NoError(t, err)
+			assert.Len(t, addresses, 2)
+		}
+	})
+
+	t.Run("An unknown should pay address rolls back the batch", func(t *testing.T) {
+		before, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+
+		batch := make([]db.Record, len(records))
+		copy(batch, records)
+		for i := range batch {
+			batch[i].Amount = 100
+		}
+		batch[1].ShouldPayAddress = []db.ExtendAddress{{Address: "Mallory"}}
+		tripIDs, err := wrapper.UpdateTripRecords(t.Context(), batch)
+		require.ErrorIs(t, err, db.ErrAddressNotInTrip)
+		assert.Nil(t, tripIDs)
+
+		after, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, before, after)
+		addresses, err := wrapper.GetRecordAddressList(t.Context(), records[1].ID)
+		require.NoError(t, err)
+		assert.Len(t, addresses, 2)
+	})
+
+	t.Run("Zero values clear the stored fields", func(t *testing.T) {
+		record := newRecord("Grouped", 10, "Alice", "Alice", "Bob")
+		record.Category = db.CategoryFix
+		record.GroupID = uuid.New()
+		record.GroupName = "Day 1"
+		record.SplitOverrides = map[db.Address]float64{"Alice": 4}
+		record.ExternalID = "ext-clear"
+		require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{record}))
+
+		cleared := record
+		cleared.Category = db.CategoryNormal
+		cleared.GroupID = uuid.Nil
+		cleared.GroupName = ""
+		cleared.SplitOverrides = nil
+		cleared.ExternalID = ""
+		_, err := wrapper.UpdateTripRecords(t.Context(), []db.Record{cleared})
+		require.NoError(t, err)
+
+		stored, err := wrapper.GetTripRecords(t.Context(), tripID)
+		require.NoError(t, err)
+		idx := slices.IndexFunc(stored, func(r db.RecordInfo) bool { return r.ID == record.ID })
+		require.NotEqual(t, -1, idx)
+		got := stored[idx]
+		assert.Equal(t, db.CategoryNormal, got.Category)
+		assert.Equal(t, uuid.Nil, got.GroupID)
+		assert.Empty(t, got.GroupName)
+		assert.Empty(t, got.SplitOverrides)
+		assert.Empty(t, got.ExternalID)
+	})
+}
+
+func TestGetTripInfos(t *testing.T) {
+	wrapper := setupTestDB(t)
+	tripA := createTripWithAddresses(t, wrapper, "Trip A")
+	tripB := createTripWithAddresses(t, wrapper, "Trip B")
+	missing := uuid.New()
+
+	infos, err := wrapper.GetTripInfos(t.Context(), []uuid.UUID{tripA, missing, tripB})
+	require.NoError(t, err)
+	require.Len(t, infos, 3)
+	assert.Equal(t, &db.TripInfo{ID: tripA, Name: "Trip A"}, infos[tripA])
+	assert.Equal(t, &db.TripInfo{ID: tripB, Name: "Trip B"}, infos[tripB])
+	assert.Contains(t, infos, missing)
+	assert.Nil(t, infos[missing])
+}
+
+// statementCounter counts the statements GORM runs on one connection.
+type statementCounter struct {
+	reads, writes atomic.Int32
+}
+
+func countStatements(t *testing.T, gormDB *gorm.DB) *statementCounter {
+	c := &statementCounter{}
+	read := func(*gorm.DB) { c.reads.Add(1) }
+	write := func(*gorm.DB) { c.writes.Add(1) }
+	require.NoError(t, gormDB.Callback().Query().After("gorm:query").Register("test:count_query", read))
+	require.NoError(t, gormDB.Callback().Row().After("gorm:row").Register("test:count_row", read))
+	require.NoError(t, gormDB.Callback().Create().After("gorm:create").Register("test:count_create", write))
+	require.NoError(t, gormDB.Callback().Update().After("gorm:update").Register("test:count_update", write))
+	require.NoError(t, gormDB.Callback().Delete().After("gorm:delete").Register("test:count_delete", write))
+	return c
+}
+
+func TestNewPgDBWrapperWithReplica(t *testing.T) {
+	// two connections to one database file stand in for the primary and its replica
+	path := filepath.Join(t.TempDir(), "trip.db")
+	primary, err := InitSqliteGORM(path)
+	require.NoError(t, err)
+	defer pg.CloseGORM(primary)
+	replica, err := InitSqliteGORM(path)
+	require.NoError(t, err)
+	defer pg.CloseGORM(replica)
+	primaryCount, replicaCount := countStatements(t, primary), countStatements(t, replica)
+	wrapper := pg.NewPgDBWrapperWithReplica(primary, replica).WithActor("key:owner")
+
+	tripID := createTripWithAddresses(t, wrapper, "Replica Trip", "Alice")
+	require.NoError(t, wrapper.CreateTripRecords(t.Context(), tripID, []db.Record{newRecord("Lunch", 20, "Alice", "Alice")}))
+	assert.NotZero(t, primaryCount.writes.Load(), "writes go to the primary")
+	assert.Zero(t, replicaCount.reads.Load()+replicaCount.writes.Load(), "writes do not touch the replica")
+
+	primaryCount.reads.Store(0)
+	info, err := wrapper.GetTripInfo(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, "Replica Trip", info.Name)
+	records, err := wrapper.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	addresses, err := wrapper.GetTripAddressList(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Equal(t, []db.Address{"Alice"}, addresses)
+	recordsByTrip, err := wrapper.DataLoaderGetRecordInfoList(t.Context(), []uuid.UUID{tripID})
+	require.NoError(t, err)
+	assert.Len(t, recordsByTrip[tripID], 1)
+	_, err = wrapper.DataLoaderGetTripInfoList(t.Context(), []uuid.UUID{tripID})
+	require.NoError(t, err)
+	assert.NotZero(t, replicaCount.reads.Load(), "reads go to the replica")
+	assert.Zero(t, primaryCount.reads.Load(), "reads do not touch the primary")
+	assert.Zero(t, replicaCount.writes.Load())
+}
+
+func TestNotFound(t *testing.T) {
+	dbtest.RunNotFoundTests(t, setupTestDB(t))
+}
+
+func TestExternalIDRetry(t *testing.T) {
+	dbtest.RunExternalIDRetryTests(t, setupTestDB(t))
+}
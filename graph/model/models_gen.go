@@ -9,6 +9,20 @@ import (
 	"strconv"
 )
 
+type Balance struct {
+	Address string `json:"address"`
+	// paid minus owed before any transfer, positive mean receive money
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+type BalanceChange struct {
+	Address string `json:"address"`
+	// net balance, positive mean receive money
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
 type EditRecord struct {
 	Old *NewRecord `json:"old,omitempty"`
 	New *NewRecord `json:"new,omitempty"`
@@ -30,6 +44,8 @@ type NewRecord struct {
 
 type NewTrip struct {
 	Name string `json:"name"`
+	// ISO 4217 code like "TWD", omitted uses the deployment default on create and keeps the current one on update
+	Currency *string `json:"currency,omitempty"`
 }
 
 type Payment struct {
@@ -40,6 +56,18 @@ type Payment struct {
 type Query struct {
 }
 
+type RecordChange struct {
+	Action   RecordAction `json:"action"`
+	RecordID string       `json:"recordId"`
+	// null when the record is deleted
+	Record *Record `json:"record,omitempty"`
+}
+
+type RecordPreview struct {
+	MoneyShare []*Tx            `json:"moneyShare"`
+	Changes    []*BalanceChange `json:"changes"`
+}
+
 type Subscription struct {
 }
 
@@ -48,6 +76,63 @@ type Tx struct {
 	Output *Payment   `json:"output"`
 }
 
+type RecordAction string
+
+const (
+	RecordActionCreate RecordAction = "CREATE"
+	RecordActionUpdate RecordAction = "UPDATE"
+	RecordActionDelete RecordAction = "DELETE"
+)
+
+var AllRecordAction = []RecordAction{
+	RecordActionCreate,
+	RecordActionUpdate,
+	RecordActionDelete,
+}
+
+func (e RecordAction) IsValid() bool {
+	switch e {
+	case RecordActionCreate, RecordActionUpdate, RecordActionDelete:
+		return true
+	}
+	return false
+}
+
+func (e RecordAction) String() string {
+	return string(e)
+}
+
+func (e *RecordAction) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = RecordAction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid RecordAction", str)
+	}
+	return nil
+}
+
+func (e RecordAction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *RecordAction) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e RecordAction) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
 type RecordCategory string
 
 const (
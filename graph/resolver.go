@@ -3,6 +3,7 @@ package graph
 import (
 	"dtm/db/db"
 	"dtm/mq/mq"
+	"dtm/service"
 )
 
 // This file will not be regenerated automatically.
@@ -13,4 +14,6 @@ import (
 type Resolver struct {
 	TripDB                  db.TripDBWrapper
 	TripMessageQueueWrapper mq.TripMessageQueueWrapper
+	// Settlement configures the money share of every trip resolved
+	Settlement service.SettlementConfig
 }
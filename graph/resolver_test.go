@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dtm/graph/model"
+	"dtm/mq/goch"
+	"dtm/mq/mq"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveChange publishes until a change of action arrives on stream, subscribing to the queues
+// happens in the background so the first messages may be published before anyone listens.
+func receiveChange(t *testing.T, stream <-chan *model.RecordChange, publish func() error, action model.RecordAction) *model.RecordChange {
+	t.Helper()
+	var received *model.RecordChange
+	require.Eventually(t, func() bool {
+		require.NoError(t, publish())
+		for {
+			select {
+			case change, ok := <-stream:
+				require.True(t, ok, "stream closed")
+				if change.Action == action {
+					received = change
+					return true
+				}
+			case <-time.After(20 * time.Millisecond):
+				return false
+			}
+		}
+	}, 2*time.Second, 10*time.Millisecond)
+	return received
+}
+
+func TestSubscriptionResolver_RecordChanged(t *testing.T) {
+	wrapper := goch.NewGoChanTripMessageQueueWrapper()
+	resolver := &Resolver{TripMessageQueueWrapper: wrapper}
+	tripID := uuid.New()
+	recordID := uuid.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := resolver.Subscription().RecordChanged(ctx, tripID.String())
+	require.NoError(t, err)
+
+	publish := func(action mq.Action, msg mq.TripRecordMessage) func() error {
+		return func() error { return wrapper.GetTripRecordMessageQueue(action).Publish(msg) }
+	}
+	record := mq.TripRecordMessage{ID: recordID, TripID: tripID, Name: "lunch", Amount: 30, PrePayAddress: "Alice"}
+
+	created := receiveChange(t, stream, publish(mq.ActionCreate, record), model.RecordActionCreate)
+	assert.Equal(t, recordID.String(), created.RecordID)
+	require.NotNil(t, created.Record)
+	assert.Equal(t, "lunch", created.Record.Name)
+	assert.Equal(t, 30.0, created.Record.Amount)
+
+	record.Amount = 45
+	updated := receiveChange(t, stream, publish(mq.ActionUpdate, record), model.RecordActionUpdate)
+	require.NotNil(t, updated.Record)
+	assert.Equal(t, 45.0, updated.Record.Amount)
+
+	deleted := receiveChange(t, stream, publish(mq.ActionDelete, mq.TripRecordMessage{ID: recordID, TripID: tripID}), model.RecordActionDelete)
+	assert.Equal(t, recordID.String(), deleted.RecordID)
+	assert.Nil(t, deleted.Record)
+
+	t.Run("Other trips are not streamed", func(t *testing.T) {
+		other := mq.TripRecordMessage{ID: uuid.New(), TripID: uuid.New(), Name: "other"}
+		require.NoError(t, wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Publish(other))
+		select {
+		case change := <-stream:
+			assert.NotEqual(t, other.ID.String(), change.RecordID)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("Stream closes when the client disconnects", func(t *testing.T) {
+		cancel()
+		assert.Eventually(t, func() bool {
+			select {
+			case _, ok := <-stream:
+				return !ok
+			default:
+				return false
+			}
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Invalid trip ID", func(t *testing.T) {
+		_, err := resolver.Subscription().RecordChanged(context.Background(), "not-a-uuid")
+		assert.ErrorContains(t, err, "invalid trip ID")
+	})
+}
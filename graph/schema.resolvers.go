@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -25,13 +26,13 @@ func (r *mutationResolver) CreateTrip(ctx context.Context, input model.NewTrip)
 		return nil, fmt.Errorf("invalid trip name")
 	}
 
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	id := uuid.New()
-	tripInfo := &db.TripInfo{
-		ID:   id,
-		Name: input.Name,
+	tripInfo, err := utils.MapNewTripToDBTrip(id, input)
+	if err != nil {
+		return nil, err
 	}
-	if err := dbTripInfo.CreateTrip(tripInfo); err != nil {
+	if err := dbTripInfo.CreateTrip(ctx, tripInfo); err != nil {
 		return nil, fmt.Errorf("failed to create trip: %w", err)
 	}
 	trip := &model.Trip{
@@ -47,17 +48,17 @@ func (r *mutationResolver) UpdateTrip(ctx context.Context, tripID string, input
 		return nil, fmt.Errorf("invalid trip name")
 	}
 
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	id, err := uuid.Parse(tripID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid trip ID: %w", err)
 	}
 
-	tripInfo := &db.TripInfo{
-		ID:   id,
-		Name: input.Name,
+	tripInfo, err := utils.MapNewTripToDBTrip(id, input)
+	if err != nil {
+		return nil, err
 	}
-	if err := dbTripInfo.UpdateTripInfo(tripInfo); err != nil {
+	if err := dbTripInfo.UpdateTripInfo(ctx, tripInfo); err != nil {
 		return nil, fmt.Errorf("failed to update trip: %w", err)
 	}
 
@@ -68,13 +69,40 @@ func (r *mutationResolver) UpdateTrip(ctx context.Context, tripID string, input
 	return trip, nil
 }
 
+// DeleteTrip is the resolver for the deleteTrip field.
+func (r *mutationResolver) DeleteTrip(ctx context.Context, tripID string) (string, error) {
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
+	id, err := uuid.Parse(tripID)
+	if err != nil {
+		return "", fmt.Errorf("invalid trip ID: %w", err)
+	}
+
+	tripInfo, err := dbTripInfo.GetTripInfo(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get trip: %w", err)
+	}
+	if err := dbTripInfo.DeleteTrip(ctx, id); err != nil {
+		return "", fmt.Errorf("failed to delete trip: %w", err)
+	}
+
+	tripMQ := r.TripMessageQueueWrapper.GetTripMessageQueue(mq.ActionDelete)
+	if err := tripMQ.Publish(mq.TripMessage{
+		ID:   id,
+		Name: tripInfo.Name,
+	}); err != nil {
+		fmt.Println("Warning: fail to notice event: " + err.Error())
+	}
+
+	return tripID, nil
+}
+
 // CreateRecord is the resolver for the createRecord field.
 func (r *mutationResolver) CreateRecord(ctx context.Context, tripID string, input model.NewRecord) (*model.Record, error) {
 	if !utils.VerifyRecordRequestAndSetDefault(&input) {
 		return nil, fmt.Errorf("invalid record input")
 	}
 
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	tripUUID, err := uuid.Parse(tripID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid trip ID: %w", err)
@@ -86,20 +114,12 @@ func (r *mutationResolver) CreateRecord(ctx context.Context, tripID string, inpu
 	}
 	record.ID = uuid.New() // Set new ID for creation
 
-	if err := dbTripInfo.CreateTripRecords(tripUUID, []db.Record{*record}); err != nil {
+	if err := dbTripInfo.CreateTripRecords(ctx, tripUUID, []db.Record{*record}); err != nil {
 		return nil, fmt.Errorf("failed to create record: %w", err)
 	}
 
 	tripMQ := r.TripMessageQueueWrapper.GetTripRecordMessageQueue(mq.ActionCreate)
-	if err := tripMQ.Publish(mq.TripRecordMessage{
-		TripID:        tripUUID,
-		ID:            record.ID,
-		Name:          record.Name,
-		Amount:        record.Amount,
-		Time:          strconv.FormatInt(record.Time.UnixMilli(), 10),
-		PrePayAddress: record.PrePayAddress,
-		Category:      utils.RecordCategory2Int(input.Category),
-	}); err != nil {
+	if err := tripMQ.Publish(mq.NewTripRecordMessage(tripUUID, *record)); err != nil {
 		fmt.Println("Warning: fail to notice event: " + err.Error())
 	}
 
@@ -119,7 +139,7 @@ func (r *mutationResolver) UpdateRecord(ctx context.Context, recordID string, in
 		return nil, fmt.Errorf("invalid record input")
 	}
 
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	oldRecord, err := utils.MapNewRecordToDBRecord(*input.Old)
 	if err != nil {
 		return nil, err
@@ -155,20 +175,12 @@ func (r *mutationResolver) UpdateRecord(ctx context.Context, recordID string, in
 	}
 
 	var tripId uuid.UUID
-	if tripId, err = dbTripInfo.UpdateTripRecord(newRecord.ID, changelog); err != nil {
+	if tripId, err = dbTripInfo.UpdateTripRecord(ctx, newRecord.ID, changelog); err != nil {
 		return nil, fmt.Errorf("failed to update record: %w", err)
 	}
 
 	tripMQ := r.TripMessageQueueWrapper.GetTripRecordMessageQueue(mq.ActionUpdate)
-	if err := tripMQ.Publish(mq.TripRecordMessage{
-		TripID:        tripId,
-		ID:            newRecord.ID,
-		Name:          newRecord.Name,
-		Amount:        newRecord.Amount,
-		Time:          strconv.FormatInt(newRecord.Time.UnixMilli(), 10),
-		PrePayAddress: newRecord.PrePayAddress,
-		Category:      utils.RecordCategory2Int(input.New.Category),
-	}); err != nil {
+	if err := tripMQ.Publish(mq.NewTripRecordMessage(tripId, *newRecord)); err != nil {
 		fmt.Println("Warning: fail to notice event: " + err.Error())
 	}
 
@@ -184,20 +196,21 @@ func (r *mutationResolver) UpdateRecord(ctx context.Context, recordID string, in
 
 // RemoveRecord is the resolver for the removeRecord field.
 func (r *mutationResolver) RemoveRecord(ctx context.Context, recordID string) (string, error) {
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	recordUID, err := uuid.Parse(recordID)
 	if err != nil {
 		return "", fmt.Errorf("invalid record ID: %w", err)
 	}
 	var tripId uuid.UUID
-	if tripId, err = dbTripInfo.DeleteTripRecord(recordUID); err != nil {
+	if tripId, err = dbTripInfo.DeleteTripRecord(ctx, recordUID); err != nil {
 		return "", fmt.Errorf("failed to delete record: %w", err)
 	}
 
 	tripMQ := r.TripMessageQueueWrapper.GetTripRecordMessageQueue(mq.ActionDelete)
 	if err := tripMQ.Publish(mq.TripRecordMessage{
-		TripID: tripId,
-		ID:     recordUID,
+		TripID:      tripId,
+		ID:          recordUID,
+		PublishedAt: time.Now().UnixNano(),
 	}); err != nil {
 		fmt.Println("Warning: fail to notice event: " + err.Error())
 	}
@@ -211,20 +224,21 @@ func (r *mutationResolver) CreateAddress(ctx context.Context, tripID string, add
 		return "", fmt.Errorf("invalid address")
 	}
 
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	tripUUID, err := uuid.Parse(tripID)
 	if err != nil {
 		return "", fmt.Errorf("invalid trip ID: %w", err)
 	}
 
-	if err := dbTripInfo.TripAddressListAdd(tripUUID, db.Address(address)); err != nil {
+	if err := dbTripInfo.TripAddressListAdd(ctx, tripUUID, db.Address(address)); err != nil {
 		return "", fmt.Errorf("failed to create address: %w", err)
 	}
 
 	tripMQ := r.TripMessageQueueWrapper.GetTripAddressMessageQueue(mq.ActionCreate)
 	if err := tripMQ.Publish(mq.TripAddressMessage{
-		TripID:  tripUUID,
-		Address: db.Address(address),
+		TripID:      tripUUID,
+		Address:     db.Address(address),
+		PublishedAt: time.Now().UnixNano(),
 	}); err != nil {
 		fmt.Println("Warning: fail to notice event: " + err.Error())
 		return address, nil
@@ -235,20 +249,21 @@ func (r *mutationResolver) CreateAddress(ctx context.Context, tripID string, add
 
 // DeleteAddress is the resolver for the deleteAddress field.
 func (r *mutationResolver) DeleteAddress(ctx context.Context, tripID string, address string) (string, error) {
-	dbTripInfo := r.TripDB
+	dbTripInfo := r.TripDB.WithActor(utils.GetAuditActor(ctx))
 	tripUUID, err := uuid.Parse(tripID)
 	if err != nil {
 		return "", fmt.Errorf("invalid trip ID: %w", err)
 	}
 
-	if err := dbTripInfo.TripAddressListRemove(tripUUID, db.Address(address)); err != nil {
+	if err := dbTripInfo.TripAddressListRemove(ctx, tripUUID, db.Address(address)); err != nil {
 		return "", fmt.Errorf("failed to delete address: %w", err)
 	}
 
 	tripMQ := r.TripMessageQueueWrapper.GetTripAddressMessageQueue(mq.ActionDelete)
 	if err := tripMQ.Publish(mq.TripAddressMessage{
-		TripID:  tripUUID,
-		Address: db.Address(address),
+		TripID:      tripUUID,
+		Address:     db.Address(address),
+		PublishedAt: time.Now().UnixNano(),
 	}); err != nil {
 		fmt.Println("Warning: fail to notice event: " + err.Error())
 		return address, nil
@@ -287,6 +302,33 @@ func (r *queryResolver) Trip(ctx context.Context, tripID string) (*model.Trip, e
 	}, nil
 }
 
+// PreviewRecord is the resolver for the previewRecord field.
+func (r *queryResolver) PreviewRecord(ctx context.Context, tripID string, input model.NewRecord) (*model.RecordPreview, error) {
+	if !utils.VerifyRecordRequestAndSetDefault(&input) {
+		return nil, fmt.Errorf("invalid record input")
+	}
+
+	tripUUID, err := uuid.Parse(tripID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trip ID: %w", err)
+	}
+
+	record, err := utils.MapNewRecordToDBRecord(input)
+	if err != nil {
+		return nil, err
+	}
+
+	txPackage, diff, err := r.Settlement.PreviewRecord(ctx, r.TripDB, tripUUID, *record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to preview record: %w", err)
+	}
+
+	return &model.RecordPreview{
+		MoneyShare: utils.ToModelTxList(txPackage.TxList),
+		Changes:    utils.ToModelBalanceChangeList(diff.Changes),
+	}, nil
+}
+
 // ShouldPayAddress is the resolver for the shouldPayAddress field.
 func (r *recordResolver) ShouldPayAddress(ctx context.Context, obj *model.Record) ([]string, error) {
 	addresses, err := utils.GetShouldPayList(ctx, obj)
@@ -344,6 +386,29 @@ func (r *recordResolver) IsValid(ctx context.Context, obj *model.Record) (bool,
 	return false, nil
 }
 
+// SubTripDelete is the resolver for the subTripDelete field.
+func (r *subscriptionResolver) SubTripDelete(ctx context.Context, tripID string) (<-chan string, error) {
+	tripMQ := r.TripMessageQueueWrapper.GetTripMessageQueue(mq.ActionDelete)
+	if tripMQ == nil {
+		return nil, fmt.Errorf("can not get target message MQ")
+	}
+	tripUUID, err := uuid.Parse(tripID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trip ID: %w", err)
+	}
+
+	tripStream := make(chan string)
+
+	mq.SubscribeProcessor(
+		tripUUID,
+		ctx,
+		tripMQ,
+		utils.TripIdMQ2GQL,
+		tripStream,
+	)
+	return tripStream, nil
+}
+
 // SubRecordCreate is the resolver for the subRecordCreate field.
 func (r *subscriptionResolver) SubRecordCreate(ctx context.Context, tripID string) (<-chan *model.Record, error) {
 	tripMQ := r.TripMessageQueueWrapper.GetTripRecordMessageQueue(mq.ActionCreate)
@@ -413,6 +478,40 @@ func (r *subscriptionResolver) SubRecordUpdate(ctx context.Context, tripID strin
 	return recordStream, nil
 }
 
+// RecordChanged is the resolver for the recordChanged field.
+func (r *subscriptionResolver) RecordChanged(ctx context.Context, tripID string) (<-chan *model.RecordChange, error) {
+	tripUUID, err := uuid.Parse(tripID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trip ID: %w", err)
+	}
+
+	actions := []mq.Action{mq.ActionCreate, mq.ActionUpdate, mq.ActionDelete}
+	tripMQs := make([]mq.TripRecordMessageQueue, len(actions))
+	for i, action := range actions {
+		if tripMQs[i] = r.TripMessageQueueWrapper.GetTripRecordMessageQueue(action); tripMQs[i] == nil {
+			return nil, fmt.Errorf("can not get target message MQ")
+		}
+	}
+
+	// every action is subscribed on its own and the streams are merged into one
+	actionStreams := make([]<-chan *model.RecordChange, 0, len(actions))
+	for i, action := range actions {
+		actionStream := make(chan *model.RecordChange)
+		mq.SubscribeProcessor(
+			tripUUID,
+			ctx,
+			tripMQs[i],
+			utils.TripRecordChangeMQ2GQL(action),
+			actionStream,
+		)
+		actionStreams = append(actionStreams, actionStream)
+	}
+
+	changeStream := make(chan *model.RecordChange)
+	mq.MergeStreams(ctx, changeStream, actionStreams...)
+	return changeStream, nil
+}
+
 // SubAddressCreate is the resolver for the subAddressCreate field.
 func (r *subscriptionResolver) SubAddressCreate(ctx context.Context, tripID string) (<-chan string, error) {
 	tripMQ := r.TripMessageQueueWrapper.GetTripAddressMessageQueue(mq.ActionCreate)
@@ -495,7 +594,7 @@ func (r *tripResolver) Records(ctx context.Context, obj *model.Trip) ([]*model.R
 
 // MoneyShare is the resolver for the moneyShare field.
 func (r *tripResolver) MoneyShare(ctx context.Context, obj *model.Trip) ([]*model.Tx, error) {
-	txPackage, totalRemaining, isValid, err := utils.CalculateMoneyShare(ctx, obj)
+	txPackage, totalRemaining, isValid, err := utils.CalculateMoneyShare(ctx, r.Settlement, obj)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TxPackage: %w", err)
 	}
@@ -540,7 +639,7 @@ func (r *tripResolver) AddressList(ctx context.Context, obj *model.Trip) ([]stri
 
 // IsValid is the resolver for the isValid field.
 func (r *tripResolver) IsValid(ctx context.Context, obj *model.Trip) (bool, error) {
-	_, totalRemaining, isValid, err := utils.CalculateMoneyShare(ctx, obj)
+	_, totalRemaining, isValid, err := utils.CalculateMoneyShare(ctx, r.Settlement, obj)
 	if err != nil {
 		return false, fmt.Errorf("failed to create TxPackage: %w", err)
 	}
@@ -551,6 +650,46 @@ func (r *tripResolver) IsValid(ctx context.Context, obj *model.Trip) (bool, erro
 	return isValid, nil
 }
 
+// MoneyShareTruncated is the resolver for the moneyShareTruncated field.
+func (r *tripResolver) MoneyShareTruncated(ctx context.Context, obj *model.Trip) (bool, error) {
+	truncated, err := utils.IsMoneyShareTruncated(ctx, r.Settlement, obj)
+	if err != nil {
+		return false, fmt.Errorf("failed to create TxPackage: %w", err)
+	}
+	return truncated, nil
+}
+
+// Currency is the resolver for the currency field.
+func (r *tripResolver) Currency(ctx context.Context, obj *model.Trip) (string, error) {
+	ginCtx, err := utils.GinContextFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	dataLoader, ok := ginCtx.Value(string(db.DataLoaderKeyTripData)).(*db.TripDataLoader)
+	if !ok {
+		return "", fmt.Errorf("data loader is not available")
+	}
+
+	tripID, err := uuid.Parse(obj.ID)
+	if err != nil {
+		return "", fmt.Errorf("invalid trip ID: %w", err)
+	}
+	tripInfo, err := dataLoader.GetTripInfoList.Load(ctx, tripID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get trip info: %w", err)
+	}
+	return r.Settlement.TripCurrency(tripInfo), nil
+}
+
+// Balances is the resolver for the balances field.
+func (r *tripResolver) Balances(ctx context.Context, obj *model.Trip) ([]*model.Balance, error) {
+	balances, err := utils.TripBalances(ctx, r.Settlement, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TxPackage: %w", err)
+	}
+	return utils.ToModelBalanceList(balances), nil
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
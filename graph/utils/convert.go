@@ -3,10 +3,13 @@ package utils
 import (
 	"dtm/db/db"
 	"dtm/graph/model"
+	"dtm/service"
 
 	"dtm/tx"
 	"fmt"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 var (
@@ -62,6 +65,52 @@ func ToModelTxList(txList []tx.Tx) []*model.Tx {
 	return modelList
 }
 
+func ToModelBalanceChangeList(changes []tx.BalanceChange) []*model.BalanceChange {
+	modelList := make([]*model.BalanceChange, len(changes))
+	for i, c := range changes {
+		modelList[i] = &model.BalanceChange{
+			Address: c.Address,
+			Before:  c.Before,
+			After:   c.After,
+		}
+	}
+	return modelList
+}
+
+// ToModelBalanceList converts the net cash of each address to balances, positive amount means the address receives money.
+func ToModelBalanceList(cashList []tx.Cash) []*model.Balance {
+	modelList := make([]*model.Balance, len(cashList))
+	for i, c := range cashList {
+		modelList[i] = &model.Balance{
+			Address:  c.Address,
+			Amount:   c.OutputAmount - c.InputAmount,
+			Currency: c.Currency,
+		}
+	}
+	return modelList
+}
+
+// RecordToUserPayment converts a db record and its should pay list to tx.UserPayment
+func RecordToUserPayment(record db.RecordInfo, addresses []db.ExtendAddress) tx.UserPayment {
+	return tx.RecordToUserPayment(record, addresses)
+}
+
+// MapNewTripToDBTrip maps the trip input to db.TripInfo, an omitted currency is left empty.
+func MapNewTripToDBTrip(id uuid.UUID, input model.NewTrip) (*db.TripInfo, error) {
+	tripInfo := &db.TripInfo{
+		ID:   id,
+		Name: input.Name,
+	}
+	if input.Currency != nil {
+		currency, err := service.ParseCurrency(*input.Currency)
+		if err != nil {
+			return nil, err
+		}
+		tripInfo.Currency = currency
+	}
+	return tripInfo, nil
+}
+
 // MapNewRecordToDBRecord This function can be in the graph package or a utils package
 func MapNewRecordToDBRecord(input model.NewRecord) (*db.Record, error) {
 	var t time.Time
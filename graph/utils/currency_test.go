@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"dtm/db/db"
+	"dtm/db/dbtest"
+	"dtm/db/mem"
+	"dtm/graph/model"
+	"dtm/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateMoneyShare_TripCurrency(t *testing.T) {
+	settlement := service.SettlementConfig{DefaultCurrency: "EUR", DefaultRoundingPrecision: 0.5}
+
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	defaultTrip, yenTrip := uuid.New(), uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: defaultTrip, Name: "default trip"}, "Alice", "Bob", "Carol")
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: yenTrip, Name: "tokyo trip", Currency: "JPY"}, "Alice", "Bob", "Carol")
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), defaultTrip, []db.Record{
+		dbtest.NewGroupRecord("lunch", 10, "Alice", []db.Address{"Alice", "Bob", "Carol"}, uuid.Nil),
+	}))
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), yenTrip, []db.Record{
+		dbtest.NewGroupRecord("ramen", 1000, "Alice", []db.Address{"Alice", "Bob", "Carol"}, uuid.Nil),
+	}))
+
+	gin.SetMode(gin.TestMode)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+	ctx := context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+
+	t.Run("Trip without currency uses the default", func(t *testing.T) {
+		pkg, remaining, isValid, err := CalculateMoneyShare(ctx, settlement, &model.Trip{ID: defaultTrip.String()})
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		assert.Zero(t, remaining)
+		require.Len(t, pkg.TxList, 1)
+		require.Len(t, pkg.TxList[0].Input, 2)
+		for _, input := range pkg.TxList[0].Input {
+			assert.Equal(t, "EUR", input.Currency)
+			// 3.33 is rounded up to the default precision of 0.5
+			assert.InDelta(t, 3.5, input.Amount, 1e-9)
+			assert.Equal(t, "3.5", settlement.FormatAmount(input.Amount, input.Currency))
+		}
+		assert.InDelta(t, 7, pkg.TxList[0].Output.Amount, 1e-9)
+
+		info, err := tripDB.GetTripInfo(t.Context(), defaultTrip)
+		require.NoError(t, err)
+		assert.Equal(t, "EUR", settlement.TripCurrency(info))
+	})
+
+	t.Run("Explicit currency overrides the default", func(t *testing.T) {
+		pkg, remaining, isValid, err := CalculateMoneyShare(ctx, settlement, &model.Trip{ID: yenTrip.String()})
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		assert.Zero(t, remaining)
+		require.Len(t, pkg.TxList, 1)
+		require.Len(t, pkg.TxList[0].Input, 2)
+		for _, input := range pkg.TxList[0].Input {
+			assert.Equal(t, "JPY", input.Currency)
+			// 333.33 is rounded up to whole yen
+			assert.InDelta(t, 334, input.Amount, 1e-9)
+			assert.Equal(t, "334", settlement.FormatAmount(input.Amount, input.Currency))
+		}
+
+		settled, _, err := settlement.SettleTrip(t.Context(), tripDB, yenTrip)
+		require.NoError(t, err)
+		assert.Equal(t, pkg.TxList, settled.TxList, "settlement outside the request rounds the same")
+	})
+}
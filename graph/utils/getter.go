@@ -3,109 +3,184 @@ package utils
 import (
 	"context"
 	"dtm/graph/model"
+	"dtm/service"
 	"dtm/tx"
 	"fmt"
+	"sync"
 
 	"dtm/db/db"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type CalculateMoneyShareResult struct {
 	txPackage      *tx.Package
 	totalRemaining float64
+	balances       []tx.Cash
 	err            error
 	isValid        bool
+	truncated      bool
 }
 
-type tripMoneyShareKeyType struct {
-	key string
+// TripMoneyShareKey is the gin context key of the request scoped money share cache.
+const TripMoneyShareKey = "trip_money_share"
+
+// moneyShareCache holds the settlement of each trip resolved in one request,
+// moneyShare and isValid of the same trip are resolved concurrently and share one calculation.
+type moneyShareCache struct {
+	mu      sync.Mutex
+	entries map[string]*moneyShareEntry
+	// records left in the budget of the request, negative when the budget is unlimited
+	recordsLeft int
+}
+
+type moneyShareEntry struct {
+	once   sync.Once
+	result CalculateMoneyShareResult
+}
+
+// moneyShareCacheMu guards creating the cache, gin context has no get-or-set.
+var moneyShareCacheMu sync.Mutex
+
+// settleSummary is replaced in tests to count the calculations.
+var settleSummary = service.SettlementConfig.SettleSummary
+
+// getMoneyShareCache returns the cache of the request, it is created on first use with the record budget
+// and stored once in the gin context.
+func getMoneyShareCache(ginCtx *gin.Context, budget int) *moneyShareCache {
+	moneyShareCacheMu.Lock()
+	defer moneyShareCacheMu.Unlock()
+	if cache, ok := ginCtx.Value(TripMoneyShareKey).(*moneyShareCache); ok {
+		return cache
+	}
+	cache := &moneyShareCache{entries: make(map[string]*moneyShareEntry), recordsLeft: -1}
+	if budget > 0 {
+		cache.recordsLeft = budget
+	}
+	ginCtx.Set(TripMoneyShareKey, cache)
+	return cache
+}
+
+func (c *moneyShareCache) entry(tripID string) *moneyShareEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[tripID]
+	if !ok {
+		e = &moneyShareEntry{}
+		c.entries[tripID] = e
+	}
+	return e
+}
+
+// take reserves up to n records of the budget and returns how many were granted.
+func (c *moneyShareCache) take(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recordsLeft < 0 {
+		return n
+	}
+	granted := min(n, c.recordsLeft)
+	c.recordsLeft -= granted
+	return granted
+}
+
+// CalculateMoneyShare calculates the settlement of the trip, the result is cached for the rest of the request.
+func CalculateMoneyShare(ctx context.Context, settlement service.SettlementConfig, obj *model.Trip) (*tx.Package, float64, bool, error) {
+	result, err := cachedMoneyShare(ctx, settlement, obj)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return result.txPackage, result.totalRemaining, result.isValid, result.err
 }
 
-var TripMoneyShareKey = tripMoneyShareKeyType{
-	key: "trip_money_share",
+// IsMoneyShareTruncated reports whether the settlement of the trip left out records over the RecordBudget of settlement,
+// it shares the cached calculation of CalculateMoneyShare.
+func IsMoneyShareTruncated(ctx context.Context, settlement service.SettlementConfig, obj *model.Trip) (bool, error) {
+	result, err := cachedMoneyShare(ctx, settlement, obj)
+	if err != nil {
+		return false, err
+	}
+	return result.truncated, result.err
 }
 
-func CalculateMoneyShare(ctx context.Context, obj *model.Trip) (*tx.Package, float64, bool, error) {
-	if result, ok := ctx.Value(TripMoneyShareKey).(CalculateMoneyShareResult); ok {
-		return result.txPackage, result.totalRemaining, result.isValid, result.err
+// TripBalances returns the net balance of every address of the trip before transfers, by tx.TripSummary,
+// it shares the cached calculation of CalculateMoneyShare and is kept when the trip can not be settled.
+func TripBalances(ctx context.Context, settlement service.SettlementConfig, obj *model.Trip) ([]tx.Cash, error) {
+	result, err := cachedMoneyShare(ctx, settlement, obj)
+	if err != nil {
+		return nil, err
 	}
+	return result.balances, result.err
+}
 
+func cachedMoneyShare(ctx context.Context, settlement service.SettlementConfig, obj *model.Trip) (CalculateMoneyShareResult, error) {
 	ginCtx, err := GinContextFromContext(ctx)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("failed to get Gin context: %w", err)
+		return CalculateMoneyShareResult{}, fmt.Errorf("failed to get Gin context: %w", err)
 	}
+	cache := getMoneyShareCache(ginCtx, settlement.RecordBudget)
+	e := cache.entry(obj.ID)
+	e.once.Do(func() {
+		e.result = calculateMoneyShare(ctx, settlement, ginCtx, cache, obj)
+	})
+	return e.result, nil
+}
+
+// calculateMoneyShare settles the records of the trip within the record budget of the request,
+// the records which come first are kept when the budget runs out.
+func calculateMoneyShare(ctx context.Context, settlement service.SettlementConfig, ginCtx *gin.Context, cache *moneyShareCache, obj *model.Trip) CalculateMoneyShareResult {
 	dataLoader, ok := ginCtx.Value(string(db.DataLoaderKeyTripData)).(*db.TripDataLoader)
 	if !ok {
-		return nil, 0, false, fmt.Errorf("data loader is not available")
+		return CalculateMoneyShareResult{err: fmt.Errorf("data loader is not available")}
 	}
 	tripID, err := uuid.Parse(obj.ID)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("invalid trip ID: %w", err)
+		return CalculateMoneyShareResult{err: fmt.Errorf("invalid trip ID: %w", err)}
 	}
 	records, err := dataLoader.GetRecordInfoList.Load(ctx, tripID)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("failed to get records for trip %s: %w", tripID, err)
+		return CalculateMoneyShareResult{err: fmt.Errorf("failed to get records for trip %s: %w", tripID, err)}
 	}
+	granted := cache.take(len(records))
+	truncated := granted < len(records)
+	records = records[:granted]
 
-	recordAddresses := make([][]db.ExtendAddress, len(records))
-	for i, record := range records {
-		recordAddresses[i], err = dataLoader.GetRecordShouldPayList.Load(ctx, record.ID)
+	shouldPay := make(map[uuid.UUID][]db.ExtendAddress, len(records))
+	for _, record := range records {
+		shouldPay[record.ID], err = dataLoader.GetRecordShouldPayList.Load(ctx, record.ID)
 		if err != nil {
-			err = fmt.Errorf("failed to get should pay addresses for record %s: %w", record.ID, err)
-			ctx = context.WithValue(ctx, TripMoneyShareKey, CalculateMoneyShareResult{err: err})
-			if ctx == nil {
-				return nil, 0, false, err
-			}
-			return nil, 0, false, err
+			return CalculateMoneyShareResult{err: fmt.Errorf("failed to get should pay addresses for record %s: %w", record.ID, err)}
 		}
 	}
 
-	payments := make([]tx.UserPayment, 0, len(records))
-	for i, record := range records {
-		if record.Amount <= 0 {
-			continue
-		}
-		payment := tx.UserPayment{
-			Name:             record.Name,
-			Amount:           record.Amount,
-			PrePayAddress:    string(record.PrePayAddress),
-			ShouldPayAddress: make([]string, len(recordAddresses[i])),
-			ExtendPayMsg:     make([]float64, len(recordAddresses[i])),
-			PaymentType:      int(record.Category),
-		}
-		for j, addr := range recordAddresses[i] {
-			payment.ShouldPayAddress[j] = string(addr.Address)
-			payment.ExtendPayMsg[j] = addr.ExtendMsg
-		}
-		payments = append(payments, payment)
-	}
-
-	txPackage, totalRemaining, err := tx.ShareMoneyEasy(payments)
-	if err == nil {
-		ctx = context.WithValue(ctx, TripMoneyShareKey, CalculateMoneyShareResult{
-			txPackage:      &txPackage,
-			totalRemaining: totalRemaining,
-			err:            nil,
-			isValid:        true,
-		})
-		if ctx == nil {
-			return &txPackage, totalRemaining, true, fmt.Errorf("context is nil after setting trip money share result")
-		}
-		return &txPackage, totalRemaining, true, nil
+	payments, err := settlement.RecordsToUserPayments(records, shouldPay)
+	if err != nil {
+		return CalculateMoneyShareResult{err: err}
+	}
+	tripInfo, err := dataLoader.GetTripInfoList.Load(ctx, tripID)
+	if err != nil {
+		return CalculateMoneyShareResult{err: fmt.Errorf("failed to get trip info %s: %w", tripID, err)}
 	}
 
-	ctx = context.WithValue(ctx, TripMoneyShareKey, CalculateMoneyShareResult{
-		txPackage:      nil,
-		totalRemaining: 0,
-		err:            nil,
-		isValid:        false,
-	})
-	if ctx == nil {
-		return &txPackage, totalRemaining, false, fmt.Errorf("context is nil after setting trip money share result")
+	currency := settlement.TripCurrency(tripInfo)
+	balances, err := settlement.SummaryInCurrency(payments, currency)
+	if err != nil {
+		// records which can not be settled make the trip invalid, it is not a resolver error
+		return CalculateMoneyShareResult{isValid: false, truncated: truncated}
+	}
+	txPackage, totalRemaining, err := settleSummary(settlement, balances, currency)
+	if err != nil {
+		// the balances are what the records say, they are shown even when no settlement is found for them
+		return CalculateMoneyShareResult{balances: balances, isValid: false, truncated: truncated}
+	}
+	return CalculateMoneyShareResult{
+		txPackage:      &txPackage,
+		totalRemaining: totalRemaining,
+		balances:       balances,
+		isValid:        true,
+		truncated:      truncated,
 	}
-	// println("Error in ShareMoneyEasy:", err)
-	return nil, 0, false, nil
 }
 
 func GetShouldPayList(ctx context.Context, obj *model.Record) ([]db.ExtendAddress, error) {
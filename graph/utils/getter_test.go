@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"dtm/db/db"
+	"dtm/db/dbtest"
+	"dtm/db/mem"
+	"dtm/graph/model"
+	"dtm/service"
+	"dtm/tx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateMoneyShare_CachedPerRequest(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, tripID := range tripIDs {
+		dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "cached trip"}, "Alice", "Bob", "Carol")
+		require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+			dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+		}))
+	}
+
+	var calls int
+	var callsMu sync.Mutex
+	original := settleSummary
+	settleSummary = func(settlement service.SettlementConfig, summary []tx.Cash, currency string) (tx.Package, float64, error) {
+		callsMu.Lock()
+		calls++
+		callsMu.Unlock()
+		return original(settlement, summary, currency)
+	}
+	t.Cleanup(func() { settleSummary = original })
+
+	newRequestContext := func() context.Context {
+		gin.SetMode(gin.TestMode)
+		ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+		return context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+	}
+
+	ctx := newRequestContext()
+	trip := &model.Trip{ID: tripIDs[0].String()}
+	// moneyShare and isValid of one trip are resolved concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pkg, remaining, isValid, err := CalculateMoneyShare(ctx, service.SettlementConfig{}, trip)
+			assert.NoError(t, err)
+			assert.True(t, isValid)
+			assert.Zero(t, remaining)
+			assert.Len(t, pkg.TxList, 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls, "one trip in one request is calculated once")
+
+	_, _, _, err := CalculateMoneyShare(ctx, service.SettlementConfig{}, &model.Trip{ID: tripIDs[1].String()})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls, "another trip in the same request is calculated on its own")
+
+	_, _, _, err = CalculateMoneyShare(newRequestContext(), service.SettlementConfig{}, trip)
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls, "cache does not outlive the request")
+}
+
+func TestTripBalances(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "balance trip"}, "Alice", "Bob")
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+	}))
+
+	gin.SetMode(gin.TestMode)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+	ctx := context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+
+	balances, err := TripBalances(ctx, service.SettlementConfig{}, &model.Trip{ID: tripID.String()})
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Balance{
+		{Address: "Alice", Amount: 15},
+		{Address: "Bob", Amount: -15},
+	}, ToModelBalanceList(balances))
+}
+
+func TestTripBalances_SettlementFails(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "unsettled trip", Currency: "JPY"}, "Alice", "Bob")
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 3000, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+	}))
+
+	original := settleSummary
+	settleSummary = func(service.SettlementConfig, []tx.Cash, string) (tx.Package, float64, error) {
+		return tx.Package{}, 0, errors.New("no settlement found")
+	}
+	t.Cleanup(func() { settleSummary = original })
+
+	gin.SetMode(gin.TestMode)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+	ctx := context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+	trip := &model.Trip{ID: tripID.String()}
+
+	_, _, isValid, err := CalculateMoneyShare(ctx, service.SettlementConfig{}, trip)
+	require.NoError(t, err)
+	assert.False(t, isValid)
+
+	balances, err := TripBalances(ctx, service.SettlementConfig{}, trip)
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Balance{
+		{Address: "Alice", Amount: 1500, Currency: "JPY"},
+		{Address: "Bob", Amount: -1500, Currency: "JPY"},
+	}, ToModelBalanceList(balances))
+}
+
+func TestCalculateMoneyShare_RecordBudget(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	bigTrip, smallTrip := uuid.New(), uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: bigTrip, Name: "big trip"}, "Alice", "Bob", "Carol")
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: smallTrip, Name: "small trip"}, "Dave", "Erin")
+	lunch := dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil)
+	hotel := dbtest.NewGroupRecord("hotel", 90, "Bob", []db.Address{"Alice", "Bob", "Carol"}, uuid.Nil)
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), bigTrip, []db.Record{
+		lunch,
+		hotel,
+		dbtest.NewGroupRecord("taxi", 20, "Carol", []db.Address{"Alice", "Carol"}, uuid.Nil),
+	}))
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), smallTrip, []db.Record{
+		dbtest.NewGroupRecord("coffee", 10, "Dave", []db.Address{"Dave", "Erin"}, uuid.Nil),
+	}))
+
+	settlement := service.SettlementConfig{RecordBudget: 2}
+
+	gin.SetMode(gin.TestMode)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+	ctx := context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+
+	// the first two records of the big trip use up the budget
+	trip := &model.Trip{ID: bigTrip.String()}
+	pkg, remaining, isValid, err := CalculateMoneyShare(ctx, settlement, trip)
+	require.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Zero(t, remaining)
+	truncated, err := IsMoneyShareTruncated(ctx, settlement, trip)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	partial, _, err := tx.ShareMoneyEasy([]tx.UserPayment{
+		RecordToUserPayment(lunch.RecordInfo, lunch.ShouldPayAddress),
+		RecordToUserPayment(hotel.RecordInfo, hotel.ShouldPayAddress),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, partial.TxList, pkg.TxList, "partial result settles the records within the budget")
+
+	// nothing is left for the next trip of the request
+	small := &model.Trip{ID: smallTrip.String()}
+	pkg, _, isValid, err = CalculateMoneyShare(ctx, settlement, small)
+	require.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Empty(t, pkg.TxList)
+	truncated, err = IsMoneyShareTruncated(ctx, settlement, small)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+
+	t.Run("Trip within the budget is not truncated", func(t *testing.T) {
+		ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ginCtx.Set(string(db.DataLoaderKeyTripData), db.NewTripDataLoader(tripDB))
+		ctx := context.WithValue(context.Background(), GinContextKeyValue, ginCtx)
+
+		pkg, _, isValid, err := CalculateMoneyShare(ctx, settlement, small)
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		assert.Len(t, pkg.TxList, 1)
+		truncated, err := IsMoneyShareTruncated(ctx, settlement, small)
+		require.NoError(t, err)
+		assert.False(t, truncated)
+	})
+}
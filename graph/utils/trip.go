@@ -8,6 +8,14 @@ import (
 	"github.com/google/uuid"
 )
 
+func TripIdMQ2GQL(msg mq.TripMessage) (string, bool, error) {
+	if msg.ID == uuid.Nil {
+		return "", true, nil
+	}
+
+	return msg.ID.String(), false, nil
+}
+
 func TripRecordMQ2GQL(msg mq.TripRecordMessage) (*model.Record, bool, error) {
 	if msg.ID == uuid.Nil {
 		// do not have record
@@ -26,6 +34,32 @@ func TripRecordMQ2GQL(msg mq.TripRecordMessage) (*model.Record, bool, error) {
 	return record, false, nil
 }
 
+var recordActionMQ2GQL = map[mq.Action]model.RecordAction{
+	mq.ActionCreate: model.RecordActionCreate,
+	mq.ActionUpdate: model.RecordActionUpdate,
+	mq.ActionDelete: model.RecordActionDelete,
+}
+
+// TripRecordChangeMQ2GQL returns a transform of record messages published for action to RecordChange,
+// the record is only set for create and update.
+func TripRecordChangeMQ2GQL(action mq.Action) func(msg mq.TripRecordMessage) (*model.RecordChange, bool, error) {
+	return func(msg mq.TripRecordMessage) (*model.RecordChange, bool, error) {
+		recordAction, ok := recordActionMQ2GQL[action]
+		if !ok {
+			return nil, false, fmt.Errorf("unsupported record action %s", action)
+		}
+		if msg.ID == uuid.Nil {
+			return nil, true, nil
+		}
+
+		change := &model.RecordChange{Action: recordAction, RecordID: msg.ID.String()}
+		if action != mq.ActionDelete {
+			change.Record, _, _ = TripRecordMQ2GQL(msg)
+		}
+		return change, false, nil
+	}
+}
+
 func TripRecordIdMQ2GQL(msg mq.TripRecordMessage) (string, bool, error) {
 	if msg.ID == uuid.Nil {
 		return "", true, nil
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 
+	"dtm/db/db"
+	"dtm/mq/mq"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,3 +28,29 @@ func GinContextFromContext(ctx context.Context) (*gin.Context, error) {
 	}
 	return gc, nil
 }
+
+// GetTripMessageQueueWrapper returns the message queue wrapper injected by MQWrapperInjectionMiddleware.
+func GetTripMessageQueueWrapper(ctx context.Context) (mq.TripMessageQueueWrapper, error) {
+	ginCtx, err := GinContextFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Gin context: %w", err)
+	}
+	wrapper, ok := ginCtx.Value(string(mq.WrapperKeyTripMessageQueue)).(mq.TripMessageQueueWrapper)
+	if !ok {
+		return nil, fmt.Errorf("message queue wrapper is not available")
+	}
+	return wrapper, nil
+}
+
+// GetAuditActor returns the actor set by AuditActorMiddleware, requests without it are anonymous.
+func GetAuditActor(ctx context.Context) string {
+	ginCtx, err := GinContextFromContext(ctx)
+	if err != nil {
+		return db.AuditActorAnonymous
+	}
+	actor, ok := ginCtx.Value(string(db.AuditActorKey)).(string)
+	if !ok || actor == "" {
+		return db.AuditActorAnonymous
+	}
+	return actor
+}
@@ -0,0 +1,16 @@
+package decimal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Places returns the number of decimal places in the shortest representation of v.
+func Places(v float64) int {
+	str := strconv.FormatFloat(v, 'f', -1, 64)
+	dot := strings.IndexByte(str, '.')
+	if dot == -1 {
+		return 0
+	}
+	return len(str) - dot - 1
+}
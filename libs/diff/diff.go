@@ -8,7 +8,7 @@ import (
 )
 
 func GetCustomDiffer() *odiff.Differ {
-	ret, err := odiff.NewDiffer(odiff.CustomValueDiffers(&UUIDComparer{}))
+	ret, err := odiff.NewDiffer(odiff.CustomValueDiffers(&UUIDComparer{}, &NamedKeyMapComparer{}))
 	if err != nil {
 		panic(err)
 	}
@@ -58,3 +58,48 @@ func (c UUIDComparer) Diff(_ odiff.DiffType, _ odiff.DiffFunc, cl *odiff.Changel
 func (c UUIDComparer) InsertParentDiffer(_ func(path []string, a reflect.Value, b reflect.Value, p interface{}) error) {
 	// do not thing
 }
+
+// NamedKeyMapComparer compares maps keyed by a named string type, e.g. map[db.Address]float64, as a leaf.
+// The patch of a change inside such a map can not convert the string path element back to the key type,
+// so the whole map is replaced instead.
+type NamedKeyMapComparer struct{}
+
+var (
+	stringType = reflect.TypeOf("")
+)
+
+// isNamedKeyMap reports whether v is a map whose key is a string kind other than string.
+func isNamedKeyMap(v reflect.Value) bool {
+	return v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Key() != stringType
+}
+
+// Match check is field match this custom type
+func (c NamedKeyMapComparer) Match(a, b reflect.Value) bool {
+	return (isNamedKeyMap(a) && isNamedKeyMap(b)) || (a.Kind() == reflect.Invalid && isNamedKeyMap(b)) || (b.Kind() == reflect.Invalid && isNamedKeyMap(a))
+}
+
+// Diff check is diff or not
+func (c NamedKeyMapComparer) Diff(_ odiff.DiffType, _ odiff.DiffFunc, cl *odiff.Changelog, path []string, a reflect.Value, b reflect.Value, _ interface{}) error {
+	var valA, valB interface{}
+	lenA, lenB := 0, 0
+	if a.IsValid() {
+		valA, lenA = a.Interface(), a.Len()
+	}
+	if b.IsValid() {
+		valB, lenB = b.Interface(), b.Len()
+	}
+	// a nil and an empty map are the same
+	if lenA == 0 && lenB == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(valA, valB) {
+		cl.Add(odiff.UPDATE, path, valA, valB)
+	}
+	return nil
+}
+
+// InsertParentDiffer do something with parent，
+// the map is a leaf, so do not thing
+func (c NamedKeyMapComparer) InsertParentDiffer(_ func(path []string, a reflect.Value, b reflect.Value, p interface{}) error) {
+	// do not thing
+}
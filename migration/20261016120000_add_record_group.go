@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upAddRecordGroup, downAddRecordGroup)
+}
+
+func upAddRecordGroup(ctx context.Context, tx *sql.Tx) error {
+	// Add optional sub-activity group to 'records' table
+	// records without group keep group_id as NULL
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE records
+		ADD COLUMN group_id UUID,
+		ADD COLUMN group_name VARCHAR(255) NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		return err
+	}
+
+	// index for settling a group of one trip
+	_, err = tx.ExecContext(ctx, `
+		CREATE INDEX idx_records_trip_id_group_id ON records (trip_id, group_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downAddRecordGroup(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP INDEX IF EXISTS idx_records_trip_id_group_id;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Remove group columns from 'records' table
+	_, err = tx.ExecContext(ctx, `
+		ALTER TABLE records
+		DROP COLUMN IF EXISTS group_id,
+		DROP COLUMN IF EXISTS group_name;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
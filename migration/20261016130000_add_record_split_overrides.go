@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upAddRecordSplitOverrides, downAddRecordSplitOverrides)
+}
+
+func upAddRecordSplitOverrides(ctx context.Context, tx *sql.Tx) error {
+	// Add split parameters keyed by address to 'records' table
+	// records without overrides keep split_overrides as NULL
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE records
+		ADD COLUMN split_overrides JSONB;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downAddRecordSplitOverrides(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE records
+		DROP COLUMN IF EXISTS split_overrides;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upAddAuditLog, downAddAuditLog)
+}
+
+func upAddAuditLog(ctx context.Context, tx *sql.Tx) error {
+	// Create 'audit_log' table, no foreign key to trips so the log outlives a deleted trip
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE audit_log (
+			id UUID PRIMARY KEY,
+			trip_id UUID NOT NULL,
+			target_id UUID NOT NULL,
+			operation VARCHAR(64) NOT NULL,
+			actor VARCHAR(255) NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// index for reading the log of one trip in time order
+	_, err = tx.ExecContext(ctx, `
+		CREATE INDEX idx_audit_log_trip_id_created_at ON audit_log (trip_id, created_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downAddAuditLog(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TABLE IF EXISTS audit_log;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
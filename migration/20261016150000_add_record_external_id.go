@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upAddRecordExternalID, downAddRecordExternalID)
+}
+
+func upAddRecordExternalID(ctx context.Context, tx *sql.Tx) error {
+	// Add client supplied external ID to 'records' table
+	// records without external ID keep external_id as NULL, NULLs never conflict
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE records
+		ADD COLUMN external_id VARCHAR(255);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// unique per trip, target of the ON CONFLICT of record creation
+	_, err = tx.ExecContext(ctx, `
+		ALTER TABLE records
+		ADD CONSTRAINT uq_records_trip_id_external_id UNIQUE (trip_id, external_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downAddRecordExternalID(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE records
+		DROP CONSTRAINT IF EXISTS uq_records_trip_id_external_id;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		ALTER TABLE records
+		DROP COLUMN IF EXISTS external_id;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
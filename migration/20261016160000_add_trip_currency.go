@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upAddTripCurrency, downAddTripCurrency)
+}
+
+func upAddTripCurrency(ctx context.Context, tx *sql.Tx) error {
+	// Add ISO 4217 currency code to 'trips' table
+	// existing trips keep an empty currency and settle in the deployment default
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE trips
+		ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downAddTripCurrency(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE trips
+		DROP COLUMN IF EXISTS currency;
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/pressly/goose/v3"
+
+	"dtm/config"
+)
+
+// Dir is the directory of migration sources in the embedded sources, the binary migrates without the repo on disk.
+const Dir = "."
+
+// sources are the migration files compiled into the binary, goose matches them to the registered go migrations.
+//
+//go:embed [0-9]*.go
+var sources embed.FS
+
+// MigrationStatus is the state of one migration in the database.
+type MigrationStatus struct {
+	Version   int64     `json:"version"`
+	Source    string    `json:"source"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"appliedAt,omitempty"`
+}
+
+// gooseMu serializes the goose global API, migrate can be triggered by cli and http at the same time.
+var gooseMu sync.Mutex
+
+// Open connects to postgres and points the connection to the app schema, the schema is created if not exists.
+func Open(ctx context.Context, connStr string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// search path is a session setting, keep one connection so it applies to every statement
+	db.SetMaxOpenConns(1)
+
+	pingCtx, pingCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer pingCancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	// create app schema if not exists
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", config.AppName)); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	// set search path to target schema
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("SET search_path TO %s", config.AppName)); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to set search path: %w", err)
+	}
+	return db, nil
+}
+
+// setup points goose to the embedded sources and postgres, the caller holds gooseMu.
+func setup() error {
+	goose.SetBaseFS(sources)
+	return goose.SetDialect("postgres")
+}
+
+// collect returns every migration in dir of the embedded sources, the caller holds gooseMu.
+func collect(dir string) (goose.Migrations, error) {
+	migrations, err := goose.CollectMigrations(dir, 0, goose.MaxVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect migrations: %w", err)
+	}
+	return migrations, nil
+}
+
+// Up applies all pending migrations in dir, it is a no-op when db is already current.
+func Up(ctx context.Context, db *sql.DB, dir string) error {
+	gooseMu.Lock()
+	defer gooseMu.Unlock()
+
+	if err := setup(); err != nil {
+		return err
+	}
+	return goose.UpContext(ctx, db, dir)
+}
+
+// Down rolls back the last applied migration in dir.
+func Down(ctx context.Context, db *sql.DB, dir string) error {
+	gooseMu.Lock()
+	defer gooseMu.Unlock()
+
+	if err := setup(); err != nil {
+		return err
+	}
+	return goose.DownContext(ctx, db, dir)
+}
+
+// Status returns the current db version and the state of every migration in dir.
+func Status(ctx context.Context, db *sql.DB, dir string) (int64, []MigrationStatus, error) {
+	gooseMu.Lock()
+	defer gooseMu.Unlock()
+
+	if err := setup(); err != nil {
+		return 0, nil, err
+	}
+	migrations, err := collect(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	version, err := goose.EnsureDBVersionContext(ctx, db)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to ensure db version: %w", err)
+	}
+
+	applied := make(map[int64]time.Time)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT version_id, tstamp FROM %s WHERE is_applied", goose.TableName()))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int64
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return 0, nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.Version,
+			Source:    filepath.Base(m.Source),
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return version, statuses, nil
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollect_OutsideRepo(t *testing.T) {
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, e := range entries {
+		if name := e.Name(); name[0] >= '0' && name[0] <= '9' && filepath.Ext(name) == ".go" {
+			want = append(want, name)
+		}
+	}
+
+	// the server binary runs from its own dir, no migration source is on disk
+	t.Chdir(t.TempDir())
+
+	gooseMu.Lock()
+	defer gooseMu.Unlock()
+	if err := setup(); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := collect(Dir)
+	if err != nil {
+		t.Fatalf("collect from embedded sources: %v", err)
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(want))
+	}
+	for i, m := range migrations {
+		if got := filepath.Base(m.Source); got != want[i] {
+			t.Errorf("migration %d source = %s, want %s", i, got, want[i])
+		}
+		if !m.Registered {
+			t.Errorf("migration %s is not registered", m.Source)
+		}
+	}
+}
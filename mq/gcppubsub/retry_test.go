@@ -0,0 +1,125 @@
+package gcppubsub
+
+import (
+	"context"
+	"dtm/mq/mq"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var fastRetry = RetryConfig{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestRetryWithBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error // error of each call, calls after the list succeed
+		wantCalls int
+		wantErr   codes.Code
+	}{
+		{name: "Transient error is retried", errs: []error{status.Error(codes.Unavailable, "try again")}, wantCalls: 2, wantErr: codes.OK},
+		{name: "Attempts are bounded", errs: []error{
+			status.Error(codes.ResourceExhausted, "quota"),
+			status.Error(codes.ResourceExhausted, "quota"),
+			status.Error(codes.ResourceExhausted, "quota"),
+			status.Error(codes.ResourceExhausted, "quota"),
+		}, wantCalls: 3, wantErr: codes.ResourceExhausted},
+		{name: "Permanent error is not retried", errs: []error{status.Error(codes.PermissionDenied, "denied")}, wantCalls: 1, wantErr: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			_, err := retryWithBackoff(context.Background(), fastRetry, "test", func() (int, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return 0, tt.errs[calls-1]
+				}
+				return calls, nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if status.Code(err) != tt.wantErr {
+				t.Errorf("error = %v, want code %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSubscribe_RetryCreateSubscription needs the Pub/Sub emulator like the tests of trip_test.go.
+func TestSubscribe_RetryCreateSubscription(t *testing.T) {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		t.Skip("Skipping test: PUBSUB_EMULATOR_HOST environment variable not set. Please start the Pub/Sub emulator.")
+	}
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "test-project")
+	if err != nil {
+		t.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	tests := []struct {
+		name string
+		// fail is called on the first attempt instead of creating the subscription
+		fail func(id string, config pubsub.SubscriptionConfig) error
+	}{
+		{name: "First attempt fails", fail: func(string, pubsub.SubscriptionConfig) error {
+			return status.Error(codes.Unavailable, "simulated outage")
+		}},
+		{name: "First attempt is taken by the server but fails", fail: func(id string, config pubsub.SubscriptionConfig) error {
+			if _, err := client.CreateSubscription(ctx, id, config); err != nil {
+				return err
+			}
+			return status.Error(codes.DeadlineExceeded, "simulated lost response")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			create := func(ctx context.Context, id string, config pubsub.SubscriptionConfig) (*pubsub.Subscription, error) {
+				calls++
+				if calls == 1 {
+					return nil, tt.fail(id, config)
+				}
+				return client.CreateSubscription(ctx, id, config)
+			}
+			service, err := NewGenericPubSubService[mq.TripRecordMessage](ctx, client, "retry-"+uuid.NewString(),
+				WithRetry(fastRetry), withCreateSubscription(create))
+			if err != nil {
+				t.Fatalf("NewGenericPubSubService() error: %v", err)
+			}
+			defer func() { _ = service.shutdown(true) }()
+
+			tripID := uuid.New()
+			subID, ch, err := service.Subscribe(tripID)
+			if err != nil {
+				t.Fatalf("Subscribe() error: %v", err)
+			}
+			if calls != 2 {
+				t.Errorf("create subscription calls = %d, want 2", calls)
+			}
+
+			time.Sleep(500 * time.Millisecond) // let the receiver start
+			want := mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: "retried"}
+			if err := service.Publish(want); err != nil {
+				t.Fatalf("Publish() error: %v", err)
+			}
+			select {
+			case got := <-ch:
+				if got.ID != want.ID {
+					t.Errorf("received %v, want %v", got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("timeout waiting for message on the retried subscription")
+			}
+			if err := service.DeSubscribe(subID); err != nil {
+				t.Errorf("DeSubscribe() error: %v", err)
+			}
+		})
+	}
+}
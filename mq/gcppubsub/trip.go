@@ -13,15 +13,94 @@ import (
 
 	"cloud.google.com/go/pubsub"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	tripIDAttribute = "tripId"
 )
 
+const (
+	defaultRetryAttempts     = 4
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay     = 2 * time.Second
+)
+
+// RetryConfig bounds the retries of creating topics and subscriptions, which fail transiently on quota
+// or eventual consistency. Zero fields keep the default of 4 attempts waiting from 100ms doubled up to 2s.
+type RetryConfig struct {
+	Attempts     int           // total attempts of one call, 1 tries only once
+	InitialDelay time.Duration // wait after the first failed attempt, doubled after each one
+	MaxDelay     time.Duration // upper bound of the wait between attempts
+}
+
+type ServiceOption func(*serviceOptions)
+
+type serviceOptions struct {
+	retry              RetryConfig
+	createSubscription createSubscriptionFunc
+}
+
+// createSubscriptionFunc creates a subscription like pubsub.Client.CreateSubscription.
+type createSubscriptionFunc func(ctx context.Context, id string, config pubsub.SubscriptionConfig) (*pubsub.Subscription, error)
+
+// WithRetry sets how creating topics and subscriptions is retried.
+func WithRetry(config RetryConfig) ServiceOption {
+	return func(o *serviceOptions) {
+		o.retry = config
+	}
+}
+
+// withCreateSubscription replaces the call creating subscriptions, e.g. to fail it in tests.
+func withCreateSubscription(create createSubscriptionFunc) ServiceOption {
+	return func(o *serviceOptions) {
+		o.createSubscription = create
+	}
+}
+
+// isRetryable reports whether a failed call of the Pub/Sub admin API may succeed when called again.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.Internal, codes.NotFound:
+		return true
+	}
+	return false
+}
+
+// retryWithBackoff calls call until it succeeds, fails with an error which is not retryable,
+// config.Attempts are used up or ctx is done. The error of the last attempt is returned.
+func retryWithBackoff[T any](ctx context.Context, config RetryConfig, name string, call func() (T, error)) (T, error) {
+	if config.Attempts <= 0 {
+		config.Attempts = defaultRetryAttempts
+	}
+	if config.InitialDelay <= 0 {
+		config.InitialDelay = defaultRetryInitialDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaultRetryMaxDelay
+	}
+
+	delay := config.InitialDelay
+	for attempt := 1; ; attempt++ {
+		result, err := call()
+		if err == nil || attempt >= config.Attempts || !isRetryable(err) {
+			return result, err
+		}
+		log.Printf("%s failed (attempt %d), retry in %s: %v", name, attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result, err
+		}
+		delay = min(delay*2, config.MaxDelay)
+	}
+}
+
 // subscriptionInfo holds details about an active Pub/Sub subscription.
 type subscriptionInfo struct {
 	gcpSubscription *pubsub.Subscription
+	tripID          uuid.UUID
 	cancel          context.CancelFunc
 }
 
@@ -32,22 +111,39 @@ type GenericPubSubService[M any] struct {
 	activeSubscriptions map[uuid.UUID]*subscriptionInfo
 	subscriptionsMutex  sync.Mutex
 	ctx                 context.Context
+	closeOnce           sync.Once // Close can be called from both defer and shutdown handler
+	retry               RetryConfig
+	createSubscription  createSubscriptionFunc
 }
 
 // NewGenericPubSubService creates and initializes a generic service for a specific message type.
 // It ensures the underlying Pub/Sub topic exists, creating it if necessary.
-func NewGenericPubSubService[M any](ctx context.Context, client *pubsub.Client, topicID string) (*GenericPubSubService[M], error) {
+// Checking and creating the topic is retried as set by WithRetry.
+func NewGenericPubSubService[M any](ctx context.Context, client *pubsub.Client, topicID string, opts ...ServiceOption) (*GenericPubSubService[M], error) {
 	if client == nil {
 		return nil, fmt.Errorf("GCP Pub/Sub client is nil")
 	}
+	options := serviceOptions{createSubscription: client.CreateSubscription}
+	for _, opt := range opts {
+		opt(&options)
+	}
 
 	topic := client.Topic(topicID)
-	exists, err := topic.Exists(ctx)
+	exists, err := retryWithBackoff(ctx, options.retry, "check topic "+topicID, func() (bool, error) {
+		return topic.Exists(ctx)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to check for existence of topic %s: %w", topicID, err)
 	}
 	if !exists {
-		topic, err = client.CreateTopic(ctx, topicID)
+		topic, err = retryWithBackoff(ctx, options.retry, "create topic "+topicID, func() (*pubsub.Topic, error) {
+			created, err := client.CreateTopic(ctx, topicID)
+			if status.Code(err) == codes.AlreadyExists {
+				// created by another instance or by an attempt which failed after the server took it
+				return client.Topic(topicID), nil
+			}
+			return created, err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create topic %s: %w", topicID, err)
 		}
@@ -59,15 +155,32 @@ func NewGenericPubSubService[M any](ctx context.Context, client *pubsub.Client,
 		topic:               topic,
 		activeSubscriptions: make(map[uuid.UUID]*subscriptionInfo),
 		ctx:                 ctx,
+		retry:               options.retry,
+		createSubscription:  options.createSubscription,
 	}, nil
 }
 
 // Publish sends a message to the configured Pub/Sub topic with the tripId as an attribute.
 func (s *GenericPubSubService[M]) Publish(msg mq.TopicProvider) error {
+	_, err := s.publish(msg)
+	return err
+}
+
+// PublishWithReceipt works like Publish, the server-assigned message ID is returned as Receipt.BrokerRef.
+func (s *GenericPubSubService[M]) PublishWithReceipt(msg mq.TopicProvider, messageID string) (mq.Receipt, error) {
+	serverID, err := s.publish(msg)
+	if err != nil {
+		return mq.Receipt{}, err
+	}
+	return mq.Receipt{ID: messageID, BrokerRef: serverID}, nil
+}
+
+// publish sends msg and waits for the server-assigned message ID.
+func (s *GenericPubSubService[M]) publish(msg mq.TopicProvider) (string, error) {
 	typeName := reflect.TypeOf(msg).Name()
 	body, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal %s: %w", typeName, err)
+		return "", fmt.Errorf("failed to marshal %s: %w", typeName, err)
 	}
 
 	routingKey := msg.GetTopic().String()
@@ -82,15 +195,15 @@ func (s *GenericPubSubService[M]) Publish(msg mq.TopicProvider) error {
 	result := s.topic.Publish(s.ctx, pubsubMsg)
 	// We can optionally wait for the result to confirm, but for high throughput,
 	// we can proceed without waiting. The library will handle retries.
-	_, err = result.Get(s.ctx)
+	serverID, err := result.Get(s.ctx)
 	if err != nil {
-		return fmt.Errorf("failed to publish %s to topic %s: %w", typeName, s.topic.ID(), err)
+		return "", fmt.Errorf("failed to publish %s to topic %s: %w", typeName, s.topic.ID(), err)
 	}
-	// _ = result // Avoid unused variable error if not waiting on Get()
-	return nil
+	return serverID, nil
 }
 
 // Subscribe creates a new filtered subscription on GCP and starts listening for messages.
+// Creating the subscription is retried as set by WithRetry.
 func (s *GenericPubSubService[M]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan M, error) {
 	subscriptionID := uuid.New() // Internal ID for tracking
 	typeName := reflect.TypeOf(*new(M)).Name()
@@ -105,7 +218,14 @@ func (s *GenericPubSubService[M]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan
 		AckDeadline:      10 * time.Second,
 	}
 
-	gcpSub, err := s.client.CreateSubscription(s.ctx, gcpSubName, config)
+	gcpSub, err := retryWithBackoff(s.ctx, s.retry, "create subscription "+gcpSubName, func() (*pubsub.Subscription, error) {
+		sub, err := s.createSubscription(s.ctx, gcpSubName, config)
+		if status.Code(err) == codes.AlreadyExists {
+			// an earlier attempt was taken by the server before it failed, the name is unique to this subscribe
+			return s.client.Subscription(gcpSubName), nil
+		}
+		return sub, err
+	})
 	if err != nil {
 		return uuid.Nil, nil, fmt.Errorf("failed to create GCP subscription %s for %s: %w", gcpSubName, typeName, err)
 	}
@@ -117,6 +237,7 @@ func (s *GenericPubSubService[M]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan
 	s.subscriptionsMutex.Lock()
 	s.activeSubscriptions[subscriptionID] = &subscriptionInfo{
 		gcpSubscription: gcpSub,
+		tripID:          tripId,
 		cancel:          cancel,
 	}
 	s.subscriptionsMutex.Unlock()
@@ -185,15 +306,71 @@ func (s *GenericPubSubService[M]) DeSubscribe(id uuid.UUID) error {
 	return nil
 }
 
-// Close gracefully shuts down all active subscriptions for this service.
-func (s *GenericPubSubService[M]) Close() {
+// DeSubscribeTrip stops the receivers of every subscription to the trip, their GCP subscriptions are deleted on exit.
+func (s *GenericPubSubService[M]) DeSubscribeTrip(tripId uuid.UUID) error {
 	s.subscriptionsMutex.Lock()
 	defer s.subscriptionsMutex.Unlock()
 
 	for _, info := range s.activeSubscriptions {
-		// log.Printf("Closing consumer for subscription ID %s", id)
-		info.cancel()
+		if info.tripID == tripId {
+			info.cancel()
+		}
 	}
+	return nil
+}
+
+// Close gracefully shuts down all active subscriptions for this service, calls after the first one are no-op.
+func (s *GenericPubSubService[M]) Close() {
+	s.closeOnce.Do(func() {
+		s.subscriptionsMutex.Lock()
+		defer s.subscriptionsMutex.Unlock()
+
+		for _, info := range s.activeSubscriptions {
+			// log.Printf("Closing consumer for subscription ID %s", id)
+			info.cancel()
+		}
+	})
+}
+
+// shutdown closes all active subscriptions, when cleanup is set the topic is deleted from GCP as well.
+func (s *GenericPubSubService[M]) shutdown(cleanup bool) error {
+	s.Close()
+	s.topic.Stop()
+	if !cleanup {
+		return nil
+	}
+	if err := s.topic.Delete(context.Background()); err != nil {
+		return fmt.Errorf("failed to delete topic %s: %w", s.topic.ID(), err)
+	}
+	log.Printf("Deleted Pub/Sub topic: %s", s.topic.ID())
+	return nil
+}
+
+type TripMQ struct {
+	genericService *GenericPubSubService[mq.TripMessage]
+	action         mq.Action
+}
+
+func NewTripMessageQueue(ctx context.Context, client *pubsub.Client, action mq.Action, opts ...ServiceOption) (*TripMQ, error) {
+	topicID := fmt.Sprintf("trip-%s", action.String())
+	gs, err := NewGenericPubSubService[mq.TripMessage](ctx, client, topicID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for Trip: %w", err)
+	}
+	return &TripMQ{genericService: gs, action: action}, nil
+}
+func (q *TripMQ) GetAction() mq.Action             { return q.action }
+func (q *TripMQ) Publish(msg mq.TripMessage) error { return q.genericService.Publish(msg) }
+func (q *TripMQ) PublishWithReceipt(msg mq.TripMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
+func (q *TripMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripMessage, error) {
+	return q.genericService.Subscribe(tripId)
+}
+func (q *TripMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
 }
 
 type TripRecordMQ struct {
@@ -201,9 +378,9 @@ type TripRecordMQ struct {
 	action         mq.Action
 }
 
-func NewTripRecordMessageQueue(ctx context.Context, client *pubsub.Client, action mq.Action) (*TripRecordMQ, error) {
+func NewTripRecordMessageQueue(ctx context.Context, client *pubsub.Client, action mq.Action, opts ...ServiceOption) (*TripRecordMQ, error) {
 	topicID := fmt.Sprintf("trip-record-%s", action.String())
-	gs, err := NewGenericPubSubService[mq.TripRecordMessage](ctx, client, topicID)
+	gs, err := NewGenericPubSubService[mq.TripRecordMessage](ctx, client, topicID, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create generic service for TripRecord: %w", err)
 	}
@@ -211,19 +388,26 @@ func NewTripRecordMessageQueue(ctx context.Context, client *pubsub.Client, actio
 }
 func (q *TripRecordMQ) GetAction() mq.Action                   { return q.action }
 func (q *TripRecordMQ) Publish(msg mq.TripRecordMessage) error { return q.genericService.Publish(msg) }
+func (q *TripRecordMQ) PublishWithReceipt(msg mq.TripRecordMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
 func (q *TripRecordMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripRecordMessage, error) {
 	return q.genericService.Subscribe(tripId)
 }
 func (q *TripRecordMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripRecordMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
 
 type TripAddressMQ struct {
 	genericService *GenericPubSubService[mq.TripAddressMessage]
 	action         mq.Action
 }
 
-func NewTripAddressMessageQueue(ctx context.Context, client *pubsub.Client, action mq.Action) (*TripAddressMQ, error) {
+func NewTripAddressMessageQueue(ctx context.Context, client *pubsub.Client, action mq.Action, opts ...ServiceOption) (*TripAddressMQ, error) {
 	topicID := fmt.Sprintf("trip-address-%s", action.String())
-	gs, err := NewGenericPubSubService[mq.TripAddressMessage](ctx, client, topicID)
+	gs, err := NewGenericPubSubService[mq.TripAddressMessage](ctx, client, topicID, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create generic service for TripAddress: %w", err)
 	}
@@ -233,16 +417,69 @@ func (q *TripAddressMQ) GetAction() mq.Action { return q.action }
 func (q *TripAddressMQ) Publish(msg mq.TripAddressMessage) error {
 	return q.genericService.Publish(msg)
 }
+func (q *TripAddressMQ) PublishWithReceipt(msg mq.TripAddressMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
 func (q *TripAddressMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripAddressMessage, error) {
 	return q.genericService.Subscribe(tripId)
 }
 func (q *TripAddressMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripAddressMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
 
 // --------- trip message queue wrapper implementation ---------
 
 type GCPTripMessageQueueWrapper struct {
+	TripMQArray    [mq.ActionCnt]*TripMQ
 	RecordMQArray  [mq.ActionCnt]*TripRecordMQ
 	AddressMQArray [mq.ActionCnt]*TripAddressMQ
+	// CleanupOnClose deletes the topics on Close, keep it off in production.
+	// It is useful for tests and ephemeral deployments, where topics would accumulate.
+	CleanupOnClose bool
+	client         *pubsub.Client
+	closeOnce      sync.Once
+	closeErr       error
+}
+
+// Close shuts down all queues and the client, topics are deleted when CleanupOnClose is set.
+// Calls after the first one are no-op.
+func (wrapper *GCPTripMessageQueueWrapper) Close() error {
+	wrapper.closeOnce.Do(func() {
+		wrapper.closeErr = wrapper.close()
+	})
+	return wrapper.closeErr
+}
+
+func (wrapper *GCPTripMessageQueueWrapper) close() error {
+	var errs []error
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	if wrapper.client != nil {
+		errs = append(errs, wrapper.client.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (wrapper *GCPTripMessageQueueWrapper) GetTripMessageQueue(action mq.Action) mq.TripMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.TripMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.TripMQArray[action]
 }
 
 func (wrapper *GCPTripMessageQueueWrapper) GetTripRecordMessageQueue(action mq.Action) mq.TripRecordMessageQueue {
@@ -260,35 +497,42 @@ func (wrapper *GCPTripMessageQueueWrapper) GetTripAddressMessageQueue(action mq.
 }
 
 // NewGCPTripMessageQueueWrapper creates a new MQ wrapper instance using GCP Pub/Sub.
-func NewGCPTripMessageQueueWrapper(ctx context.Context, projectID string) (mq.TripMessageQueueWrapper, error) {
+// The options apply to every queue of the wrapper.
+func NewGCPTripMessageQueueWrapper(ctx context.Context, projectID string, opts ...ServiceOption) (mq.TripMessageQueueWrapper, error) {
 	client, err := pubsub.NewClient(ctx, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCP Pub/Sub client for project %s: %w", projectID, err)
 	}
 
-	wrapper := &GCPTripMessageQueueWrapper{}
+	wrapper := &GCPTripMessageQueueWrapper{client: client}
+
+	// Trip: Delete
+	wrapper.TripMQArray[mq.ActionDelete], err = NewTripMessageQueue(ctx, client, mq.ActionDelete, opts...)
+	if err != nil {
+		return nil, err
+	}
 
 	// Address: Create, Delete
-	wrapper.AddressMQArray[mq.ActionCreate], err = NewTripAddressMessageQueue(ctx, client, mq.ActionCreate)
+	wrapper.AddressMQArray[mq.ActionCreate], err = NewTripAddressMessageQueue(ctx, client, mq.ActionCreate, opts...)
 	if err != nil {
 		return nil, err
 	}
 	wrapper.AddressMQArray[mq.ActionUpdate] = nil // Not implemented for Address
-	wrapper.AddressMQArray[mq.ActionDelete], err = NewTripAddressMessageQueue(ctx, client, mq.ActionDelete)
+	wrapper.AddressMQArray[mq.ActionDelete], err = NewTripAddressMessageQueue(ctx, client, mq.ActionDelete, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Record: Create, Update, Delete
-	wrapper.RecordMQArray[mq.ActionCreate], err = NewTripRecordMessageQueue(ctx, client, mq.ActionCreate)
+	wrapper.RecordMQArray[mq.ActionCreate], err = NewTripRecordMessageQueue(ctx, client, mq.ActionCreate, opts...)
 	if err != nil {
 		return nil, err
 	}
-	wrapper.RecordMQArray[mq.ActionUpdate], err = NewTripRecordMessageQueue(ctx, client, mq.ActionUpdate)
+	wrapper.RecordMQArray[mq.ActionUpdate], err = NewTripRecordMessageQueue(ctx, client, mq.ActionUpdate, opts...)
 	if err != nil {
 		return nil, err
 	}
-	wrapper.RecordMQArray[mq.ActionDelete], err = NewTripRecordMessageQueue(ctx, client, mq.ActionDelete)
+	wrapper.RecordMQArray[mq.ActionDelete], err = NewTripRecordMessageQueue(ctx, client, mq.ActionDelete, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"cloud.google.com/go/pubsub"
 	"github.com/google/uuid"
 )
 
@@ -352,3 +353,146 @@ func TestTripRecordMessageQueue_DeSubscribe_NonExistent(t *testing.T) {
 		t.Error("Expected error when de-subscribing non-existent ID from TRQ, got nil")
 	}
 }
+
+func TestGCPTripMessageQueueWrapper_CloseWithCleanup(t *testing.T) {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		t.Skip("Skipping test: PUBSUB_EMULATOR_HOST environment variable not set. Please start the Pub/Sub emulator.")
+	}
+	// use a dedicated project, so deleting topics does not affect other tests on the emulator
+	const cleanupProjectID = "cleanup-test-project"
+	ctx := context.Background()
+
+	wrapperIFace, err := gcppubsub.NewGCPTripMessageQueueWrapper(ctx, cleanupProjectID)
+	if err != nil {
+		t.Fatalf("Failed to create GCPTripMessageQueueWrapper for emulator: %v", err)
+	}
+	wrapper, ok := wrapperIFace.(*gcppubsub.GCPTripMessageQueueWrapper)
+	if !ok {
+		t.Fatal("NewGCPTripMessageQueueWrapper did not return *GCPTripMessageQueueWrapper")
+	}
+	wrapper.CleanupOnClose = true
+
+	if err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: uuid.New(), Name: "cleanup"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if err := wrapper.Close(); err != nil {
+		t.Fatalf("Close with cleanup failed: %v", err)
+	}
+
+	client, err := pubsub.NewClient(ctx, cleanupProjectID)
+	if err != nil {
+		t.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+	defer func(client *pubsub.Client) {
+		_ = client.Close()
+	}(client)
+	for _, topicID := range []string{"trip-delete", "trip-record-create", "trip-record-update", "trip-record-delete", "trip-address-create", "trip-address-delete"} {
+		exists, err := client.Topic(topicID).Exists(ctx)
+		if err != nil {
+			t.Fatalf("Failed to check topic %s: %v", topicID, err)
+		}
+		if exists {
+			t.Errorf("Topic %s still exists after Close with cleanup", topicID)
+		}
+	}
+}
+
+func TestGCPTripMessageQueueWrapper_CloseTwice(t *testing.T) {
+	wrapper, ok := getTestWrapper(t).(*gcppubsub.GCPTripMessageQueueWrapper)
+	if !ok {
+		t.Fatal("NewGCPTripMessageQueueWrapper did not return *GCPTripMessageQueueWrapper")
+	}
+	if _, _, err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(uuid.New()); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// e.g. from both a defer and a shutdown handler
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = wrapper.Close()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Close call %d failed: %v", i, err)
+		}
+	}
+	if err := wrapper.Close(); err != nil {
+		t.Errorf("Close after close failed: %v", err)
+	}
+}
+
+func TestTripRecordMessageQueue_DeSubscribeTrip(t *testing.T) {
+	trq := setupTripRecordQueue(t, mq.ActionCreate)
+	tripID := uuid.New()
+
+	var rcvChans []<-chan mq.TripRecordMessage
+	for i := 0; i < 3; i++ {
+		_, rcvChan, err := trq.Subscribe(tripID)
+		if err != nil {
+			t.Fatalf("Subscribe %d failed: %v", i, err)
+		}
+		rcvChans = append(rcvChans, rcvChan)
+	}
+	otherID, otherChan, err := trq.Subscribe(uuid.New())
+	if err != nil {
+		t.Fatalf("Subscribe other trip failed: %v", err)
+	}
+
+	if err := trq.DeSubscribeTrip(tripID); err != nil {
+		t.Fatalf("DeSubscribeTrip failed: %v", err)
+	}
+	for i, rcvChan := range rcvChans {
+		select {
+		case _, ok := <-rcvChan:
+			if ok {
+				t.Errorf("Unexpected message on channel %d after DeSubscribeTrip", i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Channel %d was not closed after DeSubscribeTrip", i)
+		}
+	}
+	if isChanClosed(otherChan) {
+		t.Error("Channel of other trip should stay open")
+	}
+	if err := trq.DeSubscribe(otherID); err != nil {
+		t.Errorf("DeSubscribe other trip failed: %v", err)
+	}
+}
+
+func TestTripRecordMessageQueue_PublishWithReceipt(t *testing.T) {
+	t.Parallel()
+	trq := setupTripRecordQueue(t, mq.ActionCreate)
+	publisher, ok := trq.(mq.ReceiptPublisher[mq.TripRecordMessage])
+	if !ok {
+		t.Fatal("TripRecordMQ does not implement ReceiptPublisher")
+	}
+	topicID := uuid.New()
+	subID, rcvChan, err := trq.Subscribe(topicID)
+	if err != nil {
+		t.Fatalf("trq.Subscribe failed: %v", err)
+	}
+	defer func() { _ = trq.DeSubscribe(subID) }()
+	// Allow time for subscription to be ready on the emulator backend
+	time.Sleep(2 * time.Second)
+
+	receipt, err := publisher.PublishWithReceipt(mq.TripRecordMessage{ID: uuid.New(), TripID: topicID, Name: "receipt"})
+	if err != nil {
+		t.Fatalf("PublishWithReceipt failed: %v", err)
+	}
+	if receipt.ID == "" || receipt.BrokerRef == "" {
+		t.Fatalf("receipt is incomplete: %+v", receipt)
+	}
+	receivedMsg, ok := receiveMsgWithTimeout(t, rcvChan, 30*time.Second)
+	if !ok {
+		t.Fatal("Timeout or channel closed while waiting for message")
+	}
+	if receivedMsg.MessageID != receipt.ID {
+		t.Errorf("delivered MessageID %q does not match receipt ID %q", receivedMsg.MessageID, receipt.ID)
+	}
+}
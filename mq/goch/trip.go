@@ -13,7 +13,32 @@ import (
 
 type Subscriber[T any] struct {
 	TripID  uuid.UUID
+	Key     string // optional client-supplied subscription key, empty means no key
 	Channel chan T
+	Paused  bool // set by Pause, the fan-out neither waits for nor removes the subscriber meanwhile
+}
+
+// DropPolicy decides what the fan-out does with a subscriber which does not take a message within the send timeout.
+type DropPolicy int
+
+const (
+	DropSubscriber DropPolicy = iota // remove the subscriber and close its channel
+	SkipMessage                      // drop only this message for the subscriber, it stays subscribed
+	Block                            // keep waiting until the subscriber takes the message, stalls the whole queue meanwhile
+)
+
+// blockRetryInterval is how long Block waits per attempt when no SendTimeout is set,
+// the lock is released between attempts so subscribers can still come and go.
+const blockRetryInterval = 50 * time.Millisecond
+
+// FanOutConfig controls how the fan-out treats slow subscribers, the zero value removes
+// a subscriber as soon as its channel is full.
+type FanOutConfig struct {
+	SendTimeout time.Duration // how long to wait for a full subscriber channel, 0 means not waiting
+	DropPolicy  DropPolicy
+	// PauseDrop drops every message for a paused subscriber, by default its channel keeps
+	// buffering while paused and only the messages which do not fit are dropped
+	PauseDrop bool
 }
 
 // fanOutQueueCore provides the generic fan-out logic for any message type.
@@ -24,10 +49,19 @@ type fanOutQueueCore[T mq.TopicProvider] struct {
 	quit        chan struct{}               // Signal to stop the fan-out goroutine
 	wg          sync.WaitGroup              // WaitGroup for the fan-out goroutine
 	bufferSize  int                         // Buffer size for the main publish channel
+	maxPerTopic int                         // Max subscribers of one topic, 0 means unlimited
+	maxTotal    int                         // Max subscribers of all topics, 0 means unlimited
+	config      FanOutConfig                // Slow subscriber handling, protected by mu
+	action      mq.Action                   // Action of the queue, reported to metrics
+	metrics     mq.Metrics                  // Message counts, protected by mu
+	stopOnce    sync.Once                   // Stop can be called more than once
+	pubMu       sync.RWMutex                // Guards publishChan against a send after Stop closed it
+	stopped     bool                        // Set by Stop, protected by pubMu
+	closed      bool                        // Set by Close, protected by mu
 }
 
 // newFanOutQueueCore creates a new instance of fanOutQueueCore.
-func newFanOutQueueCore[T mq.TopicProvider](bufferSize int) *fanOutQueueCore[T] {
+func newFanOutQueueCore[T mq.TopicProvider](bufferSize int, config FanOutConfig) *fanOutQueueCore[T] {
 	var pubChan chan T
 	if bufferSize > 0 {
 		pubChan = make(chan T, bufferSize)
@@ -40,6 +74,8 @@ func newFanOutQueueCore[T mq.TopicProvider](bufferSize int) *fanOutQueueCore[T]
 		subscribers: make(map[uuid.UUID]Subscriber[T]),
 		quit:        make(chan struct{}),
 		bufferSize:  bufferSize,
+		config:      config,
+		metrics:     mq.NopMetrics{},
 		mu:          sync.RWMutex{},
 		wg:          sync.WaitGroup{},
 	}
@@ -51,17 +87,95 @@ func newFanOutQueueCore[T mq.TopicProvider](bufferSize int) *fanOutQueueCore[T]
 
 // Publish sends a message to the main channel.
 // This is the input point for messages to be fanned out.
+// It returns ClosedQueueError after Stop.
 func (f *fanOutQueueCore[T]) Publish(msg T) error {
+	f.pubMu.RLock()
+	defer f.pubMu.RUnlock()
+
+	if f.stopped {
+		return ClosedQueueError
+	}
 	select {
 	case f.publishChan <- msg:
+		f.mu.RLock()
+		f.metrics.IncPublished(f.action)
+		f.mu.RUnlock()
 		return nil
 	case <-time.After(200 * time.Millisecond):
 		return FullQueueError
 	}
 }
 
+// setSubscriberLimit sets the per-topic and global subscriber caps, 0 means unlimited.
+func (f *fanOutQueueCore[T]) setSubscriberLimit(perTopic, total int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.maxPerTopic = perTopic
+	f.maxTotal = total
+}
+
+// setFanOutConfig replaces the slow subscriber handling, it applies from the next message on.
+func (f *fanOutQueueCore[T]) setFanOutConfig(config FanOutConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.config = config
+}
+
+// setMetrics replaces the metrics of the queue, nil counts nothing.
+func (f *fanOutQueueCore[T]) setMetrics(metrics mq.Metrics) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if metrics == nil {
+		metrics = mq.NopMetrics{}
+	}
+	f.metrics = metrics
+}
+
 // Subscribe adds a new subscriber and returns its channel and ID.
+// It returns TooManySubscribersError when the subscriber limit is reached.
 func (f *fanOutQueueCore[T]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan T, error) {
+	return f.SubscribeWithKey(tripId, "")
+}
+
+// SubscribeWithKey works like Subscribe, but a repeat call with the same non-empty key
+// replaces the prior subscriber and closes its channel instead of leaking it (e.g. after a flaky reconnect).
+// It returns ClosedQueueError after Close.
+func (f *fanOutQueueCore[T]) SubscribeWithKey(tripId uuid.UUID, key string) (uuid.UUID, <-chan T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return uuid.Nil, nil, ClosedQueueError
+	}
+
+	if key != "" {
+		for id, sub := range f.subscribers {
+			if sub.Key == key {
+				delete(f.subscribers, id)
+				close(sub.Channel)
+				break
+			}
+		}
+	}
+
+	if f.maxTotal > 0 && len(f.subscribers) >= f.maxTotal {
+		return uuid.Nil, nil, TooManySubscribersError
+	}
+	if f.maxPerTopic > 0 {
+		count := 0
+		for _, sub := range f.subscribers {
+			if sub.TripID == tripId {
+				count++
+			}
+		}
+		if count >= f.maxPerTopic {
+			return uuid.Nil, nil, TooManySubscribersError
+		}
+	}
+
 	var subChan chan T
 	if f.bufferSize > 0 {
 		subChan = make(chan T, f.bufferSize)
@@ -70,11 +184,9 @@ func (f *fanOutQueueCore[T]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan T, e
 	}
 	subscriberID := uuid.New()
 
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
 	f.subscribers[subscriberID] = Subscriber[T]{
 		TripID:  tripId,
+		Key:     key,
 		Channel: subChan,
 	}
 	// fmt.Printf("goch: New subscriber with ID '%s' added.\n", subscriberID)
@@ -95,56 +207,275 @@ func (f *fanOutQueueCore[T]) DeSubscribe(subscriberID uuid.UUID) error {
 	return fmt.Errorf("goch: subscriber with ID '%s' not found", subscriberID)
 }
 
-// Stop signals the fan-out goroutine to shut down and waits for it.
+// DeSubscribeTrip removes every subscriber of the trip and closes their channels.
+func (f *fanOutQueueCore[T]) DeSubscribeTrip(tripId uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, sub := range f.subscribers {
+		if sub.TripID == tripId {
+			delete(f.subscribers, id)
+			close(sub.Channel)
+		}
+	}
+	return nil
+}
+
+// Pause holds delivery to a subscriber without removing it, see FanOutConfig.PauseDrop for the messages
+// published meanwhile. Its channel stays open and pausing twice is no-op.
+func (f *fanOutQueueCore[T]) Pause(subscriberID uuid.UUID) error {
+	return f.setPaused(subscriberID, true)
+}
+
+// Resume lets the fan-out handle a paused subscriber by the drop policy again.
+func (f *fanOutQueueCore[T]) Resume(subscriberID uuid.UUID) error {
+	return f.setPaused(subscriberID, false)
+}
+
+func (f *fanOutQueueCore[T]) setPaused(subscriberID uuid.UUID, paused bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sub, ok := f.subscribers[subscriberID]
+	if !ok {
+		return fmt.Errorf("goch: subscriber with ID '%s' not found", subscriberID)
+	}
+	sub.Paused = paused
+	f.subscribers[subscriberID] = sub
+	return nil
+}
+
+// Stop signals the fan-out goroutine to shut down and waits for it, calls after the first one are no-op.
+// A message a Block subscriber is waiting for is dropped.
 func (f *fanOutQueueCore[T]) Stop() {
-	close(f.publishChan) // Closing the publish channel will end the fan-out routine's loop
-	f.wg.Wait()          // Wait for the fan-out routine to finish
+	f.stopOnce.Do(func() {
+		close(f.quit) // Stop waiting for Block subscribers, so a Publish waiting for room gives up
+		f.pubMu.Lock()
+		f.stopped = true
+		close(f.publishChan) // Closing the publish channel will end the fan-out routine's loop
+		f.pubMu.Unlock()
+	})
+	f.wg.Wait() // Wait for the fan-out routine to finish
 	// fmt.Println("goch: Fan-out queue stopped.")
 }
 
+// Close stops the queue like Stop, then removes every subscriber and closes its channel,
+// so a subscriber ranging over its channel ends. Calls after the first one are no-op.
+func (f *fanOutQueueCore[T]) Close() {
+	f.Stop() // the fan-out routine has exited, nothing sends on the channels closed below
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for id, sub := range f.subscribers {
+		delete(f.subscribers, id)
+		close(sub.Channel)
+	}
+}
+
 // startFanOutRoutine handles fanning out messages from the publishChan to subscribers.
 func (f *fanOutQueueCore[T]) startFanOutRoutine() {
 	defer f.wg.Done()
-
 	for msg := range f.publishChan { // Loop exits when publishChan is closed
-		f.mu.RLock() // Acquire read lock to safely read the subscribers map
+		f.removeSubscribers(f.deliver(msg))
+	}
+	// fmt.Println("goch: Fan-out routine exiting.")
+}
 
-		subscribersSnapshot := make(map[uuid.UUID]chan T)
-		for id, ch := range f.subscribers {
-			if ch.TripID == msg.GetTopic() { // Only include subscribers for the specific trip ID
-				subscribersSnapshot[id] = ch.Channel // Copy the channel to avoid holding the lock while sending
+// deliver sends msg to every subscriber of its topic following the fan-out config and returns
+// the subscribers to remove because they did not keep up under DropSubscriber.
+func (f *fanOutQueueCore[T]) deliver(msg T) []uuid.UUID {
+	f.mu.RLock()
+	config, metrics := f.config, f.metrics
+	var ids []uuid.UUID
+	for id, sub := range f.subscribers {
+		if sub.TripID == msg.GetTopic() { // Only send to subscribers for the specific trip ID
+			ids = append(ids, id)
+		}
+	}
+	f.mu.RUnlock()
+
+	var failedSubscribers []uuid.UUID // Collect IDs of subscribers that failed to receive
+	for _, id := range ids {
+		if config.DropPolicy == Block {
+			wait := config.SendTimeout
+			if wait <= 0 {
+				wait = blockRetryInterval
+			}
+			for !f.send(id, msg, wait, config.PauseDrop) {
+				select {
+				case <-f.quit:
+					return failedSubscribers
+				default:
+				}
 			}
+			continue
 		}
-		f.mu.RUnlock() // Release read lock
-
-		var failedSubscribers []uuid.UUID // Collect IDs of subscribers that failed to receive
+		if f.send(id, msg, config.SendTimeout, config.PauseDrop) {
+			continue
+		}
+		if config.DropPolicy == DropSubscriber {
+			failedSubscribers = append(failedSubscribers, id)
+		} else {
+			metrics.IncDropped(f.action)
+		}
+	}
+	return failedSubscribers
+}
 
-		for id, subChan := range subscribersSnapshot {
+// send waits up to timeout for the subscriber to take msg and reports false when it did not.
+// The read lock is held while sending, so DeSubscribe can not close the channel in the middle
+// of a send, a subscriber removed before counts as sent since there is nothing left to do.
+// A paused subscriber is never waited for, msg is buffered if its channel has room unless pauseDrop
+// and counts as sent either way, so the subscriber is kept.
+func (f *fanOutQueueCore[T]) send(id uuid.UUID, msg T, timeout time.Duration, pauseDrop bool) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	sub, ok := f.subscribers[id]
+	if !ok {
+		return true
+	}
+	if sub.Paused {
+		if !pauseDrop {
 			select {
-			case subChan <- msg:
-				// Message sent successfully
-			case <-time.After(50 * time.Millisecond): // Optional: Add a timeout for slow consumers
-				// fmt.Printf("goch: Warning: Timed out sending message to subscriber ID '%s'. Channel might be blocked.\n", id)
-				failedSubscribers = append(failedSubscribers, id)
+			case sub.Channel <- msg:
+				f.metrics.IncDelivered(f.action)
 			default:
-				// Channel is blocked or closed (sending to a closed channel with select default won't panic, it just goes to default)
-				// fmt.Printf("goch: Warning: Failed to send message to subscriber ID '%s'. Channel is full or closed.\n", id)
-				failedSubscribers = append(failedSubscribers, id)
 			}
 		}
+		return true
+	}
+	if timeout <= 0 {
+		select {
+		case sub.Channel <- msg:
+			f.metrics.IncDelivered(f.action)
+			return true
+		default:
+			return false // Channel is full, the consumer does not keep up
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sub.Channel <- msg:
+		f.metrics.IncDelivered(f.action)
+		return true
+	case <-timer.C:
+		return false
+	}
+}
 
-		// After iterating, remove failed subscribers (if any).
-		if len(failedSubscribers) > 0 {
-			for _, id := range failedSubscribers {
-				_ = f.DeSubscribe(id)
-			}
+// removeSubscribers removes the failed subscribers and closes their channels, each counts as dropped,
+// those de-subscribed meanwhile are skipped.
+func (f *fanOutQueueCore[T]) removeSubscribers(ids []uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		if sub, ok := f.subscribers[id]; ok {
+			delete(f.subscribers, id)
+			close(sub.Channel)
+			f.metrics.IncDropped(f.action)
 		}
 	}
-	// fmt.Println("goch: Fan-out routine exiting.")
 }
 
 // --- Specific Message Queue Implementations ---
 
+// ChannelTripMessageQueue implements TripMessageQueue using a Go channel.
+type ChannelTripMessageQueue struct {
+	action mq.Action
+	core   *fanOutQueueCore[mq.TripMessage] // Embed the generic core
+}
+
+// NewChannelTripMessageQueue creates a new instance of ChannelTripMessageQueue.
+func NewChannelTripMessageQueue(action mq.Action, bufferSize int) *ChannelTripMessageQueue {
+	core := newFanOutQueueCore[mq.TripMessage](bufferSize, FanOutConfig{})
+	core.action = action
+	return &ChannelTripMessageQueue{
+		action: action,
+		core:   core,
+	}
+}
+
+// GetAction returns the action associated with this queue.
+func (q *ChannelTripMessageQueue) GetAction() mq.Action {
+	return q.action
+}
+
+// Publish sends a TripMessage to the queue.
+func (q *ChannelTripMessageQueue) Publish(msg mq.TripMessage) error {
+	return q.core.Publish(msg)
+}
+
+// PublishWithReceipt works like Publish, the receipt ID is delivered to subscribers as MessageID.
+func (q *ChannelTripMessageQueue) PublishWithReceipt(msg mq.TripMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	if err := q.core.Publish(msg); err != nil {
+		return mq.Receipt{}, err
+	}
+	return mq.Receipt{ID: msg.MessageID}, nil
+}
+
+// Subscribe returns a read-only channel for TripMessages.
+func (q *ChannelTripMessageQueue) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripMessage, error) {
+	uid, subChan, err := q.core.Subscribe(tripId) // Delegate to the core's Subscribe
+	return uid, subChan, err
+}
+
+// SubscribeWithKey returns a read-only channel for TripMessages, replacing the prior subscriber with the same key.
+func (q *ChannelTripMessageQueue) SubscribeWithKey(tripId uuid.UUID, key string) (uuid.UUID, <-chan mq.TripMessage, error) {
+	return q.core.SubscribeWithKey(tripId, key)
+}
+
+// DeSubscribe removes a subscriber channel.
+func (q *ChannelTripMessageQueue) DeSubscribe(subscriberID uuid.UUID) error {
+	return q.core.DeSubscribe(subscriberID)
+}
+
+// DeSubscribeTrip removes every subscriber channel of the trip.
+func (q *ChannelTripMessageQueue) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.core.DeSubscribeTrip(tripId)
+}
+
+// Pause holds delivery to a subscriber without removing it.
+func (q *ChannelTripMessageQueue) Pause(subscriberID uuid.UUID) error {
+	return q.core.Pause(subscriberID)
+}
+
+// Resume continues delivery to a paused subscriber.
+func (q *ChannelTripMessageQueue) Resume(subscriberID uuid.UUID) error {
+	return q.core.Resume(subscriberID)
+}
+
+// SetSubscriberLimit caps subscribers per trip and in total, 0 means unlimited.
+func (q *ChannelTripMessageQueue) SetSubscriberLimit(perTopic, total int) {
+	q.core.setSubscriberLimit(perTopic, total)
+}
+
+// SetFanOutConfig sets how slow subscribers are handled.
+func (q *ChannelTripMessageQueue) SetFanOutConfig(config FanOutConfig) {
+	q.core.setFanOutConfig(config)
+}
+
+// SetMetrics sets the metrics the queue reports its message counts to, nil counts nothing.
+func (q *ChannelTripMessageQueue) SetMetrics(metrics mq.Metrics) {
+	q.core.setMetrics(metrics)
+}
+
+// Stop stops the underlying core fan-out routine.
+func (q *ChannelTripMessageQueue) Stop() {
+	q.core.Stop()
+}
+
+// Close stops the queue and closes the channel of every subscriber.
+func (q *ChannelTripMessageQueue) Close() {
+	q.core.Close()
+}
+
 // ChannelTripRecordMessageQueue implements TripRecordMessageQueue using a Go channel.
 type ChannelTripRecordMessageQueue struct {
 	action mq.Action
@@ -153,9 +484,11 @@ type ChannelTripRecordMessageQueue struct {
 
 // NewChannelTripRecordMessageQueue creates a new instance of ChannelTripRecordMessageQueue.
 func NewChannelTripRecordMessageQueue(action mq.Action, bufferSize int) *ChannelTripRecordMessageQueue {
+	core := newFanOutQueueCore[mq.TripRecordMessage](bufferSize, FanOutConfig{})
+	core.action = action
 	return &ChannelTripRecordMessageQueue{
 		action: action,
-		core:   newFanOutQueueCore[mq.TripRecordMessage](bufferSize),
+		core:   core,
 	}
 }
 
@@ -169,21 +502,70 @@ func (q *ChannelTripRecordMessageQueue) Publish(msg mq.TripRecordMessage) error
 	return q.core.Publish(msg)
 }
 
+// PublishWithReceipt works like Publish, the receipt ID is delivered to subscribers as MessageID.
+func (q *ChannelTripRecordMessageQueue) PublishWithReceipt(msg mq.TripRecordMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	if err := q.core.Publish(msg); err != nil {
+		return mq.Receipt{}, err
+	}
+	return mq.Receipt{ID: msg.MessageID}, nil
+}
+
 // Subscribe returns a read-only channel for TripRecordMessages.
 func (q *ChannelTripRecordMessageQueue) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripRecordMessage, error) {
 	uid, subChan, err := q.core.Subscribe(tripId) // Delegate to the core's Subscribe
 	return uid, subChan, err
 }
 
+// SubscribeWithKey returns a read-only channel for TripRecordMessages, replacing the prior subscriber with the same key.
+func (q *ChannelTripRecordMessageQueue) SubscribeWithKey(tripId uuid.UUID, key string) (uuid.UUID, <-chan mq.TripRecordMessage, error) {
+	return q.core.SubscribeWithKey(tripId, key)
+}
+
 func (q *ChannelTripRecordMessageQueue) DeSubscribe(subscriberID uuid.UUID) error {
 	return q.core.DeSubscribe(subscriberID)
 }
 
+// DeSubscribeTrip removes every subscriber channel of the trip.
+func (q *ChannelTripRecordMessageQueue) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.core.DeSubscribeTrip(tripId)
+}
+
+// Pause holds delivery to a subscriber without removing it.
+func (q *ChannelTripRecordMessageQueue) Pause(subscriberID uuid.UUID) error {
+	return q.core.Pause(subscriberID)
+}
+
+// Resume continues delivery to a paused subscriber.
+func (q *ChannelTripRecordMessageQueue) Resume(subscriberID uuid.UUID) error {
+	return q.core.Resume(subscriberID)
+}
+
+// SetSubscriberLimit caps subscribers per trip and in total, 0 means unlimited.
+func (q *ChannelTripRecordMessageQueue) SetSubscriberLimit(perTopic, total int) {
+	q.core.setSubscriberLimit(perTopic, total)
+}
+
+// SetFanOutConfig sets how slow subscribers are handled.
+func (q *ChannelTripRecordMessageQueue) SetFanOutConfig(config FanOutConfig) {
+	q.core.setFanOutConfig(config)
+}
+
+// SetMetrics sets the metrics the queue reports its message counts to, nil counts nothing.
+func (q *ChannelTripRecordMessageQueue) SetMetrics(metrics mq.Metrics) {
+	q.core.setMetrics(metrics)
+}
+
 // Stop stops the underlying core fan-out routine.
 func (q *ChannelTripRecordMessageQueue) Stop() {
 	q.core.Stop()
 }
 
+// Close stops the queue and closes the channel of every subscriber.
+func (q *ChannelTripRecordMessageQueue) Close() {
+	q.core.Close()
+}
+
 // ChannelTripAddressMessageQueue implements TripAddressMessageQueue using a Go channel.
 type ChannelTripAddressMessageQueue struct {
 	action mq.Action
@@ -192,9 +574,11 @@ type ChannelTripAddressMessageQueue struct {
 
 // NewChannelTripAddressMessageQueue creates a new instance of ChannelTripAddressMessageQueue.
 func NewChannelTripAddressMessageQueue(action mq.Action, bufferSize int) *ChannelTripAddressMessageQueue {
+	core := newFanOutQueueCore[mq.TripAddressMessage](bufferSize, FanOutConfig{})
+	core.action = action
 	return &ChannelTripAddressMessageQueue{
 		action: action,
-		core:   newFanOutQueueCore[mq.TripAddressMessage](bufferSize),
+		core:   core,
 	}
 }
 
@@ -212,30 +596,90 @@ func (q *ChannelTripAddressMessageQueue) Publish(msg mq.TripAddressMessage) erro
 	return nil
 }
 
+// PublishWithReceipt works like Publish, the receipt ID is delivered to subscribers as MessageID.
+func (q *ChannelTripAddressMessageQueue) PublishWithReceipt(msg mq.TripAddressMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	if err := q.core.Publish(msg); err != nil {
+		return mq.Receipt{}, err
+	}
+	return mq.Receipt{ID: msg.MessageID}, nil
+}
+
 // Subscribe returns a read-only channel for TripAddressMessages.
 func (q *ChannelTripAddressMessageQueue) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripAddressMessage, error) {
 	uid, subChan, err := q.core.Subscribe(tripId) // Delegate to the core's Subscribe
 	return uid, subChan, err
 }
 
+// SubscribeWithKey returns a read-only channel for TripAddressMessages, replacing the prior subscriber with the same key.
+func (q *ChannelTripAddressMessageQueue) SubscribeWithKey(tripId uuid.UUID, key string) (uuid.UUID, <-chan mq.TripAddressMessage, error) {
+	return q.core.SubscribeWithKey(tripId, key)
+}
+
 // DeSubscribe removes a subscriber channel.
 func (q *ChannelTripAddressMessageQueue) DeSubscribe(subscriberID uuid.UUID) error {
 	return q.core.DeSubscribe(subscriberID)
 }
 
+// DeSubscribeTrip removes every subscriber channel of the trip.
+func (q *ChannelTripAddressMessageQueue) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.core.DeSubscribeTrip(tripId)
+}
+
+// Pause holds delivery to a subscriber without removing it.
+func (q *ChannelTripAddressMessageQueue) Pause(subscriberID uuid.UUID) error {
+	return q.core.Pause(subscriberID)
+}
+
+// Resume continues delivery to a paused subscriber.
+func (q *ChannelTripAddressMessageQueue) Resume(subscriberID uuid.UUID) error {
+	return q.core.Resume(subscriberID)
+}
+
+// SetSubscriberLimit caps subscribers per trip and in total, 0 means unlimited.
+func (q *ChannelTripAddressMessageQueue) SetSubscriberLimit(perTopic, total int) {
+	q.core.setSubscriberLimit(perTopic, total)
+}
+
+// SetFanOutConfig sets how slow subscribers are handled.
+func (q *ChannelTripAddressMessageQueue) SetFanOutConfig(config FanOutConfig) {
+	q.core.setFanOutConfig(config)
+}
+
+// SetMetrics sets the metrics the queue reports its message counts to, nil counts nothing.
+func (q *ChannelTripAddressMessageQueue) SetMetrics(metrics mq.Metrics) {
+	q.core.setMetrics(metrics)
+}
+
 // Stop stops the underlying core fan-out routine.
 func (q *ChannelTripAddressMessageQueue) Stop() {
 	q.core.Stop()
 }
 
+// Close stops the queue and closes the channel of every subscriber.
+func (q *ChannelTripAddressMessageQueue) Close() {
+	q.core.Close()
+}
+
 // --- Wrapper for Message Queues ---
 
 // GoChanTripMessageQueueWrapper This struct can be used to implement the TripMessageQueueWrapper interface
 type GoChanTripMessageQueueWrapper struct {
+	TripMQArray    [mq.ActionCnt]*ChannelTripMessageQueue
 	RecordMQArray  [mq.ActionCnt]*ChannelTripRecordMessageQueue  // Use pointers to the new struct
 	AddressMQArray [mq.ActionCnt]*ChannelTripAddressMessageQueue // Use pointers to the new struct
 }
 
+func (wrapper *GoChanTripMessageQueueWrapper) GetTripMessageQueue(action mq.Action) mq.TripMessageQueue {
+	if action < 0 || action >= mq.ActionCnt {
+		return nil // or handle the error as needed
+	}
+	if wrapper.TripMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.TripMQArray[action]
+}
+
 func (wrapper *GoChanTripMessageQueueWrapper) GetTripRecordMessageQueue(action mq.Action) mq.TripRecordMessageQueue {
 	if action < 0 || action >= mq.ActionCnt {
 		return nil // or handle the error as needed
@@ -253,9 +697,91 @@ func (wrapper *GoChanTripMessageQueueWrapper) GetTripAddressMessageQueue(action
 	return wrapper.AddressMQArray[action]
 }
 
+// SetSubscriberLimit applies the subscriber caps to every queue of the wrapper, 0 means unlimited.
+func (wrapper *GoChanTripMessageQueueWrapper) SetSubscriberLimit(perTopic, total int) {
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			q.SetSubscriberLimit(perTopic, total)
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			q.SetSubscriberLimit(perTopic, total)
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			q.SetSubscriberLimit(perTopic, total)
+		}
+	}
+}
+
+// Close stops the fan-out of every queue of the wrapper, messages published afterward are rejected
+// with ClosedQueueError. Subscriber channels are closed, calls after the first one are no-op.
+func (wrapper *GoChanTripMessageQueueWrapper) Close() error {
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			q.Close()
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			q.Close()
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			q.Close()
+		}
+	}
+	return nil
+}
+
+// SetFanOutConfig applies the slow subscriber handling to every queue of the wrapper.
+func (wrapper *GoChanTripMessageQueueWrapper) SetFanOutConfig(config FanOutConfig) {
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			q.SetFanOutConfig(config)
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			q.SetFanOutConfig(config)
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			q.SetFanOutConfig(config)
+		}
+	}
+}
+
+// SetMetrics sets the metrics of every queue of the wrapper, nil counts nothing.
+func (wrapper *GoChanTripMessageQueueWrapper) SetMetrics(metrics mq.Metrics) {
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			q.SetMetrics(metrics)
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			q.SetMetrics(metrics)
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			q.SetMetrics(metrics)
+		}
+	}
+}
+
 // NewGoChanTripMessageQueueWrapper creates a new instance of GoChanTripMessageQueueWrapper.
 func NewGoChanTripMessageQueueWrapper() mq.TripMessageQueueWrapper {
 	wrapper := GoChanTripMessageQueueWrapper{}
+	// trip only need remove
+	wrapper.TripMQArray[mq.ActionCreate] = nil
+	wrapper.TripMQArray[mq.ActionUpdate] = nil
+	wrapper.TripMQArray[mq.ActionDelete] = NewChannelTripMessageQueue(mq.ActionDelete, 0)
 	// address need add and remove
 	wrapper.AddressMQArray[mq.ActionCreate] = NewChannelTripAddressMessageQueue(mq.ActionCreate, 0)
 	wrapper.AddressMQArray[mq.ActionUpdate] = nil
@@ -268,6 +794,14 @@ func NewGoChanTripMessageQueueWrapper() mq.TripMessageQueueWrapper {
 	return &wrapper
 }
 
+// NewGoChanTripMessageQueueWrapperWithMetrics works like NewGoChanTripMessageQueueWrapper,
+// every queue of the wrapper reports its message counts to metrics.
+func NewGoChanTripMessageQueueWrapperWithMetrics(metrics mq.Metrics) mq.TripMessageQueueWrapper {
+	wrapper := NewGoChanTripMessageQueueWrapper().(*GoChanTripMessageQueueWrapper)
+	wrapper.SetMetrics(metrics)
+	return wrapper
+}
+
 // QueueError --- Error Definitions ---
 // Note: These errors are less relevant now that `Publish` only indicates acceptance into the queue.
 // If you need fan-out specific errors, consider a more complex return from `Publish` or a separate error channel.
@@ -278,5 +812,7 @@ func (e QueueError) Error() string {
 }
 
 const (
-	FullQueueError QueueError = "main queue is full"
+	FullQueueError          QueueError = "main queue is full"
+	TooManySubscribersError QueueError = "subscriber limit exceeded"
+	ClosedQueueError        QueueError = "queue is closed"
 )
@@ -58,7 +58,7 @@ func TestNewFanOutQueueCore(t *testing.T) {
 
 	t.Run("Unbuffered", func(t *testing.T) {
 		t.Parallel()
-		core := newFanOutQueueCore[MockItem](0)
+		core := newFanOutQueueCore[MockItem](0, FanOutConfig{})
 		if core == nil {
 			t.Fatal("newFanOutQueueCore returned nil for unbuffered")
 		}
@@ -84,7 +84,7 @@ func TestNewFanOutQueueCore(t *testing.T) {
 	t.Run("Buffered", func(t *testing.T) {
 		t.Parallel()
 		bufferSize := 10
-		core := newFanOutQueueCore[MockItem](bufferSize)
+		core := newFanOutQueueCore[MockItem](bufferSize, FanOutConfig{})
 		if core == nil {
 			t.Fatal("newFanOutQueueCore returned nil for buffered")
 		}
@@ -104,7 +104,7 @@ func TestNewFanOutQueueCore(t *testing.T) {
 
 func TestFanOutQueueCore_PublishSubscribeDeSubscribe_Simple(t *testing.T) {
 	t.Parallel()
-	core := newFanOutQueueCore[MockItem](10)
+	core := newFanOutQueueCore[MockItem](10, FanOutConfig{})
 	defer core.Stop()
 	topic := uuid.New()
 	id1, subChan1, err := core.Subscribe(topic)
@@ -172,7 +172,7 @@ func TestFanOutQueueCore_PublishSubscribeDeSubscribe_Simple(t *testing.T) {
 
 func TestFanOutQueueCore_MultipleSubscribers(t *testing.T) {
 	t.Parallel()
-	core := newFanOutQueueCore[MockItem](10)
+	core := newFanOutQueueCore[MockItem](10, FanOutConfig{})
 	defer core.Stop()
 
 	numSubscribers := 3
@@ -252,7 +252,7 @@ func TestFanOutQueueCore_MultipleSubscribers(t *testing.T) {
 
 func TestFanOutQueueCore_DeSubscribeNonExistent(t *testing.T) {
 	t.Parallel()
-	core := newFanOutQueueCore[MockItem](0)
+	core := newFanOutQueueCore[MockItem](0, FanOutConfig{})
 	defer core.Stop()
 
 	nonExistentID := uuid.New()
@@ -269,7 +269,7 @@ func TestFanOutQueueCore_DeSubscribeNonExistent(t *testing.T) {
 
 func TestFanOutQueueCore_Stop(t *testing.T) {
 	t.Parallel()
-	core := newFanOutQueueCore[MockItem](5) // Buffered publishChan
+	core := newFanOutQueueCore[MockItem](5, FanOutConfig{}) // Buffered publishChan
 
 	topic1 := uuid.New()
 	topic2 := uuid.New()
@@ -358,11 +358,32 @@ func TestFanOutQueueCore_Stop(t *testing.T) {
 	} // cleanup
 }
 
+func TestFanOutQueueCore_StopTwice(t *testing.T) {
+	t.Parallel()
+	core := newFanOutQueueCore[MockItem](5, FanOutConfig{})
+	_, _, _ = core.Subscribe(uuid.New())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			core.Stop() // e.g. from both a defer and a shutdown handler
+		}()
+	}
+	wg.Wait()
+	core.Stop()
+
+	q := NewChannelTripRecordMessageQueue(mq.ActionCreate, 0)
+	q.Stop()
+	q.Stop()
+}
+
 func TestFanOutQueueCore_BlockedSubscriberWillRemove(t *testing.T) {
 	t.Parallel()
 
 	// check will break subscriber when target is block
-	core := newFanOutQueueCore[MockItem](1) // publishChan needs to accept message
+	core := newFanOutQueueCore[MockItem](1, FanOutConfig{}) // publishChan needs to accept message
 	topic := uuid.New()
 	id, subChan, err := core.Subscribe(topic)
 	if err != nil {
@@ -395,11 +416,300 @@ func TestFanOutQueueCore_BlockedSubscriberWillRemove(t *testing.T) {
 	core.Stop()
 }
 
+func TestFanOutQueueCore_DropPolicy(t *testing.T) {
+	t.Parallel()
+
+	subscribed := func(core *fanOutQueueCore[MockItem], id uuid.UUID) bool {
+		core.mu.RLock()
+		defer core.mu.RUnlock()
+		_, ok := core.subscribers[id]
+		return ok
+	}
+	// publishAll publishes the values to topic, the subscriber channel holds 2 of them
+	publishAll := func(t *testing.T, core *fanOutQueueCore[MockItem], topic uuid.UUID, values ...int) {
+		t.Helper()
+		for _, v := range values {
+			if pubErr := core.Publish(MockItem{Value: v, TopicID: topic}); pubErr != nil {
+				t.Fatalf("Publish %d failed: %v", v, pubErr)
+			}
+		}
+	}
+
+	t.Run("DropSubscriber removes and closes after timeout", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](2, FanOutConfig{SendTimeout: 20 * time.Millisecond, DropPolicy: DropSubscriber})
+		defer core.Stop()
+		topic := uuid.New()
+		id, subChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2, 3)
+		time.Sleep(200 * time.Millisecond)
+
+		if subscribed(core, id) {
+			t.Error("slow subscriber should be removed")
+		}
+		for _, want := range []int{1, 2} {
+			if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != want {
+				t.Fatalf("expected buffered message %d, got %v %v", want, msg.Value, ok)
+			}
+		}
+		if _, ok := receiveMsgWithTimeout(t, subChan, time.Second); ok {
+			t.Error("channel of removed subscriber should be closed")
+		}
+	})
+
+	t.Run("SkipMessage keeps the subscriber", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](2, FanOutConfig{SendTimeout: 20 * time.Millisecond, DropPolicy: SkipMessage})
+		defer core.Stop()
+		topic := uuid.New()
+		id, subChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2, 3)
+		time.Sleep(200 * time.Millisecond)
+
+		if !subscribed(core, id) {
+			t.Fatal("slow subscriber should stay subscribed")
+		}
+		for _, want := range []int{1, 2} {
+			if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != want {
+				t.Fatalf("expected buffered message %d, got %v %v", want, msg.Value, ok)
+			}
+		}
+		// message 3 was skipped, the next one is delivered
+		publishAll(t, core, topic, 4)
+		if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != 4 {
+			t.Errorf("expected message 4 after the skipped one, got %v %v", msg.Value, ok)
+		}
+	})
+
+	t.Run("Block delivers every message", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](2, FanOutConfig{SendTimeout: 20 * time.Millisecond, DropPolicy: Block})
+		defer core.Stop()
+		topic := uuid.New()
+		id, subChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2, 3)
+		time.Sleep(200 * time.Millisecond)
+
+		if !subscribed(core, id) {
+			t.Fatal("slow subscriber should stay subscribed")
+		}
+		for _, want := range []int{1, 2, 3} {
+			if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != want {
+				t.Fatalf("expected message %d, got %v %v", want, msg.Value, ok)
+			}
+		}
+	})
+
+	t.Run("Block does not hold up DeSubscribe", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{DropPolicy: Block})
+		topic := uuid.New()
+		id, _, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2)
+		time.Sleep(100 * time.Millisecond) // the fan-out is now waiting for the subscriber
+
+		done := make(chan error, 1)
+		go func() { done <- core.DeSubscribe(id) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("DeSubscribe failed: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("DeSubscribe blocked by the waiting fan-out")
+		}
+		core.Stop()
+	})
+}
+
+func TestFanOutQueueCore_PauseResume(t *testing.T) {
+	t.Parallel()
+
+	subscribed := func(core *fanOutQueueCore[MockItem], id uuid.UUID) bool {
+		core.mu.RLock()
+		defer core.mu.RUnlock()
+		_, ok := core.subscribers[id]
+		return ok
+	}
+	publishAll := func(t *testing.T, core *fanOutQueueCore[MockItem], topic uuid.UUID, values ...int) {
+		t.Helper()
+		for _, v := range values {
+			if pubErr := core.Publish(MockItem{Value: v, TopicID: topic}); pubErr != nil {
+				t.Fatalf("Publish %d failed: %v", v, pubErr)
+			}
+		}
+	}
+
+	t.Run("Paused subscriber buffers and is kept", func(t *testing.T) {
+		t.Parallel()
+		// without pausing, DropSubscriber would remove the subscriber once its buffer of 2 is full
+		core := newFanOutQueueCore[MockItem](2, FanOutConfig{DropPolicy: DropSubscriber})
+		defer core.Stop()
+		topic := uuid.New()
+		id, subChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if err := core.Pause(id); err != nil {
+			t.Fatalf("Pause failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2, 3)
+		time.Sleep(100 * time.Millisecond)
+		if !subscribed(core, id) {
+			t.Fatal("paused subscriber should stay subscribed")
+		}
+
+		if err := core.Resume(id); err != nil {
+			t.Fatalf("Resume failed: %v", err)
+		}
+		// 1 and 2 were buffered, 3 did not fit and was dropped
+		for _, want := range []int{1, 2} {
+			if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != want {
+				t.Fatalf("expected buffered message %d, got %v %v", want, msg.Value, ok)
+			}
+		}
+		publishAll(t, core, topic, 4)
+		if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != 4 {
+			t.Errorf("expected message 4 after resume, got %v %v", msg.Value, ok)
+		}
+	})
+
+	t.Run("PauseDrop drops while paused", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](2, FanOutConfig{DropPolicy: DropSubscriber, PauseDrop: true})
+		defer core.Stop()
+		topic := uuid.New()
+		id, subChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if err := core.Pause(id); err != nil {
+			t.Fatalf("Pause failed: %v", err)
+		}
+
+		publishAll(t, core, topic, 1, 2)
+		time.Sleep(100 * time.Millisecond)
+		if err := core.Resume(id); err != nil {
+			t.Fatalf("Resume failed: %v", err)
+		}
+		publishAll(t, core, topic, 3)
+		if msg, ok := receiveMsgWithTimeout(t, subChan, time.Second); !ok || msg.Value != 3 {
+			t.Errorf("expected only message 3 published after resume, got %v %v", msg.Value, ok)
+		}
+	})
+
+	t.Run("Block does not wait for a paused subscriber", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{SendTimeout: 20 * time.Millisecond, DropPolicy: Block})
+		defer core.Stop()
+		topic := uuid.New()
+		pausedID, _, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		_, activeChan, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if err := core.Pause(pausedID); err != nil {
+			t.Fatalf("Pause failed: %v", err)
+		}
+
+		// the paused channel is full after the first message, the others reach the active subscriber anyway
+		for _, want := range []int{1, 2, 3} {
+			publishAll(t, core, topic, want)
+			if msg, ok := receiveMsgWithTimeout(t, activeChan, time.Second); !ok || msg.Value != want {
+				t.Fatalf("expected message %d for the active subscriber, got %v %v", want, msg.Value, ok)
+			}
+		}
+	})
+
+	t.Run("Unknown subscriber", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{})
+		defer core.Stop()
+		if err := core.Pause(uuid.New()); err == nil {
+			t.Error("Pause of an unknown subscriber should fail")
+		}
+		if err := core.Resume(uuid.New()); err == nil {
+			t.Error("Resume of an unknown subscriber should fail")
+		}
+	})
+}
+
+func TestFanOutQueueCore_ConcurrentDeSubscribeDuringFanOut(t *testing.T) {
+	t.Parallel()
+
+	// subscribers leave while messages are fanned out to them, a remaining subscriber still gets every message
+	core := newFanOutQueueCore[MockItem](16, FanOutConfig{})
+	topic := uuid.New()
+	_, stayChan, err := core.Subscribe(topic)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id, _, err := core.Subscribe(topic)
+				if err != nil {
+					t.Errorf("Subscribe failed: %v", err)
+					return
+				}
+				// the fan-out may have removed it already when it did not keep up
+				_ = core.DeSubscribe(id)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if pubErr := core.Publish(MockItem{Value: i, TopicID: topic}); pubErr != nil {
+			t.Fatalf("Publish failed: %v", pubErr)
+		}
+		msg, ok := receiveMsgWithTimeout(t, stayChan, time.Second)
+		if !ok {
+			t.Fatalf("remaining subscriber did not get message %d", i)
+		}
+		if msg.Value != i {
+			t.Fatalf("remaining subscriber got message %d, want %d", msg.Value, i)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	core.Stop()
+}
+
 func TestFanOutQueueCore_Rec2PublishWithSameConnection(t *testing.T) {
 	t.Parallel()
 
 	// check will break subscriber when target is block
-	core := newFanOutQueueCore[MockItem](2) // publishChan needs to accept message
+	core := newFanOutQueueCore[MockItem](2, FanOutConfig{}) // publishChan needs to accept message
 	topic := uuid.New()
 	_, subChan, err := core.Subscribe(topic)
 	if err != nil {
@@ -438,7 +748,7 @@ func TestFanOutQueueCore_Rec2PublishWithSameConnection(t *testing.T) {
 func TestFanOutQueueCore_PublishToFullPublishChan_ReturnsError(t *testing.T) {
 	t.Parallel()
 	bufferSize := 1
-	core := newFanOutQueueCore[MockItem](bufferSize)
+	core := newFanOutQueueCore[MockItem](bufferSize, FanOutConfig{})
 	// No defer core.Stop() here.
 	topic := uuid.New()
 	// Create a subscriber whose channel will block, causing startFanOutRoutine to block.
@@ -502,7 +812,7 @@ func TestFanOutQueueCore_PublishNoSubscribers(t *testing.T) {
 	t.Parallel()
 	t.Run("UnbufferedPublishChan", func(t *testing.T) {
 		t.Parallel()
-		core := newFanOutQueueCore[MockItem](0) // Unbuffered publishChan
+		core := newFanOutQueueCore[MockItem](0, FanOutConfig{}) // Unbuffered publishChan
 		defer core.Stop()
 		// Publish should succeed. fanOutRoutine consumes from publishChan.
 		// If no subscribers, message is effectively dropped by fanOutRoutine.
@@ -515,7 +825,7 @@ func TestFanOutQueueCore_PublishNoSubscribers(t *testing.T) {
 
 	t.Run("BufferedPublishChan", func(t *testing.T) {
 		t.Parallel()
-		core := newFanOutQueueCore[MockItem](5) // Buffered publishChan
+		core := newFanOutQueueCore[MockItem](5, FanOutConfig{}) // Buffered publishChan
 		defer core.Stop()
 		// Publish should succeed and message goes into the buffer.
 		// fanOutRoutine will consume it and drop it.
@@ -527,6 +837,136 @@ func TestFanOutQueueCore_PublishNoSubscribers(t *testing.T) {
 	})
 }
 
+func TestFanOutQueueCore_SubscriberLimit(t *testing.T) {
+	t.Parallel()
+	t.Run("PerTopic", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{})
+		defer core.Stop()
+		core.setSubscriberLimit(2, 0)
+		topic := uuid.New()
+
+		id1, _, err := core.Subscribe(topic)
+		if err != nil {
+			t.Fatalf("Subscribe 1 failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(topic); err != nil {
+			t.Fatalf("Subscribe 2 failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(topic); err != TooManySubscribersError {
+			t.Fatalf("Expected TooManySubscribersError for subscribe 3, got %v", err)
+		}
+		// other topics keep their own quota
+		if _, _, err := core.Subscribe(uuid.New()); err != nil {
+			t.Fatalf("Subscribe to other topic failed: %v", err)
+		}
+
+		// DeSubscribe frees a slot
+		if err := core.DeSubscribe(id1); err != nil {
+			t.Fatalf("DeSubscribe failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(topic); err != nil {
+			t.Fatalf("Subscribe after DeSubscribe failed: %v", err)
+		}
+	})
+
+	t.Run("Total", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{})
+		defer core.Stop()
+		core.setSubscriberLimit(0, 2)
+
+		id1, _, err := core.Subscribe(uuid.New())
+		if err != nil {
+			t.Fatalf("Subscribe 1 failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(uuid.New()); err != nil {
+			t.Fatalf("Subscribe 2 failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(uuid.New()); err != TooManySubscribersError {
+			t.Fatalf("Expected TooManySubscribersError for subscribe 3, got %v", err)
+		}
+
+		if err := core.DeSubscribe(id1); err != nil {
+			t.Fatalf("DeSubscribe failed: %v", err)
+		}
+		if _, _, err := core.Subscribe(uuid.New()); err != nil {
+			t.Fatalf("Subscribe after DeSubscribe failed: %v", err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Parallel()
+		core := newFanOutQueueCore[MockItem](1, FanOutConfig{})
+		defer core.Stop()
+		topic := uuid.New()
+		for i := 0; i < 100; i++ {
+			if _, _, err := core.Subscribe(topic); err != nil {
+				t.Fatalf("Subscribe %d failed without limit: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestFanOutQueueCore_SubscribeWithKey(t *testing.T) {
+	t.Parallel()
+	core := newFanOutQueueCore[MockItem](10, FanOutConfig{})
+	defer core.Stop()
+	topic := uuid.New()
+
+	id1, subChan1, err := core.SubscribeWithKey(topic, "client-1")
+	if err != nil {
+		t.Fatalf("SubscribeWithKey 1 failed: %v", err)
+	}
+	id2, subChan2, err := core.SubscribeWithKey(topic, "client-1")
+	if err != nil {
+		t.Fatalf("SubscribeWithKey 2 failed: %v", err)
+	}
+	if id1 == id2 {
+		t.Error("Expected a new subscriber ID for the re-subscription")
+	}
+
+	// the first channel is replaced and closed
+	if !isChanClosed(subChan1) {
+		t.Error("Expected first channel to be closed after re-subscription with the same key")
+	}
+	core.mu.RLock()
+	count := len(core.subscribers)
+	core.mu.RUnlock()
+	if count != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", count)
+	}
+	if err := core.DeSubscribe(id1); err == nil {
+		t.Error("Expected error when desubscribing the replaced ID, got nil")
+	}
+
+	// different key and no key are not replaced
+	if _, _, err := core.SubscribeWithKey(topic, "client-2"); err != nil {
+		t.Fatalf("SubscribeWithKey client-2 failed: %v", err)
+	}
+	if _, _, err := core.Subscribe(topic); err != nil {
+		t.Fatalf("Subscribe without key failed: %v", err)
+	}
+	core.mu.RLock()
+	count = len(core.subscribers)
+	core.mu.RUnlock()
+	if count != 3 {
+		t.Errorf("Expected 3 subscribers, got %d", count)
+	}
+
+	testMsg := MockItem{Value: 969, TopicID: topic}
+	if err := core.Publish(testMsg); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	receivedMsg, ok := receiveMsgWithTimeout(t, subChan2, 100*time.Millisecond)
+	if !ok {
+		t.Fatal("Failed to receive message on the replacing channel")
+	}
+	if receivedMsg != testMsg {
+		t.Errorf("Expected message %+v, got %+v", testMsg, receivedMsg)
+	}
+}
+
 // --- ChannelTripRecordMessageQueue Tests ---
 
 // Mock db.Address if not available from dtm/db/db for test environment
@@ -534,6 +974,51 @@ func TestFanOutQueueCore_PublishNoSubscribers(t *testing.T) {
 // For the test, we assume db.Address is available and can be instantiated.
 var testAddress = db.Address("testAddress")
 
+func TestFanOutQueueCore_DeSubscribeTrip(t *testing.T) {
+	t.Parallel()
+	core := newFanOutQueueCore[MockItem](10, FanOutConfig{})
+	defer core.Stop()
+	trip := uuid.New()
+	otherTrip := uuid.New()
+
+	var tripChans []<-chan MockItem
+	for i := 0; i < 3; i++ {
+		_, subChan, err := core.Subscribe(trip)
+		if err != nil {
+			t.Fatalf("Subscribe %d failed: %v", i, err)
+		}
+		tripChans = append(tripChans, subChan)
+	}
+	otherID, otherChan, err := core.Subscribe(otherTrip)
+	if err != nil {
+		t.Fatalf("Subscribe other trip failed: %v", err)
+	}
+
+	if err := core.DeSubscribeTrip(trip); err != nil {
+		t.Fatalf("DeSubscribeTrip failed: %v", err)
+	}
+	for i, subChan := range tripChans {
+		if !isChanClosed(subChan) {
+			t.Errorf("Expected channel %d of the trip to be closed", i)
+		}
+	}
+	if isChanClosed(otherChan) {
+		t.Error("Expected channel of other trip to stay open")
+	}
+	core.mu.RLock()
+	_, otherExists := core.subscribers[otherID]
+	count := len(core.subscribers)
+	core.mu.RUnlock()
+	if count != 1 || !otherExists {
+		t.Errorf("Expected only the other trip subscriber left, got %d subscribers", count)
+	}
+
+	// trip without subscribers is a no-op
+	if err := core.DeSubscribeTrip(trip); err != nil {
+		t.Errorf("DeSubscribeTrip without subscribers failed: %v", err)
+	}
+}
+
 func TestNewChannelTripRecordMessageQueue(t *testing.T) {
 	t.Parallel()
 	q := NewChannelTripRecordMessageQueue(mq.ActionCreate, 5)
@@ -629,7 +1114,7 @@ func TestChannelTripRecordMessageQueue_PublishError(t *testing.T) {
 
 	// blockerChan will just have first one
 	final := <-blockerChan
-	if final != msg1 {
+	if !reflect.DeepEqual(final, msg1) {
 		t.Fatalf("final msg will be the first one block in second queue")
 	}
 
@@ -740,6 +1225,77 @@ func TestChannelTripAddressMessageQueue_PublishErrorIgnored(t *testing.T) {
 	q.Stop()
 }
 
+func TestChannelTripMessageQueue_DeleteEventDelivered(t *testing.T) {
+	t.Parallel()
+	wrapperIFace := NewGoChanTripMessageQueueWrapper()
+	wrapper, _ := wrapperIFace.(*GoChanTripMessageQueueWrapper)
+	defer func() {
+		for i := range wrapper.TripMQArray {
+			if wrapper.TripMQArray[i] != nil {
+				wrapper.TripMQArray[i].Stop()
+			}
+		}
+		for i := range wrapper.AddressMQArray {
+			if wrapper.AddressMQArray[i] != nil {
+				wrapper.AddressMQArray[i].Stop()
+			}
+		}
+		for i := range wrapper.RecordMQArray {
+			if wrapper.RecordMQArray[i] != nil {
+				wrapper.RecordMQArray[i].Stop()
+			}
+		}
+	}()
+
+	// only delete event is published for trip
+	if q := wrapperIFace.GetTripMessageQueue(mq.ActionCreate); q != nil {
+		t.Errorf("GetTripMessageQueue(ActionCreate) expected nil, got %T", q)
+	}
+	if q := wrapperIFace.GetTripMessageQueue(mq.ActionUpdate); q != nil {
+		t.Errorf("GetTripMessageQueue(ActionUpdate) expected nil, got %T", q)
+	}
+	if q := wrapperIFace.GetTripMessageQueue(mq.Action(99)); q != nil {
+		t.Errorf("GetTripMessageQueue(Action(99)) expected nil, got %T", q)
+	}
+	if q := wrapperIFace.GetTripMessageQueue(mq.ActionDelete); q == nil {
+		t.Fatal("GetTripMessageQueue(ActionDelete) returned nil, expected a queue")
+	}
+
+	// buffered queue so the fan-out does not drop the event before the test reads it
+	q := NewChannelTripMessageQueue(mq.ActionDelete, 10)
+	defer q.Stop()
+
+	tripID := uuid.New()
+	id, subChan, err := q.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	_, otherChan, err := q.Subscribe(uuid.New())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	msg := mq.TripMessage{ID: tripID, Name: "Deleted Trip"}
+	if pubErr := q.Publish(msg); pubErr != nil {
+		t.Fatalf("Publish failed: %v", pubErr)
+	}
+
+	receivedMsg, ok := receiveMsgWithTimeout(t, subChan, 500*time.Millisecond)
+	if !ok {
+		t.Fatal("Failed to receive TripMessage or channel closed/timed out")
+	}
+	if !reflect.DeepEqual(receivedMsg, msg) {
+		t.Errorf("Expected message %+v, got %+v", msg, receivedMsg)
+	}
+	if otherMsg, ok := receiveMsgWithTimeout(t, otherChan, 100*time.Millisecond); ok {
+		t.Errorf("Subscriber of other trip should not receive delete event, got %+v", otherMsg)
+	}
+
+	if err := q.DeSubscribe(id); err != nil {
+		t.Fatalf("DeSubscribe failed: %v", err)
+	}
+}
+
 // --- GoChanTripMessageQueueWrapper Tests ---
 
 func TestNewGoChanTripMessageQueueWrapper(t *testing.T) {
@@ -762,6 +1318,11 @@ func TestNewGoChanTripMessageQueueWrapper(t *testing.T) {
 				wrapper.RecordMQArray[i].Stop()
 			}
 		}
+		for i := range wrapper.TripMQArray {
+			if wrapper.TripMQArray[i] != nil {
+				wrapper.TripMQArray[i].Stop()
+			}
+		}
 	}()
 
 	// Verify Address MQs
@@ -818,6 +1379,11 @@ func TestGoChanTripMessageQueueWrapper_GetQueues(t *testing.T) {
 					wrapper.RecordMQArray[i].Stop()
 				}
 			}
+			for i := range wrapper.TripMQArray {
+				if wrapper.TripMQArray[i] != nil {
+					wrapper.TripMQArray[i].Stop()
+				}
+			}
 		}
 	}()
 
@@ -868,3 +1434,251 @@ func TestGoChanTripMessageQueueWrapper_GetQueues(t *testing.T) {
 		t.Errorf("GetTripAddressMessageQueue(Action(-1)) expected nil, got %T", q)
 	}
 }
+
+func TestChannelTripRecordMessageQueue_PublishWithReceipt(t *testing.T) {
+	t.Parallel()
+	q := NewChannelTripRecordMessageQueue(mq.ActionCreate, 5) // buffered, messages are received after publishing
+	defer q.Stop()
+	var _ mq.ReceiptPublisher[mq.TripRecordMessage] = q
+
+	tripID := uuid.New()
+	subID, subChan, err := q.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer func() { _ = q.DeSubscribe(subID) }()
+
+	var receipts []mq.Receipt
+	for i := 0; i < 2; i++ {
+		receipt, err := q.PublishWithReceipt(mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: fmt.Sprintf("record %d", i)})
+		if err != nil {
+			t.Fatalf("PublishWithReceipt failed: %v", err)
+		}
+		if receipt.ID == "" {
+			t.Fatal("receipt ID is empty")
+		}
+		receipts = append(receipts, receipt)
+	}
+	if receipts[0].ID == receipts[1].ID {
+		t.Errorf("receipt IDs should be unique, got %q twice", receipts[0].ID)
+	}
+
+	for i, receipt := range receipts {
+		msg, ok := receiveMsgWithTimeout(t, subChan, time.Second)
+		if !ok {
+			t.Fatalf("message %d not received", i)
+		}
+		if msg.MessageID != receipt.ID {
+			t.Errorf("delivered MessageID %q does not match receipt ID %q", msg.MessageID, receipt.ID)
+		}
+	}
+}
+
+func TestGoChanTripMessageQueueWrapper_Close(t *testing.T) {
+	t.Parallel()
+	wrapperIFace := NewGoChanTripMessageQueueWrapper()
+	tripID := uuid.New()
+	_, subChan, err := wrapperIFace.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		if err := wrapperIFace.Close(); err != nil {
+			closed <- err
+			return
+		}
+		closed <- wrapperIFace.Close() // second call is a no-op
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return, a fan-out goroutine is still running")
+	}
+
+	// the fan-out goroutines have exited, publishing is rejected instead of panicking on the closed channel
+	wrapper := wrapperIFace.(*GoChanTripMessageQueueWrapper)
+	for i, q := range wrapper.RecordMQArray {
+		if q == nil {
+			continue
+		}
+		if err := q.Publish(mq.TripRecordMessage{TripID: tripID}); err != ClosedQueueError {
+			t.Errorf("RecordMQArray[%d]: expected ClosedQueueError after Close, got %v", i, err)
+		}
+	}
+	for i, q := range wrapper.AddressMQArray {
+		if q == nil {
+			continue
+		}
+		if err := q.Publish(mq.TripAddressMessage{TripID: tripID}); err != ClosedQueueError {
+			t.Errorf("AddressMQArray[%d]: expected ClosedQueueError after Close, got %v", i, err)
+		}
+	}
+	if err := wrapper.TripMQArray[mq.ActionDelete].Publish(mq.TripMessage{ID: tripID}); err != ClosedQueueError {
+		t.Errorf("TripMQArray[ActionDelete]: expected ClosedQueueError after Close, got %v", err)
+	}
+	if _, ok := receiveMsgWithTimeout(t, subChan, 100*time.Millisecond); ok {
+		t.Error("expected no message after Close")
+	}
+	if !isChanClosed(subChan) {
+		t.Error("expected the subscriber channel to be closed by Close")
+	}
+	if _, _, err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(tripID); err != ClosedQueueError {
+		t.Errorf("expected ClosedQueueError for Subscribe after Close, got %v", err)
+	}
+}
+
+func TestGoChanTripMessageQueueWrapper_CloseEndsRange(t *testing.T) {
+	t.Parallel()
+	wrapper := NewGoChanTripMessageQueueWrapper()
+	// a published message waits for the subscriber, Close lets the fan-out finish it
+	wrapper.(*GoChanTripMessageQueueWrapper).SetFanOutConfig(FanOutConfig{SendTimeout: time.Second})
+	queue := wrapper.GetTripAddressMessageQueue(mq.ActionCreate)
+	tripID := uuid.New()
+	_, subChan, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	done := make(chan int)
+	go func() {
+		received := 0
+		for range subChan {
+			received++
+		}
+		done <- received
+	}()
+	if err := queue.Publish(mq.TripAddressMessage{TripID: tripID, Address: "Alice"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if err := wrapper.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case received := <-done:
+		if received != 1 {
+			t.Errorf("expected 1 message before the range ended, got %d", received)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("range over the subscriber channel did not end after Close")
+	}
+}
+
+// countingMetrics counts the reported messages by action.
+type countingMetrics struct {
+	mu        sync.Mutex
+	published map[mq.Action]int
+	delivered map[mq.Action]int
+	dropped   map[mq.Action]int
+}
+
+func newCountingMetrics() *countingMetrics {
+	return &countingMetrics{
+		published: make(map[mq.Action]int),
+		delivered: make(map[mq.Action]int),
+		dropped:   make(map[mq.Action]int),
+	}
+}
+
+func (m *countingMetrics) IncPublished(action mq.Action) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published[action]++
+}
+
+func (m *countingMetrics) IncDelivered(action mq.Action) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delivered[action]++
+}
+
+func (m *countingMetrics) IncDropped(action mq.Action) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped[action]++
+}
+
+func (m *countingMetrics) counts(action mq.Action) (published, delivered, dropped int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.published[action], m.delivered[action], m.dropped[action]
+}
+
+func TestChannelQueue_Metrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Slow subscriber is counted as dropped", func(t *testing.T) {
+		t.Parallel()
+		metrics := newCountingMetrics()
+		q := NewChannelTripRecordMessageQueue(mq.ActionCreate, 1)
+		defer q.Stop()
+		q.SetMetrics(metrics)
+		tripID := uuid.New()
+		_, fastChan, err := q.Subscribe(tripID)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		_, slowChan, err := q.Subscribe(tripID)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		// both take the first message into their buffer of 1
+		if err := q.Publish(mq.TripRecordMessage{TripID: tripID, Name: "first"}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if _, ok := receiveMsgWithTimeout(t, fastChan, time.Second); !ok {
+			t.Fatal("fast subscriber did not get the first message")
+		}
+		deadline := time.Now().Add(time.Second)
+		for len(slowChan) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// the slow subscriber still holds the first message, so it is removed
+		if err := q.Publish(mq.TripRecordMessage{TripID: tripID, Name: "second"}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if _, ok := receiveMsgWithTimeout(t, fastChan, time.Second); !ok {
+			t.Fatal("fast subscriber did not get the second message")
+		}
+		if _, ok := receiveMsgWithTimeout(t, slowChan, time.Second); !ok {
+			t.Fatal("slow subscriber lost its buffered message")
+		}
+		if _, ok := receiveMsgWithTimeout(t, slowChan, time.Second); ok {
+			t.Fatal("slow subscriber should be removed after the second message")
+		}
+
+		published, delivered, dropped := metrics.counts(mq.ActionCreate)
+		if published != 2 || delivered != 3 || dropped != 1 {
+			t.Errorf("counts = published %d, delivered %d, dropped %d, want 2, 3, 1", published, delivered, dropped)
+		}
+		if published, delivered, dropped := metrics.counts(mq.ActionDelete); published+delivered+dropped != 0 {
+			t.Errorf("unexpected counts for another action: %d, %d, %d", published, delivered, dropped)
+		}
+	})
+
+	t.Run("Wrapper reports the action of each queue", func(t *testing.T) {
+		t.Parallel()
+		metrics := newCountingMetrics()
+		wrapper := NewGoChanTripMessageQueueWrapperWithMetrics(metrics)
+		defer wrapper.Close()
+
+		tripID := uuid.New()
+		if err := wrapper.GetTripAddressMessageQueue(mq.ActionDelete).Publish(mq.TripAddressMessage{TripID: tripID}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if err := wrapper.GetTripRecordMessageQueue(mq.ActionUpdate).Publish(mq.TripRecordMessage{TripID: tripID}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		for action, want := range map[mq.Action]int{mq.ActionCreate: 0, mq.ActionUpdate: 1, mq.ActionDelete: 1} {
+			if published, _, _ := metrics.counts(action); published != want {
+				t.Errorf("published %s = %d, want %d", action, published, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"os"
+	"strings"
+)
+
+const defaultBrokers = "localhost:9092"
+
+// GetKafkaBrokers returns the broker addresses of the comma separated KAFKA_BROKERS, localhost:9092 when not set.
+func GetKafkaBrokers() []string {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		brokers = defaultBrokers
+	}
+	var list []string
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			list = append(list, broker)
+		}
+	}
+	return list
+}
+
+// GetKafkaConfig returns the wrapper config of the environment, KAFKA_PARTITION_KEY is trip_id (default) or round_robin.
+func GetKafkaConfig() (Config, error) {
+	name := os.Getenv("KAFKA_PARTITION_KEY")
+	if name == "" {
+		return Config{}, nil
+	}
+	partitionKey, err := ParsePartitionKey(name)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{PartitionKey: partitionKey}, nil
+}
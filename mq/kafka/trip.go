@@ -0,0 +1,562 @@
+package kafka
+
+import (
+	"context"
+	"dtm/mq/mq"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	tripIDHeader = "tripId"
+	// topics missing on the cluster are created with these settings, create them beforehand for a replicated setup
+	topicPartitions        = 3
+	topicReplicationFactor = 1
+)
+
+// PartitionKey decides how published messages are spread over the partitions of a topic.
+type PartitionKey int
+
+const (
+	// PartitionByTripID writes all messages of a trip to one partition, a subscriber gets them in publish order.
+	// It is the default.
+	PartitionByTripID PartitionKey = iota
+	// PartitionRoundRobin spreads the messages over every partition for throughput,
+	// messages of a trip may be delivered out of publish order.
+	PartitionRoundRobin
+)
+
+// ParsePartitionKey returns the partition key of the given name, trip_id or round_robin.
+func ParsePartitionKey(name string) (PartitionKey, error) {
+	switch name {
+	case "trip_id":
+		return PartitionByTripID, nil
+	case "round_robin":
+		return PartitionRoundRobin, nil
+	default:
+		return 0, fmt.Errorf("unsupported kafka partition key: %s", name)
+	}
+}
+
+// Config tunes the queues of the wrapper, the zero value keys partitions by trip ID.
+type Config struct {
+	PartitionKey PartitionKey
+}
+
+// balancer returns the writer balancer of the partition key.
+func (k PartitionKey) balancer() kafkago.Balancer {
+	if k == PartitionRoundRobin {
+		return &kafkago.RoundRobin{}
+	}
+	return &kafkago.Hash{}
+}
+
+// subscriptionInfo holds details about an active Kafka subscription.
+type subscriptionInfo struct {
+	tripID uuid.UUID
+	cancel context.CancelFunc
+}
+
+// GenericKafkaService provides a generic implementation for Kafka operations.
+// Messages are keyed by trip ID, by default all messages of a trip land on one partition in order.
+type GenericKafkaService[M any] struct {
+	brokers             []string
+	topic               string
+	partitionKey        PartitionKey
+	writer              *kafkago.Writer
+	activeSubscriptions map[uuid.UUID]*subscriptionInfo
+	subscriptionsMutex  sync.Mutex
+	ctx                 context.Context
+	closeOnce           sync.Once // Close can be called from both defer and shutdown handler
+}
+
+// NewGenericKafkaService creates and initializes a generic service for a specific message type.
+// It ensures the underlying Kafka topic exists, creating it if necessary.
+func NewGenericKafkaService[M any](ctx context.Context, brokers []string, topic string, partitionKey PartitionKey) (*GenericKafkaService[M], error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+	err := withController(ctx, brokers, func(conn *kafkago.Conn) error {
+		return conn.CreateTopics(kafkago.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     topicPartitions,
+			ReplicationFactor: topicReplicationFactor,
+		})
+	})
+	if err != nil && !errors.Is(err, kafkago.TopicAlreadyExists) {
+		return nil, fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+
+	return &GenericKafkaService[M]{
+		brokers:      brokers,
+		topic:        topic,
+		partitionKey: partitionKey,
+		writer: &kafkago.Writer{
+			Addr:         kafkago.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     partitionKey.balancer(),
+			RequiredAcks: kafkago.RequireOne,
+			BatchTimeout: 10 * time.Millisecond, // a publish waits for its batch, keep events snappy
+		},
+		activeSubscriptions: make(map[uuid.UUID]*subscriptionInfo),
+		ctx:                 ctx,
+	}, nil
+}
+
+// dial connects to the first reachable broker.
+func dial(ctx context.Context, brokers []string) (*kafkago.Conn, error) {
+	var errs []error
+	for _, broker := range brokers {
+		conn, err := kafkago.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("failed to connect to Kafka: %w", errors.Join(errs...))
+}
+
+// withController runs fn on a connection to the controller broker, topics can only be created and deleted there.
+func withController(ctx context.Context, brokers []string, fn func(conn *kafkago.Conn) error) error {
+	conn, err := dial(ctx, brokers)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	controller, err := conn.Controller()
+	if err != nil {
+		return fmt.Errorf("failed to find Kafka controller: %w", err)
+	}
+	controllerConn, err := kafkago.DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kafka controller: %w", err)
+	}
+	defer func() { _ = controllerConn.Close() }()
+	return fn(controllerConn)
+}
+
+// Publish sends a message to the configured Kafka topic keyed by its trip ID,
+// the key picks the partition unless the service spreads round-robin.
+func (s *GenericKafkaService[M]) Publish(msg mq.TopicProvider) error {
+	typeName := reflect.TypeOf(msg).Name()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", typeName, err)
+	}
+
+	key := []byte(msg.GetTopic().String())
+	err = s.writer.WriteMessages(s.ctx, kafkago.Message{
+		Key:     key,
+		Value:   body,
+		Headers: []kafkago.Header{{Key: tripIDHeader, Value: key}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s to topic %s: %w", typeName, s.topic, err)
+	}
+	return nil
+}
+
+// PublishWithReceipt works like Publish, the write is acknowledged by the partition leader when it returns.
+func (s *GenericKafkaService[M]) PublishWithReceipt(msg mq.TopicProvider, messageID string) (mq.Receipt, error) {
+	if err := s.Publish(msg); err != nil {
+		return mq.Receipt{}, err
+	}
+	return mq.Receipt{ID: messageID}, nil
+}
+
+// tailsOf returns the partitions the messages of the trip are written to and their current end offsets,
+// a subscription starts reading there so it gets every message published after Subscribe returns.
+// It is one partition when keyed by trip ID, every partition of the topic under round-robin.
+func (s *GenericKafkaService[M]) tailsOf(tripId uuid.UUID) (map[int]int64, error) {
+	conn, err := dial(s.ctx, s.brokers)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	partitions, err := conn.ReadPartitions(s.topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions of topic %s: %w", s.topic, err)
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", s.topic)
+	}
+	ids := make([]int, len(partitions))
+	for i, p := range partitions {
+		ids[i] = p.ID
+	}
+	sort.Ints(ids) // the writer balances over the sorted partition IDs as well
+	if s.partitionKey == PartitionByTripID {
+		ids = []int{(&kafkago.Hash{}).Balance(kafkago.Message{Key: []byte(tripId.String())}, ids...)}
+	}
+
+	tails := make(map[int]int64, len(ids))
+	for _, partition := range ids {
+		leader, err := kafkago.DialLeader(s.ctx, "tcp", conn.RemoteAddr().String(), s.topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to leader of %s/%d: %w", s.topic, partition, err)
+		}
+		offset, err := leader.ReadLastOffset()
+		_ = leader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read offset of %s/%d: %w", s.topic, partition, err)
+		}
+		tails[partition] = offset
+	}
+	return tails, nil
+}
+
+// Subscribe reads the partitions of the trip from their current end and delivers the messages of the trip,
+// messages of other trips sharing a partition are filtered out by key.
+func (s *GenericKafkaService[M]) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan M, error) {
+	subscriptionID := uuid.New() // Internal ID for tracking
+	typeName := reflect.TypeOf(*new(M)).Name()
+
+	tails, err := s.tailsOf(tripId)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to subscribe %s for trip %s: %w", typeName, tripId, err)
+	}
+	readers := make([]*kafkago.Reader, 0, len(tails))
+	closeReaders := func() {
+		for _, reader := range readers {
+			if err := reader.Close(); err != nil {
+				log.Printf("Error closing Kafka reader of %s subscription %s: %v", typeName, subscriptionID, err)
+			}
+		}
+	}
+	for partition, offset := range tails {
+		reader := kafkago.NewReader(kafkago.ReaderConfig{
+			Brokers:   s.brokers,
+			Topic:     s.topic,
+			Partition: partition,
+			MaxWait:   500 * time.Millisecond,
+		})
+		readers = append(readers, reader)
+		if err := reader.SetOffset(offset); err != nil {
+			closeReaders()
+			return uuid.Nil, nil, fmt.Errorf("failed to subscribe %s for trip %s: %w", typeName, tripId, err)
+		}
+	}
+
+	msgChan := make(chan M, 5)
+	// Create a cancellable context for the reader goroutines.
+	receiveCtx, cancel := context.WithCancel(s.ctx)
+
+	s.subscriptionsMutex.Lock()
+	s.activeSubscriptions[subscriptionID] = &subscriptionInfo{tripID: tripId, cancel: cancel}
+	s.subscriptionsMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, reader := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a failing reader stops the whole subscription, its channel must not silently miss a partition
+			defer cancel()
+			s.readInto(receiveCtx, reader, tripId.String(), subscriptionID, msgChan)
+		}()
+	}
+	go func() {
+		// Automatically clean up when every reader has exited.
+		wg.Wait()
+		s.subscriptionsMutex.Lock()
+		delete(s.activeSubscriptions, subscriptionID)
+		s.subscriptionsMutex.Unlock()
+
+		closeReaders()
+		close(msgChan)
+	}()
+
+	return subscriptionID, msgChan, nil
+}
+
+// readInto delivers the messages of the key read by reader to msgChan until ctx is done or the read fails.
+func (s *GenericKafkaService[M]) readInto(ctx context.Context, reader *kafkago.Reader, key string, subscriptionID uuid.UUID, msgChan chan<- M) {
+	typeName := reflect.TypeOf(*new(M)).Name()
+	for {
+		kafkaMsg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error in read loop for %s subscription %s: %v", typeName, subscriptionID, err)
+			}
+			return
+		}
+		if string(kafkaMsg.Key) != key {
+			continue
+		}
+
+		var msg M
+		if err := json.Unmarshal(kafkaMsg.Value, &msg); err != nil {
+			log.Printf("Error unmarshaling %s for %s: %v. Body: %s", typeName, subscriptionID, err, string(kafkaMsg.Value))
+			continue
+		}
+
+		select {
+		case msgChan <- msg:
+		case <-time.After(2 * time.Second):
+			log.Printf("Timeout sending %s to msgChan for %s.", typeName, subscriptionID)
+		case <-ctx.Done(): // Check if we were cancelled while trying to send.
+			return
+		}
+	}
+}
+
+// DeSubscribe stops the reader of the subscription, its channel is closed once the reader exits.
+func (s *GenericKafkaService[M]) DeSubscribe(id uuid.UUID) error {
+	s.subscriptionsMutex.Lock()
+	info, ok := s.activeSubscriptions[id]
+	if ok {
+		// It's removed from the map inside the goroutine's defer block.
+		// Here we just trigger the cancellation.
+		info.cancel()
+	}
+	s.subscriptionsMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("subscription ID %s not found for %s service", id, reflect.TypeOf(*new(M)).Name())
+	}
+	return nil
+}
+
+// DeSubscribeTrip stops the readers of every subscription to the trip.
+func (s *GenericKafkaService[M]) DeSubscribeTrip(tripId uuid.UUID) error {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+
+	for _, info := range s.activeSubscriptions {
+		if info.tripID == tripId {
+			info.cancel()
+		}
+	}
+	return nil
+}
+
+// Close gracefully shuts down all active subscriptions for this service, calls after the first one are no-op.
+func (s *GenericKafkaService[M]) Close() {
+	s.closeOnce.Do(func() {
+		s.subscriptionsMutex.Lock()
+		defer s.subscriptionsMutex.Unlock()
+
+		for _, info := range s.activeSubscriptions {
+			info.cancel()
+		}
+	})
+}
+
+// shutdown closes all active subscriptions and the writer, when cleanup is set the topic is deleted as well.
+func (s *GenericKafkaService[M]) shutdown(cleanup bool) error {
+	s.Close()
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close writer of topic %s: %w", s.topic, err)
+	}
+	if !cleanup {
+		return nil
+	}
+	err := withController(context.Background(), s.brokers, func(conn *kafkago.Conn) error {
+		return conn.DeleteTopics(s.topic)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete topic %s: %w", s.topic, err)
+	}
+	log.Printf("Deleted Kafka topic: %s", s.topic)
+	return nil
+}
+
+type TripMQ struct {
+	genericService *GenericKafkaService[mq.TripMessage]
+	action         mq.Action
+}
+
+func NewTripMessageQueue(ctx context.Context, brokers []string, action mq.Action, partitionKey PartitionKey) (*TripMQ, error) {
+	topic := fmt.Sprintf("trip-%s", action.String())
+	gs, err := NewGenericKafkaService[mq.TripMessage](ctx, brokers, topic, partitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for Trip: %w", err)
+	}
+	return &TripMQ{genericService: gs, action: action}, nil
+}
+func (q *TripMQ) GetAction() mq.Action             { return q.action }
+func (q *TripMQ) Publish(msg mq.TripMessage) error { return q.genericService.Publish(msg) }
+func (q *TripMQ) PublishWithReceipt(msg mq.TripMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
+func (q *TripMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripMessage, error) {
+	return q.genericService.Subscribe(tripId)
+}
+func (q *TripMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+type TripRecordMQ struct {
+	genericService *GenericKafkaService[mq.TripRecordMessage]
+	action         mq.Action
+}
+
+func NewTripRecordMessageQueue(ctx context.Context, brokers []string, action mq.Action, partitionKey PartitionKey) (*TripRecordMQ, error) {
+	topic := fmt.Sprintf("trip-record-%s", action.String())
+	gs, err := NewGenericKafkaService[mq.TripRecordMessage](ctx, brokers, topic, partitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for TripRecord: %w", err)
+	}
+	return &TripRecordMQ{genericService: gs, action: action}, nil
+}
+func (q *TripRecordMQ) GetAction() mq.Action                   { return q.action }
+func (q *TripRecordMQ) Publish(msg mq.TripRecordMessage) error { return q.genericService.Publish(msg) }
+func (q *TripRecordMQ) PublishWithReceipt(msg mq.TripRecordMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
+func (q *TripRecordMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripRecordMessage, error) {
+	return q.genericService.Subscribe(tripId)
+}
+func (q *TripRecordMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripRecordMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+type TripAddressMQ struct {
+	genericService *GenericKafkaService[mq.TripAddressMessage]
+	action         mq.Action
+}
+
+func NewTripAddressMessageQueue(ctx context.Context, brokers []string, action mq.Action, partitionKey PartitionKey) (*TripAddressMQ, error) {
+	topic := fmt.Sprintf("trip-address-%s", action.String())
+	gs, err := NewGenericKafkaService[mq.TripAddressMessage](ctx, brokers, topic, partitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for TripAddress: %w", err)
+	}
+	return &TripAddressMQ{genericService: gs, action: action}, nil
+}
+func (q *TripAddressMQ) GetAction() mq.Action { return q.action }
+func (q *TripAddressMQ) Publish(msg mq.TripAddressMessage) error {
+	return q.genericService.Publish(msg)
+}
+func (q *TripAddressMQ) PublishWithReceipt(msg mq.TripAddressMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
+func (q *TripAddressMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripAddressMessage, error) {
+	return q.genericService.Subscribe(tripId)
+}
+func (q *TripAddressMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripAddressMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+// --------- trip message queue wrapper implementation ---------
+
+type KafkaTripMessageQueueWrapper struct {
+	TripMQArray    [mq.ActionCnt]*TripMQ
+	RecordMQArray  [mq.ActionCnt]*TripRecordMQ
+	AddressMQArray [mq.ActionCnt]*TripAddressMQ
+	// CleanupOnClose deletes the topics on Close, keep it off in production.
+	// It is useful for tests and ephemeral deployments, where topics would accumulate.
+	CleanupOnClose bool
+	closeOnce      sync.Once
+	closeErr       error
+}
+
+// Close shuts down all queues, topics are deleted when CleanupOnClose is set.
+// Calls after the first one are no-op.
+func (wrapper *KafkaTripMessageQueueWrapper) Close() error {
+	wrapper.closeOnce.Do(func() {
+		wrapper.closeErr = wrapper.close()
+	})
+	return wrapper.closeErr
+}
+
+func (wrapper *KafkaTripMessageQueueWrapper) close() error {
+	var errs []error
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (wrapper *KafkaTripMessageQueueWrapper) GetTripMessageQueue(action mq.Action) mq.TripMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.TripMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.TripMQArray[action]
+}
+
+func (wrapper *KafkaTripMessageQueueWrapper) GetTripRecordMessageQueue(action mq.Action) mq.TripRecordMessageQueue {
+	if action < 0 || action >= mq.ActionCnt {
+		return nil
+	}
+	return wrapper.RecordMQArray[action]
+}
+
+func (wrapper *KafkaTripMessageQueueWrapper) GetTripAddressMessageQueue(action mq.Action) mq.TripAddressMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.AddressMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.AddressMQArray[action]
+}
+
+// NewKafkaTripMessageQueueWrapper creates a new MQ wrapper instance using Kafka, messages are partitioned by trip ID.
+func NewKafkaTripMessageQueueWrapper(ctx context.Context, brokers []string) (mq.TripMessageQueueWrapper, error) {
+	return NewKafkaTripMessageQueueWrapperWithConfig(ctx, brokers, Config{})
+}
+
+// NewKafkaTripMessageQueueWrapperWithConfig works like NewKafkaTripMessageQueueWrapper,
+// config.PartitionKey trades the per trip order for parallelism.
+func NewKafkaTripMessageQueueWrapperWithConfig(ctx context.Context, brokers []string, config Config) (mq.TripMessageQueueWrapper, error) {
+	wrapper := &KafkaTripMessageQueueWrapper{}
+	var err error
+	// writers of the queues created so far are released when a later one fails
+	fail := func(err error) (mq.TripMessageQueueWrapper, error) {
+		_ = wrapper.Close()
+		return nil, err
+	}
+
+	// Trip: Delete
+	if wrapper.TripMQArray[mq.ActionDelete], err = NewTripMessageQueue(ctx, brokers, mq.ActionDelete, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+
+	// Address: Create, Delete
+	if wrapper.AddressMQArray[mq.ActionCreate], err = NewTripAddressMessageQueue(ctx, brokers, mq.ActionCreate, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+	if wrapper.AddressMQArray[mq.ActionDelete], err = NewTripAddressMessageQueue(ctx, brokers, mq.ActionDelete, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+
+	// Record: Create, Update, Delete
+	if wrapper.RecordMQArray[mq.ActionCreate], err = NewTripRecordMessageQueue(ctx, brokers, mq.ActionCreate, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+	if wrapper.RecordMQArray[mq.ActionUpdate], err = NewTripRecordMessageQueue(ctx, brokers, mq.ActionUpdate, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+	if wrapper.RecordMQArray[mq.ActionDelete], err = NewTripRecordMessageQueue(ctx, brokers, mq.ActionDelete, config.PartitionKey); err != nil {
+		return fail(err)
+	}
+
+	return wrapper, nil
+}
@@ -0,0 +1,253 @@
+package kafka_test
+
+import (
+	"context"
+	"dtm/mq/kafka"
+	"dtm/mq/mq"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// --- Test Pre-requisite ---
+// This test suite requires a Kafka broker, e.g. `docker run -p 9092:9092 apache/kafka`,
+// and KAFKA_BROKERS pointing to it (localhost:9092). All tests are skipped when it is not set.
+
+// getTestWrapper connects to the brokers and creates a new wrapper for testing, topics are kept between runs.
+func getTestWrapper(t *testing.T) mq.TripMessageQueueWrapper {
+	t.Helper()
+	return getTestWrapperWithConfig(t, kafka.Config{})
+}
+
+// getTestWrapperWithConfig works like getTestWrapper with the given wrapper config.
+func getTestWrapperWithConfig(t *testing.T, config kafka.Config) mq.TripMessageQueueWrapper {
+	t.Helper()
+	if os.Getenv("KAFKA_BROKERS") == "" {
+		t.Skip("Skipping test: KAFKA_BROKERS environment variable not set.")
+	}
+	wrapper, err := kafka.NewKafkaTripMessageQueueWrapperWithConfig(context.Background(), kafka.GetKafkaBrokers(), config)
+	if err != nil {
+		t.Fatalf("Failed to create Kafka wrapper: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := wrapper.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	})
+	return wrapper
+}
+
+// receiveMsgWithTimeout returns the message and true if one is received before the timeout,
+// false on timeout or if the channel is closed.
+func receiveMsgWithTimeout[T any](tb testing.TB, ch <-chan T, timeout time.Duration) (T, bool) {
+	tb.Helper()
+	select {
+	case msg, ok := <-ch:
+		return msg, ok
+	case <-time.After(timeout):
+		var zero T
+		return zero, false
+	}
+}
+
+func TestKafkaTripRecordMQ_PublishSubscribe(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripRecordMessageQueue(mq.ActionCreate)
+	if queue == nil {
+		t.Fatal("record create queue is nil")
+	}
+	tripID, otherTripID := uuid.New(), uuid.New()
+	subID, msgChan, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if subID == uuid.Nil {
+		t.Fatal("expected a subscription ID")
+	}
+
+	// a message of another trip may share the partition, it is filtered out by key
+	if err := queue.Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: otherTripID, Name: "other"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	sent := []mq.TripRecordMessage{
+		{ID: uuid.New(), TripID: tripID, Name: "lunch", Amount: 30, PrePayAddress: "Alice"},
+		{ID: uuid.New(), TripID: tripID, Name: "dinner", Amount: 50, PrePayAddress: "Bob"},
+	}
+	for _, msg := range sent {
+		if err := queue.Publish(msg); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	// messages of a trip keep their order since they share a partition
+	for _, want := range sent {
+		received, ok := receiveMsgWithTimeout(t, msgChan, 10*time.Second)
+		if !ok {
+			t.Fatalf("expected to receive %s", want.Name)
+		}
+		if !reflect.DeepEqual(received, want) {
+			t.Errorf("expected %+v, got %+v", want, received)
+		}
+	}
+	if msg, ok := receiveMsgWithTimeout(t, msgChan, time.Second); ok {
+		t.Errorf("expected no message of the other trip, got %+v", msg)
+	}
+}
+
+func TestKafkaMQ_DeSubscribeClosesChannel(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripMessageQueue(mq.ActionDelete)
+	subID, msgChan, err := queue.Subscribe(uuid.New())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := queue.DeSubscribe(subID); err != nil {
+		t.Fatalf("DeSubscribe failed: %v", err)
+	}
+	select {
+	case _, ok := <-msgChan:
+		if ok {
+			t.Fatal("expected the channel to be closed without messages")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel was not closed after DeSubscribe")
+	}
+}
+
+func TestKafkaMQ_DeSubscribeTrip(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripAddressMessageQueue(mq.ActionCreate)
+	tripID, otherTripID := uuid.New(), uuid.New()
+	_, first, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	_, other, err := queue.Subscribe(otherTripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := queue.DeSubscribeTrip(tripID); err != nil {
+		t.Fatalf("DeSubscribeTrip failed: %v", err)
+	}
+	if _, ok := receiveMsgWithTimeout(t, first, 5*time.Second); ok {
+		t.Error("expected the subscription of the trip to be closed")
+	}
+
+	sent := mq.TripAddressMessage{TripID: otherTripID, Address: "Bob"}
+	if err := queue.Publish(sent); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if received, ok := receiveMsgWithTimeout(t, other, 10*time.Second); !ok || received != sent {
+		t.Errorf("expected the other trip to keep receiving, got %+v %v", received, ok)
+	}
+}
+
+func TestKafkaMQ_UnsupportedActions(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	if wrapper.GetTripMessageQueue(mq.ActionCreate) != nil {
+		t.Error("trip create queue should be nil")
+	}
+	if wrapper.GetTripAddressMessageQueue(mq.ActionUpdate) != nil {
+		t.Error("address update queue should be nil")
+	}
+	if wrapper.GetTripRecordMessageQueue(mq.ActionCnt) != nil {
+		t.Error("out of range action should be nil")
+	}
+}
+
+// partitionOffsets returns the end offset of every partition of the topic.
+func partitionOffsets(t *testing.T, topic string) map[int]int64 {
+	t.Helper()
+	conn, err := kafkago.Dial("tcp", kafka.GetKafkaBrokers()[0])
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		t.Fatalf("ReadPartitions failed: %v", err)
+	}
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range partitions {
+		leader, err := kafkago.DialLeader(context.Background(), "tcp", net.JoinHostPort(p.Leader.Host, strconv.Itoa(p.Leader.Port)), topic, p.ID)
+		if err != nil {
+			t.Fatalf("DialLeader failed: %v", err)
+		}
+		offsets[p.ID], err = leader.ReadLastOffset()
+		_ = leader.Close()
+		if err != nil {
+			t.Fatalf("ReadLastOffset failed: %v", err)
+		}
+	}
+	return offsets
+}
+
+// publishedPartitions publishes count records of one trip and returns how many landed on each partition.
+func publishedPartitions(t *testing.T, queue mq.TripRecordMessageQueue, tripID uuid.UUID, count int) map[int]int64 {
+	t.Helper()
+	before := partitionOffsets(t, "trip-record-update")
+	for i := 0; i < count; i++ {
+		if err := queue.Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: "taxi"}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	grown := make(map[int]int64)
+	for partition, offset := range partitionOffsets(t, "trip-record-update") {
+		if n := offset - before[partition]; n > 0 {
+			grown[partition] = n
+		}
+	}
+	return grown
+}
+
+func TestKafkaPartitionKey_TripID(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripRecordMessageQueue(mq.ActionUpdate)
+
+	grown := publishedPartitions(t, queue, uuid.New(), 6)
+	if len(grown) != 1 {
+		t.Errorf("expected every message of the trip on one partition, got %v", grown)
+	}
+}
+
+func TestKafkaPartitionKey_RoundRobin(t *testing.T) {
+	wrapper := getTestWrapperWithConfig(t, kafka.Config{PartitionKey: kafka.PartitionRoundRobin})
+	queue := wrapper.GetTripRecordMessageQueue(mq.ActionUpdate)
+	tripID := uuid.New()
+	_, msgChan, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	grown := publishedPartitions(t, queue, tripID, 6)
+	if len(grown) < 2 {
+		t.Errorf("expected the messages spread over the partitions, got %v", grown)
+	}
+
+	// the subscription reads every partition, the messages of the trip arrive in any order
+	for i := 0; i < 6; i++ {
+		if _, ok := receiveMsgWithTimeout(t, msgChan, 10*time.Second); !ok {
+			t.Fatalf("expected 6 messages, got %d", i)
+		}
+	}
+}
+
+func TestParsePartitionKey(t *testing.T) {
+	for name, want := range map[string]kafka.PartitionKey{"trip_id": kafka.PartitionByTripID, "round_robin": kafka.PartitionRoundRobin} {
+		got, err := kafka.ParsePartitionKey(name)
+		if err != nil || got != want {
+			t.Errorf("ParsePartitionKey(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := kafka.ParsePartitionKey("random"); err == nil {
+		t.Error("expected an error for an unsupported partition key")
+	}
+}
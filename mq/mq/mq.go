@@ -6,16 +6,26 @@ type TopicProvider interface {
 	GetTopic() uuid.UUID
 }
 
+// ReceiptPublisher is optionally implemented by a message queue, use a type assertion to check for it.
+type ReceiptPublisher[M any] interface {
+	PublishWithReceipt(msg M) (Receipt, error)
+}
+
 type TripMessageQueueWrapper interface {
+	GetTripMessageQueue(action Action) TripMessageQueue
 	GetTripRecordMessageQueue(action Action) TripRecordMessageQueue
 	GetTripAddressMessageQueue(action Action) TripAddressMessageQueue
+	// Close shuts down every queue of the wrapper and is safe to call more than once,
+	// a broker connection handed in by the caller stays open.
+	Close() error
 }
 
 type TripMessageQueue interface {
 	GetAction() Action
-	Publish(msg interface{}) error
-	Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan interface{}, error)
+	Publish(msg TripMessage) error
+	Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan TripMessage, error)
 	DeSubscribe(id uuid.UUID) error
+	DeSubscribeTrip(tripId uuid.UUID) error
 }
 
 type TripRecordMessageQueue interface {
@@ -23,6 +33,7 @@ type TripRecordMessageQueue interface {
 	Publish(msg TripRecordMessage) error
 	Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan TripRecordMessage, error)
 	DeSubscribe(id uuid.UUID) error
+	DeSubscribeTrip(tripId uuid.UUID) error
 }
 
 type TripAddressMessageQueue interface {
@@ -30,4 +41,23 @@ type TripAddressMessageQueue interface {
 	Publish(msg TripAddressMessage) error
 	Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan TripAddressMessage, error)
 	DeSubscribe(id uuid.UUID) error
+	DeSubscribeTrip(tripId uuid.UUID) error
+}
+
+// Metrics counts the messages of a message queue by action, e.g. to export them to a monitoring system.
+// The methods are called from the goroutines of the queue and must be safe for concurrent use.
+type Metrics interface {
+	// IncPublished counts a message accepted by Publish
+	IncPublished(action Action)
+	// IncDelivered counts a message handed to one subscriber
+	IncDelivered(action Action)
+	// IncDropped counts a subscriber which did not keep up, either removed or missing a message
+	IncDropped(action Action)
 }
+
+// NopMetrics is the Metrics of a queue without monitoring, it counts nothing.
+type NopMetrics struct{}
+
+func (NopMetrics) IncPublished(Action) {}
+func (NopMetrics) IncDelivered(Action) {}
+func (NopMetrics) IncDropped(Action)   {}
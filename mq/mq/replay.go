@@ -0,0 +1,232 @@
+package mq
+
+import (
+	"context"
+	"dtm/db/db"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replayer rebuilds a trip in a TripDBWrapper from the record and address events of the message queue,
+// e.g. to restore a lost backend from a broker which keeps the stream. Events are applied one at a time.
+// The action queues are separate streams, so events are sequenced by PublishedAt: an event which is not newer
+// than the last applied event of its record or address is stale and skipped, e.g. a create received after
+// the delete of its record. An event without PublishedAt is applied in the order it is received.
+type Replayer struct {
+	queues TripMessageQueueWrapper
+	tripDB db.TripDBWrapper
+}
+
+func NewReplayer(queues TripMessageQueueWrapper, tripDB db.TripDBWrapper) *Replayer {
+	return &Replayer{queues: queues, tripDB: tripDB}
+}
+
+// Start creates the trip of info in the db when it is missing and subscribes to the record create, update and delete
+// and the address create and delete events of the trip. The events are applied in the background until ctx is done,
+// the returned channel is closed after that. An event which can not be applied is logged and skipped.
+func (r *Replayer) Start(ctx context.Context, info db.TripInfo) (<-chan struct{}, error) {
+	if _, err := r.tripDB.GetTripInfo(ctx, info.ID); errors.Is(err, db.ErrNotFound) {
+		if err := r.tripDB.CreateTrip(ctx, &info); err != nil {
+			return nil, fmt.Errorf("failed to create trip %s to replay into: %w", info.ID, err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	var recordStreams [ActionCnt]<-chan TripRecordMessage
+	var addressStreams [ActionCnt]<-chan TripAddressMessage
+	var unsubscribes []func() error
+	unsubscribeAll := func() {
+		for _, unsubscribe := range unsubscribes {
+			if err := unsubscribe(); err != nil {
+				log.Printf("replay of trip %s: fail to de-subscribe: %v", info.ID, err)
+			}
+		}
+	}
+	for action := ActionCreate; action < ActionCnt; action++ {
+		if queue := r.queues.GetTripRecordMessageQueue(action); queue != nil {
+			id, ch, err := queue.Subscribe(info.ID)
+			if err != nil {
+				unsubscribeAll()
+				return nil, fmt.Errorf("failed to subscribe record %s events: %w", action, err)
+			}
+			recordStreams[action] = ch
+			unsubscribes = append(unsubscribes, func() error { return queue.DeSubscribe(id) })
+		}
+		if queue := r.queues.GetTripAddressMessageQueue(action); queue != nil {
+			id, ch, err := queue.Subscribe(info.ID)
+			if err != nil {
+				unsubscribeAll()
+				return nil, fmt.Errorf("failed to subscribe address %s events: %w", action, err)
+			}
+			addressStreams[action] = ch
+			unsubscribes = append(unsubscribes, func() error { return queue.DeSubscribe(id) })
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer unsubscribeAll()
+		seq := newReplaySequence()
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-recordStreams[ActionCreate]:
+				if !ok {
+					recordStreams[ActionCreate] = nil
+					continue
+				}
+				if seq.record(msg.ID, msg.PublishedAt) {
+					err = r.putRecord(ctx, msg, false)
+				}
+			case msg, ok := <-recordStreams[ActionUpdate]:
+				if !ok {
+					recordStreams[ActionUpdate] = nil
+					continue
+				}
+				if seq.record(msg.ID, msg.PublishedAt) {
+					err = r.putRecord(ctx, msg, true)
+				}
+			case msg, ok := <-recordStreams[ActionDelete]:
+				if !ok {
+					recordStreams[ActionDelete] = nil
+					continue
+				}
+				if !seq.record(msg.ID, msg.PublishedAt) {
+					continue
+				}
+				if _, err = r.tripDB.DeleteTripRecord(ctx, msg.ID); errors.Is(err, db.ErrNotFound) {
+					err = nil // created before the replay started, or its create is not received yet
+				}
+			case msg, ok := <-addressStreams[ActionCreate]:
+				if !ok {
+					addressStreams[ActionCreate] = nil
+					continue
+				}
+				if seq.address(msg.Address, msg.PublishedAt) {
+					err = r.ensureAddresses(ctx, info.ID, msg.Address)
+				}
+			case msg, ok := <-addressStreams[ActionDelete]:
+				if !ok {
+					addressStreams[ActionDelete] = nil
+					continue
+				}
+				if seq.address(msg.Address, msg.PublishedAt) {
+					err = r.tripDB.TripAddressListRemove(ctx, info.ID, msg.Address)
+				}
+			}
+			if err != nil {
+				log.Printf("replay of trip %s: fail to apply event: %v", info.ID, err)
+			}
+		}
+	}()
+	return done, nil
+}
+
+// replaySequence keeps the PublishedAt of the last applied event of every record and address of a replay.
+type replaySequence struct {
+	records   map[uuid.UUID]int64
+	addresses map[db.Address]int64
+}
+
+func newReplaySequence() *replaySequence {
+	return &replaySequence{records: make(map[uuid.UUID]int64), addresses: make(map[db.Address]int64)}
+}
+
+// record reports whether the event of the record published at should be applied and remembers it if so.
+func (s *replaySequence) record(id uuid.UUID, at int64) bool {
+	return advance(s.records, id, at)
+}
+
+// address reports whether the event of the address published at should be applied and remembers it if so.
+func (s *replaySequence) address(address db.Address, at int64) bool {
+	return advance(s.addresses, address, at)
+}
+
+func advance[K comparable](last map[K]int64, key K, at int64) bool {
+	if at == 0 {
+		return true
+	}
+	if prev, ok := last[key]; ok && at <= prev {
+		return false
+	}
+	last[key] = at
+	return true
+}
+
+// putRecord writes the record of msg, an update of a record which is not stored yet creates it.
+// Addresses of the record missing from the trip are added first, the address event may be received later.
+func (r *Replayer) putRecord(ctx context.Context, msg TripRecordMessage, update bool) error {
+	record, err := msg.toRecord()
+	if err != nil {
+		return err
+	}
+	addresses := []db.Address{record.PrePayAddress}
+	for _, extAddr := range record.ShouldPayAddress {
+		addresses = append(addresses, extAddr.Address)
+	}
+	if err := r.ensureAddresses(ctx, msg.TripID, addresses...); err != nil {
+		return err
+	}
+
+	if update {
+		if _, err = r.tripDB.UpdateTripRecords(ctx, []db.Record{record}); !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	}
+	return r.tripDB.CreateTripRecords(ctx, msg.TripID, []db.Record{record})
+}
+
+// ensureAddresses adds the addresses which are not in the address list of the trip yet.
+func (r *Replayer) ensureAddresses(ctx context.Context, tripID uuid.UUID, addresses ...db.Address) error {
+	listed, err := r.tripDB.GetTripAddressList(ctx, tripID)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if address == "" || slices.Contains(listed, address) {
+			continue
+		}
+		if err := r.tripDB.TripAddressListAdd(ctx, tripID, address); err != nil {
+			return err
+		}
+		listed = append(listed, address)
+	}
+	return nil
+}
+
+// toRecord converts the message back to the record it was published for, the inverse of NewTripRecordMessage.
+// Time is in unix milliseconds.
+func (m TripRecordMessage) toRecord() (db.Record, error) {
+	record := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID:             m.ID,
+			Name:           m.Name,
+			Amount:         m.Amount,
+			PrePayAddress:  m.PrePayAddress,
+			Category:       db.RecordCategory(m.Category),
+			GroupID:        m.GroupID,
+			GroupName:      m.GroupName,
+			SplitOverrides: m.SplitOverrides,
+			ExternalID:     m.ExternalID,
+		},
+		RecordData: db.RecordData{ShouldPayAddress: m.ShouldPayAddress},
+	}
+	if m.Time != "" {
+		millis, err := strconv.ParseInt(m.Time, 10, 64)
+		if err != nil {
+			return db.Record{}, fmt.Errorf("invalid time %q of record %s: %w", m.Time, m.ID, err)
+		}
+		record.Time = time.UnixMilli(millis)
+	}
+	return record, nil
+}
@@ -0,0 +1,219 @@
+package mq_test
+
+import (
+	"context"
+	"dtm/db/db"
+	"dtm/db/mem"
+	"dtm/mq/goch"
+	"dtm/mq/mq"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newReplayQueues returns go channel queues which wait for a busy subscriber, the replayer writes to the db
+// between events and the default fan-out would drop its subscription meanwhile.
+func newReplayQueues() mq.TripMessageQueueWrapper {
+	queues := goch.NewGoChanTripMessageQueueWrapper()
+	queues.(*goch.GoChanTripMessageQueueWrapper).SetFanOutConfig(goch.FanOutConfig{DropPolicy: goch.Block})
+	return queues
+}
+
+func TestReplayer(t *testing.T) {
+	queues := newReplayQueues()
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := mq.NewReplayer(queues, tripDB).Start(ctx, db.TripInfo{ID: tripID, Name: "Rebuilt"})
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	at := time.UnixMilli(time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC).UnixMilli())
+	created := mq.TripRecordMessage{
+		ID:               uuid.New(),
+		TripID:           tripID,
+		Name:             "Hotel",
+		Amount:           300,
+		Time:             strconv.FormatInt(at.UnixMilli(), 10),
+		PrePayAddress:    "Alice",
+		ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}},
+	}
+	updated := created
+	updated.Amount = 360
+	updated.ShouldPayAddress = []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}, {Address: "Carol"}}
+
+	if err := queues.GetTripRecordMessageQueue(mq.ActionCreate).Publish(created); err != nil {
+		t.Fatalf("Publish create error: %v", err)
+	}
+	waitFor(t, "created record", func() bool {
+		records, err := tripDB.GetTripRecords(ctx, tripID)
+		return err == nil && len(records) == 1
+	})
+	if err := queues.GetTripRecordMessageQueue(mq.ActionUpdate).Publish(updated); err != nil {
+		t.Fatalf("Publish update error: %v", err)
+	}
+	if err := queues.GetTripAddressMessageQueue(mq.ActionCreate).Publish(mq.TripAddressMessage{TripID: tripID, Address: "Dave"}); err != nil {
+		t.Fatalf("Publish address error: %v", err)
+	}
+	// an event of another trip is not replayed
+	if err := queues.GetTripAddressMessageQueue(mq.ActionCreate).Publish(mq.TripAddressMessage{TripID: uuid.New(), Address: "Zed"}); err != nil {
+		t.Fatalf("Publish address error: %v", err)
+	}
+
+	// the address event and the record update are on separate queues, either may be applied first
+	wantAddresses := []db.Address{"Alice", "Bob", "Carol", "Dave"}
+	waitFor(t, "replayed address list", func() bool {
+		addresses, err := tripDB.GetTripAddressList(ctx, tripID)
+		slices.Sort(addresses)
+		return err == nil && reflect.DeepEqual(addresses, wantAddresses)
+	})
+
+	info, err := tripDB.GetTripInfo(ctx, tripID)
+	if err != nil || info.Name != "Rebuilt" {
+		t.Errorf("GetTripInfo() = %v, %v, want the trip created by Start", info, err)
+	}
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		t.Fatalf("GetTripRecords() error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("GetTripRecords() = %v, want the updated record only", records)
+	}
+	if records[0].ID != created.ID || records[0].Amount != 360 || records[0].PrePayAddress != "Alice" || !records[0].Time.Equal(at) {
+		t.Errorf("replayed record = %+v, want %+v", records[0], updated)
+	}
+	shouldPay, err := tripDB.GetRecordAddressList(ctx, created.ID)
+	if err != nil || !reflect.DeepEqual(shouldPay, updated.ShouldPayAddress) {
+		t.Errorf("GetRecordAddressList() = %v, %v, want %v", shouldPay, err, updated.ShouldPayAddress)
+	}
+
+	t.Run("Delete removes the record", func(t *testing.T) {
+		if err := queues.GetTripRecordMessageQueue(mq.ActionDelete).Publish(mq.TripRecordMessage{ID: created.ID, TripID: tripID}); err != nil {
+			t.Fatalf("Publish delete error: %v", err)
+		}
+		waitFor(t, "deleted record", func() bool {
+			records, err := tripDB.GetTripRecords(ctx, tripID)
+			return err == nil && len(records) == 0
+		})
+	})
+
+	t.Run("Stops when the context is done", func(t *testing.T) {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("replay did not stop after cancel")
+		}
+	})
+}
+
+func TestReplayer_CopiesEveryRecordField(t *testing.T) {
+	queues := newReplayQueues()
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := mq.NewReplayer(queues, tripDB).Start(ctx, db.TripInfo{ID: tripID, Name: "Rebuilt"}); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	want := db.Record{
+		RecordInfo: db.RecordInfo{
+			ID:             uuid.New(),
+			Name:           "Dinner",
+			Amount:         90,
+			Time:           time.UnixMilli(time.Date(2025, 8, 2, 19, 0, 0, 0, time.UTC).UnixMilli()),
+			PrePayAddress:  "Alice",
+			Category:       db.CategoryFix,
+			GroupID:        uuid.New(),
+			GroupName:      "Day 2",
+			SplitOverrides: map[db.Address]float64{"Alice": 1, "Bob": 2},
+			ExternalID:     "receipt-42",
+		},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}},
+	}
+	if err := queues.GetTripRecordMessageQueue(mq.ActionCreate).Publish(mq.NewTripRecordMessage(tripID, want)); err != nil {
+		t.Fatalf("Publish create error: %v", err)
+	}
+	waitFor(t, "created record", func() bool {
+		records, err := tripDB.GetTripRecords(ctx, tripID)
+		return err == nil && len(records) == 1
+	})
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		t.Fatalf("GetTripRecords() error: %v", err)
+	}
+	got := records[0]
+	if got.ID != want.ID || got.Name != want.Name || got.Amount != want.Amount || !got.Time.Equal(want.Time) ||
+		got.PrePayAddress != want.PrePayAddress || got.Category != want.Category || got.GroupID != want.GroupID ||
+		got.GroupName != want.GroupName || !reflect.DeepEqual(got.SplitOverrides, want.SplitOverrides) ||
+		got.ExternalID != want.ExternalID {
+		t.Errorf("replayed record = %+v, want %+v", got, want.RecordInfo)
+	}
+}
+
+func TestReplayer_InterleavedCreateDelete(t *testing.T) {
+	queues := newReplayQueues()
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := mq.NewReplayer(queues, tripDB).Start(ctx, db.TripInfo{ID: tripID, Name: "Rebuilt"}); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	record := db.Record{
+		RecordInfo: db.RecordInfo{ID: uuid.New(), Name: "Taxi", Amount: 30, PrePayAddress: "Alice"},
+		RecordData: db.RecordData{ShouldPayAddress: []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}}},
+	}
+	created := mq.NewTripRecordMessage(tripID, record)
+	deleted := mq.TripRecordMessage{ID: record.ID, TripID: tripID, PublishedAt: created.PublishedAt + 1}
+	sentinel := record
+	sentinel.ID = uuid.New()
+	sentinelCreated := mq.NewTripRecordMessage(tripID, sentinel)
+	sentinelCreated.PublishedAt = deleted.PublishedAt + 1
+
+	// the delete queue delivers before the create queue, the create of the deleted record is stale
+	if err := queues.GetTripRecordMessageQueue(mq.ActionDelete).Publish(deleted); err != nil {
+		t.Fatalf("Publish delete error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, msg := range []mq.TripRecordMessage{created, sentinelCreated} {
+		if err := queues.GetTripRecordMessageQueue(mq.ActionCreate).Publish(msg); err != nil {
+			t.Fatalf("Publish create error: %v", err)
+		}
+	}
+
+	// the sentinel is applied after the stale create of the same queue
+	waitFor(t, "sentinel record", func() bool {
+		records, err := tripDB.GetTripRecords(ctx, tripID)
+		return err == nil && slices.ContainsFunc(records, func(r db.RecordInfo) bool { return r.ID == sentinel.ID })
+	})
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		t.Fatalf("GetTripRecords() error: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != sentinel.ID {
+		t.Errorf("GetTripRecords() = %v, want the sentinel only, the deleted record must not be restored", records)
+	}
+}
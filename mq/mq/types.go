@@ -2,6 +2,8 @@ package mq
 
 import (
 	"dtm/db/db"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -12,6 +14,14 @@ const (
 	ModeGoChan    Mode = "go_chan"
 	ModeRabbitMQ  Mode = "rabbitmq"
 	ModeGCPPubSub Mode = "gcp_pub_sub"
+	ModeNATS      Mode = "nats"
+	ModeKafka     Mode = "kafka"
+)
+
+type wrapperKey string
+
+const (
+	WrapperKeyTripMessageQueue wrapperKey = "trip_message_queue_wrapper"
 )
 
 type Action int
@@ -37,6 +47,16 @@ func (a Action) String() string {
 	}
 }
 
+type TripMessage struct {
+	ID        uuid.UUID
+	Name      string
+	MessageID string `json:",omitempty"` // set by PublishWithReceipt, equals Receipt.ID
+}
+
+func (m TripMessage) GetTopic() uuid.UUID {
+	return m.ID
+}
+
 type TripRecordMessage struct {
 	ID            uuid.UUID
 	TripID        uuid.UUID
@@ -45,6 +65,35 @@ type TripRecordMessage struct {
 	Time          string // ISO format
 	PrePayAddress db.Address
 	Category      int
+	// ShouldPayAddress is the split of a created or updated record, kept so a Replayer can rebuild the record
+	ShouldPayAddress []db.ExtendAddress     `json:",omitempty"`
+	GroupID          uuid.UUID              `json:",omitempty"`
+	GroupName        string                 `json:",omitempty"`
+	SplitOverrides   map[db.Address]float64 `json:",omitempty"`
+	ExternalID       string                 `json:",omitempty"`
+	// PublishedAt is the unix nanoseconds when the change was made, it orders the events of the separate action queues
+	PublishedAt int64  `json:",omitempty"`
+	MessageID   string `json:",omitempty"` // set by PublishWithReceipt, equals Receipt.ID
+}
+
+// NewTripRecordMessage returns the message of a created or updated record of a trip, it carries every field
+// of the record and is stamped with the current time.
+func NewTripRecordMessage(tripID uuid.UUID, record db.Record) TripRecordMessage {
+	return TripRecordMessage{
+		ID:               record.ID,
+		TripID:           tripID,
+		Name:             record.Name,
+		Amount:           record.Amount,
+		Time:             strconv.FormatInt(record.Time.UnixMilli(), 10),
+		PrePayAddress:    record.PrePayAddress,
+		Category:         int(record.Category),
+		ShouldPayAddress: record.ShouldPayAddress,
+		GroupID:          record.GroupID,
+		GroupName:        record.GroupName,
+		SplitOverrides:   record.SplitOverrides,
+		ExternalID:       record.ExternalID,
+		PublishedAt:      time.Now().UnixNano(),
+	}
 }
 
 func (m TripRecordMessage) GetTopic() uuid.UUID {
@@ -52,10 +101,21 @@ func (m TripRecordMessage) GetTopic() uuid.UUID {
 }
 
 type TripAddressMessage struct {
-	TripID  uuid.UUID
-	Address db.Address
+	TripID      uuid.UUID
+	Address     db.Address
+	PublishedAt int64  `json:",omitempty"` // unix nanoseconds when the change was made
+	MessageID   string `json:",omitempty"` // set by PublishWithReceipt, equals Receipt.ID
 }
 
 func (m TripAddressMessage) GetTopic() uuid.UUID {
 	return m.TripID
 }
+
+// Receipt identifies a published message. ID is carried by the delivered message as MessageID,
+// so a subscriber can correlate it with the publish.
+type Receipt struct {
+	ID string
+	// BrokerRef is the acknowledgement of the broker, the server-assigned message ID of GCP Pub/Sub
+	// or the confirmed delivery tag of RabbitMQ, empty for go channel.
+	BrokerRef string
+}
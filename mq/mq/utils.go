@@ -72,3 +72,27 @@ func SubscribeProcessor[S Subscriber[M], M any, O any](
 		}
 	}()
 }
+
+// MergeStreams forwards every value of the input streams to outputStream, which is closed after all inputs
+// are closed, e.g. streams of SubscribeProcessor which close on ctx cancel. Values received after ctx is
+// done are dropped, so the inputs are still drained until they close.
+func MergeStreams[O any](ctx context.Context, outputStream chan<- O, inputStreams ...<-chan O) {
+	done := make(chan struct{}, len(inputStreams))
+	for _, inputStream := range inputStreams {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for value := range inputStream {
+				select {
+				case outputStream <- value:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		for range inputStreams {
+			<-done
+		}
+		close(outputStream)
+	}()
+}
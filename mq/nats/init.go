@@ -0,0 +1,25 @@
+package nats
+
+import (
+	"fmt"
+	"os"
+
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// DialNatsConnection connects to the NATS server, the error is returned so the caller can retry.
+func DialNatsConnection(url string) (*natsgo.Conn, error) {
+	nc, err := natsgo.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return nc, nil
+}
+
+func CreateNatsURL() string {
+	natsURL := natsgo.DefaultURL
+	if url := os.Getenv("NATS_URL"); url != "" {
+		natsURL = url
+	}
+	return natsURL
+}
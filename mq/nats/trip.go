@@ -0,0 +1,310 @@
+package nats
+
+import (
+	"dtm/mq/mq"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// subscriptionInfo holds details about an active NATS subscription.
+type subscriptionInfo struct {
+	tripID uuid.UUID
+	cancel chan struct{}
+}
+
+// GenericNatsService provides a generic implementation for NATS operations,
+// messages of a trip are published to "<subject>.<tripID>".
+type GenericNatsService[M any] struct {
+	conn                *natsgo.Conn
+	subject             string
+	activeSubscriptions map[uuid.UUID]*subscriptionInfo
+	subscriptionsMutex  sync.Mutex
+	closeOnce           sync.Once // Close can be called from both defer and shutdown handler
+}
+
+func NewGenericNatsService[M any](conn *natsgo.Conn, subject string) (*GenericNatsService[M], error) {
+	if conn == nil {
+		return nil, fmt.Errorf("NATS connection is nil")
+	}
+	return &GenericNatsService[M]{
+		conn: conn, subject: subject, activeSubscriptions: make(map[uuid.UUID]*subscriptionInfo),
+	}, nil
+}
+
+// tripSubject returns the subject of the trip, the trip ID is the last token so a subscription only gets its trip.
+func (s *GenericNatsService[M]) tripSubject(tripId uuid.UUID) string {
+	return s.subject + "." + tripId.String()
+}
+
+func (s *GenericNatsService[M]) Publish(msg mq.TopicProvider) error {
+	typeName := reflect.TypeOf(msg).Name()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", typeName, err)
+	}
+	if err := s.conn.Publish(s.tripSubject(msg.GetTopic()), body); err != nil {
+		return fmt.Errorf("failed to publish %s to %s: %w", typeName, s.subject, err)
+	}
+	return nil
+}
+
+func (s *GenericNatsService[M]) Subscribe(tripId uuid.UUID, unmarshalFn func(data []byte) (M, error)) (uuid.UUID, <-chan M, error) {
+	subscriptionID := uuid.New()
+	typeName := reflect.TypeOf(*new(M)).Name()
+	deliveries := make(chan *natsgo.Msg, 64)
+	sub, err := s.conn.ChanSubscribe(s.tripSubject(tripId), deliveries)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to subscribe %s for trip %s: %w", typeName, tripId, err)
+	}
+	// the subscription is registered on the server once the flush returns, messages published after Subscribe are not missed
+	if err := s.conn.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		return uuid.Nil, nil, fmt.Errorf("failed to register subscription of %s for trip %s: %w", typeName, tripId, err)
+	}
+	msgChan := make(chan M, 5)
+	stopChan := make(chan struct{})
+	s.subscriptionsMutex.Lock()
+	s.activeSubscriptions[subscriptionID] = &subscriptionInfo{tripID: tripId, cancel: stopChan}
+	s.subscriptionsMutex.Unlock()
+	go func() {
+		defer func() {
+			s.subscriptionsMutex.Lock()
+			delete(s.activeSubscriptions, subscriptionID)
+			s.subscriptionsMutex.Unlock()
+			if err := sub.Unsubscribe(); err != nil && s.conn.IsConnected() {
+				log.Printf("Error unsubscribing %s subscription %s: %v", typeName, subscriptionID, err)
+			}
+			close(msgChan)
+		}()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case delivery := <-deliveries:
+				msg, err := unmarshalFn(delivery.Data)
+				if err != nil {
+					log.Printf("Error unmarshaling %s for %s: %v. Body: %s", typeName, subscriptionID, err, string(delivery.Data))
+					continue
+				}
+				select {
+				case msgChan <- msg:
+				case <-stopChan:
+					return
+				case <-time.After(2 * time.Second):
+					log.Printf("Timeout sending %s to msgChan for %s.", typeName, subscriptionID)
+				}
+			}
+		}
+	}()
+	return subscriptionID, msgChan, nil
+}
+
+func (s *GenericNatsService[M]) DeSubscribe(id uuid.UUID) error {
+	s.subscriptionsMutex.Lock()
+	info, ok := s.activeSubscriptions[id]
+	if ok {
+		delete(s.activeSubscriptions, id)
+	}
+	s.subscriptionsMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("subscription ID %s not found for %s service", id, reflect.TypeOf(*new(M)).Name())
+	}
+	close(info.cancel)
+	return nil
+}
+
+// DeSubscribeTrip stops every subscription to the trip.
+func (s *GenericNatsService[M]) DeSubscribeTrip(tripId uuid.UUID) error {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+	for id, info := range s.activeSubscriptions {
+		if info.tripID == tripId {
+			delete(s.activeSubscriptions, id)
+			close(info.cancel)
+		}
+	}
+	return nil
+}
+
+// Close stops all subscriptions, calls after the first one are no-op.
+func (s *GenericNatsService[M]) Close() {
+	s.closeOnce.Do(func() {
+		s.subscriptionsMutex.Lock()
+		defer s.subscriptionsMutex.Unlock()
+		for id, info := range s.activeSubscriptions {
+			delete(s.activeSubscriptions, id)
+			close(info.cancel)
+		}
+	})
+}
+
+type TripMQ struct {
+	genericService   *GenericNatsService[mq.TripMessage]
+	configuredAction mq.Action
+}
+
+func NewTripMessageQueue(conn *natsgo.Conn, action mq.Action) (*TripMQ, error) {
+	gs, err := NewGenericNatsService[mq.TripMessage](conn, fmt.Sprintf("trip.%s", action))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for Trip: %w", err)
+	}
+	return &TripMQ{genericService: gs, configuredAction: action}, nil
+}
+func (q *TripMQ) GetAction() mq.Action             { return q.configuredAction }
+func (q *TripMQ) Publish(msg mq.TripMessage) error { return q.genericService.Publish(msg) }
+func unmarshalTripMessage(data []byte) (mq.TripMessage, error) {
+	var msg mq.TripMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+func (q *TripMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripMessage, error) {
+	return q.genericService.Subscribe(tripId, unmarshalTripMessage)
+}
+func (q *TripMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+type TripRecordMQ struct {
+	genericService   *GenericNatsService[mq.TripRecordMessage]
+	configuredAction mq.Action
+}
+
+func NewTripRecordMessageQueue(conn *natsgo.Conn, action mq.Action) (*TripRecordMQ, error) {
+	gs, err := NewGenericNatsService[mq.TripRecordMessage](conn, fmt.Sprintf("trip.record.%s", action))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for TripRecord: %w", err)
+	}
+	return &TripRecordMQ{genericService: gs, configuredAction: action}, nil
+}
+func (q *TripRecordMQ) GetAction() mq.Action                   { return q.configuredAction }
+func (q *TripRecordMQ) Publish(msg mq.TripRecordMessage) error { return q.genericService.Publish(msg) }
+func unmarshalTripRecordMessage(data []byte) (mq.TripRecordMessage, error) {
+	var msg mq.TripRecordMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+func (q *TripRecordMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripRecordMessage, error) {
+	return q.genericService.Subscribe(tripId, unmarshalTripRecordMessage)
+}
+func (q *TripRecordMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripRecordMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+type TripAddressMQ struct {
+	genericService   *GenericNatsService[mq.TripAddressMessage]
+	configuredAction mq.Action
+}
+
+func NewTripAddressMessageQueue(conn *natsgo.Conn, action mq.Action) (*TripAddressMQ, error) {
+	gs, err := NewGenericNatsService[mq.TripAddressMessage](conn, fmt.Sprintf("trip.address.%s", action))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for TripAddress: %w", err)
+	}
+	return &TripAddressMQ{genericService: gs, configuredAction: action}, nil
+}
+func (q *TripAddressMQ) GetAction() mq.Action { return q.configuredAction }
+func (q *TripAddressMQ) Publish(msg mq.TripAddressMessage) error {
+	return q.genericService.Publish(msg)
+}
+func unmarshalTripAddressMessage(data []byte) (mq.TripAddressMessage, error) {
+	var msg mq.TripAddressMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+func (q *TripAddressMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripAddressMessage, error) {
+	return q.genericService.Subscribe(tripId, unmarshalTripAddressMessage)
+}
+func (q *TripAddressMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripAddressMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
+// --------- trip message queue wrapper implementation ---------
+
+type TripMessageQueueWrapper struct {
+	TripMQArray    [mq.ActionCnt]*TripMQ
+	RecordMQArray  [mq.ActionCnt]*TripRecordMQ
+	AddressMQArray [mq.ActionCnt]*TripAddressMQ
+	closeOnce      sync.Once
+}
+
+// Close stops the subscriptions of all queues. The connection is owned by the caller and is not closed here,
+// calls after the first one are no-op.
+func (wrapper *TripMessageQueueWrapper) Close() error {
+	wrapper.closeOnce.Do(func() {
+		for _, q := range wrapper.TripMQArray {
+			if q != nil {
+				q.genericService.Close()
+			}
+		}
+		for _, q := range wrapper.RecordMQArray {
+			if q != nil {
+				q.genericService.Close()
+			}
+		}
+		for _, q := range wrapper.AddressMQArray {
+			if q != nil {
+				q.genericService.Close()
+			}
+		}
+	})
+	return nil
+}
+
+func (wrapper *TripMessageQueueWrapper) GetTripMessageQueue(action mq.Action) mq.TripMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.TripMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.TripMQArray[action]
+}
+
+func (wrapper *TripMessageQueueWrapper) GetTripRecordMessageQueue(action mq.Action) mq.TripRecordMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.RecordMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.RecordMQArray[action]
+}
+
+func (wrapper *TripMessageQueueWrapper) GetTripAddressMessageQueue(action mq.Action) mq.TripAddressMessageQueue {
+	if action < 0 || action >= mq.ActionCnt || wrapper.AddressMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.AddressMQArray[action]
+}
+
+// NewNatsTripMessageQueueWrapper creates a new MQ wrapper instance using NATS,
+// each action publishes to a subject like "trip.record.create.<tripID>".
+func NewNatsTripMessageQueueWrapper(nc *natsgo.Conn) (mq.TripMessageQueueWrapper, error) {
+	wrapper := &TripMessageQueueWrapper{}
+	var err error
+	// trip only need remove
+	wrapper.TripMQArray[mq.ActionDelete], err = NewTripMessageQueue(nc, mq.ActionDelete)
+	if err != nil {
+		return nil, fmt.Errorf("error creating TripMessageQueue for ActionDelete: %w", err)
+	}
+	// address need add and remove
+	for _, action := range []mq.Action{mq.ActionCreate, mq.ActionDelete} {
+		wrapper.AddressMQArray[action], err = NewTripAddressMessageQueue(nc, action)
+		if err != nil {
+			return nil, fmt.Errorf("error creating TripAddressMessageQueue for %s: %w", action, err)
+		}
+	}
+	// record need add, update and delete
+	for _, action := range []mq.Action{mq.ActionCreate, mq.ActionUpdate, mq.ActionDelete} {
+		wrapper.RecordMQArray[action], err = NewTripRecordMessageQueue(nc, action)
+		if err != nil {
+			return nil, fmt.Errorf("error creating TripRecordMessageQueue for %s: %w", action, err)
+		}
+	}
+
+	return wrapper, nil
+}
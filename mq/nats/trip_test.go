@@ -0,0 +1,159 @@
+package nats_test
+
+import (
+	"dtm/mq/mq"
+	natsMQ "dtm/mq/nats"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- Test Pre-requisite ---
+// This test suite requires a NATS server, e.g. `docker run -p 4222:4222 nats`,
+// and NATS_URL pointing to it (nats://localhost:4222). All tests are skipped when it is not set.
+
+// getTestWrapper connects to the NATS server and creates a new wrapper for testing.
+func getTestWrapper(t *testing.T) mq.TripMessageQueueWrapper {
+	t.Helper()
+	if os.Getenv("NATS_URL") == "" {
+		t.Skip("Skipping test: NATS_URL environment variable not set.")
+	}
+	nc, err := natsMQ.DialNatsConnection(natsMQ.CreateNatsURL())
+	if err != nil {
+		t.Fatalf("PRE-REQUISITE FAILED: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	wrapper, err := natsMQ.NewNatsTripMessageQueueWrapper(nc)
+	if err != nil {
+		t.Fatalf("Failed to create NATS wrapper: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = wrapper.(*natsMQ.TripMessageQueueWrapper).Close()
+	})
+	return wrapper
+}
+
+// receiveMsgWithTimeout returns the message and true if one is received before the timeout,
+// false on timeout or if the channel is closed.
+func receiveMsgWithTimeout[T any](tb testing.TB, ch <-chan T, timeout time.Duration) (T, bool) {
+	tb.Helper()
+	select {
+	case msg, ok := <-ch:
+		return msg, ok
+	case <-time.After(timeout):
+		var zero T
+		return zero, false
+	}
+}
+
+func TestNatsTripRecordMQ_PublishSubscribe(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripRecordMessageQueue(mq.ActionCreate)
+	if queue == nil {
+		t.Fatal("record create queue is nil")
+	}
+	tripID, otherTripID := uuid.New(), uuid.New()
+	subID, msgChan, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if subID == uuid.Nil {
+		t.Fatal("expected a subscription ID")
+	}
+
+	// a message of another trip is filtered out by the subject
+	if err := queue.Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: otherTripID, Name: "other"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	sent := mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: "lunch", Amount: 30, PrePayAddress: "Alice"}
+	if err := queue.Publish(sent); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	received, ok := receiveMsgWithTimeout(t, msgChan, 2*time.Second)
+	if !ok {
+		t.Fatal("expected to receive the record message")
+	}
+	if !reflect.DeepEqual(received, sent) {
+		t.Errorf("expected %+v, got %+v", sent, received)
+	}
+	if msg, ok := receiveMsgWithTimeout(t, msgChan, 200*time.Millisecond); ok {
+		t.Errorf("expected no message of the other trip, got %+v", msg)
+	}
+}
+
+func TestNatsMQ_DeSubscribeClosesChannel(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripMessageQueue(mq.ActionDelete)
+	tripID := uuid.New()
+	subID, msgChan, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := queue.DeSubscribe(subID); err != nil {
+		t.Fatalf("DeSubscribe failed: %v", err)
+	}
+	select {
+	case _, ok := <-msgChan:
+		if ok {
+			t.Fatal("expected the channel to be closed without messages")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel was not closed after DeSubscribe")
+	}
+	if err := queue.DeSubscribe(subID); err == nil {
+		t.Error("expected an error when DeSubscribe twice")
+	}
+}
+
+func TestNatsMQ_DeSubscribeTrip(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	queue := wrapper.GetTripAddressMessageQueue(mq.ActionCreate)
+	tripID, otherTripID := uuid.New(), uuid.New()
+	_, first, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	_, second, err := queue.Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	_, other, err := queue.Subscribe(otherTripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := queue.DeSubscribeTrip(tripID); err != nil {
+		t.Fatalf("DeSubscribeTrip failed: %v", err)
+	}
+	for _, ch := range []<-chan mq.TripAddressMessage{first, second} {
+		if _, ok := receiveMsgWithTimeout(t, ch, 2*time.Second); ok {
+			t.Error("expected the subscription of the trip to be closed")
+		}
+	}
+
+	sent := mq.TripAddressMessage{TripID: otherTripID, Address: "Bob"}
+	if err := queue.Publish(sent); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if received, ok := receiveMsgWithTimeout(t, other, 2*time.Second); !ok || received != sent {
+		t.Errorf("expected the other trip to keep receiving, got %+v %v", received, ok)
+	}
+}
+
+func TestNatsMQ_UnsupportedActions(t *testing.T) {
+	wrapper := getTestWrapper(t)
+	if wrapper.GetTripMessageQueue(mq.ActionCreate) != nil {
+		t.Error("trip create queue should be nil")
+	}
+	if wrapper.GetTripAddressMessageQueue(mq.ActionUpdate) != nil {
+		t.Error("address update queue should be nil")
+	}
+	if wrapper.GetTripRecordMessageQueue(mq.ActionCnt) != nil {
+		t.Error("out of range action should be nil")
+	}
+}
@@ -0,0 +1,190 @@
+package rabbit
+
+import (
+	"context"
+	"dtm/mq/mq"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel records the exchanges and messages of a publish channel without a broker.
+type fakeChannel struct {
+	mu        sync.Mutex
+	closed    bool
+	exchanges []string
+	published []amqp.Publishing
+	targets   []string // exchange of each published message
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeChannel) ExchangeDeclare(name, _ string, _, _, _, _ bool, _ amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges = append(c.exchanges, name)
+	return nil
+}
+
+func (c *fakeChannel) Confirm(bool) error { return nil }
+
+func (c *fakeChannel) PublishWithContext(_ context.Context, exchange, _ string, _, _ bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.published = append(c.published, msg)
+	c.targets = append(c.targets, exchange)
+	return nil
+}
+
+func (c *fakeChannel) PublishWithDeferredConfirmWithContext(context.Context, string, string, bool, bool, amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	return nil, errors.New("confirms are not supported by fakeChannel")
+}
+
+func (c *fakeChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func newFakeService(first *fakeChannel, open func() (publishChannel, error)) *GenericRabbitMQService[mq.TripMessage] {
+	return &GenericRabbitMQService[mq.TripMessage]{
+		publishChannel:  first,
+		openChannel:     open,
+		exchangeName:    "fake_exchange",
+		activeConsumers: make(map[uuid.UUID]*consumerInfo),
+	}
+}
+
+func TestPublish_ReopensClosedChannel(t *testing.T) {
+	first, second := &fakeChannel{}, &fakeChannel{}
+	opens := 0
+	s := newFakeService(first, func() (publishChannel, error) {
+		opens++
+		return second, nil
+	})
+
+	if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err != nil {
+		t.Fatalf("first publish failed: %v", err)
+	}
+	// broker restart closes the channel
+	_ = first.Close()
+	if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err != nil {
+		t.Fatalf("publish after reopen failed: %v", err)
+	}
+
+	if opens != 1 {
+		t.Errorf("expected 1 reopen, got %d", opens)
+	}
+	if len(first.published) != 1 || len(second.published) != 1 {
+		t.Errorf("expected one message on each channel, got %d and %d", len(first.published), len(second.published))
+	}
+	if len(second.exchanges) != 1 || second.exchanges[0] != "fake_exchange" {
+		t.Errorf("expected exchange to be declared again on the new channel, got %v", second.exchanges)
+	}
+}
+
+func TestPublish_ReopenGivesUp(t *testing.T) {
+	closed := &fakeChannel{closed: true}
+	opens := 0
+	s := newFakeService(closed, func() (publishChannel, error) {
+		opens++
+		return nil, amqp.ErrClosed
+	})
+
+	err := s.Publish(mq.TripMessage{ID: uuid.New()})
+	if !errors.Is(err, amqp.ErrClosed) {
+		t.Fatalf("expected the reopen error, got %v", err)
+	}
+	if opens != publishReopenAttempts {
+		t.Errorf("expected %d reopen attempts, got %d", publishReopenAttempts, opens)
+	}
+}
+
+func TestPublish_NoReopenAfterClose(t *testing.T) {
+	opens := 0
+	s := newFakeService(&fakeChannel{}, func() (publishChannel, error) {
+		opens++
+		return &fakeChannel{}, nil
+	})
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err == nil {
+		t.Fatal("expected publish after close to fail")
+	}
+	if opens != 0 {
+		t.Errorf("expected no reopen after close, got %d", opens)
+	}
+}
+
+// publishCounter counts IncPublished by action and ignores the other counts.
+type publishCounter struct {
+	mq.NopMetrics
+	mu        sync.Mutex
+	published map[mq.Action]int
+}
+
+func (c *publishCounter) IncPublished(action mq.Action) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published[action]++
+}
+
+func TestPublish_Metrics(t *testing.T) {
+	counter := &publishCounter{published: make(map[mq.Action]int)}
+	channel := &fakeChannel{}
+	s := newFakeService(channel, func() (publishChannel, error) { return nil, amqp.ErrClosed })
+	s.action, s.metrics = mq.ActionDelete, counter
+
+	for i := 0; i < 2; i++ {
+		if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err != nil {
+			t.Fatalf("publish failed: %v", err)
+		}
+	}
+	// a failing publish is not counted
+	_ = channel.Close()
+	if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err == nil {
+		t.Fatal("expected publish on the closed channel to fail")
+	}
+	if got := counter.published[mq.ActionDelete]; got != 2 {
+		t.Errorf("expected 2 published messages, got %d", got)
+	}
+}
+
+func TestPublishDeadLetter(t *testing.T) {
+	first, second := &fakeChannel{}, &fakeChannel{}
+	s := newFakeService(first, func() (publishChannel, error) { return second, nil })
+	s.deadLetter = true
+
+	delivery := amqp.Delivery{RoutingKey: uuid.NewString(), ContentType: "application/json", Body: []byte("{not json")}
+	if err := s.publishDeadLetter(delivery, errors.New("unexpected end of JSON input")); err != nil {
+		t.Fatalf("publishDeadLetter failed: %v", err)
+	}
+	if len(first.targets) != 1 || first.targets[0] != "fake_exchange.dlx" {
+		t.Fatalf("expected the message on the dead-letter exchange, got %v", first.targets)
+	}
+	if dead := first.published[0]; string(dead.Body) != "{not json" || dead.Headers["x-error"] != "unexpected end of JSON input" {
+		t.Errorf("expected the raw body and the error header, got %q %v", dead.Body, dead.Headers)
+	}
+
+	// the dead-letter exchange is declared again on a reopened channel
+	_ = first.Close()
+	if err := s.Publish(mq.TripMessage{ID: uuid.New()}); err != nil {
+		t.Fatalf("publish after reopen failed: %v", err)
+	}
+	if len(second.exchanges) != 2 || second.exchanges[1] != "fake_exchange.dlx" {
+		t.Errorf("expected both exchanges to be declared on the new channel, got %v", second.exchanges)
+	}
+}
@@ -0,0 +1,88 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"dtm/mq/mq"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// inFlight returns how many deliveries of the subscription are neither waiting in its queue nor handed to msgChan.
+func inFlight[M any](t *testing.T, s *GenericRabbitMQService[M], subID uuid.UUID, published int, msgChan <-chan M) int {
+	t.Helper()
+	s.consumersMutex.Lock()
+	info := s.activeConsumers[subID]
+	s.consumersMutex.Unlock()
+	if info == nil {
+		t.Fatalf("subscription %s is not active", subID)
+	}
+	// the queue is exclusive to the connection, a passive declare on another channel of it reads the counts
+	ch, err := s.conn.Channel()
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	defer func() { _ = ch.Close() }()
+	queue, err := ch.QueueDeclarePassive(info.queue, true, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("Failed to inspect queue %s: %v", info.queue, err)
+	}
+	return published - queue.Messages - len(msgChan)
+}
+
+func TestSubscribePrefetch(t *testing.T) {
+	url := CreateAmqpURL()
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatalf("PRE-REQUISITE FAILED: Could not connect to RabbitMQ at %s for testing. Error: %v", url, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	const published = 30
+	for _, prefetch := range []int{1, 10} {
+		t.Run(fmt.Sprintf("prefetch %d", prefetch), func(t *testing.T) {
+			exchange := fmt.Sprintf("prefetch_test_exchange_%d", prefetch)
+			s, err := NewGenericRabbitMQService[mq.TripMessage](conn, exchange, WithPrefetch(prefetch))
+			if err != nil {
+				t.Fatalf("Failed to create generic service: %v", err)
+			}
+			defer func() {
+				_ = s.Close()
+				if ch, err := conn.Channel(); err == nil {
+					_ = ch.ExchangeDelete(exchange, false, false)
+					_ = ch.Close()
+				}
+			}()
+
+			tripID := uuid.New()
+			subID, msgChan, err := s.Subscribe(tripID, func(data []byte) (mq.TripMessage, error) {
+				var msg mq.TripMessage
+				err := json.Unmarshal(data, &msg)
+				return msg, err
+			})
+			if err != nil {
+				t.Fatalf("Subscribe failed: %v", err)
+			}
+			for i := 0; i < published; i++ {
+				if err := s.Publish(mq.TripMessage{ID: tripID}); err != nil {
+					t.Fatalf("Publish failed: %v", err)
+				}
+			}
+
+			// msgChan is not read, once it is full the consumer holds as many deliveries as the prefetch allows
+			deadline := time.Now().Add(3 * time.Second)
+			got := inFlight(t, s, subID, published, msgChan)
+			for got != prefetch && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+				got = inFlight(t, s, subID, published, msgChan)
+			}
+			if got != prefetch {
+				t.Errorf("Expected %d deliveries in flight, got %d", prefetch, got)
+			}
+		})
+	}
+}
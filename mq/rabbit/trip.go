@@ -4,9 +4,11 @@ import (
 	"context"
 	"dtm/mq/mq"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,44 +22,211 @@ type UnmarshalFunc[M any] func(data []byte) (M, error)
 // consumerInfo holds details about an active consumer.
 type consumerInfo struct {
 	tag     string
+	queue   string // server-named queue of the subscription
+	tripID  uuid.UUID
 	channel *amqp.Channel
 	cancel  chan struct{}
 }
 
+// publishChannel is the part of *amqp.Channel used for publishing, replaced by a fake in tests.
+type publishChannel interface {
+	IsClosed() bool
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	Confirm(noWait bool) error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error)
+	Close() error
+}
+
+// reopening a closed publish channel is tried publishReopenAttempts times,
+// the wait between attempts starts at publishReopenBackoff and doubles.
+const (
+	publishReopenAttempts = 3
+	publishReopenBackoff  = 50 * time.Millisecond
+)
+
 // GenericRabbitMQService provides a generic implementation for message queue operations.
 type GenericRabbitMQService[M any] struct {
 	conn            *amqp.Connection
-	publishChannel  *amqp.Channel
+	publishChannel  publishChannel
+	openChannel     func() (publishChannel, error) // opens a new publish channel from conn
 	publishMutex    sync.Mutex
 	exchangeName    string
 	activeConsumers map[uuid.UUID]*consumerInfo
 	consumersMutex  sync.Mutex
+	closeOnce       sync.Once // Close can be called from both defer and shutdown handler
+	closeErr        error
+	confirmMode     bool // publish channel is put into confirm mode by the first PublishWithReceipt
+	closed          bool // set by Close, the publish channel is not reopened after it
+	deadLetter      bool // messages which fail to unmarshal are republished to DeadLetterExchange
+	prefetch        int  // unacknowledged deliveries a subscription may hold, see WithPrefetch
+	action          mq.Action
+	metrics         mq.Metrics // message counts of action, see WithMetrics
+}
+
+// ServiceOption configures a GenericRabbitMQService.
+type ServiceOption func(*serviceOptions)
+
+type serviceOptions struct {
+	deadLetter bool
+	prefetch   int
+	action     mq.Action
+	metrics    mq.Metrics
+}
+
+// defaultPrefetch keeps one message in flight per subscription, safe for slow consumers.
+const defaultPrefetch = 1
+
+// WithDeadLetter republishes the raw body of a message which fails to unmarshal to the "<exchange>.dlx" exchange,
+// with the same routing key, instead of only dropping it. Bind a queue to it to inspect poison messages.
+func WithDeadLetter(enabled bool) ServiceOption {
+	return func(o *serviceOptions) {
+		o.deadLetter = enabled
+	}
+}
+
+// WithPrefetch lets each subscription hold up to count unacknowledged deliveries, so a fast consumer
+// does not wait for a round-trip per message. A count below 1 keeps the default of 1.
+func WithPrefetch(count int) ServiceOption {
+	return func(o *serviceOptions) {
+		o.prefetch = count
+	}
 }
 
-func NewGenericRabbitMQService[M any](conn *amqp.Connection, exchangeName string) (*GenericRabbitMQService[M], error) {
+// WithMetrics reports the published, delivered and dropped messages of the service to metrics,
+// a delivery the subscriber does not take in time counts as dropped.
+func WithMetrics(metrics mq.Metrics) ServiceOption {
+	return func(o *serviceOptions) {
+		o.metrics = metrics
+	}
+}
+
+// withAction sets the action the service reports to metrics, set by the queue of the action.
+func withAction(action mq.Action) ServiceOption {
+	return func(o *serviceOptions) {
+		o.action = action
+	}
+}
+
+// DeadLetterExchange returns the name of the dead-letter exchange of the exchange, see WithDeadLetter.
+func DeadLetterExchange(exchangeName string) string {
+	return exchangeName + ".dlx"
+}
+
+func NewGenericRabbitMQService[M any](conn *amqp.Connection, exchangeName string, opts ...ServiceOption) (*GenericRabbitMQService[M], error) {
 	if conn == nil {
 		return nil, fmt.Errorf("RabbitMQ connection is nil")
 	}
+	options := serviceOptions{prefetch: defaultPrefetch}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.prefetch < 1 {
+		options.prefetch = defaultPrefetch
+	}
+	s := &GenericRabbitMQService[M]{
+		conn: conn, exchangeName: exchangeName, activeConsumers: make(map[uuid.UUID]*consumerInfo),
+		openChannel: func() (publishChannel, error) {
+			ch, err := conn.Channel()
+			if err != nil {
+				return nil, err
+			}
+			return ch, nil
+		},
+		deadLetter: options.deadLetter,
+		prefetch:   options.prefetch,
+		action:     options.action,
+		metrics:    options.metrics,
+	}
 	pubCh, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open publish channel: %w", err)
 	}
-	err = pubCh.ExchangeDeclare(exchangeName, "topic", true, false, false, false, nil)
-	if err != nil {
+	if err = s.declareExchanges(pubCh); err != nil {
 		_ = pubCh.Close()
-		return nil, fmt.Errorf("failed to declare exchange %s: %w", exchangeName, err)
+		return nil, err
+	}
+	s.publishChannel = pubCh
+	return s, nil
+}
+
+// counter returns the metrics of the service, a service without metrics counts nothing.
+func (s *GenericRabbitMQService[M]) counter() mq.Metrics {
+	if s.metrics == nil {
+		return mq.NopMetrics{}
 	}
-	return &GenericRabbitMQService[M]{
-		conn: conn, publishChannel: pubCh, exchangeName: exchangeName, activeConsumers: make(map[uuid.UUID]*consumerInfo),
-	}, nil
+	return s.metrics
+}
+
+// declareExchanges declares the exchange, and the dead-letter exchange when it is enabled, on the channel.
+func (s *GenericRabbitMQService[M]) declareExchanges(ch publishChannel) error {
+	if err := ch.ExchangeDeclare(s.exchangeName, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", s.exchangeName, err)
+	}
+	if s.deadLetter {
+		dlx := DeadLetterExchange(s.exchangeName)
+		if err := ch.ExchangeDeclare(dlx, "topic", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare dead-letter exchange %s: %w", dlx, err)
+		}
+	}
+	return nil
+}
+
+// publishDeadLetter republishes the raw delivery to the dead-letter exchange, the unmarshal error is kept in the x-error header.
+func (s *GenericRabbitMQService[M]) publishDeadLetter(delivery amqp.Delivery, cause error) error {
+	s.publishMutex.Lock()
+	defer s.publishMutex.Unlock()
+	if err := s.ensurePublishChannel(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.publishChannel.PublishWithContext(ctx, DeadLetterExchange(s.exchangeName), delivery.RoutingKey, false, false,
+		amqp.Publishing{
+			ContentType:  delivery.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    delivery.MessageId,
+			Headers:      amqp.Table{"x-error": cause.Error()},
+			Body:         delivery.Body,
+		})
+}
+
+// ensurePublishChannel reopens the publish channel from the connection when it was closed, e.g. by a broker restart,
+// and declares the exchange on it again. It must be called with publishMutex held.
+func (s *GenericRabbitMQService[M]) ensurePublishChannel() error {
+	if s.closed {
+		return errors.New("service is closed")
+	}
+	if s.publishChannel != nil && !s.publishChannel.IsClosed() {
+		return nil
+	}
+	var err error
+	for attempt := 0; attempt < publishReopenAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(publishReopenBackoff << (attempt - 1))
+		}
+		var ch publishChannel
+		if ch, err = s.openChannel(); err != nil {
+			continue
+		}
+		if err = s.declareExchanges(ch); err != nil {
+			_ = ch.Close()
+			continue
+		}
+		log.Printf("Reopened publish channel of exchange %s", s.exchangeName)
+		s.publishChannel = ch
+		s.confirmMode = false // confirm mode belongs to the closed channel
+		return nil
+	}
+	return fmt.Errorf("failed to reopen after %d attempts: %w", publishReopenAttempts, err)
 }
 
 func (s *GenericRabbitMQService[M]) Publish(msg mq.TopicProvider) error {
 	s.publishMutex.Lock()
 	defer s.publishMutex.Unlock()
 	typeName := reflect.TypeOf(msg).Name()
-	if s.publishChannel == nil || s.publishChannel.IsClosed() {
-		return fmt.Errorf("publish channel for %s is not available", typeName)
+	if err := s.ensurePublishChannel(); err != nil {
+		return fmt.Errorf("publish channel for %s is not available: %w", typeName, err)
 	}
 	body, err := json.Marshal(msg)
 	if err != nil {
@@ -66,15 +235,61 @@ func (s *GenericRabbitMQService[M]) Publish(msg mq.TopicProvider) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	routingKey := msg.GetTopic().String()
-	return s.publishChannel.PublishWithContext(ctx, s.exchangeName, routingKey, false, false,
+	err = s.publishChannel.PublishWithContext(ctx, s.exchangeName, routingKey, false, false,
 		amqp.Publishing{ContentType: "application/json", DeliveryMode: amqp.Persistent, Body: body})
+	if err != nil {
+		return err
+	}
+	s.counter().IncPublished(s.action)
+	return nil
+}
+
+// PublishWithReceipt publishes msg with messageID as AMQP message ID and waits for the broker to confirm it,
+// the confirmed delivery tag is returned as Receipt.BrokerRef.
+func (s *GenericRabbitMQService[M]) PublishWithReceipt(msg mq.TopicProvider, messageID string) (mq.Receipt, error) {
+	s.publishMutex.Lock()
+	defer s.publishMutex.Unlock()
+	typeName := reflect.TypeOf(msg).Name()
+	if err := s.ensurePublishChannel(); err != nil {
+		return mq.Receipt{}, fmt.Errorf("publish channel for %s is not available: %w", typeName, err)
+	}
+	if !s.confirmMode {
+		if err := s.publishChannel.Confirm(false); err != nil {
+			return mq.Receipt{}, fmt.Errorf("failed to enable publisher confirms for %s: %w", typeName, err)
+		}
+		s.confirmMode = true
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return mq.Receipt{}, fmt.Errorf("failed to marshal %s: %w", typeName, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	routingKey := msg.GetTopic().String()
+	confirmation, err := s.publishChannel.PublishWithDeferredConfirmWithContext(ctx, s.exchangeName, routingKey, false, false,
+		amqp.Publishing{ContentType: "application/json", DeliveryMode: amqp.Persistent, MessageId: messageID, Body: body})
+	if err != nil {
+		return mq.Receipt{}, err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return mq.Receipt{}, fmt.Errorf("failed to wait for confirm of %s: %w", typeName, err)
+	}
+	if !acked {
+		return mq.Receipt{}, fmt.Errorf("broker rejected %s %s", typeName, messageID)
+	}
+	s.counter().IncPublished(s.action)
+	return mq.Receipt{ID: messageID, BrokerRef: strconv.FormatUint(confirmation.DeliveryTag, 10)}, nil
 }
 
 func (s *GenericRabbitMQService[M]) Subscribe(tripId uuid.UUID, unmarshalFn UnmarshalFunc[M]) (uuid.UUID, <-chan M, error) {
 	subscriptionID := uuid.New()
 	typeName := reflect.TypeOf(*new(M)).Name()
-	if s.publishChannel == nil || s.publishChannel.IsClosed() {
-		return uuid.Nil, nil, fmt.Errorf("publish channel for %s is not available", typeName)
+	s.publishMutex.Lock()
+	err := s.ensurePublishChannel()
+	s.publishMutex.Unlock()
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("publish channel for %s is not available: %w", typeName, err)
 	}
 	subChannel, err := s.conn.Channel()
 	if err != nil {
@@ -89,7 +304,7 @@ func (s *GenericRabbitMQService[M]) Subscribe(tripId uuid.UUID, unmarshalFn Unma
 		_ = subChannel.Close()
 		return uuid.Nil, nil, fmt.Errorf("failed to bind queue for %s: %w", typeName, err)
 	}
-	if err = subChannel.Qos(1, 0, false); err != nil {
+	if err = subChannel.Qos(s.prefetch, 0, false); err != nil {
 		_ = subChannel.Close()
 		return uuid.Nil, nil, fmt.Errorf("failed to set QoS for %s: %w", typeName, err)
 	}
@@ -104,6 +319,8 @@ func (s *GenericRabbitMQService[M]) Subscribe(tripId uuid.UUID, unmarshalFn Unma
 	s.consumersMutex.Lock()
 	cusInfo := consumerInfo{
 		tag:     consumerTag,
+		queue:   queue.Name,
+		tripID:  tripId,
 		channel: subChannel,
 		cancel:  stopChan,
 	}
@@ -138,17 +355,24 @@ func (s *GenericRabbitMQService[M]) Subscribe(tripId uuid.UUID, unmarshalFn Unma
 				msg, err := unmarshalFn(delivery.Body)
 				if err != nil {
 					log.Printf("Error unmarshaling %s for %s: %v. Body: %s", typeName, subscriptionID, err, string(delivery.Body))
+					if s.deadLetter {
+						if dlxErr := s.publishDeadLetter(delivery, err); dlxErr != nil {
+							log.Printf("Error dead-lettering %s for %s: %v", typeName, subscriptionID, dlxErr)
+						}
+					}
 					_ = delivery.Nack(false, false)
 					continue
 				}
 				select {
 				case msgChan <- msg:
+					s.counter().IncDelivered(s.action)
 				case <-stopChan:
 					// log.Printf("%s consumer %s stopping while sending to msgChan.", typeName, subscriptionID)
 					_ = delivery.Ack(false)
 					return
 				case <-time.After(2 * time.Second):
 					log.Printf("Timeout sending %s to msgChan for %s.", typeName, subscriptionID)
+					s.counter().IncDropped(s.action)
 					_ = delivery.Ack(false)
 					continue
 				}
@@ -179,9 +403,39 @@ func (s *GenericRabbitMQService[M]) DeSubscribe(id uuid.UUID) error {
 	return nil
 }
 
+// DeSubscribeTrip stops every consumer subscribed to the trip.
+func (s *GenericRabbitMQService[M]) DeSubscribeTrip(tripId uuid.UUID) error {
+	var infos []*consumerInfo
+	s.consumersMutex.Lock()
+	for id, info := range s.activeConsumers {
+		if info.tripID == tripId {
+			delete(s.activeConsumers, id)
+			infos = append(infos, info)
+		}
+	}
+	s.consumersMutex.Unlock()
+	for _, info := range infos {
+		select {
+		case <-info.cancel:
+		default:
+			close(info.cancel)
+		}
+	}
+	return nil
+}
+
+// Close closes the publish channel and all consumers, calls after the first one are no-op.
 func (s *GenericRabbitMQService[M]) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *GenericRabbitMQService[M]) close() error {
 	s.publishMutex.Lock()
 	defer s.publishMutex.Unlock()
+	s.closed = true
 	if s.publishChannel != nil {
 		if err := s.publishChannel.Close(); err != nil {
 			return fmt.Errorf("failed to close publish channel: %w", err)
@@ -201,13 +455,70 @@ func (s *GenericRabbitMQService[M]) Close() error {
 	return nil
 }
 
+// shutdown closes the service, when cleanup is set the exchange is deleted from broker as well.
+func (s *GenericRabbitMQService[M]) shutdown(cleanup bool) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if !cleanup {
+		return nil
+	}
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel to delete exchange %s: %w", s.exchangeName, err)
+	}
+	defer func(ch *amqp.Channel) {
+		_ = ch.Close()
+	}(ch)
+	if err := ch.ExchangeDelete(s.exchangeName, false, false); err != nil {
+		return fmt.Errorf("failed to delete exchange %s: %w", s.exchangeName, err)
+	}
+	if s.deadLetter {
+		if err := ch.ExchangeDelete(DeadLetterExchange(s.exchangeName), false, false); err != nil {
+			return fmt.Errorf("failed to delete exchange %s: %w", DeadLetterExchange(s.exchangeName), err)
+		}
+	}
+	return nil
+}
+
+type TripMQ struct {
+	genericService   *GenericRabbitMQService[mq.TripMessage]
+	configuredAction mq.Action
+}
+
+func NewTripMessageQueue(conn *amqp.Connection, exchangeName string, action mq.Action, opts ...ServiceOption) (*TripMQ, error) {
+	gs, err := NewGenericRabbitMQService[mq.TripMessage](conn, exchangeName, append([]ServiceOption{withAction(action)}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generic service for Trip: %w", err)
+	}
+	return &TripMQ{genericService: gs, configuredAction: action}, nil
+}
+func (q *TripMQ) GetAction() mq.Action             { return q.configuredAction }
+func (q *TripMQ) Publish(msg mq.TripMessage) error { return q.genericService.Publish(msg) }
+func (q *TripMQ) PublishWithReceipt(msg mq.TripMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
+func unmarshalTripMessage(data []byte) (mq.TripMessage, error) {
+	var msg mq.TripMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+func (q *TripMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripMessage, error) {
+	return q.genericService.Subscribe(tripId, unmarshalTripMessage)
+}
+func (q *TripMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
+
 type TripRecordMQ struct {
 	genericService   *GenericRabbitMQService[mq.TripRecordMessage]
 	configuredAction mq.Action
 }
 
-func NewTripRecordMessageQueue(conn *amqp.Connection, exchangeName string, action mq.Action) (*TripRecordMQ, error) {
-	gs, err := NewGenericRabbitMQService[mq.TripRecordMessage](conn, exchangeName)
+func NewTripRecordMessageQueue(conn *amqp.Connection, exchangeName string, action mq.Action, opts ...ServiceOption) (*TripRecordMQ, error) {
+	gs, err := NewGenericRabbitMQService[mq.TripRecordMessage](conn, exchangeName, append([]ServiceOption{withAction(action)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create generic service for TripRecord: %w", err)
 	}
@@ -215,6 +526,10 @@ func NewTripRecordMessageQueue(conn *amqp.Connection, exchangeName string, actio
 }
 func (q *TripRecordMQ) GetAction() mq.Action                   { return q.configuredAction }
 func (q *TripRecordMQ) Publish(msg mq.TripRecordMessage) error { return q.genericService.Publish(msg) }
+func (q *TripRecordMQ) PublishWithReceipt(msg mq.TripRecordMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
 func unmarshalTripRecordMessage(data []byte) (mq.TripRecordMessage, error) {
 	var msg mq.TripRecordMessage
 	err := json.Unmarshal(data, &msg)
@@ -224,14 +539,17 @@ func (q *TripRecordMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripRec
 	return q.genericService.Subscribe(tripId, unmarshalTripRecordMessage)
 }
 func (q *TripRecordMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripRecordMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
 
 type TripAddressMQ struct {
 	genericService   *GenericRabbitMQService[mq.TripAddressMessage]
 	configuredAction mq.Action
 }
 
-func NewTripAddressMessageQueue(conn *amqp.Connection, exchangeName string, action mq.Action) (*TripAddressMQ, error) {
-	gs, err := NewGenericRabbitMQService[mq.TripAddressMessage](conn, exchangeName)
+func NewTripAddressMessageQueue(conn *amqp.Connection, exchangeName string, action mq.Action, opts ...ServiceOption) (*TripAddressMQ, error) {
+	gs, err := NewGenericRabbitMQService[mq.TripAddressMessage](conn, exchangeName, append([]ServiceOption{withAction(action)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create generic service for TripAddress: %w", err)
 	}
@@ -241,6 +559,10 @@ func (q *TripAddressMQ) GetAction() mq.Action { return q.configuredAction }
 func (q *TripAddressMQ) Publish(msg mq.TripAddressMessage) error {
 	return q.genericService.Publish(msg)
 }
+func (q *TripAddressMQ) PublishWithReceipt(msg mq.TripAddressMessage) (mq.Receipt, error) {
+	msg.MessageID = uuid.NewString()
+	return q.genericService.PublishWithReceipt(msg, msg.MessageID)
+}
 func unmarshalTripAddressMessage(data []byte) (mq.TripAddressMessage, error) {
 	var msg mq.TripAddressMessage
 	err := json.Unmarshal(data, &msg)
@@ -250,12 +572,59 @@ func (q *TripAddressMQ) Subscribe(tripId uuid.UUID) (uuid.UUID, <-chan mq.TripAd
 	return q.genericService.Subscribe(tripId, unmarshalTripAddressMessage)
 }
 func (q *TripAddressMQ) DeSubscribe(id uuid.UUID) error { return q.genericService.DeSubscribe(id) }
+func (q *TripAddressMQ) DeSubscribeTrip(tripId uuid.UUID) error {
+	return q.genericService.DeSubscribeTrip(tripId)
+}
 
 // --------- trip message queue wrapper implementation ---------
 
 type TripMessageQueueWrapper struct {
+	TripMQArray    [mq.ActionCnt]*TripMQ
 	RecordMQArray  [mq.ActionCnt]*TripRecordMQ
 	AddressMQArray [mq.ActionCnt]*TripAddressMQ
+	// CleanupOnClose deletes the exchanges on Close, keep it off in production.
+	CleanupOnClose bool
+	closeOnce      sync.Once
+	closeErr       error
+}
+
+// Close shuts down all queues, exchanges are deleted when CleanupOnClose is set.
+// The connection is owned by the caller and is not closed here, calls after the first one are no-op.
+func (wrapper *TripMessageQueueWrapper) Close() error {
+	wrapper.closeOnce.Do(func() {
+		wrapper.closeErr = wrapper.close()
+	})
+	return wrapper.closeErr
+}
+
+func (wrapper *TripMessageQueueWrapper) close() error {
+	var errs []error
+	for _, q := range wrapper.TripMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.RecordMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	for _, q := range wrapper.AddressMQArray {
+		if q != nil {
+			errs = append(errs, q.genericService.shutdown(wrapper.CleanupOnClose))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (wrapper *TripMessageQueueWrapper) GetTripMessageQueue(action mq.Action) mq.TripMessageQueue {
+	if action < 0 || action >= mq.ActionCnt {
+		return nil // or handle the error as needed
+	}
+	if wrapper.TripMQArray[action] == nil {
+		return nil
+	}
+	return wrapper.TripMQArray[action]
 }
 
 func (wrapper *TripMessageQueueWrapper) GetTripRecordMessageQueue(action mq.Action) mq.TripRecordMessageQueue {
@@ -275,30 +644,38 @@ func (wrapper *TripMessageQueueWrapper) GetTripAddressMessageQueue(action mq.Act
 	return wrapper.AddressMQArray[action]
 }
 
-// NewRabbitTripMessageQueueWrapper creates a new instance of RabbitTripMessageQueueWrapper.
-func NewRabbitTripMessageQueueWrapper(conn *amqp.Connection) (mq.TripMessageQueueWrapper, error) {
+// NewRabbitTripMessageQueueWrapper creates a new instance of RabbitTripMessageQueueWrapper,
+// opts apply to every queue of the wrapper, e.g. WithPrefetch or WithMetrics.
+func NewRabbitTripMessageQueueWrapper(conn *amqp.Connection, opts ...ServiceOption) (mq.TripMessageQueueWrapper, error) {
 	wrapper := TripMessageQueueWrapper{}
 	var err error
+	// trip only need remove
+	wrapper.TripMQArray[mq.ActionCreate] = nil
+	wrapper.TripMQArray[mq.ActionUpdate] = nil
+	wrapper.TripMQArray[mq.ActionDelete], err = NewTripMessageQueue(conn, fmt.Sprintf("trip_exchange_%d", mq.ActionDelete), mq.ActionDelete, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating TripMessageQueue for ActionDelete: %w", err)
+	}
 	// address need add and remove
-	wrapper.AddressMQArray[mq.ActionCreate], err = NewTripAddressMessageQueue(conn, fmt.Sprintf("trip_address_exchange_%d", mq.ActionCreate), mq.ActionCreate)
+	wrapper.AddressMQArray[mq.ActionCreate], err = NewTripAddressMessageQueue(conn, fmt.Sprintf("trip_address_exchange_%d", mq.ActionCreate), mq.ActionCreate, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TripAddressMessageQueue for ActionCreate: %w", err)
 	}
 	wrapper.AddressMQArray[mq.ActionUpdate] = nil
-	wrapper.AddressMQArray[mq.ActionDelete], err = NewTripAddressMessageQueue(conn, fmt.Sprintf("trip_address_exchange_%d", mq.ActionDelete), mq.ActionDelete)
+	wrapper.AddressMQArray[mq.ActionDelete], err = NewTripAddressMessageQueue(conn, fmt.Sprintf("trip_address_exchange_%d", mq.ActionDelete), mq.ActionDelete, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TripAddressMessageQueue for ActionDelete: %w", err)
 	}
 	// record need add, update and delete
-	wrapper.RecordMQArray[mq.ActionCreate], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionCreate), mq.ActionCreate)
+	wrapper.RecordMQArray[mq.ActionCreate], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionCreate), mq.ActionCreate, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TripRecordMessageQueue for ActionCreate: %w", err)
 	}
-	wrapper.RecordMQArray[mq.ActionUpdate], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionUpdate), mq.ActionUpdate)
+	wrapper.RecordMQArray[mq.ActionUpdate], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionUpdate), mq.ActionUpdate, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TripRecordMessageQueue for ActionUpdate: %w", err)
 	}
-	wrapper.RecordMQArray[mq.ActionDelete], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionDelete), mq.ActionDelete)
+	wrapper.RecordMQArray[mq.ActionDelete], err = NewTripRecordMessageQueue(conn, fmt.Sprintf("trip_record_exchange_%d", mq.ActionDelete), mq.ActionDelete, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TripRecordMessageQueue for ActionDelete: %w", err)
 	}
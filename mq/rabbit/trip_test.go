@@ -4,12 +4,15 @@ package rabbit_test // Testing the 'rabbit' package as a black box providing 'mq
 import (
 
 	// "MODULE_PATH/YOUR_PROJECT/dtm/db/db" // Assuming this path for db.Address
+	"context"
 	"dtm/db/db"
 	"dtm/mq/mq"              // MQ interfaces
 	rabbitMQ "dtm/mq/rabbit" // RabbitMQ implementation of MQ interfaces
+	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -350,6 +353,44 @@ func TestMQInterfacesWithRabbitMQ(t *testing.T) {
 		// Be mindful of any specific error handling behavior in its Publish method.
 	})
 
+	// --- Test TripMessageQueue ---
+	t.Run("TripMessageQueue_DeleteEvent", func(t *testing.T) {
+		tq := wrapper.GetTripMessageQueue(mq.ActionDelete)
+		if tq == nil {
+			t.Fatalf("GetTripMessageQueue(%v) returned nil", mq.ActionDelete)
+		}
+		if q := wrapper.GetTripMessageQueue(mq.ActionCreate); q != nil {
+			t.Errorf("GetTripMessageQueue(ActionCreate) expected nil, got %T", q)
+		}
+
+		tripID := uuid.New()
+		msgToPublish := mq.TripMessage{ID: tripID, Name: "Deleted Trip"}
+
+		subID, rcvChan, err := tq.Subscribe(tripID)
+		if err != nil {
+			t.Fatalf("tq.Subscribe failed: %v", err)
+		}
+		defer func(tq mq.TripMessageQueue, id uuid.UUID) {
+			err := tq.DeSubscribe(id)
+			if err != nil {
+				log.Fatalf("tq.DeSubscribe failed: %v", err)
+			}
+		}(tq, subID)
+
+		time.Sleep(200 * time.Millisecond)
+		if err := tq.Publish(msgToPublish); err != nil {
+			t.Fatalf("tq.Publish failed: %v", err)
+		}
+
+		receivedMsg, ok := receiveMsgWithTimeout(t, rcvChan, 3*time.Second)
+		if !ok {
+			t.Fatalf("Timeout or channel closed while waiting for message on TripMessageQueue")
+		}
+		if !reflect.DeepEqual(receivedMsg, msgToPublish) {
+			t.Errorf("Received Trip message\n%+v\ndoes not match published message\n%+v", receivedMsg, msgToPublish)
+		}
+	})
+
 	// --- Test TripMessageQueueWrapper ---
 	t.Run("TripMessageQueueWrapper_Getters", func(t *testing.T) {
 		// Test GetTripRecordMessageQueue
@@ -458,3 +499,261 @@ func TestMQInterfacesWithRabbitMQ(t *testing.T) {
 		t.Log("Context/Cancellation test (via DeSubscribe) completed.")
 	})
 }
+
+func TestTripMessageQueueWrapper_CloseWithCleanup(t *testing.T) {
+	conn := getTestConnection(t)
+	defer func(conn *amqp.Connection) {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	wrapperIFace, err := rabbitMQ.NewRabbitTripMessageQueueWrapper(conn)
+	if err != nil {
+		t.Fatalf("Failed to create RabbitTripMessageQueueWrapper: %v", err)
+	}
+	wrapper, ok := wrapperIFace.(*rabbitMQ.TripMessageQueueWrapper)
+	if !ok {
+		t.Fatal("NewRabbitTripMessageQueueWrapper did not return *TripMessageQueueWrapper")
+	}
+	wrapper.CleanupOnClose = true
+
+	if err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: uuid.New(), Name: "cleanup"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if err := wrapper.Close(); err != nil {
+		t.Fatalf("Close with cleanup failed: %v", err)
+	}
+
+	// passive declare fails (and closes the channel) when the exchange does not exist
+	exchangeName := fmt.Sprintf("trip_record_exchange_%d", mq.ActionCreate)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	if err := ch.ExchangeDeclarePassive(exchangeName, "topic", true, false, false, false, nil); err == nil {
+		_ = ch.Close()
+		t.Errorf("Exchange %s still exists after Close with cleanup", exchangeName)
+	}
+}
+
+func TestTripMessageQueueWrapper_CloseTwice(t *testing.T) {
+	conn := getTestConnection(t)
+	defer func(conn *amqp.Connection) {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	wrapperIFace, err := rabbitMQ.NewRabbitTripMessageQueueWrapper(conn)
+	if err != nil {
+		t.Fatalf("Failed to create RabbitTripMessageQueueWrapper: %v", err)
+	}
+	wrapper, ok := wrapperIFace.(*rabbitMQ.TripMessageQueueWrapper)
+	if !ok {
+		t.Fatal("NewRabbitTripMessageQueueWrapper did not return *TripMessageQueueWrapper")
+	}
+	_, _, err = wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(uuid.New())
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// e.g. from both a defer and a shutdown handler
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = wrapper.Close()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Close call %d failed: %v", i, err)
+		}
+	}
+	if err := wrapper.Close(); err != nil {
+		t.Errorf("Close after close failed: %v", err)
+	}
+
+	gs, err := rabbitMQ.NewGenericRabbitMQService[mq.TripMessage](conn, "close_twice_exchange")
+	if err != nil {
+		t.Fatalf("Failed to create generic service: %v", err)
+	}
+	if err := gs.Close(); err != nil {
+		t.Errorf("first Close failed: %v", err)
+	}
+	if err := gs.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+}
+
+func TestTripRecordMessageQueue_DeSubscribeTrip(t *testing.T) {
+	conn := getTestConnection(t)
+	defer func(conn *amqp.Connection) {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	wrapper, err := rabbitMQ.NewRabbitTripMessageQueueWrapper(conn)
+	if err != nil {
+		t.Fatalf("Failed to create RabbitTripMessageQueueWrapper: %v", err)
+	}
+	trq := wrapper.GetTripRecordMessageQueue(mq.ActionCreate)
+	tripID := uuid.New()
+
+	var rcvChans []<-chan mq.TripRecordMessage
+	for i := 0; i < 3; i++ {
+		_, rcvChan, err := trq.Subscribe(tripID)
+		if err != nil {
+			t.Fatalf("Subscribe %d failed: %v", i, err)
+		}
+		rcvChans = append(rcvChans, rcvChan)
+	}
+	otherID, otherChan, err := trq.Subscribe(uuid.New())
+	if err != nil {
+		t.Fatalf("Subscribe other trip failed: %v", err)
+	}
+
+	if err := trq.DeSubscribeTrip(tripID); err != nil {
+		t.Fatalf("DeSubscribeTrip failed: %v", err)
+	}
+	for i, rcvChan := range rcvChans {
+		select {
+		case _, ok := <-rcvChan:
+			if ok {
+				t.Errorf("Unexpected message on channel %d after DeSubscribeTrip", i)
+			}
+		case <-time.After(3 * time.Second):
+			t.Errorf("Channel %d was not closed after DeSubscribeTrip", i)
+		}
+	}
+	if isChanClosed(otherChan) {
+		t.Error("Channel of other trip should stay open")
+	}
+	if err := trq.DeSubscribe(otherID); err != nil {
+		t.Errorf("DeSubscribe other trip failed: %v", err)
+	}
+}
+
+func TestTripRecordMessageQueue_PublishWithReceipt(t *testing.T) {
+	conn := getTestConnection(t)
+	defer func(conn *amqp.Connection) {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	wrapper, err := rabbitMQ.NewRabbitTripMessageQueueWrapper(conn)
+	if err != nil {
+		t.Fatalf("Failed to create RabbitTripMessageQueueWrapper: %v", err)
+	}
+	trq, ok := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).(mq.ReceiptPublisher[mq.TripRecordMessage])
+	if !ok {
+		t.Fatal("TripRecordMQ does not implement ReceiptPublisher")
+	}
+	tripID := uuid.New()
+	subID, rcvChan, err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(tripID)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer func() { _ = wrapper.GetTripRecordMessageQueue(mq.ActionCreate).DeSubscribe(subID) }()
+
+	receipt, err := trq.PublishWithReceipt(mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: "receipt"})
+	if err != nil {
+		t.Fatalf("PublishWithReceipt failed: %v", err)
+	}
+	if receipt.ID == "" || receipt.BrokerRef == "" {
+		t.Fatalf("receipt is incomplete: %+v", receipt)
+	}
+	msg, ok := receiveMsgWithTimeout(t, rcvChan, 5*time.Second)
+	if !ok {
+		t.Fatal("Timeout waiting for message")
+	}
+	if msg.MessageID != receipt.ID {
+		t.Errorf("delivered MessageID %q does not match receipt ID %q", msg.MessageID, receipt.ID)
+	}
+}
+
+func TestGenericService_DeadLetter(t *testing.T) {
+	conn := getTestConnection(t)
+	defer func(conn *amqp.Connection) {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}(conn)
+
+	exchange := "dead_letter_test_exchange"
+	gs, err := rabbitMQ.NewGenericRabbitMQService[mq.TripMessage](conn, exchange, rabbitMQ.WithDeadLetter(true))
+	if err != nil {
+		t.Fatalf("Failed to create generic service: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	defer func() {
+		_ = gs.Close()
+		_ = ch.ExchangeDelete(rabbitMQ.DeadLetterExchange(exchange), false, false)
+		_ = ch.ExchangeDelete(exchange, false, false)
+		_ = ch.Close()
+	}()
+
+	// operators inspect poison messages from a queue bound to the dead-letter exchange
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("Failed to declare dead-letter queue: %v", err)
+	}
+	if err := ch.QueueBind(queue.Name, "#", rabbitMQ.DeadLetterExchange(exchange), false, nil); err != nil {
+		t.Fatalf("Failed to bind dead-letter queue: %v", err)
+	}
+	deadLetters, err := ch.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		t.Fatalf("Failed to consume dead-letter queue: %v", err)
+	}
+
+	tripID := uuid.New()
+	_, msgChan, err := gs.Subscribe(tripID, func(data []byte) (mq.TripMessage, error) {
+		var msg mq.TripMessage
+		err := json.Unmarshal(data, &msg)
+		return msg, err
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	malformed := []byte(`{"ID": "not a uuid"`)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = ch.PublishWithContext(ctx, exchange, tripID.String(), false, false,
+		amqp.Publishing{ContentType: "application/json", Body: malformed})
+	if err != nil {
+		t.Fatalf("Failed to publish malformed message: %v", err)
+	}
+
+	select {
+	case dead := <-deadLetters:
+		if string(dead.Body) != string(malformed) {
+			t.Errorf("Expected dead letter body %q, got %q", malformed, dead.Body)
+		}
+		if dead.RoutingKey != tripID.String() {
+			t.Errorf("Expected dead letter routing key %s, got %s", tripID, dead.RoutingKey)
+		}
+		if _, ok := dead.Headers["x-error"]; !ok {
+			t.Error("Expected the unmarshal error in the x-error header")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Malformed message did not land on the dead-letter exchange")
+	}
+	if msg, ok := receiveMsgWithTimeout(t, msgChan, 200*time.Millisecond); ok {
+		t.Errorf("Expected no message for the subscriber, got %+v", msg)
+	}
+}
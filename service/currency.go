@@ -0,0 +1,72 @@
+package service
+
+import (
+	"dtm/db/db"
+	"dtm/tx"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// currencyMinorUnit is the increment of currencies which are not settled in cents.
+var currencyMinorUnit = map[string]float64{
+	"CLP": 1,
+	"ISK": 1,
+	"JPY": 1,
+	"KRW": 1,
+	"TWD": 1,
+	"VND": 1,
+}
+
+// ParseCurrency returns the upper case ISO 4217 code, empty stays empty for the deployment default.
+func ParseCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "", nil
+	}
+	if len(code) != 3 || strings.IndexFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return "", fmt.Errorf("invalid currency code: %s", code)
+	}
+	return code, nil
+}
+
+// ParseRoundingPrecision checks the rounding increment, 0 disables rounding.
+func ParseRoundingPrecision(precision float64) (float64, error) {
+	if precision != 0 && precision < tx.MinValueTxOutput {
+		return 0, fmt.Errorf("rounding precision %v is smaller than %.2f", precision, tx.MinValueTxOutput)
+	}
+	return precision, nil
+}
+
+// TripCurrency returns the currency the trip settles in, DefaultCurrency when the trip has none.
+func (c SettlementConfig) TripCurrency(info *db.TripInfo) string {
+	if info == nil || info.Currency == "" {
+		return c.DefaultCurrency
+	}
+	return info.Currency
+}
+
+// RoundingPrecision returns the increment transfers in the currency are rounded to, 0 keeps them unrounded.
+// The deployment default uses DefaultRoundingPrecision, other currencies their minor unit.
+func (c SettlementConfig) RoundingPrecision(currency string) float64 {
+	if currency == c.DefaultCurrency {
+		return c.DefaultRoundingPrecision
+	}
+	if unit, ok := currencyMinorUnit[currency]; ok {
+		return unit
+	}
+	return tx.MinValueTxOutput
+}
+
+// FormatAmount formats the amount with the decimal places of the rounding precision of the currency,
+// unrounded currencies keep two decimal places.
+func (c SettlementConfig) FormatAmount(amount float64, currency string) string {
+	decimals := 2
+	if precision := c.RoundingPrecision(currency); precision > 0 {
+		decimals = 0
+		if _, fraction, ok := strings.Cut(strconv.FormatFloat(precision, 'f', -1, 64), "."); ok {
+			decimals = len(fraction)
+		}
+	}
+	return strconv.FormatFloat(amount, 'f', decimals, 64)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundingPrecision(t *testing.T) {
+	t.Run("No default keeps the default currency unrounded", func(t *testing.T) {
+		settlement := SettlementConfig{}
+		assert.Zero(t, settlement.RoundingPrecision(""))
+		assert.Equal(t, "3.33", settlement.FormatAmount(10.0/3, ""))
+		assert.Equal(t, 0.01, settlement.RoundingPrecision("USD"))
+		assert.Equal(t, 1.0, settlement.RoundingPrecision("TWD"))
+	})
+
+	t.Run("Default currency uses the configured precision", func(t *testing.T) {
+		settlement := SettlementConfig{DefaultCurrency: "TWD", DefaultRoundingPrecision: 0.1}
+		assert.Equal(t, 0.1, settlement.RoundingPrecision("TWD"))
+		assert.Equal(t, "3.3", settlement.FormatAmount(3.3, "TWD"))
+		assert.Equal(t, 1.0, settlement.RoundingPrecision("JPY"))
+	})
+}
+
+func TestParseCurrency(t *testing.T) {
+	currency, err := ParseCurrency(" twd ")
+	require.NoError(t, err)
+	assert.Equal(t, "TWD", currency)
+
+	currency, err = ParseCurrency("")
+	require.NoError(t, err)
+	assert.Empty(t, currency)
+
+	for _, code := range []string{"TW", "TWDX", "T1D"} {
+		_, err := ParseCurrency(code)
+		assert.Error(t, err, code)
+	}
+
+	_, err = ParseRoundingPrecision(0.001)
+	assert.Error(t, err)
+	precision, err := ParseRoundingPrecision(0)
+	require.NoError(t, err)
+	assert.Zero(t, precision)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"dtm/db/db"
+	"dtm/tx"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// ZeroAmountPolicy decides how records without positive amount are handled when building payments.
+type ZeroAmountPolicy string
+
+const (
+	// ZeroAmountSkip drops the record silently, it is the default.
+	ZeroAmountSkip ZeroAmountPolicy = "skip"
+	// ZeroAmountError rejects the settlement with ErrZeroAmountRecord.
+	ZeroAmountError ZeroAmountPolicy = "error"
+	// ZeroAmountNoop keeps the record as placeholder payment, it does not affect the settlement.
+	ZeroAmountNoop ZeroAmountPolicy = "noop"
+)
+
+// ErrZeroAmountRecord is returned for a record without positive amount under ZeroAmountError.
+var ErrZeroAmountRecord = errors.New("record amount must be positive")
+
+// ParseZeroAmountPolicy returns the policy of the given name.
+func ParseZeroAmountPolicy(name string) (ZeroAmountPolicy, error) {
+	switch policy := ZeroAmountPolicy(name); policy {
+	case ZeroAmountSkip, ZeroAmountError, ZeroAmountNoop:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported zero amount policy: %s", name)
+	}
+}
+
+// SettlementConfig holds the deployment settings of every settlement, the zero value is the default of each field.
+type SettlementConfig struct {
+	// ZeroAmountPolicy handles records without positive amount, empty means ZeroAmountSkip
+	ZeroAmountPolicy ZeroAmountPolicy
+	// RecordBudget caps the records settled in one GraphQL request across all trips, 0 means unlimited.
+	// Records over the budget are left out and the settlement is marked truncated.
+	RecordBudget int
+	// DefaultCurrency is the currency of trips created without one
+	DefaultCurrency string
+	// DefaultRoundingPrecision is the increment transfers in DefaultCurrency are rounded to, 0 keeps them unrounded
+	DefaultRoundingPrecision float64
+}
+
+// SettleTrip calculates the settlement of all records in a trip directly from the db wrapper,
+// it is used outside the GraphQL request scope where no data loader is available.
+func (c SettlementConfig) SettleTrip(ctx context.Context, tripDB db.TripDBWrapper, tripID uuid.UUID) (tx.Package, float64, error) {
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		return tx.Package{}, 0, fmt.Errorf("failed to get records for trip %s: %w", tripID, err)
+	}
+	return c.settleRecords(ctx, tripDB, tripID, records)
+}
+
+// SettleGroup calculates the settlement of one sub-activity group in a trip,
+// records in other groups are not mixed into the result.
+func (c SettlementConfig) SettleGroup(ctx context.Context, tripDB db.TripDBWrapper, tripID uuid.UUID, groupID uuid.UUID) (tx.Package, float64, error) {
+	records, err := tripDB.GetTripRecordsByGroup(ctx, tripID, groupID)
+	if err != nil {
+		return tx.Package{}, 0, fmt.Errorf("failed to get records of group %s in trip %s: %w", groupID, tripID, err)
+	}
+	return c.settleRecords(ctx, tripDB, tripID, records)
+}
+
+// PreviewRecord calculates the settlement of a trip as if the candidate record was added,
+// together with the diff to the current settlement. Nothing is written to db.
+func (c SettlementConfig) PreviewRecord(ctx context.Context, tripDB db.TripDBWrapper, tripID uuid.UUID, candidate db.Record) (tx.Package, tx.SettlementDiff, error) {
+	records, err := tripDB.GetTripRecords(ctx, tripID)
+	if err != nil {
+		return tx.Package{}, tx.SettlementDiff{}, fmt.Errorf("failed to get records for trip %s: %w", tripID, err)
+	}
+	payments, err := c.recordsToUserPayments(ctx, tripDB, records)
+	if err != nil {
+		return tx.Package{}, tx.SettlementDiff{}, err
+	}
+	return tx.PreviewWithRecord(payments, tx.RecordToUserPayment(candidate.RecordInfo, candidate.ShouldPayAddress))
+}
+
+func (c SettlementConfig) settleRecords(ctx context.Context, tripDB db.TripDBWrapper, tripID uuid.UUID, records []db.RecordInfo) (tx.Package, float64, error) {
+	payments, err := c.recordsToUserPayments(ctx, tripDB, records)
+	if err != nil {
+		return tx.Package{}, 0, err
+	}
+	tripInfo, err := tripDB.GetTripInfo(ctx, tripID)
+	if err != nil {
+		return tx.Package{}, 0, fmt.Errorf("failed to get trip info %s: %w", tripID, err)
+	}
+	return c.SettleInCurrency(payments, c.TripCurrency(tripInfo))
+}
+
+// SettleInCurrency settles the payments in the currency of the trip and rounds the transfers to its RoundingPrecision.
+// The returned remaining is the one before rounding, the rounding residual is not money left unsettled.
+func (c SettlementConfig) SettleInCurrency(payments []tx.UserPayment, currency string) (tx.Package, float64, error) {
+	summary, err := c.SummaryInCurrency(payments, currency)
+	if err != nil {
+		return tx.Package{}, 0, err
+	}
+	return c.SettleSummary(summary, currency)
+}
+
+// SummaryInCurrency returns the net balance of every address of the payments in the currency of the trip, by tx.TripSummary.
+// The payments are copied before the currency is set, the caller's slice is left unchanged.
+func (c SettlementConfig) SummaryInCurrency(payments []tx.UserPayment, currency string) ([]tx.Cash, error) {
+	stamped := slices.Clone(payments)
+	for i := range stamped {
+		stamped[i].Currency = currency
+	}
+	return tx.TripSummary(stamped)
+}
+
+// SettleSummary settles the balances of SummaryInCurrency like SettleInCurrency settles the payments.
+func (c SettlementConfig) SettleSummary(summary []tx.Cash, currency string) (tx.Package, float64, error) {
+	txPackage, totalRemaining, err := tx.ShareSummary(summary)
+	if err != nil {
+		return tx.Package{}, 0, err
+	}
+	if precision := c.RoundingPrecision(currency); precision > 0 {
+		if _, err := txPackage.RoundTransfers(precision, tx.RoundFavorCreditor); err != nil {
+			return tx.Package{}, 0, err
+		}
+		txPackage.DropZeroTx()
+	}
+	return txPackage, totalRemaining, nil
+}
+
+func (c SettlementConfig) recordsToUserPayments(ctx context.Context, tripDB db.TripDBWrapper, records []db.RecordInfo) ([]tx.UserPayment, error) {
+	recordIds := make([]uuid.UUID, 0, len(records))
+	for _, record := range records {
+		if record.Amount <= 0 && c.ZeroAmountPolicy != ZeroAmountNoop {
+			continue // dropped or rejected by RecordsToUserPayments
+		}
+		recordIds = append(recordIds, record.ID)
+	}
+	shouldPay := make(map[uuid.UUID][]db.ExtendAddress)
+	if len(recordIds) > 0 {
+		var err error
+		// one batched call instead of a query per record
+		if shouldPay, err = tripDB.DataLoaderGetRecordShouldPayList(ctx, recordIds); err != nil {
+			return nil, fmt.Errorf("failed to get should pay addresses for records: %w", err)
+		}
+	}
+	return c.RecordsToUserPayments(records, shouldPay)
+}
+
+// RecordsToUserPayments maps db records and their should pay lists (keyed by record ID) to tx.UserPayment,
+// records without positive amount are handled by ZeroAmountPolicy.
+func (c SettlementConfig) RecordsToUserPayments(records []db.RecordInfo, shouldPay map[uuid.UUID][]db.ExtendAddress) ([]tx.UserPayment, error) {
+	payments := make([]tx.UserPayment, 0, len(records))
+	for _, record := range records {
+		if record.Amount > 0 {
+			payments = append(payments, tx.RecordToUserPayment(record, shouldPay[record.ID]))
+			continue
+		}
+		switch c.ZeroAmountPolicy {
+		case ZeroAmountError:
+			return nil, fmt.Errorf("record %s (%s) has amount %v: %w", record.Name, record.ID, record.Amount, ErrZeroAmountRecord)
+		case ZeroAmountNoop:
+			payment := tx.RecordToUserPayment(record, shouldPay[record.ID])
+			payment.Placeholder = true
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"dtm/db/db"
+	"dtm/db/dbtest"
+	"dtm/db/mem"
+	"dtm/tx"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettleGroup(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "week trip"}, "Alice", "Bob", "Carol")
+
+	dayOne := uuid.New()
+	dayTwo := uuid.New()
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		// day one: Alice pays 30 for Alice and Bob
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, dayOne),
+		// day two: Carol pays 90 for Bob and Carol
+		dbtest.NewGroupRecord("hotel", 90, "Carol", []db.Address{"Bob", "Carol"}, dayTwo),
+	}))
+
+	t.Run("Settle day one only", func(t *testing.T) {
+		pkg, remaining, err := SettlementConfig{}.SettleGroup(t.Context(), tripDB, tripID, dayOne)
+		require.NoError(t, err)
+		assert.Zero(t, remaining)
+		require.Len(t, pkg.TxList, 1)
+		assert.Equal(t, "Alice", pkg.TxList[0].Output.Address)
+		assert.InDelta(t, 15, pkg.TxList[0].Output.Amount, 1e-9)
+		require.Len(t, pkg.TxList[0].Input, 1)
+		assert.Equal(t, "Bob", pkg.TxList[0].Input[0].Address)
+	})
+
+	t.Run("Settle day two only", func(t *testing.T) {
+		pkg, remaining, err := SettlementConfig{}.SettleGroup(t.Context(), tripDB, tripID, dayTwo)
+		require.NoError(t, err)
+		assert.Zero(t, remaining)
+		require.Len(t, pkg.TxList, 1)
+		assert.Equal(t, "Carol", pkg.TxList[0].Output.Address)
+		assert.InDelta(t, 45, pkg.TxList[0].Output.Amount, 1e-9)
+		for _, tx := range pkg.TxList {
+			for _, input := range tx.Input {
+				assert.NotEqual(t, "Alice", input.Address, "day one address should not be mixed into day two")
+			}
+		}
+	})
+
+	t.Run("Empty group settles nothing", func(t *testing.T) {
+		pkg, remaining, err := SettlementConfig{}.SettleGroup(t.Context(), tripDB, tripID, uuid.New())
+		require.NoError(t, err)
+		assert.Zero(t, remaining)
+		assert.Empty(t, pkg.TxList)
+	})
+
+	t.Run("Unknown trip returns error", func(t *testing.T) {
+		_, _, err := SettlementConfig{}.SettleGroup(t.Context(), tripDB, uuid.New(), dayOne)
+		assert.Error(t, err)
+	})
+}
+
+func TestPreviewRecord(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "preview trip"}, "Alice", "Bob", "Carol")
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+	}))
+
+	candidate := dbtest.NewGroupRecord("hotel", 90, "Bob", []db.Address{"Alice", "Bob", "Carol"}, uuid.Nil)
+	pkg, diff, err := SettlementConfig{}.PreviewRecord(t.Context(), tripDB, tripID, candidate)
+	require.NoError(t, err)
+	assert.NotEmpty(t, pkg.TxList)
+	require.Len(t, diff.Changes, 3)
+	assert.Equal(t, "Alice", diff.Changes[0].Address)
+	assert.InDelta(t, 15, diff.Changes[0].Before, 0.01)
+	assert.InDelta(t, -15, diff.Changes[0].After, 0.01)
+
+	// preview must not save the candidate
+	records, err := tripDB.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestSettleTrip(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "settle trip"}, "Alice", "Bob", "Carol")
+	// the category of a record picks its split, hotel is split by fixed amounts
+	hotel := dbtest.NewGroupRecord("hotel", 100, "Bob", nil, uuid.Nil)
+	hotel.Category = db.CategoryFix
+	hotel.ShouldPayAddress = []db.ExtendAddress{{Address: "Alice", ExtendMsg: 70}, {Address: "Bob", ExtendMsg: 30}}
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob", "Carol"}, uuid.Nil),
+		dbtest.NewGroupRecord("taxi", 20, "Carol", []db.Address{"Alice", "Carol"}, uuid.Nil),
+		hotel,
+	}))
+
+	pkg, remaining, err := SettlementConfig{}.SettleTrip(t.Context(), tripDB, tripID)
+	require.NoError(t, err)
+	assert.InDelta(t, 0, remaining, 1e-9)
+	// Alice: +30 -10 -10 -70 = -60, Bob: -10 +100 -30 = 60, Carol: -10 +20 -10 = 0
+	assert.Equal(t, map[string]float64{"Alice": -60, "Bob": 60}, nonZero(pkg.NetBalance()))
+
+	_, _, err = SettlementConfig{}.SettleTrip(t.Context(), tripDB, uuid.New())
+	assert.Error(t, err)
+}
+
+// nonZero drops the balances which are settled.
+func nonZero(balance map[string]float64) map[string]float64 {
+	result := make(map[string]float64)
+	for addr, v := range balance {
+		if v > 1e-9 || v < -1e-9 {
+			result[addr] = v
+		}
+	}
+	return result
+}
+
+func TestSettleTrip_ZeroAmountPolicy(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "placeholder trip"}, "Alice", "Bob", "Carol")
+	placeholder := dbtest.NewGroupRecord("taxi (tbd)", 0, "Bob", []db.Address{"Alice", "Bob"}, uuid.Nil)
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+		placeholder,
+	}))
+	records, err := tripDB.GetTripRecords(t.Context(), tripID)
+	require.NoError(t, err)
+
+	assertSettledLunchOnly := func(t *testing.T, pkg tx.Package) {
+		require.Len(t, pkg.TxList, 1)
+		assert.Equal(t, "Alice", pkg.TxList[0].Output.Address)
+		assert.InDelta(t, 15, pkg.TxList[0].Output.Amount, 1e-9)
+	}
+
+	t.Run("Skip drops the record", func(t *testing.T) {
+		settlement := SettlementConfig{ZeroAmountPolicy: ZeroAmountSkip}
+		payments, err := settlement.recordsToUserPayments(t.Context(), tripDB, records)
+		require.NoError(t, err)
+		require.Len(t, payments, 1)
+		assert.Equal(t, "lunch", payments[0].Name)
+
+		pkg, _, err := settlement.SettleTrip(t.Context(), tripDB, tripID)
+		require.NoError(t, err)
+		assertSettledLunchOnly(t, pkg)
+	})
+
+	t.Run("Error rejects the record", func(t *testing.T) {
+		settlement := SettlementConfig{ZeroAmountPolicy: ZeroAmountError}
+		_, _, err := settlement.SettleTrip(t.Context(), tripDB, tripID)
+		require.ErrorIs(t, err, ErrZeroAmountRecord)
+		assert.ErrorContains(t, err, "taxi (tbd)")
+	})
+
+	t.Run("Noop keeps the record without affecting settlement", func(t *testing.T) {
+		settlement := SettlementConfig{ZeroAmountPolicy: ZeroAmountNoop}
+		payments, err := settlement.recordsToUserPayments(t.Context(), tripDB, records)
+		require.NoError(t, err)
+		require.Len(t, payments, 2)
+		assert.Equal(t, "taxi (tbd)", payments[1].Name)
+		assert.True(t, payments[1].Placeholder)
+		assert.Equal(t, []string{"Alice", "Bob"}, payments[1].ShouldPayAddress)
+
+		pkg, _, err := settlement.SettleTrip(t.Context(), tripDB, tripID)
+		require.NoError(t, err)
+		assertSettledLunchOnly(t, pkg)
+	})
+
+	_, err = ParseZeroAmountPolicy("drop")
+	assert.ErrorContains(t, err, "unsupported zero amount policy")
+}
+
+func TestSummaryInCurrency_KeepsPayments(t *testing.T) {
+	payments := []tx.UserPayment{
+		{Name: "lunch", Amount: 30, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob"}, Currency: "USD"},
+	}
+	summary, err := SettlementConfig{}.SummaryInCurrency(payments, "TWD")
+	require.NoError(t, err)
+	assert.NotEmpty(t, summary)
+	assert.Equal(t, "USD", payments[0].Currency)
+}
+
+// batchOnlyTripDB fails the per record lookup, so a test can assert the should pay lists are loaded in one batch.
+type batchOnlyTripDB struct {
+	db.TripDBWrapper
+}
+
+func (batchOnlyTripDB) GetRecordAddressList(_ context.Context, id uuid.UUID) ([]db.ExtendAddress, error) {
+	return nil, fmt.Errorf("unexpected per record lookup of %s", id)
+}
+
+func TestSettleTrip_BatchesShouldPayLists(t *testing.T) {
+	tripDB := mem.NewInMemoryTripDBWrapper()
+	tripID := uuid.New()
+	dbtest.CreateTrip(t, tripDB, &db.TripInfo{ID: tripID, Name: "batched trip"}, "Alice", "Bob")
+	require.NoError(t, tripDB.CreateTripRecords(t.Context(), tripID, []db.Record{
+		dbtest.NewGroupRecord("lunch", 30, "Alice", []db.Address{"Alice", "Bob"}, uuid.Nil),
+		dbtest.NewGroupRecord("taxi", 10, "Bob", []db.Address{"Alice", "Bob"}, uuid.Nil),
+	}))
+
+	pkg, _, err := SettlementConfig{}.SettleTrip(t.Context(), batchOnlyTripDB{tripDB}, tripID)
+	require.NoError(t, err)
+	require.Len(t, pkg.TxList, 1)
+	assert.Equal(t, "Alice", pkg.TxList[0].Output.Address)
+	assert.InDelta(t, 10, pkg.TxList[0].Output.Amount, 1e-9)
+}
@@ -0,0 +1,158 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AddressMapping maps real addresses to pseudonyms, e.g. "Alice" -> "Person A".
+type AddressMapping map[string]string
+
+// Inverse returns the mapping from pseudonyms back to addresses, anonymizing with it restores the original.
+func (m AddressMapping) Inverse() AddressMapping {
+	inverse := make(AddressMapping, len(m))
+	for address, pseudonym := range m {
+		inverse[pseudonym] = address
+	}
+	return inverse
+}
+
+// extend gives every address which is not in the mapping yet the next unused pseudonym,
+// new addresses are sorted first so the same input always gets the same pseudonyms.
+func (m AddressMapping) extend(addresses []string) {
+	var missing []string
+	for _, address := range addresses {
+		if _, ok := m[address]; !ok {
+			m[address] = "" // reserve, also drops duplicates
+			missing = append(missing, address)
+		}
+	}
+	sort.Strings(missing)
+
+	used := make(map[string]bool, len(m))
+	for _, pseudonym := range m {
+		used[pseudonym] = true
+	}
+	next := 0
+	for _, address := range missing {
+		for used[pseudonymOf(next)] {
+			next++
+		}
+		m[address] = pseudonymOf(next)
+		used[m[address]] = true
+	}
+}
+
+// pseudonymOf returns the n-th pseudonym, Person A ... Person Z, Person AA, Person AB ...
+func pseudonymOf(n int) string {
+	var letters []byte
+	for n++; n > 0; n = (n - 1) / 26 {
+		letters = append([]byte{byte('A' + (n-1)%26)}, letters...)
+	}
+	return "Person " + string(letters)
+}
+
+// Anonymize returns a copy of the package with every address replaced by its pseudonym in mapping,
+// addresses missing from mapping get a generated one. It returns the completed mapping, mapping itself
+// is not modified and may be nil. Every export of the copy, e.g. String, shows no real address.
+func (tp *Package) Anonymize(mapping AddressMapping) (Package, AddressMapping) {
+	var addresses []string
+	for _, t := range tp.TxList {
+		addresses = append(addresses, t.Output.Address)
+		for _, input := range t.Input {
+			addresses = append(addresses, input.Address)
+		}
+		// inputs dropped after settlement still show in the name
+		if inputs, _, ok := parseMixMapTxName(t.Name, t.Output.Address); ok {
+			addresses = append(addresses, inputs...)
+		}
+	}
+	completed := copyMapping(mapping)
+	completed.extend(addresses)
+
+	anonymized := Package{Name: tp.Name, TxList: make([]Tx, len(tp.TxList))}
+	for i, t := range tp.TxList {
+		inputs := make([]Payment, len(t.Input))
+		for j, input := range t.Input {
+			inputs[j] = Payment{Amount: input.Amount, Address: completed[input.Address], Currency: input.Currency}
+		}
+		name := t.Name
+		if nameInputs, suffix, ok := parseMixMapTxName(name, t.Output.Address); ok {
+			for j, address := range nameInputs {
+				nameInputs[j] = completed[address]
+			}
+			name = "Tx_M_" + strings.Join(nameInputs, "+") + "_to_" + completed[t.Output.Address] + suffix
+		} else if prefix, ok := strings.CutSuffix(name, t.Output.Address); ok {
+			// other generated names end with the output address, e.g. Tx_T_to_Alice
+			name = prefix + completed[t.Output.Address]
+		}
+		anonymized.TxList[i] = Tx{
+			Input:  inputs,
+			Output: Payment{Amount: t.Output.Amount, Address: completed[t.Output.Address], Currency: t.Output.Currency},
+			Name:   name,
+		}
+	}
+	return anonymized, completed
+}
+
+// parseMixMapTxName splits a name generated by mixMapTxName into its input addresses and the index suffix
+// after the output address, e.g. "_2" or "". It reports false for a name of another form.
+func parseMixMapTxName(name, output string) ([]string, string, bool) {
+	rest, ok := strings.CutPrefix(name, "Tx_M_")
+	if !ok {
+		return nil, "", false
+	}
+	i := strings.LastIndex(rest, "_to_"+output)
+	if i <= 0 {
+		return nil, "", false
+	}
+	suffix := rest[i+len("_to_"+output):]
+	if suffix != "" {
+		index, ok := strings.CutPrefix(suffix, "_")
+		if !ok {
+			return nil, "", false
+		}
+		if _, err := strconv.Atoi(index); err != nil {
+			return nil, "", false
+		}
+	}
+	return strings.Split(rest[:i], "+"), suffix, true
+}
+
+// AnonymizeRecords works like Package.Anonymize for the prepay address and split overrides of records,
+// pass the mapping returned by one to the other so both use the same pseudonyms.
+func AnonymizeRecords(records []db.RecordInfo, mapping AddressMapping) ([]db.RecordInfo, AddressMapping) {
+	var addresses []string
+	for _, record := range records {
+		addresses = append(addresses, string(record.PrePayAddress))
+		for address := range record.SplitOverrides {
+			addresses = append(addresses, string(address))
+		}
+	}
+	completed := copyMapping(mapping)
+	completed.extend(addresses)
+
+	anonymized := make([]db.RecordInfo, len(records))
+	for i, record := range records {
+		record.PrePayAddress = db.Address(completed[string(record.PrePayAddress)])
+		if record.SplitOverrides != nil {
+			overrides := make(map[db.Address]float64, len(record.SplitOverrides))
+			for address, value := range record.SplitOverrides {
+				overrides[db.Address(completed[string(address)])] = value
+			}
+			record.SplitOverrides = overrides
+		}
+		anonymized[i] = record
+	}
+	return anonymized, completed
+}
+
+func copyMapping(mapping AddressMapping) AddressMapping {
+	completed := make(AddressMapping, len(mapping))
+	for address, pseudonym := range mapping {
+		completed[address] = pseudonym
+	}
+	return completed
+}
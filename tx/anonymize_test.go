@@ -0,0 +1,83 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackage_Anonymize(t *testing.T) {
+	pkg := Package{Name: "activity", TxList: []Tx{
+		{Name: "Tx_M_Carol_to_Alice", Input: []Payment{{Amount: 40, Address: "Carol"}}, Output: Payment{Amount: 40, Address: "Alice"}},
+		{Name: "Tx_M_Carol_to_Bob_2", Input: []Payment{{Amount: 10, Address: "Carol"}}, Output: Payment{Amount: 10, Address: "Bob"}},
+	}}
+	records := []db.RecordInfo{
+		{Name: "hotel", Amount: 90, PrePayAddress: "Alice", SplitOverrides: map[db.Address]float64{"Dave": 2}},
+		{Name: "dinner", Amount: 60, PrePayAddress: "Bob"},
+	}
+
+	anonymized, mapping := pkg.Anonymize(nil)
+	anonymizedRecords, mapping := AnonymizeRecords(records, mapping)
+
+	expectedMapping := AddressMapping{"Alice": "Person A", "Bob": "Person B", "Carol": "Person C", "Dave": "Person D"}
+	if !reflect.DeepEqual(mapping, expectedMapping) {
+		t.Errorf("mapping = %v, want %v", mapping, expectedMapping)
+	}
+
+	exports := []string{anonymized.String(), ExportMarkdown("trip", anonymizedRecords, anonymized)}
+	for _, export := range exports {
+		for _, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+			if strings.Contains(export, name) {
+				t.Errorf("anonymized export contains %s:\n%s", name, export)
+			}
+		}
+	}
+	if !strings.Contains(exports[0], "Tx: Tx_M_Person C_to_Person A") || !strings.Contains(exports[1], "- Person C pays Person A 40.00") {
+		t.Errorf("unexpected anonymized exports:\n%s\n%s", exports[0], exports[1])
+	}
+	if _, ok := anonymizedRecords[0].SplitOverrides["Person D"]; !ok {
+		t.Errorf("split overrides are not anonymized: %v", anonymizedRecords[0].SplitOverrides)
+	}
+
+	t.Run("Mapping round-trips", func(t *testing.T) {
+		restored, _ := anonymized.Anonymize(mapping.Inverse())
+		if !reflect.DeepEqual(restored, pkg) {
+			t.Errorf("restored package = %v, want %v", restored, pkg)
+		}
+		restoredRecords, _ := AnonymizeRecords(anonymizedRecords, mapping.Inverse())
+		if !reflect.DeepEqual(restoredRecords, records) {
+			t.Errorf("restored records = %v, want %v", restoredRecords, records)
+		}
+	})
+
+	t.Run("Provided mapping is kept and extended", func(t *testing.T) {
+		provided := AddressMapping{"Carol": "Person A"}
+		_, completed := pkg.Anonymize(provided)
+		expected := AddressMapping{"Carol": "Person A", "Alice": "Person B", "Bob": "Person C"}
+		if !reflect.DeepEqual(completed, expected) {
+			t.Errorf("mapping = %v, want %v", completed, expected)
+		}
+		if len(provided) != 1 {
+			t.Errorf("provided mapping must not be modified, got %v", provided)
+		}
+	})
+
+	t.Run("Inputs dropped from a tx are anonymized in its name", func(t *testing.T) {
+		dropped := Package{TxList: []Tx{
+			{Name: "Tx_M_Carol+Erin_to_Alice", Input: []Payment{{Amount: 40, Address: "Carol"}}, Output: Payment{Amount: 40, Address: "Alice"}},
+		}}
+		anonymized, _ := dropped.Anonymize(nil)
+		if got, want := anonymized.TxList[0].Name, "Tx_M_Person B+Person C_to_Person A"; got != want {
+			t.Errorf("name = %s, want %s", got, want)
+		}
+	})
+}
+
+func TestPseudonymOf(t *testing.T) {
+	for n, want := range map[int]string{0: "Person A", 25: "Person Z", 26: "Person AA", 27: "Person AB", 701: "Person ZZ", 702: "Person AAA"} {
+		if got := pseudonymOf(n); got != want {
+			t.Errorf("pseudonymOf(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
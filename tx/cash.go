@@ -2,100 +2,89 @@ package tx
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
-	"math"
-	"sort"
+	"slices"
+	"strings"
 )
 
-// NormalizeCash aggregates the cash movements for each address.
-// It combines multiple entries for the same address into a single entry,
-// let cash will only have input or output amounts, not both.
-func NormalizeCash(cashList []Cash) []Cash {
-	// Create a map to aggregate amounts by address
-	addressMap := make(map[string]*Cash)
+// ErrMixedCurrency is returned when one settlement is asked for cash of several currencies,
+// amounts of different currencies can not be netted without an exchange rate.
+var ErrMixedCurrency = errors.New("cash list mixes currencies")
 
-	for _, cash := range cashList {
-		if entry, exists := addressMap[cash.Address]; exists {
-			entry.InputAmount += cash.InputAmount
-			entry.OutputAmount += cash.OutputAmount
-		} else {
-			addressMap[cash.Address] = &Cash{
-				Address:      cash.Address,
-				InputAmount:  cash.InputAmount,
-				OutputAmount: cash.OutputAmount,
-			}
-		}
-	}
-
-	// merge input and output amounts
-	result := make([]Cash, 0, len(addressMap))
-	for _, entry := range addressMap {
-		if entry.OutputAmount > entry.InputAmount {
-			entry.OutputAmount -= entry.InputAmount
-			entry.InputAmount = 0.0
-		} else {
-			entry.InputAmount -= entry.OutputAmount
-			entry.OutputAmount = 0.0
-		}
-		if entry.InputAmount < epsilon {
-			entry.InputAmount = 0
-		}
-		if entry.OutputAmount < epsilon {
-			entry.OutputAmount = 0
-		}
+// ErrInputsExceedOutputs is returned when inputs are left after every output is covered, the records of the trip
+// do not balance, or rounding left a residual. Amount is the total left, Address has the largest leftover.
+type ErrInputsExceedOutputs struct {
+	Address string
+	Amount  float64
+}
 
-		result = append(result, *entry)
-	}
+func (e *ErrInputsExceedOutputs) Error() string {
+	return fmt.Sprintf("there are remaining unspent inputs totaling %.2f", e.Amount)
+}
 
-	return result
+// ErrOutputsExceedInputs is returned when the remaining inputs can not cover the output of Address,
+// someone is owed more than the others pay. Amount is the uncovered part of Output.
+type ErrOutputsExceedInputs struct {
+	Address string
+	Amount  float64
+	Output  float64
 }
 
-// generateQueues put cash into 2 sorted queues, split by input and output
-func generateQueues(cashList []Cash) (*list.List, *list.List) {
-	// Use Go's `container/list` as a double-ended queue (deque)
-	// We'll populate temporary slices first, then sort, then push to queues.
-	var tempInputSlice []Cash
-	var tempOutputSlice []Cash
+func (e *ErrOutputsExceedInputs) Error() string {
+	return fmt.Sprintf("unexpected condition: collected inputs sum %.2f is less than output %.2f for %s",
+		e.Output-e.Amount, e.Output, e.Address)
+}
 
-	// Pre-process cashList to populate temporary slices
-	for _, cash := range cashList {
-		if cash.InputAmount > epsilon && cash.InputAmount > cash.OutputAmount { // Only push if there's actual input
-			tempInputSlice = append(tempInputSlice, cash)
-		} else if cash.OutputAmount > epsilon && cash.OutputAmount > cash.InputAmount { // Only push if there's actual output
-			tempOutputSlice = append(tempOutputSlice, cash)
-		}
-		// If both are zero or negative, or one is positive and other negative, it's ignored for this process
+// newErrInputsExceedOutputs reports the remaining inputs of settling cashList into txList,
+// the address is the one whose input is covered by txList the least.
+func newErrInputsExceedOutputs(cashList []Cash, txList []Tx, remaining float64) *ErrInputsExceedOutputs {
+	left := make(map[string]float64)
+	for _, cash := range NormalizeCash(cashList) {
+		left[cash.Address] += cash.InputAmount
 	}
-
-	// sort the input slice by InputAmount, descending, and by address for stable sorting
-	sort.SliceStable(tempInputSlice, func(i, j int) bool {
-		// Sort by address to ensure stable sorting for same InputAmount
-		if tempInputSlice[i].InputAmount == tempInputSlice[j].InputAmount {
-			return tempInputSlice[i].Address < tempInputSlice[j].Address // Ascending order by address
+	for _, tx := range txList {
+		for _, input := range tx.Input {
+			left[input.Address] -= input.Amount
 		}
-		return tempInputSlice[i].InputAmount > tempInputSlice[j].InputAmount // Descending order by InputAmount
-	})
-	// Sort the output slice by OutputAmount, descending, and by address for stable sorting
-	sort.SliceStable(tempOutputSlice, func(i, j int) bool {
-		// Sort by address to ensure stable sorting for same OutputAmount
-		if tempOutputSlice[i].OutputAmount == tempOutputSlice[j].OutputAmount {
-			return tempOutputSlice[i].Address < tempOutputSlice[j].Address // Ascending order by address
+	}
+	err := &ErrInputsExceedOutputs{Amount: remaining}
+	for address, amount := range left {
+		if amount > left[err.Address] || (amount == left[err.Address] && address < err.Address) {
+			err.Address = address
 		}
-		return tempOutputSlice[i].OutputAmount > tempOutputSlice[j].OutputAmount // Descending order by OutputAmount
-	})
-
-	// Repopulate actual queues from sorted slices
-	inputQueue := list.New()
-	for _, cash := range tempInputSlice {
-		inputQueue.PushBack(cash)
 	}
+	return err
+}
 
-	outputQueue := list.New()
-	for _, cash := range tempOutputSlice {
-		outputQueue.PushBack(cash)
-	}
+// cashKey identifies the balance of an address in one currency.
+type cashKey struct {
+	Address  string
+	Currency string
+}
 
-	return inputQueue, outputQueue
+// NormalizeCash aggregates the cash movements for each address and currency.
+// It combines multiple entries for the same address and currency into a single entry,
+// let cash will only have input or output amounts, not both.
+func NormalizeCash(cashList []Cash) []Cash {
+	return defaultSplitter.NormalizeCash(cashList)
+}
+
+// generateQueues put cash into 2 sorted queues, split by input and output,
+// cash of higher priority is queued first
+func generateQueues(cashList []Cash, priority AddressPriority) (*list.List, *list.List) {
+	return defaultSplitter.generateQueues(cashList, priority)
+}
+
+// pushByPriority queues cash behind the last cash of the same or higher priority, without priority it is PushBack.
+func pushByPriority(queue *list.List, cash Cash, priority AddressPriority) {
+	for e := queue.Back(); e != nil; e = e.Prev() {
+		if priority[e.Value.(Cash).Address] >= priority[cash.Address] {
+			queue.InsertAfter(cash, e)
+			return
+		}
+	}
+	queue.PushFront(cash)
 }
 
 // PrintCash prints the cash movements for each address in a human-readable format.
@@ -115,130 +104,107 @@ func PrintCash(cashList []Cash) {
 	}
 }
 
+// ListTxGenerateWithMixMap settles the balances by matching inputs to outputs in order. Only debtors pay,
+// so no settlement of the same balances has fewer distinct payers.
 func ListTxGenerateWithMixMap(txList *[]Tx, cashList *[]Cash) (float64, error) {
-	var totalRemainingInputAmount float64 = 0.0
-	var inputQueue, outputQueue *list.List = generateQueues(*cashList)
+	return defaultSplitter.ListTxGenerateWithMixMap(txList, cashList)
+}
 
-	// Process transactions until all outputs are covered or inputs are exhausted
+// TxListGenerateMinCount is a ListGenerateStrategy which settles with the fewest transfers, addresses are split
+// into the most groups whose balances net to zero and each group settles on its own. The search is capped by the
+// default OptimizeLimit, a larger cash list falls back to ListTxGenerateWithMixMap. Balances which do not net
+// to zero end up in one group, its leftover is returned as remaining input like ListTxGenerateWithMixMap.
+func TxListGenerateMinCount(txList *[]Tx, cashList *[]Cash) (float64, error) {
+	return ListTxGenerateOptimized(OptimizeLimit{})(txList, cashList)
+}
 
-	for outputQueue.Len() > 0 {
-		currentOutputElem := outputQueue.Front()
-		if currentOutputElem == nil {
-			break
-		}
-		outputQueue.Remove(currentOutputElem)
-		currentOutputCash := currentOutputElem.Value.(Cash) // Type assertion
+// ListTxGenerateWithPriority returns a strategy working like ListTxGenerateWithMixMap, but outputs and inputs
+// of higher priority addresses are matched first, so their positions are settled before others even if
+// it takes more transfers.
+func ListTxGenerateWithPriority(priority AddressPriority) ListGenerateStrategy {
+	return defaultSplitter.ListTxGenerateWithPriority(priority)
+}
 
-		// If for some reason output becomes zero or less (shouldn't happen with pre-processing), skip
-		if currentOutputCash.OutputAmount <= epsilon {
-			fmt.Printf("Warning: Output for %s is zero or negative, skipping.\n", currentOutputCash.Address)
-			continue // Skip this output
-		}
+// SettleStrategies lists every ListGenerateStrategy of the package, a new strategy is added here so tools
+// comparing them, e.g. the bench command, pick it up. The priority strategy settles the addresses of priority first.
+func SettleStrategies(priority AddressPriority) []SettleStrategy {
+	return []SettleStrategy{
+		{Name: "mix-map", Strategy: ListTxGenerateWithMixMap},
+		{Name: "min-count", Strategy: TxListGenerateMinCount},
+		{Name: "tree", Strategy: ListTxGenerateTree},
+		{Name: "optimized", Strategy: ListTxGenerateOptimized(OptimizeLimit{})},
+		{Name: "priority", Strategy: ListTxGenerateWithPriority(priority)},
+	}
+}
 
-		// Collect inputs to cover the current output
-		var collectedInputs []Payment
-		var currentInputSum float64 = 0.0
-
-		for inputQueue.Len() > 0 && currentInputSum < currentOutputCash.OutputAmount {
-			currentInputElem := inputQueue.Front()
-			inputQueue.Remove(currentInputElem)
-			if currentInputElem == nil {
-				break
-			}
-			currentInputCash := currentInputElem.Value.(Cash) // Type assertion
-
-			// This is an 'input' for the transaction, so it's an 'output' from the address's perspective
-			collectedInputs = append(collectedInputs, Payment{
-				Amount:  currentInputCash.InputAmount,
-				Address: currentInputCash.Address,
-			})
-			currentInputSum += currentInputCash.InputAmount
-		}
+// ListTxGeneratePartial works like ListTxGenerateWithMixMap, but an output which can not be covered by
+// the remaining inputs does not fail the generation. The output takes what is left of the inputs and
+// the uncovered part is returned as a Payment of the output address, in the order outputs are processed.
+func ListTxGeneratePartial(txList *[]Tx, cashList *[]Cash) ([]Payment, float64, error) {
+	return defaultSplitter.ListTxGeneratePartial(txList, cashList)
+}
 
-		// We have enough or more inputs to cover currentOutputCash.OutputAmount
-		txOutputPayment := Payment{
-			Amount:  currentOutputCash.OutputAmount,
-			Address: currentOutputCash.Address,
-		}
+// ListTxGeneratePartialWithPriority works like ListTxGeneratePartial with the matching order of ListTxGenerateWithPriority,
+// when inputs are limited the outputs of higher priority are covered in full before the others.
+func ListTxGeneratePartialWithPriority(txList *[]Tx, cashList *[]Cash, priority AddressPriority) ([]Payment, float64, error) {
+	return defaultSplitter.listTxGenerateWithMixMap(txList, cashList, priority, true)
+}
 
-		// Handle the case where collected inputs are exactly equal to output or greater
-		if math.Abs(currentInputSum-currentOutputCash.OutputAmount) < epsilon {
-			// Inputs sum equals output. Use all collected inputs.
-			*txList = append(*txList, Tx{
-				Name:   fmt.Sprintf("Tx_M_to_%s", currentOutputCash.Address), // Simple naming
-				Input:  collectedInputs,
-				Output: txOutputPayment,
-			})
-		} else if currentInputSum < currentOutputCash.OutputAmount {
-			// when input can not cover output
-			// This condition should not happen due to pre-processing, but let's handle it gracefully
-			return totalRemainingInputAmount, fmt.Errorf("unexpected condition: collected inputs sum %.2f is less than output %.2f for %s",
-				currentInputSum, currentOutputCash.OutputAmount, currentOutputCash.Address)
-		} else { // currentInputSum > currentOutputCash.OutputAmount
-			// Inputs sum is greater than output. We need to split the last input.
-			lastInputPayment := collectedInputs[len(collectedInputs)-1]
-			collectedInputs = collectedInputs[:len(collectedInputs)-1] // Remove the last one
-
-			// Amount needed from the last input to exactly cover the output
-			amountNeededFromLastInput := currentOutputCash.OutputAmount - (currentInputSum - lastInputPayment.Amount)
-
-			// The part of the last input that goes to the output
-			inputPartForTx := Payment{
-				Amount:  amountNeededFromLastInput,
-				Address: lastInputPayment.Address,
-			}
-			collectedInputs = append(collectedInputs, inputPartForTx)
-
-			// The remaining part of the last input goes back to the input queue
-			remainingAmount := lastInputPayment.Amount - amountNeededFromLastInput
-			if remainingAmount > epsilon { // Only push back if there's a significant remainder
-				inputQueue.PushBack(Cash{
-					Address:      lastInputPayment.Address,
-					InputAmount:  remainingAmount, // This cash represents an available input
-					OutputAmount: 0.0,
-				})
-			}
-
-			// Create the transaction
-			*txList = append(*txList, Tx{
-				Name:   fmt.Sprintf("Tx_M_to_%s", currentOutputCash.Address), // Simple naming
-				Input:  collectedInputs,
-				Output: txOutputPayment,
-			})
+// mixMapTxName names a tx generated by ListTxGenerateWithMixMap after its input and output addresses,
+// e.g. Tx_M_Alice+Charlie_to_Bob. A name already in usedNames gets the next free index appended,
+// e.g. Tx_M_Alice_to_Bob_2, so every tx of a list has a distinct name which is stable for the same cash list.
+func mixMapTxName(usedNames map[string]bool, inputs []Payment, output string) string {
+	addresses := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		if !slices.Contains(addresses, input.Address) {
+			addresses = append(addresses, input.Address)
 		}
 	}
-
-	// Any remaining inputs in the input queue are considered "unspent" or "leftover"
-
-	for inputQueue.Len() > 0 {
-		inputElem := inputQueue.Front()
-		if inputElem == nil {
-			break
-		}
-		inputQueue.Remove(inputElem)
-		inputCash := inputElem.Value.(Cash)
-		totalRemainingInputAmount += inputCash.InputAmount
+	name := "Tx_M_" + strings.Join(addresses, "+") + "_to_" + output
+	for i := 2; usedNames[name]; i++ {
+		name = fmt.Sprintf("Tx_M_%s_to_%s_%d", strings.Join(addresses, "+"), output, i)
 	}
-
-	return totalRemainingInputAmount, nil
+	usedNames[name] = true
+	return name
 }
 
 // CashListToTxPackage converts a slice of Cash objects into a TxPackage,
 // forming transactions based on the specified queue algorithm.
 // It returns the generated TxPackage and the total remaining input amount.
 func CashListToTxPackage(cashList []Cash, packageName string, strategy ListGenerateStrategy) (Package, float64, error) {
-	var generatedTxList []Tx
-	totalRemainingInputAmount, err := strategy(&generatedTxList, &cashList)
-	if err != nil {
-		return Package{}, 0, err
+	return defaultSplitter.CashListToTxPackage(cashList, packageName, strategy)
+}
+
+// CashListToPartialTxPackage converts a slice of Cash objects into a TxPackage like CashListToTxPackage,
+// outputs which can not be covered are settled as far as possible and returned as uncovered payments.
+func CashListToPartialTxPackage(cashList []Cash, packageName string) (Package, []Payment, float64, error) {
+	return defaultSplitter.CashListToPartialTxPackage(cashList, packageName)
+}
+
+// SplitCashByCurrency groups the cash list by currency, keeping the order of the cash in each group.
+func SplitCashByCurrency(cashList []Cash) map[string][]Cash {
+	groups := make(map[string][]Cash)
+	for _, cash := range cashList {
+		groups[cash.Currency] = append(groups[cash.Currency], cash)
 	}
-	if totalRemainingInputAmount > epsilon {
-		fmt.Printf("Warning: There are remaining unspent inputs totaling %.2f\n", totalRemainingInputAmount)
-		return Package{}, totalRemainingInputAmount, fmt.Errorf("there are remaining unspent inputs totaling %.2f", totalRemainingInputAmount)
+	return groups
+}
+
+// CashListToTxPackagesByCurrency settles each currency on its own like CashListToTxPackage,
+// the packages are keyed by currency and never transfer between currencies.
+func CashListToTxPackagesByCurrency(cashList []Cash, packageName string, strategy ListGenerateStrategy) (map[string]Package, error) {
+	packages := make(map[string]Package)
+	for currency, group := range SplitCashByCurrency(cashList) {
+		txPackage, _, err := CashListToTxPackage(group, packageName, strategy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to settle currency %q: %w", currency, err)
+		}
+		packages[currency] = txPackage
 	}
+	return packages, nil
+}
 
-	return Package{
-		Name:   packageName,
-		TxList: generatedTxList,
-	}, totalRemainingInputAmount, nil
+// checkSingleCurrency returns ErrMixedCurrency when cash with a balance has more than one currency.
+func checkSingleCurrency(cashList []Cash) error {
+	return defaultSplitter.checkSingleCurrency(cashList)
 }
@@ -0,0 +1,147 @@
+package tx
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomBalancedCashList builds a cash list whose inputs and outputs sum to the same total,
+// amounts are cents divided by divisor (like an average split) and some of them repeat to hit equal amount orderings.
+func randomBalancedCashList(seed int64, size int, divisor int) []Cash {
+	r := rand.New(rand.NewSource(seed))
+	cashList := make([]Cash, 0, size+1)
+	var balance float64 // positive means inputs exceed outputs
+	var lastAmount int64 = 1
+	for i := 0; i < size; i++ {
+		amount := lastAmount
+		if r.Intn(3) > 0 { // keep some equal amounts
+			amount = 1 + r.Int63n(100000)
+		}
+		lastAmount = amount
+		value := float64(amount) / 100 / float64(divisor)
+		cash := Cash{Address: string(rune('A'+i%26)) + string(rune('a'+i/26))}
+		if r.Intn(2) == 0 {
+			cash.InputAmount = value
+			balance += value
+		} else {
+			cash.OutputAmount = value
+			balance -= value
+		}
+		cashList = append(cashList, cash)
+	}
+	last := Cash{Address: "Balancer"}
+	if balance > 0 {
+		last.OutputAmount = balance
+	} else {
+		last.InputAmount = -balance
+	}
+	cashList = append(cashList, last)
+	r.Shuffle(len(cashList), func(i, j int) { cashList[i], cashList[j] = cashList[j], cashList[i] })
+	return cashList
+}
+
+func FuzzSettle(f *testing.F) {
+	f.Add(int64(1), uint8(2), uint8(1))
+	f.Add(int64(42), uint8(10), uint8(3))
+	f.Add(int64(976), uint8(30), uint8(7))
+	f.Add(int64(-7), uint8(200), uint8(1))
+
+	f.Fuzz(func(t *testing.T, seed int64, size uint8, divisor uint8) {
+		cashList := NormalizeCash(randomBalancedCashList(seed, int(size), int(divisor%9)+1))
+		credit := map[string]float64{}
+		debit := map[string]float64{}
+		for _, cash := range cashList {
+			credit[cash.Address] = cash.OutputAmount
+			debit[cash.Address] = cash.InputAmount
+		}
+
+		var txList []Tx
+		remaining, err := ListTxGenerateWithMixMap(&txList, &cashList)
+		if err != nil {
+			t.Fatalf("balanced input must settle, got error: %v", err)
+		}
+		if math.Abs(remaining) > 1e-6 {
+			t.Fatalf("balanced input must not leave remaining inputs, got %v", remaining)
+		}
+
+		received := map[string]float64{}
+		paid := map[string]float64{}
+		for _, tx := range txList {
+			inputSum, outputSum := tx.Validate()
+			if math.Abs(inputSum-outputSum) > 1e-6 {
+				t.Fatalf("tx %s is not balanced: inputs %v, output %v", tx.Name, inputSum, outputSum)
+			}
+			received[tx.Output.Address] += tx.Output.Amount
+			for _, input := range tx.Input {
+				if input.Amount < 0 {
+					t.Fatalf("tx %s has negative input %v", tx.Name, input)
+				}
+				paid[input.Address] += input.Amount
+			}
+		}
+		for addr, amount := range credit {
+			if math.Abs(received[addr]-amount) > 1e-6 {
+				t.Errorf("creditor %s received %v, want %v", addr, received[addr], amount)
+			}
+			if math.Abs(paid[addr]-debit[addr]) > 1e-6 {
+				t.Errorf("debtor %s paid %v, want %v", addr, paid[addr], debit[addr])
+			}
+		}
+	})
+}
+
+// randomCashList builds an arbitrary cash list, addresses repeat, the scale of amounts spans from far below epsilon
+// to large values and some amounts are negative like malformed data.
+func randomCashList(seed int64, size int) []Cash {
+	r := rand.New(rand.NewSource(seed))
+	cashList := make([]Cash, size)
+	scale := math.Pow(10, float64(r.Intn(20)-12))
+	amount := func() float64 {
+		value := r.Float64() * scale
+		if r.Intn(5) == 0 {
+			value = -value
+		}
+		if r.Intn(4) == 0 {
+			value = 0
+		}
+		return value
+	}
+	for i := range cashList {
+		cashList[i] = Cash{Address: string(rune('A' + r.Intn(8))), InputAmount: amount(), OutputAmount: amount()}
+	}
+	return cashList
+}
+
+func FuzzNormalizeCash(f *testing.F) {
+	f.Add(int64(1), uint8(3)) // amounts below epsilon
+	f.Add(int64(7), uint8(20))
+	f.Add(int64(-99), uint8(64))
+	f.Add(int64(2024), uint8(255))
+
+	f.Fuzz(func(t *testing.T, seed int64, size uint8) {
+		cashList := randomCashList(seed, int(size))
+		var rawNet, gross float64
+		for _, cash := range cashList {
+			rawNet += cash.InputAmount - cash.OutputAmount
+			gross += math.Abs(cash.InputAmount) + math.Abs(cash.OutputAmount)
+		}
+
+		var inputs, outputs float64
+		normalized := NormalizeCash(cashList)
+		for _, cash := range normalized {
+			if cash.InputAmount < 0 || cash.OutputAmount < 0 {
+				t.Fatalf("normalized cash of %s is negative: %+v", cash.Address, cash)
+			}
+			if cash.InputAmount > 0 && cash.OutputAmount > 0 {
+				t.Fatalf("normalized cash of %s has both input and output: %+v", cash.Address, cash)
+			}
+			inputs += cash.InputAmount
+			outputs += cash.OutputAmount
+		}
+		// money is conserved, only float noise and balances within epsilon of zero may get lost
+		if diff := math.Abs((inputs - outputs) - rawNet); diff > epsilon*gross+epsilon*float64(len(normalized)) {
+			t.Fatalf("net inputs %v - outputs %v = %v, want %v (diff %v)", inputs, outputs, inputs-outputs, rawNet, diff)
+		}
+	})
+}
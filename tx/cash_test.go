@@ -2,9 +2,14 @@ package tx
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -108,6 +113,41 @@ func TestNormalizeCash(t *testing.T) {
 				{Address: "B", InputAmount: 10, OutputAmount: 0},
 			},
 		},
+		{
+			name: "Small balances are kept",
+			cashList: []Cash{
+				{Address: "A", InputAmount: 6e-10},
+				{Address: "B", InputAmount: 6e-10},
+				{Address: "C", OutputAmount: 1.2e-9},
+			},
+			expected: []Cash{
+				{Address: "A", InputAmount: 6e-10},
+				{Address: "B", InputAmount: 6e-10},
+				{Address: "C", OutputAmount: 1.2e-9},
+			},
+		},
+		{
+			name: "Float noise of cancelling amounts is dropped",
+			cashList: []Cash{
+				{Address: "A", InputAmount: 0.1},
+				{Address: "A", InputAmount: 0.2},
+				{Address: "A", OutputAmount: 0.3},
+			},
+			expected: []Cash{
+				{Address: "A", InputAmount: 0, OutputAmount: 0},
+			},
+		},
+		{
+			name: "Negative amounts move to the other side",
+			cashList: []Cash{
+				{Address: "A", InputAmount: -5},
+				{Address: "B", InputAmount: 3, OutputAmount: -2},
+			},
+			expected: []Cash{
+				{Address: "A", InputAmount: 0, OutputAmount: 5},
+				{Address: "B", InputAmount: 5, OutputAmount: 0},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -202,7 +242,7 @@ func TestGenerateQueues(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			inputQueue, outputQueue := generateQueues(tt.cashList)
+			inputQueue, outputQueue := generateQueues(tt.cashList, nil)
 
 			gotInputs := listToCashSlice(inputQueue)
 			gotOutputs := listToCashSlice(outputQueue)
@@ -234,7 +274,7 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 			},
 			expectedTxList: []Tx{
 				{
-					Name:   "Tx_M_to_Bob",
+					Name:   "Tx_M_Alice_to_Bob",
 					Input:  []Payment{{Amount: 100, Address: "Alice"}},
 					Output: Payment{Amount: 100, Address: "Bob"},
 				},
@@ -250,7 +290,7 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 			},
 			expectedTxList: []Tx{
 				{
-					Name:   "Tx_M_to_Bob",
+					Name:   "Tx_M_Alice_to_Bob",
 					Input:  []Payment{{Amount: 70, Address: "Alice"}},
 					Output: Payment{Amount: 70, Address: "Bob"},
 				},
@@ -268,7 +308,7 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 			},
 			expectedTxList: []Tx{
 				{
-					Name: "Tx_M_to_Bob", // Alice (60), Charlie (40) -> Bob (100)
+					Name: "Tx_M_Alice+Charlie_to_Bob", // Alice (60), Charlie (40) -> Bob (100)
 					Input: []Payment{
 						{Amount: 60, Address: "Alice"},
 						{Amount: 40, Address: "Charlie"},
@@ -288,7 +328,7 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 			},
 			expectedTxList: []Tx{
 				{
-					Name: "Tx_M_to_Bob", // Note: The name 'Tx_Alice_to_Bob_split' uses the first input's address
+					Name: "Tx_M_Charlie+Alice_to_Bob", // inputs are named in the order they are taken
 					Input: []Payment{
 						{Amount: 80, Address: "Charlie"},
 						{Amount: 40, Address: "Alice"}, // 120 - 80 = 40 needed from Alice
@@ -332,14 +372,14 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 			},
 			expectedTxList: []Tx{
 				{ // R2 (120) is largest output
-					Name: "Tx_M_to_R2", // S1 (200) covers R2 (120), S1 has 80 left
+					Name: "Tx_M_S1_to_R2", // S1 (200) covers R2 (120), S1 has 80 left
 					Input: []Payment{
 						{Address: "S1", Amount: 120},
 					},
 					Output: Payment{Address: "R2", Amount: 120},
 				},
 				{ // R1 (70) is next largest output
-					Name: "Tx_M_to_R1", // S1 (80 left) covers R1 (70), S1 has 10 left
+					Name: "Tx_M_S2+S1_to_R1", // S1 (80 left) covers R1 (70), S1 has 10 left
 					Input: []Payment{
 						{Address: "S2", Amount: 50},
 						{Address: "S1", Amount: 20},
@@ -347,7 +387,7 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 					Output: Payment{Address: "R1", Amount: 70},
 				},
 				{ // R3 (30) is smallest output
-					Name: "Tx_M_to_R3", // S1 (10 left) + S2 (50) = 60. R3 (30) covered by S1 (10) + S2 (20). S2 has 30 left.
+					Name: "Tx_M_S1_to_R3", // S1 (10 left) + S2 (50) = 60. R3 (30) covered by S1 (10) + S2 (20). S2 has 30 left.
 					Input: []Payment{
 						{Address: "S1", Amount: 30},
 					},
@@ -431,6 +471,175 @@ func TestListTxGenerateWithMixMap(t *testing.T) {
 	}
 }
 
+func TestListTxGenerateWithMixMap_DistinctNames(t *testing.T) {
+	// cash list which is not normalized, Bob is an output twice and Alice covers both
+	cashList := []Cash{
+		{Address: "Alice", InputAmount: 100},
+		{Address: "Bob", OutputAmount: 60},
+		{Address: "Bob", OutputAmount: 40},
+	}
+	want := []string{"Tx_M_Alice_to_Bob", "Tx_M_Alice_to_Bob_2"}
+	for run := 0; run < 2; run++ {
+		var txList []Tx
+		cashCopy := append([]Cash(nil), cashList...)
+		if _, err := ListTxGenerateWithMixMap(&txList, &cashCopy); err != nil {
+			t.Fatalf("ListTxGenerateWithMixMap() unexpected error: %v", err)
+		}
+		var names []string
+		for _, tx := range txList {
+			names = append(names, tx.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("run %d: names = %v, want %v", run, names, want)
+		}
+	}
+}
+
+func TestListTxGenerateWithMixMap_DistinctPayers(t *testing.T) {
+	// every debtor has to send money, mix map has no creditor pass money on, so it has the fewest payers possible
+	for seed := int64(0); seed < 50; seed++ {
+		cashList := randomBalancedCashList(seed, 1+int(seed%15), 1+int(seed%3))
+		pkg, _, err := CashListToTxPackage(cashList, "mix", ListTxGenerateWithMixMap)
+		if err != nil {
+			t.Fatalf("seed %d: CashListToTxPackage() error = %v", seed, err)
+		}
+		debtors := make(map[string]bool)
+		for _, cash := range NormalizeCash(cashList) {
+			if cash.InputAmount > epsilon {
+				debtors[cash.Address] = true
+			}
+		}
+		payers := make(map[string]bool)
+		for _, tx := range pkg.TxList {
+			for _, input := range tx.Input {
+				if !debtors[input.Address] {
+					t.Errorf("seed %d: %s pays but is no debtor", seed, input.Address)
+				}
+				payers[input.Address] = true
+			}
+		}
+		if len(payers) != len(debtors) {
+			t.Errorf("seed %d: %d payers, want %d debtors", seed, len(payers), len(debtors))
+		}
+	}
+}
+
+func TestListTxGeneratePartial(t *testing.T) {
+	tests := []struct {
+		name                   string
+		initialCashList        []Cash
+		expectedTxList         []Tx
+		expectedUncovered      []Payment
+		expectedRemainingInput float64
+	}{
+		{
+			name: "Output with no inputs available",
+			initialCashList: []Cash{
+				{Address: "Bob", InputAmount: 0, OutputAmount: 100},
+				{Address: "Charlie", InputAmount: 0, OutputAmount: 50},
+			},
+			expectedTxList:    []Tx{},
+			expectedUncovered: []Payment{{Amount: 100, Address: "Bob"}, {Amount: 50, Address: "Charlie"}},
+		},
+		{
+			name: "Inputs cover the largest output only",
+			initialCashList: []Cash{
+				{Address: "Alice", InputAmount: 120, OutputAmount: 0},
+				{Address: "Bob", InputAmount: 0, OutputAmount: 100},
+				{Address: "Charlie", InputAmount: 0, OutputAmount: 50},
+			},
+			expectedTxList: []Tx{
+				{Name: "Tx_M_Alice_to_Bob", Input: []Payment{{Amount: 100, Address: "Alice"}}, Output: Payment{Amount: 100, Address: "Bob"}},
+				{Name: "Tx_M_Alice_to_Charlie", Input: []Payment{{Amount: 20, Address: "Alice"}}, Output: Payment{Amount: 20, Address: "Charlie"}},
+			},
+			expectedUncovered: []Payment{{Amount: 30, Address: "Charlie"}},
+		},
+		{
+			name: "Fully covered outputs report nothing",
+			initialCashList: []Cash{
+				{Address: "Alice", InputAmount: 100, OutputAmount: 0},
+				{Address: "Bob", InputAmount: 0, OutputAmount: 100},
+			},
+			expectedTxList: []Tx{
+				{Name: "Tx_M_Alice_to_Bob", Input: []Payment{{Amount: 100, Address: "Alice"}}, Output: Payment{Amount: 100, Address: "Bob"}},
+			},
+			expectedUncovered: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTxList []Tx
+			cashListCopy := make([]Cash, len(tt.initialCashList))
+			copy(cashListCopy, tt.initialCashList)
+
+			gotUncovered, gotRemainingInput, err := ListTxGeneratePartial(&gotTxList, &cashListCopy)
+			if err != nil {
+				t.Fatalf("ListTxGeneratePartial() unexpected error: %v", err)
+			}
+			if !floatEquals(gotRemainingInput, tt.expectedRemainingInput) {
+				t.Errorf("ListTxGeneratePartial() gotRemainingInput = %v, want %v", gotRemainingInput, tt.expectedRemainingInput)
+			}
+
+			if len(gotTxList) != len(tt.expectedTxList) {
+				t.Fatalf("ListTxGeneratePartial() generated TxList length = %v, want %v", len(gotTxList), len(tt.expectedTxList))
+			}
+			for i := range gotTxList {
+				if gotTxList[i].Name != tt.expectedTxList[i].Name {
+					t.Errorf("ListTxGeneratePartial() Tx[%d] Name got %q, want %q", i, gotTxList[i].Name, tt.expectedTxList[i].Name)
+				}
+				if !floatEquals(gotTxList[i].Output.Amount, tt.expectedTxList[i].Output.Amount) ||
+					gotTxList[i].Output.Address != tt.expectedTxList[i].Output.Address {
+					t.Errorf("ListTxGeneratePartial() Tx[%d] Output got %v, want %v", i, gotTxList[i].Output, tt.expectedTxList[i].Output)
+				}
+				if !gotTxList[i].BoolValidate() {
+					t.Errorf("ListTxGeneratePartial() Tx[%d] is not balanced: %v", i, gotTxList[i])
+				}
+			}
+
+			if len(gotUncovered) != len(tt.expectedUncovered) {
+				t.Fatalf("ListTxGeneratePartial() uncovered = %v, want %v", gotUncovered, tt.expectedUncovered)
+			}
+			for i := range gotUncovered {
+				if gotUncovered[i].Address != tt.expectedUncovered[i].Address ||
+					!floatEquals(gotUncovered[i].Amount, tt.expectedUncovered[i].Amount) {
+					t.Errorf("ListTxGeneratePartial() uncovered[%d] got %v, want %v", i, gotUncovered[i], tt.expectedUncovered[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCashListToPartialTxPackage(t *testing.T) {
+	t.Run("Partial settlement with uncovered creditor", func(t *testing.T) {
+		cashList := []Cash{{Address: "Inputter", InputAmount: 50}, {Address: "Outputter", OutputAmount: 100}}
+		pkg, uncovered, remaining, err := CashListToPartialTxPackage(cashList, "PartialPack")
+		if err != nil {
+			t.Fatalf("CashListToPartialTxPackage() unexpected error: %v", err)
+		}
+		if pkg.Name != "PartialPack" || len(pkg.TxList) != 1 || !floatEquals(pkg.TxList[0].Output.Amount, 50) {
+			t.Errorf("CashListToPartialTxPackage() package got %v", pkg)
+		}
+		if len(uncovered) != 1 || uncovered[0].Address != "Outputter" || !floatEquals(uncovered[0].Amount, 50) {
+			t.Errorf("CashListToPartialTxPackage() uncovered got %v", uncovered)
+		}
+		if !floatEquals(remaining, 0) {
+			t.Errorf("CashListToPartialTxPackage() remaining got %v, want 0", remaining)
+		}
+	})
+
+	t.Run("Remaining input is still an error", func(t *testing.T) {
+		cashList := []Cash{{Address: "Inputter", InputAmount: 100}, {Address: "Outputter", OutputAmount: 50}}
+		_, _, remaining, err := CashListToPartialTxPackage(cashList, "RemainingPack")
+		if err == nil {
+			t.Fatal("CashListToPartialTxPackage() expected error for remaining input")
+		}
+		if !floatEquals(remaining, 50) {
+			t.Errorf("CashListToPartialTxPackage() remaining got %v, want 50", remaining)
+		}
+	})
+}
+
 func TestCashListToTxPackage(t *testing.T) {
 	// A dummy strategy that always returns specific values (success)
 	successfulStrategy := func(txList *[]Tx, cashList *[]Cash) (float64, error) {
@@ -562,3 +771,346 @@ func TestCashListToTxPackage(t *testing.T) {
 		})
 	}
 }
+
+func TestListTxGeneratePartialWithPriority(t *testing.T) {
+	cashList := []Cash{
+		{Address: "Alice", InputAmount: 120},
+		{Address: "Bob", OutputAmount: 100},
+		{Address: "Charlie", OutputAmount: 50}, // leaving early
+	}
+	var txList []Tx
+	uncovered, remaining, err := ListTxGeneratePartialWithPriority(&txList, &cashList, AddressPriority{"Charlie": 1})
+	if err != nil {
+		t.Fatalf("ListTxGeneratePartialWithPriority() error = %v", err)
+	}
+	if len(txList) != 2 || txList[0].Output.Address != "Charlie" || !floatEquals(txList[0].Output.Amount, 50) {
+		t.Fatalf("high priority output should be covered in full first, got %v", txList)
+	}
+	if txList[1].Output.Address != "Bob" || !floatEquals(txList[1].Output.Amount, 70) {
+		t.Errorf("lower priority output should take the rest of the inputs, got %v", txList[1])
+	}
+	if len(uncovered) != 1 || uncovered[0].Address != "Bob" || !floatEquals(uncovered[0].Amount, 30) {
+		t.Errorf("uncovered = %v, want Bob 30", uncovered)
+	}
+	if !floatEquals(remaining, 0) {
+		t.Errorf("remaining = %v, want 0", remaining)
+	}
+}
+
+func TestListTxGenerateWithPriority(t *testing.T) {
+	newCashList := func() []Cash {
+		return []Cash{
+			{Address: "Alice", InputAmount: 120},
+			{Address: "Dave", InputAmount: 30}, // leaving early
+			{Address: "Bob", OutputAmount: 100},
+			{Address: "Charlie", OutputAmount: 50},
+		}
+	}
+	t.Run("High priority input is spent first", func(t *testing.T) {
+		cashList := newCashList()
+		var txList []Tx
+		remaining, err := ListTxGenerateWithPriority(AddressPriority{"Dave": 1})(&txList, &cashList)
+		if err != nil || !floatEquals(remaining, 0) {
+			t.Fatalf("ListTxGenerateWithPriority() remaining = %v, error = %v", remaining, err)
+		}
+		if len(txList) == 0 || txList[0].Input[0] != (Payment{Amount: 30, Address: "Dave"}) {
+			t.Errorf("Dave should pay in the first transfer, got %v", txList)
+		}
+	})
+	t.Run("Without priority the order is unchanged", func(t *testing.T) {
+		cashList, expectedCashList := newCashList(), newCashList()
+		var txList, expectedTxList []Tx
+		if _, err := ListTxGenerateWithPriority(nil)(&txList, &cashList); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ListTxGenerateWithMixMap(&expectedTxList, &expectedCashList); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(txList, expectedTxList) {
+			t.Errorf("got %v, want %v", txList, expectedTxList)
+		}
+	})
+}
+
+func TestTxListGenerateMinCount(t *testing.T) {
+	t.Run("Fewer transfers than mix map", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "A", InputAmount: 10},
+			{Address: "B", InputAmount: 6},
+			{Address: "C", InputAmount: 4},
+			{Address: "D", OutputAmount: 9},
+			{Address: "E", OutputAmount: 7},
+			{Address: "F", OutputAmount: 4},
+		}
+		greedyCashList := append([]Cash(nil), cashList...)
+		var txList, greedyTxList []Tx
+		remaining, err := TxListGenerateMinCount(&txList, &cashList)
+		if err != nil || !floatEquals(remaining, 0) {
+			t.Fatalf("TxListGenerateMinCount() remaining = %v, error = %v", remaining, err)
+		}
+		if _, err := ListTxGenerateWithMixMap(&greedyTxList, &greedyCashList); err != nil {
+			t.Fatal(err)
+		}
+		// C pays F directly, A and B settle D and E with 3 transfers
+		if got, greedy := transferCount(Package{TxList: txList}), transferCount(Package{TxList: greedyTxList}); got != 4 || greedy != 5 {
+			t.Errorf("transfers = %d (mix map %d), want 4 (mix map 5)", got, greedy)
+		}
+		for _, tx := range txList {
+			if err := tx.ValidateDetailed(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("Leftover is returned as remaining input", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "A", InputAmount: 10},
+			{Address: "B", InputAmount: 5},
+			{Address: "C", OutputAmount: 10},
+		}
+		var txList []Tx
+		remaining, err := TxListGenerateMinCount(&txList, &cashList)
+		if err != nil {
+			t.Fatalf("TxListGenerateMinCount() error = %v", err)
+		}
+		if !floatEquals(remaining, 5) || len(txList) != 1 || transferCount(Package{TxList: txList}) != 1 {
+			t.Errorf("remaining = %v, txList = %v, want 5 remaining and A pays C", remaining, txList)
+		}
+	})
+
+	t.Run("Never more transfers than mix map", func(t *testing.T) {
+		for seed := int64(0); seed < 50; seed++ {
+			cashList := NormalizeCash(randomBalancedCashList(seed, 1+int(seed%12), 1+int(seed%3)))
+			greedyCashList := append([]Cash(nil), cashList...)
+			var txList, greedyTxList []Tx
+			if _, err := TxListGenerateMinCount(&txList, &cashList); err != nil {
+				t.Fatalf("seed %d: TxListGenerateMinCount() error = %v", seed, err)
+			}
+			if _, err := ListTxGenerateWithMixMap(&greedyTxList, &greedyCashList); err != nil {
+				t.Fatalf("seed %d: ListTxGenerateWithMixMap() error = %v", seed, err)
+			}
+			if len(txList) > len(greedyTxList) || transferCount(Package{TxList: txList}) > transferCount(Package{TxList: greedyTxList}) {
+				t.Errorf("seed %d: %d txs with %d transfers, mix map %d txs with %d transfers",
+					seed, len(txList), transferCount(Package{TxList: txList}), len(greedyTxList), transferCount(Package{TxList: greedyTxList}))
+			}
+		}
+	})
+
+	t.Run("Drops into CashListToTxPackage", func(t *testing.T) {
+		pkg, remaining, err := CashListToTxPackage([]Cash{{Address: "A", InputAmount: 3}, {Address: "B", OutputAmount: 3}}, "activity", TxListGenerateMinCount)
+		if err != nil || !floatEquals(remaining, 0) || len(pkg.TxList) != 1 {
+			t.Errorf("CashListToTxPackage() = %v, %v, %v", pkg, remaining, err)
+		}
+	})
+}
+
+func TestNormalizeCash_PerCurrency(t *testing.T) {
+	cashList := []Cash{
+		{Address: "Alice", InputAmount: 100, Currency: "TWD"},
+		{Address: "Alice", OutputAmount: 3000, Currency: "JPY"},
+		{Address: "Alice", OutputAmount: 40, Currency: "TWD"},
+		{Address: "Bob", OutputAmount: 60, Currency: "TWD"},
+		{Address: "Bob", InputAmount: 3000, Currency: "JPY"},
+	}
+	got := NormalizeCash(cashList)
+	sort.Slice(got, func(i, j int) bool {
+		if got[i].Currency != got[j].Currency {
+			return got[i].Currency < got[j].Currency
+		}
+		return got[i].Address < got[j].Address
+	})
+	want := []Cash{
+		{Address: "Alice", OutputAmount: 3000, Currency: "JPY"},
+		{Address: "Bob", InputAmount: 3000, Currency: "JPY"},
+		{Address: "Alice", InputAmount: 60, Currency: "TWD"},
+		{Address: "Bob", OutputAmount: 60, Currency: "TWD"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeCash() = %v, want %v", got, want)
+	}
+}
+
+func TestCashListToTxPackage_MixedCurrency(t *testing.T) {
+	cashList := []Cash{
+		{Address: "Alice", OutputAmount: 100, Currency: "TWD"},
+		{Address: "Bob", InputAmount: 100, Currency: "TWD"},
+		{Address: "Carol", OutputAmount: 20, Currency: "USD"},
+		{Address: "Bob", InputAmount: 20, Currency: "USD"},
+		{Address: "Dave", Currency: "JPY"}, // no balance, does not count as a currency
+	}
+	_, _, err := CashListToTxPackage(cashList, "mixed", ListTxGenerateWithMixMap)
+	if !errors.Is(err, ErrMixedCurrency) {
+		t.Fatalf("CashListToTxPackage() error = %v, want ErrMixedCurrency", err)
+	}
+	if !strings.Contains(err.Error(), "TWD, USD") {
+		t.Errorf("error %q does not list the currencies", err)
+	}
+	if _, _, _, err := CashListToOptimizedTxPackage(cashList, "mixed", OptimizeLimit{}); !errors.Is(err, ErrMixedCurrency) {
+		t.Errorf("CashListToOptimizedTxPackage() error = %v, want ErrMixedCurrency", err)
+	}
+
+	packages, err := CashListToTxPackagesByCurrency(cashList, "split", ListTxGenerateWithMixMap)
+	if err != nil {
+		t.Fatalf("CashListToTxPackagesByCurrency() unexpected error: %v", err)
+	}
+	want := map[string]Package{
+		"TWD": {Name: "split", TxList: []Tx{{
+			Name:   "Tx_M_Bob_to_Alice",
+			Input:  []Payment{{Amount: 100, Address: "Bob", Currency: "TWD"}},
+			Output: Payment{Amount: 100, Address: "Alice", Currency: "TWD"},
+		}}},
+		"USD": {Name: "split", TxList: []Tx{{
+			Name:   "Tx_M_Bob_to_Carol",
+			Input:  []Payment{{Amount: 20, Address: "Bob", Currency: "USD"}},
+			Output: Payment{Amount: 20, Address: "Carol", Currency: "USD"},
+		}}},
+		"JPY": {Name: "split"},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("CashListToTxPackagesByCurrency() = %v, want %v", packages, want)
+	}
+}
+
+func TestSplitter_JPY(t *testing.T) {
+	jpy := NewSplitter(SplitConfig{Epsilon: 1e-6, DecimalPlaces: 0})
+
+	t.Run("Half yen balance is kept", func(t *testing.T) {
+		got := jpy.NormalizeCash([]Cash{
+			{Address: "Alice", InputAmount: 1000.5},
+			{Address: "Alice", OutputAmount: 1000},
+			{Address: "Bob", OutputAmount: 0.5},
+		})
+		cashListEquals(t, got, []Cash{{Address: "Alice", InputAmount: 0.5}, {Address: "Bob", OutputAmount: 0.5}}, "NormalizeCash result")
+
+		inputQueue, outputQueue := jpy.generateQueues(got, nil)
+		if inputQueue.Len() != 1 || outputQueue.Len() != 1 {
+			t.Errorf("generateQueues() queued %d inputs and %d outputs, want 1 and 1", inputQueue.Len(), outputQueue.Len())
+		}
+	})
+
+	t.Run("Half yen left over fails the package", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "Alice", InputAmount: 1000.5},
+			{Address: "Bob", OutputAmount: 1000},
+		}
+		_, remaining, err := jpy.CashListToTxPackage(cashList, "jpy", jpy.ListTxGenerateWithMixMap)
+		if err == nil {
+			t.Fatal("CashListToTxPackage() expected error for remaining half yen, got nil")
+		}
+		if !floatEquals(remaining, 0.5) {
+			t.Errorf("remaining = %v, want 0.5", remaining)
+		}
+	})
+
+	t.Run("Whole yen settle", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "Alice", InputAmount: 334},
+			{Address: "Bob", InputAmount: 333},
+			{Address: "Carol", OutputAmount: 667},
+		}
+		pkg, remaining, err := jpy.CashListToTxPackage(cashList, "jpy", jpy.ListTxGenerateWithMixMap)
+		if err != nil {
+			t.Fatalf("CashListToTxPackage() unexpected error: %v", err)
+		}
+		if remaining != 0 || len(pkg.TxList) != 1 || pkg.TxList[0].Output.Amount != 667 {
+			t.Errorf("CashListToTxPackage() = %v, %v, want one transfer of 667 to Carol", pkg, remaining)
+		}
+	})
+}
+
+func TestCashListToTxPackage_ImbalanceErrors(t *testing.T) {
+	t.Run("Outputs exceed inputs", func(t *testing.T) {
+		cashList := []Cash{{Address: "Alice", InputAmount: 30}, {Address: "Bob", InputAmount: 20}, {Address: "Carol", OutputAmount: 80}}
+		_, _, err := CashListToTxPackage(cashList, "underpaid", ListTxGenerateWithMixMap)
+		var underpaid *ErrOutputsExceedInputs
+		if !errors.As(err, &underpaid) {
+			t.Fatalf("CashListToTxPackage() error = %v, want ErrOutputsExceedInputs", err)
+		}
+		if underpaid.Address != "Carol" || !floatEquals(underpaid.Amount, 30) || !floatEquals(underpaid.Output, 80) {
+			t.Errorf("ErrOutputsExceedInputs = %+v, want Carol missing 30 of 80", underpaid)
+		}
+		var leftover *ErrInputsExceedOutputs
+		if errors.As(err, &leftover) {
+			t.Errorf("errors.As() matched ErrInputsExceedOutputs for %v", err)
+		}
+	})
+
+	t.Run("Inputs exceed outputs", func(t *testing.T) {
+		cashList := []Cash{{Address: "Alice", InputAmount: 70}, {Address: "Bob", InputAmount: 0.004}, {Address: "Carol", OutputAmount: 50}}
+		_, remaining, err := CashListToTxPackage(cashList, "overpaid", ListTxGenerateWithMixMap)
+		var leftover *ErrInputsExceedOutputs
+		if !errors.As(err, &leftover) {
+			t.Fatalf("CashListToTxPackage() error = %v, want ErrInputsExceedOutputs", err)
+		}
+		if leftover.Address != "Alice" || !floatEquals(leftover.Amount, remaining) || !floatEquals(leftover.Amount, 20.004) {
+			t.Errorf("ErrInputsExceedOutputs = %+v, want Alice with 20.004 left", leftover)
+		}
+	})
+
+	t.Run("Settle returns the remaining inputs", func(t *testing.T) {
+		// read what the settlement writes to stderr, the remaining inputs are left to the caller to report
+		stderr := os.Stderr
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stderr = w
+		cashList := []Cash{{Address: "Alice", InputAmount: 70}, {Address: "Carol", OutputAmount: 50}}
+		txPackage, remaining, err := defaultSplitter.settleCashList(cashList, "overpaid", ListTxGenerateWithMixMap)
+		os.Stderr = stderr
+		_ = w.Close()
+		written, _ := io.ReadAll(r)
+
+		if err != nil {
+			t.Fatalf("settleCashList() error = %v", err)
+		}
+		if !floatEquals(remaining, 20) {
+			t.Errorf("settleCashList() remaining = %v, want 20", remaining)
+		}
+		if len(txPackage.TxList) != 1 || !floatEquals(txPackage.TxList[0].Output.Amount, 50) {
+			t.Errorf("settleCashList() = %+v, want Carol covered with 50", txPackage)
+		}
+		if len(written) > 0 {
+			t.Errorf("settleCashList() wrote %q to stderr", written)
+		}
+	})
+
+	t.Run("Partial and optimized packages", func(t *testing.T) {
+		cashList := []Cash{{Address: "Alice", InputAmount: 60}, {Address: "Bob", OutputAmount: 40}}
+		var leftover *ErrInputsExceedOutputs
+		if _, _, _, err := CashListToPartialTxPackage(cashList, "partial"); !errors.As(err, &leftover) || leftover.Address != "Alice" {
+			t.Errorf("CashListToPartialTxPackage() error = %v, want ErrInputsExceedOutputs of Alice", err)
+		}
+		if _, _, _, err := CashListToOptimizedTxPackage(cashList, "optimized", OptimizeLimit{}); !errors.As(err, &leftover) || !floatEquals(leftover.Amount, 20) {
+			t.Errorf("CashListToOptimizedTxPackage() error = %v, want ErrInputsExceedOutputs of 20", err)
+		}
+	})
+}
+
+func TestSettleStrategies(t *testing.T) {
+	cashList := []Cash{
+		{Address: "Alice", OutputAmount: 30},
+		{Address: "Bob", InputAmount: 10},
+		{Address: "Carol", InputAmount: 20},
+	}
+	seen := make(map[string]bool)
+	for _, s := range SettleStrategies(AddressPriority{"Carol": 1}) {
+		if seen[s.Name] {
+			t.Errorf("strategy %q is listed twice", s.Name)
+		}
+		seen[s.Name] = true
+
+		pkg, remaining, err := CashListToTxPackage(append([]Cash(nil), cashList...), s.Name, s.Strategy)
+		if err != nil {
+			t.Fatalf("%s: CashListToTxPackage() unexpected error: %v", s.Name, err)
+		}
+		if remaining != 0 || len(pkg.TxList) == 0 {
+			t.Errorf("%s: CashListToTxPackage() = %v, %v, want the cash settled", s.Name, pkg.TxList, remaining)
+		}
+	}
+	for _, name := range []string{"optimized", "priority"} {
+		if !seen[name] {
+			t.Errorf("expected strategy %q to be listed", name)
+		}
+	}
+}
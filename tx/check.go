@@ -0,0 +1,116 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"dtm/libs/decimal"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// CheckPrecision flags Amount and ExtendPayMsg values which have more decimal places than decimals,
+// decimals usually is the minor unit of the trip's currency (2 for USD, 0 for JPY).
+// It returns nil when all values are within precision.
+func CheckPrecision(payments []UserPayment, decimals int) []PrecisionWarning {
+	var warnings []PrecisionWarning
+	for _, up := range payments {
+		if d := decimal.Places(up.Amount); d > decimals {
+			warnings = append(warnings, PrecisionWarning{
+				Name:     up.Name,
+				Field:    "Amount",
+				Index:    -1,
+				Value:    up.Amount,
+				Decimals: d,
+			})
+		}
+		for i, msg := range up.ExtendPayMsg {
+			if d := decimal.Places(msg); d > decimals {
+				warnings = append(warnings, PrecisionWarning{
+					Name:     up.Name,
+					Field:    "ExtendPayMsg",
+					Index:    i,
+					Value:    msg,
+					Decimals: d,
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// duplicateKey identifies records which look identical, amount is compared in cents.
+type duplicateKey struct {
+	name          string
+	amount        int64
+	prePayAddress string
+}
+
+func newDuplicateKey(name string, amount float64, prePayAddress string) duplicateKey {
+	return duplicateKey{name: name, amount: int64(math.Round(amount * 100)), prePayAddress: prePayAddress}
+}
+
+// groupDuplicates returns the indexes of keys which appear more than once,
+// groups are in order of first appearance.
+func groupDuplicates(keys []duplicateKey) [][]int {
+	indexes := make(map[duplicateKey][]int)
+	var order []duplicateKey
+	for i, key := range keys {
+		if _, ok := indexes[key]; !ok {
+			order = append(order, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+
+	var groups [][]int
+	for _, key := range order {
+		if len(indexes[key]) > 1 {
+			groups = append(groups, indexes[key])
+		}
+	}
+	return groups
+}
+
+// FindDuplicateRecords groups the IDs of records which look identical (same name, amount and prepayer),
+// e.g. after importing the same CSV twice. It returns nil when there is no duplicate.
+func FindDuplicateRecords(records []db.RecordInfo) [][]uuid.UUID {
+	keys := make([]duplicateKey, len(records))
+	for i, record := range records {
+		keys[i] = newDuplicateKey(record.Name, record.Amount, string(record.PrePayAddress))
+	}
+
+	var groups [][]uuid.UUID
+	for _, indexes := range groupDuplicates(keys) {
+		group := make([]uuid.UUID, len(indexes))
+		for j, idx := range indexes {
+			group[j] = records[idx].ID
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// FindDuplicatePayments works like FindDuplicateRecords for payments which have no ID yet,
+// it groups the indexes of the payments instead.
+func FindDuplicatePayments(payments []UserPayment) [][]int {
+	keys := make([]duplicateKey, len(payments))
+	for i, up := range payments {
+		keys[i] = newDuplicateKey(up.Name, up.Amount, up.PrePayAddress)
+	}
+	return groupDuplicates(keys)
+}
+
+// FindSelfPayments returns the indexes of the payments which are only shared by their prepayer,
+// settling skips them as they move no money. A payment which can not be converted is left to the settlement to report.
+func FindSelfPayments(payments []UserPayment) []int {
+	var indexes []int
+	for i, up := range payments {
+		if up.Placeholder {
+			continue
+		}
+		tx, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType))
+		if err == nil && tx.IsSelfPayment() {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,133 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckPrecision(t *testing.T) {
+	tests := []struct {
+		name     string
+		payments []UserPayment
+		decimals int
+		expected []PrecisionWarning
+	}{
+		{
+			name: "Clean set passes",
+			payments: []UserPayment{
+				{Name: "Lunch", Amount: 12.5, ExtendPayMsg: []float64{0, 1.25}},
+				{Name: "Taxi", Amount: 100, ExtendPayMsg: []float64{}},
+			},
+			decimals: 2,
+			expected: nil,
+		},
+		{
+			name: "Over-precise amount is flagged",
+			payments: []UserPayment{
+				{Name: "Lunch", Amount: 12.5},
+				{Name: "Dinner", Amount: 12.567},
+			},
+			decimals: 2,
+			expected: []PrecisionWarning{
+				{Name: "Dinner", Field: "Amount", Index: -1, Value: 12.567, Decimals: 3},
+			},
+		},
+		{
+			name: "Over-precise ExtendPayMsg is flagged with index",
+			payments: []UserPayment{
+				{Name: "Hotel", Amount: 300, ExtendPayMsg: []float64{100, 100.001}},
+			},
+			decimals: 2,
+			expected: []PrecisionWarning{
+				{Name: "Hotel", Field: "ExtendPayMsg", Index: 1, Value: 100.001, Decimals: 3},
+			},
+		},
+		{
+			name: "Zero decimals currency",
+			payments: []UserPayment{
+				{Name: "Ramen", Amount: 1200},
+				{Name: "Sushi", Amount: 3000.5},
+			},
+			decimals: 0,
+			expected: []PrecisionWarning{
+				{Name: "Sushi", Field: "Amount", Index: -1, Value: 3000.5, Decimals: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CheckPrecision(tt.payments, tt.decimals)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("CheckPrecision() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindDuplicateRecords(t *testing.T) {
+	lunch := db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 30, PrePayAddress: "Alice"}
+	lunchAgain := db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 30, PrePayAddress: "Alice"}
+	lunchByBob := db.RecordInfo{ID: uuid.New(), Name: "Lunch", Amount: 30, PrePayAddress: "Bob"}
+	taxi := db.RecordInfo{ID: uuid.New(), Name: "Taxi", Amount: 12.5, PrePayAddress: "Bob"}
+	taxiAgain := db.RecordInfo{ID: uuid.New(), Name: "Taxi", Amount: 12.5, PrePayAddress: "Bob"}
+
+	tests := []struct {
+		name     string
+		records  []db.RecordInfo
+		expected [][]uuid.UUID
+	}{
+		{
+			name:     "No duplicate",
+			records:  []db.RecordInfo{lunch, lunchByBob, taxi},
+			expected: nil,
+		},
+		{
+			name:     "Two identical records are a group",
+			records:  []db.RecordInfo{lunch, taxi, lunchAgain},
+			expected: [][]uuid.UUID{{lunch.ID, lunchAgain.ID}},
+		},
+		{
+			name:     "Groups keep order of first appearance",
+			records:  []db.RecordInfo{taxi, lunch, lunchByBob, taxiAgain, lunchAgain},
+			expected: [][]uuid.UUID{{taxi.ID, taxiAgain.ID}, {lunch.ID, lunchAgain.ID}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindDuplicateRecords(tt.records)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("FindDuplicateRecords() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindDuplicatePayments(t *testing.T) {
+	payments := []UserPayment{
+		{Name: "Lunch", Amount: 30, PrePayAddress: "Alice"},
+		{Name: "Taxi", Amount: 12.5, PrePayAddress: "Bob"},
+		{Name: "Lunch", Amount: 30.0, PrePayAddress: "Alice"},
+	}
+	got := FindDuplicatePayments(payments)
+	if !reflect.DeepEqual(got, [][]int{{0, 2}}) {
+		t.Errorf("FindDuplicatePayments() = %v, want [[0 2]]", got)
+	}
+}
+
+func TestFindSelfPayments(t *testing.T) {
+	payments := []UserPayment{
+		{Name: "Souvenir", Amount: 50, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice"}},
+		{Name: "Lunch", Amount: 30, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob"}},
+		{Name: "Gift", Amount: 20, PrePayAddress: "Bob", ShouldPayAddress: []string{"Bob"}, Placeholder: true},
+		{Name: "Book", Amount: 12, PrePayAddress: "Carol", ShouldPayAddress: []string{"Carol"}},
+	}
+	got := FindSelfPayments(payments)
+	if !reflect.DeepEqual(got, []int{0, 3}) {
+		t.Errorf("FindSelfPayments() = %v, want [0 3]", got)
+	}
+}
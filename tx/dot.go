@@ -0,0 +1,46 @@
+package tx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dotEscaper escapes characters which end or break a quoted DOT ID.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ")
+
+// ExportDOT renders the settlement as a Graphviz digraph, every address of the package is a node
+// and every transfer an edge from payer to receiver labeled with the amount.
+// Render it with e.g. `dot -Tpng settlement.dot -o settlement.png`.
+func ExportDOT(pkg Package) string {
+	var nodes []string
+	seen := make(map[string]bool)
+	addNode := func(address string) {
+		if !seen[address] {
+			seen[address] = true
+			nodes = append(nodes, address)
+		}
+	}
+	var edges strings.Builder
+	for _, tx := range pkg.TxList {
+		addNode(tx.Output.Address)
+		for _, input := range tx.Input {
+			addNode(input.Address)
+			// skip empty and self transfers, they need no action
+			if input.Amount < MinValueTxOutput || input.Address == tx.Output.Address {
+				continue
+			}
+			edges.WriteString(fmt.Sprintf("\t\"%s\" -> \"%s\" [label=\"%.2f\"];\n",
+				dotEscaper.Replace(input.Address), dotEscaper.Replace(tx.Output.Address), input.Amount))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph settlement {\n")
+	sb.WriteString("\trankdir=LR;\n")
+	for _, node := range nodes {
+		sb.WriteString(fmt.Sprintf("\t\"%s\";\n", dotEscaper.Replace(node)))
+	}
+	sb.WriteString(edges.String())
+	sb.WriteString("}\n")
+	return sb.String()
+}
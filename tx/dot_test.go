@@ -0,0 +1,75 @@
+package tx
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	dotNodeLine = regexp.MustCompile(`^\t"((?:[^"\\]|\\.)*)";$`)
+	dotEdgeLine = regexp.MustCompile(`^\t"((?:[^"\\]|\\.)*)" -> "((?:[^"\\]|\\.)*)" \[label="(\d+\.\d{2})"\];$`)
+)
+
+// parseDOT checks the structure written by ExportDOT and returns its nodes and edges ("from->to:label").
+func parseDOT(t *testing.T, dot string) ([]string, []string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(dot, "\n"), "\n")
+	if len(lines) < 3 || lines[0] != "digraph settlement {" || lines[1] != "\trankdir=LR;" || lines[len(lines)-1] != "}" {
+		t.Fatalf("unexpected digraph frame:\n%s", dot)
+	}
+	var nodes, edges []string
+	for _, line := range lines[2 : len(lines)-1] {
+		if m := dotEdgeLine.FindStringSubmatch(line); m != nil {
+			edges = append(edges, m[1]+"->"+m[2]+":"+m[3])
+		} else if m := dotNodeLine.FindStringSubmatch(line); m != nil {
+			if len(edges) > 0 {
+				t.Errorf("node %q declared after edges", m[1])
+			}
+			nodes = append(nodes, m[1])
+		} else {
+			t.Errorf("invalid DOT statement %q", line)
+		}
+	}
+	return nodes, edges
+}
+
+func TestExportDOT(t *testing.T) {
+	pkg := Package{TxList: []Tx{
+		{Name: "Tx_M_Carol+Bob_to_Alice", Input: []Payment{{Amount: 40, Address: "Carol"}, {Amount: 20, Address: "Bob"}}, Output: Payment{Amount: 60, Address: "Alice"}},
+		{Name: "Tx_M_Bob+Erin+Dave_to_Dave", Input: []Payment{{Amount: 10, Address: "Bob"}, {Amount: 0, Address: "Erin"}, {Amount: 5, Address: "Dave"}}, Output: Payment{Amount: 10, Address: "Dave"}},
+	}}
+
+	nodes, edges := parseDOT(t, ExportDOT(pkg))
+
+	expectedNodes := []string{"Alice", "Carol", "Bob", "Dave", "Erin"}
+	if strings.Join(nodes, ",") != strings.Join(expectedNodes, ",") {
+		t.Errorf("ExportDOT() nodes = %v, want %v", nodes, expectedNodes)
+	}
+	expectedEdges := []string{"Carol->Alice:40.00", "Bob->Alice:20.00", "Bob->Dave:10.00"}
+	if strings.Join(edges, ",") != strings.Join(expectedEdges, ",") {
+		t.Errorf("ExportDOT() edges = %v, want %v, empty and self transfers are skipped", edges, expectedEdges)
+	}
+}
+
+func TestExportDOT_EscapesAddresses(t *testing.T) {
+	pkg := Package{TxList: []Tx{
+		{Input: []Payment{{Amount: 12.5, Address: `Bob "the \ builder"`}}, Output: Payment{Amount: 12.5, Address: "Alice\nSmith"}},
+	}}
+
+	nodes, edges := parseDOT(t, ExportDOT(pkg))
+
+	if len(nodes) != 2 || nodes[1] != `Bob \"the \\ builder\"` || nodes[0] != "Alice Smith" {
+		t.Errorf("ExportDOT() nodes = %q, want escaped addresses", nodes)
+	}
+	if len(edges) != 1 || edges[0] != `Bob \"the \\ builder\"->Alice Smith:12.50` {
+		t.Errorf("ExportDOT() edges = %q, want one escaped edge", edges)
+	}
+}
+
+func TestExportDOT_Empty(t *testing.T) {
+	nodes, edges := parseDOT(t, ExportDOT(Package{}))
+	if len(nodes) != 0 || len(edges) != 0 {
+		t.Errorf("ExportDOT() of empty package = %v %v, want an empty digraph", nodes, edges)
+	}
+}
@@ -0,0 +1,169 @@
+package tx
+
+import (
+	"dtm/libs/decimal"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxExactDecimals keeps the minor units of SettleExact far from overflowing int64
+const maxExactDecimals = 9
+
+// exactCash is the net balance of an address in minor units, positive when the address is owed money.
+type exactCash struct {
+	Address  string
+	Currency string
+	Units    int64
+}
+
+// SettleExact settles payments like ShareMoneyEasy, but in integer minor units of decimals, e.g. cents for 2.
+// Each payment is split by its strategy and the shares are rounded to minor units by largest remainder,
+// so every payment balances to the unit. Balances are netted and matched in integers like ListTxGenerateWithMixMap
+// and converted back only for the returned Package, so there is no float drift and never a remaining input.
+// An Amount with more decimal places than decimals is an error, see CheckPrecision.
+func SettleExact(payments []UserPayment, decimals int) (Package, error) {
+	if decimals < 0 || decimals > maxExactDecimals {
+		return Package{}, fmt.Errorf("decimals %d is out of range 0 to %d", decimals, maxExactDecimals)
+	}
+	for _, up := range payments {
+		if d := decimal.Places(up.Amount); !up.Placeholder && d > decimals {
+			return Package{}, fmt.Errorf("UserPayment '%s' amount %v has %d decimal places, more than %d", up.Name, up.Amount, d, decimals)
+		}
+	}
+	splitter := NewSplitter(SplitConfig{Epsilon: epsilon, DecimalPlaces: decimals})
+	txList, err := appendTxList(nil, payments, splitter.strategy)
+	if err != nil {
+		return Package{}, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+
+	scale := math.Pow10(decimals)
+	balances, err := exactBalances(txList, scale)
+	if err != nil {
+		return Package{}, err
+	}
+	settled, err := settleExactBalances(balances, scale)
+	if err != nil {
+		return Package{}, err
+	}
+	return Package{Name: "activity", TxList: settled}, nil
+}
+
+// exactBalances nets the txs into minor units of scale, in the order an address first appears.
+func exactBalances(txList []Tx, scale float64) ([]exactCash, error) {
+	var balances []exactCash
+	index := make(map[cashKey]int)
+	add := func(p Payment, units int64) {
+		key := cashKey{Address: p.Address, Currency: p.Currency}
+		i, ok := index[key]
+		if !ok {
+			i = len(balances)
+			index[key] = i
+			balances = append(balances, exactCash{Address: p.Address, Currency: p.Currency})
+		}
+		balances[i].Units += units
+	}
+
+	for _, tx := range txList {
+		output, inputs, err := minorUnits(tx, scale)
+		if err != nil {
+			return nil, err
+		}
+		add(tx.Output, output)
+		for i, input := range tx.Input {
+			add(input, -inputs[i])
+		}
+	}
+
+	var currencies []string
+	for _, cash := range balances {
+		if cash.Units != 0 && !slices.Contains(currencies, cash.Currency) {
+			currencies = append(currencies, cash.Currency)
+		}
+	}
+	if len(currencies) > 1 {
+		sort.Strings(currencies)
+		return nil, fmt.Errorf("%w: %s", ErrMixedCurrency, strings.Join(currencies, ", "))
+	}
+	return balances, nil
+}
+
+// minorUnits rounds the output of tx to minor units of scale, and splits it among the inputs by largest remainder,
+// so the input units always sum to the output units. Ties go to the earlier input.
+func minorUnits(tx Tx, scale float64) (int64, []int64, error) {
+	output := int64(math.Round(tx.Output.Amount * scale))
+	inputs := make([]int64, len(tx.Input))
+	remainders := make([]float64, len(tx.Input))
+	order := make([]int, len(tx.Input))
+	left := output
+	for i, input := range tx.Input {
+		exact := input.Amount * scale
+		inputs[i] = int64(math.Floor(exact))
+		remainders[i] = exact - math.Floor(exact)
+		order[i] = i
+		left -= inputs[i]
+	}
+	if left < 0 || left > int64(len(inputs)) {
+		return 0, nil, fmt.Errorf("tx '%s' inputs do not split its output of %d minor units", tx.Name, output)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for _, i := range order[:left] {
+		inputs[i]++
+	}
+	return output, inputs, nil
+}
+
+// settleExactBalances matches debtors to creditors in the order of ListTxGenerateWithMixMap,
+// largest balance first and by address on ties, the split remainder of a debtor goes to the back of the queue.
+func settleExactBalances(balances []exactCash, scale float64) ([]Tx, error) {
+	var creditors, debtors []exactCash
+	for _, cash := range balances {
+		if cash.Units > 0 {
+			creditors = append(creditors, cash)
+		} else if cash.Units < 0 {
+			cash.Units = -cash.Units
+			debtors = append(debtors, cash)
+		}
+	}
+	byUnits := func(list []exactCash) func(i, j int) bool {
+		return func(i, j int) bool {
+			if list[i].Units == list[j].Units {
+				return list[i].Address < list[j].Address
+			}
+			return list[i].Units > list[j].Units
+		}
+	}
+	sort.SliceStable(creditors, byUnits(creditors))
+	sort.SliceStable(debtors, byUnits(debtors))
+
+	var txList []Tx
+	usedNames := make(map[string]bool)
+	for _, creditor := range creditors {
+		var inputs []Payment
+		for need := creditor.Units; need > 0; {
+			if len(debtors) == 0 {
+				// every payment balances in minor units, so the debts always cover the credits
+				return nil, fmt.Errorf("unexpected condition: %d minor units of %s are not covered", need, creditor.Address)
+			}
+			debtor := debtors[0]
+			debtors = debtors[1:]
+			take := min(debtor.Units, need)
+			inputs = append(inputs, Payment{Amount: float64(take) / scale, Address: debtor.Address, Currency: debtor.Currency})
+			need -= take
+			if debtor.Units > take {
+				debtor.Units -= take
+				debtors = append(debtors, debtor)
+			}
+		}
+		txList = append(txList, Tx{
+			Name:   mixMapTxName(usedNames, inputs, creditor.Address),
+			Input:  inputs,
+			Output: Payment{Amount: float64(creditor.Units) / scale, Address: creditor.Address, Currency: creditor.Currency},
+		})
+	}
+	return txList, nil
+}
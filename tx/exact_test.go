@@ -0,0 +1,153 @@
+package tx
+
+import (
+	"dtm/libs/decimal"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// assertExact checks every amount of pkg is whole in minor units of decimals and every tx balances to the unit,
+// it returns the net of each address in minor units, positive when the address is owed money.
+func assertExact(t *testing.T, pkg Package, decimals int) map[string]int64 {
+	t.Helper()
+	scale := math.Pow10(decimals)
+	units := func(amount float64) int64 {
+		if d := decimal.Places(amount); d > decimals {
+			t.Errorf("amount %v has %d decimal places, want at most %d", amount, d, decimals)
+		}
+		return int64(math.Round(amount * scale))
+	}
+	net := make(map[string]int64)
+	for _, tx := range pkg.TxList {
+		output := units(tx.Output.Amount)
+		sum := int64(0)
+		for _, input := range tx.Input {
+			sum += units(input.Amount)
+			net[input.Address] -= units(input.Amount)
+		}
+		if sum != output {
+			t.Errorf("tx %s inputs sum to %d minor units, output is %d", tx.Name, sum, output)
+		}
+		net[tx.Output.Address] += output
+	}
+	return net
+}
+
+func TestSettleExact_ManyThirds(t *testing.T) {
+	addresses := []string{"Alice", "Bob", "Carol", "Dave"}
+	var payments []UserPayment
+	for i := range 60 {
+		payments = append(payments, UserPayment{
+			Name:             fmt.Sprintf("Thirds%d", i),
+			Amount:           100 + float64(i%7)/100,
+			PrePayAddress:    addresses[i%4],
+			ShouldPayAddress: []string{addresses[(i+1)%4], addresses[(i+2)%4], addresses[(i+3)%4]},
+			ExtendPayMsg:     []float64{1, 1, 1},
+			PaymentType:      2, // part, shares of a third are not whole cents
+		})
+	}
+
+	pkg, err := SettleExact(payments, 2)
+	if err != nil {
+		t.Fatalf("SettleExact() unexpected error: %v", err)
+	}
+	net := assertExact(t, pkg, 2)
+
+	// the float pipeline settles the same positions up to the rounding of each share
+	txList, err := UIList2TxList(payments)
+	if err != nil {
+		t.Fatalf("UIList2TxList() unexpected error: %v", err)
+	}
+	positions := Package{TxList: txList}
+	for _, cash := range NormalizeCash(positions.ProcessTransactions()) {
+		want := cash.OutputAmount - cash.InputAmount
+		if got := float64(net[cash.Address]) / 100; math.Abs(got-want) > 0.01*float64(len(payments)) {
+			t.Errorf("net of %s = %v, want about %v", cash.Address, got, want)
+		}
+	}
+}
+
+func TestSettleExact_MatchesFloatPipeline(t *testing.T) {
+	payments := readSampleInput(t)
+	want, _, err := ShareMoneyEasy(payments)
+	if err != nil {
+		t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+	}
+	got, err := SettleExact(payments, 2)
+	if err != nil {
+		t.Fatalf("SettleExact() unexpected error: %v", err)
+	}
+	assertExact(t, got, 2)
+
+	if len(got.TxList) != len(want.TxList) {
+		t.Fatalf("SettleExact() = %v, want %v", got.String(), want.String())
+	}
+	for i := range want.TxList {
+		g, w := got.TxList[i], want.TxList[i]
+		if g.Name != w.Name || g.Output.Address != w.Output.Address || math.Abs(g.Output.Amount-w.Output.Amount) > MinValueTxOutput {
+			t.Errorf("TxList[%d] = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestSettleExact(t *testing.T) {
+	t.Run("Whole yen", func(t *testing.T) {
+		payments := []UserPayment{{Name: "Ramen", Amount: 1000, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}}}
+		pkg, err := SettleExact(payments, 0)
+		if err != nil {
+			t.Fatalf("SettleExact() unexpected error: %v", err)
+		}
+		net := assertExact(t, pkg, 0)
+		if net["Alice"] != 666 || net["Bob"] != -333 || net["Carol"] != -333 {
+			t.Errorf("net = %v, want Alice 666, Bob -333, Carol -333", net)
+		}
+	})
+
+	t.Run("Percentages of an odd amount", func(t *testing.T) {
+		payments := []UserPayment{{Name: "Taxi", Amount: 0.1, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob", "Carol", "Dave"}, ExtendPayMsg: []float64{33.3, 33.3, 33.4}, PaymentType: 5}}
+		pkg, err := SettleExact(payments, 2)
+		if err != nil {
+			t.Fatalf("SettleExact() unexpected error: %v", err)
+		}
+		if net := assertExact(t, pkg, 2); net["Alice"] != 10 {
+			t.Errorf("net of Alice = %d, want 10", net["Alice"])
+		}
+	})
+
+	t.Run("No payments", func(t *testing.T) {
+		pkg, err := SettleExact(nil, 2)
+		if err != nil || len(pkg.TxList) != 0 {
+			t.Errorf("SettleExact(nil) = %v, %v, want an empty package", pkg, err)
+		}
+	})
+
+	errorCases := []struct {
+		name     string
+		payments []UserPayment
+		decimals int
+	}{
+		{name: "Amount finer than decimals", payments: []UserPayment{{Name: "Tea", Amount: 10.005, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob"}}}, decimals: 2},
+		{name: "Negative decimals", decimals: -1},
+		{name: "Too many decimals", decimals: maxExactDecimals + 1},
+		{name: "Invalid payment", payments: []UserPayment{{Name: "Empty", Amount: 10, PrePayAddress: "Alice"}}, decimals: 2},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SettleExact(tt.payments, tt.decimals); err == nil {
+				t.Error("SettleExact() expected error, got nil")
+			}
+		})
+	}
+
+	t.Run("Mixed currencies", func(t *testing.T) {
+		payments := []UserPayment{
+			{Name: "Hotel", Amount: 100, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob"}, Currency: "TWD"},
+			{Name: "Bus", Amount: 20, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice"}, Currency: "USD"},
+		}
+		if _, err := SettleExact(payments, 2); !errors.Is(err, ErrMixedCurrency) {
+			t.Errorf("SettleExact() error = %v, want ErrMixedCurrency", err)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"fmt"
+	"strings"
+)
+
+// markdownEscaper escapes characters which break a Markdown table cell or list item.
+var markdownEscaper = strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`", "\n", " ")
+
+// ExportMarkdown renders the trip as a Markdown summary for sharing in chat apps,
+// a table of the expenses followed by a "Settlement" section listing who pays whom.
+func ExportMarkdown(tripName string, records []db.RecordInfo, pkg Package) string {
+	var sb strings.Builder
+	sb.WriteString("# " + markdownEscaper.Replace(tripName) + "\n\n")
+
+	sb.WriteString("## Expenses\n\n")
+	if len(records) == 0 {
+		sb.WriteString("No expenses.\n")
+	} else {
+		var total float64
+		sb.WriteString("| Name | Amount | Paid by |\n")
+		sb.WriteString("| --- | ---: | --- |\n")
+		for _, record := range records {
+			total += record.Amount
+			sb.WriteString(fmt.Sprintf("| %s | %.2f | %s |\n",
+				markdownEscaper.Replace(record.Name), record.Amount, markdownEscaper.Replace(string(record.PrePayAddress))))
+		}
+		sb.WriteString(fmt.Sprintf("| **Total** | **%.2f** | |\n", total))
+	}
+
+	sb.WriteString("\n## Settlement\n\n")
+	transfers := 0
+	for _, tx := range pkg.TxList {
+		for _, input := range tx.Input {
+			// skip empty and self transfers, they need no action
+			if input.Amount < MinValueTxOutput || input.Address == tx.Output.Address {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("- %s pays %s %.2f\n",
+				markdownEscaper.Replace(input.Address), markdownEscaper.Replace(tx.Output.Address), input.Amount))
+			transfers++
+		}
+	}
+	if transfers == 0 {
+		sb.WriteString("Nothing to settle.\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"strings"
+	"testing"
+)
+
+func TestExportMarkdown(t *testing.T) {
+	records := []db.RecordInfo{
+		{Name: "hotel", Amount: 90, PrePayAddress: "Alice"},
+		{Name: "dinner | drinks", Amount: 60, PrePayAddress: "Bob"},
+	}
+	pkg := Package{TxList: []Tx{
+		{Name: "Tx_M_Carol_to_Alice", Input: []Payment{{Amount: 40, Address: "Carol"}}, Output: Payment{Amount: 40, Address: "Alice"}},
+		{Name: "Tx_M_Carol+Alice_to_Bob", Input: []Payment{{Amount: 10, Address: "Carol"}, {Amount: 0, Address: "Alice"}}, Output: Payment{Amount: 10, Address: "Bob"}},
+	}}
+
+	md := ExportMarkdown("Kyoto trip", records, pkg)
+
+	expected := []string{
+		"# Kyoto trip\n",
+		"| hotel | 90.00 | Alice |\n",
+		"| dinner \\| drinks | 60.00 | Bob |\n",
+		"| **Total** | **150.00** | |\n",
+		"## Settlement\n",
+		"- Carol pays Alice 40.00\n",
+		"- Carol pays Bob 10.00\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(md, line) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", line, md)
+		}
+	}
+	if strings.Contains(md, "Alice pays") {
+		t.Errorf("expected zero transfers to be skipped, got:\n%s", md)
+	}
+}
+
+func TestExportMarkdown_Empty(t *testing.T) {
+	md := ExportMarkdown("empty", nil, Package{})
+	if !strings.Contains(md, "No expenses.") || !strings.Contains(md, "Nothing to settle.") {
+		t.Errorf("unexpected markdown for empty trip:\n%s", md)
+	}
+}
@@ -0,0 +1,159 @@
+package tx
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	DefaultOptimizeDuration   = 200 * time.Millisecond
+	DefaultOptimizeIterations = 1 << 24
+	// maxOptimizeAddresses bounds the memory of the search, it keeps two values per subset of addresses
+	maxOptimizeAddresses = 24
+)
+
+// CashListToOptimizedTxPackage converts a slice of Cash objects into a TxPackage with the fewest transfers.
+// Finding it is NP-hard, so the search is capped by limit; when the cap is hit the greedy
+// ListTxGenerateWithMixMap result is returned instead and optimal is false.
+func CashListToOptimizedTxPackage(cashList []Cash, packageName string, limit OptimizeLimit) (Package, float64, bool, error) {
+	if err := checkSingleCurrency(cashList); err != nil {
+		return Package{}, 0, false, err
+	}
+	groups, ok := zeroSumGroups(cashList, limit)
+	if !ok {
+		txPackage, remaining, err := CashListToTxPackage(cashList, packageName, ListTxGenerateWithMixMap)
+		return txPackage, remaining, false, err
+	}
+
+	var generatedTxList []Tx
+	totalRemainingInputAmount, err := settleGroups(&generatedTxList, groups)
+	if err != nil {
+		return Package{}, 0, false, err
+	}
+	if totalRemainingInputAmount > epsilon {
+		return Package{}, totalRemainingInputAmount, false, newErrInputsExceedOutputs(cashList, generatedTxList, totalRemainingInputAmount)
+	}
+
+	return Package{
+		Name:   packageName,
+		TxList: generatedTxList,
+	}, totalRemainingInputAmount, true, nil
+}
+
+// ListTxGenerateOptimized returns the strategy of CashListToOptimizedTxPackage, the cash is settled with the fewest
+// transfers when the search finishes within limit, otherwise like ListTxGenerateWithMixMap.
+func ListTxGenerateOptimized(limit OptimizeLimit) ListGenerateStrategy {
+	return func(txList *[]Tx, cashList *[]Cash) (float64, error) {
+		groups, ok := zeroSumGroups(*cashList, limit)
+		if !ok {
+			return ListTxGenerateWithMixMap(txList, cashList)
+		}
+		return settleGroups(txList, groups)
+	}
+}
+
+// settleGroups settles each zero sum group on its own, the greedy needs at most len(group)-1 transfers for it.
+func settleGroups(txList *[]Tx, groups [][]Cash) (float64, error) {
+	var totalRemainingInputAmount float64
+	for _, group := range groups {
+		remaining, err := ListTxGenerateWithMixMap(txList, &group)
+		if err != nil {
+			return totalRemainingInputAmount, err
+		}
+		totalRemainingInputAmount += remaining
+	}
+	return totalRemainingInputAmount, nil
+}
+
+// zeroSumGroups splits the addresses with a balance into the most groups whose balances sum to zero,
+// n addresses in k groups can be settled with n-k transfers, which is the minimum.
+// It returns false when the search does not finish within limit.
+func zeroSumGroups(cashList []Cash, limit OptimizeLimit) ([][]Cash, bool) {
+	if limit.MaxDuration <= 0 {
+		limit.MaxDuration = DefaultOptimizeDuration
+	}
+	if limit.MaxIterations <= 0 {
+		limit.MaxIterations = DefaultOptimizeIterations
+	}
+
+	var members []Cash
+	var balances []float64
+	for _, cash := range cashList {
+		if balance := cash.InputAmount - cash.OutputAmount; math.Abs(balance) > epsilon {
+			members = append(members, cash)
+			balances = append(balances, balance)
+		}
+	}
+	n := len(members)
+	if n > maxOptimizeAddresses || n*(1<<n) > limit.MaxIterations {
+		return nil, false
+	}
+
+	// sums[mask] is the balance of the addresses in mask, best[mask] is the most zero sum groups mask splits into
+	full := 1<<n - 1
+	sums := make([]float64, full+1)
+	best := make([]int8, full+1)
+	deadline := time.Now().Add(limit.MaxDuration)
+	for mask := 1; mask <= full; mask++ {
+		if mask&0xfff == 0 && time.Now().After(deadline) {
+			return nil, false
+		}
+		low := bits.TrailingZeros(uint(mask))
+		sums[mask] = sums[mask&(mask-1)] + balances[low]
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			if b := best[mask&^(rest&-rest)]; b > best[mask] {
+				best[mask] = b
+			}
+		}
+		if math.Abs(sums[mask]) < epsilon {
+			best[mask]++
+		}
+	}
+
+	// walk back from full, the addresses removed between two zero sum masks form one group
+	var groups [][]Cash
+	var group []Cash
+	for mask := full; mask != 0; {
+		gain := int8(0)
+		if math.Abs(sums[mask]) < epsilon {
+			gain = 1
+		}
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			bit := rest & -rest
+			if best[mask&^bit]+gain == best[mask] {
+				group = append(group, members[bits.TrailingZeros(uint(bit))])
+				mask &^= bit
+				break
+			}
+		}
+		if mask == 0 || math.Abs(sums[mask]) < epsilon {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	return groups, true
+}
+
+// ShareMoneyOptimized works like ShareMoneyEasy, but settles with the fewest transfers when the search
+// finishes within limit. optimal reports whether the result is minimal or the greedy fallback.
+func ShareMoneyOptimized(uiList []UserPayment, limit OptimizeLimit) (Package, float64, bool, error) {
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		return Package{}, 0, false, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+	txPackage := Package{
+		Name:   "UserPaymentsPackage",
+		TxList: txList,
+	}
+	cashList := NormalizeCash(txPackage.ProcessTransactions())
+	txPackageFromCash, diff, optimal, err := CashListToOptimizedTxPackage(cashList, "activity", limit)
+	if err != nil {
+		return Package{}, 0, false, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
+	}
+	txPackageFromCash.SetNoSmallValue(MinValueTxOutput)
+	txPackageFromCash.DropZeroTx()
+
+	return txPackageFromCash, diff, optimal, nil
+}
@@ -0,0 +1,149 @@
+package tx
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// transferCount counts the payments in a package, a tx with 2 inputs is 2 transfers.
+func transferCount(pkg Package) int {
+	count := 0
+	for _, tx := range pkg.TxList {
+		count += len(tx.Input)
+	}
+	return count
+}
+
+// assertReconciles checks that every address in cashList is settled to its balance by pkg.
+func assertReconciles(t *testing.T, cashList []Cash, pkg Package) {
+	t.Helper()
+	net := pkg.NetBalance()
+	for _, cash := range cashList {
+		want := cash.OutputAmount - cash.InputAmount
+		if math.Abs(net[cash.Address]-want) > 1e-6 {
+			t.Errorf("address %s settles to %v, want %v", cash.Address, net[cash.Address], want)
+		}
+	}
+}
+
+func TestCashListToOptimizedTxPackage(t *testing.T) {
+	// greedy pays C1 with D1 and part of D2, leaving 4 transfers,
+	// {C2, D1} and {C1, D2, D3} settle on their own with 3 transfers
+	cashList := []Cash{
+		{Address: "C1", OutputAmount: 10},
+		{Address: "C2", OutputAmount: 7},
+		{Address: "D1", InputAmount: 7},
+		{Address: "D2", InputAmount: 6},
+		{Address: "D3", InputAmount: 4},
+	}
+
+	greedy, _, err := CashListToTxPackage(cashList, "greedy", ListTxGenerateWithMixMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pkg, remaining, optimal, err := CashListToOptimizedTxPackage(cashList, "optimized", OptimizeLimit{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !optimal {
+		t.Errorf("expected optimal result for 5 addresses")
+	}
+	if !floatEquals(remaining, 0) {
+		t.Errorf("expected no remaining, got %v", remaining)
+	}
+	if got := transferCount(greedy); got != 4 {
+		t.Errorf("expected greedy to use 4 transfers, got %d", got)
+	}
+	if got := transferCount(pkg); got != 3 {
+		t.Errorf("expected 3 transfers, got %d", got)
+	}
+	if pkg.Name != "optimized" {
+		t.Errorf("expected package name optimized, got %s", pkg.Name)
+	}
+	assertReconciles(t, cashList, pkg)
+}
+
+func TestCashListToOptimizedTxPackage_Unbalanced(t *testing.T) {
+	cashList := []Cash{{Address: "A", InputAmount: 10}, {Address: "B", OutputAmount: 5}}
+	_, remaining, _, err := CashListToOptimizedTxPackage(cashList, "unbalanced", OptimizeLimit{})
+	if err == nil {
+		t.Fatalf("expected error for remaining inputs")
+	}
+	if !floatEquals(remaining, 5) {
+		t.Errorf("expected remaining 5, got %v", remaining)
+	}
+}
+
+func TestCashListToOptimizedTxPackage_Heuristic(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		limit OptimizeLimit
+	}{
+		{name: "Large group skips the search", size: 300, limit: OptimizeLimit{}},
+		{name: "Iteration cap", size: 12, limit: OptimizeLimit{MaxIterations: 100}},
+		{name: "Time cap", size: 20, limit: OptimizeLimit{MaxDuration: time.Millisecond, MaxIterations: 1 << 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cashList := NormalizeCash(randomBalancedCashList(int64(tt.size), tt.size, 3))
+
+			start := time.Now()
+			pkg, _, optimal, err := CashListToOptimizedTxPackage(cashList, "heuristic", tt.limit)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("expected the capped search to return quickly, took %v", elapsed)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if optimal {
+				t.Errorf("expected heuristic result")
+			}
+			assertReconciles(t, cashList, pkg)
+		})
+	}
+}
+
+func TestCashListToOptimizedTxPackage_NeverWorseThanGreedy(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		cashList := NormalizeCash(randomBalancedCashList(seed, 10, 1))
+		greedy, _, err := CashListToTxPackage(cashList, "greedy", ListTxGenerateWithMixMap)
+		if err != nil {
+			t.Fatalf("seed %d: unexpected error: %v", seed, err)
+		}
+		pkg, _, optimal, err := CashListToOptimizedTxPackage(cashList, "optimized", OptimizeLimit{MaxDuration: time.Minute})
+		if err != nil {
+			t.Fatalf("seed %d: unexpected error: %v", seed, err)
+		}
+		if !optimal {
+			t.Errorf("seed %d: expected optimal result", seed)
+		}
+		if transferCount(pkg) > transferCount(greedy) {
+			t.Errorf("seed %d: optimized uses %d transfers, greedy %d", seed, transferCount(pkg), transferCount(greedy))
+		}
+		assertReconciles(t, cashList, pkg)
+	}
+}
+
+func TestShareMoneyOptimized(t *testing.T) {
+	uiList := []UserPayment{
+		{Name: "hotel", Amount: 90, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, ExtendPayMsg: []float64{0, 0, 0}},
+		{Name: "dinner", Amount: 60, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, ExtendPayMsg: []float64{0, 0, 0}},
+	}
+	pkg, remaining, optimal, err := ShareMoneyOptimized(uiList, OptimizeLimit{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !optimal {
+		t.Errorf("expected optimal result")
+	}
+	if !floatEquals(remaining, 0) {
+		t.Errorf("expected no remaining, got %v", remaining)
+	}
+	net := pkg.NetBalance()
+	if math.Abs(net["Alice"]-40) > 1e-6 || math.Abs(net["Bob"]-10) > 1e-6 || math.Abs(net["Carol"]+50) > 1e-6 {
+		t.Errorf("unexpected settlement: %v", net)
+	}
+}
@@ -0,0 +1,92 @@
+package tx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// NetBalance returns the net balance of each address in the package,
+// positive value means the address receives money, negative means it pays.
+// It is meant for a package in one currency, the balances of an address in several currencies are added up,
+// see NetBalanceByCurrency.
+func (tp *Package) NetBalance() map[string]float64 {
+	balance := make(map[string]float64)
+	for _, cash := range tp.ProcessTransactions() {
+		balance[cash.Address] += cash.OutputAmount - cash.InputAmount
+	}
+	return balance
+}
+
+// NetBalanceByCurrency returns the net balance of each address in each currency of the package,
+// keyed by currency then address.
+func (tp *Package) NetBalanceByCurrency() map[string]map[string]float64 {
+	balance := make(map[string]map[string]float64)
+	for _, cash := range tp.ProcessTransactions() {
+		if balance[cash.Currency] == nil {
+			balance[cash.Currency] = make(map[string]float64)
+		}
+		balance[cash.Currency][cash.Address] += cash.OutputAmount - cash.InputAmount
+	}
+	return balance
+}
+
+// DiffSettlement compares the net balance of two settlements address by address in each currency.
+func DiffSettlement(before, after Package) SettlementDiff {
+	beforeBalance := before.NetBalanceByCurrency()
+	afterBalance := after.NetBalanceByCurrency()
+
+	diff := SettlementDiff{}
+	for _, currency := range unionKeys(beforeBalance, afterBalance) {
+		for _, addr := range unionKeys(beforeBalance[currency], afterBalance[currency]) {
+			beforeAmount, afterAmount := beforeBalance[currency][addr], afterBalance[currency][addr]
+			if math.Abs(afterAmount-beforeAmount) < MinValueTxOutput {
+				continue
+			}
+			diff.Changes = append(diff.Changes, BalanceChange{
+				Address:  addr,
+				Currency: currency,
+				Before:   beforeAmount,
+				After:    afterAmount,
+			})
+		}
+	}
+	return diff
+}
+
+// unionKeys returns the keys of both maps sorted.
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PreviewWithRecord settles the payments with and without the candidate,
+// it returns the settlement including the candidate and the diff to the current settlement.
+func PreviewWithRecord(existing []UserPayment, candidate UserPayment) (Package, SettlementDiff, error) {
+	before, remainingBefore, err := ShareMoneyEasy(existing)
+	if err != nil {
+		return Package{}, SettlementDiff{}, fmt.Errorf("failed to settle existing payments: %w", err)
+	}
+
+	withCandidate := make([]UserPayment, 0, len(existing)+1)
+	withCandidate = append(withCandidate, existing...)
+	withCandidate = append(withCandidate, candidate)
+	after, remainingAfter, err := ShareMoneyEasy(withCandidate)
+	if err != nil {
+		return Package{}, SettlementDiff{}, fmt.Errorf("failed to settle with candidate %s: %w", candidate.Name, err)
+	}
+
+	diff := DiffSettlement(before, after)
+	diff.RemainingBefore = remainingBefore
+	diff.RemainingAfter = remainingAfter
+	return after, diff, nil
+}
@@ -0,0 +1,111 @@
+package tx
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPreviewWithRecord(t *testing.T) {
+	existing := []UserPayment{
+		{Name: "Hotel", Amount: 90, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, ExtendPayMsg: []float64{0, 0, 0}},
+	}
+	tests := []struct {
+		name            string
+		candidate       UserPayment
+		expectedChanges []BalanceChange
+	}{
+		{
+			name:      "Candidate changes balances",
+			candidate: UserPayment{Name: "Dinner", Amount: 60, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, ExtendPayMsg: []float64{0, 0, 0}},
+			expectedChanges: []BalanceChange{
+				{Address: "Alice", Before: 60, After: 40},
+				{Address: "Bob", Before: -30, After: 10},
+				{Address: "Carol", Before: -30, After: -50},
+			},
+		},
+		{
+			name:      "Candidate introduces a new address",
+			candidate: UserPayment{Name: "Taxi", Amount: 20, PrePayAddress: "Dave", ShouldPayAddress: []string{"Alice", "Dave"}, ExtendPayMsg: []float64{0, 0}},
+			expectedChanges: []BalanceChange{
+				{Address: "Alice", Before: 60, After: 50},
+				{Address: "Dave", Before: 0, After: 10},
+			},
+		},
+		{
+			name:            "Self paid candidate changes nothing",
+			candidate:       UserPayment{Name: "Snack", Amount: 5, PrePayAddress: "Carol", ShouldPayAddress: []string{"Carol"}, ExtendPayMsg: []float64{0}},
+			expectedChanges: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, diff, err := PreviewWithRecord(existing, tt.candidate)
+			if err != nil {
+				t.Fatalf("PreviewWithRecord() unexpected error: %v", err)
+			}
+
+			// preview must match adding the record for real
+			actual, remaining, err := ShareMoneyEasy(append(append([]UserPayment{}, existing...), tt.candidate))
+			if err != nil {
+				t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+			}
+			previewBalance := preview.NetBalance()
+			for addr, amount := range actual.NetBalance() {
+				if math.Abs(previewBalance[addr]-amount) > epsilon {
+					t.Errorf("balance of %s: preview %f, actual %f", addr, previewBalance[addr], amount)
+				}
+			}
+			if math.Abs(diff.RemainingAfter-remaining) > epsilon {
+				t.Errorf("RemainingAfter = %f, want %f", diff.RemainingAfter, remaining)
+			}
+
+			if len(diff.Changes) != len(tt.expectedChanges) {
+				t.Fatalf("diff.Changes = %+v, want %+v", diff.Changes, tt.expectedChanges)
+			}
+			for i, want := range tt.expectedChanges {
+				got := diff.Changes[i]
+				if got.Address != want.Address || math.Abs(got.Before-want.Before) > 0.01 || math.Abs(got.After-want.After) > 0.01 {
+					t.Errorf("diff.Changes[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewWithRecord_InvalidCandidate(t *testing.T) {
+	candidate := UserPayment{Name: "Broken", Amount: 10, PrePayAddress: "Alice", ShouldPayAddress: []string{}, ExtendPayMsg: []float64{}}
+	if _, _, err := PreviewWithRecord(nil, candidate); err == nil {
+		t.Error("PreviewWithRecord() expected error for candidate without should pay address, got nil")
+	}
+}
+
+func TestDiffSettlement_TwoCurrencies(t *testing.T) {
+	// Alice is owed in TWD and owes in JPY, the balances must not overwrite each other
+	before := Package{TxList: []Tx{
+		{Input: []Payment{{Amount: 100, Address: "Bob", Currency: "TWD"}}, Output: Payment{Amount: 100, Address: "Alice", Currency: "TWD"}},
+		{Input: []Payment{{Amount: 3000, Address: "Alice", Currency: "JPY"}}, Output: Payment{Amount: 3000, Address: "Bob", Currency: "JPY"}},
+	}}
+	after := Package{TxList: []Tx{
+		{Input: []Payment{{Amount: 100, Address: "Bob", Currency: "TWD"}}, Output: Payment{Amount: 100, Address: "Alice", Currency: "TWD"}},
+		{Input: []Payment{{Amount: 1000, Address: "Alice", Currency: "JPY"}}, Output: Payment{Amount: 1000, Address: "Bob", Currency: "JPY"}},
+	}}
+
+	wantBalance := map[string]map[string]float64{
+		"JPY": {"Alice": -3000, "Bob": 3000},
+		"TWD": {"Alice": 100, "Bob": -100},
+	}
+	if got := before.NetBalanceByCurrency(); !reflect.DeepEqual(got, wantBalance) {
+		t.Errorf("NetBalanceByCurrency() = %v, want %v", got, wantBalance)
+	}
+
+	diff := DiffSettlement(before, after)
+	wantChanges := []BalanceChange{
+		{Address: "Alice", Currency: "JPY", Before: -3000, After: -1000},
+		{Address: "Bob", Currency: "JPY", Before: 3000, After: 1000},
+	}
+	if !reflect.DeepEqual(diff.Changes, wantChanges) {
+		t.Errorf("DiffSettlement().Changes = %+v, want %+v, the unchanged TWD balances are not listed", diff.Changes, wantChanges)
+	}
+}
@@ -0,0 +1,77 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// RecordToUserPayment converts a db record and its should pay list to UserPayment
+func RecordToUserPayment(record db.RecordInfo, addresses []db.ExtendAddress) UserPayment {
+	payment := UserPayment{
+		Name:             record.Name,
+		Amount:           record.Amount,
+		PrePayAddress:    string(record.PrePayAddress),
+		ShouldPayAddress: make([]string, len(addresses)),
+		ExtendPayMsg:     make([]float64, len(addresses)),
+		PaymentType:      int(record.Category),
+	}
+	for j, addr := range addresses {
+		payment.ShouldPayAddress[j] = string(addr.Address)
+		payment.ExtendPayMsg[j] = addr.ExtendMsg
+	}
+	if len(record.SplitOverrides) > 0 {
+		payment.ExtendPayMap = make(map[string]float64, len(record.SplitOverrides))
+		for addr, v := range record.SplitOverrides {
+			payment.ExtendPayMap[string(addr)] = v
+		}
+	}
+	return payment
+}
+
+// SettleRemaining settles the records, with should pay lists keyed by record ID, net of the transfers already paid,
+// so only the outstanding transfers are returned. Records without positive amount are skipped.
+// A paid transfer lowers the debt of From and the credit of To, paying more than owed makes To owe the difference back.
+func SettleRemaining(records []db.RecordInfo, shouldPay map[uuid.UUID][]db.ExtendAddress, paidTransfers []Transfer) (Package, error) {
+	uiList := make([]UserPayment, 0, len(records))
+	for _, record := range records {
+		if record.Amount <= 0 {
+			continue
+		}
+		uiList = append(uiList, RecordToUserPayment(record, shouldPay[record.ID]))
+	}
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		return Package{}, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+
+	// a paid transfer works like From pre-paying Amount for To
+	for _, paid := range paidTransfers {
+		if paid.From == "" || paid.To == "" || paid.From == paid.To {
+			return Package{}, fmt.Errorf("paid transfer %s -> %s must be between two addresses", paid.From, paid.To)
+		}
+		if paid.Amount <= 0 {
+			return Package{}, fmt.Errorf("paid transfer %s -> %s amount must be positive", paid.From, paid.To)
+		}
+		txList = append(txList, Tx{
+			Name:   fmt.Sprintf("Paid_%s_to_%s", paid.From, paid.To),
+			Input:  []Payment{{Amount: paid.Amount, Address: paid.To}},
+			Output: Payment{Amount: paid.Amount, Address: paid.From},
+		})
+	}
+
+	txPackage := Package{
+		Name:   "UserPaymentsPackage",
+		TxList: txList,
+	}
+	cashList := NormalizeCash(txPackage.ProcessTransactions())
+	remaining, _, err := CashListToTxPackage(cashList, "remaining", ListTxGenerateWithMixMap)
+	if err != nil {
+		return Package{}, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
+	}
+	remaining.SetNoSmallValue(MinValueTxOutput)
+	remaining.DropZeroTx()
+
+	return remaining, nil
+}
@@ -0,0 +1,112 @@
+package tx
+
+import (
+	"dtm/db/db"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// transfersOf flattens a settlement to paid transfers, sorted for comparison
+func transfersOf(pkg Package) []Transfer {
+	var transfers []Transfer
+	for _, tx := range pkg.TxList {
+		for _, input := range tx.Input {
+			transfers = append(transfers, Transfer{From: input.Address, To: tx.Output.Address, Amount: math.Round(input.Amount*100) / 100})
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].From != transfers[j].From {
+			return transfers[i].From < transfers[j].From
+		}
+		return transfers[i].To < transfers[j].To
+	})
+	return transfers
+}
+
+func remainingTestRecords() ([]db.RecordInfo, map[uuid.UUID][]db.ExtendAddress) {
+	everyone := []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}, {Address: "Carol"}}
+	records := []db.RecordInfo{
+		{ID: uuid.New(), Name: "Hotel", Amount: 300, PrePayAddress: "Alice"},
+		{ID: uuid.New(), Name: "Dinner", Amount: 90, PrePayAddress: "Bob"},
+		{ID: uuid.New(), Name: "Taxi", Amount: 60, PrePayAddress: "Dave"},
+		{ID: uuid.New(), Name: "Cancelled", Amount: 0, PrePayAddress: "Bob"},
+	}
+	shouldPay := map[uuid.UUID][]db.ExtendAddress{
+		records[0].ID: everyone,
+		records[1].ID: everyone,
+		records[2].ID: {{Address: "Carol"}, {Address: "Dave"}},
+	}
+	return records, shouldPay
+}
+
+func TestSettleRemaining(t *testing.T) {
+	records, shouldPay := remainingTestRecords()
+
+	full, err := SettleRemaining(records, shouldPay, nil)
+	if err != nil {
+		t.Fatalf("SettleRemaining() unexpected error: %v", err)
+	}
+	fullTransfers := transfersOf(full)
+	expectedFull := []Transfer{
+		{From: "Bob", To: "Alice", Amount: 10},
+		{From: "Bob", To: "Dave", Amount: 30},
+		{From: "Carol", To: "Alice", Amount: 160},
+	}
+	if !reflect.DeepEqual(fullTransfers, expectedFull) {
+		t.Fatalf("SettleRemaining() without paid transfers = %v, want %v", fullTransfers, expectedFull)
+	}
+
+	t.Run("Paid largest transfer is removed", func(t *testing.T) {
+		remaining, err := SettleRemaining(records, shouldPay, []Transfer{{From: "Carol", To: "Alice", Amount: 160}})
+		if err != nil {
+			t.Fatalf("SettleRemaining() unexpected error: %v", err)
+		}
+		expected := []Transfer{
+			{From: "Bob", To: "Alice", Amount: 10},
+			{From: "Bob", To: "Dave", Amount: 30},
+		}
+		if got := transfersOf(remaining); !reflect.DeepEqual(got, expected) {
+			t.Errorf("SettleRemaining() = %v, want %v", got, expected)
+		}
+	})
+
+	t.Run("Everything paid leaves nothing", func(t *testing.T) {
+		remaining, err := SettleRemaining(records, shouldPay, fullTransfers)
+		if err != nil {
+			t.Fatalf("SettleRemaining() unexpected error: %v", err)
+		}
+		if len(remaining.TxList) != 0 {
+			t.Errorf("SettleRemaining() = %v, want no transfers", remaining.TxList)
+		}
+	})
+
+	t.Run("Overpaid transfer is paid back", func(t *testing.T) {
+		remaining, err := SettleRemaining(records, shouldPay, []Transfer{
+			{From: "Carol", To: "Alice", Amount: 170},
+			{From: "Bob", To: "Dave", Amount: 30},
+		})
+		if err != nil {
+			t.Fatalf("SettleRemaining() unexpected error: %v", err)
+		}
+		expected := []Transfer{{From: "Bob", To: "Carol", Amount: 10}}
+		if got := transfersOf(remaining); !reflect.DeepEqual(got, expected) {
+			t.Errorf("SettleRemaining() = %v, want %v", got, expected)
+		}
+	})
+
+	t.Run("Invalid paid transfer", func(t *testing.T) {
+		for _, paid := range []Transfer{
+			{From: "Carol", To: "Carol", Amount: 10},
+			{From: "", To: "Alice", Amount: 10},
+			{From: "Carol", To: "Alice", Amount: 0},
+		} {
+			if _, err := SettleRemaining(records, shouldPay, []Transfer{paid}); err == nil {
+				t.Errorf("SettleRemaining() with %+v expected error", paid)
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// VerifyRoundTrip settles payments like ShareMoneyEasy and checks the settlement moves exactly the money
+// the payments owe, every address must net to the same balance in the settled package as in the payments.
+// The error lists every address which does not, so the lost or created money can be traced.
+func VerifyRoundTrip(payments []UserPayment) error {
+	txList, err := UIList2TxList(payments)
+	if err != nil {
+		return fmt.Errorf("failed to convert payments: %w", err)
+	}
+	input := Package{TxList: txList}
+
+	settled, remaining, err := ShareMoneyEasy(payments)
+	if err != nil {
+		return fmt.Errorf("failed to settle payments: %w", err)
+	}
+	if remaining > epsilon {
+		return fmt.Errorf("settlement leaves %.2f of inputs unspent", remaining)
+	}
+	want, got := input.NetBalanceByCurrency(), settled.NetBalanceByCurrency()
+	var errs []error
+	for _, currency := range unionKeys(want, got) {
+		if err := compareNetBalance(want[currency], got[currency]); err != nil {
+			if currency != "" {
+				err = fmt.Errorf("in %s: %w", currency, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compareNetBalance returns an error for each address whose settled balance differs from want by more than
+// MinValueTxOutput, the settlement drops transfers below it.
+func compareNetBalance(want, settled map[string]float64) error {
+	var errs []error
+	for _, addr := range unionKeys(want, settled) {
+		if math.Abs(settled[addr]-want[addr]) > MinValueTxOutput {
+			errs = append(errs, fmt.Errorf("address %s nets %.2f after settlement, want %.2f", addr, settled[addr], want[addr]))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package tx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	payments := []UserPayment{
+		{Name: "KTV", Amount: 2334, PrePayAddress: "Alan", ShouldPayAddress: []string{"Alan", "Lisa", "YoYo", "Oreo", "Luis"}},
+		{Name: "alcohol", Amount: 750, PrePayAddress: "Alan", ShouldPayAddress: []string{"Alan", "YoYo", "Luis"}},
+		{Name: "cookie", Amount: 139, PrePayAddress: "Alan", ShouldPayAddress: []string{"Lisa"}},
+		{Name: "Game", Amount: 3500, PrePayAddress: "YoYo", ShouldPayAddress: []string{"Alan", "Lisa", "YoYo", "Oreo", "Luis", "Jay"}},
+		{Name: "hotel", Amount: 100, PrePayAddress: "Jay", ShouldPayAddress: []string{"Alan", "Jay"}, ExtendPayMsg: []float64{30, 70}, PaymentType: 1},
+	}
+	if err := VerifyRoundTrip(payments); err != nil {
+		t.Errorf("VerifyRoundTrip() error = %v", err)
+	}
+
+	for i := range payments {
+		payments[i].Currency = "TWD"
+	}
+	if err := VerifyRoundTrip(payments); err != nil {
+		t.Errorf("VerifyRoundTrip() in TWD error = %v", err)
+	}
+}
+
+func TestVerifyRoundTrip_Inconsistent(t *testing.T) {
+	// the fixed amounts only cover 95 of the 100 paid
+	payments := []UserPayment{
+		{Name: "hotel", Amount: 100, PrePayAddress: "Alan", ShouldPayAddress: []string{"Alan", "Lisa"}, ExtendPayMsg: []float64{45, 50}, PaymentType: 1},
+	}
+	err := VerifyRoundTrip(payments)
+	if err == nil {
+		t.Fatal("VerifyRoundTrip() error = nil, want an error")
+	}
+	if !strings.Contains(err.Error(), "inputs sum 95.00 != output 100.00") {
+		t.Errorf("VerifyRoundTrip() error = %v, want the mismatched sums", err)
+	}
+}
+
+func TestCompareNetBalance(t *testing.T) {
+	want := map[string]float64{"Alan": 50, "Lisa": -50}
+	if err := compareNetBalance(want, map[string]float64{"Alan": 50.004, "Lisa": -50.004}); err != nil {
+		t.Errorf("compareNetBalance() within tolerance error = %v", err)
+	}
+
+	err := compareNetBalance(want, map[string]float64{"Alan": 40, "Lisa": -40, "Oreo": 0.5})
+	if err == nil {
+		t.Fatal("compareNetBalance() error = nil, want an error")
+	}
+	for _, msg := range []string{
+		"address Alan nets 40.00 after settlement, want 50.00",
+		"address Lisa nets -40.00 after settlement, want -50.00",
+		"address Oreo nets 0.50 after settlement, want 0.00",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("compareNetBalance() error = %v, want %q", err, msg)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package tx
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultSplitConfig is the precision of the package level functions, shares are rounded to 2 decimal places.
+var DefaultSplitConfig = SplitConfig{Epsilon: epsilon, DecimalPlaces: 2}
+
+var defaultSplitter = NewSplitter(DefaultSplitConfig)
+
+func NewSplitter(config SplitConfig) *Splitter {
+	return &Splitter{Config: config}
+}
+
+// Unit is the smallest amount of the config, e.g. 0.01 for 2 decimal places and 1 for 0.
+func (c SplitConfig) Unit() float64 {
+	return math.Pow10(-c.DecimalPlaces)
+}
+
+// Round rounds amount to DecimalPlaces.
+func (c SplitConfig) Round(amount float64) float64 {
+	return math.Round(amount/c.Unit()) * c.Unit()
+}
+
+// Settle works like ShareMoneyEasy with the precision of s, transfers below the unit of DecimalPlaces are dropped.
+// The strategy of each PaymentType is picked once, and the slices and maps of a call are kept on s for the next one,
+// so settling many trips in a loop with one Splitter allocates less than repeated ShareMoneyEasy.
+// The returned Package does not share memory with s, calls must be sequential.
+func (s *Splitter) Settle(uiList []UserPayment) (Package, float64, error) {
+	txList, err := appendTxList(s.txList[:0], uiList, s.strategy)
+	if err != nil {
+		return Package{}, 0, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+	s.txList = txList
+
+	cashList := s.settleCash()
+	txPackageFromCash, diff, err := s.settleCashList(cashList, "activity", s.ListTxGenerateWithMixMap)
+	if err != nil {
+		return Package{}, 0, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
+	}
+	txPackageFromCash.SetNoSmallValue(s.Config.Unit())
+	txPackageFromCash.DropZeroTx()
+
+	return txPackageFromCash, diff, nil
+}
+
+// strategy returns the strategy of the enum like ShareMoneyStrategyFactory,
+// the strategies which depend on the precision are the ones of s.
+func (s *Splitter) strategy(strategyEnum int) UserPaymentToTxStrategy {
+	if s.strategies == nil {
+		s.strategies = make([]UserPaymentToTxStrategy, len(ShareMoneyStrategyNames))
+		for i, name := range ShareMoneyStrategyNames {
+			switch name {
+			case "average":
+				s.strategies[i] = s.AverageSplitStrategy
+			case "percentage":
+				s.strategies[i] = s.PercentageSplitStrategy
+			case "count_weighted":
+				s.strategies[i] = s.CountWeightedSplitStrategy
+			default:
+				s.strategies[i] = ShareMoneyStrategyFactory(i)
+			}
+		}
+	}
+	if strategyEnum < 0 || strategyEnum >= len(s.strategies) {
+		return nil
+	}
+	return s.strategies[strategyEnum]
+}
+
+// settleCash is ProcessTransactions followed by NormalizeCash for the txs of s, into the buffers of s.
+// Unlike NormalizeCash the cash is in the order its address first appears.
+func (s *Splitter) settleCash() []Cash {
+	if s.cashIndex == nil {
+		s.cashIndex = make(map[cashKey]int)
+	}
+	clear(s.cashIndex)
+	s.cashList = s.cashList[:0]
+
+	entry := func(p Payment) *Cash {
+		key := cashKey{Address: p.Address, Currency: p.Currency}
+		i, ok := s.cashIndex[key]
+		if !ok {
+			i = len(s.cashList)
+			s.cashIndex[key] = i
+			s.cashList = append(s.cashList, Cash{Address: p.Address, Currency: p.Currency})
+		}
+		return &s.cashList[i]
+	}
+	for _, tx := range s.txList {
+		for _, input := range tx.Input {
+			entry(input).InputAmount += input.Amount
+		}
+		entry(tx.Output).OutputAmount += tx.Output.Amount
+	}
+
+	for i := range s.cashList {
+		s.netCash(&s.cashList[i])
+	}
+	return s.cashList
+}
+
+// NormalizeCash works like the package level NormalizeCash, balances within Epsilon of cancelling out are dropped.
+func (s *Splitter) NormalizeCash(cashList []Cash) []Cash {
+	// Create a map to aggregate amounts by address and currency
+	addressMap := make(map[cashKey]*Cash)
+
+	for _, cash := range cashList {
+		key := cashKey{Address: cash.Address, Currency: cash.Currency}
+		if entry, exists := addressMap[key]; exists {
+			entry.InputAmount += cash.InputAmount
+			entry.OutputAmount += cash.OutputAmount
+		} else {
+			addressMap[key] = &Cash{
+				Address:      cash.Address,
+				InputAmount:  cash.InputAmount,
+				OutputAmount: cash.OutputAmount,
+				Currency:     cash.Currency,
+			}
+		}
+	}
+
+	// merge input and output amounts, the net keeps its sign so negative amounts of malformed data move to the other side
+	result := make([]Cash, 0, len(addressMap))
+	for _, entry := range addressMap {
+		s.netCash(entry)
+		result = append(result, *entry)
+	}
+
+	return result
+}
+
+// netCash merges the input and output amounts of cash into its net balance
+func (s *Splitter) netCash(cash *Cash) {
+	net := cash.InputAmount - cash.OutputAmount
+	// a net within the absolute Epsilon of zero is float noise, any larger balance is kept
+	cash.InputAmount, cash.OutputAmount = 0, 0
+	if net > s.Config.Epsilon {
+		cash.InputAmount = net
+	} else if net < -s.Config.Epsilon {
+		cash.OutputAmount = -net
+	}
+}
+
+// generateQueues works like the package level generateQueues, cash within Epsilon of zero is not queued.
+func (s *Splitter) generateQueues(cashList []Cash, priority AddressPriority) (*list.List, *list.List) {
+	// Use Go's `container/list` as a double-ended queue (deque)
+	// We'll populate temporary slices first, then sort, then push to queues.
+	var tempInputSlice []Cash
+	var tempOutputSlice []Cash
+
+	// Pre-process cashList to populate temporary slices
+	for _, cash := range cashList {
+		if cash.InputAmount > s.Config.Epsilon && cash.InputAmount > cash.OutputAmount { // Only push if there's actual input
+			tempInputSlice = append(tempInputSlice, cash)
+		} else if cash.OutputAmount > s.Config.Epsilon && cash.OutputAmount > cash.InputAmount { // Only push if there's actual output
+			tempOutputSlice = append(tempOutputSlice, cash)
+		}
+		// If both are zero or negative, or one is positive and other negative, it's ignored for this process
+	}
+
+	// sort the input slice by InputAmount, descending, and by address for stable sorting
+	sort.SliceStable(tempInputSlice, func(i, j int) bool {
+		if pi, pj := priority[tempInputSlice[i].Address], priority[tempInputSlice[j].Address]; pi != pj {
+			return pi > pj // Descending order by priority
+		}
+		// Sort by address to ensure stable sorting for same InputAmount
+		if tempInputSlice[i].InputAmount == tempInputSlice[j].InputAmount {
+			return tempInputSlice[i].Address < tempInputSlice[j].Address // Ascending order by address
+		}
+		return tempInputSlice[i].InputAmount > tempInputSlice[j].InputAmount // Descending order by InputAmount
+	})
+	// Sort the output slice by OutputAmount, descending, and by address for stable sorting
+	sort.SliceStable(tempOutputSlice, func(i, j int) bool {
+		if pi, pj := priority[tempOutputSlice[i].Address], priority[tempOutputSlice[j].Address]; pi != pj {
+			return pi > pj // Descending order by priority
+		}
+		// Sort by address to ensure stable sorting for same OutputAmount
+		if tempOutputSlice[i].OutputAmount == tempOutputSlice[j].OutputAmount {
+			return tempOutputSlice[i].Address < tempOutputSlice[j].Address // Ascending order by address
+		}
+		return tempOutputSlice[i].OutputAmount > tempOutputSlice[j].OutputAmount // Descending order by OutputAmount
+	})
+
+	// Repopulate actual queues from sorted slices
+	inputQueue := list.New()
+	for _, cash := range tempInputSlice {
+		inputQueue.PushBack(cash)
+	}
+
+	outputQueue := list.New()
+	for _, cash := range tempOutputSlice {
+		outputQueue.PushBack(cash)
+	}
+
+	return inputQueue, outputQueue
+}
+
+// ListTxGenerateWithMixMap is a ListGenerateStrategy like the package level ListTxGenerateWithMixMap,
+// an output is covered when the inputs are within Epsilon of it.
+func (s *Splitter) ListTxGenerateWithMixMap(txList *[]Tx, cashList *[]Cash) (float64, error) {
+	_, totalRemainingInputAmount, err := s.listTxGenerateWithMixMap(txList, cashList, nil, false)
+	return totalRemainingInputAmount, err
+}
+
+// ListTxGenerateWithPriority returns a strategy like the package level ListTxGenerateWithPriority with the precision of s.
+func (s *Splitter) ListTxGenerateWithPriority(priority AddressPriority) ListGenerateStrategy {
+	return func(txList *[]Tx, cashList *[]Cash) (float64, error) {
+		_, totalRemainingInputAmount, err := s.listTxGenerateWithMixMap(txList, cashList, priority, false)
+		return totalRemainingInputAmount, err
+	}
+}
+
+// ListTxGeneratePartial works like the package level ListTxGeneratePartial with the precision of s.
+func (s *Splitter) ListTxGeneratePartial(txList *[]Tx, cashList *[]Cash) ([]Payment, float64, error) {
+	return s.listTxGenerateWithMixMap(txList, cashList, nil, true)
+}
+
+func (s *Splitter) listTxGenerateWithMixMap(txList *[]Tx, cashList *[]Cash, priority AddressPriority, allowUncovered bool) ([]Payment, float64, error) {
+	var uncovered []Payment
+	var totalRemainingInputAmount float64 = 0.0
+	var inputQueue, outputQueue *list.List = s.generateQueues(*cashList, priority)
+	usedNames := make(map[string]bool, len(*txList))
+	for _, t := range *txList {
+		usedNames[t.Name] = true
+	}
+
+	// Process transactions until all outputs are covered or inputs are exhausted
+
+	for outputQueue.Len() > 0 {
+		currentOutputElem := outputQueue.Front()
+		if currentOutputElem == nil {
+			break
+		}
+		outputQueue.Remove(currentOutputElem)
+		currentOutputCash := currentOutputElem.Value.(Cash) // Type assertion
+
+		// If for some reason output becomes zero or less (shouldn't happen with pre-processing), skip
+		if currentOutputCash.OutputAmount <= s.Config.Epsilon {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Output for %s is zero or negative, skipping.\n", currentOutputCash.Address)
+			continue // Skip this output
+		}
+
+		// Collect inputs to cover the current output
+		var collectedInputs []Payment
+		var currentInputSum float64 = 0.0
+		var sumBeforeLastInput float64 = 0.0 // kept exactly, recomputing it by subtraction drifts and can go over output
+
+		// inputs within Epsilon of output already cover it, avoid taking another input for a float residual
+		for inputQueue.Len() > 0 && currentInputSum < currentOutputCash.OutputAmount-s.Config.Epsilon {
+			currentInputElem := inputQueue.Front()
+			inputQueue.Remove(currentInputElem)
+			if currentInputElem == nil {
+				break
+			}
+			currentInputCash := currentInputElem.Value.(Cash) // Type assertion
+
+			// This is an 'input' for the transaction, so it's an 'output' from the address's perspective
+			collectedInputs = append(collectedInputs, Payment{
+				Amount:   currentInputCash.InputAmount,
+				Address:  currentInputCash.Address,
+				Currency: currentInputCash.Currency,
+			})
+			sumBeforeLastInput = currentInputSum
+			currentInputSum += currentInputCash.InputAmount
+		}
+
+		// We have enough or more inputs to cover currentOutputCash.OutputAmount
+		txOutputPayment := Payment{
+			Amount:   currentOutputCash.OutputAmount,
+			Address:  currentOutputCash.Address,
+			Currency: currentOutputCash.Currency,
+		}
+
+		// Handle the case where collected inputs are exactly equal to output or greater
+		if math.Abs(currentInputSum-currentOutputCash.OutputAmount) < s.Config.Epsilon {
+			// Inputs sum equals output. Use all collected inputs.
+			*txList = append(*txList, Tx{
+				Name:   mixMapTxName(usedNames, collectedInputs, currentOutputCash.Address),
+				Input:  collectedInputs,
+				Output: txOutputPayment,
+			})
+		} else if currentInputSum < currentOutputCash.OutputAmount {
+			// when input can not cover output
+			if allowUncovered {
+				// the output takes all collected inputs, the rest is reported as uncovered
+				if len(collectedInputs) > 0 {
+					*txList = append(*txList, Tx{
+						Name:   mixMapTxName(usedNames, collectedInputs, currentOutputCash.Address),
+						Input:  collectedInputs,
+						Output: Payment{Amount: currentInputSum, Address: currentOutputCash.Address, Currency: currentOutputCash.Currency},
+					})
+				}
+				uncovered = append(uncovered, Payment{
+					Amount:   currentOutputCash.OutputAmount - currentInputSum,
+					Address:  currentOutputCash.Address,
+					Currency: currentOutputCash.Currency,
+				})
+				continue
+			}
+			// This condition should not happen due to pre-processing, but let's handle it gracefully
+			return nil, totalRemainingInputAmount, &ErrOutputsExceedInputs{
+				Address: currentOutputCash.Address,
+				Amount:  currentOutputCash.OutputAmount - currentInputSum,
+				Output:  currentOutputCash.OutputAmount,
+			}
+		} else { // currentInputSum > currentOutputCash.OutputAmount
+			// Inputs sum is greater than output. We need to split the last input.
+			lastInputPayment := collectedInputs[len(collectedInputs)-1]
+			collectedInputs = collectedInputs[:len(collectedInputs)-1] // Remove the last one
+
+			// Amount needed from the last input to exactly cover the output
+			amountNeededFromLastInput := currentOutputCash.OutputAmount - sumBeforeLastInput
+
+			// The part of the last input that goes to the output
+			inputPartForTx := Payment{
+				Amount:   amountNeededFromLastInput,
+				Address:  lastInputPayment.Address,
+				Currency: lastInputPayment.Currency,
+			}
+			collectedInputs = append(collectedInputs, inputPartForTx)
+
+			// The remaining part of the last input goes back to the input queue
+			remainingAmount := lastInputPayment.Amount - amountNeededFromLastInput
+			if remainingAmount > s.Config.Epsilon { // Only push back if there's a significant remainder
+				pushByPriority(inputQueue, Cash{
+					Address:      lastInputPayment.Address,
+					InputAmount:  remainingAmount, // This cash represents an available input
+					OutputAmount: 0.0,
+					Currency:     lastInputPayment.Currency,
+				}, priority)
+			}
+
+			// Create the transaction
+			*txList = append(*txList, Tx{
+				Name:   mixMapTxName(usedNames, collectedInputs, currentOutputCash.Address),
+				Input:  collectedInputs,
+				Output: txOutputPayment,
+			})
+		}
+	}
+
+	// Any remaining inputs in the input queue are considered "unspent" or "leftover"
+
+	for inputQueue.Len() > 0 {
+		inputElem := inputQueue.Front()
+		if inputElem == nil {
+			break
+		}
+		inputQueue.Remove(inputElem)
+		inputCash := inputElem.Value.(Cash)
+		totalRemainingInputAmount += inputCash.InputAmount
+	}
+
+	return uncovered, totalRemainingInputAmount, nil
+}
+
+// CashListToTxPackage works like the package level CashListToTxPackage, remaining inputs over Epsilon fail the package.
+func (s *Splitter) CashListToTxPackage(cashList []Cash, packageName string, strategy ListGenerateStrategy) (Package, float64, error) {
+	txPackage, totalRemainingInputAmount, err := s.settleCashList(cashList, packageName, strategy)
+	if err != nil {
+		return Package{}, 0, err
+	}
+	if totalRemainingInputAmount > s.Config.Epsilon {
+		return Package{}, totalRemainingInputAmount, newErrInputsExceedOutputs(cashList, txPackage.TxList, totalRemainingInputAmount)
+	}
+	return txPackage, totalRemainingInputAmount, nil
+}
+
+// settleCashList works like CashListToTxPackage, but remaining inputs do not fail the package,
+// they are returned for the caller to report, e.g. as a warning of the CLI.
+func (s *Splitter) settleCashList(cashList []Cash, packageName string, strategy ListGenerateStrategy) (Package, float64, error) {
+	if err := s.checkSingleCurrency(cashList); err != nil {
+		return Package{}, 0, err
+	}
+	var generatedTxList []Tx
+	totalRemainingInputAmount, err := strategy(&generatedTxList, &cashList)
+	if err != nil {
+		return Package{}, 0, err
+	}
+	return Package{
+		Name:   packageName,
+		TxList: generatedTxList,
+	}, totalRemainingInputAmount, nil
+}
+
+// CashListToPartialTxPackage works like the package level CashListToPartialTxPackage, remaining inputs over Epsilon fail the package.
+func (s *Splitter) CashListToPartialTxPackage(cashList []Cash, packageName string) (Package, []Payment, float64, error) {
+	if err := s.checkSingleCurrency(cashList); err != nil {
+		return Package{}, nil, 0, err
+	}
+	var generatedTxList []Tx
+	uncovered, totalRemainingInputAmount, err := s.ListTxGeneratePartial(&generatedTxList, &cashList)
+	if err != nil {
+		return Package{}, nil, 0, err
+	}
+	if totalRemainingInputAmount > s.Config.Epsilon {
+		return Package{}, nil, totalRemainingInputAmount, newErrInputsExceedOutputs(cashList, generatedTxList, totalRemainingInputAmount)
+	}
+
+	return Package{
+		Name:   packageName,
+		TxList: generatedTxList,
+	}, uncovered, totalRemainingInputAmount, nil
+}
+
+// checkSingleCurrency works like the package level checkSingleCurrency, cash within Epsilon of zero has no balance.
+func (s *Splitter) checkSingleCurrency(cashList []Cash) error {
+	seen := make(map[string]bool)
+	var currencies []string
+	for _, cash := range cashList {
+		if cash.InputAmount <= s.Config.Epsilon && cash.OutputAmount <= s.Config.Epsilon {
+			continue
+		}
+		if !seen[cash.Currency] {
+			seen[cash.Currency] = true
+			currencies = append(currencies, cash.Currency)
+		}
+	}
+	if len(currencies) > 1 {
+		sort.Strings(currencies)
+		return fmt.Errorf("%w: %s, settle each currency with CashListToTxPackagesByCurrency or convert them first",
+			ErrMixedCurrency, strings.Join(currencies, ", "))
+	}
+	return nil
+}
+
+// AverageSplitStrategy splits up.Amount evenly like the package level AverageSplitStrategy,
+// the shares are rounded to the DecimalPlaces of s, e.g. whole yen for DecimalPlaces 0.
+func (s *Splitter) AverageSplitStrategy(up *UserPayment) (Tx, error) {
+	// first check
+	if len(up.ShouldPayAddress) == 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' must have at least one ShouldPayAddress for AverageSplitStrategy", up.Name)
+	}
+
+	// Create the transaction
+	tx := Tx{
+		Name:  up.Name,
+		Input: []Payment{},
+		Output: Payment{
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
+		},
+	}
+
+	// should pay user split output as input, each share is rounded to the unit of DecimalPlaces,
+	// the remaining units go one by one to the first recipients, and the first recipient also takes
+	// whatever is below the precision, so the inputs always sum to the output
+	unit := s.Config.Unit()
+	units := int64(math.Round(up.Amount / unit))
+	count := int64(len(up.ShouldPayAddress))
+	shares := make([]float64, count)
+	allocated := 0.0
+	for i := count - 1; i > 0; i-- {
+		shareUnits := units / count
+		if i < units%count {
+			shareUnits++
+		}
+		shares[i] = float64(shareUnits) * unit
+		allocated += shares[i]
+	}
+	shares[0] = up.Amount - allocated
+	for i, u := range up.ShouldPayAddress {
+		tx.Input = append(tx.Input, Payment{
+			Amount:   shares[i],
+			Address:  u,
+			Currency: up.Currency,
+		})
+	}
+
+	return tx, nil
+}
+
+// PartMoneyReconcileSplitStrategy works like the package level PartMoneyReconcileSplitStrategy,
+// the shares are rounded to the DecimalPlaces of s instead of cents.
+func (s *Splitter) PartMoneyReconcileSplitStrategy(up *UserPayment) (Tx, error) {
+	tx, err := PartMoneySplitStrategy(up)
+	if err != nil {
+		return Tx{}, err
+	}
+
+	last := -1
+	for i, u := range up.ExtendPayMsg {
+		if u > 0 {
+			last = i
+		}
+	}
+
+	allocated := 0.0
+	for i := range tx.Input {
+		if i == last {
+			continue
+		}
+		tx.Input[i].Amount = s.Config.Round(tx.Input[i].Amount)
+		allocated += tx.Input[i].Amount
+	}
+	tx.Input[last].Amount = up.Amount - allocated
+	if tx.Input[last].Amount < 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' rounding residual makes last share negative", up.Name)
+	}
+
+	return tx, nil
+}
+
+// CountWeightedSplitStrategy works like the package level CountWeightedSplitStrategy,
+// the shares are rounded down to the DecimalPlaces of s instead of cents.
+func (s *Splitter) CountWeightedSplitStrategy(up *UserPayment) (Tx, error) {
+	// first check
+	if len(up.ShouldPayAddress) == 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' must have at least one ShouldPayAddress for CountWeightedSplitStrategy", up.Name)
+	}
+	if len(up.ExtendPayMsg) != len(up.ShouldPayAddress) {
+		return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg must have the same length as ShouldPayAddress for CountWeightedSplitStrategy", up.Name)
+	}
+	totalWeight := int64(0)
+	top := 0
+	for i, u := range up.ExtendPayMsg {
+		if u < 0 || u != math.Trunc(u) || u > math.MaxInt32 {
+			return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg weight %v must be a non-negative integer", up.Name, u)
+		}
+		totalWeight += int64(u)
+		if u > up.ExtendPayMsg[top] {
+			top = i
+		}
+	}
+	if totalWeight == 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg weights must have a positive sum", up.Name)
+	}
+	if up.Amount < 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' amount must be non-negative for CountWeightedSplitStrategy", up.Name)
+	}
+
+	// Create the transaction
+	tx := Tx{
+		Name:  up.Name,
+		Input: []Payment{},
+		Output: Payment{
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
+		},
+	}
+
+	// shares are counted in units of DecimalPlaces, the highest weight takes the units left by rounding down
+	// and whatever is below the precision
+	unit := s.Config.Unit()
+	units := int64(math.Round(up.Amount / unit))
+	allocated := 0.0
+	shares := make([]float64, len(up.ShouldPayAddress))
+	for i, u := range up.ExtendPayMsg {
+		if i == top {
+			continue
+		}
+		// units*weight may not fit in 64 bits, the quotient does as weight <= totalWeight
+		hi, lo := bits.Mul64(uint64(units), uint64(u))
+		shareUnits, _ := bits.Div64(hi, lo, uint64(totalWeight))
+		shares[i] = float64(shareUnits) * unit
+		allocated += shares[i]
+	}
+	shares[top] = up.Amount - allocated
+	for i, u := range up.ShouldPayAddress {
+		tx.Input = append(tx.Input, Payment{
+			Amount:   shares[i],
+			Address:  u,
+			Currency: up.Currency,
+		})
+	}
+
+	return tx, nil
+}
+
+// PercentageSplitStrategy works like the package level PercentageSplitStrategy,
+// the percentages must sum to 100 within the Epsilon of s and are rescaled to sum to exactly 100,
+// so the inputs cover up.Amount.
+func (s *Splitter) PercentageSplitStrategy(up *UserPayment) (Tx, error) {
+	// first check
+	if len(up.ShouldPayAddress) == 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' must have at least one ShouldPayAddress for PercentageSplitStrategy", up.Name)
+	}
+	if len(up.ExtendPayMsg) != len(up.ShouldPayAddress) {
+		return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg must have the same length as ShouldPayAddress for PercentageSplitStrategy", up.Name)
+	}
+	sumOfPercentage := 0.0
+	for _, u := range up.ExtendPayMsg {
+		if u < 0 {
+			return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg must be non-negative", up.Name)
+		}
+		sumOfPercentage += u
+	}
+	if math.Abs(sumOfPercentage-100) > s.Config.Epsilon {
+		return Tx{}, fmt.Errorf("UserPayment '%s' ExtendPayMsg percentages sum to %v, must be 100", up.Name, sumOfPercentage)
+	}
+
+	// Create the transaction
+	tx := Tx{
+		Name:  up.Name,
+		Input: []Payment{},
+		Output: Payment{
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
+		},
+	}
+
+	// should pay user split output as input
+	for i, u := range up.ShouldPayAddress {
+		tx.Input = append(tx.Input, Payment{
+			Amount:   up.Amount * up.ExtendPayMsg[i] / sumOfPercentage,
+			Address:  u,
+			Currency: up.Currency,
+		})
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,113 @@
+package tx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitter_Settle(t *testing.T) {
+	sample := readSampleInput(t)
+	mixed := []UserPayment{
+		{Name: "Hotel", Amount: 1000, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}},
+		{Name: "Taxi", Amount: 300, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Carol"}, ExtendPayMsg: []float64{60, 40}, PaymentType: 5},
+		{Name: "Dinner", Amount: 450, PrePayAddress: "Carol", ShouldPayAddress: []string{"Alice", "Bob"}, ExtendPayMsg: []float64{1, 2}, PaymentType: 2},
+		{Name: "Draft", Amount: 99, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob"}, Placeholder: true},
+	}
+
+	splitter := NewSplitter(DefaultSplitConfig)
+	var settled []Package
+	for _, uiList := range [][]UserPayment{sample, mixed, sample} {
+		got, gotDiff, err := splitter.Settle(uiList)
+		if err != nil {
+			t.Fatalf("Settle() unexpected error: %v", err)
+		}
+		want, wantDiff, err := ShareMoneyEasy(uiList)
+		if err != nil {
+			t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) || gotDiff != wantDiff {
+			t.Errorf("Settle() = %v, %v, want the ShareMoneyEasy result %v, %v", got, gotDiff, want, wantDiff)
+		}
+		settled = append(settled, got)
+	}
+
+	// reused buffers must not leak into an earlier result
+	if !reflect.DeepEqual(settled[0], settled[2]) {
+		t.Errorf("first Settle() result changed to %v by later calls, want %v", settled[0], settled[2])
+	}
+
+	t.Run("Errors are returned", func(t *testing.T) {
+		unknown := []UserPayment{{Name: "Unknown", Amount: 10, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob"}, PaymentType: 99}}
+		if _, _, err := splitter.Settle(unknown); err == nil {
+			t.Error("Settle() expected error for unknown PaymentType, got nil")
+		}
+		if _, _, err := splitter.Settle(mixed); err != nil {
+			t.Errorf("Settle() after an error unexpected error: %v", err)
+		}
+	})
+}
+
+func TestSplitter_CashListToPartialTxPackage(t *testing.T) {
+	// Alice has 1e-7 more to give than Bob is owed, float noise for a coarse Epsilon only
+	cashList := []Cash{{Address: "Alice", InputAmount: 10.0000001}, {Address: "Bob", OutputAmount: 10}}
+
+	if _, _, _, err := CashListToPartialTxPackage(cashList, "PartialPack"); err == nil {
+		t.Error("CashListToPartialTxPackage() expected error for remaining input over the default epsilon, got nil")
+	}
+	splitter := NewSplitter(SplitConfig{Epsilon: 1e-6, DecimalPlaces: 2})
+	pkg, uncovered, remaining, err := splitter.CashListToPartialTxPackage(cashList, "PartialPack")
+	if err != nil {
+		t.Fatalf("CashListToPartialTxPackage() unexpected error: %v", err)
+	}
+	if len(pkg.TxList) != 1 || len(uncovered) != 0 || remaining != 0 {
+		t.Errorf("CashListToPartialTxPackage() = %v, %v, %v, want one tx and nothing left", pkg.TxList, uncovered, remaining)
+	}
+}
+
+func TestSplitter_NormalizeCash_AbsoluteEpsilon(t *testing.T) {
+	splitter := NewSplitter(SplitConfig{Epsilon: 0.5, DecimalPlaces: 0})
+	got := splitter.NormalizeCash([]Cash{
+		{Address: "A", InputAmount: 1000},
+		{Address: "A", OutputAmount: 600},
+		{Address: "B", OutputAmount: 400},
+		{Address: "C", InputAmount: 0.3},
+	})
+	want := map[string]Cash{
+		"A": {Address: "A", InputAmount: 400},
+		"B": {Address: "B", OutputAmount: 400},
+		"C": {Address: "C"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("NormalizeCash() = %v, want %v", got, want)
+	}
+	for _, cash := range got {
+		if !reflect.DeepEqual(cash, want[cash.Address]) {
+			t.Errorf("NormalizeCash() %s = %+v, want %+v", cash.Address, cash, want[cash.Address])
+		}
+	}
+}
+
+func BenchmarkSplitter_Settle(b *testing.B) {
+	payments := readSampleInput(b)
+	splitter := NewSplitter(DefaultSplitConfig)
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 1000 {
+			if _, _, err := splitter.Settle(payments); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkShareMoneyEasy(b *testing.B) {
+	payments := readSampleInput(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 1000 {
+			if _, _, err := ShareMoneyEasy(payments); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package tx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PerPersonStatements splits the settlement into one statement per address, every transfer of pkg shows up
+// in the statement of the payer and of the receiver. Empty and self transfers need no action and are skipped.
+func PerPersonStatements(pkg Package) map[string]PersonStatement {
+	statements := make(map[string]PersonStatement)
+	for _, tx := range pkg.TxList {
+		for _, input := range tx.Input {
+			if input.Amount <= epsilon || input.Address == tx.Output.Address {
+				continue
+			}
+			payer := statements[input.Address]
+			payer.Address = input.Address
+			payer.Pays = append(payer.Pays, StatementLine{Counterparty: tx.Output.Address, Amount: input.Amount})
+			payer.TotalPay += input.Amount
+			statements[input.Address] = payer
+
+			receiver := statements[tx.Output.Address]
+			receiver.Address = tx.Output.Address
+			receiver.Receives = append(receiver.Receives, StatementLine{Counterparty: input.Address, Amount: input.Amount})
+			receiver.TotalReceive += input.Amount
+			statements[tx.Output.Address] = receiver
+		}
+	}
+	return statements
+}
+
+// Net returns what the address ends up with, positive means it receives money, negative means it pays.
+func (s PersonStatement) Net() float64 {
+	return s.TotalReceive - s.TotalPay
+}
+
+// String renders the statement as a short message to send to the person.
+func (s PersonStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("Statement: " + s.Address + "\n")
+	for _, line := range s.Pays {
+		sb.WriteString(fmt.Sprintf("  - pay %s: %.2f\n", line.Counterparty, line.Amount))
+	}
+	for _, line := range s.Receives {
+		sb.WriteString(fmt.Sprintf("  - receive from %s: %.2f\n", line.Counterparty, line.Amount))
+	}
+	sb.WriteString(fmt.Sprintf("  Total pay: %.2f, total receive: %.2f\n", s.TotalPay, s.TotalReceive))
+	return sb.String()
+}
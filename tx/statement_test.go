@@ -0,0 +1,114 @@
+package tx
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readSampleInput reads the sample of the README, see sampleInput.csv in the repo root.
+func readSampleInput(t testing.TB) []UserPayment {
+	t.Helper()
+	file, err := os.Open("../sampleInput.csv")
+	if err != nil {
+		t.Fatalf("failed to open sample input: %v", err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read sample input: %v", err)
+	}
+
+	var payments []UserPayment
+	for _, row := range rows[1:] {
+		amount, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			t.Fatalf("invalid amount %q: %v", row[1], err)
+		}
+		shouldPay := strings.Split(row[3], ",")
+		payments = append(payments, UserPayment{
+			Name:             row[0],
+			Amount:           amount,
+			PrePayAddress:    row[2],
+			ShouldPayAddress: shouldPay,
+			ExtendPayMsg:     make([]float64, len(shouldPay)),
+		})
+	}
+	return payments
+}
+
+func TestPerPersonStatements(t *testing.T) {
+	payments := readSampleInput(t)
+	pkg, _, err := ShareMoneyEasy(payments)
+	if err != nil {
+		t.Fatalf("ShareMoneyEasy() error = %v", err)
+	}
+	txList, err := UIList2TxList(payments)
+	if err != nil {
+		t.Fatalf("UIList2TxList() error = %v", err)
+	}
+	positions := Package{TxList: txList}
+	cashList := NormalizeCash(positions.ProcessTransactions())
+
+	statements := PerPersonStatements(pkg)
+
+	// each statement nets to the settlement position, money paid upfront minus own share
+	for _, cash := range cashList {
+		position := cash.OutputAmount - cash.InputAmount
+		if net := statements[cash.Address].Net(); math.Abs(net-position) > MinValueTxOutput {
+			t.Errorf("statement of %s nets to %.2f, want %.2f", cash.Address, net, position)
+		}
+	}
+
+	// every transfer is paid in exactly one statement and received in exactly one statement
+	type transfer struct {
+		from, to string
+		amount   float64
+	}
+	expected := map[transfer]int{}
+	for _, tx := range pkg.TxList {
+		for _, input := range tx.Input {
+			expected[transfer{input.Address, tx.Output.Address, input.Amount}]++
+		}
+	}
+	paid, received := map[transfer]int{}, map[transfer]int{}
+	for address, statement := range statements {
+		if statement.Address != address {
+			t.Errorf("statement keyed by %s has address %s", address, statement.Address)
+		}
+		for _, line := range statement.Pays {
+			paid[transfer{address, line.Counterparty, line.Amount}]++
+		}
+		for _, line := range statement.Receives {
+			received[transfer{line.Counterparty, address, line.Amount}]++
+		}
+	}
+	for key, count := range expected {
+		if paid[key] != count || received[key] != count {
+			t.Errorf("transfer %+v is paid %d and received %d times, want %d", key, paid[key], received[key], count)
+		}
+	}
+	if len(paid) != len(expected) || len(received) != len(expected) {
+		t.Errorf("statements have %d paid and %d received transfers, want %d", len(paid), len(received), len(expected))
+	}
+
+	lisa := statements["Lisa"]
+	if !strings.Contains(lisa.String(), "pay YoYo: 1547.81") {
+		t.Errorf("unexpected statement of Lisa:\n%s", lisa.String())
+	}
+}
+
+func TestPerPersonStatements_SkipsSelfAndEmptyTransfers(t *testing.T) {
+	pkg := Package{TxList: []Tx{{
+		Name:   "Tx_M_A+B+C_to_A",
+		Input:  []Payment{{Amount: 5, Address: "A"}, {Amount: 0, Address: "B"}, {Amount: 3, Address: "C"}},
+		Output: Payment{Amount: 8, Address: "A"},
+	}}}
+	statements := PerPersonStatements(pkg)
+	if len(statements) != 2 || statements["A"].TotalReceive != 3 || statements["C"].TotalPay != 3 {
+		t.Errorf("PerPersonStatements() = %+v, want only C pays A 3", statements)
+	}
+}
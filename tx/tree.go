@@ -0,0 +1,86 @@
+package tx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ListTxGenerateTree settles the balances as a forest: each debtor pays exactly one creditor, and creditors
+// chained by size pass on what they collected above or below their credit to the next creditor.
+// The transfers never form a cycle, so who pays whom is easy to follow, but it may take more transfers
+// than ListTxGenerateWithMixMap. Balances which do not sum to zero are settled by ListTxGenerateWithMixMap instead.
+func ListTxGenerateTree(txList *[]Tx, cashList *[]Cash) (float64, error) {
+	var debtors, creditors []Cash
+	totalDebt, totalCredit := 0.0, 0.0
+	for _, cash := range NormalizeCash(*cashList) {
+		if cash.InputAmount > epsilon {
+			debtors = append(debtors, cash)
+			totalDebt += cash.InputAmount
+		} else if cash.OutputAmount > epsilon {
+			creditors = append(creditors, cash)
+			totalCredit += cash.OutputAmount
+		}
+	}
+	if math.Abs(totalDebt-totalCredit) > epsilon*math.Max(1, totalDebt) {
+		return ListTxGenerateWithMixMap(txList, cashList)
+	}
+	if len(creditors) == 0 {
+		return 0, nil
+	}
+
+	// largest first, by address for the same amount
+	sort.Slice(debtors, func(i, j int) bool {
+		if debtors[i].InputAmount == debtors[j].InputAmount {
+			return debtors[i].Address < debtors[j].Address
+		}
+		return debtors[i].InputAmount > debtors[j].InputAmount
+	})
+	sort.Slice(creditors, func(i, j int) bool {
+		if creditors[i].OutputAmount == creditors[j].OutputAmount {
+			return creditors[i].Address < creditors[j].Address
+		}
+		return creditors[i].OutputAmount > creditors[j].OutputAmount
+	})
+
+	// each debtor pays the creditor which still needs the most
+	received := make([]float64, len(creditors))
+	inputs := make([][]Payment, len(creditors))
+	for _, debtor := range debtors {
+		best := 0
+		for i := range creditors {
+			if creditors[i].OutputAmount-received[i] > creditors[best].OutputAmount-received[best]+epsilon {
+				best = i
+			}
+		}
+		received[best] += debtor.InputAmount
+		inputs[best] = append(inputs[best], Payment{Amount: debtor.InputAmount, Address: debtor.Address, Currency: debtor.Currency})
+	}
+
+	// the chain edge between creditor i and i+1 carries what creditors 0..i collected above their credit
+	surplus := 0.0
+	for i := 0; i < len(creditors)-1; i++ {
+		surplus += received[i] - creditors[i].OutputAmount
+		if surplus > epsilon {
+			inputs[i+1] = append(inputs[i+1], Payment{Amount: surplus, Address: creditors[i].Address, Currency: creditors[i].Currency})
+		} else if surplus < -epsilon {
+			inputs[i] = append(inputs[i], Payment{Amount: -surplus, Address: creditors[i+1].Address, Currency: creditors[i+1].Currency})
+		}
+	}
+
+	for i, creditor := range creditors {
+		if len(inputs[i]) == 0 {
+			continue
+		}
+		amount := 0.0
+		for _, input := range inputs[i] {
+			amount += input.Amount
+		}
+		*txList = append(*txList, Tx{
+			Name:   fmt.Sprintf("Tx_T_to_%s", creditor.Address),
+			Input:  inputs[i],
+			Output: Payment{Amount: amount, Address: creditor.Address, Currency: creditor.Currency},
+		})
+	}
+	return 0, nil
+}
@@ -0,0 +1,96 @@
+package tx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// assertForest checks the transfers of pkg form no cycle and every debtor of cashList pays exactly once.
+func assertForest(t *testing.T, cashList []Cash, pkg Package) {
+	t.Helper()
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(a string) string {
+		if p, ok := parent[a]; ok && p != a {
+			root := find(p)
+			parent[a] = root
+			return root
+		}
+		parent[a] = a
+		return a
+	}
+	payments := map[string]int{}
+	for _, tx := range pkg.TxList {
+		for _, input := range tx.Input {
+			payments[input.Address]++
+			from, to := find(input.Address), find(tx.Output.Address)
+			if from == to {
+				t.Errorf("transfer %s -> %s closes a cycle", input.Address, tx.Output.Address)
+			}
+			parent[from] = to
+		}
+	}
+	for _, cash := range NormalizeCash(cashList) {
+		if cash.InputAmount > epsilon && payments[cash.Address] != 1 {
+			t.Errorf("debtor %s pays %d times, want 1", cash.Address, payments[cash.Address])
+		}
+	}
+}
+
+func TestListTxGenerateTree(t *testing.T) {
+	t.Run("Debtors pay one creditor and creditors pass on the rest", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "A", InputAmount: 70},
+			{Address: "B", InputAmount: 20},
+			{Address: "C", InputAmount: 10},
+			{Address: "D", OutputAmount: 60},
+			{Address: "E", OutputAmount: 40},
+		}
+		var txList []Tx
+		remaining, err := ListTxGenerateTree(&txList, &cashList)
+		if err != nil || !floatEquals(remaining, 0) {
+			t.Fatalf("ListTxGenerateTree() remaining = %v, error = %v", remaining, err)
+		}
+		// A covers D and passes 10 to E, B and C pay E
+		expected := []Tx{
+			{Name: "Tx_T_to_D", Input: []Payment{{Amount: 70, Address: "A"}}, Output: Payment{Amount: 70, Address: "D"}},
+			{Name: "Tx_T_to_E", Input: []Payment{{Amount: 20, Address: "B"}, {Amount: 10, Address: "C"}, {Amount: 10, Address: "D"}}, Output: Payment{Amount: 40, Address: "E"}},
+		}
+		if !reflect.DeepEqual(txList, expected) {
+			t.Errorf("ListTxGenerateTree() = %v, want %v", txList, expected)
+		}
+		pkg := Package{TxList: txList}
+		assertForest(t, cashList, pkg)
+		assertReconciles(t, cashList, pkg)
+	})
+
+	t.Run("Random balances are settled as a forest", func(t *testing.T) {
+		for seed := int64(0); seed < 50; seed++ {
+			cashList := randomBalancedCashList(seed, 1+int(seed%15), 1+int(seed%3))
+			pkg, remaining, err := CashListToTxPackage(cashList, "tree", ListTxGenerateTree)
+			if err != nil || !floatEquals(remaining, 0) {
+				t.Fatalf("seed %d: remaining = %v, error = %v", seed, remaining, err)
+			}
+			for _, tx := range pkg.TxList {
+				if err := tx.ValidateDetailed(); err != nil {
+					t.Errorf("seed %d: %v", seed, err)
+				}
+			}
+			assertForest(t, cashList, pkg)
+			assertReconciles(t, cashList, pkg)
+		}
+	})
+
+	t.Run("Unbalanced falls back to mix map", func(t *testing.T) {
+		cashList := []Cash{
+			{Address: "A", InputAmount: 10},
+			{Address: "B", InputAmount: 5},
+			{Address: "C", OutputAmount: 10},
+		}
+		var txList []Tx
+		remaining, err := ListTxGenerateTree(&txList, &cashList)
+		if err != nil || !floatEquals(remaining, 5) {
+			t.Errorf("ListTxGenerateTree() remaining = %v, error = %v, want 5 remaining", remaining, err)
+		}
+	})
+}
@@ -3,6 +3,8 @@ package tx
 import (
 	"fmt"
 	"math"
+	"slices"
+	"sort"
 )
 
 const MinValueTxOutput = 0.01
@@ -22,31 +24,57 @@ func (t *Tx) Validate() (float64, float64) {
 
 // BoolValidate checks if the transaction is valid by ensuring that the total input amount
 func (t *Tx) BoolValidate() bool {
+	return t.ValidateDetailed() == nil
+}
+
+// IsSelfPayment reports whether every input of the transaction is its output address, e.g. a record
+// whose prepayer is the sole should pay address. Such a transaction nets to zero and moves no money.
+func (t *Tx) IsSelfPayment() bool {
+	for _, p := range t.Input {
+		if p.Address != t.Output.Address {
+			return false
+		}
+	}
+	return len(t.Input) > 0
+}
+
+// ValidateDetailed works like BoolValidate, but returns an error telling why the transaction is invalid.
+func (t *Tx) ValidateDetailed() error {
 	totalInputAmount, totalOutputAmount := t.Validate()
-	if totalInputAmount < epsilon || totalOutputAmount < epsilon {
-		return false // No inputs and outputs, considered invalid
+	if totalInputAmount < epsilon {
+		return fmt.Errorf("tx %s has no inputs", t.Name)
+	}
+	if totalOutputAmount < epsilon {
+		return fmt.Errorf("tx %s has no output", t.Name)
+	}
+	for _, p := range t.Input {
+		if p.Currency != t.Output.Currency {
+			return fmt.Errorf("%w: tx %s input of %s in %q, output in %q",
+				ErrMixedCurrency, t.Name, p.Address, p.Currency, t.Output.Currency)
+		}
 	}
 	if math.Abs(totalInputAmount-totalOutputAmount) > epsilon {
-		return false // Input and output amounts do not match
+		return fmt.Errorf("tx %s inputs sum %.2f != output %.2f", t.Name, totalInputAmount, totalOutputAmount)
 	}
-	return true // Valid transaction
+	return nil
 }
 
-// ProcessTransactions calculates the total input and output amounts for each address
+// ProcessTransactions calculates the total input and output amounts for each address and currency
 // within the TxList of the TxPackage, and returns a slice of Cash objects.
 func (tp *Package) ProcessTransactions() []Cash {
-	// Use a map to aggregate amounts by address
-	// The key is the address (string), and the value is a pointer to a Cash struct.
+	// Use a map to aggregate amounts by address and currency
+	// The key is the cashKey, and the value is a pointer to a Cash struct.
 	// Using a pointer allows us to modify the struct fields directly.
-	addressCashMap := make(map[string]*Cash)
+	addressCashMap := make(map[cashKey]*Cash)
 
 	// Helper function to get or create a Cash entry for an address
-	getCashEntry := func(addr string) *Cash {
-		if entry, ok := addressCashMap[addr]; ok {
+	getCashEntry := func(p Payment) *Cash {
+		key := cashKey{Address: p.Address, Currency: p.Currency}
+		if entry, ok := addressCashMap[key]; ok {
 			return entry
 		}
-		newEntry := &Cash{Address: addr} // Create a new Cash struct
-		addressCashMap[addr] = newEntry  // Store it in the map
+		newEntry := &Cash{Address: p.Address, Currency: p.Currency} // Create a new Cash struct
+		addressCashMap[key] = newEntry                              // Store it in the map
 		return newEntry
 	}
 
@@ -54,13 +82,13 @@ func (tp *Package) ProcessTransactions() []Cash {
 		// Process Inputs (amounts leaving an address)
 		for _, inputPayment := range tx.Input {
 			// Get or create the Cash entry for the input address
-			entry := getCashEntry(inputPayment.Address)
+			entry := getCashEntry(inputPayment)
 			entry.InputAmount += inputPayment.Amount
 		}
 
 		// Process Output (amount arriving at an address)
 		// Get or create the Cash entry for the output address
-		outputEntry := getCashEntry(tx.Output.Address)
+		outputEntry := getCashEntry(tx.Output)
 		outputEntry.OutputAmount += tx.Output.Amount
 	}
 
@@ -112,6 +140,40 @@ func (tp *Package) SetNoSmallValue(minValue float64) {
 	}
 }
 
+// RoundTransfers rounds every input of the package to a multiple of increment in the given direction,
+// and sets the output of each tx to the sum of its rounded inputs.
+// It returns the accumulated residual, which is the credit left uncovered for RoundFavorDebtor,
+// or the amount collected above the credit for RoundFavorCreditor.
+// Inputs rounded to zero are kept, use DropZeroTx after it.
+func (tp *Package) RoundTransfers(increment float64, direction RoundingDirection) (float64, error) {
+	if increment < MinValueTxOutput {
+		return 0, fmt.Errorf("rounding increment %.2f is smaller than %.2f", increment, MinValueTxOutput)
+	}
+	if direction != RoundFavorDebtor && direction != RoundFavorCreditor {
+		return 0, fmt.Errorf("unsupported rounding direction %d", direction)
+	}
+	roundFn := func(amount float64) float64 {
+		// tolerate float noise like 30.000000001 before flooring, or 29.999999999 before ceiling
+		if direction == RoundFavorCreditor {
+			return math.Ceil(amount/increment-epsilon) * increment
+		}
+		return math.Floor(amount/increment+epsilon) * increment
+	}
+
+	residual := 0.0
+	for i := range tp.TxList {
+		tx := &tp.TxList[i]
+		rounded := 0.0
+		for j := range tx.Input {
+			tx.Input[j].Amount = roundFn(tx.Input[j].Amount)
+			rounded += tx.Input[j].Amount
+		}
+		residual += math.Abs(rounded - tx.Output.Amount)
+		tx.Output.Amount = rounded
+	}
+	return residual, nil
+}
+
 // DropZeroTx can drop the tx with zero output or tx.input is zero
 // can be used after SetNoSmallValue
 func (tp *Package) DropZeroTx() {
@@ -136,25 +198,97 @@ func (tp *Package) DropZeroTx() {
 
 // UIList2TxList converts a list of UserPayment to a list of Tx
 func UIList2TxList(uiList []UserPayment) ([]Tx, error) {
-	txList := make([]Tx, 0, len(uiList))
+	return appendTxList(make([]Tx, 0, len(uiList)), uiList, ShareMoneyStrategyFactory)
+}
+
+// appendTxList appends the Tx of each UserPayment to txList, strategyOf returns the strategy of a PaymentType
+func appendTxList(txList []Tx, uiList []UserPayment, strategyOf func(strategyEnum int) UserPaymentToTxStrategy) ([]Tx, error) {
 	for _, up := range uiList {
-		tx, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType))
+		if up.Placeholder {
+			continue
+		}
+		tx, err := up.ToTx(strategyOf(up.PaymentType))
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert UserPayment to Tx: %w", err)
 		}
-		if !tx.BoolValidate() {
-			return nil, fmt.Errorf("invalid transaction: %s", tx.Name)
+		if err := tx.ValidateDetailed(); err != nil {
+			return nil, fmt.Errorf("invalid transaction: %w", err)
+		}
+		if tx.IsSelfPayment() {
+			// a record paid back by its own prepayer is a no-op, FindSelfPayments lets the caller warn about it
+			continue
 		}
 		txList = append(txList, tx)
 	}
 	return txList, nil
 }
 
-// ShareMoneyEasy is a simplified version of ShareMoneyEasy without logging
-func ShareMoneyEasy(uiList []UserPayment) (Package, float64, error) {
+// UIList2TxListByPrePayer works like UIList2TxList, then merges the txs by MergeTxByPrePayer,
+// so a payer of many small records is one creditor position instead of one per record.
+func UIList2TxListByPrePayer(uiList []UserPayment) ([]Tx, error) {
 	txList, err := UIList2TxList(uiList)
 	if err != nil {
-		return Package{}, 0, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+		return nil, err
+	}
+	return MergeTxByPrePayer(txList), nil
+}
+
+// MergeTxByPrePayer coalesces the txs paid by the same output address in the same currency into one tx,
+// inputs of the same address are summed and the name joins the merged tx names to keep the provenance.
+// Merged txs are in the order their payer first appears, inputs in the order their address first appears.
+func MergeTxByPrePayer(txList []Tx) []Tx {
+	merged := make([]Tx, 0, len(txList))
+	txIndex := make(map[cashKey]int)
+	inputIndex := make([]map[cashKey]int, 0, len(txList))
+	for _, tx := range txList {
+		outputKey := cashKey{Address: tx.Output.Address, Currency: tx.Output.Currency}
+		i, ok := txIndex[outputKey]
+		if !ok {
+			i = len(merged)
+			txIndex[outputKey] = i
+			merged = append(merged, Tx{Name: tx.Name, Output: Payment{Address: tx.Output.Address, Currency: tx.Output.Currency}})
+			inputIndex = append(inputIndex, make(map[cashKey]int))
+		} else {
+			merged[i].Name += ", " + tx.Name
+		}
+		merged[i].Output.Amount += tx.Output.Amount
+		for _, input := range tx.Input {
+			inputKey := cashKey{Address: input.Address, Currency: input.Currency}
+			j, ok := inputIndex[i][inputKey]
+			if !ok {
+				j = len(merged[i].Input)
+				inputIndex[i][inputKey] = j
+				merged[i].Input = append(merged[i].Input, Payment{Address: input.Address, Currency: input.Currency})
+			}
+			merged[i].Input[j].Amount += input.Amount
+		}
+	}
+	return merged
+}
+
+// TripSummary returns the net balance of every address of the payments before any transfer is generated,
+// OutputAmount is what the address paid beyond its share and InputAmount what it still owes.
+// It is the normalized cash ShareMoneyEasy settles, sorted by address and currency,
+// an address which paid exactly its share is kept with zero amounts.
+func TripSummary(payments []UserPayment) ([]Cash, error) {
+	cashList, err := normalizedCash(payments)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(cashList, func(i, j int) bool {
+		if cashList[i].Address != cashList[j].Address {
+			return cashList[i].Address < cashList[j].Address
+		}
+		return cashList[i].Currency < cashList[j].Currency
+	})
+	return cashList, nil
+}
+
+// normalizedCash converts the payments to txs and nets the cash flow of each address
+func normalizedCash(uiList []UserPayment) ([]Cash, error) {
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
 	}
 	// Create a TxPackage from the generated transactions
 	txPackage := Package{
@@ -164,9 +298,25 @@ func ShareMoneyEasy(uiList []UserPayment) (Package, float64, error) {
 	// Process the transactions to get the cash flow for each address
 	cashList := txPackage.ProcessTransactions()
 	// Normalize the cash
-	cashList = NormalizeCash(cashList)
+	return NormalizeCash(cashList), nil
+}
+
+// ShareMoneyEasy settles the payments, the returned float is the total of inputs left unspent,
+// it is not an error, the caller decides whether to warn about it or to fail.
+func ShareMoneyEasy(uiList []UserPayment) (Package, float64, error) {
+	cashList, err := normalizedCash(uiList)
+	if err != nil {
+		return Package{}, 0, err
+	}
+	return ShareSummary(cashList)
+}
+
+// ShareSummary settles the net balances of TripSummary like ShareMoneyEasy settles the payments,
+// a caller which needs both the balances and the settlement normalizes the payments once. The summary is not modified.
+func ShareSummary(summary []Cash) (Package, float64, error) {
+	cashList := slices.Clone(summary)
 	// Convert the cash list to a TxPackage
-	txPackageFromCash, diff, err := CashListToTxPackage(cashList, "activity", ListTxGenerateWithMixMap)
+	txPackageFromCash, diff, err := defaultSplitter.settleCashList(cashList, "activity", ListTxGenerateWithMixMap)
 	if err != nil {
 		return Package{}, 0, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
 	}
@@ -176,3 +326,66 @@ func ShareMoneyEasy(uiList []UserPayment) (Package, float64, error) {
 
 	return txPackageFromCash, diff, nil
 }
+
+// ShareMoneyByCurrency works like ShareMoneyEasy for a trip paid in several currencies,
+// each currency is settled on its own and the packages are keyed by currency.
+func ShareMoneyByCurrency(uiList []UserPayment) (map[string]Package, error) {
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+	txPackage := Package{
+		Name:   "UserPaymentsPackage",
+		TxList: txList,
+	}
+	cashList := NormalizeCash(txPackage.ProcessTransactions())
+	packages, err := CashListToTxPackagesByCurrency(cashList, "activity", ListTxGenerateWithMixMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
+	}
+	for currency, pkg := range packages {
+		pkg.SetNoSmallValue(MinValueTxOutput)
+		pkg.DropZeroTx()
+		packages[currency] = pkg
+	}
+
+	return packages, nil
+}
+
+// ShareMoneyWithPriority works like ShareMoneyEasy, but the positions of higher priority addresses are settled first,
+// see ListTxGenerateWithPriority.
+func ShareMoneyWithPriority(uiList []UserPayment, priority AddressPriority) (Package, float64, error) {
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		return Package{}, 0, fmt.Errorf("failed to convert UserPayment to TxList: %w", err)
+	}
+	txPackage := Package{
+		Name:   "UserPaymentsPackage",
+		TxList: txList,
+	}
+	cashList := NormalizeCash(txPackage.ProcessTransactions())
+	txPackageFromCash, diff, err := defaultSplitter.settleCashList(cashList, "activity", ListTxGenerateWithPriority(priority))
+	if err != nil {
+		return Package{}, 0, fmt.Errorf("failed to convert cash list to TxPackage: %w", err)
+	}
+	txPackageFromCash.SetNoSmallValue(MinValueTxOutput)
+	txPackageFromCash.DropZeroTx()
+
+	return txPackageFromCash, diff, nil
+}
+
+// ShareMoneyRounded works like ShareMoneyEasy, then rounds every transfer to a multiple of increment
+// in the given direction. The returned remaining is the rounding residual, see RoundTransfers.
+func ShareMoneyRounded(uiList []UserPayment, increment float64, direction RoundingDirection) (Package, float64, error) {
+	txPackage, _, err := ShareMoneyEasy(uiList)
+	if err != nil {
+		return Package{}, 0, err
+	}
+	residual, err := txPackage.RoundTransfers(increment, direction)
+	if err != nil {
+		return Package{}, 0, err
+	}
+	txPackage.DropZeroTx()
+
+	return txPackage, residual, nil
+}
@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
@@ -670,6 +671,73 @@ func TestTx_Validate(t *testing.T) {
 	}
 }
 
+func TestTx_ValidateDetailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		tx          Tx
+		expectedErr string
+	}{
+		{
+			name: "Valid",
+			tx:   Tx{Name: "ok", Input: []Payment{{Amount: 40, Address: "Alice"}, {Amount: 60, Address: "Bob"}}, Output: Payment{Amount: 100, Address: "Carol"}},
+		},
+		{
+			name:        "No inputs",
+			tx:          Tx{Name: "lunch", Output: Payment{Amount: 100, Address: "Carol"}},
+			expectedErr: "tx lunch has no inputs",
+		},
+		{
+			name:        "No output",
+			tx:          Tx{Name: "lunch", Input: []Payment{{Amount: 100, Address: "Alice"}}},
+			expectedErr: "tx lunch has no output",
+		},
+		{
+			name:        "Mismatched sum",
+			tx:          Tx{Name: "lunch", Input: []Payment{{Amount: 45, Address: "Alice"}, {Amount: 50, Address: "Bob"}}, Output: Payment{Amount: 100, Address: "Carol"}},
+			expectedErr: "tx lunch inputs sum 95.00 != output 100.00",
+		},
+		{
+			name: "Single currency",
+			tx:   Tx{Name: "ok", Input: []Payment{{Amount: 40, Address: "Alice", Currency: "USD"}, {Amount: 60, Address: "Bob", Currency: "USD"}}, Output: Payment{Amount: 100, Address: "Carol", Currency: "USD"}},
+		},
+		{
+			name:        "Mixed currency",
+			tx:          Tx{Name: "lunch", Input: []Payment{{Amount: 40, Address: "Alice", Currency: "USD"}, {Amount: 60, Address: "Bob", Currency: "TWD"}}, Output: Payment{Amount: 100, Address: "Carol", Currency: "USD"}},
+			expectedErr: `cash list mixes currencies: tx lunch input of Bob in "TWD", output in "USD"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tx.ValidateDetailed()
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("ValidateDetailed() unexpected error: %v", err)
+				}
+			} else if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("ValidateDetailed() error = %v, want %q", err, tt.expectedErr)
+			}
+			if tt.tx.BoolValidate() != (err == nil) {
+				t.Errorf("BoolValidate() = %v disagrees with ValidateDetailed() error %v", tt.tx.BoolValidate(), err)
+			}
+		})
+	}
+
+	t.Run("Mixed currency wraps ErrMixedCurrency", func(t *testing.T) {
+		tx := Tx{Name: "lunch", Input: []Payment{{Amount: 100, Address: "Alice"}}, Output: Payment{Amount: 100, Address: "Carol", Currency: "USD"}}
+		if err := tx.ValidateDetailed(); !errors.Is(err, ErrMixedCurrency) {
+			t.Errorf("ValidateDetailed() error = %v, want ErrMixedCurrency", err)
+		}
+	})
+
+	t.Run("UIList2TxList reports the mismatch", func(t *testing.T) {
+		_, err := UIList2TxList([]UserPayment{{Name: "free lunch", Amount: 1e-12, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob"}, ExtendPayMsg: []float64{0, 0}}})
+		if err == nil || !strings.Contains(err.Error(), "tx free lunch has no inputs") {
+			t.Errorf("UIList2TxList() error = %v, want it to contain the diagnostic", err)
+		}
+	})
+}
+
 func TestTxPackage_String(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -708,3 +776,333 @@ func TestTxPackage_String(t *testing.T) {
 		})
 	}
 }
+
+func TestShareMoneyRounded(t *testing.T) {
+	// Alice pays 100 for three, Bob and Carol owe 33.33 each, Alice takes the extra cent
+	uiList := []UserPayment{
+		{Name: "Dinner", Amount: 100, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, PaymentType: 0},
+	}
+	tests := []struct {
+		name             string
+		direction        RoundingDirection
+		expectedTransfer float64
+		expectedResidual float64
+	}{
+		{name: "Favor debtor rounds down and reports uncovered credit", direction: RoundFavorDebtor, expectedTransfer: 30, expectedResidual: 66.66 - 60},
+		{name: "Favor creditor rounds up and reports extra collected", direction: RoundFavorCreditor, expectedTransfer: 40, expectedResidual: 80 - 66.66},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg, residual, err := ShareMoneyRounded(uiList, 10, tt.direction)
+			if err != nil {
+				t.Fatalf("ShareMoneyRounded() unexpected error: %v", err)
+			}
+			if math.Abs(residual-tt.expectedResidual) > 1e-6 {
+				t.Errorf("ShareMoneyRounded() residual = %v, want %v", residual, tt.expectedResidual)
+			}
+			received := 0.0
+			for _, tx := range pkg.TxList {
+				if tx.Output.Address != "Alice" {
+					t.Errorf("ShareMoneyRounded() unexpected creditor %s", tx.Output.Address)
+				}
+				if !tx.BoolValidate() {
+					t.Errorf("ShareMoneyRounded() tx %s is not balanced", tx.Name)
+				}
+				received += tx.Output.Amount
+				for _, input := range tx.Input {
+					if math.Abs(input.Amount-tt.expectedTransfer) > 1e-9 {
+						t.Errorf("ShareMoneyRounded() transfer of %s = %v, want %v", input.Address, input.Amount, tt.expectedTransfer)
+					}
+				}
+			}
+			credit := 66.66
+			if tt.direction == RoundFavorCreditor && received < credit {
+				t.Errorf("ShareMoneyRounded() creditor received %v, less than credit %v", received, credit)
+			}
+			if tt.direction == RoundFavorDebtor && math.Abs(credit-received-residual) > 1e-6 {
+				t.Errorf("ShareMoneyRounded() uncovered credit %v does not match residual %v", credit-received, residual)
+			}
+		})
+	}
+}
+
+func TestUIList2TxList_SelfPayment(t *testing.T) {
+	uiList := []UserPayment{
+		{Name: "Souvenir", Amount: 50, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice"}, PaymentType: 0},
+		{Name: "Lunch", Amount: 30, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob"}, PaymentType: 0},
+	}
+
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		t.Fatalf("UIList2TxList() unexpected error: %v", err)
+	}
+	if len(txList) != 1 || txList[0].Name != "Lunch" {
+		t.Errorf("UIList2TxList() = %v, want only Lunch", txList)
+	}
+
+	t.Run("Only self payments settle with no transfer", func(t *testing.T) {
+		pkg, remaining, err := ShareMoneyEasy(uiList[:1])
+		if err != nil {
+			t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+		}
+		if len(pkg.TxList) != 0 || remaining != 0 {
+			t.Errorf("ShareMoneyEasy() = %v, remaining %v, want no transfer", pkg.TxList, remaining)
+		}
+	})
+
+	t.Run("Self payment does not change the settlement", func(t *testing.T) {
+		withSelf, _, err := ShareMoneyEasy(uiList)
+		if err != nil {
+			t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+		}
+		without, _, err := ShareMoneyEasy(uiList[1:])
+		if err != nil {
+			t.Fatalf("ShareMoneyEasy() unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(withSelf, without) {
+			t.Errorf("ShareMoneyEasy() with self payment = %v, want %v", withSelf, without)
+		}
+	})
+}
+
+func TestTx_IsSelfPayment(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   Tx
+		want bool
+	}{
+		{name: "Prepayer is the sole input", tx: Tx{Input: []Payment{{Amount: 10, Address: "A"}}, Output: Payment{Amount: 10, Address: "A"}}, want: true},
+		{name: "Other input", tx: Tx{Input: []Payment{{Amount: 5, Address: "A"}, {Amount: 5, Address: "B"}}, Output: Payment{Amount: 10, Address: "A"}}, want: false},
+		{name: "No input", tx: Tx{Output: Payment{Amount: 10, Address: "A"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tx.IsSelfPayment(); got != tt.want {
+				t.Errorf("IsSelfPayment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackage_RoundTransfers_Errors(t *testing.T) {
+	pkg := Package{TxList: []Tx{{Name: "T", Input: []Payment{{Amount: 15, Address: "B"}}, Output: Payment{Amount: 15, Address: "A"}}}}
+	if _, err := pkg.RoundTransfers(0.001, RoundFavorDebtor); err == nil {
+		t.Error("RoundTransfers() expected error for too small increment")
+	}
+	if _, err := pkg.RoundTransfers(10, RoundingDirection(7)); err == nil {
+		t.Error("RoundTransfers() expected error for unknown direction")
+	}
+	// exact multiples are not moved by float noise
+	pkg.TxList[0].Input[0].Amount, pkg.TxList[0].Output.Amount = 30.000000000001, 30.000000000001
+	residual, err := pkg.RoundTransfers(10, RoundFavorCreditor)
+	if err != nil {
+		t.Fatalf("RoundTransfers() unexpected error: %v", err)
+	}
+	if pkg.TxList[0].Output.Amount != 30 || residual > 1e-9 {
+		t.Errorf("RoundTransfers() got output %v and residual %v, want 30 and 0", pkg.TxList[0].Output.Amount, residual)
+	}
+}
+
+func TestShareMoneyByCurrency(t *testing.T) {
+	// TWD and JPY expenses of the same people never offset each other
+	uiList := []UserPayment{
+		{Name: "Hotel", Amount: 3000, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, Currency: "TWD"},
+		{Name: "Ramen", Amount: 4500, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, Currency: "JPY"},
+		{Name: "Taxi", Amount: 1500, PrePayAddress: "Carol", ShouldPayAddress: []string{"Alice", "Carol"}, Currency: "JPY"},
+	}
+
+	if _, _, err := ShareMoneyEasy(uiList); !errors.Is(err, ErrMixedCurrency) {
+		t.Fatalf("ShareMoneyEasy() error = %v, want ErrMixedCurrency", err)
+	}
+
+	packages, err := ShareMoneyByCurrency(uiList)
+	if err != nil {
+		t.Fatalf("ShareMoneyByCurrency() unexpected error: %v", err)
+	}
+	if len(packages) != 2 {
+		t.Fatalf("ShareMoneyByCurrency() returned %d packages, want 2", len(packages))
+	}
+	want := map[string]map[string]float64{
+		"TWD": {"Alice": 2000, "Bob": -1000, "Carol": -1000},
+		"JPY": {"Alice": -2250, "Bob": 3000, "Carol": -750},
+	}
+	for currency, balances := range want {
+		pkg, ok := packages[currency]
+		if !ok {
+			t.Fatalf("ShareMoneyByCurrency() has no %s package", currency)
+		}
+		for _, tx := range pkg.TxList {
+			if tx.Output.Currency != currency {
+				t.Errorf("%s tx %s has output currency %q", currency, tx.Name, tx.Output.Currency)
+			}
+			for _, input := range tx.Input {
+				if input.Currency != currency {
+					t.Errorf("%s tx %s has input currency %q", currency, tx.Name, input.Currency)
+				}
+			}
+		}
+		got := pkg.NetBalance()
+		for address, balance := range balances {
+			if math.Abs(got[address]-balance) > 1e-6 {
+				t.Errorf("%s balance of %s = %v, want %v", currency, address, got[address], balance)
+			}
+		}
+	}
+}
+
+func TestMergeTxByPrePayer(t *testing.T) {
+	uiList := []UserPayment{
+		{Name: "Lunch", Amount: 30, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob"}},
+		{Name: "Coffee", Amount: 6, PrePayAddress: "Bob", ShouldPayAddress: []string{"Alice", "Bob"}},
+		{Name: "Taxi", Amount: 12, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}},
+		{Name: "Snack", Amount: 3, PrePayAddress: "Alice", ShouldPayAddress: []string{"Carol"}},
+		{Name: "Souvenir", Amount: 500, PrePayAddress: "Alice", ShouldPayAddress: []string{"Bob"}, Currency: "JPY"},
+	}
+	txList, err := UIList2TxList(uiList)
+	if err != nil {
+		t.Fatalf("UIList2TxList() unexpected error: %v", err)
+	}
+	merged, err := UIList2TxListByPrePayer(uiList)
+	if err != nil {
+		t.Fatalf("UIList2TxListByPrePayer() unexpected error: %v", err)
+	}
+
+	want := []Tx{
+		{
+			Name:   "Lunch, Taxi, Snack",
+			Input:  []Payment{{Amount: 19, Address: "Alice"}, {Amount: 19, Address: "Bob"}, {Amount: 7, Address: "Carol"}},
+			Output: Payment{Amount: 45, Address: "Alice"},
+		},
+		{
+			Name:   "Coffee",
+			Input:  []Payment{{Amount: 3, Address: "Alice"}, {Amount: 3, Address: "Bob"}},
+			Output: Payment{Amount: 6, Address: "Bob"},
+		},
+		{
+			// another currency of the same payer is its own position
+			Name:   "Souvenir",
+			Input:  []Payment{{Amount: 500, Address: "Bob", Currency: "JPY"}},
+			Output: Payment{Amount: 500, Address: "Alice", Currency: "JPY"},
+		},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("MergeTxByPrePayer() returned %d txs, want %d: %+v", len(merged), len(want), merged)
+	}
+	for i := range want {
+		if merged[i].Name != want[i].Name || merged[i].Output != want[i].Output {
+			t.Errorf("tx %d = %s %+v, want %s %+v", i, merged[i].Name, merged[i].Output, want[i].Name, want[i].Output)
+		}
+		if !reflect.DeepEqual(merged[i].Input, want[i].Input) {
+			t.Errorf("tx %d inputs = %+v, want %+v", i, merged[i].Input, want[i].Input)
+		}
+		if !merged[i].BoolValidate() {
+			t.Errorf("tx %d is not balanced: %+v", i, merged[i])
+		}
+	}
+
+	// merging moves no money, the net cash of every address and currency is kept
+	before, after := Package{TxList: txList}, Package{TxList: merged}
+	netCash := func(pkg Package) map[cashKey]float64 {
+		net := make(map[cashKey]float64)
+		for _, cash := range NormalizeCash(pkg.ProcessTransactions()) {
+			net[cashKey{Address: cash.Address, Currency: cash.Currency}] = cash.InputAmount - cash.OutputAmount
+		}
+		return net
+	}
+	afterCash := netCash(after)
+	for key, balance := range netCash(before) {
+		if math.Abs(afterCash[key]-balance) > 1e-9 {
+			t.Errorf("net cash of %+v = %v after merge, want %v", key, afterCash[key], balance)
+		}
+	}
+}
+
+func TestMergeTxByPrePayer_Empty(t *testing.T) {
+	if merged := MergeTxByPrePayer(nil); len(merged) != 0 {
+		t.Errorf("MergeTxByPrePayer(nil) = %+v, want empty", merged)
+	}
+}
+
+func TestTripSummary_SampleInput(t *testing.T) {
+	payments := readSampleInput(t)
+	summary, err := TripSummary(payments)
+	if err != nil {
+		t.Fatalf("TripSummary failed: %v", err)
+	}
+
+	// net balance by hand, what an address paid minus its even share of every payment
+	want := make(map[string]float64)
+	for _, p := range payments {
+		want[p.PrePayAddress] += p.Amount
+		for _, address := range p.ShouldPayAddress {
+			want[address] -= p.Amount / float64(len(p.ShouldPayAddress))
+		}
+	}
+
+	if len(summary) != len(want) {
+		t.Fatalf("expected %d addresses, got %d: %+v", len(want), len(summary), summary)
+	}
+	total := 0.0
+	for i, cash := range summary {
+		if i > 0 && summary[i-1].Address >= cash.Address {
+			t.Errorf("summary is not sorted by address: %s before %s", summary[i-1].Address, cash.Address)
+		}
+		if cash.InputAmount > 0 && cash.OutputAmount > 0 {
+			t.Errorf("%s is not netted: %+v", cash.Address, cash)
+		}
+		net := cash.OutputAmount - cash.InputAmount
+		// shares are rounded to cents, so each payment may move an address by a cent
+		if math.Abs(net-want[cash.Address]) > 0.05 {
+			t.Errorf("%s: expected net %.2f, got %.2f", cash.Address, want[cash.Address], net)
+		}
+		total += net
+	}
+	if math.Abs(total) > epsilon*1e3 {
+		t.Errorf("balances should sum to zero, got %v", total)
+	}
+}
+
+func TestTripSummary_EvenAddress(t *testing.T) {
+	payments := []UserPayment{
+		{Name: "lunch", Amount: 60, PrePayAddress: "Alice", ShouldPayAddress: []string{"Alice", "Bob", "Carol"}, ExtendPayMsg: []float64{0, 0, 0}},
+		// Bob pays back exactly the 20 of his share of lunch
+		{Name: "coffee", Amount: 20, PrePayAddress: "Bob", ShouldPayAddress: []string{"Carol"}, ExtendPayMsg: []float64{0}},
+	}
+	summary, err := TripSummary(payments)
+	if err != nil {
+		t.Fatalf("TripSummary failed: %v", err)
+	}
+	want := []Cash{
+		{Address: "Alice", OutputAmount: 40},
+		{Address: "Bob"},
+		{Address: "Carol", InputAmount: 40},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("expected %+v, got %+v", want, summary)
+	}
+}
+
+func TestShareSummary_SampleInput(t *testing.T) {
+	payments := readSampleInput(t)
+	want, wantRemaining, err := ShareMoneyEasy(payments)
+	if err != nil {
+		t.Fatalf("ShareMoneyEasy failed: %v", err)
+	}
+	summary, err := TripSummary(payments)
+	if err != nil {
+		t.Fatalf("TripSummary failed: %v", err)
+	}
+	before := append([]Cash{}, summary...)
+
+	got, remaining, err := ShareSummary(summary)
+	if err != nil {
+		t.Fatalf("ShareSummary failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) || remaining != wantRemaining {
+		t.Errorf("ShareSummary() = %v, %v, want the ShareMoneyEasy result %v, %v", got, remaining, want, wantRemaining)
+	}
+	if !reflect.DeepEqual(summary, before) {
+		t.Errorf("ShareSummary modified the summary: %+v, was %+v", summary, before)
+	}
+}
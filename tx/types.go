@@ -1,22 +1,47 @@
 package tx
 
+import "time"
+
 // Threshold for float comparisons
 const epsilon = 1e-9
 
+// SplitConfig is the precision money is split and settled with, DefaultSplitConfig fits two-decimal currencies
+// like TWD, a zero-decimal currency like JPY uses DecimalPlaces 0.
+type SplitConfig struct {
+	Epsilon       float64 // threshold for float comparisons, differences within it are float noise
+	DecimalPlaces int     // decimal places shares are rounded to
+}
+
+// Splitter splits and settles money with the precision of its SplitConfig,
+// the package level functions of the same name use DefaultSplitConfig.
+// A Splitter is not safe for concurrent use, Settle reuses its buffers between calls.
+type Splitter struct {
+	Config SplitConfig
+
+	strategies []UserPaymentToTxStrategy // strategy of each PaymentType, filled on first Settle
+	txList     []Tx                      // txs of the settled payments, reused by Settle
+	cashIndex  map[cashKey]int           // index of an address in cashList, reused by Settle
+	cashList   []Cash                    // balances of the settled payments, reused by Settle
+}
+
 // UserPayment represents a user's intention to pay, with a single source and multiple potential destinations.
 type UserPayment struct {
-	Name             string    // A descriptive name for this user payment
-	Amount           float64   // The total amount the user is paying
-	PrePayAddress    string    // The address from which the payment originates (pre-payment)
-	ShouldPayAddress []string  // A list of addresses that should receive a share of the payment
-	ExtendPayMsg     []float64 // Additional messages or metadata associated with each should-pay address
-	PaymentType      int       // let inner module choose strategy to calculate result
+	Name             string             // A descriptive name for this user payment
+	Amount           float64            // The total amount the user is paying
+	PrePayAddress    string             // The address from which the payment originates (pre-payment)
+	ShouldPayAddress []string           // A list of addresses that should receive a share of the payment
+	ExtendPayMsg     []float64          // Additional messages or metadata associated with each should-pay address
+	ExtendPayMap     map[string]float64 // ExtendPayMsg keyed by should-pay address, takes precedence over ExtendPayMsg when set
+	PaymentType      int                // let inner module choose strategy to calculate result
+	Placeholder      bool               // kept for display only, it is not converted to Tx and does not affect the settlement
+	Currency         string             // currency code of Amount like "TWD", empty for the trip's only currency
 }
 
 // Payment represents a single payment with an amount and an address.
 type Payment struct {
-	Amount  float64
-	Address string
+	Amount   float64
+	Address  string
+	Currency string
 }
 
 // Tx represents a transaction.
@@ -37,11 +62,84 @@ type Cash struct {
 	Address      string  // The address identifier
 	InputAmount  float64 // Total amount received by this address (as an output in other transactions)
 	OutputAmount float64 // Total amount sent from this address (as an input in other transactions)
+	Currency     string  // Currency of the amounts, an address has one Cash per currency
 }
 
 // UserPaymentToTxStrategy defines the interface for converting a UserPayment into a Tx.
 // It takes the UserPayment and returns a Tx struct, or an error if conversion fails.
 type UserPaymentToTxStrategy func(up *UserPayment) (Tx, error)
 
+// AddressPriority tags addresses with a settlement priority, addresses of higher priority are settled first,
+// e.g. someone leaving the trip early. Missing addresses have priority 0.
+type AddressPriority map[string]int
+
 // ListGenerateStrategy is a strategy for converting UserPayment to Tx by averaging the payment among recipients.
 type ListGenerateStrategy func(txList *[]Tx, cashList *[]Cash) (float64, error)
+
+// SettleStrategy is a ListGenerateStrategy with the name it is listed by, see SettleStrategies.
+type SettleStrategy struct {
+	Name     string
+	Strategy ListGenerateStrategy
+}
+
+// PrecisionWarning reports a value which has more decimal places than allowed.
+type PrecisionWarning struct {
+	Name     string  // Name of the UserPayment holding the value
+	Field    string  // "Amount" or "ExtendPayMsg"
+	Index    int     // index in ExtendPayMsg, -1 for Amount
+	Value    float64 // the over-precise value
+	Decimals int     // decimal places of the value
+}
+
+// BalanceChange is the net balance of an address before and after a settlement change,
+// positive balance means the address receives money, negative means it pays.
+type BalanceChange struct {
+	Address  string
+	Currency string
+	Before   float64
+	After    float64
+}
+
+// SettlementDiff describes how a settlement changes, only addresses whose balance changed are listed.
+type SettlementDiff struct {
+	Changes         []BalanceChange // sorted by currency then address
+	RemainingBefore float64         // remaining inputs of the settlement before change
+	RemainingAfter  float64         // remaining inputs of the settlement after change
+}
+
+// RoundingDirection decides which side benefits when transfers are rounded to an increment.
+type RoundingDirection int
+
+const (
+	RoundFavorDebtor   RoundingDirection = iota // round every transfer down, creditors may be under-covered
+	RoundFavorCreditor                          // round every transfer up, creditors are always covered
+)
+
+// OptimizeLimit caps the search for the settlement with the fewest transfers,
+// zero value fields fall back to DefaultOptimizeDuration and DefaultOptimizeIterations.
+type OptimizeLimit struct {
+	MaxDuration   time.Duration // wall time spent in the search before falling back to greedy
+	MaxIterations int           // search steps, a group of n addresses needs about n*2^n steps
+}
+
+// StatementLine is one transfer of a PersonStatement, Counterparty is who is paid or who pays.
+type StatementLine struct {
+	Counterparty string
+	Amount       float64
+}
+
+// PersonStatement lists the transfers one address takes part in, for sending each person their own statement.
+type PersonStatement struct {
+	Address      string
+	Pays         []StatementLine // transfers this address pays, in settlement order
+	Receives     []StatementLine // transfers this address receives, in settlement order
+	TotalPay     float64
+	TotalReceive float64
+}
+
+// Transfer is a settlement transfer which has been paid, From paid Amount to To.
+type Transfer struct {
+	From   string
+	To     string
+	Amount float64
+}
@@ -3,34 +3,11 @@ package tx
 import (
 	"fmt"
 	"math"
+	"strings"
 )
 
 func AverageSplitStrategy(up *UserPayment) (Tx, error) {
-	// first check
-	if len(up.ShouldPayAddress) == 0 {
-		return Tx{}, fmt.Errorf("UserPayment '%s' must have at least one ShouldPayAddress for AverageSplitStrategy", up.Name)
-	}
-
-	// Create the transaction
-	tx := Tx{
-		Name:  up.Name,
-		Input: []Payment{},
-		Output: Payment{
-			Amount:  up.Amount,
-			Address: up.PrePayAddress,
-		},
-	}
-
-	// should pay user split output as input
-	eachPayAmount := up.Amount / float64(len(up.ShouldPayAddress))
-	for _, u := range up.ShouldPayAddress {
-		tx.Input = append(tx.Input, Payment{
-			Amount:  eachPayAmount,
-			Address: u,
-		})
-	}
-
-	return tx, nil
+	return defaultSplitter.AverageSplitStrategy(up)
 }
 
 func FixMoneySplitStrategy(up *UserPayment) (Tx, error) {
@@ -52,22 +29,60 @@ func FixMoneySplitStrategy(up *UserPayment) (Tx, error) {
 		Name:  up.Name,
 		Input: []Payment{},
 		Output: Payment{
-			Amount:  up.Amount,
-			Address: up.PrePayAddress,
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
 		},
 	}
 
 	// should pay user split output as input
 	for i, u := range up.ShouldPayAddress {
 		tx.Input = append(tx.Input, Payment{
-			Amount:  up.ExtendPayMsg[i],
-			Address: u,
+			Amount:   up.ExtendPayMsg[i],
+			Address:  u,
+			Currency: up.Currency,
 		})
 	}
 
 	return tx, nil
 }
 
+// DerivedTotalSplitStrategy works like FixMoneySplitStrategy, but the total is the sum of the fixed contributions
+// in ExtendPayMsg, up.Amount is ignored, so the resulting Tx is always balanced.
+func DerivedTotalSplitStrategy(up *UserPayment) (Tx, error) {
+	derived := *up
+	derived.Amount = 0
+	for _, u := range up.ExtendPayMsg {
+		derived.Amount += u
+	}
+	tx, err := FixMoneySplitStrategy(&derived)
+	if err != nil {
+		return Tx{}, err
+	}
+	if derived.Amount <= 0 {
+		return Tx{}, fmt.Errorf("UserPayment '%s' contributions must have a positive sum", up.Name)
+	}
+	return tx, nil
+}
+
+// NewDerivedTotalUserPayment creates a fixed-amount UserPayment whose Amount is the sum of contributions,
+// for when each person's contribution is known but the total is not.
+func NewDerivedTotalUserPayment(name string, prePayAddress string, shouldPayAddress []string, contributions []float64) (UserPayment, error) {
+	up := UserPayment{
+		Name:             name,
+		PrePayAddress:    prePayAddress,
+		ShouldPayAddress: shouldPayAddress,
+		ExtendPayMsg:     contributions,
+		PaymentType:      1, // FixMoneySplitStrategy
+	}
+	tx, err := DerivedTotalSplitStrategy(&up)
+	if err != nil {
+		return UserPayment{}, err
+	}
+	up.Amount = tx.Output.Amount
+	return up, nil
+}
+
 func PartMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	// first check
 	if len(up.ShouldPayAddress) == 0 {
@@ -87,8 +102,9 @@ func PartMoneySplitStrategy(up *UserPayment) (Tx, error) {
 		Name:  up.Name,
 		Input: []Payment{},
 		Output: Payment{
-			Amount:  up.Amount,
-			Address: up.PrePayAddress,
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
 		},
 	}
 
@@ -103,14 +119,29 @@ func PartMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	// should pay user split output as input
 	for i, u := range up.ShouldPayAddress {
 		tx.Input = append(tx.Input, Payment{
-			Amount:  up.Amount * (up.ExtendPayMsg[i] / sumOfPart),
-			Address: u,
+			Amount:   up.Amount * (up.ExtendPayMsg[i] / sumOfPart),
+			Address:  u,
+			Currency: up.Currency,
 		})
 	}
 
 	return tx, nil
 }
 
+// PartMoneyReconcileSplitStrategy works like PartMoneySplitStrategy for fractional shares (e.g. [1.5, 2.5, 1]),
+// but rounds each share to cents and lets the last payer absorb the rounding residual,
+// so the inputs always sum exactly to the output.
+func PartMoneyReconcileSplitStrategy(up *UserPayment) (Tx, error) {
+	return defaultSplitter.PartMoneyReconcileSplitStrategy(up)
+}
+
+// CountWeightedSplitStrategy splits up.Amount by integer weights in ExtendPayMsg, e.g. nights stayed [3, 2, 1].
+// Each share is rounded down to cents and the remaining cents go to the participant of the highest weight,
+// the first one on ties, so the inputs always sum exactly to the output.
+func CountWeightedSplitStrategy(up *UserPayment) (Tx, error) {
+	return defaultSplitter.CountWeightedSplitStrategy(up)
+}
+
 func FixBeforeAverageMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	// first check
 	if len(up.ShouldPayAddress) == 0 {
@@ -125,8 +156,9 @@ func FixBeforeAverageMoneySplitStrategy(up *UserPayment) (Tx, error) {
 		Name:  up.Name,
 		Input: []Payment{},
 		Output: Payment{
-			Amount:  up.Amount,
-			Address: up.PrePayAddress,
+			Amount:   up.Amount,
+			Address:  up.PrePayAddress,
+			Currency: up.Currency,
 		},
 	}
 
@@ -153,13 +185,15 @@ func FixBeforeAverageMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	for i, u := range up.ExtendPayMsg {
 		if u < 0 {
 			tx.Input = append(tx.Input, Payment{
-				Amount:  -u,
-				Address: up.ShouldPayAddress[i],
+				Amount:   -u,
+				Address:  up.ShouldPayAddress[i],
+				Currency: up.Currency,
 			})
 		} else {
 			tx.Input = append(tx.Input, Payment{
-				Amount:  averageMoney + u,
-				Address: up.ShouldPayAddress[i],
+				Amount:   averageMoney + u,
+				Address:  up.ShouldPayAddress[i],
+				Currency: up.Currency,
 			})
 		}
 	}
@@ -167,25 +201,60 @@ func FixBeforeAverageMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	return tx, nil
 }
 
+// PercentageSplitStrategy splits up.Amount by the percentages in ExtendPayMsg, e.g. [50, 30, 20],
+// the percentages must be non-negative and sum to 100.
+func PercentageSplitStrategy(up *UserPayment) (Tx, error) {
+	return defaultSplitter.PercentageSplitStrategy(up)
+}
+
 func TransferMoneySplitStrategy(up *UserPayment) (Tx, error) {
 	return FixMoneySplitStrategy(up)
 }
 
-func ShareMoneyStrategyFactory(strategyEnum int) UserPaymentToTxStrategy {
-	switch strategyEnum {
-	case 0:
-		return AverageSplitStrategy
-	case 1:
-		return FixMoneySplitStrategy
-	case 2:
-		return PartMoneySplitStrategy
-	case 3:
-		return FixBeforeAverageMoneySplitStrategy
-	case 4:
-		return TransferMoneySplitStrategy
+// ShareMoneyStrategyNames are the names accepted by ShareMoneyStrategyFromName,
+// the index of a name is its strategy enum of ShareMoneyStrategyFactory and UserPayment.PaymentType.
+var ShareMoneyStrategyNames = []string{"average", "fixed", "part", "fix_before_average", "transfer", "percentage", "count_weighted"}
+
+// ShareMoneyStrategyFromName returns the strategy of name, e.g. "average", see ShareMoneyStrategyNames.
+func ShareMoneyStrategyFromName(name string) (UserPaymentToTxStrategy, error) {
+	switch name {
+	case "average":
+		return AverageSplitStrategy, nil
+	case "fixed":
+		return FixMoneySplitStrategy, nil
+	case "part":
+		return PartMoneySplitStrategy, nil
+	case "fix_before_average":
+		return FixBeforeAverageMoneySplitStrategy, nil
+	case "transfer":
+		return TransferMoneySplitStrategy, nil
+	case "percentage":
+		return PercentageSplitStrategy, nil
+	case "count_weighted":
+		return CountWeightedSplitStrategy, nil
 	default:
+		return nil, fmt.Errorf("unknown share money strategy %q, expected one of %s", name, strings.Join(ShareMoneyStrategyNames, ", "))
+	}
+}
+
+// ShareMoneyStrategyEnum returns the strategy enum of name, to be set as UserPayment.PaymentType.
+func ShareMoneyStrategyEnum(name string) (int, error) {
+	for i, n := range ShareMoneyStrategyNames {
+		if n == name {
+			return i, nil
+		}
+	}
+	_, err := ShareMoneyStrategyFromName(name)
+	return 0, err
+}
+
+// ShareMoneyStrategyFactory returns the strategy of the enum, nil when unknown. Prefer ShareMoneyStrategyFromName.
+func ShareMoneyStrategyFactory(strategyEnum int) UserPaymentToTxStrategy {
+	if strategyEnum < 0 || strategyEnum >= len(ShareMoneyStrategyNames) {
 		return nil
 	}
+	strategy, _ := ShareMoneyStrategyFromName(ShareMoneyStrategyNames[strategyEnum])
+	return strategy
 }
 
 func (up *UserPayment) ToTx(strategy UserPaymentToTxStrategy) (Tx, error) {
@@ -199,6 +268,31 @@ func (up *UserPayment) ToTx(strategy UserPaymentToTxStrategy) (Tx, error) {
 	if up.Amount <= 0 {
 		return Tx{}, fmt.Errorf("UserPayment '%s' amount must be positive", up.Name)
 	}
+	if len(up.ExtendPayMap) > 0 {
+		aligned, err := up.alignExtendPayMap()
+		if err != nil {
+			return Tx{}, err
+		}
+		return strategy(aligned)
+	}
 
 	return strategy(up)
 }
+
+// alignExtendPayMap returns a copy of the UserPayment whose ExtendPayMsg is taken from ExtendPayMap
+// in the order of ShouldPayAddress, addresses without value get 0.
+func (up *UserPayment) alignExtendPayMap() (*UserPayment, error) {
+	aligned := *up
+	aligned.ExtendPayMsg = make([]float64, len(up.ShouldPayAddress))
+	used := 0
+	for i, addr := range up.ShouldPayAddress {
+		if v, ok := up.ExtendPayMap[addr]; ok {
+			aligned.ExtendPayMsg[i] = v
+			used++
+		}
+	}
+	if used != len(up.ExtendPayMap) {
+		return nil, fmt.Errorf("UserPayment '%s' ExtendPayMap has address not in ShouldPayAddress", up.Name)
+	}
+	return &aligned, nil
+}
@@ -2,8 +2,10 @@ package tx
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -115,6 +117,42 @@ func TestAverageSplitStrategy(t *testing.T) {
 	}
 }
 
+func TestAverageSplitStrategy_RoundingRemainder(t *testing.T) {
+	tests := []struct {
+		name           string
+		amount         float64
+		recipients     []string
+		expectedShares []float64
+	}{
+		{name: "100 among 3, first gets the extra cent", amount: 100, recipients: []string{"A", "B", "C"}, expectedShares: []float64{33.34, 33.33, 33.33}},
+		{name: "10 among 7, first three get an extra cent", amount: 10, recipients: []string{"A", "B", "C", "D", "E", "F", "G"}, expectedShares: []float64{1.43, 1.43, 1.43, 1.43, 1.43, 1.43, 1.42}},
+		{name: "0.05 among 3", amount: 0.05, recipients: []string{"A", "B", "C"}, expectedShares: []float64{0.02, 0.02, 0.01}},
+		{name: "1 among 6", amount: 1, recipients: []string{"A", "B", "C", "D", "E", "F"}, expectedShares: []float64{0.17, 0.17, 0.17, 0.17, 0.16, 0.16}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := AverageSplitStrategy(&UserPayment{Name: tt.name, Amount: tt.amount, PrePayAddress: "A", ShouldPayAddress: tt.recipients})
+			if err != nil {
+				t.Fatalf("AverageSplitStrategy() unexpected error: %v", err)
+			}
+			if !tx.BoolValidate() {
+				t.Errorf("AverageSplitStrategy() tx is not balanced: %v", tx)
+			}
+			sum := 0.0
+			for i, input := range tx.Input {
+				if math.Abs(input.Amount-tt.expectedShares[i]) > epsilon {
+					t.Errorf("share of %s = %v, want %v", input.Address, input.Amount, tt.expectedShares[i])
+				}
+				sum += input.Amount
+			}
+			if math.Round(sum*100) != math.Round(tt.amount*100) {
+				t.Errorf("inputs sum to %.2f, want %.2f", sum, tt.amount)
+			}
+		})
+	}
+}
+
 func TestUserPayment_ToTx(t *testing.T) {
 	// A dummy strategy for successful conversions, as the actual logic is in AverageSplitStrategy
 	dummyStrategy := func(up *UserPayment) (Tx, error) {
@@ -608,6 +646,138 @@ func TestPartMoneySplitStrategy(t *testing.T) {
 	}
 }
 
+func TestPercentageSplitStrategy(t *testing.T) {
+	tests := []struct {
+		name         string
+		userPayment  *UserPayment
+		expectedTx   Tx
+		expectedErr  error
+		expectingErr bool
+	}{
+		{
+			name: "Successful conversion with percentages",
+			userPayment: &UserPayment{
+				Name:             "DinnerSplitByPercentage",
+				Amount:           200.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{"BobAccount", "CharlieAccount", "DavidAccount"},
+				ExtendPayMsg:     []float64{50, 30, 20},
+			},
+			expectedTx: Tx{
+				Name: "DinnerSplitByPercentage",
+				Input: []Payment{
+					{Amount: 100.0, Address: "BobAccount"},    // 200 * 50%
+					{Amount: 60.0, Address: "CharlieAccount"}, // 200 * 30%
+					{Amount: 40.0, Address: "DavidAccount"},   // 200 * 20%
+				},
+				Output: Payment{Amount: 200.0, Address: "AliceAccount"},
+			},
+			expectedErr:  nil,
+			expectingErr: false,
+		},
+		{
+			name: "Zero percentage pays nothing",
+			userPayment: &UserPayment{
+				Name:             "ZeroPercentage",
+				Amount:           80.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{"BobAccount", "CharlieAccount"},
+				ExtendPayMsg:     []float64{100, 0},
+			},
+			expectedTx: Tx{
+				Name: "ZeroPercentage",
+				Input: []Payment{
+					{Amount: 80.0, Address: "BobAccount"},
+					{Amount: 0.0, Address: "CharlieAccount"},
+				},
+				Output: Payment{Amount: 80.0, Address: "AliceAccount"},
+			},
+			expectedErr:  nil,
+			expectingErr: false,
+		},
+		{
+			name: "Error: No recipients",
+			userPayment: &UserPayment{
+				Name:             "NoRecipients",
+				Amount:           100.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{},
+				ExtendPayMsg:     []float64{},
+			},
+			expectedTx:   Tx{},
+			expectedErr:  fmt.Errorf("UserPayment 'NoRecipients' must have at least one ShouldPayAddress for PercentageSplitStrategy"),
+			expectingErr: true,
+		},
+		{
+			name: "Error: Mismatched lengths of ShouldPayAddress and ExtendPayMsg",
+			userPayment: &UserPayment{
+				Name:             "MismatchedLengths",
+				Amount:           100.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{"BobAccount"},
+				ExtendPayMsg:     []float64{50, 50},
+			},
+			expectedTx:   Tx{},
+			expectedErr:  fmt.Errorf("UserPayment 'MismatchedLengths' ExtendPayMsg must have the same length as ShouldPayAddress for PercentageSplitStrategy"),
+			expectingErr: true,
+		},
+		{
+			name: "Error: Negative percentage",
+			userPayment: &UserPayment{
+				Name:             "NegativePercentage",
+				Amount:           100.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{"BobAccount", "CharlieAccount"},
+				ExtendPayMsg:     []float64{120, -20},
+			},
+			expectedTx:   Tx{},
+			expectedErr:  fmt.Errorf("UserPayment 'NegativePercentage' ExtendPayMsg must be non-negative"),
+			expectingErr: true,
+		},
+		{
+			name: "Error: Percentages do not sum to 100",
+			userPayment: &UserPayment{
+				Name:             "SumNot100",
+				Amount:           100.0,
+				PrePayAddress:    "AliceAccount",
+				ShouldPayAddress: []string{"BobAccount", "CharlieAccount", "DavidAccount"},
+				ExtendPayMsg:     []float64{50, 30, 10},
+			},
+			expectedTx:   Tx{},
+			expectedErr:  fmt.Errorf("UserPayment 'SumNot100' ExtendPayMsg percentages sum to 90, must be 100"),
+			expectingErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTx, err := PercentageSplitStrategy(tt.userPayment)
+
+			if (err != nil) != tt.expectingErr {
+				t.Errorf("PercentageSplitStrategy() error = %v, expectingErr %v", err, tt.expectingErr)
+				return
+			}
+			if tt.expectingErr {
+				if err != nil && tt.expectedErr != nil && err.Error() != tt.expectedErr.Error() {
+					t.Errorf("PercentageSplitStrategy() error message mismatch. Got: %q, Want: %q", err.Error(), tt.expectedErr.Error())
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(gotTx, tt.expectedTx) {
+				t.Errorf("PercentageSplitStrategy() gotTx = %v, want %v", gotTx, tt.expectedTx)
+			}
+			if !gotTx.BoolValidate() {
+				t.Errorf("PercentageSplitStrategy() gotTx is not balanced: %v", gotTx)
+			}
+		})
+	}
+
+	if reflect.ValueOf(ShareMoneyStrategyFactory(5)).Pointer() != reflect.ValueOf(PercentageSplitStrategy).Pointer() {
+		t.Errorf("ShareMoneyStrategyFactory(5) is not PercentageSplitStrategy")
+	}
+}
+
 func TestFixBeforeAverageMoneySplitStrategy(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -745,3 +915,366 @@ func TestFixBeforeAverageMoneySplitStrategy(t *testing.T) {
 		})
 	}
 }
+
+func TestPartMoneyReconcileSplitStrategy(t *testing.T) {
+	tests := []struct {
+		name            string
+		amount          float64
+		shares          []float64
+		expectedAmounts []float64
+	}{
+		{name: "Fractional shares", amount: 100, shares: []float64{1.5, 2.5, 1}, expectedAmounts: []float64{30, 50, 20}},
+		{name: "Thirds absorb residual in last payer", amount: 100, shares: []float64{1, 1, 1}, expectedAmounts: []float64{33.33, 33.33, 33.34}},
+		{name: "Awkward total over 5.0 shares", amount: 100.01, shares: []float64{0.7, 1.3, 1.1, 0.9, 1.0}, expectedAmounts: []float64{14, 26, 22, 18, 20.01}},
+		{name: "Zero share keeps zero", amount: 10, shares: []float64{1, 2, 0}, expectedAmounts: []float64{3.33, 6.67, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses := make([]string, len(tt.shares))
+			for i := range addresses {
+				addresses[i] = fmt.Sprintf("Addr%d", i)
+			}
+			up := &UserPayment{Name: tt.name, Amount: tt.amount, PrePayAddress: "Payer", ShouldPayAddress: addresses, ExtendPayMsg: tt.shares}
+
+			tx, err := PartMoneyReconcileSplitStrategy(up)
+			if err != nil {
+				t.Fatalf("PartMoneyReconcileSplitStrategy() unexpected error: %v", err)
+			}
+			if !tx.BoolValidate() {
+				in, out := tx.Validate()
+				t.Errorf("BoolValidate() = false, inputs %v != output %v", in, out)
+			}
+			for i, want := range tt.expectedAmounts {
+				if math.Abs(tx.Input[i].Amount-want) > epsilon {
+					t.Errorf("Input[%d].Amount = %v, want %v", i, tx.Input[i].Amount, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid shares are rejected", func(t *testing.T) {
+		up := &UserPayment{Name: "NoShares", Amount: 10, PrePayAddress: "Payer", ShouldPayAddress: []string{"A", "B"}, ExtendPayMsg: []float64{0, 0}}
+		if _, err := PartMoneyReconcileSplitStrategy(up); err == nil {
+			t.Error("PartMoneyReconcileSplitStrategy() expected error for zero shares, got nil")
+		}
+	})
+}
+
+func TestCountWeightedSplitStrategy(t *testing.T) {
+	tests := []struct {
+		name            string
+		amount          float64
+		weights         []float64
+		expectedAmounts []float64
+	}{
+		{name: "100 in thirds gives the cent to the first of equal weights", amount: 100, weights: []float64{1, 1, 1}, expectedAmounts: []float64{33.34, 33.33, 33.33}},
+		{name: "100 by nights gives the cents to the highest weight", amount: 100, weights: []float64{2, 3, 1}, expectedAmounts: []float64{33.33, 50.01, 16.66}},
+		{name: "Zero weight pays nothing", amount: 100, weights: []float64{2, 0, 1}, expectedAmounts: []float64{66.67, 0, 33.33}},
+		{name: "Even split has no remainder", amount: 90, weights: []float64{1, 2}, expectedAmounts: []float64{30, 60}},
+		{name: "Single participant", amount: 10.01, weights: []float64{4}, expectedAmounts: []float64{10.01}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses := make([]string, len(tt.weights))
+			for i := range addresses {
+				addresses[i] = fmt.Sprintf("Addr%d", i)
+			}
+			up := &UserPayment{Name: tt.name, Amount: tt.amount, PrePayAddress: "Payer", ShouldPayAddress: addresses, ExtendPayMsg: tt.weights}
+
+			tx, err := CountWeightedSplitStrategy(up)
+			if err != nil {
+				t.Fatalf("CountWeightedSplitStrategy() unexpected error: %v", err)
+			}
+			cents := int64(0)
+			for i, want := range tt.expectedAmounts {
+				if math.Abs(tx.Input[i].Amount-want) > epsilon {
+					t.Errorf("Input[%d].Amount = %v, want %v", i, tx.Input[i].Amount, want)
+				}
+				cents += int64(math.Round(tx.Input[i].Amount * 100))
+			}
+			if want := int64(math.Round(tt.amount * 100)); cents != want {
+				t.Errorf("inputs sum to %d cents, want %d", cents, want)
+			}
+			if !tx.BoolValidate() {
+				in, out := tx.Validate()
+				t.Errorf("BoolValidate() = false, inputs %v != output %v", in, out)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name    string
+		weights []float64
+	}{
+		{name: "Fractional weight", weights: []float64{1.5, 1}},
+		{name: "Negative weight", weights: []float64{-1, 2}},
+		{name: "Zero weights", weights: []float64{0, 0}},
+		{name: "Length mismatch", weights: []float64{1}},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &UserPayment{Name: tt.name, Amount: 100, PrePayAddress: "Payer", ShouldPayAddress: []string{"A", "B"}, ExtendPayMsg: tt.weights}
+			if _, err := CountWeightedSplitStrategy(up); err == nil {
+				t.Error("CountWeightedSplitStrategy() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestUserPayment_ToTx_ExtendPayMap(t *testing.T) {
+	positional := UserPayment{
+		Name:             "Dinner",
+		Amount:           100,
+		PrePayAddress:    "Alice",
+		ShouldPayAddress: []string{"Alice", "Bob", "Carol"},
+		ExtendPayMsg:     []float64{1, 2, 2},
+		PaymentType:      2,
+	}
+	byAddress := map[string]float64{"Alice": 1, "Bob": 2, "Carol": 2}
+	inputsByAddress := func(tx Tx) map[string]float64 {
+		result := map[string]float64{}
+		for _, input := range tx.Input {
+			result[input.Address] += input.Amount
+		}
+		return result
+	}
+
+	want, err := positional.ToTx(ShareMoneyStrategyFactory(positional.PaymentType))
+	if err != nil {
+		t.Fatalf("positional ToTx() unexpected error: %v", err)
+	}
+
+	t.Run("Map input gives same result as positional", func(t *testing.T) {
+		up := positional
+		up.ExtendPayMsg = nil
+		up.ExtendPayMap = byAddress
+		got, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType))
+		if err != nil {
+			t.Fatalf("ToTx() unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ToTx() got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Map input is robust to address reordering", func(t *testing.T) {
+		up := positional
+		up.ShouldPayAddress = []string{"Carol", "Alice", "Bob"}
+		up.ExtendPayMsg = []float64{1, 2, 2} // stale positional values are ignored
+		up.ExtendPayMap = byAddress
+		got, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType))
+		if err != nil {
+			t.Fatalf("ToTx() unexpected error: %v", err)
+		}
+		gotInputs, wantInputs := inputsByAddress(got), inputsByAddress(want)
+		for addr, amount := range wantInputs {
+			if !floatEquals(gotInputs[addr], amount) {
+				t.Errorf("ToTx() input of %s got %v, want %v", addr, gotInputs[addr], amount)
+			}
+		}
+	})
+
+	t.Run("Map input with unknown address fails", func(t *testing.T) {
+		up := positional
+		up.ExtendPayMap = map[string]float64{"Alice": 1, "Bob": 2, "Dave": 2}
+		if _, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType)); err == nil {
+			t.Error("ToTx() expected error for address not in ShouldPayAddress")
+		}
+	})
+}
+
+func TestDerivedTotalSplitStrategy(t *testing.T) {
+	addresses := []string{"Alice", "Bob", "Carol"}
+	contributions := []float64{40, 35, 25}
+
+	t.Run("Amount is derived from contributions", func(t *testing.T) {
+		up := &UserPayment{Name: "Gift", PrePayAddress: "Dave", ShouldPayAddress: addresses, ExtendPayMsg: contributions}
+		tx, err := DerivedTotalSplitStrategy(up)
+		if err != nil {
+			t.Fatalf("DerivedTotalSplitStrategy() unexpected error: %v", err)
+		}
+		expected := Tx{
+			Name:   "Gift",
+			Input:  []Payment{{Amount: 40, Address: "Alice"}, {Amount: 35, Address: "Bob"}, {Amount: 25, Address: "Carol"}},
+			Output: Payment{Amount: 100, Address: "Dave"},
+		}
+		if !reflect.DeepEqual(tx, expected) {
+			t.Errorf("DerivedTotalSplitStrategy() = %v, want %v", tx, expected)
+		}
+		if err := tx.ValidateDetailed(); err != nil {
+			t.Errorf("derived Tx is not balanced: %v", err)
+		}
+		if up.Amount != 0 {
+			t.Errorf("UserPayment must not be modified, Amount = %v", up.Amount)
+		}
+	})
+
+	t.Run("Constructor sets Amount for ToTx", func(t *testing.T) {
+		up, err := NewDerivedTotalUserPayment("Gift", "Dave", addresses, contributions)
+		if err != nil {
+			t.Fatalf("NewDerivedTotalUserPayment() unexpected error: %v", err)
+		}
+		if up.Amount != 100 {
+			t.Errorf("Amount = %v, want 100", up.Amount)
+		}
+		tx, err := up.ToTx(ShareMoneyStrategyFactory(up.PaymentType))
+		if err != nil {
+			t.Fatalf("ToTx() unexpected error: %v", err)
+		}
+		if !tx.BoolValidate() || tx.Output.Amount != 100 {
+			t.Errorf("ToTx() = %v, want balanced Tx with 100 output", tx)
+		}
+	})
+
+	t.Run("Invalid contributions are rejected", func(t *testing.T) {
+		for name, shares := range map[string][]float64{"zero sum": {0, 0, 0}, "negative": {40, -5, 25}, "length mismatch": {40, 35}} {
+			if _, err := NewDerivedTotalUserPayment("Gift", "Dave", addresses, shares); err == nil {
+				t.Errorf("%s: expected error, got nil", name)
+			}
+		}
+	})
+}
+
+func TestSplitStrategies_PreserveCurrency(t *testing.T) {
+	up := UserPayment{
+		Name:             "Sushi",
+		Amount:           9000,
+		PrePayAddress:    "Alice",
+		ShouldPayAddress: []string{"Alice", "Bob", "Carol"},
+		ExtendPayMsg:     []float64{3000, 3000, 3000},
+		Currency:         "JPY",
+	}
+	for paymentType := 0; paymentType <= 4; paymentType++ {
+		tx, err := up.ToTx(ShareMoneyStrategyFactory(paymentType))
+		if err != nil {
+			t.Fatalf("PaymentType %d: ToTx() unexpected error: %v", paymentType, err)
+		}
+		if tx.Output.Currency != "JPY" {
+			t.Errorf("PaymentType %d: output currency = %q, want JPY", paymentType, tx.Output.Currency)
+		}
+		for _, input := range tx.Input {
+			if input.Currency != "JPY" {
+				t.Errorf("PaymentType %d: input currency of %s = %q, want JPY", paymentType, input.Address, input.Currency)
+			}
+		}
+	}
+}
+
+func TestShareMoneyStrategyFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want UserPaymentToTxStrategy
+	}{
+		{"average", AverageSplitStrategy},
+		{"fixed", FixMoneySplitStrategy},
+		{"part", PartMoneySplitStrategy},
+		{"fix_before_average", FixBeforeAverageMoneySplitStrategy},
+		{"transfer", TransferMoneySplitStrategy},
+		{"percentage", PercentageSplitStrategy},
+		{"count_weighted", CountWeightedSplitStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ShareMoneyStrategyFromName(tt.name)
+			if err != nil {
+				t.Fatalf("ShareMoneyStrategyFromName(%q) error = %v", tt.name, err)
+			}
+			if reflect.ValueOf(got).Pointer() != reflect.ValueOf(tt.want).Pointer() {
+				t.Errorf("ShareMoneyStrategyFromName(%q) returned another strategy", tt.name)
+			}
+			// the int factory keeps its enum order
+			enum, err := ShareMoneyStrategyEnum(tt.name)
+			if err != nil {
+				t.Fatalf("ShareMoneyStrategyEnum(%q) error = %v", tt.name, err)
+			}
+			if reflect.ValueOf(ShareMoneyStrategyFactory(enum)).Pointer() != reflect.ValueOf(tt.want).Pointer() {
+				t.Errorf("ShareMoneyStrategyFactory(%d) does not match %q", enum, tt.name)
+			}
+		})
+	}
+}
+
+func TestShareMoneyStrategyFromName_Unknown(t *testing.T) {
+	for _, name := range []string{"", "Average", "equal"} {
+		if strategy, err := ShareMoneyStrategyFromName(name); err == nil || strategy != nil {
+			t.Errorf("ShareMoneyStrategyFromName(%q) expected an error, got strategy %v", name, strategy != nil)
+		} else if !strings.Contains(err.Error(), "unknown share money strategy") {
+			t.Errorf("ShareMoneyStrategyFromName(%q) error = %v", name, err)
+		}
+		if _, err := ShareMoneyStrategyEnum(name); err == nil {
+			t.Errorf("ShareMoneyStrategyEnum(%q) expected an error", name)
+		}
+	}
+	for _, enum := range []int{-1, len(ShareMoneyStrategyNames)} {
+		if ShareMoneyStrategyFactory(enum) != nil {
+			t.Errorf("ShareMoneyStrategyFactory(%d) expected nil", enum)
+		}
+	}
+}
+
+func TestSplitter_JPYStrategies(t *testing.T) {
+	jpy := NewSplitter(SplitConfig{Epsilon: 1e-6, DecimalPlaces: 0})
+	addresses := []string{"A", "B", "C"}
+
+	tests := []struct {
+		name            string
+		strategy        UserPaymentToTxStrategy
+		shares          []float64
+		expectedAmounts []float64
+	}{
+		{name: "Average", strategy: jpy.AverageSplitStrategy, expectedAmounts: []float64{334, 333, 333}},
+		{name: "Part reconcile", strategy: jpy.PartMoneyReconcileSplitStrategy, shares: []float64{1, 1, 1}, expectedAmounts: []float64{333, 333, 334}},
+		{name: "Part reconcile rounds half up", strategy: jpy.PartMoneyReconcileSplitStrategy, shares: []float64{1, 1, 2}, expectedAmounts: []float64{250, 250, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &UserPayment{Name: tt.name, Amount: 1000, PrePayAddress: "Payer", ShouldPayAddress: addresses, ExtendPayMsg: tt.shares}
+			tx, err := up.ToTx(tt.strategy)
+			if err != nil {
+				t.Fatalf("ToTx() unexpected error: %v", err)
+			}
+			for i, want := range tt.expectedAmounts {
+				if tx.Input[i].Amount != want {
+					t.Errorf("Input[%d].Amount = %v, want %v", i, tx.Input[i].Amount, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Half yen shares are rounded away", func(t *testing.T) {
+		up := &UserPayment{Name: "Odd", Amount: 1001, PrePayAddress: "Payer", ShouldPayAddress: []string{"A", "B"}, ExtendPayMsg: []float64{1, 1}}
+		tx, err := jpy.PartMoneyReconcileSplitStrategy(up)
+		if err != nil {
+			t.Fatalf("PartMoneyReconcileSplitStrategy() unexpected error: %v", err)
+		}
+		// 500.5 rounds to 501, the default config would keep 500.5
+		if tx.Input[0].Amount != 501 || tx.Input[1].Amount != 500 {
+			t.Errorf("inputs = %v, want whole yen 501 and 500", tx.Input)
+		}
+		def, err := PartMoneyReconcileSplitStrategy(up)
+		if err != nil {
+			t.Fatalf("PartMoneyReconcileSplitStrategy() unexpected error: %v", err)
+		}
+		if math.Abs(def.Input[0].Amount-500.5) > epsilon {
+			t.Errorf("default Input[0].Amount = %v, want 500.5", def.Input[0].Amount)
+		}
+	})
+
+	t.Run("Percentages compare with Epsilon", func(t *testing.T) {
+		loose := NewSplitter(SplitConfig{Epsilon: 0.5, DecimalPlaces: 0})
+		up := &UserPayment{Name: "Pct", Amount: 1000, PrePayAddress: "Payer", ShouldPayAddress: []string{"A", "B"}, ExtendPayMsg: []float64{50, 49.8}}
+		if _, err := jpy.PercentageSplitStrategy(up); err == nil {
+			t.Error("PercentageSplitStrategy() expected error for percentages summing to 99.8, got nil")
+		}
+		tx, err := loose.PercentageSplitStrategy(up)
+		if err != nil {
+			t.Fatalf("PercentageSplitStrategy() with Epsilon 0.5 unexpected error: %v", err)
+		}
+		// the percentages are rescaled, so the inputs still cover the amount
+		if err := tx.ValidateDetailed(); err != nil {
+			t.Errorf("ValidateDetailed() of the rescaled split unexpected error: %v", err)
+		}
+	})
+}
@@ -2,10 +2,16 @@ package web
 
 import (
 	"context"
+	"dtm/db/db"
+	"dtm/graph/model"
+	"dtm/graph/utils"
+	migrations "dtm/migration"
+	"dtm/service"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/vektah/gqlparser/v2/ast"
 
@@ -65,3 +71,86 @@ func GraphQLPlaygroundHandler(title string, endpoint string) gin.HandlerFunc {
 		h.ServeHTTP(c.Writer, c.Request)
 	}
 }
+
+// SettleRecordsRequest holds records and their should pay lists in the db model shapes,
+// should pay lists are keyed by record ID. An empty currency settles in the deployment default.
+type SettleRecordsRequest struct {
+	Records   []db.RecordInfo                  `json:"records"`
+	ShouldPay map[uuid.UUID][]db.ExtendAddress `json:"shouldPay"`
+	Currency  string                           `json:"currency,omitempty"`
+}
+
+// SettleRecordsResponse is the settlement of the posted records.
+type SettleRecordsResponse struct {
+	MoneyShare      []*model.Tx `json:"moneyShare"`
+	RemainingInputs float64     `json:"remainingInputs"`
+}
+
+// SettleRecordsHandler settles posted records without GraphQL and data loaders, zero amount records,
+// the default currency and the rounding of transfers by settlement like the GraphQL settlement.
+func SettleRecordsHandler(settlement service.SettlementConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SettleRecordsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		currency, err := service.ParseCurrency(req.Currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		payments, err := settlement.RecordsToUserPayments(req.Records, req.ShouldPay)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		txPackage, totalRemaining, err := settlement.SettleInCurrency(payments, settlement.TripCurrency(&db.TripInfo{Currency: currency}))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, SettleRecordsResponse{
+			MoneyShare:      utils.ToModelTxList(txPackage.TxList),
+			RemainingInputs: totalRemaining,
+		})
+	}
+}
+
+// MigrateResponse is the migration status of the db after migrate.
+type MigrateResponse struct {
+	Version    int64                        `json:"version"`
+	Migrations []migrations.MigrationStatus `json:"migrations"`
+}
+
+// MigrateHandler runs pending migrations in dir against the postgres of connStr and reports the status,
+// calling it again when the db is current changes nothing.
+func MigrateHandler(connStr string, dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		sqlDB, err := migrations.Open(ctx, connStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer func() {
+			if err := sqlDB.Close(); err != nil {
+				logger.Error("Failed to close migration db", "error", err)
+			}
+		}()
+
+		if err := migrations.Up(ctx, sqlDB, dir); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "migrate failed: " + err.Error()})
+			return
+		}
+		version, statuses, err := migrations.Status(ctx, sqlDB, dir)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, MigrateResponse{Version: version, Migrations: statuses})
+	}
+}
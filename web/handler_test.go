@@ -0,0 +1,177 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"dtm/db/db"
+	"dtm/graph/model"
+	"dtm/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postSettleRecords(t *testing.T, settlement service.SettlementConfig, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/settle/records", SettleRecordsHandler(settlement))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/settle/records", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSettleRecordsHandler(t *testing.T) {
+	hotel := db.RecordInfo{ID: uuid.New(), Name: "hotel", Amount: 90, Time: time.Now(), PrePayAddress: "Alice", Category: db.CategoryNormal}
+	dinner := db.RecordInfo{ID: uuid.New(), Name: "dinner", Amount: 60, Time: time.Now(), PrePayAddress: "Bob", Category: db.CategoryNormal}
+	everyone := []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}, {Address: "Carol"}}
+	body, err := json.Marshal(SettleRecordsRequest{
+		Records:   []db.RecordInfo{hotel, dinner},
+		ShouldPay: map[uuid.UUID][]db.ExtendAddress{hotel.ID: everyone, dinner.ID: everyone},
+	})
+	require.NoError(t, err)
+
+	w := postSettleRecords(t, service.SettlementConfig{}, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp SettleRecordsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Zero(t, resp.RemainingInputs)
+
+	// each person spends 50, so Alice gets 40 back and Bob gets 10 back, all from Carol
+	net := map[string]float64{}
+	for _, transfer := range resp.MoneyShare {
+		net[transfer.Output.Address] += transfer.Output.Amount
+		for _, input := range transfer.Input {
+			net[input.Address] -= input.Amount
+		}
+	}
+	assert.InDelta(t, 40, net["Alice"], 0.01)
+	assert.InDelta(t, 10, net["Bob"], 0.01)
+	assert.InDelta(t, -50, net["Carol"], 0.01)
+}
+
+func TestSettleRecordsHandler_Currency(t *testing.T) {
+	// 100 split by three is not a whole TWD
+	taxi := db.RecordInfo{ID: uuid.New(), Name: "taxi", Amount: 100, Time: time.Now(), PrePayAddress: "Alice", Category: db.CategoryNormal}
+	everyone := []db.ExtendAddress{{Address: "Alice"}, {Address: "Bob"}, {Address: "Carol"}}
+	settle := func(t *testing.T, settlement service.SettlementConfig, currency string) []*model.Tx {
+		t.Helper()
+		body, err := json.Marshal(SettleRecordsRequest{
+			Records:   []db.RecordInfo{taxi},
+			ShouldPay: map[uuid.UUID][]db.ExtendAddress{taxi.ID: everyone},
+			Currency:  currency,
+		})
+		require.NoError(t, err)
+		w := postSettleRecords(t, settlement, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp SettleRecordsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.NotEmpty(t, resp.MoneyShare)
+		return resp.MoneyShare
+	}
+	assertWholeUnits := func(t *testing.T, transfers []*model.Tx) {
+		t.Helper()
+		for _, transfer := range transfers {
+			assert.Equal(t, math.Round(transfer.Output.Amount), transfer.Output.Amount)
+			for _, input := range transfer.Input {
+				assert.Equal(t, math.Round(input.Amount), input.Amount)
+			}
+		}
+	}
+
+	t.Run("Requested TWD is rounded to whole units", func(t *testing.T) {
+		assertWholeUnits(t, settle(t, service.SettlementConfig{}, "twd"))
+	})
+
+	t.Run("Default currency and rounding apply without a requested currency", func(t *testing.T) {
+		assertWholeUnits(t, settle(t, service.SettlementConfig{DefaultCurrency: "TWD", DefaultRoundingPrecision: 1}, ""))
+	})
+
+	t.Run("Invalid currency", func(t *testing.T) {
+		body, err := json.Marshal(SettleRecordsRequest{Records: []db.RecordInfo{taxi}, Currency: "TW1"})
+		require.NoError(t, err)
+		w := postSettleRecords(t, service.SettlementConfig{}, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestSettleRecordsHandler_BadRequest(t *testing.T) {
+	t.Run("Invalid JSON", func(t *testing.T) {
+		w := postSettleRecords(t, service.SettlementConfig{}, []byte("{"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Record without should pay list", func(t *testing.T) {
+		body, err := json.Marshal(SettleRecordsRequest{
+			Records: []db.RecordInfo{{ID: uuid.New(), Name: "taxi", Amount: 20, PrePayAddress: "Alice"}},
+		})
+		require.NoError(t, err)
+		w := postSettleRecords(t, service.SettlementConfig{}, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func postMigrate(t *testing.T, dsn string, adminKey string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/admin/migrate", RequireAdminKeyMiddleware(), MigrateHandler(dsn, "../migration"))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/migrate", nil)
+	req.Header.Set("X-Admin-Key", adminKey)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMigrateHandler_AdminKey(t *testing.T) {
+	t.Run("Refused without configured key", func(t *testing.T) {
+		t.Setenv("ADMIN_KEY", "")
+		w := postMigrate(t, "", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Refused with wrong key", func(t *testing.T) {
+		t.Setenv("ADMIN_KEY", "secret")
+		w := postMigrate(t, "", "guess")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestMigrateHandler(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skip migrate test")
+	}
+	t.Setenv("ADMIN_KEY", "secret")
+
+	w := postMigrate(t, dsn, "secret")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var first MigrateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	require.NotEmpty(t, first.Migrations)
+	for _, m := range first.Migrations {
+		assert.True(t, m.Applied, "migration %s should be applied", m.Source)
+	}
+	assert.Equal(t, first.Migrations[len(first.Migrations)-1].Version, first.Version)
+
+	// second call is a no-op
+	w = postMigrate(t, dsn, "secret")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var second MigrateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, first, second)
+}
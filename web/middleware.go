@@ -3,8 +3,11 @@ package web
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"dtm/db/db"
 	"dtm/graph/utils"
+	"dtm/mq/mq"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"log/slog"
@@ -18,6 +21,9 @@ import (
 
 var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
+// MaxBodySize is the largest request body accepted by the GraphQL endpoint.
+const MaxBodySize = 4 * 1024 * 1024 // 4MB
+
 func AdminKeyMiddleware() gin.HandlerFunc {
 	adminKey := os.Getenv("ADMIN_KEY") // Retrieve from env variable
 
@@ -39,6 +45,38 @@ func AdminKeyMiddleware() gin.HandlerFunc {
 	}
 }
 
+// RequireAdminKeyMiddleware guards admin only routes, unlike AdminKeyMiddleware the route is
+// refused when ADMIN_KEY is not configured.
+func RequireAdminKeyMiddleware() gin.HandlerFunc {
+	adminKey := os.Getenv("ADMIN_KEY")
+
+	return func(c *gin.Context) {
+		if adminKey == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin key is not configured"})
+			return
+		}
+		if c.GetHeader("X-Admin-Key") != adminKey {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuditActorMiddleware names the actor of the request for the audit log, a request with X-Admin-Key is
+// identified by a fingerprint of the key so the key itself is never stored.
+func AuditActorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := db.AuditActorAnonymous
+		if key := c.GetHeader("X-Admin-Key"); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			actor = "key:" + hex.EncodeToString(sum[:])[:12]
+		}
+		c.Set(string(db.AuditActorKey), actor)
+		c.Next()
+	}
+}
+
 func CorsConfig(webConfig ServiceConfig) cors.Config {
 	corsConf := cors.DefaultConfig()
 	if webConfig.IsDev {
@@ -86,8 +124,7 @@ func GraphQLBodyLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
 			return
 		}
 
-		const maxBodySize = 4 * 1024 * 1024 // 4MB
-		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxBodySize)
 
 		bodyBytes, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -98,6 +135,11 @@ func GraphQLBodyLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
 
 		// gin body can read only once so write back
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		// nothing to log, e.g. admin commands without payload
+		if len(bodyBytes) == 0 {
+			c.Next()
+			return
+		}
 
 		jsonData := make(map[string]interface{})
 		err = json.Unmarshal(bodyBytes, &jsonData)
@@ -123,19 +165,29 @@ func GraphQLBodyLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
 	}
 }
 
-func TripDataLoaderInjectionMiddleware(wrapper db.TripDBWrapper) gin.HandlerFunc {
+func TripDataLoaderInjectionMiddleware(wrapper db.TripDBWrapper, config db.DataLoaderConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		DBTripDataLoader := *db.NewTripDataLoader(wrapper)
+		DBTripDataLoader := *db.NewTripDataLoaderWithConfig(wrapper, config)
 		c.Set(string(db.DataLoaderKeyTripData), &DBTripDataLoader)
 		c.Next()
 	}
 }
 
+// MQWrapperInjectionMiddleware stores the selected message queue wrapper in request context,
+// resolvers get it back with utils.GetTripMessageQueueWrapper.
+func MQWrapperInjectionMiddleware(wrapper mq.TripMessageQueueWrapper) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(string(mq.WrapperKeyTripMessageQueue), wrapper)
+		c.Next()
+	}
+}
+
 func setupMiddlewares(r *gin.Engine, webConfig ServiceConfig) {
 	// r.Use(limiterMiddleWare()) // We limit it by cloudflare, so no need to limit here
 	r.Use(gin.Recovery())
 	r.Use(gin.Logger())
 	r.Use(AdminKeyMiddleware())
+	r.Use(AuditActorMiddleware())
 	r.Use(GraphQLBodyLogMiddleware(logger))
 	r.Use(cors.New(CorsConfig(webConfig)))
 	r.Use(secure.New(secure.Config{
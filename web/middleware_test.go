@@ -0,0 +1,95 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dtm/graph/utils"
+	"dtm/mq/goch"
+	"dtm/mq/mq"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMQWrapperInjectionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	wrapper := &goch.GoChanTripMessageQueueWrapper{}
+	wrapper.RecordMQArray[mq.ActionCreate] = goch.NewChannelTripRecordMessageQueue(mq.ActionCreate, 1)
+
+	tripID := uuid.New()
+	_, ch, err := wrapper.GetTripRecordMessageQueue(mq.ActionCreate).Subscribe(tripID)
+	require.NoError(t, err)
+
+	// the handler plays a resolver, it only sees the request context
+	resolver := func(c *gin.Context) {
+		w, err := utils.GetTripMessageQueueWrapper(c.Request.Context())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := w.GetTripRecordMessageQueue(mq.ActionCreate).Publish(mq.TripRecordMessage{ID: uuid.New(), TripID: tripID, Name: "lunch"}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+
+	t.Run("Resolver publishes through injected wrapper", func(t *testing.T) {
+		r := gin.New()
+		r.Use(GinContextToContextMiddleware())
+		r.POST("/query", MQWrapperInjectionMiddleware(wrapper), resolver)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		select {
+		case msg := <-ch:
+			assert.Equal(t, "lunch", msg.Name)
+		case <-time.After(time.Second):
+			t.Fatal("message published by resolver was not received")
+		}
+	})
+
+	t.Run("Missing middleware returns error", func(t *testing.T) {
+		r := gin.New()
+		r.Use(GinContextToContextMiddleware())
+		r.POST("/query", resolver)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "message queue wrapper is not available")
+	})
+}
+
+func TestAuditActorMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinContextToContextMiddleware(), AuditActorMiddleware())
+	r.GET("/actor", func(c *gin.Context) {
+		c.String(http.StatusOK, utils.GetAuditActor(c.Request.Context()))
+	})
+
+	actorOf := func(key string) string {
+		req := httptest.NewRequest(http.MethodGet, "/actor", nil)
+		if key != "" {
+			req.Header.Set("X-Admin-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "anonymous", actorOf(""))
+	actor := actorOf("secret")
+	assert.Regexp(t, `^key:[0-9a-f]{12}$`, actor)
+	assert.NotContains(t, actor, "secret")
+	assert.Equal(t, actor, actorOf("secret"), "same key is the same actor")
+	assert.NotEqual(t, actor, actorOf("other"))
+}
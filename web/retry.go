@@ -0,0 +1,48 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultRetryInitialDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay     = 10 * time.Second
+)
+
+// StartupRetryConfig makes Serve wait for postgres and the message queue which are not ready yet,
+// e.g. containers started at the same time. Zero Timeout tries only once.
+type StartupRetryConfig struct {
+	Timeout      time.Duration // total time to wait for one dependency
+	InitialDelay time.Duration // wait after the first failed attempt, doubled after each one, default 500ms
+	MaxDelay     time.Duration // upper bound of the wait between attempts, default 10s
+}
+
+// retryWithBackoff calls connect until it succeeds or config.Timeout passes, the error of the last attempt is returned.
+func retryWithBackoff[T any](name string, config StartupRetryConfig, connect func() (T, error)) (T, error) {
+	if config.InitialDelay <= 0 {
+		config.InitialDelay = defaultRetryInitialDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaultRetryMaxDelay
+	}
+
+	deadline := time.Now().Add(config.Timeout)
+	delay := config.InitialDelay
+	for attempt := 1; ; attempt++ {
+		dep, err := connect()
+		if err == nil {
+			return dep, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			var zero T
+			return zero, fmt.Errorf("%s is not available after %d attempts: %w", name, attempt, err)
+		}
+		wait := min(delay, remaining)
+		log.Printf("%s is not available (attempt %d), retry in %s: %v", name, attempt, wait, err)
+		time.Sleep(wait)
+		delay = min(delay*2, config.MaxDelay)
+	}
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dtm/mq/mq"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var fastRetry = StartupRetryConfig{Timeout: 2 * time.Second, InitialDelay: 20 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+
+func TestRetryWithBackoff_DependencyBecomesAvailable(t *testing.T) {
+	// reserve a free port, the dependency starts listening on it after a delay
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		time.Sleep(300 * time.Millisecond)
+		listener, err = net.Listen("tcp", addr)
+	}()
+	t.Cleanup(func() {
+		<-started
+		if listener != nil {
+			_ = listener.Close()
+		}
+	})
+
+	begin := time.Now()
+	conn, err := retryWithBackoff("tcp dependency", fastRetry, func() (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.GreaterOrEqual(t, time.Since(begin), 300*time.Millisecond)
+}
+
+func TestRetryWithBackoff_Timeout(t *testing.T) {
+	var attempts int32
+	_, err := retryWithBackoff("broken", StartupRetryConfig{Timeout: 200 * time.Millisecond, InitialDelay: 10 * time.Millisecond}, func() (int, error) {
+		atomic.AddInt32(&attempts, 1)
+		return 0, errors.New("connection refused")
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "broken is not available after")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Greater(t, atomic.LoadInt32(&attempts), int32(2))
+
+	// zero timeout tries only once
+	atomic.StoreInt32(&attempts, 0)
+	_, err = retryWithBackoff("broken", StartupRetryConfig{}, func() (int, error) {
+		atomic.AddInt32(&attempts, 1)
+		return 0, errors.New("connection refused")
+	})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestNewTripDB_WaitsForPostgres(t *testing.T) {
+	ready := time.Now().Add(200 * time.Millisecond)
+	original := initPostgres
+	initPostgres = func(dsn string) (*gorm.DB, error) {
+		if time.Now().Before(ready) {
+			return nil, errors.New("failed to ping database: connection refused")
+		}
+		// the connection is opened lazily, so no postgres is needed
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true})
+	}
+	t.Cleanup(func() { initPostgres = original })
+
+	tripDB, closeDB, err := newTripDB(ServiceConfig{IsDev: false, StartupRetry: fastRetry})
+	require.NoError(t, err)
+	assert.NotNil(t, tripDB)
+	closeDB()
+}
+
+func TestNewMessageQueue_RabbitGivesUp(t *testing.T) {
+	var attempts int32
+	original := dialRabbit
+	dialRabbit = func(addr string) (*amqp.Connection, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("failed to connect to RabbitMQ: connection refused")
+	}
+	t.Cleanup(func() { dialRabbit = original })
+
+	_, _, err := newMessageQueue(ServiceConfig{MqMode: mq.ModeRabbitMQ, StartupRetry: StartupRetryConfig{Timeout: 100 * time.Millisecond, InitialDelay: 10 * time.Millisecond}})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "rabbitmq is not available after")
+	assert.Greater(t, atomic.LoadInt32(&attempts), int32(1))
+}
@@ -3,10 +3,15 @@ package web
 import (
 	"context"
 	"dtm/graph"
+	migrations "dtm/migration"
 	"dtm/mq/gcppubsub"
 	"dtm/mq/goch"
+	"dtm/mq/kafka"
 	"dtm/mq/mq"
+	natsMQ "dtm/mq/nats"
 	"dtm/mq/rabbit"
+	"dtm/service"
+	"fmt"
 	"log"
 
 	"dtm/db/db"
@@ -15,15 +20,32 @@ import (
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	natsgo "github.com/nats-io/nats.go"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
 )
 
 type ServiceConfig struct {
 	IsDev  bool
 	Port   string
 	MqMode mq.Mode
+	// DataLoader tunes the batching of the GraphQL data loaders
+	DataLoader db.DataLoaderConfig
+	// Settlement holds the zero amount policy, record budget, default currency and rounding of settlements
+	Settlement service.SettlementConfig
+	// Write checks the records written to the db, e.g. their decimal places
+	Write db.WriteConfig
+	// StartupRetry waits for postgres and the message queue on startup
+	StartupRetry StartupRetryConfig
 }
 
+// dependency constructors, replaced in tests
+var (
+	initPostgres = pg.InitPostgresGORM
+	dialRabbit   = rabbit.DialRabbitConnection
+	dialNats     = natsMQ.DialNatsConnection
+)
+
 func Serve(config ServiceConfig) {
 	// set by config
 	if config.IsDev {
@@ -40,66 +62,141 @@ func Serve(config ServiceConfig) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 	// setup service
-	var dbDep db.TripDBWrapper
-	var mqDep mq.TripMessageQueueWrapper
-	if config.IsDev {
-		dbDep = mem.NewInMemoryTripDBWrapper()
-	} else {
-		iDB, err := pg.InitPostgresGORM(pg.CreateDSN())
-		if err != nil {
-			panic(err)
-		}
-		defer pg.CloseGORM(iDB)
-		dbDep = pg.NewPgDBWrapper(iDB)
+	dbDep, closeDB, err := newTripDB(config)
+	if err != nil {
+		panic(err)
 	}
-	switch config.MqMode {
-	case mq.ModeGoChan:
-		mqDep = goch.NewGoChanTripMessageQueueWrapper()
-	case mq.ModeRabbitMQ:
-		mqc := rabbit.NewRabbitConnection(rabbit.CreateAmqpURL())
-		if mqc == nil {
-			panic("Failed to connect to RabbitMQ")
-		}
-		defer func(mqc *amqp.Connection) {
-			err := mqc.Close()
-			if err != nil {
-				panic(err)
-			}
-		}(mqc)
-		var err error
-		mqDep, err = rabbit.NewRabbitTripMessageQueueWrapper(mqc)
-		if err != nil {
-			panic("Failed to create RabbitMQ trip message queue wrapper: " + err.Error())
-		}
-	case mq.ModeGCPPubSub:
-		// os.Setenv("GCP_PROJECT_ID", "gcp-exercise-434714")
-		mqc, err := gcppubsub.NewGCPTripMessageQueueWrapper(context.Background(), gcppubsub.GetGCPProjectID())
-		if err != nil {
-			panic("Failed to create GCP Pub/Sub trip message queue wrapper: " + err.Error())
-		}
-		mqDep = mqc
-	default:
-		panic("Unsupported message queue mode: " + string(config.MqMode))
+	defer closeDB()
+	mqDep, closeMQ, err := newMessageQueue(config)
+	if err != nil {
+		panic(err)
 	}
+	defer closeMQ()
+	defer func() {
+		if err := mqDep.Close(); err != nil {
+			logger.Error("Failed to close message queue", "error", err)
+		}
+	}() // runs before closeMQ, queues are shut down before their connection
 	// GraphQL endpoint
 	executableSchema := graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
 		TripDB:                  dbDep,
 		TripMessageQueueWrapper: mqDep,
+		Settlement:              config.Settlement,
 	}})
 	if config.IsDev {
 		r.GET("/", GraphQLPlaygroundHandler("DTM", "/query"))
 	}
 	// query and mutation endpoints
-	r.POST("/query", gzip.Gzip(gzip.DefaultCompression), TripDataLoaderInjectionMiddleware(dbDep), GraphQLHandler(executableSchema))
-	r.GET("/query", gzip.Gzip(gzip.DefaultCompression), TripDataLoaderInjectionMiddleware(dbDep), GraphQLHandler(executableSchema))
+	r.POST("/query", gzip.Gzip(gzip.DefaultCompression), TripDataLoaderInjectionMiddleware(dbDep, config.DataLoader), MQWrapperInjectionMiddleware(mqDep), GraphQLHandler(executableSchema))
+	r.GET("/query", gzip.Gzip(gzip.DefaultCompression), TripDataLoaderInjectionMiddleware(dbDep, config.DataLoader), MQWrapperInjectionMiddleware(mqDep), GraphQLHandler(executableSchema))
+	// REST settlement endpoint, independent of GraphQL
+	r.POST("/api/settle/records", SettleRecordsHandler(config.Settlement))
+	// admin endpoint to migrate postgres without shell access
+	if !config.IsDev {
+		r.POST("/admin/migrate", RequireAdminKeyMiddleware(), MigrateHandler(pg.CreateDSN(), migrations.Dir))
+	}
 	// Subscriptions endpoint
-	r.GET("/subscription", TripDataLoaderInjectionMiddleware(dbDep), GraphQLHandler(executableSchema))
+	r.GET("/subscription", TripDataLoaderInjectionMiddleware(dbDep, config.DataLoader), MQWrapperInjectionMiddleware(mqDep), GraphQLHandler(executableSchema))
 
 	// Start the server
 	println("Starting web server on port " + config.Port)
-	err := r.Run("0.0.0.0:" + config.Port)
+	err = r.Run("0.0.0.0:" + config.Port)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 }
+
+// newTripDB returns the db of the config and a function to release it, postgres is retried by config.StartupRetry.
+// Reads go to the replica of DATABASE_REPLICA_URL when it is set.
+func newTripDB(config ServiceConfig) (db.TripDBWrapper, func(), error) {
+	if config.IsDev {
+		return mem.NewInMemoryTripDBWrapperWithConfig(config.Write), func() {}, nil
+	}
+	iDB, err := retryWithBackoff("postgres", config.StartupRetry, func() (*gorm.DB, error) {
+		return initPostgres(pg.CreateDSN())
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	replicaDSN := pg.CreateReplicaDSN()
+	if replicaDSN == "" {
+		return pg.NewPgDBWrapperWithConfig(iDB, nil, config.Write), func() { pg.CloseGORM(iDB) }, nil
+	}
+	replica, err := retryWithBackoff("postgres replica", config.StartupRetry, func() (*gorm.DB, error) {
+		return initPostgres(replicaDSN)
+	})
+	if err != nil {
+		pg.CloseGORM(iDB)
+		return nil, nil, err
+	}
+	return pg.NewPgDBWrapperWithConfig(iDB, replica, config.Write), func() {
+		pg.CloseGORM(replica)
+		pg.CloseGORM(iDB)
+	}, nil
+}
+
+// newMessageQueue returns the message queue of the config and a function to release it,
+// the broker connection is retried by config.StartupRetry.
+func newMessageQueue(config ServiceConfig) (mq.TripMessageQueueWrapper, func(), error) {
+	switch config.MqMode {
+	case mq.ModeGoChan:
+		return goch.NewGoChanTripMessageQueueWrapper(), func() {}, nil
+	case mq.ModeRabbitMQ:
+		mqc, err := retryWithBackoff("rabbitmq", config.StartupRetry, func() (*amqp.Connection, error) {
+			return dialRabbit(rabbit.CreateAmqpURL())
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		closeConn := func() {
+			if err := mqc.Close(); err != nil {
+				panic(err)
+			}
+		}
+		mqDep, err := rabbit.NewRabbitTripMessageQueueWrapper(mqc)
+		if err != nil {
+			closeConn()
+			return nil, nil, fmt.Errorf("failed to create RabbitMQ trip message queue wrapper: %w", err)
+		}
+		return mqDep, closeConn, nil
+	case mq.ModeGCPPubSub:
+		// os.Setenv("GCP_PROJECT_ID", "gcp-exercise-434714")
+		projectID := gcppubsub.GetGCPProjectID()
+		mqDep, err := retryWithBackoff("gcp pub/sub", config.StartupRetry, func() (mq.TripMessageQueueWrapper, error) {
+			return gcppubsub.NewGCPTripMessageQueueWrapper(context.Background(), projectID)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create GCP Pub/Sub trip message queue wrapper: %w", err)
+		}
+		return mqDep, func() {}, nil
+	case mq.ModeNATS:
+		nc, err := retryWithBackoff("nats", config.StartupRetry, func() (*natsgo.Conn, error) {
+			return dialNats(natsMQ.CreateNatsURL())
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		mqDep, err := natsMQ.NewNatsTripMessageQueueWrapper(nc)
+		if err != nil {
+			nc.Close()
+			return nil, nil, fmt.Errorf("failed to create NATS trip message queue wrapper: %w", err)
+		}
+		return mqDep, nc.Close, nil
+	case mq.ModeKafka:
+		brokers := kafka.GetKafkaBrokers()
+		kafkaConfig, err := kafka.GetKafkaConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		mqDep, err := retryWithBackoff("kafka", config.StartupRetry, func() (mq.TripMessageQueueWrapper, error) {
+			return kafka.NewKafkaTripMessageQueueWrapperWithConfig(context.Background(), brokers, kafkaConfig)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create Kafka trip message queue wrapper: %w", err)
+		}
+		return mqDep, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported message queue mode: %s", config.MqMode)
+	}
+}